@@ -48,6 +48,7 @@ var (
 		loginCommand(),
 		whoamiCommand(),
 		importCommand(),
+		migrateOwncloudSQLCommand(),
 		lsCommand(),
 		statCommand(),
 		uploadCommand(),
@@ -92,6 +93,10 @@ var (
 		setlockCommand(),
 		getlockCommand(),
 		unlockCommand(),
+		uploadSessionsListCommand(),
+		uploadSessionsRestartCommand(),
+		uploadSessionsRemoveCommand(),
+		uploadSessionsPurgeCommand(),
 		helpCommand(),
 	}
 )
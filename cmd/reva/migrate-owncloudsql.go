@@ -0,0 +1,89 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	_ "github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json"
+	_ "github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3"
+	_ "github.com/opencloud-eu/reva/v2/pkg/storage/fs/ocis"
+	_ "github.com/opencloud-eu/reva/v2/pkg/storage/fs/posix"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/migrate/owncloudsql"
+)
+
+func migrateOwncloudSQLCommand() *command {
+	cmd := newCommand("migrate-owncloudsql")
+	cmd.Description = func() string {
+		return "migrate the files, versions, trashbins and shares of an ownCloud 10 instance to decomposedfs. File ids are not preserved, the journal maps them to the new ids"
+	}
+	cmd.Usage = func() string { return "Usage: migrate-owncloudsql [-flags] <config file>" }
+	verifyOnly := cmd.Bool("verify-only", false, "only verify a previous migration and write the report")
+
+	cmd.ResetFlags = func() {
+		*verifyOnly = false
+	}
+
+	cmd.Action = func(w ...io.Writer) error {
+		if cmd.NArg() < 1 {
+			return errors.New("Invalid arguments: " + cmd.Usage())
+		}
+
+		m := map[string]interface{}{}
+		if _, err := toml.DecodeFile(cmd.Args()[0], &m); err != nil {
+			return errors.Wrap(err, "could not read config file")
+		}
+
+		log := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+		report, err := owncloudsql.Run(context.Background(), m, *verifyOnly, &log)
+		if report != nil {
+			t := table.NewWriter()
+			t.SetOutputMirror(os.Stdout)
+			t.AppendHeader(table.Row{"User", "Directories", "Files", "Versions", "Trash items", "Skipped", "Verified", "Mismatches", "Changed ids"})
+			names := make([]string, 0, len(report.Users))
+			for name := range report.Users {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				u := report.Users[name]
+				t.AppendRow(table.Row{name, u.Directories, u.Files, u.Versions, u.TrashItems, u.Skipped, u.Verified, u.Mismatches, u.ChangedIDs})
+			}
+			t.AppendFooter(table.Row{"Shares", "", report.Shares.Shares, "Links", report.Shares.PublicLinks, report.Shares.Skipped, report.Shares.Verified, report.Shares.Mismatches, ""})
+			t.Render()
+			for _, l := range report.Limitations {
+				log.Warn().Msg(l)
+			}
+			if len(report.Problems) > 0 {
+				log.Warn().Msg(strconv.Itoa(len(report.Problems)) + " problems, see the report for details")
+			}
+		}
+		return err
+	}
+	return cmd
+}
@@ -0,0 +1,92 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"encoding/gob"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/opencloud-eu/reva/v2/internal/http/services/uploads"
+	"github.com/pkg/errors"
+)
+
+func uploadSessionsListCommand() *command {
+	cmd := newCommand("upload-sessions-list")
+	cmd.Description = func() string { return "list upload sessions of the storage providers" }
+	cmd.Usage = func() string { return "Usage: upload-sessions-list [-flags]" }
+	endpoint := cmd.String("endpoint", "", "the URL of the uploads admin service")
+	provider := cmd.String("provider", "", "only list sessions of the storage provider with this address (optional)")
+	space := cmd.String("space", "", "filter by space id (optional)")
+	user := cmd.String("user", "", "filter by the id of the user that initiated the upload (optional)")
+	expired := cmd.String("expired", "", "filter by expiration: true or false (optional)")
+	processing := cmd.String("processing", "", "filter by processing state: true or false (optional)")
+	hasVirus := cmd.String("has-virus", "", "filter by virus scan result: true or false (optional)")
+
+	cmd.ResetFlags = func() {
+		*endpoint, *provider, *space, *user, *expired, *processing, *hasVirus = "", "", "", "", "", "", ""
+	}
+
+	cmd.Action = func(w ...io.Writer) error {
+		if *endpoint == "" {
+			return errors.New("endpoint can not be empty: " + cmd.Usage())
+		}
+
+		q := url.Values{}
+		for k, v := range map[string]string{
+			"provider":   *provider,
+			"space":      *space,
+			"user":       *user,
+			"expired":    *expired,
+			"processing": *processing,
+			"has_virus":  *hasVirus,
+		} {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+
+		var sessions []uploads.Session
+		if err := doUploadsRequest(getAuthContext(), "GET", *endpoint, "/sessions", q, &sessions); err != nil {
+			return err
+		}
+
+		if len(w) == 0 {
+			t := table.NewWriter()
+			t.SetOutputMirror(os.Stdout)
+			t.AppendHeader(table.Row{"Provider", "ID", "Filename", "Offset", "Size", "Space", "Executant", "Expires", "Processing", "Step", "Scan result"})
+			for _, s := range sessions {
+				t.AppendRows([]table.Row{
+					{s.Provider, s.ID, s.Filename, s.Offset, s.Size, s.SpaceID, s.Executant.GetOpaqueId(), s.Expires.Format(time.RFC3339), s.Processing, s.PostprocessingStep, s.ScanResult},
+				})
+			}
+			t.Render()
+		} else {
+			enc := gob.NewEncoder(w[0])
+			if err := enc.Encode(sessions); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,66 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/opencloud-eu/reva/v2/internal/http/services/uploads"
+	"github.com/pkg/errors"
+)
+
+func uploadSessionsPurgeCommand() *command {
+	cmd := newCommand("upload-sessions-purge")
+	cmd.Description = func() string { return "purge expired upload sessions including their data" }
+	cmd.Usage = func() string { return "Usage: upload-sessions-purge [-flags]" }
+	endpoint := cmd.String("endpoint", "", "the URL of the uploads admin service")
+	provider := cmd.String("provider", "", "only purge sessions of the storage provider with this address (optional)")
+	space := cmd.String("space", "", "only purge sessions of this space (optional)")
+
+	cmd.ResetFlags = func() {
+		*endpoint, *provider, *space = "", "", ""
+	}
+
+	cmd.Action = func(w ...io.Writer) error {
+		if *endpoint == "" {
+			return errors.New("endpoint can not be empty: " + cmd.Usage())
+		}
+
+		q := url.Values{}
+		if *provider != "" {
+			q.Set("provider", *provider)
+		}
+		if *space != "" {
+			q.Set("space", *space)
+		}
+
+		var purged []uploads.Session
+		if err := doUploadsRequest(getAuthContext(), http.MethodPost, *endpoint, "/purge", q, &purged); err != nil {
+			return err
+		}
+		for _, s := range purged {
+			fmt.Printf("purged upload session %s of provider %s (%s)\n", s.ID, s.Provider, s.Filename)
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,54 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+func uploadSessionsRestartCommand() *command {
+	cmd := newCommand("upload-sessions-restart")
+	cmd.Description = func() string { return "restart the postprocessing of an upload session" }
+	cmd.Usage = func() string { return "Usage: upload-sessions-restart [-flags] <provider> <upload_id>" }
+	endpoint := cmd.String("endpoint", "", "the URL of the uploads admin service")
+
+	cmd.ResetFlags = func() {
+		*endpoint = ""
+	}
+
+	cmd.Action = func(w ...io.Writer) error {
+		if cmd.NArg() < 2 || *endpoint == "" {
+			return errors.New("Invalid arguments: " + cmd.Usage())
+		}
+		provider, id := cmd.Args()[0], cmd.Args()[1]
+
+		p := "/sessions/" + url.PathEscape(provider) + "/" + url.PathEscape(id) + "/restart"
+		if err := doUploadsRequest(getAuthContext(), http.MethodPost, *endpoint, p, url.Values{}, nil); err != nil {
+			return err
+		}
+		fmt.Println("restarted postprocessing of upload session " + id)
+		return nil
+	}
+	return cmd
+}
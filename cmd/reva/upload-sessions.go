@@ -0,0 +1,70 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp"
+)
+
+// doUploadsRequest sends a request to the uploads admin service and decodes the json response into v
+func doUploadsRequest(ctx context.Context, method, endpoint, p string, query url.Values, v interface{}) error {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + p)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := rhttp.NewRequest(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if t, ok := ctxpkg.ContextGetToken(ctx); ok {
+		req.Header.Set(ctxpkg.TokenHeader, t)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("error: uploads service returned %s", res.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/cmd/revad/internal/grace"
+	"github.com/opencloud-eu/reva/v2/pkg/conversions"
 	"github.com/opencloud-eu/reva/v2/pkg/logger"
 	"github.com/opencloud-eu/reva/v2/pkg/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
@@ -295,6 +296,12 @@ func parseSharedConfOrDie(v interface{}) {
 		fmt.Fprintf(os.Stderr, "error decoding shared config: %s\n", err.Error())
 		os.Exit(1)
 	}
+	for _, r := range sharedconf.SharingRoles() {
+		if err := conversions.RegisterCustomRole(r.Name, r.Permissions); err != nil {
+			fmt.Fprintf(os.Stderr, "error registering sharing role: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
 }
 
 func isEnabledHTTP(conf map[string]interface{}) bool {
@@ -22,6 +22,7 @@ import (
 	// Load core GRPC services
 	_ "github.com/opencloud-eu/reva/v2/internal/grpc/interceptors/eventsmiddleware"
 	_ "github.com/opencloud-eu/reva/v2/internal/grpc/interceptors/prometheus"
+	_ "github.com/opencloud-eu/reva/v2/internal/grpc/interceptors/ratelimit"
 	_ "github.com/opencloud-eu/reva/v2/internal/grpc/interceptors/readonly"
 	// Add your own service here
 )
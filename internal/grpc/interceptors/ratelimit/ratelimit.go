@@ -0,0 +1,116 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/ratelimit"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPriority = 50
+)
+
+func init() {
+	rgrpc.RegisterUnaryInterceptor("ratelimit", NewUnary)
+	rgrpc.RegisterStreamInterceptor("ratelimit", NewStream)
+}
+
+func parseConfig(m map[string]interface{}) (*ratelimit.Config, error) {
+	c := &ratelimit.Config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewUnary returns a new unary interceptor
+// that rejects grpc calls exceeding the configured rate limits.
+func NewUnary(m map[string]interface{}) (grpc.UnaryServerInterceptor, int, error) {
+	c, err := parseConfig(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	limiter, err := ratelimit.New("grpc", c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := check(ctx, limiter, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}, defaultPriority, nil
+}
+
+// NewStream returns a new server stream interceptor
+// that rejects grpc streams exceeding the configured rate limits.
+func NewStream(m map[string]interface{}) (grpc.StreamServerInterceptor, int, error) {
+	c, err := parseConfig(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	limiter, err := ratelimit.New("grpc", c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(ss.Context(), limiter, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}, defaultPriority, nil
+}
+
+func check(ctx context.Context, limiter *ratelimit.Limiter, method string) error {
+	r := ratelimit.Request{Name: method}
+	if u, ok := ctxpkg.ContextGetUser(ctx); ok {
+		r.User = u.GetId().GetOpaqueId()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(r.IP); err == nil {
+			r.IP = host
+		}
+	}
+
+	allowed, retryAfter, err := limiter.Take(r)
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("method", method).Msg("error checking rate limit")
+	}
+	if allowed {
+		return nil
+	}
+	seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", seconds))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s, retry after %s seconds", method, seconds)
+}
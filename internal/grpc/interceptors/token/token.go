@@ -20,10 +20,13 @@ package token
 
 import (
 	"context"
+	"net"
 
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 // NewUnary returns a new unary interceptor that adds
@@ -47,6 +50,8 @@ func NewUnary() grpc.UnaryServerInterceptor {
 					ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.InitiatorHeader, initiatorID)
 				}
 			}
+
+			ctx = withClientIP(ctx, md)
 		}
 
 		return handler(ctx, req)
@@ -77,6 +82,8 @@ func NewStream() grpc.StreamServerInterceptor {
 					ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.InitiatorHeader, initiatorID)
 				}
 			}
+
+			ctx = withClientIP(ctx, md)
 		}
 
 		wrapped := newWrappedServerStream(ctx, ss)
@@ -85,6 +92,44 @@ func NewStream() grpc.StreamServerInterceptor {
 	return interceptor
 }
 
+// withClientIP adds the client ip from the metadata to the context. When trusted proxies are
+// configured the metadata is only accepted from callers that are trusted to forward it, otherwise
+// any client could pick the ip that ends up in e.g. the audit log or is checked against the trusted
+// networks of the second factor.
+func withClientIP(ctx context.Context, md metadata.MD) context.Context {
+	val := md.Get(ctxpkg.ClientIPHeader)
+	if len(val) == 0 || val[0] == "" {
+		return ctx
+	}
+	if sharedconf.RestrictForwardedClientIP() {
+		p, ok := peer.FromContext(ctx)
+		if !ok || !sharedconf.IsTrustedProxy(peerIP(p.Addr)) {
+			return ctx
+		}
+	}
+	clientIP := val[0]
+	ctx = ctxpkg.ContextSetClientIP(ctx, clientIP)
+	return metadata.AppendToOutgoingContext(ctx, ctxpkg.ClientIPHeader, clientIP)
+}
+
+func peerIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UnixAddr:
+		// unix sockets can only be used by local processes
+		return net.IPv6loopback
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
 func newWrappedServerStream(ctx context.Context, ss grpc.ServerStream) *wrappedServerStream {
 	return &wrappedServerStream{ServerStream: ss, newCtx: ctx}
 }
@@ -28,6 +28,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	"github.com/opencloud-eu/reva/v2/pkg/auth"
 	"github.com/opencloud-eu/reva/v2/pkg/auth/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/auth/totp"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/plugin"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
@@ -44,6 +45,8 @@ func init() {
 type config struct {
 	AuthManager  string                            `mapstructure:"auth_manager"`
 	AuthManagers map[string]map[string]interface{} `mapstructure:"auth_managers"`
+	// TOTP requires a second factor on top of the credentials checked by the auth manager
+	TOTP *totp.Config `mapstructure:"totp"`
 }
 
 func (c *config) init() {
@@ -107,6 +110,17 @@ func New(m map[string]interface{}, ss *grpc.Server, _ *zerolog.Logger) (rgrpc.Se
 		return nil, err
 	}
 
+	if c.TOTP != nil {
+		if err := c.TOTP.Init(); err != nil {
+			return nil, err
+		}
+		store, err := c.TOTP.NewStore()
+		if err != nil {
+			return nil, err
+		}
+		authManager = totp.Wrap(authManager, c.TOTP, store)
+	}
+
 	svc := &service{
 		conf:    c,
 		authmgr: authManager,
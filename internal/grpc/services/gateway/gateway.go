@@ -26,6 +26,7 @@ import (
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences/keys"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/cache"
@@ -183,6 +184,7 @@ func New(m map[string]interface{}, _ *grpc.Server, _ *zerolog.Logger) (rgrpc.Ser
 
 func (s *svc) Register(ss *grpc.Server) {
 	gateway.RegisterGatewayAPIServer(ss, s)
+	keys.RegisterKeysAPIServer(ss, s)
 }
 
 func (s *svc) Close() error {
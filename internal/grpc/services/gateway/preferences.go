@@ -58,3 +58,37 @@ func (s *svc) GetKey(ctx context.Context, req *preferences.GetKeyRequest) (*pref
 
 	return res, nil
 }
+
+// ListKeys forwards the listing of the keys to the preferences service, see keys.KeysAPIServer
+func (s *svc) ListKeys(ctx context.Context, req *preferences.GetKeyRequest) (*preferences.GetKeyResponse, error) {
+	c, err := pool.GetPreferencesKeysClient(s.c.PreferencesEndpoint)
+	if err != nil {
+		return &preferences.GetKeyResponse{
+			Status: status.NewInternal(ctx, "error getting preferences client"),
+		}, nil
+	}
+
+	res, err := c.ListKeys(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "gateway: error calling ListKeys")
+	}
+
+	return res, nil
+}
+
+// DeleteKey forwards the deletion of a key to the preferences service, see keys.KeysAPIServer
+func (s *svc) DeleteKey(ctx context.Context, req *preferences.SetKeyRequest) (*preferences.SetKeyResponse, error) {
+	c, err := pool.GetPreferencesKeysClient(s.c.PreferencesEndpoint)
+	if err != nil {
+		return &preferences.SetKeyResponse{
+			Status: status.NewInternal(ctx, "error getting preferences client"),
+		}, nil
+	}
+
+	res, err := c.DeleteKey(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "gateway: error calling DeleteKey")
+	}
+
+	return res, nil
+}
@@ -39,6 +39,8 @@ import (
 	"google.golang.org/grpc/codes"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/opencloud-eu/reva/v2/internal/http/services/datagateway"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
@@ -79,10 +81,13 @@ import (
 // transferClaims are custom claims for a JWT token to be used between the metadata and data gateways.
 type transferClaims struct {
 	jwt.RegisteredClaims
-	Target string `json:"target"`
+	Target    string `json:"target"`
+	SingleUse bool   `json:"single_use,omitempty"`
 }
 
-func (s *svc) sign(_ context.Context, target string, expiresAt int64) (string, error) {
+// sign returns a transfer token for the target. The data gateway only accepts single use tokens
+// for one download, they are told apart by their id.
+func (s *svc) sign(_ context.Context, target string, expiresAt int64, singleUse bool) (string, error) {
 	// Tus sends a separate request to the datagateway service for every chunk.
 	// For large files, this can take a long time, so we extend the expiration
 	claims := transferClaims{
@@ -91,7 +96,11 @@ func (s *svc) sign(_ context.Context, target string, expiresAt int64) (string, e
 			Audience:  jwt.ClaimStrings{"reva"},
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
-		Target: target,
+		Target:    target,
+		SingleUse: singleUse,
+	}
+	if singleUse {
+		claims.ID = uuid.New().String()
 	}
 
 	t := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), claims)
@@ -530,7 +539,8 @@ func (s *svc) InitiateFileDownload(ctx context.Context, req *provider.InitiateFi
 
 			// TODO(labkode): calculate signature of the whole request? we only sign the URI now. Maybe worth https://tools.ietf.org/html/draft-cavage-http-signatures-11
 			target := u.String()
-			token, err := s.sign(ctx, target, time.Now().UTC().Add(time.Duration(s.c.TransferExpires)*time.Second).Unix())
+			singleUse := utils.ExistsInOpaque(req.GetOpaque(), datagateway.OpaqueKeySingleUse)
+			token, err := s.sign(ctx, target, time.Now().UTC().Add(time.Duration(s.c.TransferExpires)*time.Second).Unix(), singleUse)
 			if err != nil {
 				return &gateway.InitiateFileDownloadResponse{
 					Status: status.NewStatusFromErrType(ctx, "error creating signature for download", err),
@@ -568,6 +578,7 @@ func (s *svc) InitiateFileUpload(ctx context.Context, req *provider.InitiateFile
 
 	if storageRes.Status.Code != rpc.Code_CODE_OK {
 		return &gateway.InitiateFileUploadResponse{
+			Opaque: storageRes.Opaque,
 			Status: storageRes.Status,
 		}, nil
 	}
@@ -597,7 +608,7 @@ func (s *svc) InitiateFileUpload(ctx context.Context, req *provider.InitiateFile
 			if storageRes.Protocols[p].Expiration != nil {
 				expiresAt = utils.TSToTime(storageRes.Protocols[p].Expiration).Unix()
 			}
-			token, err := s.sign(ctx, target, expiresAt)
+			token, err := s.sign(ctx, target, expiresAt, false)
 			if err != nil {
 				return &gateway.InitiateFileUploadResponse{
 					Status: status.NewStatusFromErrType(ctx, "error creating signature for upload", err),
@@ -24,10 +24,14 @@ import (
 	"sort"
 
 	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	"github.com/mitchellh/mapstructure"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/group"
 	"github.com/opencloud-eu/reva/v2/pkg/group/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/group/provisioning"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 	"github.com/pkg/errors"
@@ -99,6 +103,7 @@ func (s *service) UnprotectedEndpoints() []string {
 
 func (s *service) Register(ss *grpc.Server) {
 	grouppb.RegisterGroupAPIServer(ss, s)
+	provisioning.RegisterProvisioningAPIServer(ss, s)
 }
 
 func (s *service) GetGroup(ctx context.Context, req *grouppb.GetGroupRequest) (*grouppb.GetGroupResponse, error) {
@@ -201,3 +206,119 @@ func (s *service) HasMember(ctx context.Context, req *grouppb.HasMemberRequest)
 		Ok:     ok,
 	}, nil
 }
+
+// writer returns the group manager if it allows provisioning groups and the caller is a service account
+func (s *service) writer(ctx context.Context) (group.Writer, *rpc.Status) {
+	if u, ok := ctxpkg.ContextGetUser(ctx); !ok || u.GetId().GetType() != userpb.UserType_USER_TYPE_SERVICE {
+		return nil, status.NewPermissionDenied(ctx, nil, "only service accounts can provision groups")
+	}
+	w, ok := s.groupmgr.(group.Writer)
+	if !ok {
+		return nil, status.NewUnimplemented(ctx, nil, "group driver does not support provisioning groups")
+	}
+	return w, nil
+}
+
+// CreateGroup creates a group, see provisioning.ProvisioningAPIServer
+func (s *service) CreateGroup(ctx context.Context, req *grouppb.Group) (*grouppb.GetGroupResponse, error) {
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &grouppb.GetGroupResponse{Status: st}, nil
+	}
+	g, err := w.CreateGroup(ctx, req)
+	if err != nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewStatusFromErrType(ctx, "error creating group", err),
+		}, nil
+	}
+	return &grouppb.GetGroupResponse{
+		Status: status.NewOK(ctx),
+		Group:  g,
+	}, nil
+}
+
+// UpdateGroup updates a group, see provisioning.ProvisioningAPIServer
+func (s *service) UpdateGroup(ctx context.Context, req *grouppb.Group) (*grouppb.GetGroupResponse, error) {
+	if req.GetId() == nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewInvalid(ctx, "groupid missing"),
+		}, nil
+	}
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &grouppb.GetGroupResponse{Status: st}, nil
+	}
+	g, err := w.UpdateGroup(ctx, req)
+	if err != nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewStatusFromErrType(ctx, "error updating group", err),
+		}, nil
+	}
+	return &grouppb.GetGroupResponse{
+		Status: status.NewOK(ctx),
+		Group:  g,
+	}, nil
+}
+
+// DeleteGroup deletes a group, see provisioning.ProvisioningAPIServer
+func (s *service) DeleteGroup(ctx context.Context, req *grouppb.GetGroupRequest) (*grouppb.GetGroupResponse, error) {
+	if req.GetGroupId() == nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewInvalid(ctx, "groupid missing"),
+		}, nil
+	}
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &grouppb.GetGroupResponse{Status: st}, nil
+	}
+	if err := w.DeleteGroup(ctx, req.GetGroupId()); err != nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewStatusFromErrType(ctx, "error deleting group", err),
+		}, nil
+	}
+	return &grouppb.GetGroupResponse{
+		Status: status.NewOK(ctx),
+	}, nil
+}
+
+// AddMembers adds members to a group, see provisioning.ProvisioningAPIServer
+func (s *service) AddMembers(ctx context.Context, req *grouppb.Group) (*grouppb.GetGroupResponse, error) {
+	if req.GetId() == nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewInvalid(ctx, "groupid missing"),
+		}, nil
+	}
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &grouppb.GetGroupResponse{Status: st}, nil
+	}
+	if err := w.AddMembers(ctx, req.GetId(), req.GetMembers()); err != nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewStatusFromErrType(ctx, "error adding members", err),
+		}, nil
+	}
+	return &grouppb.GetGroupResponse{
+		Status: status.NewOK(ctx),
+	}, nil
+}
+
+// RemoveMembers removes members from a group, see provisioning.ProvisioningAPIServer
+func (s *service) RemoveMembers(ctx context.Context, req *grouppb.Group) (*grouppb.GetGroupResponse, error) {
+	if req.GetId() == nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewInvalid(ctx, "groupid missing"),
+		}, nil
+	}
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &grouppb.GetGroupResponse{Status: st}, nil
+	}
+	if err := w.RemoveMembers(ctx, req.GetId(), req.GetMembers()); err != nil {
+		return &grouppb.GetGroupResponse{
+			Status: status.NewStatusFromErrType(ctx, "error removing members", err),
+		}, nil
+	}
+	return &grouppb.GetGroupResponse{
+		Status: status.NewOK(ctx),
+	}, nil
+}
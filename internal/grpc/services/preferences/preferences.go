@@ -20,6 +20,7 @@ package preferences
 
 import (
 	"context"
+	"encoding/json"
 
 	"google.golang.org/grpc"
 
@@ -27,6 +28,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/preferences"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences/keys"
 	"github.com/opencloud-eu/reva/v2/pkg/preferences/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
@@ -41,6 +43,8 @@ func init() {
 type config struct {
 	Driver  string                            `mapstructure:"driver"`
 	Drivers map[string]map[string]interface{} `mapstructure:"drivers"`
+	// Defaults are returned for keys the user did not set, locked defaults can not be changed
+	Defaults preferences.Defaults `mapstructure:"defaults"`
 }
 
 func (c *config) init() {
@@ -56,7 +60,11 @@ type service struct {
 
 func getPreferencesManager(c *config) (preferences.Manager, error) {
 	if f, ok := registry.NewFuncs[c.Driver]; ok {
-		return f(c.Drivers[c.Driver])
+		pm, err := f(c.Drivers[c.Driver])
+		if err != nil {
+			return nil, err
+		}
+		return preferences.WithDefaults(pm, c.Defaults), nil
 	}
 	return nil, errtypes.NotFound("driver not found: " + c.Driver)
 }
@@ -100,13 +108,14 @@ func (s *service) UnprotectedEndpoints() []string {
 
 func (s *service) Register(ss *grpc.Server) {
 	preferencespb.RegisterPreferencesAPIServer(ss, s)
+	keys.RegisterKeysAPIServer(ss, s)
 }
 
 func (s *service) SetKey(ctx context.Context, req *preferencespb.SetKeyRequest) (*preferencespb.SetKeyResponse, error) {
 	err := s.pm.SetKey(ctx, req.Key.Key, req.Key.Namespace, req.Val)
 	if err != nil {
 		return &preferencespb.SetKeyResponse{
-			Status: status.NewInternal(ctx, "error setting key"),
+			Status: status.NewStatusFromErrType(ctx, "error setting key", err),
 		}, nil
 	}
 
@@ -132,3 +141,48 @@ func (s *service) GetKey(ctx context.Context, req *preferencespb.GetKeyRequest)
 		Val:    val,
 	}, nil
 }
+
+// ListKeys returns all keys of the namespace as a json object, see keys.KeysAPIServer
+func (s *service) ListKeys(ctx context.Context, req *preferencespb.GetKeyRequest) (*preferencespb.GetKeyResponse, error) {
+	km, ok := s.pm.(preferences.KeyManager)
+	if !ok {
+		return &preferencespb.GetKeyResponse{
+			Status: status.NewUnimplemented(ctx, nil, "preferences driver does not support listing keys"),
+		}, nil
+	}
+	values, err := km.ListKeys(ctx, req.GetKey().GetNamespace())
+	if err != nil {
+		return &preferencespb.GetKeyResponse{
+			Status: status.NewStatusFromErrType(ctx, "error listing keys", err),
+		}, nil
+	}
+	val, err := json.Marshal(values)
+	if err != nil {
+		return &preferencespb.GetKeyResponse{
+			Status: status.NewInternal(ctx, "error encoding keys"),
+		}, nil
+	}
+	return &preferencespb.GetKeyResponse{
+		Status: status.NewOK(ctx),
+		Val:    string(val),
+	}, nil
+}
+
+// DeleteKey removes a key, see keys.KeysAPIServer
+func (s *service) DeleteKey(ctx context.Context, req *preferencespb.SetKeyRequest) (*preferencespb.SetKeyResponse, error) {
+	km, ok := s.pm.(preferences.KeyManager)
+	if !ok {
+		return &preferencespb.SetKeyResponse{
+			Status: status.NewUnimplemented(ctx, nil, "preferences driver does not support deleting keys"),
+		}, nil
+	}
+	err := km.DeleteKey(ctx, req.GetKey().GetKey(), req.GetKey().GetNamespace())
+	if _, ok := err.(errtypes.IsNotFound); err != nil && !ok {
+		return &preferencespb.SetKeyResponse{
+			Status: status.NewStatusFromErrType(ctx, "error deleting key", err),
+		}, nil
+	}
+	return &preferencespb.SetKeyResponse{
+		Status: status.NewOK(ctx),
+	}, nil
+}
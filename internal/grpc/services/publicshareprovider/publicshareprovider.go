@@ -22,6 +22,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"time"
@@ -30,6 +31,7 @@ import (
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/password"
 	"github.com/opencloud-eu/reva/v2/pkg/permission"
@@ -45,8 +47,12 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/conversions"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/bruteforce"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/usage"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 )
@@ -66,6 +72,19 @@ type config struct {
 	WriteableShareMustHavePassword bool                              `mapstructure:"writeable_share_must_have_password"`
 	PublicShareMustHavePassword    bool                              `mapstructure:"public_share_must_have_password"`
 	PasswordPolicy                 map[string]interface{}            `mapstructure:"password_policy"`
+	BruteForceProtection           *bruteforce.Config                `mapstructure:"brute_force_protection"`
+	LinkUsage                      *usage.Config                     `mapstructure:"link_usage"`
+	Events                         eventconfig                       `mapstructure:"events"`
+}
+
+type eventconfig struct {
+	Endpoint             string `mapstructure:"nats_address"`
+	Cluster              string `mapstructure:"nats_clusterid"`
+	TLSInsecure          bool   `mapstructure:"tls_insecure"`
+	TLSRootCACertificate string `mapstructure:"tls_root_ca_cert"`
+	EnableTLS            bool   `mapstructure:"nats_enable_tls"`
+	AuthUsername         string `mapstructure:"nats_username"`
+	AuthPassword         string `mapstructure:"nats_password"`
 }
 
 type passwordPolicy struct {
@@ -89,6 +108,9 @@ type service struct {
 	gatewaySelector       pool.Selectable[gateway.GatewayAPIClient]
 	allowedPathsForShares []*regexp.Regexp
 	passwordValidator     password.Validator
+	bruteForce            *bruteforce.Tracker
+	usage                 *usage.Manager
+	stream                events.Stream
 }
 
 func getShareManager(c *config) (publicshare.Manager, error) {
@@ -100,6 +122,10 @@ func getShareManager(c *config) (publicshare.Manager, error) {
 
 // TODO(labkode): add ctx to Close.
 func (s *service) Close() error {
+	// stop the background work of the manager, e.g. the expiry sweeper
+	if c, ok := s.sm.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
 func (s *service) UnprotectedEndpoints() []string {
@@ -172,6 +198,28 @@ func New(gatewaySelector pool.Selectable[gateway.GatewayAPIClient], sm publicsha
 		passwordValidator:     newPasswordPolicy(p),
 	}
 
+	if c.BruteForceProtection != nil {
+		t, err := bruteforce.New(c.BruteForceProtection)
+		if err != nil {
+			return nil, err
+		}
+		service.bruteForce = t
+	}
+	if c.LinkUsage != nil {
+		u, err := usage.New(c.LinkUsage)
+		if err != nil {
+			return nil, err
+		}
+		service.usage = u
+	}
+	if c.Events.Endpoint != "" {
+		s, err := stream.NatsFromConfig("publicshareprovider", false, stream.NatsConfig(c.Events))
+		if err != nil {
+			return nil, err
+		}
+		service.stream = s
+	}
+
 	return service, nil
 }
 
@@ -323,6 +371,13 @@ func (s *service) CreatePublicShare(ctx context.Context, req *link.CreatePublicS
 		}
 	}
 
+	limits, err := s.limitsFromOpaque(req.GetOpaque())
+	if err != nil {
+		return &link.CreatePublicShareResponse{
+			Status: status.NewInvalidArg(ctx, err.Error()),
+		}, nil
+	}
+
 	user := ctxpkg.ContextMustGetUser(ctx)
 	res := &link.CreatePublicShareResponse{}
 	share, err := s.sm.CreatePublicShare(ctx, user, req.GetResourceInfo(), req.GetGrant())
@@ -336,6 +391,21 @@ func (s *service) CreatePublicShare(ctx context.Context, req *link.CreatePublicS
 		res.Opaque = utils.AppendPlainToOpaque(nil, "resourcename", sRes.GetInfo().GetName())
 	}
 
+	if limits != nil && share != nil {
+		u, err := s.usage.SetLimits(share.GetId().GetOpaqueId(), *limits)
+		if err != nil {
+			// a link without its limits must not be handed out
+			log.Error().Err(err).Str("share", share.GetId().GetOpaqueId()).Msg("could not store public share limits, revoking share")
+			if err := s.sm.RevokePublicShare(ctx, user, &link.PublicShareReference{Spec: &link.PublicShareReference_Id{Id: share.GetId()}}); err != nil {
+				log.Error().Err(err).Str("share", share.GetId().GetOpaqueId()).Msg("could not revoke public share")
+			}
+			return &link.CreatePublicShareResponse{
+				Status: status.NewInternal(ctx, "error persisting public share limits"),
+			}, nil
+		}
+		res.Opaque = utils.AppendJSONToOpaque(res.Opaque, usage.OpaqueKey, u)
+	}
+
 	return res, nil
 }
 
@@ -376,6 +446,11 @@ func (s *service) RemovePublicShare(ctx context.Context, req *link.RemovePublicS
 			Status: status.NewInternal(ctx, "error deleting public share"),
 		}, err
 	}
+	if s.usage != nil {
+		if err := s.usage.Delete(ps.GetId().GetOpaqueId()); err != nil {
+			log.Error().Err(err).Str("share", ps.GetId().GetOpaqueId()).Msg("could not delete public share usage")
+		}
+	}
 	o := utils.AppendJSONToOpaque(nil, "resourceid", ps.GetResourceId())
 	o = utils.AppendPlainToOpaque(o, "resourcename", sRes.GetInfo().GetName())
 	return &link.RemovePublicShareResponse{
@@ -388,15 +463,62 @@ func (s *service) GetPublicShareByToken(ctx context.Context, req *link.GetPublic
 	log := appctx.GetLogger(ctx)
 	log.Debug().Msg("getting public share by token")
 
+	// only attempts to authenticate count against the brute force protection
+	clientIP, _ := ctxpkg.ContextGetClientIP(ctx)
+	guarded := s.bruteForce != nil && (req.GetAuthentication().GetPassword() != "" || req.GetAuthentication().GetSignature() != nil)
+	var attempt bruteforce.Failure
+	if guarded {
+		retryAfter, f, err := s.bruteForce.Attempt(req.GetToken(), clientIP)
+		if err != nil {
+			log.Error().Err(err).Msg("error counting public link attempt")
+		}
+		attempt = f
+		if retryAfter > 0 {
+			return &link.GetPublicShareByTokenResponse{
+				Status: status.NewResourceExhausted(ctx, fmt.Sprintf("too many failed attempts, retry in %s", retryAfter.Round(time.Second))),
+			}, nil
+		}
+	}
+
 	// there are 2 passes here, and the second request has no password
 	found, err := s.sm.GetPublicShareByToken(ctx, req.GetToken(), req.GetAuthentication(), req.GetSign())
 	switch v := err.(type) {
 	case nil:
+		if guarded {
+			if err := s.bruteForce.Succeed(req.GetToken(), clientIP); err != nil {
+				log.Error().Err(err).Msg("error resetting failed public link attempts")
+			}
+		}
+		if s.usage != nil {
+			// only the first authentication of a link session counts as an access, other lookups just check the limits
+			var session *string
+			if utils.ExistsInOpaque(req.GetOpaque(), usage.OpaqueKeyRecordAccess) {
+				// clients are told apart by their ip, without one every authentication counts
+				key := ""
+				if clientIP != "" {
+					key = usage.SessionKey(req.GetToken(), clientIP)
+				}
+				session = &key
+			}
+			err := s.checkUsage(found.GetId().GetOpaqueId(), session)
+			switch err.(type) {
+			case nil:
+			case errtypes.PermissionDenied:
+				return &link.GetPublicShareByTokenResponse{
+					Status: status.NewNotFound(ctx, err.Error()),
+				}, nil
+			default:
+				log.Error().Err(err).Msg("error recording public share access")
+			}
+		}
 		return &link.GetPublicShareByTokenResponse{
 			Status: status.NewOK(ctx),
 			Share:  found,
 		}, nil
 	case errtypes.InvalidCredentials:
+		if guarded {
+			s.failedAttempt(ctx, req.GetToken(), clientIP, attempt)
+		}
 		return &link.GetPublicShareByTokenResponse{
 			Status: status.NewPermissionDenied(ctx, v, "wrong password"),
 		}, nil
@@ -411,6 +533,65 @@ func (s *service) GetPublicShareByToken(ctx context.Context, req *link.GetPublic
 	}
 }
 
+// checkUsage fails with a permission denied error if the share has reached its limits. If a
+// session is given the access is recorded, new sessions are also refused once the access limit
+// has been reached. Lookups within a session are only refused once the download limit has been reached.
+func (s *service) checkUsage(shareID string, session *string) error {
+	if session != nil {
+		_, err := s.usage.RecordAccess(shareID, *session)
+		return err
+	}
+	u, err := s.usage.Get(shareID)
+	if err != nil {
+		return err
+	}
+	if u.DownloadsExhausted() {
+		return errtypes.PermissionDenied("public link has reached its download limit")
+	}
+	return nil
+}
+
+// failedAttempt records a failed attempt on the link and tells the link owner when the client got locked out.
+// Too many failures on the link only raise a warning, the link itself is never locked.
+func (s *service) failedAttempt(ctx context.Context, token, clientIP string, attempt bruteforce.Failure) {
+	log := appctx.GetLogger(ctx)
+
+	f, err := s.bruteForce.Fail(token, attempt)
+	if err != nil {
+		log.Error().Err(err).Msg("error recording failed public link attempt")
+	}
+	if f.LinkAlert {
+		log.Warn().Str("token", token).Int("attempts", f.LinkAttempts).Msg("too many failed attempts on public link from different clients")
+	}
+	if !f.LockedOut {
+		return
+	}
+	log.Warn().Str("client_ip", clientIP).Int("attempts", f.Attempts).Msg("locked out client after too many failed public link attempts")
+	if s.stream == nil {
+		return
+	}
+
+	share, err := s.sm.GetPublicShare(ctx, nil, &link.PublicShareReference{Spec: &link.PublicShareReference_Token{Token: token}}, false)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting locked out public share")
+		return
+	}
+	ev := events.LinkLockedOut{
+		ShareID:        share.GetId(),
+		Sharer:         share.GetCreator(),
+		Owner:          share.GetOwner(),
+		ItemID:         share.GetResourceId(),
+		Token:          token,
+		ClientIP:       clientIP,
+		FailedAttempts: f.Attempts,
+		LockedUntil:    utils.TimeToTS(time.Now().Add(f.RetryAfter)),
+		Timestamp:      utils.TSNow(),
+	}
+	if err := events.Publish(ctx, s.stream, ev); err != nil {
+		log.Error().Err(err).Msg("error publishing LinkLockedOut event")
+	}
+}
+
 func (s *service) GetPublicShare(ctx context.Context, req *link.GetPublicShareRequest) (*link.GetPublicShareResponse, error) {
 	log := appctx.GetLogger(ctx)
 	log.Info().Str("publicshareprovider", "get").Msg("get public share")
@@ -438,10 +619,18 @@ func (s *service) GetPublicShare(ctx context.Context, req *link.GetPublicShareRe
 			Status: status.NewNotFound(ctx, "not found"),
 		}, nil
 	default:
-		return &link.GetPublicShareResponse{
+		res := &link.GetPublicShareResponse{
 			Status: status.NewOK(ctx),
 			Share:  ps,
-		}, nil
+		}
+		if s.usage != nil {
+			u, err := s.usage.Get(ps.GetId().GetOpaqueId())
+			if err != nil {
+				log.Error().Err(err).Msg("error reading public share usage")
+			}
+			res.Opaque = utils.AppendJSONToOpaque(nil, usage.OpaqueKey, u)
+		}
+		return res, nil
 	}
 }
 
@@ -462,6 +651,19 @@ func (s *service) ListPublicShares(ctx context.Context, req *link.ListPublicShar
 		Status: status.NewOK(ctx),
 		Share:  shares,
 	}
+	if s.usage != nil {
+		// the usage of all listed shares keyed by share id
+		usages := make(map[string]usage.Usage, len(shares))
+		for _, share := range shares {
+			u, err := s.usage.Get(share.GetId().GetOpaqueId())
+			if err != nil {
+				log.Error().Err(err).Msg("error reading public share usage")
+				continue
+			}
+			usages[share.GetId().GetOpaqueId()] = u
+		}
+		res.Opaque = utils.AppendJSONToOpaque(nil, usage.OpaqueKey, usages)
+	}
 	return res, nil
 }
 
@@ -597,21 +799,60 @@ func (s *service) UpdatePublicShare(ctx context.Context, req *link.UpdatePublicS
 		}
 	}
 
-	updateR, err := s.sm.UpdatePublicShare(ctx, user, req)
+	limits, err := s.limitsFromOpaque(req.GetOpaque())
 	if err != nil {
 		return &link.UpdatePublicShareResponse{
-			Status: status.NewInternal(ctx, err.Error()),
+			Status: status.NewInvalidArg(ctx, err.Error()),
 		}, nil
 	}
 
+	// requests can update only the limits
+	updateR := ps
+	if req.GetUpdate() != nil {
+		updateR, err = s.sm.UpdatePublicShare(ctx, user, req)
+		if err != nil {
+			return &link.UpdatePublicShareResponse{
+				Status: status.NewInternal(ctx, err.Error()),
+			}, nil
+		}
+	}
+
 	res := &link.UpdatePublicShareResponse{
 		Status: status.NewOK(ctx),
 		Share:  updateR,
 		Opaque: utils.AppendPlainToOpaque(nil, "resourcename", sRes.GetInfo().GetName()),
 	}
+
+	if limits != nil {
+		u, err := s.usage.SetLimits(ps.GetId().GetOpaqueId(), *limits)
+		if err != nil {
+			return &link.UpdatePublicShareResponse{
+				Status: status.NewInternal(ctx, "error persisting public share limits"),
+			}, nil
+		}
+		res.Opaque = utils.AppendJSONToOpaque(res.Opaque, usage.OpaqueKey, u)
+	}
 	return res, nil
 }
 
+// limitsFromOpaque returns the usage limits sent with a create or update request, if any
+func (s *service) limitsFromOpaque(o *types.Opaque) (*usage.Limits, error) {
+	if _, ok := o.GetMap()[usage.OpaqueKeyLimits]; !ok {
+		return nil, nil
+	}
+	if s.usage == nil {
+		return nil, errors.New("public link limits are not enabled")
+	}
+	l := &usage.Limits{}
+	if err := utils.ReadJSONFromOpaque(o, usage.OpaqueKeyLimits, l); err != nil {
+		return nil, errors.Wrap(err, "invalid public link limits")
+	}
+	if l.MaxDownloads < 0 || l.MaxAccesses < 0 {
+		return nil, errors.New("public link limits must not be negative")
+	}
+	return l, nil
+}
+
 func isInternalLink(req *link.UpdatePublicShareRequest, ps *link.PublicShare) bool {
 	switch {
 	case req.GetUpdate().GetType() == link.UpdatePublicShareRequest_Update_TYPE_PERMISSIONS:
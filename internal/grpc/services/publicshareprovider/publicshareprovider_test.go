@@ -19,6 +19,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/mocks"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/usage"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
@@ -212,6 +213,82 @@ var _ = Describe("PublicShareProvider", func() {
 			Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_OK))
 			Expect(res.GetShare()).To(Equal(createdLink))
 		})
+		Context("with download limits", func() {
+			var req *link.CreatePublicShareRequest
+			BeforeEach(func() {
+				createdLink.Id = &link.PublicShareId{OpaqueId: "limited"}
+				req = &link.CreatePublicShareRequest{
+					Opaque: utils.AppendJSONToOpaque(nil, usage.OpaqueKeyLimits, usage.Limits{MaxDownloads: 1, MaxAccesses: 1}),
+					ResourceInfo: &providerpb.ResourceInfo{
+						Owner: &userpb.UserId{
+							OpaqueId: "alice",
+						},
+						Path: "./NewFolder/file.txt",
+					},
+					Grant: &link.Grant{
+						Permissions: &link.PublicSharePermissions{
+							Permissions: linkPermissions,
+						},
+						Password: "SecretPassw0rd!",
+					},
+				}
+			})
+			It("fails when link usage is not enabled", func() {
+				res, err := provider.CreatePublicShare(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_INVALID_ARGUMENT))
+			})
+			It("stores the limits and disables the link once they are reached", func() {
+				revaConfig["link_usage"] = map[string]interface{}{"store": "memory", "volatile": true}
+				var err error
+				provider, err = createPublicShareProvider(revaConfig, gatewaySelector, manager)
+				Expect(err).ToNot(HaveOccurred())
+
+				manager.
+					EXPECT().
+					CreatePublicShare(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Return(createdLink, nil)
+
+				res, err := provider.CreatePublicShare(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_OK))
+				u := usage.Usage{}
+				Expect(utils.ReadJSONFromOpaque(res.GetOpaque(), usage.OpaqueKey, &u)).To(Succeed())
+				Expect(u.MaxDownloads).To(Equal(1))
+
+				manager.
+					EXPECT().
+					GetPublicShareByToken(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Return(createdLink, nil)
+				// lookups without a link session do not count as accesses
+				tokenReq := &link.GetPublicShareByTokenRequest{Token: "token"}
+				for i := 0; i < 3; i++ {
+					tRes, err := provider.GetPublicShareByToken(ctx, tokenReq)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(tRes.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_OK))
+				}
+
+				// every request of a link session authenticates, only the first one counts
+				sessionReq := &link.GetPublicShareByTokenRequest{
+					Opaque: utils.AppendPlainToOpaque(nil, usage.OpaqueKeyRecordAccess, "true"),
+					Token:  "token",
+				}
+				clientCtx := ctxpkg.ContextSetClientIP(ctx, "10.0.0.1")
+				for i := 0; i < 3; i++ {
+					tRes, err := provider.GetPublicShareByToken(clientCtx, sessionReq)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(tRes.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_OK))
+				}
+				tRes, err := provider.GetPublicShareByToken(ctx, tokenReq)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tRes.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_OK))
+
+				// other clients start a new session, which exceeds the access limit
+				tRes, err = provider.GetPublicShareByToken(ctxpkg.ContextSetClientIP(ctx, "10.0.0.2"), sessionReq)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tRes.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_NOT_FOUND))
+			})
+		})
 		It("has no user permission to create public share", func() {
 			gatewayClient.
 				EXPECT().
@@ -1674,6 +1751,73 @@ var _ = Describe("PublicShareProvider", func() {
 				Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_INTERNAL))
 				Expect(res.GetStatus().GetMessage()).To(Equal("unexpected error"))
 			})
+			Context("with brute force protection", func() {
+				var (
+					clientCtx context.Context
+					request   *link.GetPublicShareByTokenRequest
+				)
+				BeforeEach(func() {
+					revaConfig["brute_force_protection"] = map[string]interface{}{
+						"free_attempts":    1,
+						"base_delay":       60,
+						"lockout_attempts": 3,
+					}
+					var err error
+					provider, err = createPublicShareProvider(revaConfig, gatewaySelector, manager)
+					Expect(err).ToNot(HaveOccurred())
+
+					manager.EXPECT().GetPublicShareByToken(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Unset()
+					clientCtx = ctxpkg.ContextSetClientIP(ctx, "10.0.0.1")
+					request = &link.GetPublicShareByTokenRequest{
+						Token: "token",
+						Authentication: &link.PublicShareAuthentication{
+							Spec: &link.PublicShareAuthentication_Password{
+								Password: "guess",
+							},
+						},
+					}
+				})
+				It("blocks a client after too many failed attempts", func() {
+					manager.
+						EXPECT().
+						GetPublicShareByToken(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+						Times(2).Return(nil, errtypes.InvalidCredentials("wrong password"))
+
+					res, err := provider.GetPublicShareByToken(clientCtx, request)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_PERMISSION_DENIED))
+
+					res, err = provider.GetPublicShareByToken(clientCtx, request)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_PERMISSION_DENIED))
+
+					// the share manager is not asked again while the client is blocked
+					res, err = provider.GetPublicShareByToken(clientCtx, request)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_RESOURCE_EXHAUSTED))
+
+					// other clients can still try
+					manager.
+						EXPECT().
+						GetPublicShareByToken(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+						Once().Return(existingLink, nil)
+					res, err = provider.GetPublicShareByToken(ctxpkg.ContextSetClientIP(ctx, "10.0.0.2"), request)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_OK))
+				})
+				It("does not count requests without credentials", func() {
+					manager.
+						EXPECT().
+						GetPublicShareByToken(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+						Times(3).Return(nil, errtypes.InvalidCredentials("wrong password"))
+
+					for i := 0; i < 3; i++ {
+						res, err := provider.GetPublicShareByToken(clientCtx, &link.GetPublicShareByTokenRequest{Token: "token"})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.GetStatus().GetCode()).To(Equal(rpc.Code_CODE_PERMISSION_DENIED))
+					}
+				})
+			})
 		})
 	})
 })
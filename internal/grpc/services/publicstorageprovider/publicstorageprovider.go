@@ -34,9 +34,11 @@ import (
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/internal/http/services/datagateway"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/usage"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
@@ -58,12 +60,14 @@ func init() {
 }
 
 type config struct {
-	GatewayAddr string `mapstructure:"gateway_addr"`
+	GatewayAddr string        `mapstructure:"gateway_addr"`
+	LinkUsage   *usage.Config `mapstructure:"link_usage"`
 }
 
 type service struct {
 	conf            *config
 	gatewaySelector pool.Selectable[gateway.GatewayAPIClient]
+	usage           *usage.Manager
 }
 
 func (s *service) Close() error {
@@ -104,6 +108,11 @@ func New(m map[string]interface{}, ss *grpc.Server, _ *zerolog.Logger) (rgrpc.Se
 		conf:            c,
 		gatewaySelector: gatewaySelector,
 	}
+	if c.LinkUsage != nil {
+		if service.usage, err = usage.New(c.LinkUsage); err != nil {
+			return nil, err
+		}
+	}
 
 	return service, nil
 }
@@ -206,15 +215,21 @@ func (s *service) InitiateFileDownload(ctx context.Context, req *provider.Initia
 	}
 
 	req.Opaque = statRes.Info.Opaque
-	return s.initiateFileDownload(ctx, req)
+	return s.initiateFileDownload(ctx, req, int64(statRes.GetInfo().GetSize()))
 }
 
 func (s *service) translatePublicRefToCS3Ref(ctx context.Context, ref *provider.Reference) (*provider.Reference, *provider.ResourceInfo, string, error) {
+	cs3Ref, info, _, token, err := s.resolvePublicRef(ctx, ref)
+	return cs3Ref, info, token, err
+}
+
+// resolvePublicRef translates the reference like translatePublicRefToCS3Ref and also returns the resolved share
+func (s *service) resolvePublicRef(ctx context.Context, ref *provider.Reference) (*provider.Reference, *provider.ResourceInfo, interface{}, string, error) {
 	log := appctx.GetLogger(ctx)
 
-	info, _, _, token, err := s.extractLinkFromScope(ctx)
+	info, share, _, token, err := s.extractLinkFromScope(ctx)
 	if err != nil {
-		return nil, nil, "", err
+		return nil, nil, nil, "", err
 	}
 
 	var path string
@@ -242,11 +257,11 @@ func (s *service) translatePublicRefToCS3Ref(ctx context.Context, ref *provider.
 		Str("originalPath", info.Path).
 		Str("relativePath", path).
 		Msg("translatePublicRefToCS3Ref")
-	return cs3Ref, info, token, nil
+	return cs3Ref, info, share, token, nil
 }
 
-func (s *service) initiateFileDownload(ctx context.Context, req *provider.InitiateFileDownloadRequest) (*provider.InitiateFileDownloadResponse, error) {
-	ref, info, _, err := s.translatePublicRefToCS3Ref(ctx, req.Ref)
+func (s *service) initiateFileDownload(ctx context.Context, req *provider.InitiateFileDownloadRequest, size int64) (*provider.InitiateFileDownloadResponse, error) {
+	ref, info, share, _, err := s.resolvePublicRef(ctx, req.Ref)
 	switch {
 	case err != nil:
 		return &provider.InitiateFileDownloadResponse{
@@ -257,10 +272,32 @@ func (s *service) initiateFileDownload(ctx context.Context, req *provider.Initia
 			Status: status.NewPermissionDenied(ctx, nil, "share does not grant InitiateFileDownload permission"),
 		}, nil
 	}
+
 	dReq := &provider.InitiateFileDownloadRequest{
 		Ref: ref,
 	}
 
+	// links with a download limit are disabled once it has been reached
+	var shareID string
+	if ps, ok := share.(*link.PublicShare); ok && s.usage != nil {
+		shareID = ps.GetId().GetOpaqueId()
+		u, err := s.usage.Get(shareID)
+		if err != nil {
+			return &provider.InitiateFileDownloadResponse{
+				Status: status.NewInternal(ctx, "initiateFileDownload: error reading public share usage"),
+			}, nil
+		}
+		if u.DownloadsExhausted() {
+			return &provider.InitiateFileDownloadResponse{
+				Status: status.NewPermissionDenied(ctx, nil, "public link has reached its download limit"),
+			}, nil
+		}
+		if u.MaxDownloads > 0 {
+			// the download is counted here, so the transfer token must not be reused for further downloads
+			dReq.Opaque = utils.AppendPlainToOpaque(nil, datagateway.OpaqueKeySingleUse, "true")
+		}
+	}
+
 	gatewayClient, err := s.gatewaySelector.Next()
 	if err != nil {
 		return nil, err
@@ -278,6 +315,15 @@ func (s *service) initiateFileDownload(ctx context.Context, req *provider.Initia
 		}, nil
 	}
 
+	if shareID != "" {
+		// a concurrent download might have used up the limit in the meantime
+		if _, err := s.usage.RecordDownload(shareID, size); err != nil {
+			return &provider.InitiateFileDownloadResponse{
+				Status: status.NewStatusFromErrType(ctx, "initiateFileDownload", err),
+			}, nil
+		}
+	}
+
 	protocols := make([]*provider.FileDownloadProtocol, len(dRes.Protocols))
 	for p := range dRes.Protocols {
 		if !strings.HasSuffix(dRes.Protocols[p].DownloadEndpoint, "/") {
@@ -44,8 +44,11 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/router"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/uploadsessions"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/uploadpolicy"
 	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/pkg/errors"
@@ -71,6 +74,7 @@ type config struct {
 	MountID             string                            `mapstructure:"mount_id"`
 	UploadExpiration    int64                             `mapstructure:"upload_expiration" docs:"0;Duration for how long uploads will be valid."`
 	Events              eventconfig                       `mapstructure:"events" docs:"0;Event stream configuration"`
+	GatewayAddr         string                            `mapstructure:"gateway_addr" docs:";The gateway used to check the permission to manage upload sessions."`
 }
 
 type eventconfig struct {
@@ -87,6 +91,7 @@ func (c *config) init() {
 	if c.Driver == "" {
 		c.Driver = "localhome"
 	}
+	c.GatewayAddr = sharedconf.GetGatewaySVC(c.GatewayAddr)
 
 	if c.DataServerURL == "" {
 		host, err := os.Hostname()
@@ -108,6 +113,8 @@ type Service struct {
 	Storage       storage.FS
 	dataServerURL *url.URL
 	availableXS   []*provider.ResourceChecksumPriority
+	// uploadPolicies are the shared upload policies
+	uploadPolicies *uploadpolicy.Config
 }
 
 func (s *Service) Close() error {
@@ -119,6 +126,7 @@ func (s *Service) UnprotectedEndpoints() []string { return []string{} }
 func (s *Service) Register(ss *grpc.Server) {
 	provider.RegisterProviderAPIServer(ss, s)
 	provider.RegisterSpacesAPIServer(ss, s)
+	uploadsessions.RegisterUploadSessionsAPIServer(ss, s)
 }
 
 func parseXSTypes(xsTypes map[string]uint32) ([]*provider.ResourceChecksumPriority, error) {
@@ -203,10 +211,11 @@ func New(m map[string]interface{}, ss *grpc.Server, log *zerolog.Logger) (rgrpc.
 	}
 
 	service := &Service{
-		conf:          c,
-		Storage:       fs,
-		dataServerURL: u,
-		availableXS:   xsTypes,
+		conf:           c,
+		Storage:        fs,
+		dataServerURL:  u,
+		availableXS:    xsTypes,
+		uploadPolicies: sharedconf.UploadPolicies(),
 	}
 
 	return service, nil
@@ -382,7 +391,8 @@ func (s *Service) InitiateFileUpload(ctx context.Context, req *provider.Initiate
 
 	ctx = ctxpkg.ContextSetLockID(ctx, req.LockId)
 
-	var uploadLength int64
+	// the upload length is unknown unless the client announced it
+	var uploadLength int64 = -1
 	if req.Opaque != nil && req.Opaque.Map != nil {
 		if req.Opaque.Map["Upload-Length"] != nil {
 			var err error
@@ -404,6 +414,19 @@ func (s *Service) InitiateFileUpload(ctx context.Context, req *provider.Initiate
 		}
 	}
 
+	if !s.uploadPolicies.Empty() {
+		if err := s.checkUploadPolicy(ctx, req.Ref, sRes.GetInfo(), uploadLength); err != nil {
+			log.Debug().Err(err).Interface("ref", req.Ref).Msg("upload policy violated")
+			res := &provider.InitiateFileUploadResponse{
+				Status: status.NewStatusFromErrType(ctx, "upload policy violated", err),
+			}
+			if v := uploadpolicy.Violation(err); v != "" {
+				res.Opaque = utils.AppendPlainToOpaque(nil, uploadpolicy.OpaqueKey, v)
+			}
+			return res, nil
+		}
+	}
+
 	// pass on the provider it to be persisted with the upload info. that is required to correlate the upload with the proper provider later on
 	metadata["providerID"] = s.conf.MountID
 	var expirationTimestamp *typesv1beta1.Timestamp
@@ -414,6 +437,9 @@ func (s *Service) InitiateFileUpload(ctx context.Context, req *provider.Initiate
 		metadata["expires"] = strconv.Itoa(int(expirationTimestamp.Seconds))
 	}
 
+	if uploadLength < 0 {
+		uploadLength = 0
+	}
 	uploadIDs, err := s.Storage.InitiateUpload(ctx, req.Ref, uploadLength, metadata)
 	if err != nil {
 		var st *rpc.Status
@@ -481,6 +507,69 @@ func (s *Service) InitiateFileUpload(ctx context.Context, req *provider.Initiate
 	return res, nil
 }
 
+// checkUploadPolicy checks the upload against the policy of the space it is uploaded to
+func (s *Service) checkUploadPolicy(ctx context.Context, ref *provider.Reference, info *provider.ResourceInfo, uploadLength int64) error {
+	filename := path.Base(ref.GetPath())
+	if ref.GetPath() == "" || filename == "." {
+		filename = info.GetName()
+	}
+	policy, err := s.uploadPolicy(ctx, ref, info)
+	if err != nil {
+		return err
+	}
+	return policy.Check(filename, uploadLength)
+}
+
+// checkMovePolicy checks the new name of a moved file or folder against the policy of the destination space
+func (s *Service) checkMovePolicy(ctx context.Context, source, destination *provider.Reference) error {
+	filename := path.Base(destination.GetPath())
+	if destination.GetPath() == "" || filename == "." {
+		// the name does not change
+		return nil
+	}
+	sRes, err := s.Stat(ctx, &provider.StatRequest{Ref: source})
+	if err != nil {
+		return err
+	}
+	if sRes.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		// the move reports the error
+		return nil
+	}
+	policy, err := s.uploadPolicy(ctx, destination, nil)
+	if err != nil {
+		return err
+	}
+	if sRes.GetInfo().GetType() == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		return policy.CheckFilename(filename)
+	}
+	return policy.CheckName(filename)
+}
+
+// uploadPolicy returns the upload policy of the space the reference points to
+func (s *Service) uploadPolicy(ctx context.Context, ref *provider.Reference, info *provider.ResourceInfo) (uploadpolicy.Policy, error) {
+	spaceID := ref.GetResourceId().GetSpaceId()
+	var spaceType string
+	if s.uploadPolicies.NeedsSpaceType() {
+		spaceType = info.GetSpace().GetSpaceType()
+		if spaceType == "" && spaceID != "" {
+			id := &provider.StorageSpaceId{OpaqueId: storagespace.FormatResourceID(&provider.ResourceId{
+				StorageId: ref.GetResourceId().GetStorageId(),
+				SpaceId:   spaceID,
+				OpaqueId:  spaceID,
+			})}
+			spaces, err := s.Storage.ListStorageSpaces(ctx, []*provider.ListStorageSpacesRequest_Filter{{Type: provider.ListStorageSpacesRequest_Filter_TYPE_ID, Term: &provider.ListStorageSpacesRequest_Filter_Id{Id: id}}}, true)
+			if err != nil {
+				return uploadpolicy.Policy{}, err
+			}
+			if len(spaces) > 0 {
+				spaceType = spaces[0].GetSpaceType()
+			}
+		}
+	}
+
+	return s.uploadPolicies.PolicyFor(spaceType, spaceID), nil
+}
+
 func (s *Service) GetPath(ctx context.Context, req *provider.GetPathRequest) (*provider.GetPathResponse, error) {
 	// TODO(labkode): check that the storage ID is the same as the storage provider id.
 	fn, err := s.Storage.GetPathByID(ctx, req.ResourceId)
@@ -760,6 +849,19 @@ func (s *Service) Delete(ctx context.Context, req *provider.DeleteRequest) (*pro
 func (s *Service) Move(ctx context.Context, req *provider.MoveRequest) (*provider.MoveResponse, error) {
 	ctx = ctxpkg.ContextSetLockID(ctx, req.LockId)
 
+	if !s.uploadPolicies.Empty() {
+		if err := s.checkMovePolicy(ctx, req.Source, req.Destination); err != nil {
+			appctx.GetLogger(ctx).Debug().Err(err).Interface("destination", req.Destination).Msg("upload policy violated")
+			res := &provider.MoveResponse{
+				Status: status.NewStatusFromErrType(ctx, "upload policy violated", err),
+			}
+			if v := uploadpolicy.Violation(err); v != "" {
+				res.Opaque = utils.AppendPlainToOpaque(nil, uploadpolicy.OpaqueKey, v)
+			}
+			return res, nil
+		}
+	}
+
 	err := s.Storage.Move(ctx, req.Source, req.Destination)
 
 	return &provider.MoveResponse{
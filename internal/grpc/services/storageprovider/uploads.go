@@ -0,0 +1,110 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package storageprovider
+
+import (
+	"context"
+	"time"
+
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typesv1beta1 "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/permission"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/storage"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// ListUploadSessions lists the upload sessions of the storage driver, see uploadsessions.UploadSessionsAPIServer
+func (s *Service) ListUploadSessions(ctx context.Context, req *provider.ListStorageSpacesRequest) (*provider.ListStorageSpacesResponse, error) {
+	sessions, st := s.uploadSessions(ctx, req.GetOpaque())
+	if st != nil {
+		return &provider.ListStorageSpacesResponse{Status: st}, nil
+	}
+
+	now := time.Now()
+	infos := make([]storage.UploadSessionInfo, 0, len(sessions))
+	for _, us := range sessions {
+		infos = append(infos, storage.NewUploadSessionInfo(us, now))
+	}
+	return &provider.ListStorageSpacesResponse{
+		Status: status.NewOK(ctx),
+		Opaque: utils.AppendJSONToOpaque(nil, storage.OpaqueKeyUploadSessions, infos),
+	}, nil
+}
+
+// PurgeUploadSessions purges the upload sessions of the storage driver, see uploadsessions.UploadSessionsAPIServer
+func (s *Service) PurgeUploadSessions(ctx context.Context, req *provider.DeleteStorageSpaceRequest) (*provider.DeleteStorageSpaceResponse, error) {
+	log := appctx.GetLogger(ctx)
+
+	sessions, st := s.uploadSessions(ctx, req.GetOpaque())
+	if st != nil {
+		return &provider.DeleteStorageSpaceResponse{Status: st}, nil
+	}
+
+	now := time.Now()
+	infos := make([]storage.UploadSessionInfo, 0, len(sessions))
+	for _, us := range sessions {
+		if err := us.Purge(ctx); err != nil {
+			log.Error().Err(err).Str("uploadid", us.ID()).Msg("error purging upload session")
+			continue
+		}
+		infos = append(infos, storage.NewUploadSessionInfo(us, now))
+	}
+	return &provider.DeleteStorageSpaceResponse{
+		Status: status.NewOK(ctx),
+		Opaque: utils.AppendJSONToOpaque(nil, storage.OpaqueKeyUploadSessions, infos),
+	}, nil
+}
+
+// uploadSessions checks the permission to manage uploads and returns the upload sessions matching the filter in the opaque
+func (s *Service) uploadSessions(ctx context.Context, opaque *typesv1beta1.Opaque) ([]storage.UploadSession, *rpc.Status) {
+	log := appctx.GetLogger(ctx)
+
+	usl, ok := s.Storage.(storage.UploadSessionLister)
+	if !ok {
+		return nil, status.NewUnimplemented(ctx, nil, "storage driver does not support listing upload sessions")
+	}
+
+	gwc, err := pool.GetGatewayServiceClient(s.conf.GatewayAddr)
+	if err != nil {
+		return nil, status.NewInternal(ctx, "error getting gateway client")
+	}
+	allowed, err := utils.CheckPermission(ctx, permission.ManageUploads, gwc)
+	if err != nil {
+		log.Error().Err(err).Msg("error checking permission")
+		return nil, status.NewInternal(ctx, "error checking permission")
+	}
+	if !allowed {
+		return nil, status.NewPermissionDenied(ctx, nil, "not allowed to manage upload sessions")
+	}
+
+	filter := storage.UploadSessionFilter{}
+	if err := utils.ReadJSONFromOpaque(opaque, storage.OpaqueKeyUploadSessions, &filter); err != nil {
+		return nil, status.NewInvalid(ctx, "invalid upload session filter")
+	}
+	sessions, err := usl.ListUploadSessions(ctx, filter)
+	if err != nil {
+		log.Error().Err(err).Msg("error listing upload sessions")
+		return nil, status.NewStatusFromErrType(ctx, "error listing upload sessions", err)
+	}
+	return sessions, nil
+}
@@ -25,14 +25,17 @@ import (
 	"sort"
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/plugin"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 	"github.com/opencloud-eu/reva/v2/pkg/user"
 	"github.com/opencloud-eu/reva/v2/pkg/user/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/user/provisioning"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
@@ -124,6 +127,7 @@ func (s *service) UnprotectedEndpoints() []string {
 
 func (s *service) Register(ss *grpc.Server) {
 	userpb.RegisterUserAPIServer(ss, s)
+	provisioning.RegisterProvisioningAPIServer(ss, s)
 }
 
 func (s *service) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
@@ -215,3 +219,77 @@ func (s *service) GetUserGroups(ctx context.Context, req *userpb.GetUserGroupsRe
 	}
 	return res, nil
 }
+
+// writer returns the user manager if it allows provisioning users and the caller is a service account
+func (s *service) writer(ctx context.Context) (user.Writer, *rpc.Status) {
+	if u, ok := ctxpkg.ContextGetUser(ctx); !ok || u.GetId().GetType() != userpb.UserType_USER_TYPE_SERVICE {
+		return nil, status.NewPermissionDenied(ctx, nil, "only service accounts can provision users")
+	}
+	w, ok := s.usermgr.(user.Writer)
+	if !ok {
+		return nil, status.NewUnimplemented(ctx, nil, "user driver does not support provisioning users")
+	}
+	return w, nil
+}
+
+// CreateUser creates a user, see provisioning.ProvisioningAPIServer
+func (s *service) CreateUser(ctx context.Context, req *userpb.User) (*userpb.GetUserResponse, error) {
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &userpb.GetUserResponse{Status: st}, nil
+	}
+	u, err := w.CreateUser(ctx, req)
+	if err != nil {
+		return &userpb.GetUserResponse{
+			Status: status.NewStatusFromErrType(ctx, "error creating user", err),
+		}, nil
+	}
+	return &userpb.GetUserResponse{
+		Status: status.NewOK(ctx),
+		User:   u,
+	}, nil
+}
+
+// UpdateUser updates a user, see provisioning.ProvisioningAPIServer
+func (s *service) UpdateUser(ctx context.Context, req *userpb.User) (*userpb.GetUserResponse, error) {
+	if req.GetId() == nil {
+		return &userpb.GetUserResponse{
+			Status: status.NewInvalid(ctx, "userid missing"),
+		}, nil
+	}
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &userpb.GetUserResponse{Status: st}, nil
+	}
+	u, err := w.UpdateUser(ctx, req)
+	if err != nil {
+		return &userpb.GetUserResponse{
+			Status: status.NewStatusFromErrType(ctx, "error updating user", err),
+		}, nil
+	}
+	return &userpb.GetUserResponse{
+		Status: status.NewOK(ctx),
+		User:   u,
+	}, nil
+}
+
+// DeleteUser deletes a user, see provisioning.ProvisioningAPIServer
+func (s *service) DeleteUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	if req.GetUserId() == nil {
+		return &userpb.GetUserResponse{
+			Status: status.NewInvalid(ctx, "userid missing"),
+		}, nil
+	}
+	w, st := s.writer(ctx)
+	if st != nil {
+		return &userpb.GetUserResponse{Status: st}, nil
+	}
+	if err := w.DeleteUser(ctx, req.GetUserId()); err != nil {
+		return &userpb.GetUserResponse{
+			Status: status.NewStatusFromErrType(ctx, "error deleting user", err),
+		}, nil
+	}
+	return &userpb.GetUserResponse{
+		Status: status.NewOK(ctx),
+	}, nil
+}
@@ -20,6 +20,7 @@ package usershareprovider
 
 import (
 	"context"
+	"io"
 	"path/filepath"
 	"regexp"
 	"slices"
@@ -88,6 +89,10 @@ func getShareManager(c *config) (share.Manager, error) {
 
 // TODO(labkode): add ctx to Close.
 func (s *service) Close() error {
+	// stop the background work of the manager, e.g. the expiry sweeper
+	if c, ok := s.sm.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
 
@@ -288,7 +288,12 @@ func authenticateUser(w http.ResponseWriter, r *http.Request, conf *config, toke
 
 	log.Debug().Msgf("AuthenticateRequest: type: %s, client_id: %s against %s", req.Type, req.ClientId, conf.GatewaySvc)
 
-	res, err := client.Authenticate(ctx, req)
+	// the auth provider may need the client ip, e.g. to decide if a second factor is required
+	authCtx := ctx
+	if clientIP, err := utils.GetClientIP(r); err == nil && clientIP != "" {
+		authCtx = metadata.AppendToOutgoingContext(ctx, ctxpkg.ClientIPHeader, clientIP)
+	}
+	res, err := client.Authenticate(authCtx, req)
 	if err != nil {
 		logError(isUnprotectedEndpoint, log, err, "error calling Authenticate", http.StatusUnauthorized, w)
 		return nil, err
@@ -351,6 +356,10 @@ func ctxWithUserInfo(ctx context.Context, r *http.Request, user *userpb.User, to
 	ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.TokenHeader, token)
 	ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.UserAgentHeader, r.UserAgent())
 	ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.InitiatorHeader, initiatorid)
+	if clientIP, err := utils.GetClientIP(r); err == nil && clientIP != "" {
+		ctx = ctxpkg.ContextSetClientIP(ctx, clientIP)
+		ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.ClientIPHeader, clientIP)
+	}
 	ctx = ctxpkg.ContextSetScopes(ctx, tokenScope)
 	return ctx
 }
@@ -23,6 +23,7 @@ import (
 	_ "github.com/opencloud-eu/reva/v2/internal/http/interceptors/cors"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/interceptors/prometheus"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/interceptors/providerauthorizer"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/interceptors/ratelimit"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/interceptors/requestid"
 	// Add your own middleware.
 )
@@ -0,0 +1,74 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/ratelimit"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+const (
+	defaultPriority = 50
+)
+
+func init() {
+	global.RegisterMiddleware("ratelimit", New)
+}
+
+// New returns a new HTTP middleware that rejects requests exceeding the configured rate limits
+func New(m map[string]interface{}) (global.Middleware, int, error) {
+	c := &ratelimit.Config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, 0, err
+	}
+	limiter, err := ratelimit.New("http", c)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := ratelimit.Request{Name: r.URL.Path}
+			if u, ok := ctxpkg.ContextGetUser(r.Context()); ok {
+				req.User = u.GetId().GetOpaqueId()
+			}
+			// only honours X-Forwarded-For from trusted proxies
+			req.IP, _ = utils.GetClientIP(r)
+
+			allowed, retryAfter, err := limiter.Take(req)
+			if err != nil {
+				appctx.GetLogger(r.Context()).Error().Err(err).Str("path", r.URL.Path).Msg("error checking rate limit")
+			}
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}, defaultPriority, nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package audit implements a service that writes all events into a tamper-evident audit log
+// and allows querying it.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/audit"
+	"github.com/opencloud-eu/reva/v2/pkg/bytesize"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/permission"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+func init() {
+	global.Register("audit", New)
+}
+
+// Config holds the config options for the audit HTTP service
+type Config struct {
+	Prefix             string `mapstructure:"prefix" docs:"audit;The prefix to be used for this HTTP service"`
+	GatewaySvc         string `mapstructure:"gatewaysvc"`
+	Sink               string `mapstructure:"sink" docs:"file;Where to write the audit log to. One of file or syslog."`
+	Dir                string `mapstructure:"dir" docs:"/var/tmp/reva/audit;The directory for the audit log files."`
+	MaxFileSize        string `mapstructure:"max_file_size" docs:"100MB;The size after which the audit log file is rotated. 0 disables the rotation."`
+	SyslogNetwork      string `mapstructure:"syslog_network" docs:";The network to reach the syslog daemon, e.g. udp. Empty uses the local syslog daemon."`
+	SyslogAddress      string `mapstructure:"syslog_address" docs:";The address of the syslog daemon."`
+	SyslogTag          string `mapstructure:"syslog_tag" docs:"reva-audit;The tag of the syslog messages."`
+	ConsumerGroup      string `mapstructure:"consumer_group" docs:"audit;The consumer group to receive the events with."`
+	NatsAddress        string `mapstructure:"nats_address"`
+	NatsClusterID      string `mapstructure:"nats_clusterID"`
+	NatsTLSInsecure    bool   `mapstructure:"nats_tls_insecure"`
+	NatsRootCACertPath string `mapstructure:"nats_root_ca_cert_path"`
+	NatsEnableTLS      bool   `mapstructure:"nats_enable_tls"`
+	NatsUsername       string `mapstructure:"nats_username"`
+	NatsPassword       string `mapstructure:"nats_password"`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "audit"
+	}
+	if c.Sink == "" {
+		c.Sink = "file"
+	}
+	if c.Dir == "" {
+		c.Dir = "/var/tmp/reva/audit"
+	}
+	if c.MaxFileSize == "" {
+		c.MaxFileSize = "100MB"
+	}
+	if c.SyslogTag == "" {
+		c.SyslogTag = "reva-audit"
+	}
+	if c.ConsumerGroup == "" {
+		c.ConsumerGroup = "audit"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+}
+
+type svc struct {
+	conf   *Config
+	router *chi.Mux
+	log    *audit.Log
+	sink   io.Closer
+}
+
+// New returns a new audit service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	if conf.NatsAddress == "" {
+		return nil, errors.New("audit: missing nats configuration")
+	}
+
+	var sink interface {
+		audit.Sink
+		io.Closer
+	}
+	switch conf.Sink {
+	case "file":
+		maxSize, err := bytesize.Parse(conf.MaxFileSize)
+		if err != nil {
+			return nil, err
+		}
+		s, err := audit.NewFileSink(conf.Dir, int64(maxSize))
+		if err != nil {
+			return nil, err
+		}
+		sink = s
+	case "syslog":
+		s, err := audit.NewSyslogSink(conf.SyslogNetwork, conf.SyslogAddress, conf.SyslogTag)
+		if err != nil {
+			return nil, err
+		}
+		sink = s
+	default:
+		return nil, errors.New("audit: unknown sink " + conf.Sink)
+	}
+
+	auditLog, err := audit.NewLog(sink)
+	if err != nil {
+		return nil, err
+	}
+
+	evstream, err := stream.NatsFromConfig("audit", false, stream.NatsConfig{
+		Endpoint:             conf.NatsAddress,
+		Cluster:              conf.NatsClusterID,
+		EnableTLS:            conf.NatsEnableTLS,
+		TLSInsecure:          conf.NatsTLSInsecure,
+		TLSRootCACertificate: conf.NatsRootCACertPath,
+		AuthUsername:         conf.NatsUsername,
+		AuthPassword:         conf.NatsPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := events.ConsumeAllManualAck(evstream, conf.ConsumerGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &svc{
+		conf:   conf,
+		router: chi.NewRouter(),
+		log:    auditLog,
+		sink:   sink,
+	}
+	go s.consume(ch, log)
+	s.routerInit(log)
+	return s, nil
+}
+
+func (s *svc) consume(ch <-chan events.Event, log *zerolog.Logger) {
+	for ev := range ch {
+		r, ok := audit.Normalize(ev)
+		if !ok {
+			log.Debug().Str("type", ev.Type).Str("id", ev.ID).Msg("skipping unreadable event")
+			_ = ev.Ack()
+			continue
+		}
+		if _, err := s.log.Append(r); err != nil {
+			log.Error().Err(err).Str("type", ev.Type).Str("id", ev.ID).Msg("error writing audit record")
+			// the event is redelivered
+			_ = ev.Nack()
+			continue
+		}
+		if err := ev.Ack(); err != nil {
+			log.Error().Err(err).Str("type", ev.Type).Str("id", ev.ID).Msg("error acknowledging event")
+		}
+	}
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Use(s.requireReadPermission)
+	s.router.Get("/records", s.handleRecords)
+	s.router.Get("/verify", s.handleVerify)
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "audit").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return s.sink.Close()
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+func (s *svc) requireReadPermission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := appctx.GetLogger(ctx)
+
+		client, err := pool.GetGatewayServiceClient(s.conf.GatewaySvc)
+		if err != nil {
+			log.Error().Err(err).Msg("error getting grpc gateway client")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok, err := utils.CheckPermission(ctx, permission.ReadAuditLog, client)
+		if err != nil {
+			log.Error().Err(err).Msg("error checking permission")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleRecords returns the audit records matching the query parameters
+func (s *svc) handleRecords(w http.ResponseWriter, r *http.Request) {
+	log := appctx.GetLogger(r.Context())
+
+	searcher, ok := s.log.Sink().(audit.Searcher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	q, err := queryFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	records, err := searcher.Search(q)
+	if err != nil {
+		log.Error().Err(err).Msg("error searching audit records")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, records)
+}
+
+// handleVerify checks the hash chain of the whole audit log
+func (s *svc) handleVerify(w http.ResponseWriter, r *http.Request) {
+	log := appctx.GetLogger(r.Context())
+
+	searcher, ok := s.log.Sink().(audit.Searcher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	records, err := searcher.Search(audit.Query{})
+	if err != nil {
+		log.Error().Err(err).Msg("error reading audit records")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := struct {
+		Valid   bool   `json:"valid"`
+		Records int    `json:"records"`
+		Error   string `json:"error,omitempty"`
+	}{Valid: true, Records: len(records)}
+	if err := audit.Verify(records); err != nil {
+		res.Valid = false
+		res.Error = err.Error()
+	}
+	writeJSON(w, r, res)
+}
+
+func queryFromRequest(r *http.Request) (audit.Query, error) {
+	v := r.URL.Query()
+	q := audit.Query{
+		User:     v.Get("user"),
+		Resource: v.Get("resource"),
+	}
+	for param, target := range map[string]*time.Time{
+		"since": &q.Since,
+		"until": &q.Until,
+	} {
+		if t := v.Get(param); t != "" {
+			parsed, err := time.Parse(time.RFC3339, t)
+			if err != nil {
+				return q, err
+			}
+			*target = parsed
+		}
+	}
+	if l := v.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error marshalling response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing JSON response")
+	}
+}
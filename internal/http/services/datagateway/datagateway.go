@@ -34,6 +34,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp"
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
 	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
@@ -50,8 +51,14 @@ func init() {
 const (
 	// TokenTransportHeader holds the header key for the reva transfer token
 	TokenTransportHeader = "X-Reva-Transfer"
+	// OpaqueKeySingleUse is the opaque key of an InitiateFileDownload request asking for a transfer
+	// token that can only be used for one download
+	OpaqueKeySingleUse = "datagateway-single-use"
 )
 
+// ErrTokenUsed is returned for single use transfer tokens that have already been used
+var ErrTokenUsed = errors.New("transfer token has already been used")
+
 func init() {
 	global.Register("datagateway", New)
 }
@@ -59,19 +66,36 @@ func init() {
 // transferClaims are custom claims for a JWT token to be used between the metadata and data gateways.
 type transferClaims struct {
 	jwt.RegisteredClaims
-	Target string `json:"target"`
+	Target    string `json:"target"`
+	SingleUse bool   `json:"single_use,omitempty"`
 }
 type config struct {
 	Prefix               string `mapstructure:"prefix"`
 	TransferSharedSecret string `mapstructure:"transfer_shared_secret"`
 	Timeout              int64  `mapstructure:"timeout"`
 	Insecure             bool   `mapstructure:"insecure"`
+
+	SingleUseStore             string   `mapstructure:"single_use_store" docs:"memory;The store remembering the used single use transfer tokens. The memory store only works with a single data gateway, use redis, redis-sentinel or nats-js-kv otherwise."`
+	SingleUseStoreNodes        []string `mapstructure:"single_use_store_nodes" docs:";The nodes of the single use store."`
+	SingleUseStoreDatabase     string   `mapstructure:"single_use_store_database" docs:"reva;The database of the single use store."`
+	SingleUseStoreTable        string   `mapstructure:"single_use_store_table" docs:"datagateway-used-tokens;The table of the single use store."`
+	SingleUseStoreAuthUsername string   `mapstructure:"single_use_store_auth_username" docs:";The username to authenticate with the single use store."`
+	SingleUseStoreAuthPassword string   `mapstructure:"single_use_store_auth_password" docs:";The password to authenticate with the single use store."`
 }
 
 func (c *config) init() {
 	if c.Prefix == "" {
 		c.Prefix = "datagateway"
 	}
+	if c.SingleUseStore == "" {
+		c.SingleUseStore = "memory"
+	}
+	if c.SingleUseStoreDatabase == "" {
+		c.SingleUseStoreDatabase = "reva"
+	}
+	if c.SingleUseStoreTable == "" {
+		c.SingleUseStoreTable = "datagateway-used-tokens"
+	}
 
 	c.TransferSharedSecret = sharedconf.GetJWTSecret(c.TransferSharedSecret)
 }
@@ -80,6 +104,8 @@ type svc struct {
 	conf    *config
 	handler http.Handler
 	client  *http.Client
+	// used remembers the ids of the single use tokens until they expire
+	used cas.Store
 }
 
 // New returns a new datagateway
@@ -91,12 +117,26 @@ func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error)
 
 	conf.init()
 
+	// the keys of nats-js-kv stores expire with the bucket, tokens are not valid for that long
+	used, err := cas.New(cas.Config{
+		Store:        conf.SingleUseStore,
+		Nodes:        conf.SingleUseStoreNodes,
+		Database:     conf.SingleUseStoreDatabase,
+		Table:        conf.SingleUseStoreTable,
+		AuthUsername: conf.SingleUseStoreAuthUsername,
+		AuthPassword: conf.SingleUseStoreAuthPassword,
+	}, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &svc{
 		conf: conf,
 		client: rhttp.GetHTTPClient(
 			rhttp.Timeout(time.Duration(conf.Timeout*int64(time.Second))),
 			rhttp.Insecure(conf.Insecure),
 		),
+		used: used,
 	}
 	s.setHandler()
 	return s, nil
@@ -171,6 +211,13 @@ func (s *svc) doRequest(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	if claims.SingleUse && r.Method == http.MethodGet {
+		if err := s.use(claims); err != nil {
+			log.Info().Err(err).Str("id", claims.ID).Msg("refusing transfer token")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
 
 	target := claims.Target
 	// add query params to target, clients can send checksums and other information.
@@ -228,6 +275,19 @@ func (s *svc) doRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// use marks a single use token as used, it fails if the token has been used before
+func (s *svc) use(claims *transferClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errtypes.InvalidCredentials("single use token without id or expiration")
+	}
+	return s.used.Update(claims.ID, time.Until(claims.ExpiresAt.Time)+time.Minute, func(old []byte) ([]byte, error) {
+		if old != nil {
+			return nil, ErrTokenUsed
+		}
+		return []byte{1}, nil
+	})
+}
+
 func copyHeader(dst, src http.Header) {
 	for key, values := range src {
 		for i := range values {
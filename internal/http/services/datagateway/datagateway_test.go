@@ -0,0 +1,96 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package datagateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+func TestSingleUseTokens(t *testing.T) {
+	data := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer data.Close()
+
+	log := zerolog.Nop()
+	gw, err := New(map[string]interface{}{"transfer_shared_secret": "secret"}, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gw.Handler())
+	defer server.Close()
+
+	sign := func(singleUse bool, id string) string {
+		claims := transferClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				Audience:  jwt.ClaimStrings{"reva"},
+				ID:        id,
+			},
+			Target:    data.URL,
+			SingleUse: singleUse,
+		}
+		tkn, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tkn
+	}
+	do := func(method, tkn string) int {
+		req, err := http.NewRequest(method, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(TokenTransportHeader, tkn)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	reusable := sign(false, "")
+	for i := 0; i < 2; i++ {
+		if code := do(http.MethodGet, reusable); code != http.StatusOK {
+			t.Errorf("expected regular tokens to be reusable, got %d", code)
+		}
+	}
+
+	once := sign(true, "download-1")
+	if code := do(http.MethodHead, once); code != http.StatusOK {
+		t.Errorf("expected HEAD requests to not use up the token, got %d", code)
+	}
+	if code := do(http.MethodGet, once); code != http.StatusOK {
+		t.Errorf("expected the first download to succeed, got %d", code)
+	}
+	if code := do(http.MethodGet, once); code != http.StatusForbidden {
+		t.Errorf("expected the second download to be refused, got %d", code)
+	}
+	if code := do(http.MethodGet, sign(true, "")); code != http.StatusForbidden {
+		t.Errorf("expected single use tokens without id to be refused, got %d", code)
+	}
+}
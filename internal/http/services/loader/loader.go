@@ -22,6 +22,7 @@ import (
 	// Load core HTTP services
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/appprovider"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/archiver"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/audit"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/datagateway"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/dataprovider"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/helloworld"
@@ -30,12 +31,19 @@ import (
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/ocmd"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/owncloud/ocdav"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/owncloud/ocs"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/postprocessing"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/preferences"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/prometheus"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/reverseproxy"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/sciencemesh"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/scim"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/siteacc"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/sse"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/sysinfo"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/totp"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/uploads"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/webhooks"
 	_ "github.com/opencloud-eu/reva/v2/internal/http/services/wellknown"
+	_ "github.com/opencloud-eu/reva/v2/internal/http/services/wopi"
 	// Add your own service here
 )
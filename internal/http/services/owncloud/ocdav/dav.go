@@ -58,6 +58,7 @@ const (
 	ErrMissingBasicAuth   = "ERR_MISSING_BASIC_AUTH"
 	ErrMissingBearerAuth  = "ERR_MISSING_BEARER_AUTH"
 	ErrFileNotFoundInRoot = "ERR_FILE_NOT_FOUND_IN_ROOT"
+	ErrTooManyAttempts    = "ERR_TOO_MANY_ATTEMPTS"
 )
 
 // DavHandler routes to the different sub handlers
@@ -297,8 +298,10 @@ func (h *DavHandler) Handler(s *svc) http.Handler {
 				}
 			}
 
+			// failed attempts are tracked per client ip
+			authCtx := withClientIP(r.Context(), r)
 			if _, pass, hasValidBasicAuthHeader = r.BasicAuth(); hasValidBasicAuthHeader {
-				res, err = handleBasicAuth(r.Context(), s.gatewaySelector, token, pass)
+				res, err = handleBasicAuth(authCtx, s.gatewaySelector, token, pass)
 			} else {
 				q := r.URL.Query()
 				sig := q.Get("signature")
@@ -308,7 +311,7 @@ func (h *DavHandler) Handler(s *svc) http.Handler {
 					w.WriteHeader(http.StatusUnauthorized)
 					return
 				}
-				res, err = handleSignatureAuth(r.Context(), s.gatewaySelector, token, sig, expiration)
+				res, err = handleSignatureAuth(authCtx, s.gatewaySelector, token, sig, expiration)
 			}
 
 			switch {
@@ -327,6 +330,12 @@ func (h *DavHandler) Handler(s *svc) http.Handler {
 				b, err := errors.Marshal(http.StatusUnauthorized, "No 'Authorization: Basic' header found", "", ErrMissingBasicAuth)
 				errors.HandleWebdavError(log, w, b, err)
 				return
+			case res.Status.Code == rpc.Code_CODE_RESOURCE_EXHAUSTED:
+				log.Debug().Str("token", token).Interface("status", res.Status).Msg("too many failed attempts")
+				w.WriteHeader(http.StatusTooManyRequests)
+				b, err := errors.Marshal(http.StatusTooManyRequests, "Too many failed attempts, please try again later", "", ErrTooManyAttempts)
+				errors.HandleWebdavError(log, w, b, err)
+				return
 			case res.Status.Code == rpc.Code_CODE_NOT_FOUND:
 				w.WriteHeader(http.StatusNotFound)
 				return
@@ -417,6 +426,19 @@ func getTokenStatInfo(ctx context.Context, selector pool.Selectable[gatewayv1bet
 	}})
 }
 
+// withClientIP passes the client ip on to grpc calls unless the auth middleware already did
+func withClientIP(ctx context.Context, r *http.Request) context.Context {
+	if _, ok := ctxpkg.ContextGetClientIP(ctx); ok {
+		return ctx
+	}
+	clientIP, err := utils.GetClientIP(r)
+	if err != nil || clientIP == "" {
+		return ctx
+	}
+	ctx = ctxpkg.ContextSetClientIP(ctx, clientIP)
+	return metadata.AppendToOutgoingContext(ctx, ctxpkg.ClientIPHeader, clientIP)
+}
+
 func handleBasicAuth(ctx context.Context, selector pool.Selectable[gatewayv1beta1.GatewayAPIClient], token, pw string) (*gatewayv1beta1.AuthenticateResponse, error) {
 	c, err := selector.Next()
 	if err != nil {
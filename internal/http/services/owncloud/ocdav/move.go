@@ -322,6 +322,9 @@ func (s *svc) handleMove(ctx context.Context, w http.ResponseWriter, r *http.Req
 			// > of the same server namespace.
 			status = http.StatusBadGateway
 		}
+		if hsc := uploadPolicyStatus(mRes.Opaque); hsc != 0 {
+			status = hsc
+		}
 
 		w.WriteHeader(status)
 
@@ -37,6 +37,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/uploadpolicy"
 	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/rs/zerolog"
@@ -295,6 +296,12 @@ func (s *svc) handlePut(ctx context.Context, w http.ResponseWriter, r *http.Requ
 			// drain body to avoid `connection closed` errors
 			_, _ = io.Copy(io.Discard, r.Body)
 		}
+		if hsc := uploadPolicyStatus(uRes.Opaque); hsc != 0 {
+			w.WriteHeader(hsc)
+			b, err := errors.Marshal(hsc, uRes.Status.Message, "", "")
+			errors.HandleWebdavError(&log, w, b, err)
+			return
+		}
 		switch uRes.Status.Code {
 		case rpc.Code_CODE_PERMISSION_DENIED:
 			status := http.StatusForbidden
@@ -454,3 +461,18 @@ func getContentLength(r *http.Request) (int64, error) {
 	}
 	return length, nil
 }
+
+// uploadPolicyStatus returns the http status for an upload that was rejected by an upload policy
+// or 0 if the upload did not violate a policy
+func uploadPolicyStatus(o *typespb.Opaque) int {
+	switch utils.ReadPlainFromOpaque(o, uploadpolicy.OpaqueKey) {
+	case uploadpolicy.ViolationSize:
+		return http.StatusRequestEntityTooLarge
+	case uploadpolicy.ViolationType:
+		return http.StatusUnsupportedMediaType
+	case uploadpolicy.ViolationFilename:
+		return http.StatusBadRequest
+	default:
+		return 0
+	}
+}
@@ -244,6 +244,12 @@ func (s *svc) handleTusPost(ctx context.Context, w http.ResponseWriter, r *http.
 			// drain body to avoid `connection closed` errors
 			_, _ = io.Copy(io.Discard, r.Body)
 		}
+		if hsc := uploadPolicyStatus(uRes.Opaque); hsc != 0 {
+			w.WriteHeader(hsc)
+			b, err := errors.Marshal(hsc, uRes.Status.Message, "", "")
+			errors.HandleWebdavError(&log, w, b, err)
+			return
+		}
 		if uRes.Status.Code == rpc.Code_CODE_NOT_FOUND {
 			w.WriteHeader(http.StatusPreconditionFailed)
 			return
@@ -293,6 +299,9 @@ func (s *svc) handleTusPost(ctx context.Context, w http.ResponseWriter, r *http.
 				httpReq.Header.Set(net.HeaderUploadOffset, "0")
 			}
 			httpReq.Header.Set(net.HeaderTusResumable, r.Header.Get(net.HeaderTusResumable))
+			if r.Header.Get(net.HeaderUploadChecksum) != "" {
+				httpReq.Header.Set(net.HeaderUploadChecksum, r.Header.Get(net.HeaderUploadChecksum))
+			}
 
 			httpRes, err = s.client.Do(httpReq)
 			if err != nil || httpRes == nil {
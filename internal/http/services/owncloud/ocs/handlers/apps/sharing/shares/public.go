@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
@@ -41,18 +42,19 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/usage"
 	"github.com/pkg/errors"
 )
 
 var _defaultPublicLinkPermission = 1
 
-func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request, statInfo *provider.ResourceInfo) (*link.PublicShare, *ocsError) {
+func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request, statInfo *provider.ResourceInfo) (*link.PublicShare, *usage.Usage, *ocsError) {
 	ctx := r.Context()
 	log := appctx.GetLogger(ctx)
 
 	c, err := h.getClient()
 	if err != nil {
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaServerError.StatusCode,
 			Message: "error getting grpc gateway client",
 			Error:   err,
@@ -61,7 +63,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 
 	permKey, err := permKeyFromRequest(r, h)
 	if err != nil {
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaBadRequest.StatusCode,
 			Message: "Could not read permission from request",
 			Error:   err,
@@ -72,14 +74,14 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 	if permKey != nil && *permKey != 0 {
 		ok, err := utils.CheckPermission(ctx, permission.WritePublicLink, c)
 		if err != nil {
-			return nil, &ocsError{
+			return nil, nil, &ocsError{
 				Code:    response.MetaServerError.StatusCode,
 				Message: "failed to check user permission",
 				Error:   err,
 			}
 		}
 		if !ok {
-			return nil, &ocsError{
+			return nil, nil, &ocsError{
 				Code:    response.MetaForbidden.StatusCode,
 				Message: "user is not allowed to create a public link",
 			}
@@ -88,7 +90,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 
 	err = r.ParseForm()
 	if err != nil {
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaBadRequest.StatusCode,
 			Message: "Could not parse form from request",
 			Error:   err,
@@ -102,14 +104,14 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 		req := link.ListPublicSharesRequest{Filters: f}
 		res, err := c.ListPublicShares(ctx, &req)
 		if err != nil {
-			return nil, &ocsError{
+			return nil, nil, &ocsError{
 				Code:    response.MetaServerError.StatusCode,
 				Message: "could not list public links",
 				Error:   err,
 			}
 		}
 		if res.Status.Code != rpc.Code_CODE_OK {
-			return nil, &ocsError{
+			return nil, nil, &ocsError{
 				Code:    int(res.Status.GetCode()),
 				Message: "could not list public links",
 			}
@@ -117,7 +119,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 
 		for _, l := range res.GetShare() {
 			if l.Quicklink {
-				return l, nil
+				return l, usageFromOpaque(res.GetOpaque(), l.GetId().GetOpaqueId()), nil
 			}
 		}
 	}
@@ -129,7 +131,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 	}
 	permissions, err := ocPublicPermToCs3(permKey)
 	if err != nil {
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaBadRequest.StatusCode,
 			Message: "Could not create permission from permission key",
 			Error:   err,
@@ -138,7 +140,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 
 	password := r.FormValue("password")
 	if h.enforcePassword(permKey) && len(password) == 0 {
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaBadRequest.StatusCode,
 			Message: "missing required password",
 			Error:   errors.New("missing required password"),
@@ -146,7 +148,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 	}
 	if len(password) > 0 {
 		if err := h.passwordValidator.Validate(password); err != nil {
-			return nil, &ocsError{
+			return nil, nil, &ocsError{
 				Code:    response.MetaBadRequest.StatusCode,
 				Message: xstrings.FirstRuneToUpper(err.Error()),
 				Error:   fmt.Errorf("password validation failed: %w", err),
@@ -165,7 +167,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 
 	if !sufficientPermissions(statInfo.PermissionSet, permissions, true) {
 		response.WriteOCSError(w, r, http.StatusForbidden, "no share permission", nil)
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    http.StatusForbidden,
 			Message: "Cannot set the requested share permissions",
 			Error:   errors.New("cannot set the requested share permissions"),
@@ -187,7 +189,7 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 		if expireTimeString[0] != "" {
 			expireTime, err := conversions.ParseTimestamp(expireTimeString[0])
 			if err != nil {
-				return nil, &ocsError{
+				return nil, nil, &ocsError{
 					Code:    response.MetaBadRequest.StatusCode,
 					Message: err.Error(),
 					Error:   err,
@@ -199,6 +201,18 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	limits, ok, err := limitsFromRequest(r)
+	if err != nil {
+		return nil, nil, &ocsError{
+			Code:    response.MetaBadRequest.StatusCode,
+			Message: err.Error(),
+			Error:   err,
+		}
+	}
+	if ok {
+		req.Opaque = utils.AppendJSONToOpaque(req.Opaque, usage.OpaqueKeyLimits, limits)
+	}
+
 	// set displayname and password protected as arbitrary metadata
 	req.ResourceInfo.ArbitraryMetadata = &provider.ArbitraryMetadata{
 		Metadata: map[string]string{
@@ -210,22 +224,29 @@ func (h *Handler) createPublicLinkShare(w http.ResponseWriter, r *http.Request,
 	createRes, err := c.CreatePublicShare(ctx, &req)
 	if err != nil {
 		log.Debug().Err(err).Str("createShare", "shares").Msgf("error creating a public share to resource id: %v", statInfo.GetId())
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaServerError.StatusCode,
 			Message: "error creating public share",
 			Error:   fmt.Errorf("error creating a public share to resource id: %v", statInfo.GetId()),
 		}
 	}
 
-	if createRes.Status.Code != rpc.Code_CODE_OK {
+	switch createRes.Status.Code {
+	case rpc.Code_CODE_OK:
+	case rpc.Code_CODE_INVALID_ARGUMENT:
+		return nil, nil, &ocsError{
+			Code:    response.MetaBadRequest.StatusCode,
+			Message: createRes.Status.Message,
+		}
+	default:
 		log.Debug().Err(errors.New("create public share failed")).Str("shares", "createShare").Msgf("create public share failed with status code: %v", createRes.Status.Code.String())
-		return nil, &ocsError{
+		return nil, nil, &ocsError{
 			Code:    response.MetaServerError.StatusCode,
 			Message: "grpc create public share request failed",
 			Error:   nil,
 		}
 	}
-	return createRes.Share, nil
+	return createRes.Share, usageFromOpaque(createRes.GetOpaque(), ""), nil
 }
 
 func (h *Handler) listPublicShares(r *http.Request, filters []*link.ListPublicSharesRequest_Filter) ([]*conversions.ShareData, *rpc.Status, error) {
@@ -262,6 +283,7 @@ func (h *Handler) listPublicShares(r *http.Request, filters []*link.ListPublicSh
 			sData := conversions.PublicShare2ShareData(share, r, h.publicURL)
 
 			sData.Name = share.DisplayName
+			addUsage(sData, usageFromOpaque(res.GetOpaque(), share.GetId().GetOpaqueId()))
 
 			h.addFileInfo(ctx, sData, info)
 			h.mapUserIds(ctx, client, sData)
@@ -497,7 +519,18 @@ func (h *Handler) updatePublicShare(w http.ResponseWriter, r *http.Request, shar
 		})
 	}
 
+	// Download and access limits
+	limits, limitsFound, err := limitsFromRequest(r)
+	if err != nil {
+		response.WriteOCSError(w, r, response.MetaBadRequest.StatusCode, err.Error(), err)
+		return
+	}
+	if limitsFound {
+		updatesFound = true
+	}
+
 	// Updates are atomical. See: https://github.com/cs3org/cs3apis/pull/67#issuecomment-617651428 so in order to get the latest updated version
+	var u *usage.Usage
 	if len(updates) > 0 {
 		uRes := &link.UpdatePublicShareResponse{Share: share}
 		for k := range updates {
@@ -528,13 +561,98 @@ func (h *Handler) updatePublicShare(w http.ResponseWriter, r *http.Request, shar
 		return
 	}
 
+	// the limits are not part of the cs3 share and are updated on their own
+	if limitsFound {
+		uRes, err := gwC.UpdatePublicShare(r.Context(), &link.UpdatePublicShareRequest{
+			Opaque: utils.AppendJSONToOpaque(nil, usage.OpaqueKeyLimits, limits),
+			Ref: &link.PublicShareReference{
+				Spec: &link.PublicShareReference_Id{
+					Id: &link.PublicShareId{
+						OpaqueId: share.Id.OpaqueId,
+					},
+				},
+			},
+		})
+		switch {
+		case err != nil:
+			log.Err(err).Str("shareID", share.Id.OpaqueId).Msg("sending limits update request to public link provider")
+			response.WriteOCSError(w, r, response.MetaServerError.StatusCode, "Error sending update request to public link provider", err)
+			return
+		case uRes.Status.Code == rpc.Code_CODE_INVALID_ARGUMENT:
+			response.WriteOCSError(w, r, response.MetaBadRequest.StatusCode, uRes.Status.Message, nil)
+			return
+		case uRes.Status.Code != rpc.Code_CODE_OK:
+			response.WriteOCSError(w, r, response.MetaServerError.StatusCode, fmt.Sprintf("Error sending update request to public link provider: %s", uRes.Status.Message), nil)
+			return
+		}
+		u = usageFromOpaque(uRes.GetOpaque(), "")
+	}
+
 	s := conversions.PublicShare2ShareData(share, r, h.publicURL)
+	addUsage(s, u)
 	h.addFileInfo(r.Context(), s, statRes.Info)
 	h.mapUserIds(r.Context(), gwC, s)
 
 	response.WriteOCSSuccess(w, r, s)
 }
 
+// limitsFromRequest parses the maxDownloads and maxAccesses form values. Empty values remove the limit.
+func limitsFromRequest(r *http.Request) (usage.Limits, bool, error) {
+	l := usage.Limits{}
+	found := false
+	for key, limit := range map[string]*int{"maxDownloads": &l.MaxDownloads, "maxAccesses": &l.MaxAccesses} {
+		v, ok := r.Form[key]
+		if !ok {
+			continue
+		}
+		found = true
+		if v[0] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n < 0 {
+			return l, false, fmt.Errorf("invalid %s", key)
+		}
+		*limit = n
+	}
+	return l, found, nil
+}
+
+// usageFromOpaque returns the usage of a public link from a response opaque.
+// List responses carry the usage of all shares keyed by the share id.
+func usageFromOpaque(o *types.Opaque, shareID string) *usage.Usage {
+	if _, ok := o.GetMap()[usage.OpaqueKey]; !ok {
+		return nil
+	}
+	if shareID == "" {
+		u := &usage.Usage{}
+		if err := utils.ReadJSONFromOpaque(o, usage.OpaqueKey, u); err != nil {
+			return nil
+		}
+		return u
+	}
+	usages := map[string]*usage.Usage{}
+	if err := utils.ReadJSONFromOpaque(o, usage.OpaqueKey, &usages); err != nil {
+		return nil
+	}
+	return usages[shareID]
+}
+
+// addUsage adds the limits and access statistics to the share data
+func addUsage(s *conversions.ShareData, u *usage.Usage) {
+	if u == nil {
+		return
+	}
+	s.MaxDownloads = u.MaxDownloads
+	s.MaxAccesses = u.MaxAccesses
+	s.DownloadCount = u.Downloads
+	s.AccessCount = u.Accesses
+	s.BytesServed = u.BytesServed
+	if u.LastAccess > 0 {
+		s.LastAccess = time.Unix(u.LastAccess, 0).UTC().Format("2006-01-02 15:04:05")
+	}
+}
+
 func (h *Handler) removePublicShare(w http.ResponseWriter, r *http.Request, share *link.PublicShare) {
 	ctx := r.Context()
 
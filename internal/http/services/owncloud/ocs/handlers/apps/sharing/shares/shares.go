@@ -354,13 +354,14 @@ func (h *Handler) CreateShare(w http.ResponseWriter, r *http.Request) {
 			response.WriteOCSError(w, r, http.StatusForbidden, "No share permission", nil)
 			return
 		}
-		share, ocsErr := h.createPublicLinkShare(w, r, statRes.Info)
+		share, u, ocsErr := h.createPublicLinkShare(w, r, statRes.Info)
 		if ocsErr != nil {
 			response.WriteOCSError(w, r, ocsErr.Code, ocsErr.Message, ocsErr.Error)
 			return
 		}
 
 		s := conversions.PublicShare2ShareData(share, r, h.publicURL)
+		addUsage(s, u)
 		h.addFileInfo(ctx, s, statRes.GetInfo())
 		h.addPath(ctx, s, statRes.GetInfo())
 		h.mapUserIds(ctx, client, s)
@@ -498,6 +499,7 @@ func (h *Handler) extractPermissions(reqRole string, reqPermissions string, ri *
 	permissions := role.OCSPermissions()
 	if ri != nil && ri.Type == provider.ResourceType_RESOURCE_TYPE_FILE && permissions != conversions.PermissionInvalid {
 		// Single file shares should never have delete or create permissions
+		requested := permissions
 		permissions &^= conversions.PermissionCreate
 		permissions &^= conversions.PermissionDelete
 		if permissions == conversions.PermissionInvalid {
@@ -507,7 +509,10 @@ func (h *Handler) extractPermissions(reqRole string, reqPermissions string, ri *
 				Error:   errors.New("cannot set the requested share permissions"),
 			}
 		}
-		role = conversions.RoleFromOCSPermissions(permissions, ri)
+		// keep custom roles that are valid for files as they are
+		if permissions != requested || !conversions.IsCustomRole(role.Name) {
+			role = conversions.RoleFromOCSPermissions(permissions, ri)
+		}
 	}
 
 	if !sufficientPermissions(ri.PermissionSet, role.CS3ResourcePermissions(), false) && role.Name != conversions.RoleDenied {
@@ -602,6 +607,7 @@ func (h *Handler) GetShare(w http.ResponseWriter, r *http.Request) {
 
 	if err == nil && psRes.GetShare() != nil {
 		share = conversions.PublicShare2ShareData(psRes.Share, r, h.publicURL)
+		addUsage(share, usageFromOpaque(psRes.GetOpaque(), ""))
 		resourceID = psRes.Share.ResourceId
 	}
 
@@ -23,7 +23,9 @@ import (
 
 	"github.com/opencloud-eu/reva/v2/internal/http/services/owncloud/ocs/config"
 	"github.com/opencloud-eu/reva/v2/internal/http/services/owncloud/ocs/response"
+	"github.com/opencloud-eu/reva/v2/pkg/conversions"
 	"github.com/opencloud-eu/reva/v2/pkg/owncloud/ocs"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 )
 
 // Handler renders the capability endpoint
@@ -115,6 +117,8 @@ func (h *Handler) Init(c *config.Config) {
 		h.c.Capabilities.Files.AppProviders = []*ocs.CapabilitiesAppProvider{}
 	}
 
+	h.c.Capabilities.Files.UploadPolicies = uploadPolicies(sharedconf.UploadPolicies())
+
 	// dav
 
 	if h.c.Capabilities.Dav == nil {
@@ -135,6 +139,15 @@ func (h *Handler) Init(c *config.Config) {
 
 	// h.c.Capabilities.FilesSharing.APIEnabled is boolean
 
+	h.c.Capabilities.FilesSharing.Roles = nil
+	for _, r := range conversions.CustomRoles() {
+		h.c.Capabilities.FilesSharing.Roles = append(h.c.Capabilities.FilesSharing.Roles, &ocs.CapabilitiesFilesSharingRole{
+			Name:                r.Name,
+			Permissions:         int(r.OCSPermissions()),
+			ResourcePermissions: conversions.PermissionNames(r.CS3ResourcePermissions()),
+		})
+	}
+
 	if h.c.Capabilities.FilesSharing.Public == nil {
 		h.c.Capabilities.FilesSharing.Public = &ocs.CapabilitiesFilesSharingPublic{}
 	}
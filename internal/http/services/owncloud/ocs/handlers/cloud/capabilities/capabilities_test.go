@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/opencloud-eu/reva/v2/pkg/owncloud/ocs"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/uploadpolicy"
 )
 
 func TestMarshal(t *testing.T) {
@@ -59,3 +60,36 @@ func TestMarshal(t *testing.T) {
 		t.Fatal("xml data does not match")
 	}
 }
+
+func TestUploadPolicies(t *testing.T) {
+	policies := uploadPolicies(&uploadpolicy.Config{
+		Default: uploadpolicy.Policy{MaxFileSize: "10GB"},
+		SpaceTypes: map[string]uploadpolicy.Policy{
+			"project":  {BlockedExtensions: []string{"exe"}},
+			"personal": {MaxFileSize: "1GB"},
+		},
+		Spaces: map[string]uploadpolicy.Policy{
+			"space-1": {MaxFilenameLength: 64},
+		},
+	})
+
+	if len(policies) != 4 {
+		t.Fatalf("expected 4 upload policies, got %d", len(policies))
+	}
+	if policies[0].SpaceType != "" || policies[0].SpaceID != "" || policies[0].MaxFileSize != "10GB" {
+		t.Errorf("unexpected default policy %+v", policies[0])
+	}
+	if policies[1].SpaceType != "personal" || policies[1].MaxFileSize != "1GB" {
+		t.Errorf("unexpected personal policy %+v", policies[1])
+	}
+	if policies[2].SpaceType != "project" || len(policies[2].BlockedExtensions) != 1 {
+		t.Errorf("unexpected project policy %+v", policies[2])
+	}
+	if policies[3].SpaceID != "space-1" || policies[3].MaxFilenameLength != 64 {
+		t.Errorf("unexpected space policy %+v", policies[3])
+	}
+
+	if policies := uploadPolicies(&uploadpolicy.Config{}); policies != nil {
+		t.Errorf("expected no upload policies, got %+v", policies)
+	}
+}
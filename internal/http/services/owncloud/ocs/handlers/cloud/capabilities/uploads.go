@@ -19,9 +19,11 @@
 package capabilities
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/opencloud-eu/reva/v2/pkg/owncloud/ocs"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/uploadpolicy"
 )
 
 type chunkProtocol string
@@ -77,3 +79,47 @@ func setCapabilitiesForChunkProtocol(cp chunkProtocol, c *ocs.CapabilitiesData)
 		// }
 	}
 }
+
+// uploadPolicies advertises the configured upload policies. Like in the config, the policies of
+// space types and spaces only contain the fields that override the default policy.
+func uploadPolicies(c *uploadpolicy.Config) []*ocs.CapabilitiesUploadPolicy {
+	if c.Empty() {
+		return nil
+	}
+	policies := []*ocs.CapabilitiesUploadPolicy{}
+	if !c.Default.Empty() {
+		policies = append(policies, uploadPolicy("", "", c.Default))
+	}
+	spaceTypes := make([]string, 0, len(c.SpaceTypes))
+	for st := range c.SpaceTypes {
+		spaceTypes = append(spaceTypes, st)
+	}
+	sort.Strings(spaceTypes)
+	for _, st := range spaceTypes {
+		policies = append(policies, uploadPolicy(st, "", c.SpaceTypes[st]))
+	}
+	spaces := make([]string, 0, len(c.Spaces))
+	for id := range c.Spaces {
+		spaces = append(spaces, id)
+	}
+	sort.Strings(spaces)
+	for _, id := range spaces {
+		policies = append(policies, uploadPolicy("", id, c.Spaces[id]))
+	}
+	return policies
+}
+
+func uploadPolicy(spaceType, spaceID string, p uploadpolicy.Policy) *ocs.CapabilitiesUploadPolicy {
+	return &ocs.CapabilitiesUploadPolicy{
+		SpaceType:           spaceType,
+		SpaceID:             spaceID,
+		MaxFileSize:         p.MaxFileSize,
+		AllowedExtensions:   p.AllowedExtensions,
+		BlockedExtensions:   p.BlockedExtensions,
+		AllowedMimeTypes:    p.AllowedMimeTypes,
+		BlockedMimeTypes:    p.BlockedMimeTypes,
+		ForbiddenCharacters: p.ForbiddenCharacters,
+		ReservedNames:       p.ReservedNames,
+		MaxFilenameLength:   p.MaxFilenameLength,
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package postprocessing implements a service that runs the postprocessing pipeline
+// for finished uploads and allows admins to inspect and resume it.
+package postprocessing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/permission"
+	"github.com/opencloud-eu/reva/v2/pkg/postprocessing"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/store"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+func init() {
+	global.Register("postprocessing", New)
+}
+
+// StepConfig configures a single step of the pipeline
+type StepConfig struct {
+	Name         string                 `mapstructure:"name" docs:";The name of the step, e.g. virusscan or policies. It is reported in the postprocessing events."`
+	Type         string                 `mapstructure:"type" docs:";The registered type of the step. Defaults to the name."`
+	Timeout      string                 `mapstructure:"timeout" docs:";The maximum duration of a single attempt. Empty disables the limit."`
+	Retries      int                    `mapstructure:"retries" docs:"0;The number of additional attempts when the step fails."`
+	RetryBackoff string                 `mapstructure:"retry_backoff" docs:"1s;The time to wait between attempts."`
+	OnError      string                 `mapstructure:"on_error" docs:"retry;The outcome when all attempts failed. One of retry, abort, delete or continue."`
+	Options      map[string]interface{} `mapstructure:"options" docs:"nil;The configuration of the step."`
+}
+
+// Config holds the config options for the postprocessing HTTP service
+type Config struct {
+	Prefix             string       `mapstructure:"prefix" docs:"postprocessing;The prefix to be used for this HTTP service"`
+	GatewaySvc         string       `mapstructure:"gatewaysvc"`
+	Steps              []StepConfig `mapstructure:"steps" docs:"nil;The ordered steps of the pipeline."`
+	MaxRetries         int          `mapstructure:"max_retries" docs:"5;The number of retries before an upload is aborted."`
+	RetryBackoff       string       `mapstructure:"retry_backoff" docs:"10s;The initial delay before a retry. It doubles with every failure."`
+	MaxRetryBackoff    string       `mapstructure:"max_retry_backoff" docs:"1h;The maximum delay between retries."`
+	Workers            int          `mapstructure:"workers" docs:"4;The number of uploads processed concurrently."`
+	ConsumerGroup      string       `mapstructure:"consumer_group" docs:"postprocessing;The consumer group to receive the events with."`
+	NatsAddress        string       `mapstructure:"nats_address"`
+	NatsClusterID      string       `mapstructure:"nats_clusterID"`
+	NatsTLSInsecure    bool         `mapstructure:"nats_tls_insecure"`
+	NatsRootCACertPath string       `mapstructure:"nats_root_ca_cert_path"`
+	NatsEnableTLS      bool         `mapstructure:"nats_enable_tls"`
+	NatsUsername       string       `mapstructure:"nats_username"`
+	NatsPassword       string       `mapstructure:"nats_password"`
+	Store              string       `mapstructure:"store" docs:"nats-js-kv;The store to keep the state of the uploads in. It has to be persistent, otherwise uploads are stuck in processing after a restart. Replicas need nats-js-kv, redis or redis-sentinel to not run an upload twice."`
+	StoreNodes         []string     `mapstructure:"store_nodes" docs:"nil;The nodes of the store. Defaults to the nats address."`
+	StoreDatabase      string       `mapstructure:"store_database" docs:"reva;The database of the store."`
+	StoreTable         string       `mapstructure:"store_table" docs:"postprocessing;The table of the store. Replicas take over the uploads of the table that are not running on another replica."`
+	StoreAuthUsername  string       `mapstructure:"store_auth_username" docs:";The username to authenticate with the store."`
+	StoreAuthPassword  string       `mapstructure:"store_auth_password" docs:";The password to authenticate with the store."`
+	LeaseDuration      string       `mapstructure:"lease_duration" docs:"1m;The time a replica holds an upload without renewing it. Uploads of replicas that stopped are taken over after it, the store is scanned for them in this interval."`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "postprocessing"
+	}
+	if c.RetryBackoff == "" {
+		c.RetryBackoff = "10s"
+	}
+	if c.MaxRetryBackoff == "" {
+		c.MaxRetryBackoff = "1h"
+	}
+	if c.ConsumerGroup == "" {
+		c.ConsumerGroup = "postprocessing"
+	}
+	if c.Store == "" {
+		c.Store = store.TypeNatsJSKV
+	}
+	if len(c.StoreNodes) == 0 && c.NatsAddress != "" && !strings.HasPrefix(c.NatsAddress, "file://") {
+		c.StoreNodes = []string{c.NatsAddress}
+	}
+	if c.StoreDatabase == "" {
+		c.StoreDatabase = "reva"
+	}
+	if c.StoreTable == "" {
+		c.StoreTable = "postprocessing"
+	}
+	if c.LeaseDuration == "" {
+		c.LeaseDuration = "1m"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+}
+
+type svc struct {
+	conf         *Config
+	router       *chi.Mux
+	orchestrator *postprocessing.Orchestrator
+}
+
+// New returns a new postprocessing service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	if conf.NatsAddress == "" {
+		return nil, errors.New("postprocessing: missing nats configuration")
+	}
+	switch conf.Store {
+	case store.TypeMemory, store.TypeOCMem, store.TypeNoop:
+		return nil, fmt.Errorf("postprocessing: the %s store does not persist the state of the uploads", conf.Store)
+	}
+
+	steps, err := pipelineFromConfig(conf.Steps)
+	if err != nil {
+		return nil, err
+	}
+	opts := postprocessing.Options{
+		MaxRetries: conf.MaxRetries,
+		Workers:    conf.Workers,
+	}
+	opts.Store, err = cas.New(cas.Config{
+		Store:        conf.Store,
+		Nodes:        conf.StoreNodes,
+		Database:     conf.StoreDatabase,
+		Table:        conf.StoreTable,
+		AuthUsername: conf.StoreAuthUsername,
+		AuthPassword: conf.StoreAuthPassword,
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if opts.LeaseDuration, err = time.ParseDuration(conf.LeaseDuration); err != nil {
+		return nil, err
+	}
+	if opts.RetryBackoff, err = time.ParseDuration(conf.RetryBackoff); err != nil {
+		return nil, err
+	}
+	if opts.MaxRetryBackoff, err = time.ParseDuration(conf.MaxRetryBackoff); err != nil {
+		return nil, err
+	}
+
+	evstream, err := stream.NatsFromConfig("postprocessing", false, stream.NatsConfig{
+		Endpoint:             conf.NatsAddress,
+		Cluster:              conf.NatsClusterID,
+		EnableTLS:            conf.NatsEnableTLS,
+		TLSInsecure:          conf.NatsTLSInsecure,
+		TLSRootCACertificate: conf.NatsRootCACertPath,
+		AuthUsername:         conf.NatsUsername,
+		AuthPassword:         conf.NatsPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := postprocessing.NewOrchestrator(steps, evstream, opts, log)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := events.ConsumeManualAck(evstream, conf.ConsumerGroup, events.BytesReceived{}, events.ResumePostprocessing{})
+	if err != nil {
+		return nil, err
+	}
+	go o.Consume(ch)
+	// continue with the uploads that were in processing when a replica stopped
+	go o.Watch(opts.LeaseDuration)
+
+	s := &svc{
+		conf:         conf,
+		router:       chi.NewRouter(),
+		orchestrator: o,
+	}
+	s.routerInit(log)
+	return s, nil
+}
+
+func pipelineFromConfig(confs []StepConfig) ([]postprocessing.PipelineStep, error) {
+	steps := make([]postprocessing.PipelineStep, 0, len(confs))
+	for i, c := range confs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("postprocessing: step %d has no name", i)
+		}
+		typ := c.Type
+		if typ == "" {
+			typ = c.Name
+		}
+		step, err := postprocessing.NewStep(typ, c.Options)
+		if err != nil {
+			return nil, err
+		}
+		ps := postprocessing.PipelineStep{
+			Name:    events.Postprocessingstep(c.Name),
+			Step:    step,
+			Retries: c.Retries,
+			OnError: events.PostprocessingOutcome(c.OnError),
+		}
+		switch ps.OnError {
+		case "", events.PPOutcomeRetry, events.PPOutcomeAbort, events.PPOutcomeDelete, events.PPOutcomeContinue:
+		default:
+			return nil, fmt.Errorf("postprocessing: step %s: invalid error outcome %q", c.Name, c.OnError)
+		}
+		if c.Timeout != "" {
+			if ps.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+				return nil, err
+			}
+		}
+		ps.RetryBackoff = time.Second
+		if c.RetryBackoff != "" {
+			if ps.RetryBackoff, err = time.ParseDuration(c.RetryBackoff); err != nil {
+				return nil, err
+			}
+		}
+		steps = append(steps, ps)
+	}
+	return steps, nil
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Use(s.requireManagePermission)
+	s.router.Get("/uploads", s.handleList)
+	s.router.Get("/uploads/{id}", s.handleGet)
+	s.router.Post("/uploads/{id}/resume", s.handleResume)
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "postprocessing").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+func (s *svc) requireManagePermission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := appctx.GetLogger(ctx)
+
+		client, err := pool.GetGatewayServiceClient(s.conf.GatewaySvc)
+		if err != nil {
+			log.Error().Err(err).Msg("error getting grpc gateway client")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok, err := utils.CheckPermission(ctx, permission.ManageUploads, client)
+		if err != nil {
+			log.Error().Err(err).Msg("error checking permission")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleList returns all uploads currently in postprocessing
+func (s *svc) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, s.orchestrator.List())
+}
+
+// handleGet returns the postprocessing state of a single upload
+func (s *svc) handleGet(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.orchestrator.Status(chi.URLParam(r, "id"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, st)
+}
+
+// handleResume resumes a waiting upload, optionally at the step given in the query
+func (s *svc) handleResume(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := s.orchestrator.Status(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := s.orchestrator.Resume(id, events.Postprocessingstep(r.URL.Query().Get("step"))); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error marshalling response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing JSON response")
+	}
+}
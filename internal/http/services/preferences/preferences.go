@@ -31,6 +31,8 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
 	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 func init() {
@@ -80,6 +82,7 @@ func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error)
 func (s *svc) routerInit(log *zerolog.Logger) error {
 	s.router.Get("/", s.handleGet)
 	s.router.Post("/", s.handlePost)
+	s.router.Delete("/", s.handleDelete)
 
 	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
 		log.Debug().Str("service", "preferences").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
@@ -115,9 +118,9 @@ func (s *svc) handleGet(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	ns := r.URL.Query().Get("ns")
 
-	if key == "" || ns == "" {
+	if ns == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		if _, err := w.Write([]byte("key or namespace query missing")); err != nil {
+		if _, err := w.Write([]byte("namespace query missing")); err != nil {
 			log.Error().Err(err).Msg("error writing to response")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -131,16 +134,29 @@ func (s *svc) handleGet(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	keysClient, err := pool.GetPreferencesKeysClient(s.conf.GatewaySvc)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting grpc gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	res, err := client.GetKey(ctx, &preferences.GetKeyRequest{
+	req := &preferences.GetKeyRequest{
 		Key: &preferences.PreferenceKey{
 			Namespace: ns,
 			Key:       key,
 		},
-	})
+	}
+	var res *preferences.GetKeyResponse
+	if key == "" {
+		// without a key all keys of the namespace are listed
+		res, err = keysClient.ListKeys(ctx, req)
+	} else {
+		res, err = client.GetKey(ctx, req)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("error retrieving key")
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	if res.Status.Code != rpc.Code_CODE_OK {
@@ -153,11 +169,24 @@ func (s *svc) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	js, err := json.Marshal(map[string]interface{}{
+	response := map[string]interface{}{
 		"namespace": ns,
 		"key":       key,
 		"value":     res.Val,
-	})
+	}
+	if key == "" {
+		keys := map[string]string{}
+		if err := json.Unmarshal([]byte(res.Val), &keys); err != nil {
+			log.Error().Err(err).Msg("error decoding keys")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response = map[string]interface{}{
+			"namespace": ns,
+			"keys":      keys,
+		}
+	}
+	js, err := json.Marshal(response)
 	if err != nil {
 		log.Error().Err(err).Msg("error marshalling response")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -211,8 +240,70 @@ func (s *svc) handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if res.Status.Code != rpc.Code_CODE_OK {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStatus(w, res.Status.Code)
 		log.Error().Interface("status", res.Status).Msg("error setting key")
 		return
 	}
 }
+
+func (s *svc) handleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	key := r.URL.Query().Get("key")
+	ns := r.URL.Query().Get("ns")
+
+	if key == "" || ns == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte("key or namespace query missing")); err != nil {
+			log.Error().Err(err).Msg("error writing to response")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	client, err := pool.GetPreferencesKeysClient(s.conf.GatewaySvc)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting grpc gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := client.DeleteKey(ctx, &preferences.SetKeyRequest{
+		Key: &preferences.PreferenceKey{
+			Namespace: ns,
+			Key:       key,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error deleting key")
+		writeError(w, err)
+		return
+	}
+	if res.Status.Code != rpc.Code_CODE_OK {
+		writeStatus(w, res.Status.Code)
+		log.Error().Interface("status", res.Status).Msg("error deleting key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError answers requests the gateway does not support, e.g. older ones that can not list or delete keys,
+// with 501 Not Implemented
+func writeError(w http.ResponseWriter, err error) {
+	if grpcstatus.Code(err) == codes.Unimplemented {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func writeStatus(w http.ResponseWriter, code rpc.Code) {
+	switch code {
+	case rpc.Code_CODE_PERMISSION_DENIED:
+		// the key is locked by a default
+		w.WriteHeader(http.StatusForbidden)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errInvalidFilter is returned for filters that can not be parsed
+var errInvalidFilter = errors.New("invalid filter")
+
+// attributes returns the values of a resource attribute. Paths are lower case, e.g. emails.value.
+type attributes func(path string) []string
+
+// filter is a parsed SCIM filter expression, see https://www.rfc-editor.org/rfc/rfc7644#section-3.4.2.2
+type filter interface {
+	match(attrs attributes) bool
+}
+
+type logicalFilter struct {
+	and         bool
+	left, right filter
+}
+
+func (f logicalFilter) match(attrs attributes) bool {
+	if f.and {
+		return f.left.match(attrs) && f.right.match(attrs)
+	}
+	return f.left.match(attrs) || f.right.match(attrs)
+}
+
+type notFilter struct {
+	f filter
+}
+
+func (f notFilter) match(attrs attributes) bool {
+	return !f.f.match(attrs)
+}
+
+type attributeFilter struct {
+	path  string
+	op    string
+	value string
+}
+
+func (f attributeFilter) match(attrs attributes) bool {
+	values := attrs(f.path)
+	if f.op == "pr" {
+		for _, v := range values {
+			if v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if f.op == "ne" {
+		return !(attributeFilter{path: f.path, op: "eq", value: f.value}).match(attrs)
+	}
+	for _, v := range values {
+		if compare(f.op, strings.ToLower(v), strings.ToLower(f.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(op, v, value string) bool {
+	switch op {
+	case "eq":
+		return v == value
+	case "co":
+		return strings.Contains(v, value)
+	case "sw":
+		return strings.HasPrefix(v, value)
+	case "ew":
+		return strings.HasSuffix(v, value)
+	case "gt":
+		return v > value
+	case "ge":
+		return v >= value
+	case "lt":
+		return v < value
+	case "le":
+		return v <= value
+	}
+	return false
+}
+
+// parseFilter parses a SCIM filter. An empty filter matches all resources.
+func parseFilter(s string) (filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", errInvalidFilter, p.tokens[p.pos].value)
+	}
+	return f, nil
+}
+
+// equalityValue returns the value if the filter is a simple equality check of the given attribute
+func equalityValue(f filter, path string) (string, bool) {
+	af, ok := f.(attributeFilter)
+	if !ok || af.op != "eq" || af.path != path {
+		return "", false
+	}
+	return af.value, true
+}
+
+type token struct {
+	value  string
+	quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{value: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("%w: unterminated string", errInvalidFilter)
+			}
+			var v string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &v); err != nil {
+				return nil, fmt.Errorf("%w: %s", errInvalidFilter, err.Error())
+			}
+			tokens = append(tokens, token{value: v, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for ; end < len(s) && !strings.ContainsRune(" \t()\"", rune(s[end])); end++ {
+			}
+			tokens = append(tokens, token{value: s[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].value, keyword)
+}
+
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, fmt.Errorf("%w: unexpected end", errInvalidFilter)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) expect(value string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.quoted || t.value != value {
+		return fmt.Errorf("%w: expected %q, got %q", errInvalidFilter, value, t.value)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (filter, error) {
+	left, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseExpression() (filter, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f: f}, p.expect(")")
+	}
+	if p.peekKeyword("(") {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	}
+
+	attr, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if attr.quoted {
+		return nil, fmt.Errorf("%w: expected attribute, got %q", errInvalidFilter, attr.value)
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	f := attributeFilter{path: normalizePath(attr.value), op: strings.ToLower(op.value)}
+	switch f.op {
+	case "pr":
+		return f, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %q", errInvalidFilter, op.value)
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !value.quoted && value.value == "null" {
+		value.value = ""
+	}
+	f.value = value.value
+	return f, nil
+}
+
+// normalizePath lower cases an attribute path and strips the schema urn of core attributes
+func normalizePath(path string) string {
+	path = strings.ToLower(path)
+	for _, schema := range []string{userSchema, groupSchema} {
+		path = strings.TrimPrefix(path, strings.ToLower(schema)+":")
+	}
+	return path
+}
@@ -0,0 +1,412 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// Group is the SCIM representation of a group. The displayName is used as group name when creating groups.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+func (s *svc) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	withMembers := !strings.Contains(strings.ToLower(r.URL.Query().Get("excludedAttributes")), "members")
+
+	var groups []*grouppb.Group
+	if name, ok := equalityValue(f, "displayname"); ok {
+		g, err := s.groups.GetGroupByClaim(ctx, "group_name", name, true)
+		switch err.(type) {
+		case nil:
+			groups = []*grouppb.Group{g}
+		case errtypes.NotFound:
+		default:
+			handleError(w, r, err)
+			return
+		}
+	} else {
+		groups, err = s.groups.FindGroups(ctx, "", true)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GetId().GetOpaqueId() < groups[j].GetId().GetOpaqueId() })
+
+	resources := []interface{}{}
+	for _, g := range groups {
+		if withMembers || f != nil {
+			if g.Members, err = s.groups.GetMembers(ctx, g.Id); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+		if f == nil || f.match(groupAttributes(g)) {
+			if !withMembers {
+				g.Members = nil
+			}
+			resources = append(resources, toSCIMGroup(r, g))
+		}
+	}
+	res, err := s.page(r, resources)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (s *svc) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	skipMembers := strings.Contains(strings.ToLower(r.URL.Query().Get("excludedAttributes")), "members")
+	g, err := s.groups.GetGroup(r.Context(), &grouppb.GroupId{OpaqueId: chi.URLParam(r, "id")}, skipMembers)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, toSCIMGroup(r, g))
+}
+
+func (s *svc) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sg := &Group{}
+	if err := readJSON(r, sg); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if sg.DisplayName == "" {
+		writeError(w, r, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+
+	g, err := s.groups.CreateGroup(ctx, &grouppb.Group{
+		Id:          &grouppb.GroupId{OpaqueId: sg.ID},
+		GroupName:   sg.DisplayName,
+		DisplayName: sg.DisplayName,
+	})
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	s.publish(r, events.GroupCreated{
+		GroupID:   g.GetId().GetOpaqueId(),
+		Timestamp: utils.TSNow(),
+	})
+	if err := s.setMembers(r, g.Id, nil, memberIDs(sg.Members)); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	s.respondWithGroup(w, r, http.StatusCreated, g.Id)
+}
+
+func (s *svc) handleReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sg := &Group{}
+	if err := readJSON(r, sg); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	g, err := s.groups.GetGroup(ctx, &grouppb.GroupId{OpaqueId: chi.URLParam(r, "id")}, false)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	if sg.DisplayName != "" && sg.DisplayName != g.DisplayName {
+		g.DisplayName = sg.DisplayName
+		if _, err := s.groups.UpdateGroup(ctx, g); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+	if err := s.setMembers(r, g.Id, g.Members, memberIDs(sg.Members)); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	s.respondWithGroup(w, r, http.StatusOK, g.Id)
+}
+
+func (s *svc) handlePatchGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ops, err := readPatch(r)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	g, err := s.groups.GetGroup(ctx, &grouppb.GroupId{OpaqueId: chi.URLParam(r, "id")}, false)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	displayName := g.DisplayName
+	members := g.Members
+	for _, op := range ops {
+		if displayName, members, err = patchGroup(displayName, members, op); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+
+	if displayName != g.DisplayName {
+		g.DisplayName = displayName
+		if _, err := s.groups.UpdateGroup(ctx, g); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+	if err := s.setMembers(r, g.Id, g.Members, members); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	s.respondWithGroup(w, r, http.StatusOK, g.Id)
+}
+
+func (s *svc) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := s.groups.DeleteGroup(r.Context(), &grouppb.GroupId{OpaqueId: id}); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	s.publish(r, events.GroupDeleted{
+		GroupID:   id,
+		Timestamp: utils.TSNow(),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *svc) respondWithGroup(w http.ResponseWriter, r *http.Request, status int, gid *grouppb.GroupId) {
+	g, err := s.groups.GetGroup(r.Context(), gid, false)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, status, toSCIMGroup(r, g))
+}
+
+// setMembers changes the members of a group from current to wanted and publishes the corresponding events
+func (s *svc) setMembers(r *http.Request, gid *grouppb.GroupId, current, wanted []*userpb.UserId) error {
+	added := difference(wanted, current)
+	removed := difference(current, wanted)
+	ctx := r.Context()
+	if err := s.validateMembers(ctx, added); err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		if err := s.groups.AddMembers(ctx, gid, added); err != nil {
+			return err
+		}
+		for _, u := range added {
+			s.publish(r, events.GroupMemberAdded{
+				GroupID:   gid.GetOpaqueId(),
+				UserID:    u.GetOpaqueId(),
+				Timestamp: utils.TSNow(),
+			})
+		}
+	}
+	if len(removed) > 0 {
+		if err := s.groups.RemoveMembers(ctx, gid, removed); err != nil {
+			return err
+		}
+		for _, u := range removed {
+			s.publish(r, events.GroupMemberRemoved{
+				GroupID:   gid.GetOpaqueId(),
+				UserID:    u.GetOpaqueId(),
+				Timestamp: utils.TSNow(),
+			})
+		}
+	}
+	return nil
+}
+
+// validateMembers replaces the member ids sent by the identity provider with the full ids of the users
+func (s *svc) validateMembers(ctx context.Context, members []*userpb.UserId) error {
+	for i, m := range members {
+		u, err := s.users.GetUser(ctx, m, true)
+		if err != nil {
+			if _, ok := err.(errtypes.NotFound); ok {
+				return errtypes.BadRequest("unknown member: " + m.GetOpaqueId())
+			}
+			return err
+		}
+		members[i] = u.Id
+	}
+	return nil
+}
+
+// patchGroup applies a patch operation to the display name and members of a group
+func patchGroup(displayName string, members []*userpb.UserId, op patchOperation) (string, []*userpb.UserId, error) {
+	if op.Path == "" {
+		if op.Op == "remove" {
+			return "", nil, errtypes.BadRequest("remove operations require a path")
+		}
+		values := map[string]json.RawMessage{}
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return "", nil, errtypes.BadRequest("invalid patch value")
+		}
+		var err error
+		for path, value := range values {
+			if displayName, members, err = patchGroup(displayName, members, patchOperation{Op: op.Op, Path: path, Value: value}); err != nil {
+				return "", nil, err
+			}
+		}
+		return displayName, members, nil
+	}
+
+	path, f, err := splitValuePath(op.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	switch path {
+	case "displayname":
+		if op.Op == "remove" {
+			return "", nil, errtypes.BadRequest("displayName is required")
+		}
+		if err := json.Unmarshal(op.Value, &displayName); err != nil {
+			return "", nil, errtypes.BadRequest("invalid displayName")
+		}
+	case "members":
+		values := []MultiValue{}
+		if len(op.Value) > 0 && string(op.Value) != "null" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return "", nil, errtypes.BadRequest("invalid members")
+			}
+		}
+		switch {
+		case op.Op == "add":
+			members = append(members, difference(memberIDs(values), members)...)
+		case op.Op == "replace":
+			members = memberIDs(values)
+		case f != nil:
+			kept := []*userpb.UserId{}
+			for _, m := range members {
+				if !f.match(memberAttributes(m)) {
+					kept = append(kept, m)
+				}
+			}
+			members = kept
+		case len(values) > 0:
+			members = difference(members, memberIDs(values))
+		default:
+			members = nil
+		}
+	}
+	// other attributes can not be stored and are ignored like on create
+	return displayName, members, nil
+}
+
+// difference returns the ids in a that are not in b
+func difference(a, b []*userpb.UserId) []*userpb.UserId {
+	diff := []*userpb.UserId{}
+	for _, id := range a {
+		found := false
+		for _, other := range b {
+			if id.GetOpaqueId() == other.GetOpaqueId() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, id)
+		}
+	}
+	return diff
+}
+
+func memberIDs(values []MultiValue) []*userpb.UserId {
+	ids := make([]*userpb.UserId, 0, len(values))
+	for _, v := range values {
+		ids = append(ids, &userpb.UserId{OpaqueId: v.Value})
+	}
+	return ids
+}
+
+func toSCIMGroup(r *http.Request, g *grouppb.Group) *Group {
+	id := g.GetId().GetOpaqueId()
+	sg := &Group{
+		Schemas:     []string{groupSchema},
+		ID:          id,
+		DisplayName: g.DisplayName,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Location:     location(r, "Groups", id),
+		},
+	}
+	if sg.DisplayName == "" {
+		sg.DisplayName = g.GroupName
+	}
+	for _, m := range g.Members {
+		sg.Members = append(sg.Members, MultiValue{Value: m.GetOpaqueId(), Ref: location(r, "Users", m.GetOpaqueId())})
+	}
+	return sg
+}
+
+func groupAttributes(g *grouppb.Group) attributes {
+	return func(path string) []string {
+		switch path {
+		case "id":
+			return []string{g.GetId().GetOpaqueId()}
+		case "displayname":
+			return []string{g.DisplayName, g.GroupName}
+		case "members", "members.value":
+			values := make([]string, 0, len(g.Members))
+			for _, m := range g.Members {
+				values = append(values, m.GetOpaqueId())
+			}
+			return values
+		case "meta.resourcetype":
+			return []string{"Group"}
+		}
+		return nil
+	}
+}
+
+func memberAttributes(m *userpb.UserId) attributes {
+	return func(path string) []string {
+		if path == "value" {
+			return []string{m.GetOpaqueId()}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+)
+
+// patchOperation is a single operation of a SCIM PATCH request, see https://www.rfc-editor.org/rfc/rfc7644#section-3.5.2
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// readPatch reads the operations of a PATCH request. Operation names are lower cased.
+func readPatch(r *http.Request) ([]patchOperation, error) {
+	req := struct {
+		Schemas    []string         `json:"schemas"`
+		Operations []patchOperation `json:"Operations"`
+	}{}
+	if err := readJSON(r, &req); err != nil {
+		return nil, err
+	}
+	for i := range req.Operations {
+		op := strings.ToLower(req.Operations[i].Op)
+		switch op {
+		case "add", "replace", "remove":
+		default:
+			return nil, errtypes.BadRequest("invalid patch operation: " + req.Operations[i].Op)
+		}
+		req.Operations[i].Op = op
+	}
+	return req.Operations, nil
+}
+
+// splitValuePath splits a path like members[value eq "id"] into the attribute and the filter
+func splitValuePath(path string) (string, filter, error) {
+	i := strings.Index(path, "[")
+	if i < 0 {
+		return normalizePath(path), nil, nil
+	}
+	if !strings.HasSuffix(path, "]") {
+		return "", nil, errtypes.BadRequest("invalid path: " + path)
+	}
+	f, err := parseFilter(path[i+1 : len(path)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	return normalizePath(path[:i]), f, nil
+}
@@ -0,0 +1,305 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package scim
+
+import (
+	"context"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/group"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/user"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// userManager is the part of the user api the service provisions users with
+type userManager interface {
+	GetUser(ctx context.Context, uid *userpb.UserId, skipFetchingGroups bool) (*userpb.User, error)
+	GetUserByClaim(ctx context.Context, claim, value string, skipFetchingGroups bool) (*userpb.User, error)
+	FindUsers(ctx context.Context, query string, skipFetchingGroups bool) ([]*userpb.User, error)
+	user.Writer
+}
+
+// groupManager is the part of the group api the service provisions groups with
+type groupManager interface {
+	GetGroup(ctx context.Context, gid *grouppb.GroupId, skipFetchingMembers bool) (*grouppb.Group, error)
+	GetGroupByClaim(ctx context.Context, claim, value string, skipFetchingMembers bool) (*grouppb.Group, error)
+	FindGroups(ctx context.Context, query string, skipFetchingMembers bool) ([]*grouppb.Group, error)
+	GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error)
+	group.Writer
+}
+
+// authenticate returns a context to call the providers with as the configured service account
+func authenticate(ctx context.Context, conf *Config) (context.Context, error) {
+	gwc, err := pool.GetGatewayServiceClient(conf.GatewaySvc)
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetServiceUserContextWithContext(ctx, gwc, conf.ServiceAccountID, conf.ServiceAccountSecret)
+}
+
+// checkStatus returns the error of a provider call
+func checkStatus(status *rpc.Status, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case status.GetCode() == rpc.Code_CODE_INVALID:
+		return errtypes.BadRequest(status.GetMessage())
+	default:
+		return errtypes.NewErrtypeFromStatus(status)
+	}
+}
+
+// userProvider provisions users with the configured user provider
+type userProvider struct {
+	conf *Config
+}
+
+func (p *userProvider) GetUser(ctx context.Context, uid *userpb.UserId, skipFetchingGroups bool) (*userpb.User, error) {
+	ctx, c, err := p.userClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.GetUser(ctx, &userpb.GetUserRequest{UserId: uid, SkipFetchingUserGroups: skipFetchingGroups})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetUser(), nil
+}
+
+func (p *userProvider) GetUserByClaim(ctx context.Context, claim, value string, skipFetchingGroups bool) (*userpb.User, error) {
+	ctx, c, err := p.userClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.GetUserByClaim(ctx, &userpb.GetUserByClaimRequest{Claim: claim, Value: value, SkipFetchingUserGroups: skipFetchingGroups})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetUser(), nil
+}
+
+func (p *userProvider) FindUsers(ctx context.Context, query string, skipFetchingGroups bool) ([]*userpb.User, error) {
+	ctx, c, err := p.userClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.FindUsers(ctx, &userpb.FindUsersRequest{Filter: query, SkipFetchingUserGroups: skipFetchingGroups})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetUsers(), nil
+}
+
+func (p *userProvider) CreateUser(ctx context.Context, u *userpb.User) (*userpb.User, error) {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := pool.GetUserProvisioningClient(p.conf.UserProviderSvc)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.CreateUser(ctx, u)
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetUser(), nil
+}
+
+func (p *userProvider) UpdateUser(ctx context.Context, u *userpb.User) (*userpb.User, error) {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := pool.GetUserProvisioningClient(p.conf.UserProviderSvc)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.UpdateUser(ctx, u)
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetUser(), nil
+}
+
+func (p *userProvider) DeleteUser(ctx context.Context, uid *userpb.UserId) error {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return err
+	}
+	c, err := pool.GetUserProvisioningClient(p.conf.UserProviderSvc)
+	if err != nil {
+		return err
+	}
+	res, err := c.DeleteUser(ctx, &userpb.GetUserRequest{UserId: uid})
+	return checkStatus(res.GetStatus(), err)
+}
+
+func (p *userProvider) userClient(ctx context.Context) (context.Context, userpb.UserAPIClient, error) {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := pool.GetUserProviderServiceClient(p.conf.UserProviderSvc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ctx, c, nil
+}
+
+// groupProvider provisions groups with the configured group provider
+type groupProvider struct {
+	conf *Config
+}
+
+func (p *groupProvider) GetGroup(ctx context.Context, gid *grouppb.GroupId, skipFetchingMembers bool) (*grouppb.Group, error) {
+	ctx, c, err := p.groupClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.GetGroup(ctx, &grouppb.GetGroupRequest{GroupId: gid, SkipFetchingMembers: skipFetchingMembers})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetGroup(), nil
+}
+
+func (p *groupProvider) GetGroupByClaim(ctx context.Context, claim, value string, skipFetchingMembers bool) (*grouppb.Group, error) {
+	ctx, c, err := p.groupClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.GetGroupByClaim(ctx, &grouppb.GetGroupByClaimRequest{Claim: claim, Value: value, SkipFetchingMembers: skipFetchingMembers})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetGroup(), nil
+}
+
+func (p *groupProvider) FindGroups(ctx context.Context, query string, skipFetchingMembers bool) ([]*grouppb.Group, error) {
+	ctx, c, err := p.groupClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.FindGroups(ctx, &grouppb.FindGroupsRequest{Filter: query, SkipFetchingMembers: skipFetchingMembers})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetGroups(), nil
+}
+
+func (p *groupProvider) GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error) {
+	ctx, c, err := p.groupClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.GetMembers(ctx, &grouppb.GetMembersRequest{GroupId: gid})
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetMembers(), nil
+}
+
+func (p *groupProvider) CreateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error) {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := pool.GetGroupProvisioningClient(p.conf.GroupProviderSvc)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.CreateGroup(ctx, g)
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetGroup(), nil
+}
+
+func (p *groupProvider) UpdateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error) {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := pool.GetGroupProvisioningClient(p.conf.GroupProviderSvc)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.UpdateGroup(ctx, g)
+	if err := checkStatus(res.GetStatus(), err); err != nil {
+		return nil, err
+	}
+	return res.GetGroup(), nil
+}
+
+func (p *groupProvider) DeleteGroup(ctx context.Context, gid *grouppb.GroupId) error {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return err
+	}
+	c, err := pool.GetGroupProvisioningClient(p.conf.GroupProviderSvc)
+	if err != nil {
+		return err
+	}
+	res, err := c.DeleteGroup(ctx, &grouppb.GetGroupRequest{GroupId: gid})
+	return checkStatus(res.GetStatus(), err)
+}
+
+func (p *groupProvider) AddMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return err
+	}
+	c, err := pool.GetGroupProvisioningClient(p.conf.GroupProviderSvc)
+	if err != nil {
+		return err
+	}
+	res, err := c.AddMembers(ctx, &grouppb.Group{Id: gid, Members: members})
+	return checkStatus(res.GetStatus(), err)
+}
+
+func (p *groupProvider) RemoveMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return err
+	}
+	c, err := pool.GetGroupProvisioningClient(p.conf.GroupProviderSvc)
+	if err != nil {
+		return err
+	}
+	res, err := c.RemoveMembers(ctx, &grouppb.Group{Id: gid, Members: members})
+	return checkStatus(res.GetStatus(), err)
+}
+
+func (p *groupProvider) groupClient(ctx context.Context) (context.Context, grouppb.GroupAPIClient, error) {
+	ctx, err := authenticate(ctx, p.conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := pool.GetGroupProviderServiceClient(p.conf.GroupProviderSvc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ctx, c, nil
+}
@@ -0,0 +1,337 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package scim implements a SCIM 2.0 service to provision users and groups,
+// see https://www.rfc-editor.org/rfc/rfc7644
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+)
+
+const (
+	userSchema      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema     = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	listSchema      = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	patchSchema     = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	errorSchema     = "urn:ietf:params:scim:api:messages:2.0:Error"
+	providerSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimContentType = "application/scim+json"
+)
+
+func init() {
+	global.Register("scim", New)
+}
+
+// Config holds the config options for the SCIM HTTP service
+type Config struct {
+	Prefix               string `mapstructure:"prefix" docs:"scim;The prefix to be used for this HTTP service"`
+	Secret               string `mapstructure:"secret" docs:";The bearer token the identity provider has to send. Required."`
+	GatewaySvc           string `mapstructure:"gatewaysvc" docs:";The gateway to authenticate the service account with."`
+	UserProviderSvc      string `mapstructure:"userprovidersvc" docs:";The user provider to provision users with. Defaults to the gateway address."`
+	GroupProviderSvc     string `mapstructure:"groupprovidersvc" docs:";The group provider to provision groups with. Defaults to the gateway address."`
+	ServiceAccountID     string `mapstructure:"service_account_id" docs:";The id of the service account to call the providers with. Required."`
+	ServiceAccountSecret string `mapstructure:"service_account_secret" docs:";The secret of the service account."`
+	MaxResults           int    `mapstructure:"max_results" docs:"100;The maximum number of resources returned in one page."`
+	NatsAddress          string `mapstructure:"nats_address" docs:";The nats server to publish events to. Events are disabled if empty."`
+	NatsClusterID        string `mapstructure:"nats_clusterID"`
+	NatsTLSInsecure      bool   `mapstructure:"nats_tls_insecure"`
+	NatsRootCACertPath   string `mapstructure:"nats_root_ca_cert_path"`
+	NatsEnableTLS        bool   `mapstructure:"nats_enable_tls"`
+	NatsUsername         string `mapstructure:"nats_username"`
+	NatsPassword         string `mapstructure:"nats_password"`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "scim"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+	if c.UserProviderSvc == "" {
+		c.UserProviderSvc = c.GatewaySvc
+	}
+	if c.GroupProviderSvc == "" {
+		c.GroupProviderSvc = c.GatewaySvc
+	}
+	if c.MaxResults <= 0 {
+		c.MaxResults = 100
+	}
+}
+
+type svc struct {
+	conf   *Config
+	router *chi.Mux
+	users  userManager
+	groups groupManager
+	stream events.Publisher
+}
+
+// New returns a new SCIM service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	if conf.Secret == "" {
+		return nil, errors.New("scim: missing secret")
+	}
+	if conf.ServiceAccountID == "" {
+		return nil, errors.New("scim: missing service account")
+	}
+
+	s := newService(conf, &userProvider{conf: conf}, &groupProvider{conf: conf}, log)
+	if conf.NatsAddress != "" {
+		var err error
+		s.stream, err = stream.NatsFromConfig("scim", false, stream.NatsConfig{
+			Endpoint:             conf.NatsAddress,
+			Cluster:              conf.NatsClusterID,
+			EnableTLS:            conf.NatsEnableTLS,
+			TLSInsecure:          conf.NatsTLSInsecure,
+			TLSRootCACertificate: conf.NatsRootCACertPath,
+			AuthUsername:         conf.NatsUsername,
+			AuthPassword:         conf.NatsPassword,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// newService returns a SCIM service that provisions users and groups with the given managers
+func newService(conf *Config, users userManager, groups groupManager, log *zerolog.Logger) *svc {
+	s := &svc{
+		conf:   conf,
+		router: chi.NewRouter(),
+		users:  users,
+		groups: groups,
+	}
+	s.routerInit(log)
+	return s
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Use(s.authenticate)
+	s.router.Get("/ServiceProviderConfig", s.handleServiceProviderConfig)
+	s.router.Route("/Users", func(r chi.Router) {
+		r.Get("/", s.handleListUsers)
+		r.Post("/", s.handleCreateUser)
+		r.Get("/{id}", s.handleGetUser)
+		r.Put("/{id}", s.handleReplaceUser)
+		r.Patch("/{id}", s.handlePatchUser)
+		r.Delete("/{id}", s.handleDeleteUser)
+	})
+	s.router.Route("/Groups", func(r chi.Router) {
+		r.Get("/", s.handleListGroups)
+		r.Post("/", s.handleCreateGroup)
+		r.Get("/{id}", s.handleGetGroup)
+		r.Put("/{id}", s.handleReplaceGroup)
+		r.Patch("/{id}", s.handlePatchGroup)
+		r.Delete("/{id}", s.handleDeleteGroup)
+	})
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "scim").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+// Unprotected returns all paths because the identity provider authenticates with the configured secret instead of a reva token
+func (s *svc) Unprotected() []string {
+	return []string{"/"}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+func (s *svc) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.Secret)) != 1 {
+			writeError(w, r, http.StatusUnauthorized, "", "invalid credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *svc) handleServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(b bool) map[string]interface{} { return map[string]interface{}{"supported": b} }
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{providerSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": s.conf.MaxResults},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Authentication with a shared bearer token",
+		}},
+	})
+}
+
+func (s *svc) publish(r *http.Request, ev interface{}) {
+	if s.stream == nil {
+		return
+	}
+	if err := events.Publish(r.Context(), s.stream, ev); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Interface("event", ev).Msg("error publishing event")
+	}
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// page applies the startIndex and count query parameters to a list of resources
+func (s *svc) page(r *http.Request, resources []interface{}) (listResponse, error) {
+	startIndex, count := 1, s.conf.MaxResults
+	if v := r.URL.Query().Get("startIndex"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return listResponse{}, errtypes.BadRequest("invalid startIndex")
+		}
+		if i > 1 {
+			startIndex = i
+		}
+	}
+	if v := r.URL.Query().Get("count"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return listResponse{}, errtypes.BadRequest("invalid count")
+		}
+		count = min(max(i, 0), s.conf.MaxResults)
+	}
+
+	res := listResponse{
+		Schemas:      []string{listSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+	if startIndex <= len(resources) {
+		end := min(startIndex-1+count, len(resources))
+		res.Resources = resources[startIndex-1 : end]
+	}
+	res.ItemsPerPage = len(res.Resources)
+	return res, nil
+}
+
+func location(r *http.Request, resource, id string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	base := strings.TrimSuffix(r.URL.Path, "/")
+	if i := strings.Index(base, "/"+resource); i >= 0 {
+		base = base[:i]
+	}
+	return fmt.Sprintf("%s://%s%s/%s/%s", scheme, r.Host, base, resource, id)
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errtypes.BadRequest("invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error marshalling response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(js); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing JSON response")
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, scimType, detail string) {
+	writeJSON(w, r, status, map[string]interface{}{
+		"schemas":  []string{errorSchema},
+		"status":   strconv.Itoa(status),
+		"scimType": scimType,
+		"detail":   detail,
+	})
+}
+
+// handleError writes the SCIM error response for an error returned by the managers
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.(type) {
+	case errtypes.NotFound:
+		writeError(w, r, http.StatusNotFound, "", err.Error())
+	case errtypes.AlreadyExists:
+		writeError(w, r, http.StatusConflict, "uniqueness", err.Error())
+	case errtypes.BadRequest:
+		writeError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+	case errtypes.PermissionDenied:
+		writeError(w, r, http.StatusForbidden, "", err.Error())
+	case errtypes.NotSupported:
+		writeError(w, r, http.StatusNotImplemented, "", err.Error())
+	default:
+		if errors.Is(err, errInvalidFilter) {
+			writeError(w, r, http.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("scim request failed")
+		writeError(w, r, http.StatusInternalServerError, "", "internal error")
+	}
+}
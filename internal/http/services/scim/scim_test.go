@@ -0,0 +1,220 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	groupjson "github.com/opencloud-eu/reva/v2/pkg/group/manager/json"
+	userjson "github.com/opencloud-eu/reva/v2/pkg/user/manager/json"
+)
+
+type client struct {
+	t       *testing.T
+	handler http.Handler
+}
+
+func (c client) do(method, path, body string, v interface{}) int {
+	c.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			c.t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func newClient(t *testing.T) client {
+	dir := t.TempDir()
+	for _, f := range []string{"users.json", "groups.json"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte(`[]`), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users, err := userjson.New(map[string]interface{}{"users": filepath.Join(dir, "users.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := groupjson.New(map[string]interface{}{"groups": filepath.Join(dir, "groups.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{Secret: "secret", MaxResults: 2}
+	conf.init()
+	log := zerolog.Nop()
+	s := newService(conf, users.(userManager), groups.(groupManager), &log)
+	return client{t: t, handler: s.Handler()}
+}
+
+func TestAuthentication(t *testing.T) {
+	c := newClient(t)
+	req := httptest.NewRequest(http.MethodGet, "/Users", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rec.Code)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	c := newClient(t)
+
+	einstein := User{}
+	if code := c.do(http.MethodPost, "/Users", `{"schemas":["`+userSchema+`"],"userName":"einstein","name":{"formatted":"Albert Einstein"},"emails":[{"value":"einstein@example.org","primary":true}],"active":"True"}`, &einstein); code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", code)
+	}
+	if einstein.ID == "" || einstein.DisplayName != "Albert Einstein" || einstein.Emails[0].Value != "einstein@example.org" {
+		t.Fatalf("unexpected user %+v", einstein)
+	}
+	if code := c.do(http.MethodPost, "/Users", `{"userName":"einstein"}`, nil); code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", code)
+	}
+	for _, name := range []string{"marie", "richard"} {
+		if code := c.do(http.MethodPost, "/Users", `{"userName":"`+name+`"}`, nil); code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d", code)
+		}
+	}
+
+	res := listResponse{}
+	c.do(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq "Einstein"`), "", &res)
+	if res.TotalResults != 0 {
+		t.Fatalf("expected the fast path to match the username exactly, got %d results", res.TotalResults)
+	}
+	c.do(http.MethodGet, "/Users?filter="+url.QueryEscape(`emails.value ew "@example.org" or userName sw "ma"`), "", &res)
+	if res.TotalResults != 2 {
+		t.Fatalf("expected 2 results, got %d", res.TotalResults)
+	}
+	c.do(http.MethodGet, "/Users?startIndex=3", "", &res)
+	if res.TotalResults != 3 || res.ItemsPerPage != 1 || res.StartIndex != 3 {
+		t.Fatalf("unexpected page %+v", res)
+	}
+	if code := c.do(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq`), "", nil); code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", code)
+	}
+
+	patched := User{}
+	if code := c.do(http.MethodPatch, "/Users/"+einstein.ID, `{"schemas":["`+patchSchema+`"],"Operations":[{"op":"Replace","path":"displayName","value":"Albert"},{"op":"replace","value":{"active":false,"emails":[{"value":"albert@example.org"}]}}]}`, &patched); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if patched.DisplayName != "Albert" || patched.Emails[0].Value != "albert@example.org" || patched.UserName != "einstein" {
+		t.Fatalf("unexpected user %+v", patched)
+	}
+
+	if code := c.do(http.MethodDelete, "/Users/"+einstein.ID, "", nil); code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", code)
+	}
+	if code := c.do(http.MethodGet, "/Users/"+einstein.ID, "", nil); code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", code)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	c := newClient(t)
+
+	einstein, marie := User{}, User{}
+	c.do(http.MethodPost, "/Users", `{"userName":"einstein"}`, &einstein)
+	c.do(http.MethodPost, "/Users", `{"userName":"marie"}`, &marie)
+
+	physics := Group{}
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"physics-lovers","members":[{"value":"`+einstein.ID+`"}]}`, &physics); code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", code)
+	}
+	if len(physics.Members) != 1 || physics.Members[0].Value != einstein.ID {
+		t.Fatalf("unexpected group %+v", physics)
+	}
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"chemistry","members":[{"value":"unknown"}]}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", code)
+	}
+
+	patched := Group{}
+	c.do(http.MethodPatch, "/Groups/"+physics.ID, `{"Operations":[{"op":"add","path":"members","value":[{"value":"`+marie.ID+`"}]}]}`, &patched)
+	if len(patched.Members) != 2 {
+		t.Fatalf("expected 2 members, got %+v", patched.Members)
+	}
+	c.do(http.MethodPatch, "/Groups/"+physics.ID, `{"Operations":[{"op":"remove","path":"members[value eq \"`+einstein.ID+`\"]"}]}`, &patched)
+	if len(patched.Members) != 1 || patched.Members[0].Value != marie.ID {
+		t.Fatalf("unexpected members %+v", patched.Members)
+	}
+
+	res := listResponse{}
+	c.do(http.MethodGet, "/Groups?filter="+url.QueryEscape(`members.value eq "`+marie.ID+`"`)+"&excludedAttributes=members", "", &res)
+	if res.TotalResults != 1 {
+		t.Fatalf("expected 1 result, got %d", res.TotalResults)
+	}
+	if g := res.Resources[0].(map[string]interface{}); g["members"] != nil {
+		t.Fatalf("expected members to be excluded, got %v", g["members"])
+	}
+
+	replaced := Group{}
+	c.do(http.MethodPut, "/Groups/"+physics.ID, `{"displayName":"Physics Lovers","members":[]}`, &replaced)
+	if replaced.DisplayName != "Physics Lovers" || len(replaced.Members) != 0 {
+		t.Fatalf("unexpected group %+v", replaced)
+	}
+
+	if code := c.do(http.MethodDelete, "/Groups/"+physics.ID, "", nil); code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", code)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	attrs := func(path string) []string {
+		return map[string][]string{
+			"username":     {"einstein"},
+			"emails.value": {"einstein@example.org"},
+			"displayname":  {""},
+		}[path]
+	}
+	for filter, expected := range map[string]bool{
+		`userName eq "Einstein"`: true,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "einstein"`: true,
+		`userName ne "einstein"`:                                                   false,
+		`emails.value co "example" and not (userName sw "marie")`:                  true,
+		`userName eq "marie" or userName eq "einstein" and displayName pr`:         false,
+		`(userName eq "marie" or userName eq "einstein") and not (displayName pr)`: true,
+		`displayName eq null`:                                                      true,
+		`userName gt "a"`:                                                          true,
+	} {
+		f, err := parseFilter(filter)
+		if err != nil {
+			t.Fatalf("error parsing %q: %v", filter, err)
+		}
+		if f.match(attrs) != expected {
+			t.Errorf("expected %q to be %v", filter, expected)
+		}
+	}
+	for _, filter := range []string{`userName`, `userName xx "a"`, `(userName eq "a"`, `userName eq "a`, `userName eq "a" foo`} {
+		if _, err := parseFilter(filter); err == nil {
+			t.Errorf("expected error parsing %q", filter)
+		}
+	}
+}
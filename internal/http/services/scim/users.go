@@ -0,0 +1,330 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// User is the SCIM representation of a user. Attributes that can not be stored by the user managers are ignored.
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	UserName    string       `json:"userName"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Name        *Name        `json:"name,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Groups      []MultiValue `json:"groups,omitempty"`
+	// Active is always true, IdPs send it as bool or string
+	Active interface{} `json:"active,omitempty"`
+	Meta   *Meta       `json:"meta,omitempty"`
+}
+
+// Name holds the name components of a user
+type Name struct {
+	Formatted string `json:"formatted,omitempty"`
+}
+
+// MultiValue is an entry of a multi-valued attribute like emails or members
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Meta holds the resource metadata
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+func (s *svc) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	var users []*userpb.User
+	if username, ok := equalityValue(f, "username"); ok {
+		u, err := s.users.GetUserByClaim(ctx, "username", username, false)
+		switch err.(type) {
+		case nil:
+			users = []*userpb.User{u}
+		case errtypes.NotFound:
+		default:
+			handleError(w, r, err)
+			return
+		}
+	} else {
+		users, err = s.users.FindUsers(ctx, "", false)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].GetId().GetOpaqueId() < users[j].GetId().GetOpaqueId() })
+
+	resources := []interface{}{}
+	for _, u := range users {
+		if f == nil || f.match(userAttributes(u)) {
+			resources = append(resources, toSCIMUser(r, u))
+		}
+	}
+	res, err := s.page(r, resources)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (s *svc) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	u, err := s.users.GetUser(r.Context(), &userpb.UserId{OpaqueId: chi.URLParam(r, "id")}, false)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, toSCIMUser(r, u))
+}
+
+func (s *svc) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	su := &User{}
+	if err := readJSON(r, su); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if su.UserName == "" {
+		writeError(w, r, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+
+	u, err := s.users.CreateUser(r.Context(), fromSCIMUser(su))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	s.publish(r, events.UserCreated{
+		UserID:    u.GetId().GetOpaqueId(),
+		Timestamp: utils.TSNow(),
+	})
+	writeJSON(w, r, http.StatusCreated, toSCIMUser(r, u))
+}
+
+func (s *svc) handleReplaceUser(w http.ResponseWriter, r *http.Request) {
+	su := &User{}
+	if err := readJSON(r, su); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	u := fromSCIMUser(su)
+	u.Id = &userpb.UserId{OpaqueId: chi.URLParam(r, "id")}
+
+	u, err := s.users.UpdateUser(r.Context(), u)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, toSCIMUser(r, u))
+}
+
+func (s *svc) handlePatchUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ops, err := readPatch(r)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	u, err := s.users.GetUser(ctx, &userpb.UserId{OpaqueId: chi.URLParam(r, "id")}, false)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	for _, op := range ops {
+		if err := patchUser(u, op); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+
+	u, err = s.users.UpdateUser(ctx, u)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, toSCIMUser(r, u))
+}
+
+func (s *svc) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := s.users.DeleteUser(r.Context(), &userpb.UserId{OpaqueId: id}); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	s.publish(r, events.UserDeleted{
+		UserID:    id,
+		Timestamp: utils.TSNow(),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchUser applies a patch operation to a user
+func patchUser(u *userpb.User, op patchOperation) error {
+	if op.Path == "" {
+		if op.Op == "remove" {
+			return errtypes.BadRequest("remove operations require a path")
+		}
+		values := map[string]json.RawMessage{}
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return errtypes.BadRequest("invalid patch value")
+		}
+		for path, value := range values {
+			if err := patchUser(u, patchOperation{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path := normalizePath(op.Path)
+	var value string
+	if op.Op != "remove" {
+		value = stringValue(op.Value)
+	}
+	switch {
+	case path == "username":
+		if value == "" {
+			return errtypes.BadRequest("userName is required")
+		}
+		u.Username = value
+	case path == "displayname" || path == "name.formatted":
+		u.DisplayName = value
+	case path == "name" && op.Op != "remove":
+		n := &Name{}
+		if err := json.Unmarshal(op.Value, n); err != nil {
+			return errtypes.BadRequest("invalid name")
+		}
+		if n.Formatted != "" {
+			u.DisplayName = n.Formatted
+		}
+	case strings.HasPrefix(path, "emails"):
+		u.Mail = value
+	}
+	// other attributes can not be stored and are ignored like on create
+	return nil
+}
+
+// stringValue returns a string from a patch value. Multi-valued attributes like emails use the primary or first value.
+// Values of unsupported types, e.g. for active, are ignored.
+func stringValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	values := []MultiValue{}
+	if err := json.Unmarshal(raw, &values); err == nil {
+		return primaryValue(values)
+	}
+	return ""
+}
+
+func primaryValue(values []MultiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+func fromSCIMUser(su *User) *userpb.User {
+	u := &userpb.User{
+		Id:          &userpb.UserId{OpaqueId: su.ID},
+		Username:    su.UserName,
+		DisplayName: su.DisplayName,
+		Mail:        primaryValue(su.Emails),
+	}
+	if u.DisplayName == "" && su.Name != nil {
+		u.DisplayName = su.Name.Formatted
+	}
+	return u
+}
+
+func toSCIMUser(r *http.Request, u *userpb.User) *User {
+	id := u.GetId().GetOpaqueId()
+	su := &User{
+		Schemas:     []string{userSchema},
+		ID:          id,
+		UserName:    u.Username,
+		DisplayName: u.DisplayName,
+		Active:      true,
+		Meta: &Meta{
+			ResourceType: "User",
+			Location:     location(r, "Users", id),
+		},
+	}
+	if u.DisplayName != "" {
+		su.Name = &Name{Formatted: u.DisplayName}
+	}
+	if u.Mail != "" {
+		su.Emails = []MultiValue{{Value: u.Mail, Primary: true}}
+	}
+	for _, g := range u.Groups {
+		su.Groups = append(su.Groups, MultiValue{Value: g, Ref: location(r, "Groups", g)})
+	}
+	return su
+}
+
+func userAttributes(u *userpb.User) attributes {
+	return func(path string) []string {
+		switch path {
+		case "id":
+			return []string{u.GetId().GetOpaqueId()}
+		case "username":
+			return []string{u.Username}
+		case "displayname", "name.formatted":
+			return []string{u.DisplayName}
+		case "emails", "emails.value":
+			return []string{u.Mail}
+		case "groups", "groups.value":
+			return u.Groups
+		case "active":
+			return []string{"true"}
+		case "meta.resourcetype":
+			return []string{"User"}
+		}
+		return nil
+	}
+}
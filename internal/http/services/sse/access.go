@@ -0,0 +1,207 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sse
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/sse"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+)
+
+var errUnauthenticated = errors.New("sse: unauthenticated")
+
+type spaceSet struct {
+	ids     map[string]struct{}
+	expires time.Time
+}
+
+// accessChecker decides which notifications a user receives. It caches the spaces a user is
+// a member of or has received shares from to avoid a stat for every unrelated change.
+type accessChecker struct {
+	gatewaySvc string
+	ttl        time.Duration
+
+	mu     sync.Mutex
+	spaces map[string]*spaceSet
+}
+
+func newAccessChecker(gatewaySvc string, ttl time.Duration) *accessChecker {
+	return &accessChecker{
+		gatewaySvc: gatewaySvc,
+		ttl:        ttl,
+		spaces:     map[string]*spaceSet{},
+	}
+}
+
+// check returns the notification for the change if the user can access the changed resource.
+// The context has to carry the token of the user.
+func (a *accessChecker) check(ctx context.Context, u *userpb.User, c sse.Change) (sse.Notification, bool, error) {
+	n := sse.Notification{Type: c.Type, SpaceID: c.SpaceID}
+	if c.ResourceID != nil {
+		n.ResourceID = storagespace.FormatResourceID(c.ResourceID)
+	}
+
+	if c.HasAudience() {
+		if !c.Addresses(u) {
+			return n, false, nil
+		}
+		// the spaces and shares of the user changed
+		a.invalidate(u)
+		return n, true, nil
+	}
+	if c.Ref == nil && c.ParentRef == nil {
+		return n, false, nil
+	}
+
+	// new spaces are not in the cache yet
+	if c.SpaceID != "" && c.Type != sse.TypeSpaceCreated {
+		ok, err := a.inSpaces(ctx, u, c.SpaceID)
+		if err != nil || !ok {
+			return n, false, err
+		}
+	}
+
+	client, err := pool.GetGatewayServiceClient(a.gatewaySvc)
+	if err != nil {
+		return n, false, err
+	}
+	if c.Ref != nil {
+		info, err := stat(ctx, client, c.Ref)
+		if err != nil {
+			return n, false, err
+		}
+		if info != nil {
+			n.ResourceID = storagespace.FormatResourceID(info.GetId())
+			if info.GetParentId() != nil {
+				n.ParentID = storagespace.FormatResourceID(info.GetParentId())
+			}
+			n.SpaceID = info.GetId().GetSpaceId()
+			n.ETag = info.GetEtag()
+			return n, true, nil
+		}
+	}
+	if c.ParentRef != nil {
+		// the resource is gone, tell everybody who can see the parent
+		info, err := stat(ctx, client, c.ParentRef)
+		if err != nil || info == nil {
+			return n, false, err
+		}
+		n.ParentID = storagespace.FormatResourceID(info.GetId())
+		n.ETag = info.GetEtag()
+		return n, true, nil
+	}
+	return n, false, nil
+}
+
+// stat returns nil if the resource does not exist or the user can not access it
+func stat(ctx context.Context, client gateway.GatewayAPIClient, ref *provider.Reference) (*provider.ResourceInfo, error) {
+	res, err := client.Stat(ctx, &provider.StatRequest{Ref: ref})
+	if err != nil {
+		return nil, err
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		return res.GetInfo(), nil
+	case rpc.Code_CODE_NOT_FOUND, rpc.Code_CODE_PERMISSION_DENIED:
+		return nil, nil
+	case rpc.Code_CODE_UNAUTHENTICATED:
+		return nil, errUnauthenticated
+	default:
+		return nil, errtypes.NewErrtypeFromStatus(res.GetStatus())
+	}
+}
+
+func (a *accessChecker) invalidate(u *userpb.User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.spaces, u.GetId().GetOpaqueId())
+}
+
+// inSpaces returns true if the user is a member of the space or has received a share from it
+func (a *accessChecker) inSpaces(ctx context.Context, u *userpb.User, spaceID string) (bool, error) {
+	uid := u.GetId().GetOpaqueId()
+	a.mu.Lock()
+	set, ok := a.spaces[uid]
+	a.mu.Unlock()
+	if !ok || time.Now().After(set.expires) {
+		var err error
+		if set, err = a.loadSpaces(ctx); err != nil {
+			return false, err
+		}
+		a.mu.Lock()
+		a.spaces[uid] = set
+		a.mu.Unlock()
+	}
+	_, ok = set.ids[spaceID]
+	return ok, nil
+}
+
+func (a *accessChecker) loadSpaces(ctx context.Context) (*spaceSet, error) {
+	client, err := pool.GetGatewayServiceClient(a.gatewaySvc)
+	if err != nil {
+		return nil, err
+	}
+	set := &spaceSet{ids: map[string]struct{}{}, expires: time.Now().Add(a.ttl)}
+
+	lsRes, err := client.ListStorageSpaces(ctx, &provider.ListStorageSpacesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	switch lsRes.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK, rpc.Code_CODE_NOT_FOUND:
+	case rpc.Code_CODE_UNAUTHENTICATED:
+		return nil, errUnauthenticated
+	default:
+		return nil, errtypes.NewErrtypeFromStatus(lsRes.GetStatus())
+	}
+	for _, s := range lsRes.GetStorageSpaces() {
+		set.ids[s.GetRoot().GetSpaceId()] = struct{}{}
+	}
+
+	lrsRes, err := client.ListReceivedShares(ctx, &collaboration.ListReceivedSharesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	switch lrsRes.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK, rpc.Code_CODE_NOT_FOUND:
+	case rpc.Code_CODE_UNAUTHENTICATED:
+		return nil, errUnauthenticated
+	default:
+		return nil, errtypes.NewErrtypeFromStatus(lrsRes.GetStatus())
+	}
+	for _, rs := range lrsRes.GetShares() {
+		if rs.GetState() == collaboration.ShareState_SHARE_STATE_REJECTED {
+			continue
+		}
+		set.ids[rs.GetShare().GetResourceId().GetSpaceId()] = struct{}{}
+	}
+	return set, nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package sse implements a service that streams change notifications to clients
+// using server-sent events.
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/sse"
+)
+
+func init() {
+	global.Register("sse", New)
+}
+
+// Config holds the config options for the sse HTTP service
+type Config struct {
+	Prefix             string `mapstructure:"prefix" docs:"sse;The prefix to be used for this HTTP service"`
+	GatewaySvc         string `mapstructure:"gatewaysvc"`
+	BufferSize         int    `mapstructure:"buffer_size" docs:"1000;The number of recent notifications kept for clients resuming with Last-Event-ID."`
+	ClientBuffer       int    `mapstructure:"client_buffer" docs:"100;The number of notifications queued per client before it is disconnected."`
+	Heartbeat          string `mapstructure:"heartbeat" docs:"30s;The interval of keepalive comments sent to idle clients."`
+	Retry              string `mapstructure:"retry" docs:"5s;The reconnection delay suggested to the clients."`
+	CacheTTL           string `mapstructure:"cache_ttl" docs:"1m;How long the spaces and shares of a connected user are cached."`
+	NatsAddress        string `mapstructure:"nats_address"`
+	NatsClusterID      string `mapstructure:"nats_clusterID"`
+	NatsTLSInsecure    bool   `mapstructure:"nats_tls_insecure"`
+	NatsRootCACertPath string `mapstructure:"nats_root_ca_cert_path"`
+	NatsEnableTLS      bool   `mapstructure:"nats_enable_tls"`
+	NatsUsername       string `mapstructure:"nats_username"`
+	NatsPassword       string `mapstructure:"nats_password"`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "sse"
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 1000
+	}
+	if c.ClientBuffer == 0 {
+		c.ClientBuffer = 100
+	}
+	if c.Heartbeat == "" {
+		c.Heartbeat = "30s"
+	}
+	if c.Retry == "" {
+		c.Retry = "5s"
+	}
+	if c.CacheTTL == "" {
+		c.CacheTTL = "1m"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+}
+
+type svc struct {
+	conf      *Config
+	router    *chi.Mux
+	broker    *sse.Broker
+	access    *accessChecker
+	heartbeat time.Duration
+	retry     time.Duration
+}
+
+// New returns a new sse service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	if conf.NatsAddress == "" {
+		return nil, errors.New("sse: missing nats configuration")
+	}
+
+	s := &svc{
+		conf:   conf,
+		router: chi.NewRouter(),
+		broker: sse.NewBroker(conf.BufferSize, conf.ClientBuffer),
+	}
+	var err error
+	if s.heartbeat, err = time.ParseDuration(conf.Heartbeat); err != nil {
+		return nil, err
+	}
+	if s.retry, err = time.ParseDuration(conf.Retry); err != nil {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(conf.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	s.access = newAccessChecker(conf.GatewaySvc, ttl)
+
+	// every instance has to receive all events to notify its own clients, so the events are consumed
+	// without a group on a consumer that is removed when the instance goes away
+	evstream, err := stream.NatsFromConfig("sse", true, stream.NatsConfig{
+		Endpoint:             conf.NatsAddress,
+		Cluster:              conf.NatsClusterID,
+		EnableTLS:            conf.NatsEnableTLS,
+		TLSInsecure:          conf.NatsTLSInsecure,
+		TLSRootCACertificate: conf.NatsRootCACertPath,
+		AuthUsername:         conf.NatsUsername,
+		AuthPassword:         conf.NatsPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := events.Consume(evstream, "", sse.Events()...)
+	if err != nil {
+		return nil, err
+	}
+	go s.consume(ch)
+
+	s.routerInit(log)
+	return s, nil
+}
+
+func (s *svc) consume(ch <-chan events.Event) {
+	for ev := range ch {
+		if c, ok := sse.FromEvent(ev.Event); ok {
+			s.broker.Publish(c)
+		}
+	}
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Get("/", s.handleStream)
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "sse").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+// handleStream streams the notifications for the resources the current user can access
+func (s *svc) handleStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+	if _, ok := ctxpkg.ContextGetUser(ctx); !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		// EventSource can not set headers on the first connection
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	sub, replay, complete := s.broker.Subscribe(lastEventID)
+	defer s.broker.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", s.retry.Milliseconds()); err != nil {
+		return
+	}
+	if lastEventID != "" && !complete {
+		if err := writeEvent(w, "", sse.TypeResync, []byte("{}")); err != nil {
+			return
+		}
+	}
+	for _, e := range replay {
+		if !s.send(w, r, e) {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		log.Error().Err(err).Msg("sse: streaming is not supported by the response writer")
+		return
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				// the client fell behind, it will reconnect and resume
+				return
+			}
+			if !s.send(w, r, e) {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// send writes the entry if the user may see it. It returns false when the stream has to be closed.
+func (s *svc) send(w http.ResponseWriter, r *http.Request, e sse.Entry) bool {
+	ctx := r.Context()
+	u := ctxpkg.ContextMustGetUser(ctx)
+	n, ok, err := s.access.check(ctx, u, e.Change)
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		// the token expired, the client has to reconnect with a new one
+		return false
+	case err != nil:
+		appctx.GetLogger(ctx).Error().Err(err).Str("type", e.Change.Type).Msg("sse: could not check access")
+		return true
+	case !ok:
+		return true
+	}
+
+	data := e.Change.Message
+	if data == nil {
+		if data, err = json.Marshal(n); err != nil {
+			return true
+		}
+	}
+	return writeEvent(w, e.ID, e.Change.Type, data) == nil
+}
+
+func writeEvent(w http.ResponseWriter, id, typ string, data []byte) error {
+	var b bytes.Buffer
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\n", typ)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
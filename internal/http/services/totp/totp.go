@@ -0,0 +1,294 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package totp implements the HTTP service users enrol their second factor with
+package totp
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/auth/totp"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+)
+
+func init() {
+	global.Register("totp", New)
+}
+
+// Config holds the config options for the totp HTTP service. It has to use the same
+// storage and code store as the totp config of the auth provider.
+type Config struct {
+	Prefix      string `mapstructure:"prefix" docs:"totp;The prefix to be used for this HTTP service"`
+	totp.Config `mapstructure:",squash"`
+}
+
+func (c *Config) init() error {
+	if c.Prefix == "" {
+		c.Prefix = "totp"
+	}
+	return c.Config.Init()
+}
+
+type svc struct {
+	conf   *Config
+	router *chi.Mux
+	store  *totp.Store
+}
+
+// New returns a new totp service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	if err := conf.init(); err != nil {
+		return nil, err
+	}
+
+	store, err := conf.NewStore()
+	if err != nil {
+		return nil, err
+	}
+	return newService(conf, store, log), nil
+}
+
+func newService(conf *Config, store *totp.Store, log *zerolog.Logger) *svc {
+	svc := &svc{
+		conf:   conf,
+		router: chi.NewRouter(),
+		store:  store,
+	}
+	svc.routerInit(log)
+	return svc
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Get("/", s.handleGet)
+	s.router.Post("/", s.handleEnrol)
+	s.router.Post("/confirm", s.handleConfirm)
+	s.router.Delete("/", s.handleDelete)
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "totp").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+type statusResponse struct {
+	Enrolled  bool `json:"enrolled"`
+	Confirmed bool `json:"confirmed"`
+}
+
+type enrolResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+func (s *svc) handleGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := ctxpkg.ContextGetUser(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	e, err := s.store.Get(r.Context(), u.GetId().GetOpaqueId())
+	switch err.(type) {
+	case nil:
+		writeJSON(w, r, http.StatusOK, statusResponse{Enrolled: true, Confirmed: e.Confirmed})
+	case errtypes.NotFound:
+		writeJSON(w, r, http.StatusOK, statusResponse{})
+	default:
+		handleError(w, r, err)
+	}
+}
+
+// handleEnrol creates a new secret. Replacing a confirmed secret requires a code of the current one.
+func (s *svc) handleEnrol(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	userID := u.GetId().GetOpaqueId()
+
+	e, err := s.store.Get(ctx, userID)
+	switch err.(type) {
+	case nil:
+		if e.Confirmed {
+			req, err := readCode(r)
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+			if err := s.store.Use(ctx, userID, req.Code); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+	case errtypes.NotFound:
+	default:
+		handleError(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if err := s.store.Put(ctx, userID, &totp.Enrolment{Secret: secret, CreatedAt: time.Now()}); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, enrolResponse{
+		Secret: secret,
+		URI:    totp.URI(s.conf.Issuer, u.GetUsername(), secret),
+	})
+}
+
+// handleConfirm activates the second factor once the user proved to own the secret
+func (s *svc) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	userID := u.GetId().GetOpaqueId()
+
+	req, err := readCode(r)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	e, err := s.store.Get(ctx, userID)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if e.Confirmed {
+		handleError(w, r, errtypes.AlreadyExists("second factor already confirmed"))
+		return
+	}
+	if err := s.store.Use(ctx, userID, req.Code); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	e.Confirmed = true
+	if err := s.store.Put(ctx, userID, e); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, statusResponse{Enrolled: true, Confirmed: true})
+}
+
+// handleDelete removes the second factor. Confirmed secrets can only be removed with a code.
+func (s *svc) handleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	userID := u.GetId().GetOpaqueId()
+
+	e, err := s.store.Get(ctx, userID)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if e.Confirmed {
+		req, err := readCode(r)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if err := s.store.Use(ctx, userID, req.Code); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+	if err := s.store.Delete(ctx, userID); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readCode(r *http.Request) (*codeRequest, error) {
+	req := &codeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Code == "" {
+		return nil, errtypes.BadRequest("missing code")
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing response")
+	}
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case errtypes.NotFound:
+		status = http.StatusNotFound
+	case errtypes.BadRequest:
+		status = http.StatusBadRequest
+	case errtypes.AlreadyExists:
+		status = http.StatusConflict
+	case errtypes.InvalidCredentials:
+		status = http.StatusForbidden
+	default:
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("totp: internal error")
+	}
+	w.WriteHeader(status)
+}
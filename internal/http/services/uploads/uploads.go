@@ -0,0 +1,454 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package uploads implements an admin HTTP service to inspect, restart and purge upload sessions
+package uploads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	registry "github.com/cs3org/go-cs3apis/cs3/storage/registry/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/permission"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/storage"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+func init() {
+	global.Register("uploads", New)
+}
+
+// Config holds the config options for the uploads HTTP service
+type Config struct {
+	Prefix             string `mapstructure:"prefix" docs:"uploads;The prefix to be used for this HTTP service"`
+	GatewaySvc         string `mapstructure:"gatewaysvc"`
+	StorageRegistrySvc string `mapstructure:"storage_registry_svc" docs:";The storage registry that knows the storage providers whose upload sessions are managed. Defaults to the gateway."`
+	NatsAddress        string `mapstructure:"nats_address"`
+	NatsClusterID      string `mapstructure:"nats_clusterID"`
+	NatsTLSInsecure    bool   `mapstructure:"nats_tls_insecure"`
+	NatsRootCACertPath string `mapstructure:"nats_root_ca_cert_path"`
+	NatsEnableTLS      bool   `mapstructure:"nats_enable_tls"`
+	NatsUsername       string `mapstructure:"nats_username"`
+	NatsPassword       string `mapstructure:"nats_password"`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "uploads"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+	if c.StorageRegistrySvc == "" {
+		c.StorageRegistrySvc = c.GatewaySvc
+	}
+}
+
+// Session is the json representation of an upload session
+type Session struct {
+	// Provider is the address of the storage provider as known to the storage registry
+	Provider string `json:"provider"`
+	storage.UploadSessionInfo
+}
+
+type svc struct {
+	conf   *Config
+	router *chi.Mux
+	stream events.Stream
+}
+
+// New returns a new uploads service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	var evstream events.Stream
+	if conf.NatsAddress == "" || conf.NatsClusterID == "" {
+		log.Warn().Msg("missing or incomplete nats configuration. Postprocessing can not be restarted.")
+	} else {
+		s, err := stream.NatsFromConfig("uploads", false, stream.NatsConfig{
+			Endpoint:             conf.NatsAddress,
+			Cluster:              conf.NatsClusterID,
+			EnableTLS:            conf.NatsEnableTLS,
+			TLSInsecure:          conf.NatsTLSInsecure,
+			TLSRootCACertificate: conf.NatsRootCACertPath,
+			AuthUsername:         conf.NatsUsername,
+			AuthPassword:         conf.NatsPassword,
+		})
+		if err != nil {
+			return nil, err
+		}
+		evstream = s
+	}
+
+	s := &svc{
+		conf:   conf,
+		router: chi.NewRouter(),
+		stream: evstream,
+	}
+	s.routerInit(log)
+	return s, nil
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Use(s.requireManagePermission)
+	s.router.Get("/sessions", s.handleList)
+	s.router.Delete("/sessions/{provider}/{id}", s.handleTerminate)
+	s.router.Post("/sessions/{provider}/{id}/restart", s.handleRestart)
+	s.router.Post("/purge", s.handlePurge)
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "uploads").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+func (s *svc) requireManagePermission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := appctx.GetLogger(ctx)
+
+		client, err := pool.GetGatewayServiceClient(s.conf.GatewaySvc)
+		if err != nil {
+			log.Error().Err(err).Msg("error getting grpc gateway client")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok, err := utils.CheckPermission(ctx, permission.ManageUploads, client)
+		if err != nil {
+			log.Error().Err(err).Msg("error checking permission")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleList lists the upload sessions of all or a single provider
+func (s *svc) handleList(w http.ResponseWriter, r *http.Request) {
+	log := appctx.GetLogger(r.Context())
+
+	filter, err := filterFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.listSessions(r, filter, false)
+	if err != nil {
+		log.Error().Err(err).Msg("error listing upload sessions")
+		w.WriteHeader(httpStatus(err))
+		return
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Expires.Before(res[j].Expires)
+	})
+
+	writeJSON(w, r, res)
+}
+
+// handleRestart restarts the postprocessing of an upload session
+func (s *svc) handleRestart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	if s.stream == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	us, err := s.getSession(r, false)
+	if err != nil {
+		w.WriteHeader(httpStatus(err))
+		return
+	}
+
+	if err := events.Publish(ctx, s.stream, events.RestartPostprocessing{
+		UploadID:  us.ID,
+		Timestamp: utils.TSNow(),
+	}); err != nil {
+		log.Error().Err(err).Str("uploadid", us.ID).Msg("error publishing RestartPostprocessing event")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleTerminate removes an upload session including its data
+func (s *svc) handleTerminate(w http.ResponseWriter, r *http.Request) {
+	log := appctx.GetLogger(r.Context())
+
+	if _, err := s.getSession(r, true); err != nil {
+		log.Error().Err(err).Str("uploadid", chi.URLParam(r, "id")).Msg("error purging upload session")
+		w.WriteHeader(httpStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurge removes all upload sessions matching the filter. By default only expired
+// sessions that are not processing are purged.
+func (s *svc) handlePurge(w http.ResponseWriter, r *http.Request) {
+	log := appctx.GetLogger(r.Context())
+
+	filter, err := filterFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if filter.Expired == nil {
+		filter.Expired = boolPtr(true)
+	}
+	if filter.Processing == nil {
+		filter.Processing = boolPtr(false)
+	}
+
+	res, err := s.listSessions(r, filter, true)
+	if err != nil {
+		log.Error().Err(err).Msg("error purging upload sessions")
+		w.WriteHeader(httpStatus(err))
+		return
+	}
+
+	writeJSON(w, r, res)
+}
+
+// listSessions lists, and optionally purges, the upload sessions of all or a single provider.
+// The storage providers check the permission of the user again.
+func (s *svc) listSessions(r *http.Request, filter storage.UploadSessionFilter, purge bool) ([]Session, error) {
+	providers, err := s.providers(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	if addr := r.URL.Query().Get("provider"); addr != "" {
+		if !slices.Contains(providers, addr) {
+			return []Session{}, nil
+		}
+		providers = []string{addr}
+	}
+
+	res := []Session{}
+	for _, addr := range providers {
+		infos, err := s.providerSessions(r.Context(), addr, filter, purge)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", addr, err)
+		}
+		for _, info := range infos {
+			res = append(res, Session{Provider: addr, UploadSessionInfo: info})
+		}
+	}
+	return res, nil
+}
+
+// getSession returns the upload session of the request. Purged sessions are removed by the storage provider.
+func (s *svc) getSession(r *http.Request, purge bool) (storage.UploadSessionInfo, error) {
+	providers, err := s.providers(r.Context())
+	if err != nil {
+		return storage.UploadSessionInfo{}, err
+	}
+	addr := chi.URLParam(r, "provider")
+	if !slices.Contains(providers, addr) {
+		return storage.UploadSessionInfo{}, errtypes.NotFound("provider")
+	}
+	id := chi.URLParam(r, "id")
+	infos, err := s.providerSessions(r.Context(), addr, storage.UploadSessionFilter{ID: &id}, purge)
+	if err != nil {
+		return storage.UploadSessionInfo{}, err
+	}
+	if len(infos) == 0 {
+		return storage.UploadSessionInfo{}, errtypes.NotFound(id)
+	}
+	return infos[0], nil
+}
+
+// providers returns the addresses of all storage providers known to the storage registry
+func (s *svc) providers(ctx context.Context) ([]string, error) {
+	client, err := pool.GetStorageRegistryClient(s.conf.StorageRegistrySvc)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.ListStorageProviders(ctx, &registry.ListStorageProvidersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if res.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		return nil, errtypes.NewErrtypeFromStatus(res.GetStatus())
+	}
+	providers := make([]string, 0, len(res.GetProviders()))
+	for _, p := range res.GetProviders() {
+		if !slices.Contains(providers, p.GetAddress()) {
+			providers = append(providers, p.GetAddress())
+		}
+	}
+	return providers, nil
+}
+
+// providerSessions lists or purges the upload sessions of a storage provider
+func (s *svc) providerSessions(ctx context.Context, addr string, filter storage.UploadSessionFilter, purge bool) ([]storage.UploadSessionInfo, error) {
+	client, err := pool.GetUploadSessionsClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	opaque := utils.AppendJSONToOpaque(nil, storage.OpaqueKeyUploadSessions, filter)
+
+	var (
+		st  *rpc.Status
+		res *types.Opaque
+	)
+	if purge {
+		r, err := client.PurgeUploadSessions(ctx, &provider.DeleteStorageSpaceRequest{Opaque: opaque})
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		st, res = r.GetStatus(), r.GetOpaque()
+	} else {
+		r, err := client.ListUploadSessions(ctx, &provider.ListStorageSpacesRequest{Opaque: opaque})
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		st, res = r.GetStatus(), r.GetOpaque()
+	}
+	if st.GetCode() != rpc.Code_CODE_OK {
+		return nil, errtypes.NewErrtypeFromStatus(st)
+	}
+	var infos []storage.UploadSessionInfo
+	if err := utils.ReadJSONFromOpaque(res, storage.OpaqueKeyUploadSessions, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// grpcError maps storage providers that do not offer the upload sessions api to errtypes.NotSupported
+func grpcError(err error) error {
+	if grpcstatus.Code(err) == codes.Unimplemented {
+		return errtypes.NotSupported("the storage provider does not support managing upload sessions")
+	}
+	return err
+}
+
+// httpStatus maps the errors of the storage providers to http status codes
+func httpStatus(err error) int {
+	var (
+		notFound     errtypes.IsNotFound
+		denied       errtypes.IsPermissionDenied
+		notSupported errtypes.IsNotSupported
+	)
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &denied):
+		return http.StatusForbidden
+	case errors.As(err, &notSupported):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func filterFromRequest(r *http.Request) (storage.UploadSessionFilter, error) {
+	q := r.URL.Query()
+	filter := storage.UploadSessionFilter{}
+
+	if id := q.Get("id"); id != "" {
+		filter.ID = &id
+	}
+	if space := q.Get("space"); space != "" {
+		filter.SpaceID = &space
+	}
+	if user := q.Get("user"); user != "" {
+		filter.Executant = &userpb.UserId{OpaqueId: user}
+	}
+	for param, target := range map[string]**bool{
+		"expired":    &filter.Expired,
+		"processing": &filter.Processing,
+		"has_virus":  &filter.HasVirus,
+	} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, err
+		}
+		*target = &b
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error marshalling response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing JSON response")
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
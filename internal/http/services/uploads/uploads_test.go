@@ -0,0 +1,52 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package uploads
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilterFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/sessions?space=space-id&user=einstein&expired=true&processing=false", nil)
+	filter, err := filterFromRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.SpaceID == nil || *filter.SpaceID != "space-id" {
+		t.Errorf("expected space filter, got %v", filter.SpaceID)
+	}
+	if filter.Executant.GetOpaqueId() != "einstein" {
+		t.Errorf("expected executant filter, got %v", filter.Executant)
+	}
+	if filter.Expired == nil || !*filter.Expired {
+		t.Errorf("expected expired filter to be true, got %v", filter.Expired)
+	}
+	if filter.Processing == nil || *filter.Processing {
+		t.Errorf("expected processing filter to be false, got %v", filter.Processing)
+	}
+	if filter.HasVirus != nil || filter.ID != nil {
+		t.Error("expected unset filters to be nil")
+	}
+
+	r = httptest.NewRequest("GET", "/sessions?expired=maybe", nil)
+	if _, err := filterFromRequest(r); err == nil {
+		t.Error("expected an error for an invalid boolean")
+	}
+}
@@ -0,0 +1,518 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package webhooks implements the HTTP service that delivers events to registered webhooks
+// and allows managing them.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+	grpcmetadata "google.golang.org/grpc/metadata"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/permission"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/opencloud-eu/reva/v2/pkg/webhooks"
+)
+
+func init() {
+	global.Register("webhooks", New)
+}
+
+// Config holds the config options for the webhooks HTTP service
+type Config struct {
+	Prefix             string `mapstructure:"prefix" docs:"webhooks;The prefix to be used for this HTTP service"`
+	GatewaySvc         string `mapstructure:"gatewaysvc"`
+	ProviderAddr       string `mapstructure:"provider_addr" docs:"localhost:9215;The address of the storage provider the webhooks are stored in."`
+	ServiceUserID      string `mapstructure:"service_user_id" docs:";The id of the service user accessing the metadata storage."`
+	ServiceUserIdp     string `mapstructure:"service_user_idp" docs:";The idp of the service user accessing the metadata storage."`
+	MachineAuthAPIKey  string `mapstructure:"machine_auth_apikey" docs:";The machine auth api key of the service user."`
+	MaxAttempts        int    `mapstructure:"max_attempts" docs:"5;The number of attempts before a delivery is moved to the dead letters."`
+	InitialBackoff     int    `mapstructure:"initial_backoff" docs:"1;The number of seconds to wait before retrying a delivery. It doubles with every retry."`
+	MaxBackoff         int    `mapstructure:"max_backoff" docs:"300;The maximum number of seconds to wait between two attempts."`
+	Timeout            int    `mapstructure:"timeout" docs:"10;The number of seconds to wait for a webhook to respond."`
+	Workers            int    `mapstructure:"workers" docs:"4;The number of deliveries that are sent concurrently."`
+	Insecure           bool   `mapstructure:"insecure" docs:"false;Allow webhooks with plain http endpoints."`
+	AllowPrivate       bool   `mapstructure:"allow_private" docs:"false;Allow webhooks with endpoints on loopback, private or link-local addresses."`
+	ConsumerGroup      string `mapstructure:"consumer_group" docs:"webhooks;The consumer group to receive the events with."`
+	NatsAddress        string `mapstructure:"nats_address"`
+	NatsClusterID      string `mapstructure:"nats_clusterID"`
+	NatsTLSInsecure    bool   `mapstructure:"nats_tls_insecure"`
+	NatsRootCACertPath string `mapstructure:"nats_root_ca_cert_path"`
+	NatsEnableTLS      bool   `mapstructure:"nats_enable_tls"`
+	NatsUsername       string `mapstructure:"nats_username"`
+	NatsPassword       string `mapstructure:"nats_password"`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "webhooks"
+	}
+	if c.ProviderAddr == "" {
+		c.ProviderAddr = "localhost:9215"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+	if c.ConsumerGroup == "" {
+		c.ConsumerGroup = "webhooks"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+}
+
+type svc struct {
+	conf       *Config
+	router     *chi.Mux
+	store      *webhooks.Store
+	dispatcher *webhooks.Dispatcher
+}
+
+// New returns a new webhooks service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	if conf.NatsAddress == "" {
+		return nil, errors.New("webhooks: missing nats configuration")
+	}
+
+	storage, err := metadata.NewCS3Storage(conf.ProviderAddr, conf.ProviderAddr, conf.ServiceUserID, conf.ServiceUserIdp, conf.MachineAuthAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	s := &svc{
+		conf:   conf,
+		router: chi.NewRouter(),
+		store:  webhooks.NewStore(storage),
+	}
+	s.dispatcher = webhooks.NewDispatcher(s.store, webhooks.Options{
+		MaxAttempts:    conf.MaxAttempts,
+		InitialBackoff: time.Duration(conf.InitialBackoff) * time.Second,
+		MaxBackoff:     time.Duration(conf.MaxBackoff) * time.Second,
+		Workers:        conf.Workers,
+		Client:         webhooks.NewClient(time.Duration(conf.Timeout)*time.Second, conf.AllowPrivate),
+		Authorize:      s.authorize,
+	}, log)
+
+	evstream, err := stream.NatsFromConfig("webhooks", false, stream.NatsConfig{
+		Endpoint:             conf.NatsAddress,
+		Cluster:              conf.NatsClusterID,
+		EnableTLS:            conf.NatsEnableTLS,
+		TLSInsecure:          conf.NatsTLSInsecure,
+		TLSRootCACertificate: conf.NatsRootCACertPath,
+		AuthUsername:         conf.NatsUsername,
+		AuthPassword:         conf.NatsPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := events.ConsumeAllManualAck(evstream, conf.ConsumerGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dispatcher.Start()
+	go s.dispatcher.Consume(ch)
+	s.routerInit(log)
+	return s, nil
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Get("/", s.handleList)
+	s.router.Post("/", s.handleCreate)
+	s.router.Route("/{id}", func(r chi.Router) {
+		r.Get("/", s.handleGet)
+		r.Delete("/", s.handleDelete)
+		r.Get("/deadletters", s.handleListDeadLetters)
+		r.Post("/deadletters/{delivery}", s.handleRedeliver)
+		r.Delete("/deadletters/{delivery}", s.handleDeleteDeadLetter)
+	})
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "webhooks").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+type createRequest struct {
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	SpaceID    string   `json:"space_id"`
+	PathPrefix string   `json:"path_prefix"`
+}
+
+// handleList returns the webhooks of the user. Users with the ManageWebhooks permission can list all webhooks.
+func (s *svc) handleList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	owner := u.GetId().GetOpaqueId()
+	if r.URL.Query().Get("all") == "true" {
+		isAdmin, err := s.isAdmin(r.Context())
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if !isAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		owner = ""
+	}
+	list, err := s.store.List(ctx, owner)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	for _, wh := range list {
+		wh.Secret = ""
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+// handleCreate registers a webhook. Users without the ManageWebhooks permission have to restrict it to a
+// space they have access to. The secret to verify the deliveries with is only returned here.
+func (s *svc) handleCreate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	req := &createRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		handleError(w, r, errtypes.BadRequest("invalid body"))
+		return
+	}
+	if err := s.validateURL(r.Context(), req.URL); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	isAdmin, err := s.isAdmin(r.Context())
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if req.SpaceID == "" && !isAdmin {
+		handleError(w, r, errtypes.BadRequest("missing space_id"))
+		return
+	}
+	if req.SpaceID != "" {
+		if err := s.checkSpaceAccess(r.Context(), req.SpaceID); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	wh := &webhooks.Webhook{
+		ID:         uuid.New().String(),
+		Owner:      u.GetId().GetOpaqueId(),
+		URL:        req.URL,
+		Secret:     hex.EncodeToString(secret),
+		Events:     req.Events,
+		SpaceID:    req.SpaceID,
+		PathPrefix: req.PathPrefix,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.store.Put(ctx, wh); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, wh)
+}
+
+func (s *svc) handleGet(w http.ResponseWriter, r *http.Request) {
+	wh, ok := s.getWebhook(w, r)
+	if !ok {
+		return
+	}
+	wh.Secret = ""
+	writeJSON(w, r, http.StatusOK, wh)
+}
+
+func (s *svc) handleDelete(w http.ResponseWriter, r *http.Request) {
+	wh, ok := s.getWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.Delete(r.Context(), wh.ID); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *svc) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	wh, ok := s.getWebhook(w, r)
+	if !ok {
+		return
+	}
+	list, err := s.store.ListDeadLetters(r.Context(), wh.ID)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+// handleRedeliver queues a dead letter again
+func (s *svc) handleRedeliver(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.getDeadLetter(w, r); !ok {
+		return
+	}
+	if err := s.dispatcher.Redeliver(r.Context(), chi.URLParam(r, "delivery")); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *svc) handleDeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.getDeadLetter(w, r); !ok {
+		return
+	}
+	if err := s.store.DeleteDeadLetter(r.Context(), chi.URLParam(r, "delivery")); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhook returns the webhook of the request if the user owns it or may manage all webhooks
+func (s *svc) getWebhook(w http.ResponseWriter, r *http.Request) (*webhooks.Webhook, bool) {
+	u, ok := ctxpkg.ContextGetUser(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+	wh, err := s.store.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(w, r, err)
+		return nil, false
+	}
+	if wh.Owner != u.GetId().GetOpaqueId() {
+		isAdmin, err := s.isAdmin(r.Context())
+		if err != nil {
+			handleError(w, r, err)
+			return nil, false
+		}
+		if !isAdmin {
+			// do not leak the existence of other users' webhooks
+			w.WriteHeader(http.StatusNotFound)
+			return nil, false
+		}
+	}
+	return wh, true
+}
+
+func (s *svc) getDeadLetter(w http.ResponseWriter, r *http.Request) (*webhooks.Delivery, bool) {
+	wh, ok := s.getWebhook(w, r)
+	if !ok {
+		return nil, false
+	}
+	dl, err := s.store.GetDeadLetter(r.Context(), chi.URLParam(r, "delivery"))
+	if err != nil {
+		handleError(w, r, err)
+		return nil, false
+	}
+	if dl.WebhookID != wh.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	return dl, true
+}
+
+func (s *svc) validateURL(ctx context.Context, u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return errtypes.BadRequest("invalid url")
+	}
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if !s.conf.Insecure {
+			return errtypes.BadRequest("webhooks must use https")
+		}
+	default:
+		return errtypes.BadRequest("invalid url")
+	}
+	if !s.conf.AllowPrivate {
+		// the dispatcher checks the address again when connecting, the host may resolve differently by then
+		if err := webhooks.CheckURL(ctx, u); err != nil {
+			return errtypes.BadRequest(err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *svc) isAdmin(ctx context.Context) (bool, error) {
+	client, err := pool.GetGatewayServiceClient(s.conf.GatewaySvc)
+	if err != nil {
+		return false, err
+	}
+	return utils.CheckPermission(ctx, permission.ManageWebhooks, client)
+}
+
+// authorize makes sure the owner of the webhook may still receive its events. It is called by the
+// dispatcher before every delivery attempt.
+func (s *svc) authorize(ctx context.Context, w *webhooks.Webhook) error {
+	ctx, err := s.ownerContext(ctx, w.Owner)
+	if err != nil {
+		return err
+	}
+	if w.SpaceID != "" {
+		return s.checkSpaceAccess(ctx, w.SpaceID)
+	}
+	// webhooks without a space can only be created by admins
+	isAdmin, err := s.isAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return errtypes.PermissionDenied("webhook owner is no admin")
+	}
+	return nil
+}
+
+// ownerContext returns a context authenticated as the owner of a webhook
+func (s *svc) ownerContext(ctx context.Context, owner string) (context.Context, error) {
+	if s.conf.MachineAuthAPIKey == "" {
+		return nil, errtypes.NotSupported("machine auth not configured")
+	}
+	client, err := pool.GetGatewayServiceClient(s.conf.GatewaySvc)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Authenticate(ctx, &gateway.AuthenticateRequest{
+		Type:         "machine",
+		ClientId:     "userid:" + owner,
+		ClientSecret: s.conf.MachineAuthAPIKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		return nil, errtypes.NewErrtypeFromStatus(res.GetStatus())
+	}
+	ctx = ctxpkg.ContextSetUser(ctx, res.GetUser())
+	return grpcmetadata.AppendToOutgoingContext(ctx, ctxpkg.TokenHeader, res.GetToken()), nil
+}
+
+// checkSpaceAccess makes sure the user can access the space the webhook is restricted to
+func (s *svc) checkSpaceAccess(ctx context.Context, spaceID string) error {
+	rid, err := storagespace.ParseID(spaceID)
+	if err != nil || rid.GetSpaceId() == "" {
+		return errtypes.BadRequest("invalid space_id")
+	}
+	if rid.OpaqueId == "" {
+		rid.OpaqueId = rid.SpaceId
+	}
+	client, err := pool.GetGatewayServiceClient(s.conf.GatewaySvc)
+	if err != nil {
+		return err
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{Ref: &provider.Reference{ResourceId: &rid}})
+	if err != nil {
+		return err
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		return nil
+	case rpc.Code_CODE_NOT_FOUND, rpc.Code_CODE_PERMISSION_DENIED:
+		return errtypes.PermissionDenied(spaceID)
+	default:
+		return errors.New(res.GetStatus().GetMessage())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing response")
+	}
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case errtypes.NotFound:
+		status = http.StatusNotFound
+	case errtypes.BadRequest:
+		status = http.StatusBadRequest
+	case errtypes.PermissionDenied:
+		status = http.StatusForbidden
+	default:
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("webhooks: internal error")
+	}
+	w.WriteHeader(status)
+}
@@ -0,0 +1,624 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package wopi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	authpb "github.com/cs3org/go-cs3apis/cs3/auth/provider/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/opencloud-eu/reva/v2/internal/http/services/datagateway"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/auth/scope"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// WOPI request and response headers
+const (
+	HeaderOverride                  = "X-WOPI-Override"
+	HeaderLock                      = "X-WOPI-Lock"
+	HeaderOldLock                   = "X-WOPI-OldLock"
+	HeaderLockFailureReason         = "X-WOPI-LockFailureReason"
+	HeaderItemVersion               = "X-WOPI-ItemVersion"
+	HeaderSuggestedTarget           = "X-WOPI-SuggestedTarget"
+	HeaderRelativeTarget            = "X-WOPI-RelativeTarget"
+	HeaderOverwriteRelativeTarget   = "X-WOPI-OverwriteRelativeTarget"
+	HeaderRequestedName             = "X-WOPI-RequestedName"
+	HeaderInvalidFileNameError      = "X-WOPI-InvalidFileNameError"
+	HeaderValidRelativeTarget       = "X-WOPI-ValidRelativeTarget"
+	headerUploadLength              = "Upload-Length"
+	lockFailureReasonOtherApp       = "the file is locked by another application"
+	lockFailureReasonNotLocked      = "the file is not locked"
+	lockFailureReasonLockMismatched = "the lock does not match"
+)
+
+// FileInfo is the response of CheckFileInfo
+type FileInfo struct {
+	BaseFileName               string `json:"BaseFileName"`
+	OwnerID                    string `json:"OwnerId"`
+	Size                       int64  `json:"Size"`
+	UserID                     string `json:"UserId"`
+	UserFriendlyName           string `json:"UserFriendlyName,omitempty"`
+	Version                    string `json:"Version"`
+	LastModifiedTime           string `json:"LastModifiedTime,omitempty"`
+	ReadOnly                   bool   `json:"ReadOnly"`
+	UserCanWrite               bool   `json:"UserCanWrite"`
+	UserCanRename              bool   `json:"UserCanRename"`
+	UserCanNotWriteRelative    bool   `json:"UserCanNotWriteRelative"`
+	SupportsLocks              bool   `json:"SupportsLocks"`
+	SupportsGetLock            bool   `json:"SupportsGetLock"`
+	SupportsExtendedLockLength bool   `json:"SupportsExtendedLockLength"`
+	SupportsUpdate             bool   `json:"SupportsUpdate"`
+	SupportsRename             bool   `json:"SupportsRename"`
+}
+
+// handleCheckFileInfo returns information about the file and the permissions of the user
+func (s *svc) handleCheckFileInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	a := getAccess(ctx)
+
+	info, ok := s.stat(w, r, a.ref)
+	if !ok {
+		return
+	}
+
+	canWrite := a.editor && info.GetPermissionSet().GetInitiateFileUpload()
+	fi := FileInfo{
+		BaseFileName:               path.Base(info.GetPath()),
+		OwnerID:                    info.GetOwner().GetOpaqueId(),
+		Size:                       int64(info.GetSize()),
+		Version:                    strings.Trim(info.GetEtag(), `"`),
+		ReadOnly:                   !canWrite,
+		UserCanWrite:               canWrite,
+		UserCanRename:              canWrite && a.owner && info.GetPermissionSet().GetMove(),
+		UserCanNotWriteRelative:    !canWrite || !a.owner || s.conf.DisableRelativePuts,
+		SupportsLocks:              true,
+		SupportsGetLock:            true,
+		SupportsExtendedLockLength: true,
+		SupportsUpdate:             true,
+		SupportsRename:             true,
+	}
+	if info.GetName() != "" {
+		fi.BaseFileName = info.GetName()
+	}
+	if info.GetMtime() != nil {
+		fi.LastModifiedTime = utils.TSToTime(info.GetMtime()).UTC().Format(time.RFC3339Nano)
+	}
+	if u, ok := ctxpkg.ContextGetUser(ctx); ok {
+		fi.UserID = u.GetId().GetOpaqueId()
+		fi.UserFriendlyName = u.GetDisplayName()
+	}
+
+	writeJSON(w, r, fi)
+}
+
+// handleGetFile streams the content of the file
+func (s *svc) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+	a := getAccess(ctx)
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := client.InitiateFileDownload(ctx, &provider.InitiateFileDownloadRequest{Ref: a.ref})
+	if err != nil {
+		log.Error().Err(err).Msg("error initiating file download")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if res.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		writeStatus(w, r, res.GetStatus())
+		return
+	}
+
+	var ep, token string
+	for _, p := range res.GetProtocols() {
+		if p.GetProtocol() == "spaces" || (ep == "" && p.GetProtocol() == "simple") {
+			ep, token = p.GetDownloadEndpoint(), p.GetToken()
+		}
+	}
+
+	httpReq, err := rhttp.NewRequest(ctx, http.MethodGet, ep, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	httpReq.Header.Set(datagateway.TokenTransportHeader, token)
+	httpRes, err := s.client.Do(httpReq)
+	if err != nil {
+		log.Error().Err(err).Msg("error performing http request to the data gateway")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		w.WriteHeader(httpRes.StatusCode)
+		return
+	}
+	if etag := httpRes.Header.Get("Etag"); etag != "" {
+		w.Header().Set(HeaderItemVersion, strings.Trim(etag, `"`))
+	}
+	if l := httpRes.Header.Get("Content-Length"); l != "" {
+		w.Header().Set("Content-Length", l)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, httpRes.Body); err != nil {
+		log.Error().Err(err).Msg("error streaming file content")
+	}
+}
+
+// handlePutFile overwrites the content of the file. The file must be locked with the sent lock
+// unless it is empty.
+func (s *svc) handlePutFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+	a := getAccess(ctx)
+
+	if r.Header.Get(HeaderOverride) != "PUT" {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if !a.editor {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	lockID := r.Header.Get(HeaderLock)
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	if lock == nil {
+		info, ok := s.stat(w, r, a.ref)
+		if !ok {
+			return
+		}
+		if info.GetSize() != 0 {
+			s.writeLockConflict(w, nil, lockFailureReasonNotLocked)
+			return
+		}
+	} else if lock.GetLockId() != lockID {
+		s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+		return
+	}
+
+	etag, ok := s.upload(w, r, a.ref, lock.GetLockId(), r.Body, r.ContentLength)
+	if !ok {
+		return
+	}
+	if etag != "" {
+		w.Header().Set(HeaderItemVersion, strings.Trim(etag, `"`))
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Debug().Str("fileid", storagespace.FormatResourceID(a.ref.GetResourceId())).Msg("wopi: file updated")
+}
+
+// handlePutRelativeFile creates a new file next to the file, e.g. for 'save as'
+func (s *svc) handlePutRelativeFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+	a := getAccess(ctx)
+
+	suggested := r.Header.Get(HeaderSuggestedTarget)
+	relative := r.Header.Get(HeaderRelativeTarget)
+	// the access token is limited to the file, siblings are created on behalf of the user,
+	// which is only allowed if the file was opened with full access to the account
+	if !a.editor || !a.owner || s.conf.DisableRelativePuts || (suggested == "") == (relative == "") {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	info, ok := s.stat(w, r, a.ref)
+	if !ok {
+		return
+	}
+	uctx, err := s.userContext(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("error minting user token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ur := r.WithContext(uctx)
+	// the names are sanitized below, so the new file always is a child of the parent
+	if ok := s.checkParent(w, ur, info.GetParentId()); !ok {
+		return
+	}
+
+	var name string
+	if suggested != "" {
+		name = suggested
+		if strings.HasPrefix(suggested, ".") {
+			name = strings.TrimSuffix(info.GetName(), path.Ext(info.GetName())) + suggested
+		}
+		name = sanitizeName(name)
+		if !isValidName(name) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		name, ok = s.freeName(w, ur, info.GetParentId(), name)
+		if !ok {
+			return
+		}
+	} else {
+		name = relative
+		if !isValidName(name) {
+			w.Header().Set(HeaderValidRelativeTarget, sanitizeName(name))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ref := childRef(info.GetParentId(), name)
+		existing, ok := s.statOptional(w, ur, ref)
+		if !ok {
+			return
+		}
+		if existing != nil {
+			overwrite, _ := strconv.ParseBool(r.Header.Get(HeaderOverwriteRelativeTarget))
+			lock, ok := s.getLock(w, ur, ref)
+			if !ok {
+				return
+			}
+			if !overwrite || lock != nil {
+				w.Header().Set(HeaderValidRelativeTarget, "")
+				if lock != nil {
+					w.Header().Set(HeaderLock, lock.GetLockId())
+				}
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	ref := childRef(info.GetParentId(), name)
+	if _, ok := s.upload(w, ur, ref, "", r.Body, r.ContentLength); !ok {
+		return
+	}
+	created, ok := s.stat(w, ur, ref)
+	if !ok {
+		return
+	}
+
+	u, _ := ctxpkg.ContextGetUser(ctx)
+	scopes, err := scope.AddResourceInfoScope(created, authpb.Role_ROLE_OWNER, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tkn, err := s.tokenManager.MintToken(ctx, u, scopes)
+	if err != nil {
+		log.Error().Err(err).Msg("error minting access token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{
+		"Name": name,
+		"Url":  s.fileURL(r, created.GetId(), tkn),
+	})
+}
+
+// handleRenameFile renames the file while keeping its extension
+func (s *svc) handleRenameFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+	a := getAccess(ctx)
+
+	if !a.editor {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !a.owner {
+		w.Header().Set(HeaderInvalidFileNameError, "renaming is not allowed")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	requested := r.Header.Get(HeaderRequestedName)
+	if !isValidName(requested) {
+		w.Header().Set(HeaderInvalidFileNameError, "the requested name contains invalid characters")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	if lock != nil && lock.GetLockId() != r.Header.Get(HeaderLock) {
+		s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+		return
+	}
+
+	info, ok := s.stat(w, r, a.ref)
+	if !ok {
+		return
+	}
+	name := requested + path.Ext(info.GetName())
+
+	// the access token is limited to the file, the file is renamed on behalf of the user
+	uctx, err := s.userContext(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("error minting user token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if ok := s.checkParent(w, r.WithContext(uctx), info.GetParentId()); !ok {
+		return
+	}
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := client.Move(uctx, &provider.MoveRequest{
+		Source:      a.ref,
+		Destination: childRef(info.GetParentId(), name),
+		LockId:      lock.GetLockId(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error moving file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		writeJSON(w, r, map[string]string{"Name": requested})
+	case rpc.Code_CODE_ALREADY_EXISTS:
+		w.Header().Set(HeaderInvalidFileNameError, "a file with the requested name already exists")
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		writeStatus(w, r, res.GetStatus())
+	}
+}
+
+// userContext returns a context with a token of the user that is not limited to the file. It is only
+// used for requests on siblings of the file, the token never leaves the WOPI host.
+func (s *svc) userContext(ctx context.Context) (context.Context, error) {
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return nil, fmt.Errorf("no user in context")
+	}
+	scopes, err := scope.AddOwnerScope(nil)
+	if err != nil {
+		return nil, err
+	}
+	tkn, err := s.tokenManager.MintToken(ctx, u, scopes)
+	if err != nil {
+		return nil, err
+	}
+	ctx = ctxpkg.ContextSetToken(ctx, tkn)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(ctxpkg.TokenHeader, tkn)
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// checkParent verifies that the user of the request may create files in the parent folder
+func (s *svc) checkParent(w http.ResponseWriter, r *http.Request, parent *provider.ResourceId) bool {
+	info, ok := s.stat(w, r, &provider.Reference{ResourceId: parent})
+	if !ok {
+		return false
+	}
+	if !info.GetPermissionSet().GetInitiateFileUpload() {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// freeName returns the given name or, if it is taken, a name with an appended number
+func (s *svc) freeName(w http.ResponseWriter, r *http.Request, parent *provider.ResourceId, name string) (string, bool) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; i < 100; i++ {
+		existing, ok := s.statOptional(w, r, childRef(parent, name))
+		if !ok {
+			return "", false
+		}
+		if existing == nil {
+			return name, true
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	w.WriteHeader(http.StatusConflict)
+	return "", false
+}
+
+// upload writes the body to the given reference and returns the new etag
+func (s *svc) upload(w http.ResponseWriter, r *http.Request, ref *provider.Reference, lockID string, body io.Reader, length int64) (string, bool) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	if length < 0 {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return "", false
+		}
+		body, length = bytes.NewReader(b), int64(len(b))
+	}
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+	res, err := client.InitiateFileUpload(ctx, &provider.InitiateFileUploadRequest{
+		Ref:    ref,
+		LockId: lockID,
+		Opaque: utils.AppendPlainToOpaque(nil, headerUploadLength, strconv.FormatInt(length, 10)),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error initiating file upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+	if res.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		writeStatus(w, r, res.GetStatus())
+		return "", false
+	}
+	if length == 0 {
+		// the initiate file upload request already created the empty file
+		return "", true
+	}
+
+	var ep, token string
+	for _, p := range res.GetProtocols() {
+		if p.GetProtocol() == "simple" {
+			ep, token = p.GetUploadEndpoint(), p.GetToken()
+		}
+	}
+	httpReq, err := rhttp.NewRequest(ctx, http.MethodPut, ep, body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+	httpReq.Header.Set(datagateway.TokenTransportHeader, token)
+	httpReq.ContentLength = length
+	httpRes, err := s.client.Do(httpReq)
+	if err != nil {
+		log.Error().Err(err).Msg("error performing http request to the data gateway")
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK && httpRes.StatusCode != http.StatusCreated && httpRes.StatusCode != http.StatusNoContent {
+		log.Error().Int("status", httpRes.StatusCode).Msg("upload to the data gateway failed")
+		w.WriteHeader(httpRes.StatusCode)
+		return "", false
+	}
+	return httpRes.Header.Get("Etag"), true
+}
+
+func (s *svc) stat(w http.ResponseWriter, r *http.Request, ref *provider.Reference) (*provider.ResourceInfo, bool) {
+	info, ok := s.statOptional(w, r, ref)
+	if ok && info == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	return info, ok
+}
+
+// statOptional returns nil if the resource does not exist
+func (s *svc) statOptional(w http.ResponseWriter, r *http.Request, ref *provider.Reference) (*provider.ResourceInfo, bool) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{Ref: ref})
+	if err != nil {
+		log.Error().Err(err).Msg("error stating file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		return res.GetInfo(), true
+	case rpc.Code_CODE_NOT_FOUND:
+		return nil, true
+	default:
+		writeStatus(w, r, res.GetStatus())
+		return nil, false
+	}
+}
+
+// fileURL returns the WOPI url of a file including the access token
+func (s *svc) fileURL(r *http.Request, id *provider.ResourceId, tkn string) string {
+	base := s.conf.PublicURL
+	if base == "" {
+		scheme := "https"
+		if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+			scheme = "http"
+		}
+		base = scheme + "://" + r.Host + "/" + s.conf.Prefix
+	}
+	return strings.TrimSuffix(base, "/") + "/files/" + url.PathEscape(storagespace.FormatResourceID(id)) + "?access_token=" + url.QueryEscape(tkn)
+}
+
+func childRef(parent *provider.ResourceId, name string) *provider.Reference {
+	return &provider.Reference{ResourceId: parent, Path: utils.MakeRelativePath(name)}
+}
+
+const invalidNameChars = `/\:*?"<>|`
+
+func isValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, invalidNameChars)
+}
+
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(invalidNameChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// writeStatus writes the http status matching the CS3 status
+func writeStatus(w http.ResponseWriter, r *http.Request, st *rpc.Status) {
+	hsc := status.HTTPStatusFromCode(st.GetCode())
+	if st.GetCode() == rpc.Code_CODE_PERMISSION_DENIED || st.GetCode() == rpc.Code_CODE_UNAUTHENTICATED {
+		hsc = http.StatusUnauthorized
+	}
+	if hsc == http.StatusInternalServerError {
+		appctx.GetLogger(r.Context()).Error().Interface("status", st).Msg("wopi: unexpected status")
+	}
+	w.WriteHeader(hsc)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error marshalling response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("error writing JSON response")
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package wopi
+
+import (
+	"net/http"
+	"time"
+
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// WOPI locks are mapped onto CS3 write locks with the WOPI lock string as the lock id.
+
+// handleLock locks the file or refreshes the lock if it is already locked with the same lock
+func (s *svc) handleLock(w http.ResponseWriter, r *http.Request) {
+	a := getAccess(r.Context())
+	lockID := r.Header.Get(HeaderLock)
+	if !a.editor {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if lockID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	switch {
+	case lock == nil:
+		s.setLock(w, r, a.ref, lockID)
+	case lock.GetLockId() == lockID:
+		s.refreshLock(w, r, a.ref, lockID, "")
+	default:
+		s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+	}
+}
+
+// handleUnlockAndRelock replaces the existing lock with a new one
+func (s *svc) handleUnlockAndRelock(w http.ResponseWriter, r *http.Request) {
+	a := getAccess(r.Context())
+	lockID, oldLockID := r.Header.Get(HeaderLock), r.Header.Get(HeaderOldLock)
+	if !a.editor {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if lockID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	switch {
+	case lock == nil:
+		s.writeLockConflict(w, nil, lockFailureReasonNotLocked)
+	case lock.GetLockId() != oldLockID:
+		s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+	default:
+		s.refreshLock(w, r, a.ref, lockID, oldLockID)
+	}
+}
+
+// handleRefreshLock extends the expiration of the lock
+func (s *svc) handleRefreshLock(w http.ResponseWriter, r *http.Request) {
+	a := getAccess(r.Context())
+	lockID := r.Header.Get(HeaderLock)
+	if !a.editor {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	switch {
+	case lock == nil:
+		s.writeLockConflict(w, nil, lockFailureReasonNotLocked)
+	case lock.GetLockId() != lockID:
+		s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+	default:
+		s.refreshLock(w, r, a.ref, lockID, "")
+	}
+}
+
+// handleUnlock removes the lock
+func (s *svc) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+	a := getAccess(ctx)
+	lockID := r.Header.Get(HeaderLock)
+	if !a.editor {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	switch {
+	case lock == nil:
+		s.writeLockConflict(w, nil, lockFailureReasonNotLocked)
+		return
+	case lock.GetLockId() != lockID:
+		s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+		return
+	}
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := client.Unlock(ctx, &provider.UnlockRequest{Ref: a.ref, Lock: s.newLock(r, lockID)})
+	if err != nil {
+		log.Error().Err(err).Msg("error unlocking file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if res.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		writeStatus(w, r, res.GetStatus())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetLock returns the current lock in the X-WOPI-Lock header
+func (s *svc) handleGetLock(w http.ResponseWriter, r *http.Request) {
+	a := getAccess(r.Context())
+	lock, ok := s.getLock(w, r, a.ref)
+	if !ok {
+		return
+	}
+	w.Header().Set(HeaderLock, s.wopiLockID(lock))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *svc) newLock(r *http.Request, lockID string) *provider.Lock {
+	lock := &provider.Lock{
+		LockId:     lockID,
+		Type:       provider.LockType_LOCK_TYPE_WRITE,
+		AppName:    s.conf.AppName,
+		Expiration: utils.TimeToTS(time.Now().Add(time.Duration(s.conf.LockDuration) * time.Second)),
+	}
+	if u, ok := ctxpkg.ContextGetUser(r.Context()); ok {
+		lock.User = u.GetId()
+	}
+	return lock
+}
+
+func (s *svc) setLock(w http.ResponseWriter, r *http.Request, ref *provider.Reference, lockID string) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := client.SetLock(ctx, &provider.SetLockRequest{Ref: ref, Lock: s.newLock(r, lockID)})
+	if err != nil {
+		log.Error().Err(err).Msg("error locking file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		w.WriteHeader(http.StatusOK)
+	case rpc.Code_CODE_FAILED_PRECONDITION, rpc.Code_CODE_ABORTED:
+		// somebody else locked the file in the meantime
+		if lock, ok := s.getLock(w, r, ref); ok {
+			s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+		}
+	default:
+		writeStatus(w, r, res.GetStatus())
+	}
+}
+
+func (s *svc) refreshLock(w http.ResponseWriter, r *http.Request, ref *provider.Reference, lockID, existingLockID string) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := client.RefreshLock(ctx, &provider.RefreshLockRequest{
+		Ref:            ref,
+		Lock:           s.newLock(r, lockID),
+		ExistingLockId: existingLockID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error refreshing lock")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		w.WriteHeader(http.StatusOK)
+	case rpc.Code_CODE_FAILED_PRECONDITION, rpc.Code_CODE_ABORTED, rpc.Code_CODE_LOCKED:
+		if lock, ok := s.getLock(w, r, ref); ok {
+			s.writeLockConflict(w, lock, lockFailureReasonLockMismatched)
+		}
+	default:
+		writeStatus(w, r, res.GetStatus())
+	}
+}
+
+// getLock returns the current lock of the file or nil if it is not locked
+func (s *svc) getLock(w http.ResponseWriter, r *http.Request, ref *provider.Reference) (*provider.Lock, bool) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	client, err := s.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("error selecting next gateway client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	res, err := client.GetLock(ctx, &provider.GetLockRequest{Ref: ref})
+	if err != nil {
+		log.Error().Err(err).Msg("error getting lock")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	switch res.GetStatus().GetCode() {
+	case rpc.Code_CODE_OK:
+		lock := res.GetLock()
+		if lock.GetExpiration() != nil && utils.TSToTime(lock.GetExpiration()).Before(time.Now()) {
+			return nil, true
+		}
+		if lock.GetLockId() == "" {
+			return nil, true
+		}
+		return lock, true
+	case rpc.Code_CODE_NOT_FOUND:
+		return nil, true
+	default:
+		writeStatus(w, r, res.GetStatus())
+		return nil, false
+	}
+}
+
+// wopiLockID returns the lock id if the lock was set by a WOPI client
+func (s *svc) wopiLockID(lock *provider.Lock) string {
+	if lock == nil || (lock.GetAppName() != "" && lock.GetAppName() != s.conf.AppName) {
+		return ""
+	}
+	return lock.GetLockId()
+}
+
+func (s *svc) writeLockConflict(w http.ResponseWriter, lock *provider.Lock, reason string) {
+	if lock != nil && s.wopiLockID(lock) == "" {
+		reason = lockFailureReasonOtherApp
+	}
+	w.Header().Set(HeaderLock, s.wopiLockID(lock))
+	w.Header().Set(HeaderLockFailureReason, reason)
+	w.WriteHeader(http.StatusConflict)
+}
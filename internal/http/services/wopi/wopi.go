@@ -0,0 +1,269 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package wopi implements a WOPI host that serves files and locks to WOPI clients like
+// Collabora or OnlyOffice directly from the gateway.
+// See https://learn.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/
+package wopi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	authpb "github.com/cs3org/go-cs3apis/cs3/auth/provider/v1beta1"
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi/v5"
+	"github.com/mitchellh/mapstructure"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/global"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/token"
+	tokenmgr "github.com/opencloud-eu/reva/v2/pkg/token/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+func init() {
+	global.Register("wopi", New)
+}
+
+// Config holds the config options for the WOPI host HTTP service
+type Config struct {
+	Prefix              string                            `mapstructure:"prefix" docs:"wopi;The prefix to be used for this HTTP service"`
+	GatewaySvc          string                            `mapstructure:"gatewaysvc"`
+	PublicURL           string                            `mapstructure:"public_url" docs:";The URL under which WOPI clients reach this service, e.g. https://cloud.example.com/wopi. Defaults to the host of the request."`
+	AppName             string                            `mapstructure:"app_name" docs:"wopi;The app name used for the CS3 locks."`
+	LockDuration        int                               `mapstructure:"lock_duration" docs:"1800;The number of seconds a WOPI lock is valid."`
+	TokenManager        string                            `mapstructure:"token_manager" docs:"jwt;The token manager used to verify the access tokens."`
+	TokenManagers       map[string]map[string]interface{} `mapstructure:"token_managers"`
+	Insecure            bool                              `mapstructure:"insecure" docs:"false;Whether to skip certificate checks when talking to the data gateway."`
+	Timeout             int64                             `mapstructure:"timeout" docs:"0;The timeout for transfers to and from the data gateway in seconds."`
+	DisableRelativePuts bool                              `mapstructure:"disable_relative_puts" docs:"false;Disallow WOPI clients to create new files with PutRelativeFile."`
+}
+
+func (c *Config) init() {
+	if c.Prefix == "" {
+		c.Prefix = "wopi"
+	}
+	if c.AppName == "" {
+		c.AppName = "wopi"
+	}
+	if c.LockDuration == 0 {
+		c.LockDuration = 30 * 60
+	}
+	if c.TokenManager == "" {
+		c.TokenManager = "jwt"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+}
+
+type svc struct {
+	conf            *Config
+	router          *chi.Mux
+	gatewaySelector pool.Selectable[gateway.GatewayAPIClient]
+	tokenManager    token.Manager
+	client          *http.Client
+}
+
+// New returns a new WOPI host service
+func New(m map[string]interface{}, log *zerolog.Logger) (global.Service, error) {
+	conf := &Config{}
+	if err := mapstructure.Decode(m, conf); err != nil {
+		return nil, err
+	}
+	conf.init()
+
+	f, ok := tokenmgr.NewFuncs[conf.TokenManager]
+	if !ok {
+		return nil, fmt.Errorf("token manager not found: %s", conf.TokenManager)
+	}
+	tm, err := f(conf.TokenManagers[conf.TokenManager])
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := pool.GatewaySelector(conf.GatewaySvc)
+	if err != nil {
+		return nil, err
+	}
+
+	return newService(conf, selector, tm, log), nil
+}
+
+func newService(conf *Config, selector pool.Selectable[gateway.GatewayAPIClient], tm token.Manager, log *zerolog.Logger) *svc {
+	s := &svc{
+		conf:            conf,
+		router:          chi.NewRouter(),
+		gatewaySelector: selector,
+		tokenManager:    tm,
+		client: rhttp.GetHTTPClient(
+			rhttp.Timeout(time.Duration(conf.Timeout)*time.Second),
+			rhttp.Insecure(conf.Insecure),
+		),
+	}
+	s.routerInit(log)
+	return s
+}
+
+func (s *svc) routerInit(log *zerolog.Logger) {
+	s.router.Route("/files/{fileid}", func(r chi.Router) {
+		r.Use(s.authenticate)
+		r.Get("/", s.handleCheckFileInfo)
+		r.Post("/", s.handleFileOperation)
+		r.Get("/contents", s.handleGetFile)
+		r.Post("/contents", s.handlePutFile)
+	})
+
+	_ = chi.Walk(s.router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Debug().Str("service", "wopi").Str("method", method).Str("route", route).Int("middlewares", len(middlewares)).Msg("serving endpoint")
+		return nil
+	})
+}
+
+// Close performs cleanup.
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Prefix() string {
+	return s.conf.Prefix
+}
+
+// Unprotected returns all routes because WOPI clients authenticate with the access_token query parameter
+func (s *svc) Unprotected() []string {
+	return []string{"/"}
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+type accessKey struct{}
+
+// access describes what the access token of a request grants
+type access struct {
+	ref    *provider.Reference
+	token  string
+	editor bool
+	// owner is set if the user opened the file with full access to the account, only then
+	// files may be created or renamed next to it
+	owner bool
+}
+
+func getAccess(ctx context.Context) *access {
+	a, _ := ctx.Value(accessKey{}).(*access)
+	return a
+}
+
+// authenticate verifies that the access token was minted by the token manager for the requested file
+// and sets up the context so that all gateway calls are made on behalf of the user.
+func (s *svc) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := appctx.GetLogger(ctx)
+
+		tkn := r.URL.Query().Get("access_token")
+		if tkn == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		u, scopes, err := s.tokenManager.DismantleToken(ctx, tkn)
+		if err != nil {
+			log.Debug().Err(err).Msg("invalid wopi access token")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		id, err := storagespace.ParseID(chi.URLParam(r, "fileid"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		role, ok := roleFor(scopes, &id)
+		if !ok {
+			log.Debug().Str("fileid", chi.URLParam(r, "fileid")).Msg("wopi access token is not valid for the file")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx = ctxpkg.ContextSetUser(ctx, u)
+		ctx = ctxpkg.ContextSetToken(ctx, tkn)
+		ctx = metadata.AppendToOutgoingContext(ctx, ctxpkg.TokenHeader, tkn)
+		ctx = context.WithValue(ctx, accessKey{}, &access{
+			ref:    &provider.Reference{ResourceId: &id},
+			token:  tkn,
+			editor: isEditor(role),
+			owner:  role == authpb.Role_ROLE_OWNER,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func isEditor(role authpb.Role) bool {
+	return role == authpb.Role_ROLE_OWNER || role == authpb.Role_ROLE_EDITOR
+}
+
+// roleFor returns the role of the resourceinfo scope granting access to the given resource
+func roleFor(scopes map[string]*authpb.Scope, id *provider.ResourceId) (authpb.Role, bool) {
+	for k, scope := range scopes {
+		if !strings.HasPrefix(k, "resourceinfo") {
+			continue
+		}
+		var ri provider.ResourceInfo
+		if err := utils.UnmarshalJSONToProtoV1(scope.GetResource().GetValue(), &ri); err != nil {
+			continue
+		}
+		if ri.GetId().GetSpaceId() == id.GetSpaceId() && ri.GetId().GetOpaqueId() == id.GetOpaqueId() {
+			return scope.GetRole(), true
+		}
+	}
+	return authpb.Role_ROLE_INVALID, false
+}
+
+// handleFileOperation dispatches the POST requests on a file by their X-WOPI-Override header
+func (s *svc) handleFileOperation(w http.ResponseWriter, r *http.Request) {
+	switch r.Header.Get(HeaderOverride) {
+	case "LOCK":
+		if r.Header.Get(HeaderOldLock) != "" {
+			s.handleUnlockAndRelock(w, r)
+			return
+		}
+		s.handleLock(w, r)
+	case "UNLOCK":
+		s.handleUnlock(w, r)
+	case "REFRESH_LOCK":
+		s.handleRefreshLock(w, r)
+	case "GET_LOCK":
+		s.handleGetLock(w, r)
+	case "PUT_RELATIVE":
+		s.handlePutRelativeFile(w, r)
+	case "RENAME_FILE":
+		s.handleRenameFile(w, r)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
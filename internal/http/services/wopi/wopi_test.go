@@ -0,0 +1,301 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package wopi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	authpb "github.com/cs3org/go-cs3apis/cs3/auth/provider/v1beta1"
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/opencloud-eu/reva/v2/internal/http/services/datagateway"
+	"github.com/opencloud-eu/reva/v2/pkg/auth/scope"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/token/manager/jwt"
+	"github.com/opencloud-eu/reva/v2/tests/cs3mocks/mocks"
+)
+
+type selector struct {
+	client gateway.GatewayAPIClient
+}
+
+func (s selector) Next(opts ...pool.Option) (gateway.GatewayAPIClient, error) {
+	return s.client, nil
+}
+
+// stubClient is a minimal WOPI client
+type stubClient struct {
+	t      *testing.T
+	server *httptest.Server
+	fileID string
+	token  string
+}
+
+func (c stubClient) do(method, p string, headers map[string]string, body string) *http.Response {
+	req, err := http.NewRequest(method, c.server.URL+"/files/"+url.PathEscape(c.fileID)+p+"?access_token="+url.QueryEscape(c.token), strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+func TestWopiHost(t *testing.T) {
+	fileID := &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "file"}
+	otherID := &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "other"}
+	parentID := &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "parent"}
+	user := &userpb.User{Id: &userpb.UserId{OpaqueId: "einstein"}, DisplayName: "Albert Einstein"}
+
+	// the data gateway stores the uploaded content
+	content := "hello world"
+	data := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(datagateway.TokenTransportHeader) != "transfer-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = io.WriteString(w, content)
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			content = string(b)
+			w.Header().Set("Etag", `"new-etag"`)
+		}
+	}))
+	defer data.Close()
+
+	var lock *provider.Lock
+	client := &mocks.GatewayAPIClient{}
+	created := map[string]bool{}
+	client.On("Stat", mock.Anything, mock.Anything).Return(func(_ context.Context, req *provider.StatRequest, _ ...grpc.CallOption) *provider.StatResponse {
+		if req.GetRef().GetResourceId().GetOpaqueId() == parentID.OpaqueId && req.GetRef().GetPath() == "" {
+			return &provider.StatResponse{Status: status.NewOK(context.Background()), Info: &provider.ResourceInfo{
+				Id:            parentID,
+				PermissionSet: &provider.ResourcePermissions{InitiateFileUpload: true},
+			}}
+		}
+		if p := req.GetRef().GetPath(); p != "" && p != "./test.docx" {
+			if !created[p] {
+				return &provider.StatResponse{Status: status.NewNotFound(context.Background(), "not found")}
+			}
+			return &provider.StatResponse{Status: status.NewOK(context.Background()), Info: &provider.ResourceInfo{
+				Id:       otherID,
+				ParentId: parentID,
+				Name:     p[2:],
+			}}
+		}
+		return &provider.StatResponse{Status: status.NewOK(context.Background()), Info: &provider.ResourceInfo{
+			Id:            fileID,
+			ParentId:      parentID,
+			Name:          "test.docx",
+			Path:          "./test.docx",
+			Size:          uint64(len(content)),
+			Etag:          `"etag"`,
+			Owner:         user.Id,
+			PermissionSet: &provider.ResourcePermissions{InitiateFileUpload: true, Move: true},
+		}}
+	}, nil)
+	client.On("GetLock", mock.Anything, mock.Anything).Return(func(_ context.Context, _ *provider.GetLockRequest, _ ...grpc.CallOption) *provider.GetLockResponse {
+		return &provider.GetLockResponse{Status: status.NewOK(context.Background()), Lock: lock}
+	}, nil)
+	client.On("SetLock", mock.Anything, mock.Anything).Return(func(_ context.Context, req *provider.SetLockRequest, _ ...grpc.CallOption) *provider.SetLockResponse {
+		lock = req.Lock
+		return &provider.SetLockResponse{Status: status.NewOK(context.Background())}
+	}, nil)
+	client.On("RefreshLock", mock.Anything, mock.Anything).Return(func(_ context.Context, req *provider.RefreshLockRequest, _ ...grpc.CallOption) *provider.RefreshLockResponse {
+		lock = req.Lock
+		return &provider.RefreshLockResponse{Status: status.NewOK(context.Background())}
+	}, nil)
+	client.On("Unlock", mock.Anything, mock.Anything).Return(func(_ context.Context, _ *provider.UnlockRequest, _ ...grpc.CallOption) *provider.UnlockResponse {
+		lock = nil
+		return &provider.UnlockResponse{Status: status.NewOK(context.Background())}
+	}, nil)
+	client.On("InitiateFileDownload", mock.Anything, mock.Anything).Return(&gateway.InitiateFileDownloadResponse{
+		Status:    status.NewOK(context.Background()),
+		Protocols: []*gateway.FileDownloadProtocol{{Protocol: "spaces", DownloadEndpoint: data.URL, Token: "transfer-token"}},
+	}, nil)
+	var uploadLockID, uploadToken string
+	client.On("InitiateFileUpload", mock.Anything, mock.Anything).Return(func(ctx context.Context, req *provider.InitiateFileUploadRequest, _ ...grpc.CallOption) *gateway.InitiateFileUploadResponse {
+		uploadLockID = req.LockId
+		md, _ := metadata.FromOutgoingContext(ctx)
+		uploadToken = md.Get(ctxpkg.TokenHeader)[0]
+		created[req.GetRef().GetPath()] = true
+		return &gateway.InitiateFileUploadResponse{
+			Status:    status.NewOK(context.Background()),
+			Protocols: []*gateway.FileUploadProtocol{{Protocol: "simple", UploadEndpoint: data.URL, Token: "transfer-token"}},
+		}
+	}, nil)
+
+	var moved *provider.MoveRequest
+	client.On("Move", mock.Anything, mock.Anything).Return(func(ctx context.Context, req *provider.MoveRequest, _ ...grpc.CallOption) *provider.MoveResponse {
+		moved = req
+		md, _ := metadata.FromOutgoingContext(ctx)
+		uploadToken = md.Get(ctxpkg.TokenHeader)[0]
+		return &provider.MoveResponse{Status: status.NewOK(context.Background())}
+	}, nil)
+
+	tm, err := jwt.New(map[string]interface{}{"secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mint := func(id *provider.ResourceId, role authpb.Role) string {
+		scopes, err := scope.AddResourceInfoScope(&provider.ResourceInfo{Id: id, Path: "./test.docx"}, role, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tkn, err := tm.MintToken(context.Background(), user, scopes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tkn
+	}
+
+	conf := &Config{}
+	conf.init()
+	log := zerolog.Nop()
+	server := httptest.NewServer(newService(conf, selector{client: client}, tm, &log).Handler())
+	defer server.Close()
+
+	wopi := stubClient{t: t, server: server, fileID: storagespace.FormatResourceID(fileID), token: mint(fileID, authpb.Role_ROLE_EDITOR)}
+
+	// CheckFileInfo
+	res := wopi.do(http.MethodGet, "", nil, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("CheckFileInfo: expected 200, got %d", res.StatusCode)
+	}
+	var fi FileInfo
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if fi.BaseFileName != "test.docx" || fi.Size != int64(len(content)) || !fi.UserCanWrite || fi.UserID != "einstein" || fi.Version != "etag" {
+		t.Errorf("CheckFileInfo: unexpected response %+v", fi)
+	}
+
+	// GetFile
+	res = wopi.do(http.MethodGet, "/contents", nil, "")
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(b) != "hello world" {
+		t.Errorf("GetFile: expected the file content, got %d %q", res.StatusCode, b)
+	}
+
+	// PutFile on a non empty file needs a lock
+	res = wopi.do(http.MethodPost, "/contents", map[string]string{HeaderOverride: "PUT"}, "changed")
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("PutFile: expected 409 without lock, got %d", res.StatusCode)
+	}
+
+	// Lock, GetLock and a conflicting Lock
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "LOCK", HeaderLock: "lock-1"}, ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("Lock: expected 200, got %d", res.StatusCode)
+	}
+	if lock.GetLockId() != "lock-1" || lock.GetType() != provider.LockType_LOCK_TYPE_WRITE || lock.GetAppName() != "wopi" {
+		t.Errorf("Lock: unexpected CS3 lock %+v", lock)
+	}
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "GET_LOCK"}, ""); res.Header.Get(HeaderLock) != "lock-1" {
+		t.Errorf("GetLock: expected lock-1, got %q", res.Header.Get(HeaderLock))
+	}
+	res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "LOCK", HeaderLock: "lock-2"}, "")
+	if res.StatusCode != http.StatusConflict || res.Header.Get(HeaderLock) != "lock-1" {
+		t.Errorf("Lock: expected a conflict with lock-1, got %d %q", res.StatusCode, res.Header.Get(HeaderLock))
+	}
+
+	// UnlockAndRelock
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "LOCK", HeaderLock: "lock-2", HeaderOldLock: "lock-1"}, ""); res.StatusCode != http.StatusOK || lock.GetLockId() != "lock-2" {
+		t.Errorf("UnlockAndRelock: expected lock-2, got %d %q", res.StatusCode, lock.GetLockId())
+	}
+
+	// PutFile with the lock
+	res = wopi.do(http.MethodPost, "/contents", map[string]string{HeaderOverride: "PUT", HeaderLock: "lock-2"}, "changed")
+	if res.StatusCode != http.StatusOK || content != "changed" || uploadLockID != "lock-2" || res.Header.Get(HeaderItemVersion) != "new-etag" {
+		t.Errorf("PutFile: expected the content to be updated, got %d %q %q", res.StatusCode, content, uploadLockID)
+	}
+
+	// Unlock with a wrong lock, then the right one
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "UNLOCK", HeaderLock: "lock-1"}, ""); res.StatusCode != http.StatusConflict {
+		t.Errorf("Unlock: expected 409, got %d", res.StatusCode)
+	}
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "UNLOCK", HeaderLock: "lock-2"}, ""); res.StatusCode != http.StatusOK || lock != nil {
+		t.Errorf("Unlock: expected the file to be unlocked, got %d", res.StatusCode)
+	}
+
+	// PutRelativeFile and RenameFile need a session with full access to the account
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "PUT_RELATIVE", HeaderSuggestedTarget: ".pdf"}, "pdf"); res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("PutRelativeFile: expected 501 for an editor session, got %d", res.StatusCode)
+	}
+	if res = wopi.do(http.MethodPost, "", map[string]string{HeaderOverride: "RENAME_FILE", HeaderRequestedName: "renamed"}, ""); res.StatusCode != http.StatusBadRequest || moved != nil {
+		t.Errorf("RenameFile: expected 400 for an editor session, got %d", res.StatusCode)
+	}
+	owner := stubClient{t: t, server: server, fileID: wopi.fileID, token: mint(fileID, authpb.Role_ROLE_OWNER)}
+	res = owner.do(http.MethodPost, "", map[string]string{HeaderOverride: "PUT_RELATIVE", HeaderSuggestedTarget: "x/../../escaped.pdf"}, "pdf")
+	var rel map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&rel); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || rel["Name"] != "x_.._.._escaped.pdf" || !created["./x_.._.._escaped.pdf"] {
+		t.Errorf("PutRelativeFile: expected the suggested target to be sanitized, got %d %+v", res.StatusCode, rel)
+	}
+	if uploadToken == owner.token {
+		t.Error("PutRelativeFile: expected the sibling to be created with a user token, not the access token")
+	}
+	res = owner.do(http.MethodPost, "", map[string]string{HeaderOverride: "RENAME_FILE", HeaderRequestedName: "renamed"}, "")
+	if res.StatusCode != http.StatusOK || moved.GetDestination().GetPath() != "./renamed.docx" || moved.GetDestination().GetResourceId().GetOpaqueId() != parentID.OpaqueId || uploadToken == owner.token {
+		t.Errorf("RenameFile: expected the file to be renamed in its folder with a user token, got %d %+v", res.StatusCode, moved)
+	}
+
+	// viewers can not lock
+	viewer := stubClient{t: t, server: server, fileID: wopi.fileID, token: mint(fileID, authpb.Role_ROLE_VIEWER)}
+	if res = viewer.do(http.MethodPost, "", map[string]string{HeaderOverride: "LOCK", HeaderLock: "lock-3"}, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Lock: expected viewers to be rejected, got %d", res.StatusCode)
+	}
+
+	// tokens are only valid for their file
+	other := stubClient{t: t, server: server, fileID: wopi.fileID, token: mint(otherID, authpb.Role_ROLE_EDITOR)}
+	if res = other.do(http.MethodGet, "", nil, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("CheckFileInfo: expected a token for another file to be rejected, got %d", res.StatusCode)
+	}
+	invalid := stubClient{t: t, server: server, fileID: wopi.fileID, token: "invalid"}
+	if res = invalid.do(http.MethodGet, "", nil, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("CheckFileInfo: expected an invalid token to be rejected, got %d", res.StatusCode)
+	}
+}
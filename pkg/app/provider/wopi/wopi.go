@@ -35,6 +35,7 @@ import (
 	"github.com/beevik/etree"
 	appprovider "github.com/cs3org/go-cs3apis/cs3/app/provider/v1beta1"
 	appregistry "github.com/cs3org/go-cs3apis/cs3/app/registry/v1beta1"
+	authpb "github.com/cs3org/go-cs3apis/cs3/auth/provider/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/golang-jwt/jwt/v5"
@@ -42,6 +43,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/app"
 	"github.com/opencloud-eu/reva/v2/pkg/app/provider/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/auth/scope"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/mime"
@@ -49,6 +51,8 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/templates"
 	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/token"
+	tokenmgr "github.com/opencloud-eu/reva/v2/pkg/token/manager/registry"
 	"github.com/pkg/errors"
 )
 
@@ -57,19 +61,22 @@ func init() {
 }
 
 type config struct {
-	IOPSecret                 string `mapstructure:"iop_secret" docs:";The IOP secret used to connect to the wopiserver."`
-	WopiURL                   string `mapstructure:"wopi_url" docs:";The wopiserver's URL."`
-	WopiFolderURLBaseURL      string `mapstructure:"wopi_folder_url_base_url" docs:";The base URL to generate links to navigate back to the containing folder."`
-	WopiFolderURLPathTemplate string `mapstructure:"wopi_folder_url_path_template" docs:";The template to generate the folderurl path segments."`
-	AppName                   string `mapstructure:"app_name" docs:";The App user-friendly name."`
-	AppIconURI                string `mapstructure:"app_icon_uri" docs:";A URI to a static asset which represents the app icon."`
-	AppURL                    string `mapstructure:"app_url" docs:";The App URL."`
-	AppIntURL                 string `mapstructure:"app_int_url" docs:";The internal app URL in case of dockerized deployments. Defaults to AppURL"`
-	AppAPIKey                 string `mapstructure:"app_api_key" docs:";The API key used by the app, if applicable."`
-	JWTSecret                 string `mapstructure:"jwt_secret" docs:";The JWT secret to be used to retrieve the token TTL."`
-	AppDesktopOnly            bool   `mapstructure:"app_desktop_only" docs:"false;Specifies if the app can be opened only on desktop."`
-	InsecureConnections       bool   `mapstructure:"insecure_connections"`
-	AppDisableChat            bool   `mapstructure:"app_disable_chat"`
+	IOPSecret                 string                            `mapstructure:"iop_secret" docs:";The IOP secret used to connect to the wopiserver."`
+	WopiURL                   string                            `mapstructure:"wopi_url" docs:";The wopiserver's URL."`
+	WopiFolderURLBaseURL      string                            `mapstructure:"wopi_folder_url_base_url" docs:";The base URL to generate links to navigate back to the containing folder."`
+	WopiFolderURLPathTemplate string                            `mapstructure:"wopi_folder_url_path_template" docs:";The template to generate the folderurl path segments."`
+	AppName                   string                            `mapstructure:"app_name" docs:";The App user-friendly name."`
+	AppIconURI                string                            `mapstructure:"app_icon_uri" docs:";A URI to a static asset which represents the app icon."`
+	AppURL                    string                            `mapstructure:"app_url" docs:";The App URL."`
+	AppIntURL                 string                            `mapstructure:"app_int_url" docs:";The internal app URL in case of dockerized deployments. Defaults to AppURL"`
+	AppAPIKey                 string                            `mapstructure:"app_api_key" docs:";The API key used by the app, if applicable."`
+	JWTSecret                 string                            `mapstructure:"jwt_secret" docs:";The JWT secret to be used to retrieve the token TTL."`
+	AppDesktopOnly            bool                              `mapstructure:"app_desktop_only" docs:"false;Specifies if the app can be opened only on desktop."`
+	InsecureConnections       bool                              `mapstructure:"insecure_connections"`
+	AppDisableChat            bool                              `mapstructure:"app_disable_chat"`
+	WopiHostURL               string                            `mapstructure:"wopi_host_url" docs:";The URL of the built-in WOPI host service, e.g. https://cloud.example.com/wopi. If set, no wopiserver is needed."`
+	TokenManager              string                            `mapstructure:"token_manager" docs:"jwt;The token manager used to sign the access tokens for the built-in WOPI host."`
+	TokenManagers             map[string]map[string]interface{} `mapstructure:"token_managers"`
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -81,9 +88,10 @@ func parseConfig(m map[string]interface{}) (*config, error) {
 }
 
 type wopiProvider struct {
-	conf       *config
-	wopiClient *http.Client
-	appURLs    map[string]map[string]string // map[viewMode]map[extension]appURL
+	conf         *config
+	wopiClient   *http.Client
+	appURLs      map[string]map[string]string // map[viewMode]map[extension]appURL
+	tokenManager token.Manager
 }
 
 // New returns an implementation of the app.Provider interface that
@@ -115,14 +123,33 @@ func New(m map[string]interface{}) (app.Provider, error) {
 		return http.ErrUseLastResponse
 	}
 
-	return &wopiProvider{
+	p := &wopiProvider{
 		conf:       c,
 		wopiClient: wopiClient,
 		appURLs:    appURLs,
-	}, nil
+	}
+
+	if c.WopiHostURL != "" {
+		if c.TokenManager == "" {
+			c.TokenManager = "jwt"
+		}
+		f, ok := tokenmgr.NewFuncs[c.TokenManager]
+		if !ok {
+			return nil, fmt.Errorf("wopi: token manager not found: %s", c.TokenManager)
+		}
+		p.tokenManager, err = f(c.TokenManagers[c.TokenManager])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
 }
 
 func (p *wopiProvider) GetAppURL(ctx context.Context, resource *provider.ResourceInfo, viewMode appprovider.ViewMode, token, language string) (*appprovider.OpenInAppURL, error) {
+	if p.conf.WopiHostURL != "" {
+		return p.getBuiltinAppURL(ctx, resource, viewMode, language)
+	}
+
 	log := appctx.GetLogger(ctx)
 
 	ext := path.Ext(resource.Path)
@@ -253,15 +280,7 @@ func (p *wopiProvider) GetAppURL(ctx context.Context, resource *provider.Resourc
 	}
 
 	urlQuery := url.Query()
-	if language != "" {
-		urlQuery.Set("ui", language)                  // OnlyOffice
-		urlQuery.Set("lang", covertLangTag(language)) // Collabora, Impact on the default document language of OnlyOffice
-		urlQuery.Set("UI_LLCC", language)             // Office365
-	}
-	if p.conf.AppDisableChat {
-		urlQuery.Set("dchat", "1") // OnlyOffice disable chat
-	}
-
+	p.addAppParams(urlQuery, language)
 	url.RawQuery = urlQuery.Encode()
 	appFullURL := url.String()
 
@@ -287,6 +306,104 @@ func (p *wopiProvider) GetAppURL(ctx context.Context, resource *provider.Resourc
 	}, nil
 }
 
+// getBuiltinAppURL returns the app url pointing the app to the built-in WOPI host.
+// The access token is minted by the token manager and limited to the resource.
+func (p *wopiProvider) getBuiltinAppURL(ctx context.Context, resource *provider.ResourceInfo, viewMode appprovider.ViewMode, language string) (*appprovider.OpenInAppURL, error) {
+	log := appctx.GetLogger(ctx)
+
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return nil, errtypes.UserRequired("wopi: user not found in context")
+	}
+
+	ext := path.Ext(resource.Path)
+	role := authpb.Role_ROLE_VIEWER
+	var appURL string
+	if viewMode == appprovider.ViewMode_VIEW_MODE_READ_WRITE {
+		access := "edit"
+		if resource.GetSize() == 0 {
+			if _, ok := p.appURLs["editnew"][ext]; ok {
+				access = "editnew"
+			}
+		}
+		if appURL = p.appURLs[access][ext]; appURL != "" {
+			role = authpb.Role_ROLE_EDITOR
+			if isOwnerSession(ctx) {
+				// the token stays limited to the file, the owner role only tells the WOPI host that
+				// the user has full access to the account and may create and rename files next to it
+				role = authpb.Role_ROLE_OWNER
+			}
+		}
+	}
+	if appURL == "" {
+		appURL = p.appURLs["view"][ext]
+	}
+	if appURL == "" {
+		return nil, errors.New("wopi: neither edit nor view app url found")
+	}
+
+	scopes, err := scope.AddResourceInfoScope(resource, role, nil)
+	if err != nil {
+		return nil, err
+	}
+	tkn, err := p.tokenManager.MintToken(ctx, u, scopes)
+	if err != nil {
+		return nil, errors.Wrap(err, "wopi: error minting access token")
+	}
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tkn, claims); err != nil || claims.ExpiresAt == nil {
+		return nil, errtypes.InternalError("wopi: can not determine the expiration of the access token")
+	}
+
+	wopiSrc := strings.TrimSuffix(p.conf.WopiHostURL, "/") + "/files/" + url.PathEscape(storagespace.FormatResourceID(resource.GetId()))
+	fullURL, err := url.Parse(appURL)
+	if err != nil {
+		return nil, err
+	}
+	q := fullURL.Query()
+	q.Set("WOPISrc", wopiSrc)
+	p.addAppParams(q, language)
+	fullURL.RawQuery = q.Encode()
+
+	log.Info().Msg(fmt.Sprintf("wopi: returning app URL %s", fullURL.String()))
+	return &appprovider.OpenInAppURL{
+		AppUrl: fullURL.String(),
+		Method: "POST",
+		FormParameters: map[string]string{
+			"access_token": tkn,
+			// milliseconds since Jan 1, 1970 UTC
+			"access_token_ttl": strconv.FormatInt(claims.ExpiresAt.Unix()*1000, 10),
+		},
+	}, nil
+}
+
+// isOwnerSession returns true if the request was made with a token that is not limited to
+// some resources, e.g. by a public link or a share.
+func isOwnerSession(ctx context.Context) bool {
+	scopes, ok := ctxpkg.ContextGetScopes(ctx)
+	if !ok || len(scopes) == 0 {
+		return false
+	}
+	for k := range scopes {
+		if k != "user" {
+			return false
+		}
+	}
+	return true
+}
+
+// addAppParams adds the language and feature parameters understood by the different apps
+func (p *wopiProvider) addAppParams(q url.Values, language string) {
+	if language != "" {
+		q.Set("ui", language)                  // OnlyOffice
+		q.Set("lang", covertLangTag(language)) // Collabora, Impact on the default document language of OnlyOffice
+		q.Set("UI_LLCC", language)             // Office365
+	}
+	if p.conf.AppDisableChat {
+		q.Set("dchat", "1") // OnlyOffice disable chat
+	}
+}
+
 func (p *wopiProvider) GetAppProviderInfo(ctx context.Context) (*appregistry.ProviderInfo, error) {
 	// Initially we store the mime types in a map to avoid duplicates
 	mimeTypesMap := make(map[string]bool)
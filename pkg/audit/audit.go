@@ -0,0 +1,166 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package audit turns events into hash-chained audit records and persists them.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+)
+
+// OutcomeSuccess is the outcome of records for events that do not carry an outcome
+const OutcomeSuccess = "success"
+
+// Record is a normalised audit log entry
+type Record struct {
+	Seq          uint64    `json:"seq"`
+	Time         time.Time `json:"time"`
+	EventID      string    `json:"event_id"`
+	Action       string    `json:"action"`
+	Actor        string    `json:"actor,omitempty"`
+	Impersonator string    `json:"impersonator,omitempty"`
+	ResourceID   string    `json:"resource_id,omitempty"`
+	ResourcePath string    `json:"resource_path,omitempty"`
+	Outcome      string    `json:"outcome"`
+	IP           string    `json:"ip,omitempty"`
+	PrevHash     string    `json:"prev_hash"`
+	Hash         string    `json:"hash"`
+}
+
+// ComputeHash returns the hash of the record chained to the hash of the previous record
+func (r Record) ComputeHash() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r) // a record can always be marshalled
+	sum := sha256.Sum256(append([]byte(r.PrevHash), b...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Normalize converts an event as returned by events.ConsumeAll into an audit record.
+// It returns false if the event can not be read.
+func Normalize(ev events.Event) (Record, bool) {
+	payload, ok := ev.Event.([]byte)
+	if !ok {
+		b, err := json.Marshal(ev.Event)
+		if err != nil {
+			return Record{}, false
+		}
+		payload = b
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return Record{}, false
+	}
+
+	r := Record{
+		Time:         timestamp(fields),
+		EventID:      ev.ID,
+		Action:       strings.TrimPrefix(ev.Type, "events."),
+		Actor:        userID(fields, "Executant", "ExecutingUser", "Sharer", "Creator"),
+		Impersonator: userID(fields, "ImpersonatingUser"),
+		Outcome:      OutcomeSuccess,
+		IP:           ev.ClientIP,
+	}
+	if r.Actor == "" {
+		r.Actor = ev.InitiatorID
+	}
+
+	var ref provider.Reference
+	if decode(fields, "Ref", &ref) {
+		r.ResourcePath = ref.GetPath()
+		if ref.GetResourceId() != nil {
+			r.ResourceID = storagespace.FormatResourceID(ref.GetResourceId())
+		}
+	}
+	if r.ResourceID == "" {
+		for _, name := range []string{"ItemID", "ResourceID", "ID"} {
+			var rid provider.ResourceId
+			if decode(fields, name, &rid) && rid.GetOpaqueId() != "" {
+				r.ResourceID = storagespace.FormatResourceID(&rid)
+				break
+			}
+		}
+	}
+	if r.ResourcePath == "" {
+		var p string
+		if decode(fields, "Path", &p) || decode(fields, "Filename", &p) {
+			r.ResourcePath = p
+		}
+	}
+
+	var outcome string
+	if decode(fields, "Outcome", &outcome) && outcome != "" {
+		r.Outcome = outcome
+	}
+	var failed bool
+	if decode(fields, "Failed", &failed) && failed {
+		r.Outcome = "failure"
+	}
+	return r, true
+}
+
+func decode(fields map[string]json.RawMessage, name string, v interface{}) bool {
+	raw, ok := fields[name]
+	if !ok || string(raw) == "null" {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// userID reads the opaque id of the first set field, which may hold a user or a user id
+func userID(fields map[string]json.RawMessage, names ...string) string {
+	for _, name := range names {
+		var u userpb.User
+		if decode(fields, name, &u) && u.GetId().GetOpaqueId() != "" {
+			return u.GetId().GetOpaqueId()
+		}
+		var id userpb.UserId
+		if decode(fields, name, &id) && id.GetOpaqueId() != "" {
+			return id.GetOpaqueId()
+		}
+		var s string
+		if decode(fields, name, &s) && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// timestamp reads the time of the event, which may be a cs3 or a go timestamp
+func timestamp(fields map[string]json.RawMessage) time.Time {
+	for _, name := range []string{"Timestamp", "CTime", "MTime"} {
+		var ts types.Timestamp
+		if decode(fields, name, &ts) && ts.GetSeconds() != 0 {
+			return time.Unix(int64(ts.GetSeconds()), int64(ts.GetNanos())).UTC()
+		}
+		var t time.Time
+		if decode(fields, name, &t) && !t.IsZero() {
+			return t.UTC()
+		}
+	}
+	return time.Now().UTC()
+}
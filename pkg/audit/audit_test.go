@@ -0,0 +1,148 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+)
+
+func TestNormalize(t *testing.T) {
+	payload, err := json.Marshal(events.ItemTrashed{
+		Executant:         &userpb.UserId{OpaqueId: "admin"},
+		ImpersonatingUser: &userpb.User{Id: &userpb.UserId{OpaqueId: "einstein"}},
+		Ref: &provider.Reference{
+			ResourceId: &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "node"},
+			Path:       "./file.txt",
+		},
+		Timestamp: &types.Timestamp{Seconds: 1700000000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := Normalize(events.Event{Type: "events.ItemTrashed", ID: "event-1", ClientIP: "10.0.0.1", Event: payload})
+	if !ok {
+		t.Fatal("expected event to be normalised")
+	}
+	expected := Record{
+		Time:         time.Unix(1700000000, 0).UTC(),
+		EventID:      "event-1",
+		Action:       "ItemTrashed",
+		Actor:        "admin",
+		Impersonator: "einstein",
+		ResourceID:   "storage$space!node",
+		ResourcePath: "./file.txt",
+		Outcome:      OutcomeSuccess,
+		IP:           "10.0.0.1",
+	}
+	if r != expected {
+		t.Errorf("expected %+v, got %+v", expected, r)
+	}
+
+	if _, ok := Normalize(events.Event{Type: "events.Broken", Event: []byte("not json")}); ok {
+		t.Error("expected broken event to be skipped")
+	}
+}
+
+func TestFileLog(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLog(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC()
+	for i, user := range []string{"einstein", "marie", "einstein", "richard", "einstein"} {
+		if _, err := l.Append(Record{Time: start.Add(time.Duration(i) * time.Minute), Action: "FileUploaded", Actor: user, ResourcePath: "/users/" + user + "/file.txt"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, filePattern))
+	if len(files) < 2 {
+		t.Errorf("expected the log to be rotated, got %d files", len(files))
+	}
+	for i, f := range files {
+		if fileNum(f) != uint64(i+1) {
+			t.Errorf("expected %s to be file %d", f, i+1)
+		}
+	}
+
+	// the chain continues after a restart
+	l, err = NewLog(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := l.Append(Record{Time: start, Action: "FileDownloaded", Actor: "marie"}); err != nil || r.Seq != 6 {
+		t.Fatalf("expected record 6, got %d, %v", r.Seq, err)
+	}
+
+	all, err := sink.Search(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 {
+		t.Fatalf("expected 6 records, got %d", len(all))
+	}
+	if err := Verify(all); err != nil {
+		t.Errorf("expected a valid chain, got %v", err)
+	}
+
+	res, _ := sink.Search(Query{User: "einstein", Since: start.Add(time.Minute)})
+	if len(res) != 2 {
+		t.Errorf("expected 2 records, got %d", len(res))
+	}
+	res, _ = sink.Search(Query{Resource: "/users/marie"})
+	if len(res) != 1 {
+		t.Errorf("expected 1 record, got %d", len(res))
+	}
+
+	// tamper with a record
+	all[2].Actor = "mallory"
+	if err := Verify(all); err == nil {
+		t.Error("expected a modified record to be detected")
+	}
+	all[2].Hash = all[2].ComputeHash()
+	if err := Verify(all); err == nil {
+		t.Error("expected a broken chain to be detected")
+	}
+	if err := Verify(append(all[:1:1], all[2:]...)); err == nil {
+		t.Error("expected a removed record to be detected")
+	}
+	all[2].Actor = "einstein"
+	all[2].Hash = all[2].ComputeHash()
+	if err := Verify(all[1:]); err == nil {
+		t.Error("expected removed records at the head to be detected")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const filePattern = "audit-*.jsonl"
+
+// FileSink writes one json record per line into files that are rotated when they exceed a maximum size.
+// The files are numbered, so they sort in the order they were written.
+type FileSink struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// num is the number of the current file
+	num uint64
+}
+
+// NewFileSink returns a new sink writing to the given directory. A maxSize of 0 disables the rotation.
+func NewFileSink(dir string, maxSize int64) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSink{dir: dir, maxSize: maxSize}, nil
+}
+
+// files returns the audit log files, oldest first
+func (s *FileSink) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, filePattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// fileNum returns the number of the file, 0 if it has none
+func fileNum(name string) uint64 {
+	num, _, _ := strings.Cut(strings.TrimPrefix(filepath.Base(name), "audit-"), "-")
+	n, _ := strconv.ParseUint(num, 10, 64)
+	return n
+}
+
+func (s *FileSink) open() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var name string
+	if len(files) > 0 {
+		name = files[len(files)-1]
+		s.num = fileNum(name)
+	}
+	if name != "" {
+		if info, err := os.Stat(name); err == nil && (s.maxSize == 0 || info.Size() < s.maxSize) {
+			s.size = info.Size()
+		} else {
+			name = ""
+		}
+	}
+	if name == "" {
+		s.num++
+		name = filepath.Join(s.dir, fmt.Sprintf("audit-%010d-%s.jsonl", s.num, time.Now().UTC().Format("20060102T150405")))
+		s.size = 0
+	}
+	s.f, err = os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// Write appends the record to the current file
+func (s *FileSink) Write(r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil && s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.f.Close(); err != nil {
+			return err
+		}
+		s.f = nil
+	}
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Last returns the last record of the newest file
+func (s *FileSink) Last() (*Record, error) {
+	files, err := s.files()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	b, err := os.ReadFile(files[len(files)-1])
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return nil, nil
+	}
+	r := &Record{}
+	if err := json.Unmarshal(last, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Search returns the records matching the query
+func (s *FileSink) Search(q Query) ([]Record, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				f.Close()
+				return nil, err
+			}
+			if !q.Matches(r) {
+				continue
+			}
+			records = append(records, r)
+			if q.Limit > 0 && len(records) >= q.Limit {
+				f.Close()
+				return records, nil
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Close closes the current file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,144 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package audit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Sink persists audit records
+type Sink interface {
+	// Write persists the record
+	Write(Record) error
+	// Last returns the last persisted record to continue the hash chain with. It returns nil if there is none.
+	Last() (*Record, error)
+}
+
+// Query filters audit records. Empty fields match all records.
+type Query struct {
+	// User matches the actor or the impersonator
+	User string
+	// Resource matches the resource id or a prefix of the resource path
+	Resource string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Matches returns true if the record matches the query
+func (q Query) Matches(r Record) bool {
+	if q.User != "" && r.Actor != q.User && r.Impersonator != q.User {
+		return false
+	}
+	if q.Resource != "" && r.ResourceID != q.Resource && !hasPathPrefix(r.ResourcePath, q.Resource) {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && r.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+func hasPathPrefix(p, prefix string) bool {
+	if p == "" {
+		return false
+	}
+	if p == prefix {
+		return true
+	}
+	if prefix[len(prefix)-1] != '/' {
+		prefix += "/"
+	}
+	return len(p) > len(prefix) && p[:len(prefix)] == prefix
+}
+
+// Searcher is implemented by sinks that can be queried
+type Searcher interface {
+	// Search returns the records matching the query in the order they were written
+	Search(Query) ([]Record, error)
+}
+
+// Log chains records and writes them to a sink
+type Log struct {
+	sink Sink
+
+	mu       sync.Mutex
+	seq      uint64
+	lastHash string
+}
+
+// NewLog returns a new log writing to the given sink, continuing its hash chain
+func NewLog(sink Sink) (*Log, error) {
+	l := &Log{sink: sink}
+	last, err := sink.Last()
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		l.seq, l.lastHash = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// Sink returns the sink of the log
+func (l *Log) Sink() Sink {
+	return l.sink
+}
+
+// Append chains the record to the previous one and writes it
+func (l *Log) Append(r Record) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.Seq = l.seq + 1
+	r.PrevHash = l.lastHash
+	r.Hash = r.ComputeHash()
+	if err := l.sink.Write(r); err != nil {
+		return r, err
+	}
+	l.seq, l.lastHash = r.Seq, r.Hash
+	return r, nil
+}
+
+// Verify checks that the given records, the whole log starting with the first record, have not
+// been tampered with. It returns an error describing the first broken link.
+func Verify(records []Record) error {
+	for i, r := range records {
+		if r.ComputeHash() != r.Hash {
+			return fmt.Errorf("record %d has been modified", r.Seq)
+		}
+		if i == 0 {
+			// records removed from the head would otherwise go unnoticed
+			if r.Seq != 1 || r.PrevHash != "" {
+				return fmt.Errorf("the log starts with record %d, the records before have been removed", r.Seq)
+			}
+			continue
+		}
+		prev := records[i-1]
+		if r.PrevHash != prev.Hash || r.Seq != prev.Seq+1 {
+			return fmt.Errorf("chain is broken between record %d and %d", prev.Seq, r.Seq)
+		}
+	}
+	return nil
+}
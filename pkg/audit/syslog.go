@@ -0,0 +1,60 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+//go:build !windows
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink writes records as json messages to syslog
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog daemon at the given address. An empty network
+// and address connect to the local syslog daemon.
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Write sends the record to syslog
+func (s *SyslogSink) Write(r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(b))
+}
+
+// Last always returns nil because syslog can not be read back. The hash chain starts anew.
+func (s *SyslogSink) Last() (*Record, error) {
+	return nil, nil
+}
+
+// Close closes the connection to syslog
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+//go:build windows
+
+package audit
+
+import "errors"
+
+// SyslogSink is not supported on windows
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error because syslog is not supported on windows
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
+
+// Write does nothing
+func (s *SyslogSink) Write(r Record) error {
+	return nil
+}
+
+// Last returns nil
+func (s *SyslogSink) Last() (*Record, error) {
+	return nil, nil
+}
+
+// Close does nothing
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -33,6 +33,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/auth/manager/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/auth/scope"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/usage"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/pkg/errors"
@@ -112,6 +113,9 @@ func (m *manager) Authenticate(ctx context.Context, token, secret string) (*user
 	}
 
 	publicShareResponse, err := gwConn.GetPublicShareByToken(ctx, &link.GetPublicShareByTokenRequest{
+		// the first authentication of a client counts as an access of the link, the public share
+		// provider recognizes the following ones of the same link session
+		Opaque:         utils.AppendPlainToOpaque(nil, usage.OpaqueKeyRecordAccess, "true"),
 		Token:          token,
 		Authentication: auth,
 		Sign:           true,
@@ -123,6 +127,8 @@ func (m *manager) Authenticate(ctx context.Context, token, secret string) (*user
 		return nil, nil, errtypes.NotFound(publicShareResponse.Status.Message)
 	case publicShareResponse.Status.Code == rpcv1beta1.Code_CODE_PERMISSION_DENIED:
 		return nil, nil, errtypes.InvalidCredentials(publicShareResponse.Status.Message)
+	case publicShareResponse.Status.Code == rpcv1beta1.Code_CODE_RESOURCE_EXHAUSTED:
+		return nil, nil, errtypes.TooManyRequests(publicShareResponse.Status.Message)
 	case publicShareResponse.Status.Code != rpcv1beta1.Code_CODE_OK:
 		return nil, nil, errtypes.InternalError(publicShareResponse.Status.Message)
 	}
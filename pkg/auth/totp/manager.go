@@ -0,0 +1,242 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package totp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	authpb "github.com/cs3org/go-cs3apis/cs3/auth/provider/v1beta1"
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/auth"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+	"github.com/pkg/errors"
+)
+
+// Config configures the second factor
+type Config struct {
+	Issuer string `mapstructure:"issuer" docs:"reva;The issuer shown in authenticator apps."`
+	// Enforce rejects password logins of users without a confirmed second factor
+	Enforce bool `mapstructure:"enforce" docs:"false;Reject single-factor logins of users that did not enrol a second factor."`
+	// TrustedNetworks are exempt from the second factor, e.g. the VPN. Configure the shared trusted_proxies
+	// along with them, otherwise the X-Forwarded-For header of any client is taken as its ip.
+	TrustedNetworks []string `mapstructure:"trusted_networks" docs:"[];CIDRs of networks that may log in with a single factor, e.g. the VPN."`
+	Skew            int      `mapstructure:"skew" docs:"1;The number of time steps before and after the current one that are accepted."`
+
+	ProviderAddr      string `mapstructure:"provider_addr" docs:"com.owncloud.api.storage-system;The storage provider holding the enrolments."`
+	ServiceUserID     string `mapstructure:"service_user_id" docs:";The id of the service user accessing the storage."`
+	ServiceUserIdp    string `mapstructure:"service_user_idp" docs:";The idp of the service user."`
+	MachineAuthAPIKey string `mapstructure:"machine_auth_apikey" docs:";The machine auth api key of the service user."`
+
+	MaxFailures     int `mapstructure:"max_failures" docs:"5;The number of invalid codes after which the second factor of a user is locked."`
+	LockoutDuration int `mapstructure:"lockout_duration" docs:"300;The number of seconds the second factor stays locked."`
+
+	CodeStore             string   `mapstructure:"code_store" docs:"memory;The store to keep the used codes and failed attempts in. Use a shared store like nats-js-kv or redis when running multiple replicas."`
+	CodeStoreNodes        []string `mapstructure:"code_store_nodes" docs:";The nodes of the code store."`
+	CodeStoreDatabase     string   `mapstructure:"code_store_database" docs:"reva;The database of the code store."`
+	CodeStoreTable        string   `mapstructure:"code_store_table" docs:"totp-codes;The table of the code store."`
+	CodeStoreAuthUsername string   `mapstructure:"code_store_auth_username" docs:";The username to authenticate with the code store."`
+	CodeStoreAuthPassword string   `mapstructure:"code_store_auth_password" docs:";The password to authenticate with the code store."`
+
+	networks []*net.IPNet
+}
+
+// Init sets the defaults and parses the trusted networks
+func (c *Config) Init() error {
+	if c.Issuer == "" {
+		c.Issuer = "reva"
+	}
+	if c.Skew == 0 {
+		c.Skew = 1
+	}
+	if c.ProviderAddr == "" {
+		c.ProviderAddr = "com.owncloud.api.storage-system"
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 5
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = 300
+	}
+	if c.CodeStoreDatabase == "" {
+		c.CodeStoreDatabase = "reva"
+	}
+	if c.CodeStoreTable == "" {
+		c.CodeStoreTable = "totp-codes"
+	}
+	c.networks = c.networks[:0]
+	for _, n := range c.TrustedNetworks {
+		_, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return errors.Wrapf(err, "totp: invalid trusted network %s", n)
+		}
+		c.networks = append(c.networks, ipnet)
+	}
+	return nil
+}
+
+// Trusted returns true if the client ip in the context belongs to a trusted network
+func (c *Config) Trusted(ctx context.Context) bool {
+	clientIP, ok := ctxpkg.ContextGetClientIP(ctx)
+	if !ok {
+		return false
+	}
+	// the client ip has already been resolved through the trusted proxies, if any, by the http auth middleware
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewStore returns the store configured for the enrolments and codes
+func (c *Config) NewStore() (*Store, error) {
+	s, err := metadata.NewCS3Storage(c.ProviderAddr, c.ProviderAddr, c.ServiceUserID, c.ServiceUserIdp, c.MachineAuthAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := cas.New(cas.Config{
+		Store:        c.CodeStore,
+		Nodes:        c.CodeStoreNodes,
+		Database:     c.CodeStoreDatabase,
+		Table:        c.CodeStoreTable,
+		AuthUsername: c.CodeStoreAuthUsername,
+		AuthPassword: c.CodeStoreAuthPassword,
+	}, c.codesTTL())
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(c, s, codes), nil
+}
+
+// codesTTL is how long the codes of a user are kept, the used codes only need to be
+// remembered while they are valid
+func (c *Config) codesTTL() time.Duration {
+	return max(time.Duration(c.LockoutDuration)*time.Second, time.Duration(2*c.Skew+1)*Period*time.Second)
+}
+
+type manager struct {
+	next  auth.Manager
+	conf  *Config
+	store *Store
+}
+
+// Wrap returns an auth manager that requires a code of the user's second factor in addition to the
+// password. The code is appended to the password. Logins from trusted networks only need the password.
+func Wrap(next auth.Manager, c *Config, store *Store) auth.Manager {
+	return &manager{next: next, conf: c, store: store}
+}
+
+func (m *manager) Configure(ml map[string]interface{}) error {
+	return m.next.Configure(ml)
+}
+
+func (m *manager) Authenticate(ctx context.Context, clientID, clientSecret string) (*user.User, map[string]*authpb.Scope, error) {
+	if m.conf.Trusted(ctx) {
+		return m.next.Authenticate(ctx, clientID, clientSecret)
+	}
+	log := appctx.GetLogger(ctx)
+	// all failures caused by the credentials return the same error, otherwise
+	// the password could be probed without knowing the second factor
+	invalid := errtypes.InvalidCredentials(clientID)
+
+	u, scopes, err := m.next.Authenticate(ctx, clientID, clientSecret)
+	if err == nil {
+		enrolled, err := m.enrolled(ctx, u)
+		if err != nil {
+			return nil, nil, err
+		}
+		if enrolled {
+			log.Debug().Str("client_id", clientID).Msg("totp: second factor missing")
+			return nil, nil, invalid
+		}
+		if m.conf.Enforce {
+			log.Debug().Str("client_id", clientID).Msg("totp: user has no second factor")
+			return nil, nil, invalid
+		}
+		return u, scopes, nil
+	}
+
+	password, code, ok := splitCode(clientSecret)
+	if !ok {
+		return nil, nil, credentialsError(err, invalid)
+	}
+	u, scopes, err = m.next.Authenticate(ctx, clientID, password)
+	if err != nil {
+		return nil, nil, credentialsError(err, invalid)
+	}
+	enrolled, err := m.enrolled(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !enrolled {
+		return nil, nil, invalid
+	}
+	if err := m.store.Use(ctx, u.GetId().GetOpaqueId(), code); err != nil {
+		log.Debug().Err(err).Str("client_id", clientID).Msg("totp: invalid second factor")
+		return nil, nil, credentialsError(err, invalid)
+	}
+	return u, scopes, nil
+}
+
+// credentialsError replaces the errors caused by wrong credentials
+func credentialsError(err, invalid error) error {
+	switch err.(type) {
+	case errtypes.InvalidCredentials, errtypes.NotFound, errtypes.PermissionDenied:
+		return invalid
+	default:
+		return err
+	}
+}
+
+func (m *manager) enrolled(ctx context.Context, u *user.User) (bool, error) {
+	e, err := m.store.Get(ctx, u.GetId().GetOpaqueId())
+	switch err.(type) {
+	case nil:
+		return e.Confirmed, nil
+	case errtypes.NotFound:
+		return false, nil
+	default:
+		return false, errors.Wrap(err, "totp: error reading enrolment")
+	}
+}
+
+// splitCode splits the code appended to the password
+func splitCode(secret string) (string, string, bool) {
+	if len(secret) <= Digits {
+		return "", "", false
+	}
+	code := secret[len(secret)-Digits:]
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", "", false
+		}
+	}
+	return secret[:len(secret)-Digits], code, true
+}
@@ -0,0 +1,169 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package totp
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+// Enrolment holds the second factor of a user
+type Enrolment struct {
+	Secret string `json:"secret"`
+	// Confirmed is set once the user proved to own the secret. Unconfirmed enrolments are not enforced.
+	Confirmed bool      `json:"confirmed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// codes is the record of the used codes and failed attempts of a user
+type codes struct {
+	// LastStep is the time step of the last used code, codes can not be used twice
+	LastStep int64 `json:"last_step"`
+	// Failures is the number of invalid codes since the last valid one
+	Failures int `json:"failures"`
+	// LockedUntil is the unix time until which no code is accepted
+	LockedUntil int64 `json:"locked_until"`
+}
+
+// errLocked aborts the update of a locked second factor
+var errLocked = errtypes.InvalidCredentials("second factor locked after too many invalid codes")
+
+// Store persists the enrolments in a metadata storage. The used codes and failed attempts are kept
+// in a store that all replicas update atomically.
+type Store struct {
+	conf        *Config
+	storage     metadata.Storage
+	codes       cas.Store
+	initOnce    sync.Once
+	initErr     error
+	spaceName   string
+	enrolmentsD string
+}
+
+// NewStore returns a store using the given metadata storage and code store
+func NewStore(c *Config, s metadata.Storage, codes cas.Store) *Store {
+	return &Store{
+		conf:        c,
+		storage:     s,
+		codes:       codes,
+		spaceName:   "totp-enrolments",
+		enrolmentsD: "enrolments",
+	}
+}
+
+func (s *Store) init() error {
+	s.initOnce.Do(func() {
+		ctx := context.Background()
+		if s.initErr = s.storage.Init(ctx, s.spaceName); s.initErr != nil {
+			return
+		}
+		s.initErr = s.storage.MakeDirIfNotExist(ctx, s.enrolmentsD)
+	})
+	return s.initErr
+}
+
+func (s *Store) path(userID string) string {
+	return path.Join(s.enrolmentsD, url.PathEscape(userID)+".json")
+}
+
+// Get returns the enrolment of the user or a NotFound error
+func (s *Store) Get(ctx context.Context, userID string) (*Enrolment, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	res, err := s.storage.Download(ctx, metadata.DownloadRequest{Path: s.path(userID)})
+	if err != nil {
+		return nil, err
+	}
+	e := &Enrolment{}
+	if err := json.Unmarshal(res.Content, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Put stores the enrolment of the user
+func (s *Store) Put(ctx context.Context, userID string, e *Enrolment) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.storage.SimpleUpload(ctx, s.path(userID), b)
+}
+
+// Delete removes the enrolment of the user
+func (s *Store) Delete(ctx context.Context, userID string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return s.storage.Delete(ctx, s.path(userID))
+}
+
+// Use validates a code of the user's enrolment and records it so it can not be used again.
+// After too many invalid codes no code is accepted until the lockout has passed.
+func (s *Store) Use(ctx context.Context, userID, code string) error {
+	e, err := s.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	step, valid := Validate(e.Secret, code, now, s.conf.Skew)
+
+	var used bool
+	err = s.codes.Update(userID, s.conf.codesTTL(), func(old []byte) ([]byte, error) {
+		c := codes{}
+		if old != nil {
+			if err := json.Unmarshal(old, &c); err != nil {
+				return nil, err
+			}
+		}
+		if c.LockedUntil > now.Unix() {
+			return nil, errLocked
+		}
+		used = valid && step > c.LastStep
+		if used {
+			c.LastStep, c.Failures = step, 0
+			return json.Marshal(c)
+		}
+		c.Failures++
+		if c.Failures >= s.conf.MaxFailures {
+			c.Failures = 0
+			c.LockedUntil = now.Add(time.Duration(s.conf.LockoutDuration) * time.Second).Unix()
+		}
+		return json.Marshal(c)
+	})
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errtypes.InvalidCredentials("invalid second factor")
+	}
+	return nil
+}
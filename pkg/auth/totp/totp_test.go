@@ -0,0 +1,150 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package totp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencloud-eu/reva/v2/pkg/auth/manager/demo"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+func TestCode(t *testing.T) {
+	// test vector from RFC 6238 appendix B, truncated to 6 digits
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	for ts, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := Code(secret, Step(time.Unix(ts, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("expected code %s at %d, got %s", expected, ts, code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	if _, ok := Validate(secret, "005924", now.Add(Period*time.Second), 1); !ok {
+		t.Error("expected code of the previous step to be valid")
+	}
+	if _, ok := Validate(secret, "005924", now.Add(2*Period*time.Second), 1); ok {
+		t.Error("expected code outside of the skew to be invalid")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("reva", "einstein", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/reva:einstein?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	next, _ := demo.New(nil)
+	s, err := metadata.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{TrustedNetworks: []string{"10.0.0.0/8"}, MaxFailures: 3}
+	if err := conf.Init(); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := cas.New(cas.Config{Store: "memory", Database: conf.CodeStoreDatabase, Table: conf.CodeStoreTable}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(conf, s, codes)
+	m := Wrap(next, conf, store)
+
+	// not enrolled
+	u, _, err := m.Authenticate(ctx, "einstein", "relativity")
+	if err != nil {
+		t.Fatalf("expected password login without enrolment to succeed: %v", err)
+	}
+	userID := u.GetId().GetOpaqueId()
+
+	conf.Enforce = true
+	if _, _, err := m.Authenticate(ctx, "einstein", "relativity"); err == nil {
+		t.Fatal("expected single-factor login to fail when enforced")
+	}
+	spoofed := ctxpkg.ContextSetClientIP(ctx, "10.1.2.3, 192.168.0.1")
+	if _, _, err := m.Authenticate(spoofed, "einstein", "relativity"); err == nil {
+		t.Fatal("expected single-factor login with a forwarded-for list to fail")
+	}
+	trusted := ctxpkg.ContextSetClientIP(ctx, "10.1.2.3")
+	if _, _, err := m.Authenticate(trusted, "einstein", "relativity"); err != nil {
+		t.Fatalf("expected single-factor login from a trusted network to succeed: %v", err)
+	}
+
+	secret, _ := GenerateSecret()
+	if err := store.Put(ctx, userID, &Enrolment{Secret: secret, Confirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, errWithoutCode := m.Authenticate(ctx, "einstein", "relativity")
+	if errWithoutCode == nil {
+		t.Fatal("expected login without code to fail")
+	}
+	_, _, errWrongPassword := m.Authenticate(ctx, "einstein", "wrong")
+	if errWithoutCode.Error() != errWrongPassword.Error() {
+		t.Fatalf("expected the same error for a missing code and a wrong password, got %v and %v", errWithoutCode, errWrongPassword)
+	}
+	if _, _, err := m.Authenticate(ctx, "einstein", "relativity000000"); err == nil {
+		t.Fatal("expected login with wrong code to fail")
+	}
+	code, _ := Code(secret, Step(time.Now()))
+	if _, _, err := m.Authenticate(ctx, "einstein", "wrong"+code); err == nil {
+		t.Fatal("expected login with wrong password to fail")
+	}
+	if _, _, err := m.Authenticate(ctx, "einstein", "relativity"+code); err != nil {
+		t.Fatalf("expected login with code to succeed: %v", err)
+	}
+	_, _, err = m.Authenticate(ctx, "einstein", "relativity"+code)
+	if _, ok := err.(errtypes.InvalidCredentials); !ok {
+		t.Fatalf("expected reused code to be rejected, got %v", err)
+	}
+
+	// the reused code counts as a failure, two more lock the second factor
+	for i := 0; i < 2; i++ {
+		if _, _, err := m.Authenticate(ctx, "einstein", "relativity000000"); err == nil {
+			t.Fatal("expected login with wrong code to fail")
+		}
+	}
+	later, _ := Code(secret, Step(time.Now().Add(Period*time.Second)))
+	if _, _, err := m.Authenticate(ctx, "einstein", "relativity"+later); err == nil {
+		t.Fatal("expected a valid code to be rejected while the second factor is locked")
+	}
+
+	if err := store.Delete(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, userID); err == nil {
+		t.Fatal("expected enrolment to be deleted")
+	}
+}
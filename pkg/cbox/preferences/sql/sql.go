@@ -98,3 +98,42 @@ func (m *mgr) GetKey(ctx context.Context, key, namespace string) (string, error)
 	}
 	return val, nil
 }
+
+func (m *mgr) ListKeys(ctx context.Context, namespace string) (map[string]string, error) {
+	user, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return nil, errtypes.UserRequired("preferences: error getting user from ctx")
+	}
+	query := `SELECT configkey, configvalue FROM oc_preferences WHERE userid=? AND appid=?`
+	rows, err := m.db.Query(query, user.Id.OpaqueId, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := map[string]string{}
+	for rows.Next() {
+		var key, val string
+		if err := rows.Scan(&key, &val); err != nil {
+			return nil, err
+		}
+		keys[key] = val
+	}
+	return keys, rows.Err()
+}
+
+func (m *mgr) DeleteKey(ctx context.Context, key, namespace string) error {
+	user, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return errtypes.UserRequired("preferences: error getting user from ctx")
+	}
+	query := `DELETE FROM oc_preferences WHERE userid=? AND appid=? AND configkey=?`
+	res, err := m.db.Exec(query, user.Id.OpaqueId, namespace, key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errtypes.NotFound(namespace + ":" + key)
+	}
+	return nil
+}
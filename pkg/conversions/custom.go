@@ -0,0 +1,156 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package conversions
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/grants"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	customRolesMu sync.RWMutex
+	customRoles   = map[string]*provider.ResourcePermissions{}
+)
+
+// RegisterCustomRole adds a role defined in the configuration. The permissions are the names of the
+// cs3 resource permissions the role grants, e.g. "stat" or "initiate_file_download". The ocs and
+// webdav permissions of the role are derived from them.
+func RegisterCustomRole(name string, permissions []string) error {
+	if name == "" {
+		return fmt.Errorf("custom role without name")
+	}
+	if isBuiltinRole(name) {
+		return fmt.Errorf("custom role %s conflicts with a builtin role", name)
+	}
+
+	rp := &provider.ResourcePermissions{}
+	fields := rp.ProtoReflect().Descriptor().Fields()
+	for _, p := range permissions {
+		field := fields.ByName(protoreflect.Name(p))
+		if field == nil || field.Kind() != protoreflect.BoolKind {
+			return fmt.Errorf("custom role %s: unknown permission %s", name, p)
+		}
+		rp.ProtoReflect().Set(field, protoreflect.ValueOfBool(true))
+	}
+	if grants.PermissionsEqual(rp, &provider.ResourcePermissions{}) {
+		return fmt.Errorf("custom role %s grants no permissions", name)
+	}
+	// custom roles are looked up before the builtin roles, they must not shadow them
+	for _, r := range builtinRoles() {
+		if grants.PermissionsEqual(rp, r.cS3ResourcePermissions) {
+			return fmt.Errorf("custom role %s grants the same permissions as the builtin role %s", name, r.Name)
+		}
+	}
+
+	customRolesMu.Lock()
+	defer customRolesMu.Unlock()
+	customRoles[name] = rp
+	return nil
+}
+
+// CustomRoles returns the roles defined in the configuration sorted by name
+func CustomRoles() []*Role {
+	customRolesMu.RLock()
+	defer customRolesMu.RUnlock()
+
+	roles := make([]*Role, 0, len(customRoles))
+	for name, rp := range customRoles {
+		roles = append(roles, newCustomRole(name, rp))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// PermissionNames returns the names of the granted cs3 resource permissions
+func PermissionNames(rp *provider.ResourcePermissions) []string {
+	names := []string{}
+	rp.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if field.Kind() == protoreflect.BoolKind && v.Bool() {
+			names = append(names, string(field.Name()))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// IsCustomRole returns true if the role was defined in the configuration
+func IsCustomRole(name string) bool {
+	customRolesMu.RLock()
+	defer customRolesMu.RUnlock()
+	_, ok := customRoles[name]
+	return ok
+}
+
+// customRoleFromName returns the custom role with the given name or nil
+func customRoleFromName(name string) *Role {
+	customRolesMu.RLock()
+	defer customRolesMu.RUnlock()
+
+	if rp, ok := customRoles[name]; ok {
+		return newCustomRole(name, rp)
+	}
+	return nil
+}
+
+// customRoleFromResourcePermissions returns the custom role granting exactly the given permissions or nil
+func customRoleFromResourcePermissions(rp *provider.ResourcePermissions) *Role {
+	for _, r := range CustomRoles() {
+		if grants.PermissionsEqual(rp, r.cS3ResourcePermissions) {
+			return r
+		}
+	}
+	return nil
+}
+
+func newCustomRole(name string, rp *provider.ResourcePermissions) *Role {
+	// callers may modify the permissions of the role
+	rp = proto.Clone(rp).(*provider.ResourcePermissions)
+	return &Role{
+		Name:                   name,
+		cS3ResourcePermissions: rp,
+		ocsPermissions:         ocsPermissionsFromResourcePermissions(rp),
+	}
+}
+
+// builtinRoles returns the builtin roles that can be derived from resource permissions
+func builtinRoles() []*Role {
+	return []*Role{
+		NewViewerRole(), NewViewerListGrantsRole(), NewSpaceViewerRole(), NewEditorRole(), NewEditorListGrantsRole(),
+		NewSpaceEditorRole(), NewSpaceEditorWithoutVersionsRole(), NewFileEditorRole(), NewFileEditorListGrantsRole(),
+		NewCoownerRole(), NewEditorLiteRole(), NewUploaderRole(), NewManagerRole(), NewSecureViewerRole(),
+	}
+}
+
+func isBuiltinRole(name string) bool {
+	switch name {
+	case RoleViewer, RoleViewerListGrants, RoleSpaceViewer, RoleEditor, RoleEditorListGrants,
+		RoleSpaceEditor, RoleSpaceEditorWithoutVersions, RoleFileEditor, RoleFileEditorListGrants,
+		RoleCoowner, RoleEditorLite, RoleUploader, RoleManager, RoleSecureViewer,
+		RoleUnknown, RoleLegacy, RoleDenied, "none":
+		return true
+	}
+	return false
+}
@@ -177,6 +177,18 @@ type ShareData struct {
 	// PasswordProtected represents a public share is password protected
 	// PasswordProtected bool `json:"password_protected,omitempty" xml:"password_protected,omitempty"`
 	Hidden bool `json:"hidden" xml:"hidden"`
+	// MaxDownloads is the number of downloads after which a public link is disabled
+	MaxDownloads int `json:"max_downloads,omitempty" xml:"max_downloads,omitempty"`
+	// MaxAccesses is the number of accesses after which a public link is disabled
+	MaxAccesses int `json:"max_accesses,omitempty" xml:"max_accesses,omitempty"`
+	// DownloadCount is the number of downloads of a public link
+	DownloadCount int `json:"download_count,omitempty" xml:"download_count,omitempty"`
+	// AccessCount is the number of accesses of a public link
+	AccessCount int `json:"access_count,omitempty" xml:"access_count,omitempty"`
+	// LastAccess is the time of the last access of a public link
+	LastAccess string `json:"last_access,omitempty" xml:"last_access,omitempty"`
+	// BytesServed is the number of bytes downloaded through a public link
+	BytesServed int64 `json:"bytes_served,omitempty" xml:"bytes_served,omitempty"`
 }
 
 // ShareeData holds share recipient search results
@@ -158,6 +158,9 @@ func (r *Role) WebDAVPermissions(isDir, isShared, isMountpoint, isPublic bool) s
 
 // RoleFromName creates a role from the name
 func RoleFromName(name string) *Role {
+	if r := customRoleFromName(name); r != nil {
+		return r
+	}
 	switch name {
 	case RoleDenied:
 		return NewDeniedRole()
@@ -565,29 +568,10 @@ func RoleFromResourcePermissions(rp *provider.ResourcePermissions, islink bool)
 		}
 		return r
 	}
-	if rp.ListContainer &&
-		rp.ListRecycle &&
-		rp.Stat &&
-		rp.GetPath &&
-		rp.GetQuota &&
-		rp.InitiateFileDownload {
-		r.ocsPermissions |= PermissionRead
-	}
-	if rp.InitiateFileUpload &&
-		rp.RestoreRecycleItem {
-		r.ocsPermissions |= PermissionWrite
-	}
-	if rp.Stat &&
-		rp.CreateContainer &&
-		rp.InitiateFileUpload {
-		r.ocsPermissions |= PermissionCreate
-	}
-	if rp.Delete {
-		r.ocsPermissions |= PermissionDelete
-	}
-	if rp.AddGrant {
-		r.ocsPermissions |= PermissionShare
+	if custom := customRoleFromResourcePermissions(rp); custom != nil {
+		return custom
 	}
+	r.ocsPermissions = ocsPermissionsFromResourcePermissions(rp)
 
 	if r.ocsPermissions.Contain(PermissionRead) {
 		if r.ocsPermissions.Contain(PermissionWrite) && r.ocsPermissions.Contain(PermissionCreate) && r.ocsPermissions.Contain(PermissionDelete) && r.ocsPermissions.Contain(PermissionShare) {
@@ -625,6 +609,35 @@ func RoleFromResourcePermissions(rp *provider.ResourcePermissions, islink bool)
 	return r
 }
 
+// ocsPermissionsFromResourcePermissions maps cs3 resource permissions to the ocs permission bits
+func ocsPermissionsFromResourcePermissions(rp *provider.ResourcePermissions) Permissions {
+	p := PermissionInvalid
+	if rp.ListContainer &&
+		rp.ListRecycle &&
+		rp.Stat &&
+		rp.GetPath &&
+		rp.GetQuota &&
+		rp.InitiateFileDownload {
+		p |= PermissionRead
+	}
+	if rp.InitiateFileUpload &&
+		rp.RestoreRecycleItem {
+		p |= PermissionWrite
+	}
+	if rp.Stat &&
+		rp.CreateContainer &&
+		rp.InitiateFileUpload {
+		p |= PermissionCreate
+	}
+	if rp.Delete {
+		p |= PermissionDelete
+	}
+	if rp.AddGrant {
+		p |= PermissionShare
+	}
+	return p
+}
+
 // SufficientCS3Permissions returns true if the `existing` permissions contain the `requested` permissions
 func SufficientCS3Permissions(existing, requested *provider.ResourcePermissions) bool {
 	if existing == nil || requested == nil {
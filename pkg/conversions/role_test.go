@@ -120,3 +120,38 @@ func TestSufficientPermissions(t *testing.T) {
 		assert.Equal(t, test.Sufficient, SufficientCS3Permissions(test.Existing, test.Requested))
 	}
 }
+
+func TestCustomRoles(t *testing.T) {
+	t.Cleanup(func() { customRoles = map[string]*providerv1beta1.ResourcePermissions{} })
+
+	assert.Error(t, RegisterCustomRole("editor", []string{"stat"}))
+	assert.Error(t, RegisterCustomRole("broken", []string{"fly"}))
+	assert.Error(t, RegisterCustomRole("empty", nil))
+	assert.Error(t, RegisterCustomRole("my-viewer", PermissionNames(NewViewerRole().CS3ResourcePermissions())))
+	assert.False(t, IsCustomRole("my-viewer"))
+
+	editorWithoutDelete := []string{
+		"create_container", "get_path", "get_quota", "initiate_file_download", "initiate_file_upload",
+		"list_container", "list_recycle", "move", "restore_recycle_item", "stat",
+	}
+	assert.NoError(t, RegisterCustomRole("editor-without-delete", editorWithoutDelete))
+	assert.True(t, IsCustomRole("editor-without-delete"))
+
+	role := RoleFromName("editor-without-delete")
+	assert.Equal(t, "editor-without-delete", role.Name)
+	assert.Equal(t, PermissionRead|PermissionWrite|PermissionCreate, role.OCSPermissions())
+	assert.False(t, role.CS3ResourcePermissions().Delete)
+	assert.Equal(t, "NVCK", role.WebDAVPermissions(true, false, false, false))
+	assert.Equal(t, editorWithoutDelete, PermissionNames(role.CS3ResourcePermissions()))
+
+	// modifying a role must not change the definition
+	role.CS3ResourcePermissions().Delete = true
+	assert.False(t, RoleFromName("editor-without-delete").CS3ResourcePermissions().Delete)
+
+	roundtrip := RoleFromResourcePermissions(RoleFromName("editor-without-delete").CS3ResourcePermissions(), false)
+	assert.Equal(t, "editor-without-delete", roundtrip.Name)
+
+	roles := CustomRoles()
+	assert.Len(t, roles, 1)
+	assert.Equal(t, "editor-without-delete", roles[0].Name)
+}
@@ -0,0 +1,35 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ctx
+
+import "context"
+
+// ClientIPHeader is the header key used to pass the ip of the client to grpc calls.
+var ClientIPHeader = "client-ip"
+
+// ContextGetClientIP returns the client ip if set in the given context.
+func ContextGetClientIP(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey).(string)
+	return ip, ok
+}
+
+// ContextSetClientIP stores the client ip in the context.
+func ContextSetClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
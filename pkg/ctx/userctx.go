@@ -33,6 +33,7 @@ const (
 	lockIDKey
 	scopeKey
 	initiatorKey
+	clientIPKey
 )
 
 // ContextGetUser returns the user if set in the given context.
@@ -203,6 +203,50 @@ func (e TooEarly) Error() string { return "error: too early: " + string(e) }
 // IsTooEarly implements the IsTooEarly interface.
 func (e TooEarly) IsTooEarly() {}
 
+// TooLarge is the error to use when a resource exceeds a size limit.
+type TooLarge string
+
+func (e TooLarge) Error() string { return "error: too large: " + string(e) }
+
+// IsTooLarge implements the IsTooLarge interface.
+func (e TooLarge) IsTooLarge() {}
+
+// StatusCode returns StatusRequestEntityTooLarge, this implementation is needed to allow TUS to cast the correct http errors.
+func (e TooLarge) StatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// Body returns the error body. This implementation is needed to allow TUS to cast the correct http errors
+func (e TooLarge) Body() []byte {
+	return []byte(e.Error())
+}
+
+// UnsupportedMediaType is the error to use when a file type is not accepted.
+type UnsupportedMediaType string
+
+func (e UnsupportedMediaType) Error() string { return "error: unsupported media type: " + string(e) }
+
+// IsUnsupportedMediaType implements the IsUnsupportedMediaType interface.
+func (e UnsupportedMediaType) IsUnsupportedMediaType() {}
+
+// StatusCode returns StatusUnsupportedMediaType, this implementation is needed to allow TUS to cast the correct http errors.
+func (e UnsupportedMediaType) StatusCode() int {
+	return http.StatusUnsupportedMediaType
+}
+
+// Body returns the error body. This implementation is needed to allow TUS to cast the correct http errors
+func (e UnsupportedMediaType) Body() []byte {
+	return []byte(e.Error())
+}
+
+// TooManyRequests is the error to use when a client has to wait before trying again.
+type TooManyRequests string
+
+func (e TooManyRequests) Error() string { return "error: too many requests: " + string(e) }
+
+// IsTooManyRequests implements the IsTooManyRequests interface.
+func (e TooManyRequests) IsTooManyRequests() {}
+
 // IsNotFound is the interface to implement
 // to specify that a resource is not found.
 type IsNotFound interface {
@@ -293,6 +337,24 @@ type IsTooEarly interface {
 	IsTooEarly()
 }
 
+// IsTooLarge is the interface to implement
+// to specify that a resource exceeds a size limit.
+type IsTooLarge interface {
+	IsTooLarge()
+}
+
+// IsUnsupportedMediaType is the interface to implement
+// to specify that a file type is not accepted.
+type IsUnsupportedMediaType interface {
+	IsUnsupportedMediaType()
+}
+
+// IsTooManyRequests is the interface to implement
+// to specify that a client has to wait before trying again.
+type IsTooManyRequests interface {
+	IsTooManyRequests()
+}
+
 // NewErrtypeFromStatus maps a rpc status to an errtype
 func NewErrtypeFromStatus(status *rpc.Status) error {
 	switch status.Code {
@@ -329,6 +391,8 @@ func NewErrtypeFromStatus(status *rpc.Status) error {
 		return BadRequest(status.Message)
 	case rpc.Code_CODE_TOO_EARLY:
 		return TooEarly(status.Message)
+	case rpc.Code_CODE_RESOURCE_EXHAUSTED:
+		return TooManyRequests(status.Message)
 	default:
 		return InternalError(status.Message)
 	}
@@ -365,6 +429,12 @@ func NewErrtypeFromHTTPStatusCode(code int, message string) error {
 		return TooEarly(message)
 	case StatusChecksumMismatch:
 		return ChecksumMismatch(message)
+	case http.StatusRequestEntityTooLarge:
+		return TooLarge(message)
+	case http.StatusUnsupportedMediaType:
+		return UnsupportedMediaType(message)
+	case http.StatusTooManyRequests:
+		return TooManyRequests(message)
 	default:
 		return InternalError(message)
 	}
@@ -401,6 +471,12 @@ func NewHTTPStatusCodeFromErrtype(err error) int {
 		return http.StatusTooEarly
 	case ChecksumMismatch:
 		return StatusChecksumMismatch
+	case TooLarge:
+		return http.StatusRequestEntityTooLarge
+	case UnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case TooManyRequests:
+		return http.StatusTooManyRequests
 	default:
 		return http.StatusInternalServerError
 	}
@@ -47,6 +47,9 @@ var (
 
 	// MetadatakeyInitiatorID is the key used for the initiator id in the metadata map of the event
 	MetadatakeyInitiatorID = "initiatorid"
+
+	// MetadatakeyClientIP is the key used for the ip of the client in the metadata map of the event
+	MetadatakeyClientIP = "clientip"
 )
 
 type (
@@ -77,15 +80,31 @@ type (
 		ID          string
 		TraceParent string
 		InitiatorID string
+		ClientIP    string
 		Event       interface{}
+
+		ack  func() error
+		nack func() error
 	}
 )
 
 // Consume returns a channel that will get all events that match the given evs
-// group defines the service type: One group will get exactly one copy of a event that is emitted
+// group defines the service type: One group will get exactly one copy of a event that is emitted.
+// Without a group the consumer gets its own copy of every event.
 // NOTE: uses reflect on initialization
 func Consume(s Consumer, group string, evs ...Unmarshaller) (<-chan Event, error) {
-	c, err := s.Consume(MainQueueName, events.WithGroup(group))
+	return consume(s, group, true, evs)
+}
+
+// ConsumeManualAck is like Consume but the events are not acknowledged when they are handed over.
+// The consumer has to call Ack once it processed an event, events that are not acknowledged in time
+// or are negatively acknowledged with Nack are redelivered.
+func ConsumeManualAck(s Consumer, group string, evs ...Unmarshaller) (<-chan Event, error) {
+	return consume(s, group, false, evs)
+}
+
+func consume(s Consumer, group string, autoAck bool, evs []Unmarshaller) (<-chan Event, error) {
+	c, err := s.Consume(MainQueueName, consumeOptions(group, autoAck)...)
 	if err != nil {
 		return nil, err
 	}
@@ -103,22 +122,19 @@ func Consume(s Consumer, group string, evs ...Unmarshaller) (<-chan Event, error
 			et := e.Metadata[MetadatakeyEventType]
 			ev, ok := registeredEvents[et]
 			if !ok {
+				// the consumer is not interested in the event, so it must not be redelivered
+				skip(&e, autoAck)
 				continue
 			}
 
 			event, err := ev.Unmarshal(e.Payload)
 			if err != nil {
 				log.Printf("can't unmarshal event %v", err)
+				skip(&e, autoAck)
 				continue
 			}
 
-			outchan <- Event{
-				Type:        et,
-				ID:          e.Metadata[MetadatakeyEventID],
-				TraceParent: e.Metadata[MetadatakeyTraceParent],
-				InitiatorID: e.Metadata[MetadatakeyInitiatorID],
-				Event:       event,
-			}
+			outchan <- envelope(&e, autoAck, event)
 		}
 	}()
 	return outchan, nil
@@ -126,7 +142,16 @@ func Consume(s Consumer, group string, evs ...Unmarshaller) (<-chan Event, error
 
 // ConsumeAll allows consuming all events. Note that unmarshalling must be done manually in this case, therefore Event.Event will always be of type []byte
 func ConsumeAll(s Consumer, group string) (<-chan Event, error) {
-	c, err := s.Consume(MainQueueName, events.WithGroup(group))
+	return consumeAll(s, group, true)
+}
+
+// ConsumeAllManualAck is like ConsumeAll but the consumer has to acknowledge the events, see ConsumeManualAck.
+func ConsumeAllManualAck(s Consumer, group string) (<-chan Event, error) {
+	return consumeAll(s, group, false)
+}
+
+func consumeAll(s Consumer, group string, autoAck bool) (<-chan Event, error) {
+	c, err := s.Consume(MainQueueName, consumeOptions(group, autoAck)...)
 	if err != nil {
 		return nil, err
 	}
@@ -135,29 +160,81 @@ func ConsumeAll(s Consumer, group string) (<-chan Event, error) {
 	go func() {
 		for {
 			e := <-c
-			outchan <- Event{
-				Type:        e.Metadata[MetadatakeyEventType],
-				ID:          e.Metadata[MetadatakeyEventID],
-				TraceParent: e.Metadata[MetadatakeyTraceParent],
-				InitiatorID: e.Metadata[MetadatakeyInitiatorID],
-				Event:       e.Payload,
-			}
+			outchan <- envelope(&e, autoAck, e.Payload)
 		}
 	}()
 	return outchan, nil
 }
 
+func consumeOptions(group string, autoAck bool) []events.ConsumeOption {
+	opts := []events.ConsumeOption{}
+	if group != "" {
+		// without a group the stream picks a unique one
+		opts = append(opts, events.WithGroup(group))
+	}
+	if !autoAck {
+		// the stream decides how long to wait for the acknowledgement
+		opts = append(opts, events.WithAutoAck(false, 0))
+	}
+	return opts
+}
+
+func envelope(e *events.Event, autoAck bool, event interface{}) Event {
+	ev := Event{
+		Type:        e.Metadata[MetadatakeyEventType],
+		ID:          e.Metadata[MetadatakeyEventID],
+		TraceParent: e.Metadata[MetadatakeyTraceParent],
+		InitiatorID: e.Metadata[MetadatakeyInitiatorID],
+		ClientIP:    e.Metadata[MetadatakeyClientIP],
+		Event:       event,
+	}
+	if !autoAck {
+		ev.ack, ev.nack = e.Ack, e.Nack
+	}
+	return ev
+}
+
+// skip acknowledges an event that is not handed over to the consumer
+func skip(e *events.Event, autoAck bool) {
+	if autoAck {
+		return
+	}
+	if err := e.Ack(); err != nil {
+		log.Printf("can't acknowledge event %v", err)
+	}
+}
+
+// Ack acknowledges that the event has been processed, so it is not redelivered.
+// It does nothing if the event was not consumed with manual acknowledgement.
+func (e *Event) Ack() error {
+	if e.ack == nil {
+		return nil
+	}
+	return e.ack()
+}
+
+// Nack tells the stream that the event could not be processed, so it is redelivered.
+// It does nothing if the event was not consumed with manual acknowledgement.
+func (e *Event) Nack() error {
+	if e.nack == nil {
+		return nil
+	}
+	return e.nack()
+}
+
 // Publish publishes the ev to the MainQueue from where it is distributed to all subscribers
 // NOTE: needs to use reflect on runtime
 func Publish(ctx context.Context, s Publisher, ev interface{}) error {
 	evName := reflect.TypeOf(ev).String()
 	traceParent := getTraceParentFromCtx(ctx)
 	iid, _ := ctxpkg.ContextGetInitiator(ctx)
+	ip, _ := ctxpkg.ContextGetClientIP(ctx)
 	return s.Publish(MainQueueName, ev, events.WithMetadata(map[string]string{
 		MetadatakeyEventType:   evName,
 		MetadatakeyEventID:     uuid.New().String(),
 		MetadatakeyTraceParent: traceParent,
 		MetadatakeyInitiatorID: iid,
+		MetadatakeyClientIP:    ip,
 	}))
 }
 
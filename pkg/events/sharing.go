@@ -118,6 +118,24 @@ func (ShareExpired) Unmarshal(v []byte) (interface{}, error) {
 	return e, err
 }
 
+// ShareExpiringSoon is emitted some time before a share expires
+type ShareExpiringSoon struct {
+	ShareID    *collaboration.ShareId
+	ShareOwner *user.UserId
+	ItemID     *provider.ResourceId
+	ExpiresAt  time.Time
+	// split the protobuf Grantee oneof so we can use stdlib encoding/json
+	GranteeUserID  *user.UserId
+	GranteeGroupID *group.GroupId
+}
+
+// Unmarshal to fulfill umarshaller interface
+func (ShareExpiringSoon) Unmarshal(v []byte) (interface{}, error) {
+	e := ShareExpiringSoon{}
+	err := json.Unmarshal(v, &e)
+	return e, err
+}
+
 // ReceivedShareUpdated is emitted when a received share is accepted or declined
 type ReceivedShareUpdated struct {
 	Executant      *user.UserId
@@ -225,6 +243,60 @@ func (LinkAccessFailed) Unmarshal(v []byte) (interface{}, error) {
 	return e, err
 }
 
+// LinkLockedOut is emitted when a client was locked out of a password protected public link after too many failed attempts
+type LinkLockedOut struct {
+	ShareID        *link.PublicShareId
+	Sharer         *user.UserId
+	Owner          *user.UserId
+	ItemID         *provider.ResourceId
+	Token          string
+	ClientIP       string
+	FailedAttempts int
+	LockedUntil    *types.Timestamp
+	Timestamp      *types.Timestamp
+}
+
+// Unmarshal to fulfill umarshaller interface
+func (LinkLockedOut) Unmarshal(v []byte) (interface{}, error) {
+	e := LinkLockedOut{}
+	err := json.Unmarshal(v, &e)
+	return e, err
+}
+
+// LinkExpired is emitted when a public link expires
+type LinkExpired struct {
+	ShareID    *link.PublicShareId
+	ShareToken string
+	Sharer     *user.UserId
+	Owner      *user.UserId
+	ItemID     *provider.ResourceId
+	ExpiredAt  time.Time
+}
+
+// Unmarshal to fulfill umarshaller interface
+func (LinkExpired) Unmarshal(v []byte) (interface{}, error) {
+	e := LinkExpired{}
+	err := json.Unmarshal(v, &e)
+	return e, err
+}
+
+// LinkExpiringSoon is emitted some time before a public link expires
+type LinkExpiringSoon struct {
+	ShareID    *link.PublicShareId
+	ShareToken string
+	Sharer     *user.UserId
+	Owner      *user.UserId
+	ItemID     *provider.ResourceId
+	ExpiresAt  time.Time
+}
+
+// Unmarshal to fulfill umarshaller interface
+func (LinkExpiringSoon) Unmarshal(v []byte) (interface{}, error) {
+	e := LinkExpiringSoon{}
+	err := json.Unmarshal(v, &e)
+	return e, err
+}
+
 // LinkRemoved is emitted when a share is removed
 type LinkRemoved struct {
 	Executant *user.UserId
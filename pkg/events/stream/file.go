@@ -0,0 +1,874 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go-micro.dev/v4/events"
+)
+
+// FileConfig configures a file backed stream
+type FileConfig struct {
+	// Dir is the directory the events and the state of the consumer groups are persisted in
+	Dir string
+	// MaxAge is the time after which events are removed. It defaults to 7 days, a negative value keeps them forever.
+	MaxAge time.Duration
+	// MaxEvents is the number of events retained per topic, 0 retains all events
+	MaxEvents int
+	// AckWait is the default time after which unacknowledged events are redelivered
+	AckWait time.Duration
+	// SegmentSize is the number of events per segment file. The retention limits remove whole
+	// segments and always keep the newest one.
+	SegmentSize int
+}
+
+// FileConfigFromURL parses a file url. The retention limits are passed as query parameters, e.g.
+// file:///var/lib/reva/events?max_age=168h&max_events=100000&ack_wait=30s&segment_size=1000
+func FileConfigFromURL(u string) (FileConfig, error) {
+	cfg := FileConfig{}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return cfg, err
+	}
+	if parsed.Scheme != "file" || parsed.Path == "" {
+		return cfg, fmt.Errorf("invalid file stream url '%s'", u)
+	}
+	cfg.Dir = parsed.Path
+
+	q := parsed.Query()
+	for param, target := range map[string]*time.Duration{
+		"max_age":  &cfg.MaxAge,
+		"ack_wait": &cfg.AckWait,
+	} {
+		if v := q.Get(param); v != "" {
+			if *target, err = time.ParseDuration(v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", param, err)
+			}
+		}
+	}
+	for param, target := range map[string]*int{
+		"max_events":   &cfg.MaxEvents,
+		"segment_size": &cfg.SegmentSize,
+	} {
+		if v := q.Get(param); v != "" {
+			if *target, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", param, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+var (
+	fileStoresMu sync.Mutex
+	fileStores   = map[string]*fileStore{}
+)
+
+// FileStream is an embedded stream persisting the events in append only segment files. Consumer groups
+// are durable and get the events at least once: events that are not acknowledged in time are redelivered.
+// Consumers without a group get an ephemeral group that lives until the stream is closed.
+// All services of a process using the same directory share the files, every File call returns a handle
+// that has to be closed. The directory must not be used by other processes.
+type FileStream struct {
+	store *fileStore
+
+	mu sync.Mutex
+	// ephemeral are the groups of the consumers without a group, they are removed on Close
+	ephemeral []*fileGroup
+	closeOnce sync.Once
+}
+
+// fileStore holds the topics of a directory, it is shared by the handles of the process
+type fileStore struct {
+	cfg FileConfig
+	// refs is the number of open handles, guarded by fileStoresMu
+	refs int
+
+	mu     sync.Mutex
+	topics map[string]*fileTopic
+	closed chan struct{}
+}
+
+// File returns a handle of the file stream for the configured directory
+func File(cfg FileConfig) (*FileStream, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("missing directory of the file stream")
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 7 * 24 * time.Hour
+	}
+	if cfg.AckWait <= 0 {
+		cfg.AckWait = 30 * time.Second
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 1000
+	}
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Dir = dir
+
+	fileStoresMu.Lock()
+	defer fileStoresMu.Unlock()
+	s, ok := fileStores[dir]
+	if !ok {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		s = &fileStore{
+			cfg:    cfg,
+			topics: map[string]*fileTopic{},
+			closed: make(chan struct{}),
+		}
+		fileStores[dir] = s
+	}
+	s.refs++
+	return &FileStream{store: s}, nil
+}
+
+// Close stops the consumers without a group that were started with this handle. The consumer groups
+// are stopped and the files are closed when the last handle of the directory is closed.
+func (s *FileStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		for _, g := range s.ephemeral {
+			g.topic.removeGroup(g)
+		}
+		s.ephemeral = nil
+		s.mu.Unlock()
+
+		fileStoresMu.Lock()
+		defer fileStoresMu.Unlock()
+		s.store.refs--
+		if s.store.refs > 0 {
+			return
+		}
+		delete(fileStores, s.store.cfg.Dir)
+		s.store.close()
+	})
+	return nil
+}
+
+func (s *fileStore) close() {
+	close(s.closed)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.topics {
+		t.close()
+	}
+}
+
+// Publish implementation
+func (s *FileStream) Publish(topic string, msg interface{}, opts ...events.PublishOption) error {
+	options := events.PublishOptions{
+		Timestamp: time.Now(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	payload, ok := msg.([]byte)
+	if !ok {
+		p, err := json.Marshal(msg)
+		if err != nil {
+			return events.ErrEncodingMessage
+		}
+		payload = p
+	}
+
+	t, err := s.store.topic(topic)
+	if err != nil {
+		return err
+	}
+	return t.append(fileRecord{
+		ID:        uuid.New().String(),
+		Timestamp: options.Timestamp,
+		Metadata:  options.Metadata,
+		Payload:   payload,
+	})
+}
+
+// Consume implementation. Consumers of the same group share the returned channel.
+func (s *FileStream) Consume(topic string, opts ...events.ConsumeOption) (<-chan events.Event, error) {
+	// like the go-micro streams events are acknowledged on delivery unless WithAutoAck(false, ...) is passed
+	options := events.ConsumeOptions{AutoAck: true}
+	for _, o := range opts {
+		o(&options)
+	}
+	durable := options.Group != ""
+	if !durable {
+		options.Group = uuid.New().String()
+	}
+	if options.AckWait <= 0 {
+		options.AckWait = s.store.cfg.AckWait
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.store.closed:
+		return nil, fmt.Errorf("file stream is closed")
+	default:
+	}
+
+	t, err := s.store.topic(topic)
+	if err != nil {
+		return nil, err
+	}
+	g, created, err := t.group(options, durable)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if !durable {
+			s.ephemeral = append(s.ephemeral, g)
+		}
+		go s.store.deliver(topic, t, g)
+	}
+	return g.out, nil
+}
+
+func (s *fileStore) topic(name string) (*fileTopic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil, fmt.Errorf("file stream is closed")
+	default:
+	}
+	if t, ok := s.topics[name]; ok {
+		return t, nil
+	}
+	t, err := openTopic(s.cfg, filepath.Join(s.cfg.Dir, url.PathEscape(name)))
+	if err != nil {
+		return nil, err
+	}
+	s.topics[name] = t
+	return t, nil
+}
+
+// deliver sends the events of the topic to the consumers of the group until the group is removed
+// or the stream is closed
+func (s *fileStore) deliver(topic string, t *fileTopic, g *fileGroup) {
+	for {
+		t.mu.Lock()
+		rec, wait := t.next(g, time.Now())
+		changed := t.changed
+		t.mu.Unlock()
+
+		if rec == nil {
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if wait >= 0 {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+			select {
+			case <-changed:
+			case <-timeout:
+			case <-g.removed:
+				return
+			case <-s.closed:
+				return
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+
+		seq := rec.Seq
+		ev := events.Event{
+			ID:        rec.ID,
+			Topic:     topic,
+			Timestamp: rec.Timestamp,
+			Metadata:  rec.Metadata,
+			Payload:   rec.Payload,
+		}
+		ev.SetAckFunc(func() error {
+			return t.ack(g, seq)
+		})
+		ev.SetNackFunc(func() error {
+			t.nack(g, seq)
+			return nil
+		})
+
+		select {
+		case g.out <- ev:
+		case <-g.removed:
+			return
+		case <-s.closed:
+			return
+		}
+		if g.opts.AutoAck {
+			_ = t.ack(g, seq)
+		}
+	}
+}
+
+type fileRecord struct {
+	Seq       uint64            `json:"seq"`
+	ID        string            `json:"id"`
+	Timestamp time.Time         `json:"timestamp"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Payload   []byte            `json:"payload"`
+}
+
+type fileSegment struct {
+	path   string
+	first  uint64
+	last   uint64
+	count  int
+	newest time.Time
+}
+
+// fileTopic keeps the index of the segments in memory, the events are read from the segments when they are delivered
+type fileTopic struct {
+	cfg FileConfig
+	dir string
+
+	mu       sync.Mutex
+	segments []*fileSegment
+	// total is the number of events in the segments
+	total   int
+	current *os.File
+	nextSeq uint64
+	groups  map[string]*fileGroup
+	// changed is closed and replaced whenever the consumers have to look for events to deliver
+	changed chan struct{}
+}
+
+func openTopic(cfg FileConfig, dir string) (*fileTopic, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "groups"), 0700); err != nil {
+		return nil, err
+	}
+	t := &fileTopic{
+		cfg:     cfg,
+		dir:     dir,
+		nextSeq: 1,
+		groups:  map[string]*fileGroup{},
+		changed: make(chan struct{}),
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	// the segments are named by the zero padded sequence number of their first event
+	sort.Strings(paths)
+	for _, p := range paths {
+		seg, err := indexSegment(p)
+		if err != nil {
+			return nil, err
+		}
+		if seg.count == 0 {
+			continue
+		}
+		t.segments = append(t.segments, seg)
+		t.total += seg.count
+		t.nextSeq = seg.last + 1
+	}
+	// new events always go to a new segment, the last one might end with a partially written event
+	t.enforceRetention(time.Now())
+	return t, nil
+}
+
+// indexSegment reads the sequence numbers and the age of the events in the segment
+func indexSegment(p string) (*fileSegment, error) {
+	r, err := openSegment(&fileSegment{path: p})
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	seg := r.seg
+	for {
+		rec, err := r.read()
+		if err == io.EOF {
+			return seg, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seg.count == 0 {
+			seg.first = rec.Seq
+		}
+		seg.last = rec.Seq
+		seg.count++
+		if rec.Timestamp.After(seg.newest) {
+			seg.newest = rec.Timestamp
+		}
+	}
+}
+
+// segmentReader reads the events of a segment one after the other
+type segmentReader struct {
+	seg *fileSegment
+	f   *os.File
+	r   *bufio.Reader
+	// offset is the position of the next event in the file
+	offset int64
+}
+
+func openSegment(seg *fileSegment) (*segmentReader, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{seg: seg, f: f, r: bufio.NewReader(f)}, nil
+}
+
+// read returns the next event or io.EOF. Events that were not written completely are skipped.
+func (r *segmentReader) read() (*fileRecord, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			// read the partial line again once it is complete
+			if _, err := r.f.Seek(r.offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			r.r.Reset(r.f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.offset += int64(len(line))
+
+		rec := &fileRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			continue
+		}
+		return rec, nil
+	}
+}
+
+func (r *segmentReader) close() {
+	r.f.Close()
+}
+
+// read returns the first event with a sequence number of at least seq, nil if there is none.
+// The reader is passed on to the following calls, so events are read sequentially.
+func (t *fileTopic) read(reader **segmentReader, seq uint64) (*fileRecord, error) {
+	i := sort.Search(len(t.segments), func(i int) bool { return t.segments[i].last >= seq })
+	for ; i < len(t.segments); i++ {
+		seg := t.segments[i]
+		r := *reader
+		if r != nil && r.seg != seg {
+			r.close()
+			r = nil
+		}
+		if r == nil {
+			var err error
+			if r, err = openSegment(seg); err != nil {
+				return nil, err
+			}
+			*reader = r
+		}
+		for {
+			rec, err := r.read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if rec.Seq >= seq {
+				return rec, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (t *fileTopic) append(r fileRecord) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current == nil || t.segments[len(t.segments)-1].count >= t.cfg.SegmentSize {
+		if t.current != nil {
+			t.current.Close()
+		}
+		p := filepath.Join(t.dir, fmt.Sprintf("%020d.log", t.nextSeq))
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		t.current = f
+		t.segments = append(t.segments, &fileSegment{path: p, first: t.nextSeq})
+	}
+
+	r.Seq = t.nextSeq
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := t.current.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := t.current.Sync(); err != nil {
+		return err
+	}
+
+	seg := t.segments[len(t.segments)-1]
+	seg.last = r.Seq
+	seg.count++
+	if r.Timestamp.After(seg.newest) {
+		seg.newest = r.Timestamp
+	}
+	t.total++
+	t.nextSeq++
+
+	t.enforceRetention(time.Now())
+	t.notify()
+	return nil
+}
+
+// enforceRetention removes the oldest segments exceeding the retention limits
+func (t *fileTopic) enforceRetention(now time.Time) {
+	removed := false
+	for len(t.segments) > 1 {
+		seg := t.segments[0]
+		expired := t.cfg.MaxAge > 0 && now.Sub(seg.newest) > t.cfg.MaxAge
+		tooMany := t.cfg.MaxEvents > 0 && t.total-seg.count >= t.cfg.MaxEvents
+		if !expired && !tooMany {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			break
+		}
+		t.segments = t.segments[1:]
+		t.total -= seg.count
+		removed = true
+	}
+	if !removed {
+		return
+	}
+	for _, g := range t.groups {
+		t.skipRemoved(g)
+	}
+}
+
+func (t *fileTopic) firstSeq() uint64 {
+	if len(t.segments) > 0 {
+		return t.segments[0].first
+	}
+	return t.nextSeq
+}
+
+// skipRemoved moves the ack floor of the group behind the events removed by the retention limits
+func (t *fileTopic) skipRemoved(g *fileGroup) {
+	first := t.firstSeq()
+	if g.reader != nil && g.reader.seg.last < first {
+		g.reader.close()
+		g.reader = nil
+	}
+	if g.cursor < first {
+		g.cursor = first
+	}
+	if g.ackFloor+1 >= first {
+		return
+	}
+	g.ackFloor = first - 1
+	for seq := range g.acked {
+		if seq <= g.ackFloor {
+			delete(g.acked, seq)
+		}
+	}
+	for seq := range g.pending {
+		if seq <= g.ackFloor {
+			delete(g.pending, seq)
+			delete(g.deliveries, seq)
+		}
+	}
+}
+
+func (t *fileTopic) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *fileTopic) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != nil {
+		t.current.Close()
+		t.current = nil
+	}
+	for _, g := range t.groups {
+		if g.reader != nil {
+			g.reader.close()
+			g.reader = nil
+		}
+	}
+}
+
+// group returns the consumer group, it returns true if the group was created
+func (t *fileTopic) group(opts events.ConsumeOptions, durable bool) (*fileGroup, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if g, ok := t.groups[opts.Group]; ok {
+		return g, false, nil
+	}
+	g := &fileGroup{
+		topic:      t,
+		opts:       opts,
+		acked:      map[uint64]struct{}{},
+		pending:    map[uint64]time.Time{},
+		deliveries: map[uint64]int{},
+		out:        make(chan events.Event),
+		removed:    make(chan struct{}),
+	}
+	if durable {
+		g.path = filepath.Join(t.dir, "groups", url.PathEscape(opts.Group)+".json")
+	}
+
+	loaded, err := g.load()
+	if err != nil {
+		return nil, false, err
+	}
+	if !loaded {
+		// new groups get the events published from now on or since the offset
+		g.ackFloor = t.nextSeq - 1
+		if !opts.Offset.IsZero() {
+			seq, err := t.seqSince(opts.Offset)
+			if err != nil {
+				return nil, false, err
+			}
+			g.ackFloor = seq - 1
+		}
+		if err := g.persist(); err != nil {
+			return nil, false, err
+		}
+	}
+	// the events above the ack floor that were delivered before the restart are delivered again
+	g.cursor = g.ackFloor + 1
+	t.skipRemoved(g)
+	t.groups[opts.Group] = g
+	return g, true, nil
+}
+
+// removeGroup stops the delivery to the group
+func (t *fileTopic) removeGroup(g *fileGroup) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.groups[g.opts.Group] != g {
+		return
+	}
+	delete(t.groups, g.opts.Group)
+	close(g.removed)
+	if g.reader != nil {
+		g.reader.close()
+		g.reader = nil
+	}
+}
+
+// seqSince returns the sequence number of the first event published at or after the given time
+func (t *fileTopic) seqSince(since time.Time) (uint64, error) {
+	for _, seg := range t.segments {
+		if seg.newest.Before(since) {
+			continue
+		}
+		r, err := openSegment(seg)
+		if err != nil {
+			return 0, err
+		}
+		for {
+			rec, err := r.read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.close()
+				return 0, err
+			}
+			if !rec.Timestamp.Before(since) {
+				r.close()
+				return rec.Seq, nil
+			}
+		}
+		r.close()
+	}
+	return t.nextSeq, nil
+}
+
+// next returns the next event to deliver to the group or the time to wait for the next redelivery.
+// A negative wait means there is nothing to redeliver.
+func (t *fileTopic) next(g *fileGroup, now time.Time) (*fileRecord, time.Duration) {
+	limit := g.opts.GetRetryLimit()
+
+	// redeliver the oldest event that was not acknowledged in time
+	wait := time.Duration(-1)
+	var due []uint64
+	for seq, deadline := range g.pending {
+		if now.Before(deadline) {
+			if d := deadline.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		due = append(due, seq)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+	for _, seq := range due {
+		if limit > 0 && g.deliveries[seq] >= limit {
+			// give up the event
+			_ = t.ackLocked(g, seq)
+			continue
+		}
+		var reader *segmentReader
+		rec, err := t.read(&reader, seq)
+		if reader != nil {
+			reader.close()
+		}
+		if err != nil || rec == nil || rec.Seq != seq {
+			// the event has been removed
+			delete(g.pending, seq)
+			delete(g.deliveries, seq)
+			continue
+		}
+		g.deliveries[seq]++
+		g.pending[seq] = now.Add(g.opts.AckWait)
+		return rec, 0
+	}
+
+	// deliver the next new event
+	for g.cursor < t.nextSeq {
+		rec, err := t.read(&g.reader, g.cursor)
+		if err != nil || rec == nil {
+			// the event can not be read, continue with the next one
+			g.cursor++
+			continue
+		}
+		g.cursor = rec.Seq + 1
+		if _, ok := g.acked[rec.Seq]; ok || rec.Seq <= g.ackFloor {
+			continue
+		}
+		g.deliveries[rec.Seq]++
+		if !g.opts.AutoAck {
+			g.pending[rec.Seq] = now.Add(g.opts.AckWait)
+		}
+		return rec, 0
+	}
+	return nil, wait
+}
+
+func (t *fileTopic) ack(g *fileGroup, seq uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ackLocked(g, seq)
+}
+
+func (t *fileTopic) ackLocked(g *fileGroup, seq uint64) error {
+	if _, ok := g.acked[seq]; ok || seq <= g.ackFloor {
+		return nil
+	}
+	g.acked[seq] = struct{}{}
+	delete(g.pending, seq)
+	delete(g.deliveries, seq)
+	for {
+		if _, ok := g.acked[g.ackFloor+1]; !ok {
+			break
+		}
+		delete(g.acked, g.ackFloor+1)
+		g.ackFloor++
+	}
+	return g.persist()
+}
+
+// nack makes the event available for redelivery immediately
+func (t *fileTopic) nack(g *fileGroup, seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := g.pending[seq]; ok {
+		g.pending[seq] = time.Time{}
+		t.notify()
+	}
+}
+
+type fileGroup struct {
+	topic *fileTopic
+	opts  events.ConsumeOptions
+	// path is the file the state of durable groups is persisted in
+	path string
+
+	// all events up to the ack floor were acknowledged
+	ackFloor uint64
+	// acked holds the acknowledged events above the ack floor
+	acked map[uint64]struct{}
+	// pending holds the redelivery deadline of delivered but unacknowledged events
+	pending    map[uint64]time.Time
+	deliveries map[uint64]int
+	// cursor is the sequence number of the next event that has not been delivered yet
+	cursor uint64
+	reader *segmentReader
+	out    chan events.Event
+	// removed is closed when the group is removed
+	removed chan struct{}
+}
+
+type fileGroupState struct {
+	AckFloor uint64   `json:"ack_floor"`
+	Acked    []uint64 `json:"acked,omitempty"`
+}
+
+func (g *fileGroup) load() (bool, error) {
+	if g.path == "" {
+		return false, nil
+	}
+	b, err := os.ReadFile(g.path)
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	state := fileGroupState{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return false, err
+	}
+	g.ackFloor = state.AckFloor
+	for _, seq := range state.Acked {
+		g.acked[seq] = struct{}{}
+	}
+	return true, nil
+}
+
+// persist writes the state of durable groups. It is not synced to disk, losing it only causes redeliveries.
+func (g *fileGroup) persist() error {
+	if g.path == "" {
+		return nil
+	}
+	state := fileGroupState{AckFloor: g.ackFloor}
+	for seq := range g.acked {
+		state.Acked = append(state.Acked, seq)
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.path)
+}
+
+// isFileURL returns true if the endpoint selects the file stream
+func isFileURL(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "file://")
+}
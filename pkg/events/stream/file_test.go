@@ -0,0 +1,342 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	revaevents "github.com/opencloud-eu/reva/v2/pkg/events"
+	"go-micro.dev/v4/events"
+)
+
+func newFileStream(t *testing.T, cfg FileConfig) *FileStream {
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	s, err := File(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func receive(t *testing.T, ch <-chan events.Event) events.Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return events.Event{}
+}
+
+func expectNothing(t *testing.T, ch <-chan events.Event, d time.Duration) {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %s", ev.Payload)
+	case <-time.After(d):
+	}
+}
+
+func TestFileConfigFromURL(t *testing.T) {
+	cfg, err := FileConfigFromURL("file:///var/lib/reva/events?max_age=24h&max_events=10&ack_wait=5s&segment_size=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FileConfig{Dir: "/var/lib/reva/events", MaxAge: 24 * time.Hour, MaxEvents: 10, AckWait: 5 * time.Second, SegmentSize: 3}
+	if cfg != expected {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+	if _, err := FileConfigFromURL("nats://localhost:4222"); err == nil {
+		t.Error("expected an error for a non file url")
+	}
+	if _, err := FileConfigFromURL("file:///tmp?max_age=forever"); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
+
+func TestFileStreamGroups(t *testing.T) {
+	s := newFileStream(t, FileConfig{})
+
+	ch, err := s.Consume("topic", events.WithGroup("group"), events.WithAutoAck(true, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch2, err := s.Consume("topic", events.WithGroup("group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch != ch2 {
+		t.Error("consumers of a group must share the events")
+	}
+	other, err := s.Consume("topic", events.WithGroup("other"), events.WithAutoAck(true, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"one", "two"} {
+		if err := s.Publish("topic", []byte(msg), events.WithMetadata(map[string]string{"eventtype": "test"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []<-chan events.Event{ch, other} {
+		for _, msg := range []string{"one", "two"} {
+			ev := receive(t, c)
+			if string(ev.Payload) != msg || ev.Metadata["eventtype"] != "test" || ev.Topic != "topic" {
+				t.Errorf("unexpected event %+v", ev)
+			}
+		}
+	}
+	expectNothing(t, ch, 50*time.Millisecond)
+}
+
+func TestFileStreamRedelivery(t *testing.T) {
+	s := newFileStream(t, FileConfig{})
+	ch, err := s.Consume("topic", events.WithGroup("group"), events.WithAutoAck(false, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Publish("topic", "event"); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := receive(t, ch)
+	// not acknowledged in time
+	ev = receive(t, ch)
+	if string(ev.Payload) != `"event"` {
+		t.Errorf("unexpected payload %s", ev.Payload)
+	}
+	// nacked events are redelivered right away
+	if err := ev.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	ev = receive(t, ch)
+	if err := ev.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	expectNothing(t, ch, 250*time.Millisecond)
+}
+
+func TestFileStreamRetryLimit(t *testing.T) {
+	s := newFileStream(t, FileConfig{})
+	ch, err := s.Consume("topic", events.WithGroup("group"), events.WithAutoAck(false, 50*time.Millisecond), events.WithRetryLimit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Publish("topic", "event"); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ch)
+	receive(t, ch)
+	expectNothing(t, ch, 200*time.Millisecond)
+}
+
+func TestFileStreamDurability(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStream(t, FileConfig{Dir: dir})
+	ch, err := s.Consume("topic", events.WithGroup("group"), events.WithAutoAck(false, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := s.Publish("topic", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ack := range []bool{true, false, true} {
+		ev := receive(t, ch)
+		if !ack {
+			continue
+		}
+		if err := ev.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s = newFileStream(t, FileConfig{Dir: dir})
+	ch, err = s.Consume("topic", events.WithGroup("group"), events.WithAutoAck(false, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := receive(t, ch)
+	if string(ev.Payload) != "two" {
+		t.Errorf("expected the unacknowledged event to be redelivered, got %s", ev.Payload)
+	}
+	if err := ev.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Publish("topic", []byte("four")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := receive(t, ch); string(ev.Payload) != "four" {
+		t.Errorf("expected the new event, got %s", ev.Payload)
+	}
+}
+
+func TestFileStreamRetention(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStream(t, FileConfig{Dir: dir, SegmentSize: 2, MaxEvents: 3})
+	for _, msg := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		if err := s.Publish("topic", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, "topic", "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Errorf("expected 2 segments, got %v", segments)
+	}
+
+	ch, err := s.Consume("topic", events.WithGroup("group"), events.WithOffset(time.Unix(0, 0)), events.WithAutoAck(true, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"5", "6", "7"} {
+		if ev := receive(t, ch); string(ev.Payload) != msg {
+			t.Errorf("expected %s, got %s", msg, ev.Payload)
+		}
+	}
+}
+
+func TestFileStreamPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStream(t, FileConfig{Dir: dir})
+	if err := s.Publish("topic", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "topic", "*.log"))
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"seq":2,"id":"broken`)
+	f.Close()
+
+	s = newFileStream(t, FileConfig{Dir: dir})
+	ch, err := s.Consume("topic", events.WithGroup("group"), events.WithOffset(time.Unix(0, 0)), events.WithAutoAck(true, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Publish("topic", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"one", "two"} {
+		if ev := receive(t, ch); string(ev.Payload) != msg {
+			t.Errorf("expected %s, got %s", msg, ev.Payload)
+		}
+	}
+}
+
+func TestNatsFromConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NatsFromConfig("test", false, NatsConfig{Endpoint: "file://" + dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*FileStream).Close()
+	s2, err := NatsFromConfig("other", false, NatsConfig{Endpoint: "file://" + dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := s.Consume("topic", events.WithGroup("group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handles share the stream, closing one keeps the other working
+	if err := s2.Publish("topic", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := receive(t, ch); string(ev.Payload) != "one" {
+		t.Errorf("expected one, got %s", ev.Payload)
+	}
+	s2.(*FileStream).Close()
+	if err := s.Publish("topic", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := receive(t, ch); string(ev.Payload) != "two" {
+		t.Errorf("expected two, got %s", ev.Payload)
+	}
+}
+
+func TestFileStreamEphemeralGroups(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStream(t, FileConfig{Dir: dir})
+	s2 := newFileStream(t, FileConfig{Dir: dir})
+	if _, err := s2.Consume("topic"); err != nil {
+		t.Fatal(err)
+	}
+	s2.Close()
+
+	topic, err := s.store.topic("topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	if len(topic.groups) != 0 {
+		t.Errorf("expected the ephemeral group to be removed, got %d groups", len(topic.groups))
+	}
+}
+
+func TestConsumeManualAck(t *testing.T) {
+	s := newFileStream(t, FileConfig{AckWait: 100 * time.Millisecond})
+	ch, err := revaevents.ConsumeManualAck(s, "group", revaevents.ContainerCreated{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auto, err := revaevents.Consume(s, "auto", revaevents.ContainerCreated{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := revaevents.Publish(context.Background(), s, revaevents.ContainerCreated{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// events are redelivered until the consumer acknowledges them
+	first := receiveEnvelope(t, ch)
+	second := receiveEnvelope(t, ch)
+	if first.ID != second.ID {
+		t.Errorf("expected event %s to be redelivered, got %s", first.ID, second.ID)
+	}
+	if err := second.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected redelivery of acknowledged event %s", ev.ID)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	// other groups acknowledge automatically
+	ev := receiveEnvelope(t, auto)
+	if err := ev.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-auto:
+		t.Fatalf("unexpected redelivery of event %s", ev.ID)
+	case <-time.After(250 * time.Millisecond):
+	}
+}
+
+func receiveEnvelope(t *testing.T, ch <-chan revaevents.Event) revaevents.Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return revaevents.Event{}
+}
@@ -27,8 +27,17 @@ type NatsConfig struct {
 
 }
 
-// NatsFromConfig returns a nats stream from the given config
+// NatsFromConfig returns a nats stream from the given config. Endpoints starting with file:// select the
+// embedded file stream instead, see FileConfigFromURL.
 func NatsFromConfig(connName string, disableDurability bool, cfg NatsConfig) (events.Stream, error) {
+	if isFileURL(cfg.Endpoint) {
+		fc, err := FileConfigFromURL(cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return File(fc)
+	}
+
 	var tlsConf *tls.Config
 	if cfg.EnableTLS {
 		var rootCAPool *x509.CertPool
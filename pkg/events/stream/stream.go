@@ -32,10 +32,14 @@ func (ch Chan) Consume(_ string, _ ...events.ConsumeOption) (<-chan events.Event
 			}
 			b, _ := json.Marshal(e)
 			evname := reflect.TypeOf(e).String()
-			evch <- events.Event{
+			ev := events.Event{
 				Payload:  b,
 				Metadata: map[string]string{"eventtype": evname},
 			}
+			// events can not be redelivered
+			ev.SetAckFunc(func() error { return nil })
+			ev.SetNackFunc(func() error { return nil })
+			evch <- ev
 		}
 	}()
 	return evch, nil
@@ -33,3 +33,17 @@ type Manager interface {
 	GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error)
 	HasMember(ctx context.Context, gid *grouppb.GroupId, uid *userpb.UserId) (bool, error)
 }
+
+// Writer is an optional interface for group managers that allow provisioning groups.
+type Writer interface {
+	// CreateGroup creates a new group and returns it including the generated id.
+	CreateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error)
+	// UpdateGroup replaces the attributes of the group identified by g.Id. Members are not changed.
+	UpdateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error)
+	// DeleteGroup deletes the group identified by gid.
+	DeleteGroup(ctx context.Context, gid *grouppb.GroupId) error
+	// AddMembers adds the given users to the group. Users that already are members are ignored.
+	AddMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error
+	// RemoveMembers removes the given users from the group. Users that are no members are ignored.
+	RemoveMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error
+}
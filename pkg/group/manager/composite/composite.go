@@ -0,0 +1,344 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package composite implements a group manager that chains several other group managers.
+// Groups with the same id in several backends are merged, so a json backend can add guests
+// to a group that is kept in LDAP.
+package composite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/group"
+	"github.com/opencloud-eu/reva/v2/pkg/group/manager/registry"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registry.Register("composite", New)
+}
+
+type backendConfig struct {
+	// Driver is the name of the group manager driver of the backend
+	Driver string `mapstructure:"driver"`
+	// Config is the configuration of the driver
+	Config map[string]interface{} `mapstructure:"config"`
+	// Idp routes lookups of group ids with this idp to the backend
+	Idp string `mapstructure:"idp"`
+	// Prefix routes lookups of group ids starting with this prefix to the backend
+	Prefix string `mapstructure:"prefix"`
+	// Timeout is the timeout for requests to the backend in seconds. Defaults to the global timeout.
+	Timeout int `mapstructure:"timeout"`
+}
+
+type config struct {
+	// Backends are queried in the configured order. Earlier backends win when results are merged.
+	Backends []backendConfig `mapstructure:"backends"`
+	// Timeout is the default timeout for requests to a backend in seconds
+	Timeout int `mapstructure:"timeout"`
+}
+
+func (c *config) init() {
+	if c.Timeout == 0 {
+		c.Timeout = 5
+	}
+	for i := range c.Backends {
+		if c.Backends[i].Timeout == 0 {
+			c.Backends[i].Timeout = c.Timeout
+		}
+	}
+}
+
+func parseConfig(m map[string]interface{}) (*config, error) {
+	c := &config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		err = errors.Wrap(err, "error decoding conf")
+		return nil, err
+	}
+	c.init()
+	return c, nil
+}
+
+type backend struct {
+	group.Manager
+	name    string
+	idp     string
+	prefix  string
+	timeout time.Duration
+}
+
+// routes returns true if lookups of the given id should go to this backend
+func (b *backend) routes(gid *grouppb.GroupId) bool {
+	return (b.idp != "" && b.idp == gid.GetIdp()) || (b.prefix != "" && strings.HasPrefix(gid.GetOpaqueId(), b.prefix))
+}
+
+type manager struct {
+	backends []*backend
+}
+
+// New returns a group manager that queries all configured group managers.
+func New(m map[string]interface{}) (group.Manager, error) {
+	c, err := parseConfig(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Backends) == 0 {
+		return nil, errors.New("composite: no backends configured")
+	}
+
+	mgr := &manager{}
+	for i, bc := range c.Backends {
+		if bc.Driver == "composite" {
+			return nil, errors.New("composite: backends can not be composite")
+		}
+		f, ok := registry.NewFuncs[bc.Driver]
+		if !ok {
+			return nil, errtypes.NotFound(fmt.Sprintf("composite: driver %s not found", bc.Driver))
+		}
+		gm, err := f(bc.Config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "composite: error creating backend %d", i)
+		}
+		mgr.backends = append(mgr.backends, &backend{
+			Manager: gm,
+			name:    fmt.Sprintf("%d-%s", i, bc.Driver),
+			idp:     bc.Idp,
+			prefix:  bc.Prefix,
+			timeout: time.Duration(bc.Timeout) * time.Second,
+		})
+	}
+	return mgr, nil
+}
+
+// route returns the backends responsible for the given id. All backends are responsible if none matches.
+func (m *manager) route(gid *grouppb.GroupId) []*backend {
+	routed := []*backend{}
+	for _, b := range m.backends {
+		if b.routes(gid) {
+			routed = append(routed, b)
+		}
+	}
+	if len(routed) == 0 {
+		return m.backends
+	}
+	return routed
+}
+
+// result is the result of a backend request
+type result[T any] struct {
+	value T
+	err   error
+}
+
+// call sends a request to the backend. It returns when the timeout of the backend is reached or the context
+// is done, even if the backend does not stop.
+func call[T any](ctx context.Context, b *backend, f func(context.Context, *backend) (T, error)) (T, error) {
+	var res T
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	done := make(chan result[T], 1)
+	go func() {
+		r, err := f(ctx, b)
+		done <- result[T]{r, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return res, ctx.Err()
+	}
+}
+
+// first returns the first result of the backends in order. Errors other than NotFound are returned if no backend found a result.
+func first[T any](ctx context.Context, backends []*backend, f func(context.Context, *backend) (T, error)) (T, error) {
+	var res T
+	var failed error
+	for _, b := range backends {
+		r, err := call(ctx, b, f)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		switch err.(type) {
+		case nil:
+			return r, nil
+		case errtypes.NotFound:
+		default:
+			appctx.GetLogger(ctx).Warn().Err(err).Str("backend", b.name).Msg("composite: backend request failed")
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	if failed != nil {
+		return res, failed
+	}
+	return res, errtypes.NotFound("no backend found a result")
+}
+
+// all queries the backends concurrently and returns the results in the order of the backends.
+// Failing backends are logged and skipped, backends that do not answer in time are not waited for.
+func all[T any](ctx context.Context, backends []*backend, f func(context.Context, *backend) (T, error)) []T {
+	results := make([]T, len(backends))
+	ok := make([]bool, len(backends))
+	wg := sync.WaitGroup{}
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			r, err := call(ctx, b, f)
+			if err != nil {
+				if _, notFound := err.(errtypes.NotFound); !notFound && ctx.Err() == nil {
+					appctx.GetLogger(ctx).Warn().Err(err).Str("backend", b.name).Msg("composite: backend request failed")
+				}
+				return
+			}
+			results[i], ok[i] = r, true
+		}(i, b)
+	}
+	wg.Wait()
+
+	res := make([]T, 0, len(backends))
+	for i := range results {
+		if ok[i] {
+			res = append(res, results[i])
+		}
+	}
+	return res
+}
+
+// key identifies a group across the backends
+func key(gid *grouppb.GroupId) string {
+	return gid.GetIdp() + "!" + gid.GetOpaqueId()
+}
+
+// knows returns NotFound if the backend does not have the group. A group with the same opaque id
+// from another idp is a different group.
+func knows(ctx context.Context, b *backend, gid *grouppb.GroupId) error {
+	if gid.GetIdp() == "" {
+		return nil
+	}
+	g, err := b.GetGroup(ctx, gid, true)
+	if err != nil {
+		return err
+	}
+	if idp := g.GetId().GetIdp(); idp != "" && idp != gid.GetIdp() {
+		return errtypes.NotFound(gid.GetOpaqueId())
+	}
+	return nil
+}
+
+func (m *manager) GetGroup(ctx context.Context, gid *grouppb.GroupId, skipFetchingMembers bool) (*grouppb.Group, error) {
+	g, err := first(ctx, m.route(gid), func(ctx context.Context, b *backend) (*grouppb.Group, error) {
+		return b.GetGroup(ctx, gid, true)
+	})
+	if err != nil || skipFetchingMembers {
+		return g, err
+	}
+	g.Members, err = m.GetMembers(ctx, g.Id)
+	return g, err
+}
+
+func (m *manager) GetGroupByClaim(ctx context.Context, claim, value string, skipFetchingMembers bool) (*grouppb.Group, error) {
+	g, err := first(ctx, m.backends, func(ctx context.Context, b *backend) (*grouppb.Group, error) {
+		return b.GetGroupByClaim(ctx, claim, value, true)
+	})
+	if err != nil || skipFetchingMembers {
+		return g, err
+	}
+	g.Members, err = m.GetMembers(ctx, g.Id)
+	return g, err
+}
+
+// FindGroups merges the groups found by all backends. Groups with the same id are only returned once.
+func (m *manager) FindGroups(ctx context.Context, query string, skipFetchingMembers bool) ([]*grouppb.Group, error) {
+	results := all(ctx, m.backends, func(ctx context.Context, b *backend) ([]*grouppb.Group, error) {
+		return b.FindGroups(ctx, query, true)
+	})
+	groups := []*grouppb.Group{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		for _, g := range r {
+			if seen[key(g.GetId())] {
+				continue
+			}
+			seen[key(g.GetId())] = true
+			if !skipFetchingMembers {
+				members, err := m.GetMembers(ctx, g.Id)
+				if err != nil {
+					return nil, err
+				}
+				g.Members = members
+			}
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+// GetMembers merges the members all backends know for the group.
+func (m *manager) GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error) {
+	results := all(ctx, m.backends, func(ctx context.Context, b *backend) ([]*userpb.UserId, error) {
+		if err := knows(ctx, b, gid); err != nil {
+			return nil, err
+		}
+		return b.GetMembers(ctx, gid)
+	})
+	if len(results) == 0 {
+		return nil, errtypes.NotFound(gid.GetOpaqueId())
+	}
+	members := []*userpb.UserId{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		for _, u := range r {
+			key := u.GetIdp() + "!" + u.GetOpaqueId()
+			if !seen[key] {
+				seen[key] = true
+				members = append(members, u)
+			}
+		}
+	}
+	return members, nil
+}
+
+// HasMember returns true if any backend knows the user as member of the group.
+func (m *manager) HasMember(ctx context.Context, gid *grouppb.GroupId, uid *userpb.UserId) (bool, error) {
+	results := all(ctx, m.backends, func(ctx context.Context, b *backend) (bool, error) {
+		if err := knows(ctx, b, gid); err != nil {
+			return false, err
+		}
+		return b.HasMember(ctx, gid, uid)
+	})
+	for _, r := range results {
+		if r {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package composite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/group"
+	"github.com/opencloud-eu/reva/v2/pkg/group/manager/registry"
+
+	_ "github.com/opencloud-eu/reva/v2/pkg/group/manager/json"
+)
+
+// hangingManager never answers and ignores the context
+type hangingManager struct {
+	group.Manager
+}
+
+func (hangingManager) FindGroups(context.Context, string, bool) ([]*grouppb.Group, error) {
+	select {}
+}
+
+func init() {
+	registry.Register("hanging", func(map[string]interface{}) (group.Manager, error) {
+		return hangingManager{}, nil
+	})
+}
+
+func jsonBackend(t *testing.T, content string) map[string]interface{} {
+	file := filepath.Join(t.TempDir(), "groups.json")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{"driver": "json", "config": map[string]interface{}{"groups": file}}
+}
+
+func TestCompositeGroupManager(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := New(map[string]interface{}{
+		"backends": []map[string]interface{}{
+			jsonBackend(t, `[{"id":{"opaque_id":"physics-lovers"},"group_name":"physics-lovers","members":[{"idp":"ldap","opaque_id":"einstein"}]},{"id":{"opaque_id":"violin-haters"},"group_name":"violin-haters"}]`),
+			jsonBackend(t, `[{"id":{"opaque_id":"physics-lovers"},"group_name":"physics-guests","members":[{"idp":"guests","opaque_id":"marie"},{"idp":"ldap","opaque_id":"einstein"}]},{"id":{"opaque_id":"guests"},"group_name":"guests"}]`),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := mgr.GetGroup(ctx, &grouppb.GroupId{OpaqueId: "physics-lovers"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GroupName != "physics-lovers" || len(g.Members) != 2 {
+		t.Fatalf("expected the first backend's group with merged members, got %v", g)
+	}
+
+	ok, err := mgr.HasMember(ctx, &grouppb.GroupId{OpaqueId: "physics-lovers"}, &userpb.UserId{Idp: "guests", OpaqueId: "marie"})
+	if err != nil || !ok {
+		t.Fatalf("expected the guest to be a member, got %v, %v", ok, err)
+	}
+
+	groups, err := mgr.FindGroups(ctx, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 distinct groups, got %d", len(groups))
+	}
+
+	if _, err := mgr.GetMembers(ctx, &grouppb.GroupId{OpaqueId: "unknown"}); err == nil {
+		t.Fatal("expected an error for an unknown group")
+	}
+}
+
+func TestCompositeGroupManagerIdps(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := New(map[string]interface{}{
+		"backends": []map[string]interface{}{
+			jsonBackend(t, `[{"id":{"idp":"ldap","opaque_id":"staff"},"group_name":"staff","members":[{"idp":"ldap","opaque_id":"einstein"}]}]`),
+			jsonBackend(t, `[{"id":{"idp":"guests","opaque_id":"staff"},"group_name":"guest-staff","members":[{"idp":"guests","opaque_id":"marie"}]}]`),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := mgr.GetMembers(ctx, &grouppb.GroupId{Idp: "ldap", OpaqueId: "staff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].OpaqueId != "einstein" {
+		t.Errorf("expected only the members of the ldap group, got %v", members)
+	}
+	groups, err := mgr.FindGroups(ctx, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Errorf("expected the groups of both idps, got %d", len(groups))
+	}
+}
+
+func TestCompositeGroupManagerTimeout(t *testing.T) {
+	mgr, err := New(map[string]interface{}{
+		"backends": []map[string]interface{}{
+			jsonBackend(t, `[{"id":{"opaque_id":"staff"},"group_name":"staff"}]`),
+			{"driver": "hanging", "timeout": 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// backends that do not answer in time are skipped
+	start := time.Now()
+	groups, err := mgr.FindGroups(context.Background(), "", true)
+	if err != nil || len(groups) != 1 {
+		t.Fatalf("expected the group of the answering backend, got %v, %v", groups, err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Error("expected the hanging backend not to be waited for")
+	}
+
+	// a canceled request returns immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if groups, _ := mgr.FindGroups(ctx, "", true); len(groups) != 0 {
+		t.Errorf("expected no groups for a canceled request, got %v", groups)
+	}
+}
@@ -22,11 +22,14 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/google/uuid"
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/group"
@@ -40,6 +43,9 @@ func init() {
 }
 
 type manager struct {
+	sync.RWMutex
+	file   string
+	info   os.FileInfo
 	groups []*grouppb.Group
 }
 
@@ -71,24 +77,80 @@ func New(m map[string]interface{}) (group.Manager, error) {
 		return nil, err
 	}
 
-	f, err := os.ReadFile(c.Groups)
-	if err != nil {
+	mgr := &manager{
+		file: c.Groups,
+	}
+	if err := mgr.load(); err != nil {
 		return nil, err
 	}
+	return mgr, nil
+}
+
+// load reads the groups file if it was changed since it was last read
+func (m *manager) load() error {
+	info, err := os.Stat(m.file)
+	if err != nil {
+		return err
+	}
+	// persist replaces the file, so a changed file is detected even if the modification time did not change
+	if m.groups != nil && os.SameFile(info, m.info) && info.ModTime().Equal(m.info.ModTime()) {
+		return nil
+	}
+
+	f, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
 
 	groups := []*grouppb.Group{}
 
 	err = json.Unmarshal(f, &groups)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	m.groups = groups
+	m.info = info
+	return nil
+}
 
-	return &manager{
-		groups: groups,
-	}, nil
+// reload picks up changes other instances made to the groups file
+func (m *manager) reload() {
+	m.Lock()
+	defer m.Unlock()
+	_ = m.load()
+}
+
+// persist atomically writes the groups to the groups file
+func (m *manager) persist() error {
+	b, err := json.MarshalIndent(m.groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), filepath.Base(m.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), m.file); err != nil {
+		return err
+	}
+	if info, err := os.Stat(m.file); err == nil {
+		m.info = info
+	}
+	return nil
 }
 
 func (m *manager) GetGroup(ctx context.Context, gid *grouppb.GroupId, skipFetchingMembers bool) (*grouppb.Group, error) {
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	for _, g := range m.groups {
 		if (g.Id.GetOpaqueId() == gid.OpaqueId || g.GroupName == gid.OpaqueId) && (gid.Idp == "" || gid.Idp == g.Id.GetIdp()) {
 			group := proto.Clone(g).(*grouppb.Group)
@@ -102,6 +164,9 @@ func (m *manager) GetGroup(ctx context.Context, gid *grouppb.GroupId, skipFetchi
 }
 
 func (m *manager) GetGroupByClaim(ctx context.Context, claim, value string, skipFetchingMembers bool) (*grouppb.Group, error) {
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	for _, g := range m.groups {
 		if groupClaim, err := extractClaim(g, claim); err == nil && value == groupClaim {
 			group := proto.Clone(g).(*grouppb.Group)
@@ -129,6 +194,9 @@ func extractClaim(g *grouppb.Group, claim string) (string, error) {
 }
 
 func (m *manager) FindGroups(ctx context.Context, query string, skipFetchingMembers bool) ([]*grouppb.Group, error) {
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	groups := []*grouppb.Group{}
 	for _, g := range m.groups {
 		if groupContains(g, query) {
@@ -149,9 +217,16 @@ func groupContains(g *grouppb.Group, query string) bool {
 }
 
 func (m *manager) GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error) {
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	for _, g := range m.groups {
 		if g.Id.GetOpaqueId() == gid.OpaqueId || g.GroupName == gid.OpaqueId {
-			return g.Members, nil
+			members := make([]*userpb.UserId, 0, len(g.Members))
+			for _, u := range g.Members {
+				members = append(members, proto.Clone(u).(*userpb.UserId))
+			}
+			return members, nil
 		}
 	}
 	return nil, errtypes.NotFound(gid.OpaqueId)
@@ -170,3 +245,151 @@ func (m *manager) HasMember(ctx context.Context, gid *grouppb.GroupId, uid *user
 	}
 	return false, nil
 }
+
+// CreateGroup implements the group.Writer interface
+func (m *manager) CreateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error) {
+	if g.GroupName == "" {
+		return nil, errtypes.BadRequest("group name must not be empty")
+	}
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	g = proto.Clone(g).(*grouppb.Group)
+	if g.Id == nil {
+		g.Id = &grouppb.GroupId{}
+	}
+	if g.Id.OpaqueId == "" {
+		g.Id.OpaqueId = uuid.New().String()
+	}
+	for _, existing := range m.groups {
+		if existing.Id.GetOpaqueId() == g.Id.OpaqueId || existing.GroupName == g.GroupName {
+			return nil, errtypes.AlreadyExists(g.GroupName)
+		}
+	}
+
+	m.groups = append(m.groups, g)
+	if err := m.persist(); err != nil {
+		m.groups = m.groups[:len(m.groups)-1]
+		return nil, err
+	}
+	return proto.Clone(g).(*grouppb.Group), nil
+}
+
+// UpdateGroup implements the group.Writer interface
+func (m *manager) UpdateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	i := m.indexOf(g.GetId())
+	if i < 0 {
+		return nil, errtypes.NotFound(g.GetId().GetOpaqueId())
+	}
+	for j, existing := range m.groups {
+		if j != i && g.GroupName != "" && existing.GroupName == g.GroupName {
+			return nil, errtypes.AlreadyExists(g.GroupName)
+		}
+	}
+
+	old := m.groups[i]
+	updated := proto.Clone(g).(*grouppb.Group)
+	updated.Id = old.Id
+	updated.Members = old.Members
+	if updated.GroupName == "" {
+		updated.GroupName = old.GroupName
+	}
+	m.groups[i] = updated
+	if err := m.persist(); err != nil {
+		m.groups[i] = old
+		return nil, err
+	}
+	return proto.Clone(updated).(*grouppb.Group), nil
+}
+
+// DeleteGroup implements the group.Writer interface
+func (m *manager) DeleteGroup(ctx context.Context, gid *grouppb.GroupId) error {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return err
+	}
+
+	i := m.indexOf(gid)
+	if i < 0 {
+		return errtypes.NotFound(gid.GetOpaqueId())
+	}
+	groups := m.groups
+	m.groups = append(append([]*grouppb.Group{}, groups[:i]...), groups[i+1:]...)
+	if err := m.persist(); err != nil {
+		m.groups = groups
+		return err
+	}
+	return nil
+}
+
+// AddMembers implements the group.Writer interface
+func (m *manager) AddMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error {
+	return m.updateMembers(gid, func(g *grouppb.Group) {
+		for _, u := range members {
+			if indexOfMember(g.Members, u) < 0 {
+				g.Members = append(g.Members, proto.Clone(u).(*userpb.UserId))
+			}
+		}
+	})
+}
+
+// RemoveMembers implements the group.Writer interface
+func (m *manager) RemoveMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error {
+	return m.updateMembers(gid, func(g *grouppb.Group) {
+		for _, u := range members {
+			if i := indexOfMember(g.Members, u); i >= 0 {
+				g.Members = append(g.Members[:i], g.Members[i+1:]...)
+			}
+		}
+	})
+}
+
+func (m *manager) updateMembers(gid *grouppb.GroupId, update func(*grouppb.Group)) error {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return err
+	}
+
+	i := m.indexOf(gid)
+	if i < 0 {
+		return errtypes.NotFound(gid.GetOpaqueId())
+	}
+	old := m.groups[i]
+	updated := proto.Clone(old).(*grouppb.Group)
+	update(updated)
+	m.groups[i] = updated
+	if err := m.persist(); err != nil {
+		m.groups[i] = old
+		return err
+	}
+	return nil
+}
+
+func (m *manager) indexOf(gid *grouppb.GroupId) int {
+	for i, g := range m.groups {
+		if g.Id.GetOpaqueId() == gid.GetOpaqueId() && (gid.GetIdp() == "" || gid.GetIdp() == g.Id.GetIdp()) {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfMember(members []*userpb.UserId, uid *userpb.UserId) int {
+	for i, u := range members {
+		if u.OpaqueId == uid.GetOpaqueId() && u.Idp == uid.GetIdp() {
+			return i
+		}
+	}
+	return -1
+}
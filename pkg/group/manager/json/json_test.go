@@ -28,6 +28,7 @@ import (
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/google/go-cmp/cmp"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/group"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 )
@@ -172,3 +173,61 @@ func TestUserManager(t *testing.T) {
 		t.Fatalf("group differ: expected=%v got=%v", "sailing-lovers", resFind[0].GroupName)
 	}
 }
+
+func TestGroupWriter(t *testing.T) {
+	file := t.TempDir() + "/groups.json"
+	if err := os.WriteFile(file, []byte(`[]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := New(map[string]interface{}{"groups": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := mgr.(group.Writer)
+
+	created, err := w.CreateGroup(ctx, &grouppb.Group{GroupName: "physics-lovers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.CreateGroup(ctx, &grouppb.Group{GroupName: "physics-lovers"}); !reflect.DeepEqual(err, errtypes.AlreadyExists("physics-lovers")) {
+		t.Fatalf("expected already exists error, got %v", err)
+	}
+
+	einstein := &userpb.UserId{Idp: "localhost", OpaqueId: "einstein"}
+	marie := &userpb.UserId{Idp: "localhost", OpaqueId: "marie"}
+	if err := w.AddMembers(ctx, created.Id, []*userpb.UserId{einstein, marie, einstein}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RemoveMembers(ctx, created.Id, []*userpb.UserId{marie}); err != nil {
+		t.Fatal(err)
+	}
+
+	created.DisplayName = "Physics Lovers"
+	updated, err := w.UpdateGroup(ctx, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Members) != 1 {
+		t.Fatalf("expected update to keep the members, got %v", updated.Members)
+	}
+
+	// a second instance reads the persisted changes
+	other, err := New(map[string]interface{}{"groups": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err := other.GetMembers(ctx, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*userpb.UserId{einstein}, members, protocmp.Transform()); diff != "" {
+		t.Fatalf("unexpected members: %s", diff)
+	}
+
+	if err := w.DeleteGroup(ctx, created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.GetGroup(ctx, created.Id, false); !reflect.DeepEqual(err, errtypes.NotFound(created.Id.OpaqueId)) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
@@ -52,6 +53,10 @@ type config struct {
 	Idp            string                `mapstructure:"idp"`
 	// Nobody specifies the fallback gid number for groups that don't have a gidNumber set in LDAP
 	Nobody int64 `mapstructure:"nobody"`
+	// WriteEnabled allows creating, updating and deleting groups and changing their members
+	WriteEnabled bool `mapstructure:"write_enabled"`
+	// CreateObjectClasses are the object classes of newly created groups. The configured group_objectclass is always added.
+	CreateObjectClasses []string `mapstructure:"group_create_objectclasses"`
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -324,3 +329,219 @@ func (m *manager) ldapEntryToUserID(entry *ldap.Entry) (*userpb.UserId, error) {
 		Type:     userpb.UserType_USER_TYPE_PRIMARY,
 	}, nil
 }
+
+// CreateGroup implements the group.Writer interface. The entry is created below the group base dn
+// using the group name as RDN.
+func (m *manager) CreateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error) {
+	if !m.c.WriteEnabled {
+		return nil, errtypes.NotSupported("ldap: write support is disabled")
+	}
+	if g.GroupName == "" {
+		return nil, errtypes.BadRequest("group name must not be empty")
+	}
+	log := appctx.GetLogger(ctx)
+	if _, err := m.c.LDAPIdentity.GetLDAPGroupByAttribute(log, m.ldapClient, "group_name", g.GroupName); err == nil {
+		return nil, errtypes.AlreadyExists(g.GroupName)
+	}
+
+	schema := m.c.LDAPIdentity.Group.Schema
+	dn := fmt.Sprintf("%s=%s,%s", schema.Groupname, ldap.EscapeDN(g.GroupName), m.c.LDAPIdentity.Group.BaseDN)
+	ar := ldap.NewAddRequest(dn, nil)
+	ar.Attribute("objectClass", m.objectClasses())
+
+	attrs := map[string]string{
+		schema.Groupname: g.GroupName,
+	}
+	setIfEmpty := func(attr, value string) {
+		if _, ok := attrs[attr]; !ok && value != "" {
+			attrs[attr] = value
+		}
+	}
+	setIfEmpty(schema.DisplayName, g.DisplayName)
+	setIfEmpty(schema.Mail, g.Mail)
+	if g.GidNumber != 0 {
+		setIfEmpty(schema.GIDNumber, strconv.FormatInt(g.GidNumber, 10))
+	}
+	for attr, value := range attrs {
+		ar.Attribute(attr, []string{value})
+	}
+	if !m.isPosixGroup() {
+		// groupOfNames and similar classes require at least one member
+		ar.Attribute(schema.Member, []string{""})
+	}
+	if !isOperationalID(schema.ID) {
+		id := uuid.New()
+		if g.GetId().GetOpaqueId() != "" {
+			var err error
+			if id, err = uuid.Parse(g.GetId().GetOpaqueId()); err != nil {
+				return nil, errtypes.BadRequest("ldap: group ids must be uuids")
+			}
+		}
+		if schema.IDIsOctetString {
+			b, _ := id.MarshalBinary()
+			ar.Attribute(schema.ID, []string{string(b)})
+		} else {
+			ar.Attribute(schema.ID, []string{id.String()})
+		}
+	}
+
+	if err := m.ldapClient.Add(ar); err != nil {
+		log.Debug().Err(err).Str("dn", dn).Msg("error creating group")
+		return nil, ldapError(err, g.GroupName)
+	}
+	return m.GetGroupByClaim(ctx, "group_name", g.GroupName, true)
+}
+
+// UpdateGroup implements the group.Writer interface. Renaming groups is not supported.
+func (m *manager) UpdateGroup(ctx context.Context, g *grouppb.Group) (*grouppb.Group, error) {
+	if !m.c.WriteEnabled {
+		return nil, errtypes.NotSupported("ldap: write support is disabled")
+	}
+	log := appctx.GetLogger(ctx)
+	entry, err := m.c.LDAPIdentity.GetLDAPGroupByID(log, m.ldapClient, g.GetId().GetOpaqueId())
+	if err != nil {
+		return nil, err
+	}
+	schema := m.c.LDAPIdentity.Group.Schema
+	if g.GroupName != "" && g.GroupName != entry.GetEqualFoldAttributeValue(schema.Groupname) {
+		return nil, errtypes.NotSupported("ldap: groups can not be renamed")
+	}
+
+	mr := ldap.NewModifyRequest(entry.DN, nil)
+	replace := func(attr, value string) {
+		switch {
+		case attr == schema.Groupname:
+		case value != "":
+			mr.Replace(attr, []string{value})
+		case entry.GetEqualFoldAttributeValue(attr) != "":
+			mr.Delete(attr, nil)
+		}
+	}
+	replace(schema.DisplayName, g.DisplayName)
+	replace(schema.Mail, g.Mail)
+	if g.GidNumber != 0 {
+		replace(schema.GIDNumber, strconv.FormatInt(g.GidNumber, 10))
+	}
+	if len(mr.Changes) > 0 {
+		if err := m.ldapClient.Modify(mr); err != nil {
+			log.Debug().Err(err).Str("dn", entry.DN).Msg("error updating group")
+			return nil, ldapError(err, g.GetId().GetOpaqueId())
+		}
+	}
+	return m.GetGroup(ctx, g.Id, true)
+}
+
+// DeleteGroup implements the group.Writer interface
+func (m *manager) DeleteGroup(ctx context.Context, gid *grouppb.GroupId) error {
+	if !m.c.WriteEnabled {
+		return errtypes.NotSupported("ldap: write support is disabled")
+	}
+	log := appctx.GetLogger(ctx)
+	entry, err := m.c.LDAPIdentity.GetLDAPGroupByID(log, m.ldapClient, gid.GetOpaqueId())
+	if err != nil {
+		return err
+	}
+	if err := m.ldapClient.Del(ldap.NewDelRequest(entry.DN, nil)); err != nil {
+		log.Debug().Err(err).Str("dn", entry.DN).Msg("error deleting group")
+		return ldapError(err, gid.GetOpaqueId())
+	}
+	return nil
+}
+
+// AddMembers implements the group.Writer interface
+func (m *manager) AddMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error {
+	return m.updateMembers(ctx, gid, members, true)
+}
+
+// RemoveMembers implements the group.Writer interface
+func (m *manager) RemoveMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId) error {
+	return m.updateMembers(ctx, gid, members, false)
+}
+
+func (m *manager) updateMembers(ctx context.Context, gid *grouppb.GroupId, members []*userpb.UserId, add bool) error {
+	if !m.c.WriteEnabled {
+		return errtypes.NotSupported("ldap: write support is disabled")
+	}
+	log := appctx.GetLogger(ctx)
+	entry, err := m.c.LDAPIdentity.GetLDAPGroupByID(log, m.ldapClient, gid.GetOpaqueId())
+	if err != nil {
+		return err
+	}
+
+	memberAttr := m.c.LDAPIdentity.Group.Schema.Member
+	current := entry.GetEqualFoldAttributeValues(memberAttr)
+	values := []string{}
+	for _, uid := range members {
+		userEntry, err := m.c.LDAPIdentity.GetLDAPUserByID(log, m.ldapClient, uid.GetOpaqueId())
+		if err != nil {
+			if _, ok := err.(errtypes.NotFound); ok && !add {
+				continue
+			}
+			return err
+		}
+		value := userEntry.DN
+		if m.isPosixGroup() {
+			value = userEntry.GetEqualFoldAttributeValue(m.c.LDAPIdentity.User.Schema.Username)
+		}
+		if containsFold(current, value) != add && !containsFold(values, value) {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	mr := ldap.NewModifyRequest(entry.DN, nil)
+	if add {
+		mr.Add(memberAttr, values)
+	} else {
+		mr.Delete(memberAttr, values)
+	}
+	if err := m.ldapClient.Modify(mr); err != nil {
+		log.Debug().Err(err).Str("dn", entry.DN).Msg("error updating group members")
+		return ldapError(err, gid.GetOpaqueId())
+	}
+	return nil
+}
+
+func (m *manager) isPosixGroup() bool {
+	return strings.EqualFold(m.c.LDAPIdentity.Group.Objectclass, "posixGroup")
+}
+
+func (m *manager) objectClasses() []string {
+	classes := append([]string{}, m.c.CreateObjectClasses...)
+	if containsFold(classes, m.c.LDAPIdentity.Group.Objectclass) {
+		return classes
+	}
+	return append(classes, m.c.LDAPIdentity.Group.Objectclass)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOperationalID returns true for id attributes that are generated by the server
+func isOperationalID(attr string) bool {
+	switch strings.ToLower(attr) {
+	case "entryuuid", "objectguid", "nsuniqueid", "ipauniqueid":
+		return true
+	}
+	return false
+}
+
+func ldapError(err error, id string) error {
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists):
+		return errtypes.AlreadyExists(id)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject):
+		return errtypes.NotFound(id)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights):
+		return errtypes.PermissionDenied(id)
+	}
+	return err
+}
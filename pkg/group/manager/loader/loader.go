@@ -20,6 +20,7 @@ package loader
 
 import (
 	// Load core group manager drivers.
+	_ "github.com/opencloud-eu/reva/v2/pkg/group/manager/composite"
 	_ "github.com/opencloud-eu/reva/v2/pkg/group/manager/json"
 	_ "github.com/opencloud-eu/reva/v2/pkg/group/manager/ldap"
 	// Add your own here
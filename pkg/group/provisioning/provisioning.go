@@ -0,0 +1,244 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package provisioning defines the grpc api to create, update and delete groups and to change their members,
+// which the CS3 group api has no calls for.
+//
+// The api reuses the messages of the CS3 group api: creating and updating take the group and return it in a
+// GetGroupResponse, deleting takes a GetGroupRequest with the id of the group. Adding and removing members
+// take a group with the id and the members to add or remove. Providers whose driver does not allow writing
+// answer with an unimplemented status.
+package provisioning
+
+import (
+	"context"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the full name of the grpc service
+const ServiceName = "revad.identity.group.v1beta1.ProvisioningAPI"
+
+const (
+	createMethod        = "/" + ServiceName + "/CreateGroup"
+	updateMethod        = "/" + ServiceName + "/UpdateGroup"
+	deleteMethod        = "/" + ServiceName + "/DeleteGroup"
+	addMembersMethod    = "/" + ServiceName + "/AddMembers"
+	removeMembersMethod = "/" + ServiceName + "/RemoveMembers"
+)
+
+// ProvisioningAPIServer is the server api for provisioning groups
+type ProvisioningAPIServer interface {
+	// CreateGroup creates the group and returns it including the generated id
+	CreateGroup(context.Context, *grouppb.Group) (*grouppb.GetGroupResponse, error)
+	// UpdateGroup replaces the attributes of the group identified by its id, members are not changed
+	UpdateGroup(context.Context, *grouppb.Group) (*grouppb.GetGroupResponse, error)
+	// DeleteGroup deletes the group, the response contains no group
+	DeleteGroup(context.Context, *grouppb.GetGroupRequest) (*grouppb.GetGroupResponse, error)
+	// AddMembers adds the members of the request to the group identified by its id
+	AddMembers(context.Context, *grouppb.Group) (*grouppb.GetGroupResponse, error)
+	// RemoveMembers removes the members of the request from the group identified by its id
+	RemoveMembers(context.Context, *grouppb.Group) (*grouppb.GetGroupResponse, error)
+}
+
+// ProvisioningAPIClient is the client api for provisioning groups
+type ProvisioningAPIClient interface {
+	// CreateGroup creates the group and returns it including the generated id
+	CreateGroup(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error)
+	// UpdateGroup replaces the attributes of the group identified by its id, members are not changed
+	UpdateGroup(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error)
+	// DeleteGroup deletes the group, the response contains no group
+	DeleteGroup(ctx context.Context, in *grouppb.GetGroupRequest, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error)
+	// AddMembers adds the members of the request to the group identified by its id
+	AddMembers(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error)
+	// RemoveMembers removes the members of the request from the group identified by its id
+	RemoveMembers(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error)
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewProvisioningAPIClient returns a new client for the provisioning api
+func NewProvisioningAPIClient(cc grpc.ClientConnInterface) ProvisioningAPIClient {
+	return &client{cc: cc}
+}
+
+func (c *client) CreateGroup(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error) {
+	out := new(grouppb.GetGroupResponse)
+	if err := c.cc.Invoke(ctx, createMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) UpdateGroup(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error) {
+	out := new(grouppb.GetGroupResponse)
+	if err := c.cc.Invoke(ctx, updateMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) DeleteGroup(ctx context.Context, in *grouppb.GetGroupRequest, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error) {
+	out := new(grouppb.GetGroupResponse)
+	if err := c.cc.Invoke(ctx, deleteMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) AddMembers(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error) {
+	out := new(grouppb.GetGroupResponse)
+	if err := c.cc.Invoke(ctx, addMembersMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) RemoveMembers(ctx context.Context, in *grouppb.Group, opts ...grpc.CallOption) (*grouppb.GetGroupResponse, error) {
+	out := new(grouppb.GetGroupResponse)
+	if err := c.cc.Invoke(ctx, removeMembersMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterProvisioningAPIServer registers the provisioning api with the grpc server
+func RegisterProvisioningAPIServer(s grpc.ServiceRegistrar, srv ProvisioningAPIServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ProvisioningAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateGroup",
+			Handler:    createHandler,
+		},
+		{
+			MethodName: "UpdateGroup",
+			Handler:    updateHandler,
+		},
+		{
+			MethodName: "DeleteGroup",
+			Handler:    deleteHandler,
+		},
+		{
+			MethodName: "AddMembers",
+			Handler:    addMembersHandler,
+		},
+		{
+			MethodName: "RemoveMembers",
+			Handler:    removeMembersHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func createHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(grouppb.Group)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).CreateGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: createMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).CreateGroup(ctx, req.(*grouppb.Group))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func updateHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(grouppb.Group)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).UpdateGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: updateMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).UpdateGroup(ctx, req.(*grouppb.Group))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func deleteHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(grouppb.GetGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).DeleteGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: deleteMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).DeleteGroup(ctx, req.(*grouppb.GetGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func addMembersHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(grouppb.Group)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).AddMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: addMembersMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).AddMembers(ctx, req.(*grouppb.Group))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func removeMembersHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(grouppb.Group)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).RemoveMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: removeMembersMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).RemoveMembers(ctx, req.(*grouppb.Group))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -0,0 +1,428 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	ocm "github.com/cs3org/go-cs3apis/cs3/sharing/ocm/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/ocm/share"
+	"github.com/opencloud-eu/reva/v2/pkg/ocm/share/repository/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/cfg"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+)
+
+// This module implements the share.Repository interface on top of a sqlite or postgres database.
+//
+// The OCM shares are saved in the table:
+//     ocm_shares(*id*, token, storage_id, space_id, opaque_id, owner, creator, grantee, expiration, data)
+//
+// The received OCM shares are saved in the table:
+//     ocm_received_shares(*id*, grantee, owner, creator, state, data)
+//
+// data holds the json encoded share, the other columns are extracted from it so they can be indexed.
+// Local users are referenced by their opaque id, remote grantees by their opaque id and idp.
+
+func init() {
+	registry.Register("sql", New)
+}
+
+var migrations = []sqldb.Migration{
+	{
+		`CREATE TABLE ocm_shares (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			token VARCHAR(255) NOT NULL,
+			storage_id VARCHAR(255) NOT NULL,
+			space_id VARCHAR(255) NOT NULL,
+			opaque_id VARCHAR(255) NOT NULL,
+			owner VARCHAR(255) NOT NULL,
+			creator VARCHAR(255) NOT NULL,
+			grantee VARCHAR(512) NOT NULL,
+			expiration BIGINT,
+			data TEXT NOT NULL
+		)`,
+		"CREATE UNIQUE INDEX ocm_shares_resource_grantee ON ocm_shares (storage_id, space_id, opaque_id, grantee)",
+		"CREATE INDEX ocm_shares_token ON ocm_shares (token)",
+		"CREATE INDEX ocm_shares_owner ON ocm_shares (owner)",
+		"CREATE INDEX ocm_shares_creator ON ocm_shares (creator)",
+		"CREATE INDEX ocm_shares_grantee ON ocm_shares (grantee)",
+		`CREATE TABLE ocm_received_shares (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			grantee VARCHAR(255) NOT NULL,
+			owner VARCHAR(512) NOT NULL,
+			creator VARCHAR(512) NOT NULL,
+			state INTEGER NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		"CREATE INDEX ocm_received_shares_grantee ON ocm_received_shares (grantee)",
+	},
+}
+
+type config struct {
+	DB sqldb.Config `mapstructure:",squash"`
+}
+
+type mgr struct {
+	db *sql.DB
+}
+
+// New returns a new sql repository for ocm shares.
+func New(m map[string]interface{}) (share.Repository, error) {
+	var c config
+	if err := cfg.Decode(m, &c); err != nil {
+		return nil, err
+	}
+
+	db, err := sqldb.Open(c.DB)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithDB(db)
+}
+
+// NewWithDB returns a new sql repository for ocm shares using the given database. The schema is migrated on startup.
+func NewWithDB(db *sql.DB) (share.Repository, error) {
+	if err := sqldb.Migrate(context.Background(), db, "ocm_shares", migrations); err != nil {
+		return nil, err
+	}
+	return &mgr{db: db}, nil
+}
+
+// formatUserID includes the idp, remote users with the same opaque id may exist on several servers
+func formatUserID(u *userpb.UserId) string {
+	return u.GetOpaqueId() + "@" + u.GetIdp()
+}
+
+func granteeID(g *provider.Grantee) string {
+	if g.GetType() == provider.GranteeType_GRANTEE_TYPE_USER {
+		return formatUserID(g.GetUserId())
+	}
+	return g.GetGroupId().GetOpaqueId() + "@" + g.GetGroupId().GetIdp()
+}
+
+func isOwnerOrCreator(u *userpb.User, s *ocm.Share) bool {
+	return utils.UserEqual(u.GetId(), s.GetOwner()) || utils.UserEqual(u.GetId(), s.GetCreator())
+}
+
+func (m *mgr) queryShares(ctx context.Context, where string, args ...interface{}) ([]*ocm.Share, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT data FROM ocm_shares WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []*ocm.Share
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var s ocm.Share
+		if err := utils.UnmarshalJSONToProtoV1([]byte(data), &s); err != nil {
+			return nil, err
+		}
+		shares = append(shares, &s)
+	}
+	return shares, rows.Err()
+}
+
+func (m *mgr) queryReceivedShares(ctx context.Context, where string, args ...interface{}) ([]*ocm.ReceivedShare, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT data FROM ocm_received_shares WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []*ocm.ReceivedShare
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var s ocm.ReceivedShare
+		if err := utils.UnmarshalJSONToProtoV1([]byte(data), &s); err != nil {
+			return nil, err
+		}
+		shares = append(shares, &s)
+	}
+	return shares, rows.Err()
+}
+
+func (m *mgr) StoreShare(ctx context.Context, ocmshare *ocm.Share) (*ocm.Share, error) {
+	ocmshare.Id = &ocm.ShareId{OpaqueId: uuid.New().String()}
+	data, err := utils.MarshalProtoV1ToJSON(ocmshare)
+	if err != nil {
+		return nil, errtypes.InternalError("failed to marshal ocm share")
+	}
+	var expiration sql.NullInt64
+	if e := ocmshare.GetExpiration(); e != nil {
+		expiration = sql.NullInt64{Int64: int64(e.GetSeconds()), Valid: true}
+	}
+	rid := ocmshare.GetResourceId()
+	_, err = m.db.ExecContext(ctx, "INSERT INTO ocm_shares (id, token, storage_id, space_id, opaque_id, owner, creator, grantee, expiration, data)"+
+		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		ocmshare.GetId().GetOpaqueId(), ocmshare.GetToken(), rid.GetStorageId(), rid.GetSpaceId(), rid.GetOpaqueId(),
+		ocmshare.GetOwner().GetOpaqueId(), ocmshare.GetCreator().GetOpaqueId(), granteeID(ocmshare.GetGrantee()), expiration, string(data))
+	if err != nil {
+		if sqldb.IsUniqueViolation(err) {
+			return nil, share.ErrShareAlreadyExisting
+		}
+		return nil, err
+	}
+	return ocmshare, nil
+}
+
+func (m *mgr) get(ctx context.Context, ref *ocm.ShareReference) (*ocm.Share, error) {
+	var (
+		shares []*ocm.Share
+		err    error
+	)
+	switch {
+	case ref.GetId() != nil:
+		shares, err = m.queryShares(ctx, "id = $1", ref.GetId().GetOpaqueId())
+	case ref.GetKey() != nil:
+		key := ref.GetKey()
+		rid := key.GetResourceId()
+		shares, err = m.queryShares(ctx, "storage_id = $1 AND space_id = $2 AND opaque_id = $3 AND grantee = $4",
+			rid.GetStorageId(), rid.GetSpaceId(), rid.GetOpaqueId(), granteeID(key.GetGrantee()))
+		if err == nil && len(shares) > 0 && !utils.UserEqual(key.GetOwner(), shares[0].GetOwner()) && !utils.UserEqual(key.GetOwner(), shares[0].GetCreator()) {
+			shares = nil
+		}
+	case ref.GetToken() != "":
+		shares, err = m.queryShares(ctx, "token = $1", ref.GetToken())
+	default:
+		return nil, errtypes.NotFound(ref.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, share.ErrShareNotFound
+	}
+	return shares[0], nil
+}
+
+func (m *mgr) GetShare(ctx context.Context, user *userpb.User, ref *ocm.ShareReference) (*ocm.Share, error) {
+	s, err := m.get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// shares are looked up by token on behalf of the remote grantee
+	if ref.GetToken() != "" || isOwnerOrCreator(user, s) {
+		return s, nil
+	}
+	return nil, share.ErrShareNotFound
+}
+
+func (m *mgr) DeleteShare(ctx context.Context, user *userpb.User, ref *ocm.ShareReference) error {
+	s, err := m.get(ctx, ref)
+	if err != nil || !isOwnerOrCreator(user, s) {
+		return errtypes.NotFound(ref.String())
+	}
+	_, err = m.db.ExecContext(ctx, "DELETE FROM ocm_shares WHERE id = $1", s.GetId().GetOpaqueId())
+	return err
+}
+
+// UpdateShare updates the share with the given fields.
+func (m *mgr) UpdateShare(ctx context.Context, user *userpb.User, ref *ocm.ShareReference, fields ...*ocm.UpdateOCMShareRequest_UpdateField) (*ocm.Share, error) {
+	s, err := m.get(ctx, ref)
+	if err != nil || !isOwnerOrCreator(user, s) {
+		return nil, errtypes.NotFound(ref.String())
+	}
+
+	for _, f := range fields {
+		if exp := f.GetExpiration(); exp != nil {
+			s.Expiration = exp
+		}
+		if am := f.GetAccessMethods(); am != nil {
+			s.AccessMethods = mergeAccessMethods(s.GetAccessMethods(), am)
+		}
+	}
+
+	data, err := utils.MarshalProtoV1ToJSON(s)
+	if err != nil {
+		return nil, errtypes.InternalError("failed to marshal ocm share")
+	}
+	var expiration sql.NullInt64
+	if e := s.GetExpiration(); e != nil {
+		expiration = sql.NullInt64{Int64: int64(e.GetSeconds()), Valid: true}
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE ocm_shares SET expiration = $1, data = $2 WHERE id = $3", expiration, string(data), s.GetId().GetOpaqueId()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// mergeAccessMethods replaces the options of the existing access methods with the ones of the update.
+// Options that are not part of the update are kept.
+func mergeAccessMethods(existing []*ocm.AccessMethod, update *ocm.AccessMethod) []*ocm.AccessMethod {
+	var (
+		webdavOptions   = update.GetWebdavOptions()
+		webappOptions   = update.GetWebappOptions()
+		transferOptions = update.GetTransferOptions()
+	)
+	for _, am := range existing {
+		if webdavOptions == nil {
+			webdavOptions = am.GetWebdavOptions()
+		}
+		if webappOptions == nil {
+			webappOptions = am.GetWebappOptions()
+		}
+		if transferOptions == nil {
+			transferOptions = am.GetTransferOptions()
+		}
+	}
+
+	merged := []*ocm.AccessMethod{}
+	if webdavOptions != nil {
+		merged = append(merged, &ocm.AccessMethod{Term: &ocm.AccessMethod_WebdavOptions{WebdavOptions: webdavOptions}})
+	}
+	if webappOptions != nil {
+		merged = append(merged, &ocm.AccessMethod{Term: &ocm.AccessMethod_WebappOptions{WebappOptions: webappOptions}})
+	}
+	if transferOptions != nil {
+		merged = append(merged, &ocm.AccessMethod{Term: &ocm.AccessMethod_TransferOptions{TransferOptions: transferOptions}})
+	}
+	return merged
+}
+
+func (m *mgr) ListShares(ctx context.Context, user *userpb.User, filters []*ocm.ListOCMSharesRequest_Filter) ([]*ocm.Share, error) {
+	var args sqldb.Args
+	userID := args.Add(user.GetId().GetOpaqueId())
+	where := "(owner = " + userID + " OR creator = " + userID + " OR grantee = " + args.Add(formatUserID(user.GetId())) + ")"
+
+	// TODO add the rest of filters.
+	var resources []string
+	for _, f := range filters {
+		if f.GetType() == ocm.ListOCMSharesRequest_Filter_TYPE_RESOURCE_ID {
+			rid := f.GetResourceId()
+			resources = append(resources, "(storage_id = "+args.Add(rid.GetStorageId())+" AND space_id = "+args.Add(rid.GetSpaceId())+" AND opaque_id = "+args.Add(rid.GetOpaqueId())+")")
+		}
+	}
+	if len(filters) > 0 {
+		if len(resources) == 0 {
+			return nil, nil
+		}
+		where += " AND (" + strings.Join(resources, " OR ") + ")"
+	}
+
+	shares, err := m.queryShares(ctx, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ss []*ocm.Share
+	for _, s := range shares {
+		if isOwnerOrCreator(user, s) || utils.UserEqual(user.GetId(), s.GetGrantee().GetUserId()) {
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+func (m *mgr) StoreReceivedShare(ctx context.Context, rs *ocm.ReceivedShare) (*ocm.ReceivedShare, error) {
+	ts := utils.TSNow()
+	rs.Id = &ocm.ShareId{OpaqueId: uuid.New().String()}
+	rs.Ctime = ts
+	rs.Mtime = ts
+
+	data, err := utils.MarshalProtoV1ToJSON(rs)
+	if err != nil {
+		return nil, errtypes.InternalError("failed to marshal ocm received share")
+	}
+	_, err = m.db.ExecContext(ctx, "INSERT INTO ocm_received_shares (id, grantee, owner, creator, state, data) VALUES ($1, $2, $3, $4, $5, $6)",
+		rs.GetId().GetOpaqueId(), rs.GetGrantee().GetUserId().GetOpaqueId(), formatUserID(rs.GetOwner()), formatUserID(rs.GetCreator()), int(rs.GetState()), string(data))
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func isGrantee(user *userpb.User, rs *ocm.ReceivedShare) bool {
+	return rs.GetGrantee().GetType() == provider.GranteeType_GRANTEE_TYPE_USER && utils.UserEqual(user.GetId(), rs.GetGrantee().GetUserId())
+}
+
+func (m *mgr) ListReceivedShares(ctx context.Context, user *userpb.User) ([]*ocm.ReceivedShare, error) {
+	shares, err := m.queryReceivedShares(ctx, "grantee = $1", user.GetId().GetOpaqueId())
+	if err != nil {
+		return nil, err
+	}
+	var rss []*ocm.ReceivedShare
+	for _, rs := range shares {
+		if utils.UserEqual(user.GetId(), rs.GetOwner()) || utils.UserEqual(user.GetId(), rs.GetCreator()) {
+			// omit shares created by me
+			continue
+		}
+		if isGrantee(user, rs) {
+			rss = append(rss, rs)
+		}
+	}
+	return rss, nil
+}
+
+func (m *mgr) GetReceivedShare(ctx context.Context, user *userpb.User, ref *ocm.ShareReference) (*ocm.ReceivedShare, error) {
+	if ref.GetId() == nil {
+		return nil, errtypes.NotFound(ref.String())
+	}
+	shares, err := m.queryReceivedShares(ctx, "id = $1", ref.GetId().GetOpaqueId())
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 || !isGrantee(user, shares[0]) {
+		return nil, errtypes.NotFound(ref.String())
+	}
+	return shares[0], nil
+}
+
+func (m *mgr) UpdateReceivedShare(ctx context.Context, user *userpb.User, rs *ocm.ReceivedShare, fieldMask *field_mask.FieldMask) (*ocm.ReceivedShare, error) {
+	existing, err := m.GetReceivedShare(ctx, user, &ocm.ShareReference{Spec: &ocm.ShareReference_Id{Id: rs.GetId()}})
+	if err != nil {
+		return nil, err
+	}
+	updated := proto.Clone(existing).(*ocm.ReceivedShare)
+
+	for _, mask := range fieldMask.GetPaths() {
+		switch mask {
+		case "state":
+			updated.State = rs.GetState()
+		// TODO case "mount_point":
+		default:
+			return nil, errtypes.NotSupported("updating " + mask + " is not supported")
+		}
+	}
+	updated.Mtime = utils.TSNow()
+
+	data, err := utils.MarshalProtoV1ToJSON(updated)
+	if err != nil {
+		return nil, errtypes.InternalError("failed to marshal ocm received share")
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE ocm_received_shares SET state = $1, data = $2 WHERE id = $3", int(updated.GetState()), string(data), updated.GetId().GetOpaqueId()); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
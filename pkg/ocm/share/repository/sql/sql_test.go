@@ -0,0 +1,255 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql_test
+
+import (
+	"context"
+	"errors"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	ocm "github.com/cs3org/go-cs3apis/cs3/sharing/ocm/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/ocm/share"
+	"github.com/opencloud-eu/reva/v2/pkg/ocm/share/repository/sql"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb/sqldbtest"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sql", func() {
+	for _, driver := range sqldbtest.Drivers {
+		Context(driver, func() { describeRepository(driver) })
+	}
+})
+
+func describeRepository(driver string) {
+	var (
+		owner = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "admin",
+			},
+		}
+		other = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "marie",
+			},
+		}
+		remote = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://remote.example.org",
+				OpaqueId: "einstein",
+			},
+		}
+		resourceID = &provider.ResourceId{
+			StorageId: "storageid",
+			SpaceId:   "spaceid",
+			OpaqueId:  "opaqueid",
+		}
+		resourceID2 = &provider.ResourceId{
+			StorageId: "storageid",
+			SpaceId:   "spaceid",
+			OpaqueId:  "opaqueid2",
+		}
+		remoteGrantee = &provider.Grantee{
+			Type: provider.GranteeType_GRANTEE_TYPE_USER,
+			Id:   &provider.Grantee_UserId{UserId: remote.Id},
+		}
+
+		ctx = context.Background()
+		r   share.Repository
+
+		newShare = func(rid *provider.ResourceId, token string) *ocm.Share {
+			return &ocm.Share{
+				ResourceId: rid,
+				Name:       "file",
+				Token:      token,
+				Grantee:    remoteGrantee,
+				Owner:      owner.Id,
+				Creator:    owner.Id,
+				AccessMethods: []*ocm.AccessMethod{
+					{Term: &ocm.AccessMethod_WebdavOptions{WebdavOptions: &ocm.WebDAVAccessMethod{
+						Permissions: &provider.ResourcePermissions{Stat: true},
+					}}},
+				},
+			}
+		}
+		byID = func(id *ocm.ShareId) *ocm.ShareReference {
+			return &ocm.ShareReference{Spec: &ocm.ShareReference_Id{Id: id}}
+		}
+	)
+
+	BeforeEach(func() {
+		c, cleanup, err := sqldbtest.Config(driver, GinkgoT().TempDir())
+		if errors.Is(err, sqldbtest.ErrNotConfigured) {
+			Skip(err.Error())
+		}
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(cleanup)
+		db, err := sqldb.Open(c)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(db.Close)
+		r, err = sql.NewWithDB(db)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("shares", func() {
+		var s *ocm.Share
+
+		BeforeEach(func() {
+			var err error
+			s, err = r.StoreShare(ctx, newShare(resourceID, "token"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Id.OpaqueId).ToNot(BeEmpty())
+		})
+
+		It("does not store a share twice", func() {
+			_, err := r.StoreShare(ctx, newShare(resourceID, "token2"))
+			Expect(err).To(Equal(share.ErrShareAlreadyExisting))
+		})
+
+		It("gets shares by id, key and token", func() {
+			got, err := r.GetShare(ctx, owner, byID(s.Id))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.Token).To(Equal("token"))
+
+			got, err = r.GetShare(ctx, owner, &ocm.ShareReference{Spec: &ocm.ShareReference_Key{Key: &ocm.ShareKey{
+				Owner:      owner.Id,
+				ResourceId: resourceID,
+				Grantee:    remoteGrantee,
+			}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.Id.OpaqueId).To(Equal(s.Id.OpaqueId))
+
+			got, err = r.GetShare(ctx, remote, &ocm.ShareReference{Spec: &ocm.ShareReference_Token{Token: "token"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.Id.OpaqueId).To(Equal(s.Id.OpaqueId))
+		})
+
+		It("hides shares from other users", func() {
+			_, err := r.GetShare(ctx, other, byID(s.Id))
+			Expect(err).To(Equal(share.ErrShareNotFound))
+
+			shares, err := r.ListShares(ctx, other, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(BeEmpty())
+		})
+
+		It("lists shares filtered by resource", func() {
+			_, err := r.StoreShare(ctx, newShare(resourceID2, "token2"))
+			Expect(err).ToNot(HaveOccurred())
+
+			shares, err := r.ListShares(ctx, owner, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(2))
+
+			shares, err = r.ListShares(ctx, owner, []*ocm.ListOCMSharesRequest_Filter{
+				{
+					Type: ocm.ListOCMSharesRequest_Filter_TYPE_RESOURCE_ID,
+					Term: &ocm.ListOCMSharesRequest_Filter_ResourceId{ResourceId: resourceID2},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(1))
+			Expect(shares[0].Token).To(Equal("token2"))
+
+			shares, err = r.ListShares(ctx, remote, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(2))
+		})
+
+		It("updates shares", func() {
+			_, err := r.UpdateShare(ctx, owner, byID(s.Id), &ocm.UpdateOCMShareRequest_UpdateField{
+				Field: &ocm.UpdateOCMShareRequest_UpdateField_Expiration{Expiration: &typespb.Timestamp{Seconds: 1000}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			got, err := r.GetShare(ctx, owner, byID(s.Id))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.Expiration.Seconds).To(Equal(uint64(1000)))
+			Expect(got.AccessMethods).To(HaveLen(1))
+
+			_, err = r.UpdateShare(ctx, other, byID(s.Id))
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+		})
+
+		It("deletes shares", func() {
+			Expect(r.DeleteShare(ctx, other, byID(s.Id))).ToNot(Succeed())
+			Expect(r.DeleteShare(ctx, owner, byID(s.Id))).To(Succeed())
+
+			_, err := r.GetShare(ctx, owner, byID(s.Id))
+			Expect(err).To(Equal(share.ErrShareNotFound))
+		})
+	})
+
+	Describe("received shares", func() {
+		var rs *ocm.ReceivedShare
+
+		BeforeEach(func() {
+			var err error
+			rs, err = r.StoreReceivedShare(ctx, &ocm.ReceivedShare{
+				Name:          "file",
+				RemoteShareId: "remote-id",
+				Grantee: &provider.Grantee{
+					Type: provider.GranteeType_GRANTEE_TYPE_USER,
+					Id:   &provider.Grantee_UserId{UserId: owner.Id},
+				},
+				Owner:   remote.Id,
+				Creator: remote.Id,
+				State:   ocm.ShareState_SHARE_STATE_PENDING,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("lists and gets received shares of the grantee", func() {
+			shares, err := r.ListReceivedShares(ctx, owner)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(1))
+			Expect(shares[0].RemoteShareId).To(Equal("remote-id"))
+
+			shares, err = r.ListReceivedShares(ctx, other)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(BeEmpty())
+
+			_, err = r.GetReceivedShare(ctx, other, byID(rs.Id))
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+		})
+
+		It("updates the state of received shares", func() {
+			_, err := r.UpdateReceivedShare(ctx, owner, &ocm.ReceivedShare{
+				Id:    rs.Id,
+				State: ocm.ShareState_SHARE_STATE_ACCEPTED,
+			}, &fieldmaskpb.FieldMask{Paths: []string{"state"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			got, err := r.GetReceivedShare(ctx, owner, byID(rs.Id))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.State).To(Equal(ocm.ShareState_SHARE_STATE_ACCEPTED))
+
+			_, err = r.UpdateReceivedShare(ctx, owner, rs, &fieldmaskpb.FieldMask{Paths: []string{"name"}})
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotSupported("")))
+		})
+	})
+}
@@ -174,6 +174,22 @@ type CapabilitiesArchiver struct {
 	MaxSize     string   `json:"max_size" xml:"max_size" mapstructure:"max_size"`
 }
 
+// CapabilitiesUploadPolicy holds the upload restrictions for a space type or space.
+// An empty space type and space id describe the default policy. It is not configured
+// directly but derived from the upload_policies of the ocs service.
+type CapabilitiesUploadPolicy struct {
+	SpaceType           string   `json:"space_type,omitempty" xml:"space_type,omitempty"`
+	SpaceID             string   `json:"space_id,omitempty" xml:"space_id,omitempty"`
+	MaxFileSize         string   `json:"max_file_size,omitempty" xml:"max_file_size,omitempty"`
+	AllowedExtensions   []string `json:"allowed_extensions,omitempty" xml:"allowed_extensions>element"`
+	BlockedExtensions   []string `json:"blocked_extensions,omitempty" xml:"blocked_extensions>element"`
+	AllowedMimeTypes    []string `json:"allowed_mime_types,omitempty" xml:"allowed_mime_types>element"`
+	BlockedMimeTypes    []string `json:"blocked_mime_types,omitempty" xml:"blocked_mime_types>element"`
+	ForbiddenCharacters string   `json:"forbidden_characters,omitempty" xml:"forbidden_characters,omitempty"`
+	ReservedNames       []string `json:"reserved_names,omitempty" xml:"reserved_names>element"`
+	MaxFilenameLength   int      `json:"max_filename_length,omitempty" xml:"max_filename_length,omitempty"`
+}
+
 // CapabilitiesAppProvider holds available app provider information
 type CapabilitiesAppProvider struct {
 	Enabled    bool   `json:"enabled" xml:"enabled" mapstructure:"enabled"`
@@ -197,6 +213,7 @@ type CapabilitiesFiles struct {
 	TusSupport       *CapabilitiesFilesTusSupport `json:"tus_support" xml:"tus_support" mapstructure:"tus_support"`
 	Archivers        []*CapabilitiesArchiver      `json:"archivers" xml:"archivers" mapstructure:"archivers"`
 	AppProviders     []*CapabilitiesAppProvider   `json:"app_providers" xml:"app_providers" mapstructure:"app_providers"`
+	UploadPolicies   []*CapabilitiesUploadPolicy  `json:"upload_policies,omitempty" xml:"upload_policies,omitempty" mapstructure:"-"`
 }
 
 // CapabilitiesDav holds dav endpoint config
@@ -222,10 +239,19 @@ type CapabilitiesFilesSharing struct {
 	Federation                    *CapabilitiesFilesSharingFederation      `json:"federation" xml:"federation"`
 	Public                        *CapabilitiesFilesSharingPublic          `json:"public" xml:"public"`
 	User                          *CapabilitiesFilesSharingUser            `json:"user" xml:"user"`
+	// Roles lists the custom sharing roles, it is filled from the shared configuration
+	Roles []*CapabilitiesFilesSharingRole `json:"roles,omitempty" xml:"roles,omitempty" mapstructure:"-"`
 	// TODO: Remove next line once web defaults to resharing=false
 	Resharing ocsBool `json:"resharing" xml:"resharing"`
 }
 
+// CapabilitiesFilesSharingRole describes a custom sharing role
+type CapabilitiesFilesSharingRole struct {
+	Name                string   `json:"name" xml:"name"`
+	Permissions         int      `json:"permissions" xml:"permissions"`
+	ResourcePermissions []string `json:"resource_permissions" xml:"resource_permissions>element"`
+}
+
 // CapabilitiesFilesSharingPublic TODO document
 type CapabilitiesFilesSharingPublic struct {
 	Enabled            ocsBool                                   `json:"enabled" xml:"enabled"`
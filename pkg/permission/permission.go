@@ -37,6 +37,12 @@ const (
 	WriteFavorites string = "Favorites.Write"
 	// DeleteReadOnlyPassword is the hardcoded name for the ReadOnlyPublicLinkPassword.Delete permission
 	DeleteReadOnlyPassword string = "ReadOnlyPublicLinkPassword.Delete"
+	// ManageUploads is the hardcoded name for the Uploads.Manage permission
+	ManageUploads string = "Uploads.Manage"
+	// ReadAuditLog is the hardcoded name for the AuditLog.Read permission
+	ReadAuditLog string = "AuditLog.Read"
+	// ManageWebhooks is the hardcoded name for the Webhooks.ManageAll permission
+	ManageWebhooks string = "Webhooks.ManageAll"
 )
 
 // Manager defines the interface for the permission service driver
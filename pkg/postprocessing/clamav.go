@@ -0,0 +1,245 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package postprocessing
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/opencloud-eu/reva/v2/pkg/bytesize"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+)
+
+func init() {
+	Register(string(events.PPStepAntivirus), NewClamAV)
+}
+
+// ClamAVConfig configures the ClamAV virus scanner step
+type ClamAVConfig struct {
+	Address        string `mapstructure:"address" docs:"tcp://localhost:3310;The address of clamd. Either tcp://host:port or unix:///path/to/clamd.sock."`
+	DialTimeout    string `mapstructure:"dial_timeout" docs:"10s;The timeout for connecting to clamd."`
+	ChunkSize      string `mapstructure:"chunk_size" docs:"64KB;The size of the chunks the file is streamed to clamd with."`
+	MaxScanSize    string `mapstructure:"max_scan_size" docs:";Only the first bytes up to this size are scanned. Empty scans the whole file."`
+	InfectedAction string `mapstructure:"infected_action" docs:"delete;The outcome for infected files. One of delete, abort or continue."`
+	Insecure       bool   `mapstructure:"insecure" docs:"false;Whether to skip certificate checks when downloading the file."`
+}
+
+// ClamAV scans uploads with clamd using the INSTREAM command
+type ClamAV struct {
+	network     string
+	address     string
+	dialTimeout time.Duration
+	chunkSize   int
+	maxScanSize int64
+	outcome     events.PostprocessingOutcome
+	client      *http.Client
+}
+
+// NewClamAV returns a new ClamAV step
+func NewClamAV(m map[string]interface{}) (Step, error) {
+	c := &ClamAVConfig{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, err
+	}
+	if c.Address == "" {
+		c.Address = "tcp://localhost:3310"
+	}
+	if c.DialTimeout == "" {
+		c.DialTimeout = "10s"
+	}
+	if c.ChunkSize == "" {
+		c.ChunkSize = "64KB"
+	}
+	if c.InfectedAction == "" {
+		c.InfectedAction = string(events.PPOutcomeDelete)
+	}
+
+	s := &ClamAV{
+		outcome: events.PostprocessingOutcome(c.InfectedAction),
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: c.Insecure},
+			},
+		},
+	}
+	switch s.outcome {
+	case events.PPOutcomeDelete, events.PPOutcomeAbort, events.PPOutcomeContinue:
+	default:
+		return nil, fmt.Errorf("clamav: invalid infected action %q", c.InfectedAction)
+	}
+
+	switch {
+	case strings.HasPrefix(c.Address, "unix://"):
+		s.network, s.address = "unix", strings.TrimPrefix(c.Address, "unix://")
+	case strings.HasPrefix(c.Address, "tcp://"):
+		s.network, s.address = "tcp", strings.TrimPrefix(c.Address, "tcp://")
+	default:
+		s.network, s.address = "tcp", c.Address
+	}
+
+	var err error
+	if s.dialTimeout, err = time.ParseDuration(c.DialTimeout); err != nil {
+		return nil, err
+	}
+	chunkSize, err := bytesize.Parse(c.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize == 0 {
+		return nil, errors.New("clamav: chunk size must not be 0")
+	}
+	s.chunkSize = int(chunkSize)
+	if c.MaxScanSize != "" {
+		maxScanSize, err := bytesize.Parse(c.MaxScanSize)
+		if err != nil {
+			return nil, err
+		}
+		s.maxScanSize = int64(maxScanSize)
+	}
+	return s, nil
+}
+
+// Run downloads the upload and scans it. Infected files lead to the configured outcome.
+func (s *ClamAV) Run(ctx context.Context, u Upload) (Result, error) {
+	res := events.VirusscanResult{
+		ResourceID: u.ResourceID,
+		Scandate:   time.Now(),
+	}
+
+	var (
+		body io.Reader = strings.NewReader("")
+		err  error
+	)
+	if u.Filesize > 0 {
+		rc, err := s.download(ctx, u.URL)
+		if err != nil {
+			res.ErrorMsg = err.Error()
+			return Result{Data: res}, err
+		}
+		defer rc.Close()
+		body = rc
+	}
+	if s.maxScanSize > 0 {
+		body = io.LimitReader(body, s.maxScanSize)
+	}
+
+	res.Description, res.Infected, err = s.Scan(ctx, body)
+	if err != nil {
+		res.ErrorMsg = err.Error()
+		return Result{Data: res}, err
+	}
+	if res.Infected {
+		return Result{Outcome: s.outcome, Data: res}, nil
+	}
+	return Result{Outcome: events.PPOutcomeContinue, Data: res}, nil
+}
+
+func (s *ClamAV) download(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("clamav: unexpected status %d downloading the file", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// Scan streams the content to clamd and returns the reported signature and whether it was found
+func (s *ClamAV) Scan(ctx context.Context, r io.Reader) (string, bool, error) {
+	d := net.Dialer{Timeout: s.dialTimeout}
+	conn, err := d.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return "", false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// abort blocking reads and writes when the context is canceled
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", false, err
+	}
+	buf := make([]byte, 4+s.chunkSize)
+	for {
+		n, rerr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", false, err
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return "", false, rerr
+		}
+	}
+	// a zero length chunk terminates the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", false, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || reply == "") {
+		if ctx.Err() != nil {
+			return "", false, ctx.Err()
+		}
+		return "", false, err
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses replies like "stream: OK", "stream: Eicar-Signature FOUND"
+// or "INSTREAM size limit exceeded. ERROR"
+func parseClamdReply(reply string) (string, bool, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	msg := reply
+	if i := strings.Index(reply, ": "); i >= 0 {
+		msg = reply[i+2:]
+	}
+	switch {
+	case msg == "OK":
+		return "", false, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return strings.TrimSuffix(msg, " FOUND"), true, nil
+	default:
+		return "", false, fmt.Errorf("clamav: %s", reply)
+	}
+}
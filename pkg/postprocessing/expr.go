@@ -0,0 +1,535 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package postprocessing
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/opencloud-eu/reva/v2/pkg/bytesize"
+)
+
+// Expression is a compiled boolean policy expression.
+//
+// The language supports string, number, boolean and list literals, variables,
+// the comparison operators ==, !=, <, <=, >, >=, the regex operators =~ and !~,
+// the membership operator in and the logical operators &&, || and !.
+// Numbers may carry a size unit, e.g. 10MB or 2GiB.
+//
+//	extension in ["exe", "bat"] || (size > 100MB && user.idp != "https://idp.example.com")
+type Expression struct {
+	src  string
+	root node
+}
+
+// Env holds the variables an expression is evaluated against.
+type Env map[string]interface{}
+
+type node func(env Env) (interface{}, error)
+
+// CompileExpression parses the given source into an Expression.
+func CompileExpression(src string) (*Expression, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression. It fails if the expression does not yield a boolean.
+func (e *Expression) Eval(env Env) (bool, error) {
+	v, err := e.root(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q does not evaluate to a boolean", e.src)
+	}
+	return b, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(rs) && rs[i] != r; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+				sb.WriteRune(rs[i])
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			toks = append(toks, token{kind: tokString, text: sb.String(), pos: start})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			numEnd := i
+			for i < len(rs) && unicode.IsLetter(rs[i]) {
+				i++
+			}
+			text := string(rs[start:i])
+			var n float64
+			if numEnd == i {
+				f, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %q at position %d", text, start)
+				}
+				n = f
+			} else {
+				b, err := bytesize.Parse(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid size %q at position %d: %w", text, start, err)
+				}
+				n = float64(b.Bytes())
+			}
+			toks = append(toks, token{kind: tokNumber, text: text, num: n, pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_' || rs[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: string(rs[start:i]), pos: start})
+		default:
+			start := i
+			op := string(r)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||":
+					op = two
+				}
+			}
+			switch op {
+			case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",":
+			default:
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, start)
+			}
+			i += len([]rune(op))
+			toks = append(toks, token{kind: tokOp, text: op, pos: start})
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(rs)}), nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && (t.text == "and" || t.text == "or" || t.text == "not" || t.text == "in") {
+		t.kind = tokOp
+	}
+	if t.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %q at position %d", op, p.peek().pos)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||", "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical(left, right, true)
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&", "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logical(left, right, false)
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!", "not") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env Env) (interface{}, error) {
+			v, err := operand(env)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operand of ! is not a boolean")
+			}
+			return !b, nil
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("==", "!=", "<", "<=", ">", ">=", "=~", "!~", "in") {
+		return left, nil
+	}
+	op := p.next().text
+	rightTok := p.peek()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "=~", "!~":
+		var re *regexp.Regexp
+		if rightTok.kind == tokString {
+			// compile literal patterns once
+			if re, err = regexp.Compile(rightTok.text); err != nil {
+				return nil, fmt.Errorf("invalid regular expression at position %d: %w", rightTok.pos, err)
+			}
+		}
+		return match(left, right, re, op == "!~"), nil
+	case "in":
+		return contains(left, right), nil
+	default:
+		return compare(left, right, op), nil
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return constant(t.text), nil
+	case tokNumber:
+		return constant(t.num), nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		}
+		name := t.text
+		return func(env Env) (interface{}, error) {
+			v, ok := env[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable %q", name)
+			}
+			return normalize(v), nil
+		}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			var items []node
+			for !p.isOp("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			p.next()
+			return func(env Env) (interface{}, error) {
+				list := make([]interface{}, 0, len(items))
+				for _, item := range items {
+					v, err := item(env)
+					if err != nil {
+						return nil, err
+					}
+					list = append(list, v)
+				}
+				return list, nil
+			}, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func constant(v interface{}) node {
+	return func(Env) (interface{}, error) { return v, nil }
+}
+
+// normalize converts variable values to the types the evaluator works with
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	case []string:
+		list := make([]interface{}, 0, len(t))
+		for _, s := range t {
+			list = append(list, s)
+		}
+		return list
+	}
+	return v
+}
+
+func logical(left, right node, or bool) node {
+	return func(env Env) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of logical operator is not a boolean")
+		}
+		// short circuit
+		if lb == or {
+			return lb, nil
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of logical operator is not a boolean")
+		}
+		return rb, nil
+	}
+}
+
+func compare(left, right node, op string) node {
+	return func(env Env) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			return equal(l, r), nil
+		case "!=":
+			return !equal(l, r), nil
+		}
+		switch lv := l.(type) {
+		case float64:
+			rv, ok := r.(float64)
+			if !ok {
+				return nil, fmt.Errorf("cannot compare number with %T", r)
+			}
+			return order(lv < rv, lv == rv, op), nil
+		case string:
+			rv, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot compare string with %T", r)
+			}
+			return order(lv < rv, lv == rv, op), nil
+		}
+		return nil, fmt.Errorf("operator %s is not defined for %T", op, l)
+	}
+}
+
+func order(less, eq bool, op string) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || eq
+	case ">":
+		return !less && !eq
+	default:
+		return !less
+	}
+}
+
+func equal(l, r interface{}) bool {
+	switch lv := l.(type) {
+	case string, float64, bool:
+		return l == r
+	case []interface{}:
+		rv, ok := r.([]interface{})
+		if !ok || len(lv) != len(rv) {
+			return false
+		}
+		for i := range lv {
+			if !equal(lv[i], rv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return l == nil && r == nil
+}
+
+func match(left, right node, re *regexp.Regexp, negate bool) node {
+	return func(env Env) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := l.(string)
+		if !ok {
+			return nil, fmt.Errorf("left operand of regex match is not a string")
+		}
+		pattern := re
+		if pattern == nil {
+			r, err := right(env)
+			if err != nil {
+				return nil, err
+			}
+			ps, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("right operand of regex match is not a string")
+			}
+			if pattern, err = regexp.Compile(ps); err != nil {
+				return nil, err
+			}
+		}
+		return pattern.MatchString(s) != negate, nil
+	}
+}
+
+func contains(left, right node) node {
+	return func(env Env) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		switch rv := r.(type) {
+		case []interface{}:
+			for _, item := range rv {
+				if equal(l, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case string:
+			ls, ok := l.(string)
+			if !ok {
+				return nil, fmt.Errorf("left operand of in is not a string")
+			}
+			return strings.Contains(rv, ls), nil
+		}
+		return nil, fmt.Errorf("right operand of in is not a list or string")
+	}
+}
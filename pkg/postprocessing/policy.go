@@ -0,0 +1,169 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package postprocessing
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/mime"
+)
+
+func init() {
+	Register(string(events.PPStepPolicies), NewPolicy)
+	Register(string(events.PPStepDelay), NewDelay)
+}
+
+// PolicyRule decides about the outcome of an upload if its expression matches
+type PolicyRule struct {
+	Name       string `mapstructure:"name" docs:";The name of the rule. It is reported in the step result."`
+	Expression string `mapstructure:"expression" docs:";The expression to evaluate, e.g. extension in [\"exe\", \"bat\"] || size > 1GB."`
+	Outcome    string `mapstructure:"outcome" docs:"abort;The outcome when the expression matches. One of abort, delete, retry or continue."`
+}
+
+// PolicyConfig configures the policy step
+type PolicyConfig struct {
+	Rules []PolicyRule `mapstructure:"rules" docs:"nil;The rules to evaluate. The first matching rule decides the outcome."`
+}
+
+// PolicyResult is the result of the policy step
+type PolicyResult struct {
+	Rule    string                       `json:"rule,omitempty"`
+	Outcome events.PostprocessingOutcome `json:"outcome"`
+}
+
+type policyRule struct {
+	name    string
+	expr    *Expression
+	outcome events.PostprocessingOutcome
+}
+
+// Policy evaluates expressions against the upload metadata
+//
+// The following variables are available to the expressions: filename, extension, mimetype,
+// size, user.id, user.idp, user.username, user.mail, user.groups, space_owner.id and impersonated.
+type Policy struct {
+	rules []policyRule
+}
+
+// NewPolicy returns a new policy step
+func NewPolicy(m map[string]interface{}) (Step, error) {
+	c := &PolicyConfig{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	for i, r := range c.Rules {
+		expr, err := CompileExpression(r.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("policies: rule %d: %w", i, err)
+		}
+		outcome := events.PostprocessingOutcome(r.Outcome)
+		switch outcome {
+		case "":
+			outcome = events.PPOutcomeAbort
+		case events.PPOutcomeAbort, events.PPOutcomeDelete, events.PPOutcomeRetry, events.PPOutcomeContinue:
+		default:
+			return nil, fmt.Errorf("policies: rule %d: invalid outcome %q", i, r.Outcome)
+		}
+		name := r.Name
+		if name == "" {
+			name = r.Expression
+		}
+		p.rules = append(p.rules, policyRule{name: name, expr: expr, outcome: outcome})
+	}
+	return p, nil
+}
+
+// Run evaluates the rules in order. The first matching rule decides the outcome.
+func (p *Policy) Run(_ context.Context, u Upload) (Result, error) {
+	env := PolicyEnv(u)
+	for _, r := range p.rules {
+		ok, err := r.expr.Eval(env)
+		if err != nil {
+			return Result{}, fmt.Errorf("policies: rule %q: %w", r.name, err)
+		}
+		if ok {
+			return Result{Outcome: r.outcome, Data: PolicyResult{Rule: r.name, Outcome: r.outcome}}, nil
+		}
+	}
+	return Result{Outcome: events.PPOutcomeContinue, Data: PolicyResult{Outcome: events.PPOutcomeContinue}}, nil
+}
+
+// PolicyEnv returns the variables policy expressions are evaluated against
+func PolicyEnv(u Upload) Env {
+	env := Env{
+		"filename":       u.Filename,
+		"extension":      strings.ToLower(strings.TrimPrefix(path.Ext(u.Filename), ".")),
+		"mimetype":       mime.Detect(false, u.Filename),
+		"size":           u.Filesize,
+		"user.id":        "",
+		"user.idp":       "",
+		"user.username":  "",
+		"user.mail":      "",
+		"user.groups":    []string{},
+		"space_owner.id": u.SpaceOwner.GetOpaqueId(),
+		"impersonated":   u.ImpersonatingUser != nil,
+	}
+	if usr := u.ExecutingUser; usr != nil {
+		env["user.id"] = usr.GetId().GetOpaqueId()
+		env["user.idp"] = usr.GetId().GetIdp()
+		env["user.username"] = usr.GetUsername()
+		env["user.mail"] = usr.GetMail()
+		env["user.groups"] = append([]string{}, usr.GetGroups()...)
+	}
+	return env
+}
+
+// Delay holds back the upload for the configured duration
+type Delay struct {
+	duration time.Duration
+}
+
+// NewDelay returns a new delay step
+func NewDelay(m map[string]interface{}) (Step, error) {
+	c := struct {
+		Duration string `mapstructure:"duration" docs:"0s;The time to delay the processing by."`
+	}{}
+	if err := mapstructure.Decode(m, &c); err != nil {
+		return nil, err
+	}
+	d := &Delay{}
+	if c.Duration != "" {
+		var err error
+		if d.duration, err = time.ParseDuration(c.Duration); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Run waits for the configured duration
+func (d *Delay) Run(ctx context.Context, _ Upload) (Result, error) {
+	if err := sleep(ctx, d.duration); err != nil {
+		return Result{}, err
+	}
+	return Result{Outcome: events.PPOutcomeContinue}, nil
+}
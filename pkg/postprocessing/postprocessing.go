@@ -0,0 +1,821 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package postprocessing implements an orchestrator that runs a configurable pipeline
+// of steps for every finished upload and decides about its outcome.
+package postprocessing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/store"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// Upload describes the upload a pipeline is run for
+type Upload struct {
+	ID                string               `json:"id"`
+	URL               string               `json:"-"`
+	Filename          string               `json:"filename"`
+	Filesize          uint64               `json:"filesize"`
+	SpaceOwner        *user.UserId         `json:"space_owner,omitempty"`
+	ExecutingUser     *user.User           `json:"executing_user,omitempty"`
+	ImpersonatingUser *user.User           `json:"impersonating_user,omitempty"`
+	ResourceID        *provider.ResourceId `json:"resource_id,omitempty"`
+}
+
+// UploadFromEvent creates an Upload from a BytesReceived event
+func UploadFromEvent(ev events.BytesReceived) Upload {
+	return Upload{
+		ID:                ev.UploadID,
+		URL:               ev.URL,
+		Filename:          ev.Filename,
+		Filesize:          ev.Filesize,
+		SpaceOwner:        ev.SpaceOwner,
+		ExecutingUser:     ev.ExecutingUser,
+		ImpersonatingUser: ev.ImpersonatingUser,
+		ResourceID:        ev.ResourceID,
+	}
+}
+
+// Result is the result of a single step
+type Result struct {
+	// Outcome decides how the pipeline goes on. An empty outcome means continue.
+	Outcome events.PostprocessingOutcome
+	// Data is reported as the step result in the emitted events
+	Data interface{}
+}
+
+// Step is a single step of the postprocessing pipeline
+type Step interface {
+	Run(ctx context.Context, u Upload) (Result, error)
+}
+
+// NewStepFunc is the function that steps should register to at init time.
+type NewStepFunc func(m map[string]interface{}) (Step, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]NewStepFunc{}
+)
+
+// Register registers a step type. It is meant to be called from init functions.
+func Register(name string, f NewStepFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = f
+}
+
+// NewStep creates a step of the given registered type
+func NewStep(name string, m map[string]interface{}) (Step, error) {
+	registryMu.RLock()
+	f, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("postprocessing: unknown step %q", name)
+	}
+	return f(m)
+}
+
+// PipelineStep is a step together with its execution settings
+type PipelineStep struct {
+	// Name is reported as the finished step in the emitted events
+	Name events.Postprocessingstep
+	Step Step
+	// Timeout limits a single attempt. 0 disables the limit.
+	Timeout time.Duration
+	// Retries is the number of additional attempts when the step fails
+	Retries int
+	// RetryBackoff is the time to wait between attempts
+	RetryBackoff time.Duration
+	// OnError is the outcome used when all attempts failed
+	OnError events.PostprocessingOutcome
+}
+
+// Options configure the Orchestrator
+type Options struct {
+	// MaxRetries is the number of times a pipeline may be retried when a step asks for it
+	// before the upload is aborted
+	MaxRetries int
+	// RetryBackoff is the initial delay before a retry. It doubles with every failure.
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the delay between retries
+	MaxRetryBackoff time.Duration
+	// Workers limits the number of uploads processed concurrently
+	Workers int
+	// Store keeps the state of the uploads, so that they are resumed after a restart or by
+	// another replica. Replicas sharing it need a store with atomic updates, see cas.New.
+	// Defaults to an in-memory store.
+	Store cas.Store
+	// LeaseDuration is the time a replica holds an upload it runs the pipeline for. The lease is
+	// renewed while the pipeline runs, other replicas take the upload over once it expired.
+	LeaseDuration time.Duration
+}
+
+func (o *Options) init() {
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.RetryBackoff == 0 {
+		o.RetryBackoff = 10 * time.Second
+	}
+	if o.MaxRetryBackoff == 0 {
+		o.MaxRetryBackoff = time.Hour
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.Store == nil {
+		// the memory store can not fail
+		o.Store, _ = cas.New(cas.Config{Store: store.TypeMemory, Database: "reva", Table: "postprocessing"}, 0)
+	}
+	if o.LeaseDuration <= 0 {
+		o.LeaseDuration = time.Minute
+	}
+}
+
+// Status is the state of an upload in postprocessing
+type Status struct {
+	Upload   Upload                                    `json:"upload"`
+	Running  bool                                      `json:"running"`
+	NextStep events.Postprocessingstep                 `json:"next_step,omitempty"`
+	Failures int                                       `json:"failures"`
+	Results  map[events.Postprocessingstep]interface{} `json:"results,omitempty"`
+}
+
+// record is the persisted state of an upload. Unlike Status it contains the upload url.
+type record struct {
+	Upload   Upload                                    `json:"upload"`
+	URL      string                                    `json:"url"`
+	Next     int                                       `json:"next"`
+	Failures int                                       `json:"failures"`
+	Results  map[events.Postprocessingstep]interface{} `json:"results,omitempty"`
+	// Outcome is set once the pipeline is finished, until the outcome is published
+	Outcome events.PostprocessingOutcome `json:"outcome,omitempty"`
+	// Lease identifies the run that holds the upload until LeaseUntil
+	Lease      string    `json:"lease,omitempty"`
+	LeaseUntil time.Time `json:"lease_until,omitempty"`
+	// RetryAt is the time a waiting upload is due again
+	RetryAt time.Time `json:"retry_at,omitempty"`
+}
+
+func (r record) running(now time.Time) bool {
+	return r.Lease != "" && r.LeaseUntil.After(now)
+}
+
+// state is an upload the replica holds the lease for
+type state struct {
+	rec    record
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	errUnknownUpload = errors.New("postprocessing: unknown upload")
+	// errLeased is returned if the upload is running, on this or another replica
+	errLeased = errors.New("postprocessing: the upload is running")
+	// errNotDue is returned if the upload waits for a retry
+	errNotDue = errors.New("postprocessing: the upload is not due")
+	// errLeaseLost is returned if the upload was started over or taken over by another replica
+	errLeaseLost = errors.New("postprocessing: the upload was taken over")
+)
+
+// Orchestrator runs the pipeline for incoming uploads and publishes the results. The state of
+// the uploads lives in the store, a replica runs an upload while it holds its lease.
+type Orchestrator struct {
+	steps []PipelineStep
+	pub   events.Publisher
+	opts  Options
+	log   *zerolog.Logger
+
+	mu sync.Mutex
+	// uploads are the uploads this replica holds the lease for
+	uploads map[string]*state
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	// replaceable in tests
+	sleep     func(ctx context.Context, d time.Duration) error
+	afterFunc func(d time.Duration, f func()) *time.Timer
+}
+
+// NewOrchestrator returns a new Orchestrator running the given steps in order
+func NewOrchestrator(steps []PipelineStep, pub events.Publisher, o Options, log *zerolog.Logger) (*Orchestrator, error) {
+	if pub == nil {
+		return nil, errors.New("postprocessing: missing event publisher")
+	}
+	seen := map[events.Postprocessingstep]bool{}
+	for i := range steps {
+		if steps[i].Name == "" || steps[i].Step == nil {
+			return nil, fmt.Errorf("postprocessing: step %d is incomplete", i)
+		}
+		if seen[steps[i].Name] {
+			return nil, fmt.Errorf("postprocessing: duplicate step %q", steps[i].Name)
+		}
+		seen[steps[i].Name] = true
+		if steps[i].OnError == "" {
+			steps[i].OnError = events.PPOutcomeRetry
+		}
+	}
+	o.init()
+	if log == nil {
+		nop := zerolog.Nop()
+		log = &nop
+	}
+	return &Orchestrator{
+		steps:     steps,
+		pub:       pub,
+		opts:      o,
+		log:       log,
+		uploads:   map[string]*state{},
+		workers:   make(chan struct{}, o.Workers),
+		sleep:     sleep,
+		afterFunc: time.AfterFunc,
+	}, nil
+}
+
+// read returns the stored record of an upload
+func (o *Orchestrator) read(id string) (record, error) {
+	v, err := o.opts.Store.Get(id)
+	if err != nil {
+		return record{}, err
+	}
+	if v == nil {
+		return record{}, errUnknownUpload
+	}
+	r := record{}
+	err = json.Unmarshal(v, &r)
+	return r, err
+}
+
+// update changes the stored record of an upload with fn in one atomic step
+func (o *Orchestrator) update(id string, fn func(r *record) error) (record, error) {
+	var r record
+	err := o.opts.Store.Update(id, 0, func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, errUnknownUpload
+		}
+		r = record{}
+		if err := json.Unmarshal(old, &r); err != nil {
+			return nil, err
+		}
+		if err := fn(&r); err != nil {
+			return nil, err
+		}
+		return json.Marshal(r)
+	})
+	return r, err
+}
+
+// acquire takes the lease of a stored upload that is not running. Uploads waiting for a retry
+// are only taken if due returns true, nil takes them right away. from restarts the pipeline at
+// the given step, -1 continues with the next unfinished step.
+func (o *Orchestrator) acquire(id string, due func(r *record, now time.Time) bool, from int) (*state, error) {
+	now := time.Now()
+	lease := uuid.New().String()
+	r, err := o.update(id, func(r *record) error {
+		switch {
+		case r.running(now):
+			return errLeased
+		case due != nil && !due(r, now):
+			return errNotDue
+		}
+		r.Lease, r.LeaseUntil, r.RetryAt = lease, now.Add(o.opts.LeaseDuration), time.Time{}
+		if from >= 0 {
+			// the pipeline is run again from the given step
+			r.Next, r.Outcome = from, ""
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o.hold(r), nil
+}
+
+// hold registers an upload the replica got the lease for
+func (o *Orchestrator) hold(r record) *state {
+	r.Upload.URL = r.URL
+	if r.Results == nil {
+		r.Results = map[events.Postprocessingstep]interface{}{}
+	}
+	if r.Next > len(o.steps) {
+		// the pipeline was shortened in the meantime
+		r.Next = len(o.steps)
+	}
+	st := &state{rec: r}
+	st.ctx, st.cancel = context.WithCancel(context.Background())
+
+	o.mu.Lock()
+	if old, ok := o.uploads[r.Upload.ID]; ok {
+		// the upload was started over
+		old.cancel()
+	}
+	o.uploads[r.Upload.ID] = st
+	o.mu.Unlock()
+	return st
+}
+
+// release forgets an upload the replica no longer holds the lease for
+func (o *Orchestrator) release(st *state) {
+	o.mu.Lock()
+	if o.uploads[st.rec.Upload.ID] == st {
+		delete(o.uploads, st.rec.Upload.ID)
+	}
+	o.mu.Unlock()
+	st.cancel()
+}
+
+// save stores the state of a run and renews its lease, or gives the lease up. It fails with
+// errLeaseLost if the upload was started over or taken over, the run has to stop then.
+func (o *Orchestrator) save(st *state, giveUp bool) error {
+	o.mu.Lock()
+	rec := st.rec
+	rec.Results = make(map[events.Postprocessingstep]interface{}, len(st.rec.Results))
+	for k, v := range st.rec.Results {
+		rec.Results[k] = v
+	}
+	o.mu.Unlock()
+
+	_, err := o.update(rec.Upload.ID, func(r *record) error {
+		if r.Lease != rec.Lease {
+			return errLeaseLost
+		}
+		*r = rec
+		r.LeaseUntil = time.Now().Add(o.opts.LeaseDuration)
+		if giveUp {
+			r.Lease, r.LeaseUntil = "", time.Time{}
+		}
+		return nil
+	})
+	if errors.Is(err, errUnknownUpload) {
+		return errLeaseLost
+	}
+	return err
+}
+
+// renew extends the lease of a running upload
+func (o *Orchestrator) renew(st *state) error {
+	_, err := o.update(st.rec.Upload.ID, func(r *record) error {
+		if r.Lease != st.rec.Lease {
+			return errLeaseLost
+		}
+		r.LeaseUntil = time.Now().Add(o.opts.LeaseDuration)
+		return nil
+	})
+	if errors.Is(err, errUnknownUpload) {
+		return errLeaseLost
+	}
+	return err
+}
+
+// keepLease renews the lease until done is closed. The run is canceled if the lease is lost.
+func (o *Orchestrator) keepLease(st *state, done <-chan struct{}) {
+	t := time.NewTicker(o.opts.LeaseDuration / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			err := o.renew(st)
+			switch {
+			case errors.Is(err, errLeaseLost):
+				o.log.Info().Str("uploadid", st.rec.Upload.ID).Msg("postprocessing was taken over, stopping")
+				st.cancel()
+				return
+			case err != nil:
+				o.log.Error().Err(err).Str("uploadid", st.rec.Upload.ID).Msg("could not renew postprocessing lease")
+			}
+		}
+	}
+}
+
+// Consume handles the postprocessing related events of the given channel until it is closed.
+// The events are acknowledged once the upload state is persisted, so an upload is not lost
+// when the service stops before it is finished.
+func (o *Orchestrator) Consume(ch <-chan events.Event) {
+	for e := range ch {
+		var err error
+		switch ev := e.Event.(type) {
+		case events.BytesReceived:
+			if err = o.Start(UploadFromEvent(ev)); err != nil {
+				o.log.Error().Err(err).Str("uploadid", ev.UploadID).Msg("could not start postprocessing")
+			}
+		case events.ResumePostprocessing:
+			if err = o.Resume(ev.UploadID, ev.Step); err != nil {
+				o.log.Error().Err(err).Str("uploadid", ev.UploadID).Msg("could not resume postprocessing")
+			}
+		}
+		if err != nil {
+			// the event is redelivered
+			_ = e.Nack()
+			continue
+		}
+		if err := e.Ack(); err != nil {
+			o.log.Error().Err(err).Str("id", e.ID).Msg("could not acknowledge event")
+		}
+	}
+}
+
+// Start runs the pipeline for a new upload. An upload that is already known is started over,
+// a run of another replica stops once it notices that it lost the lease.
+func (o *Orchestrator) Start(u Upload) error {
+	r := record{
+		Upload:     u,
+		URL:        u.URL,
+		Results:    map[events.Postprocessingstep]interface{}{},
+		Lease:      uuid.New().String(),
+		LeaseUntil: time.Now().Add(o.opts.LeaseDuration),
+	}
+	err := o.opts.Store.Update(u.ID, 0, func([]byte) ([]byte, error) {
+		return json.Marshal(r)
+	})
+	if err != nil {
+		return err
+	}
+	o.schedule(o.hold(r))
+	return nil
+}
+
+// Resume continues the postprocessing of an upload at the given step. If no step is given
+// the pipeline continues with the next unfinished step. An empty upload id resumes all
+// uploads that are currently not running. Uploads running on another replica are left alone.
+func (o *Orchestrator) Resume(uploadID string, step events.Postprocessingstep) error {
+	from := -1
+	if step != "" {
+		for i := range o.steps {
+			if o.steps[i].Name == step {
+				from = i
+			}
+		}
+		if from < 0 {
+			return fmt.Errorf("postprocessing: unknown step %q", step)
+		}
+	}
+	if uploadID == "" {
+		return o.scan(nil, from)
+	}
+
+	st, err := o.acquire(uploadID, nil, from)
+	switch {
+	case errors.Is(err, errLeased):
+		// the pipeline is running right now
+		return nil
+	case errors.Is(err, errUnknownUpload):
+		return fmt.Errorf("postprocessing: unknown upload %q", uploadID)
+	case err != nil:
+		return err
+	}
+	o.schedule(st)
+	return nil
+}
+
+// Scan resumes the uploads that are due for a retry or whose replica stopped without finishing them
+func (o *Orchestrator) Scan() error {
+	return o.scan(func(r *record, now time.Time) bool { return !r.RetryAt.After(now) }, -1)
+}
+
+// Watch scans the store in the given interval until the process ends
+func (o *Orchestrator) Watch(interval time.Duration) {
+	for {
+		if err := o.Scan(); err != nil {
+			o.log.Error().Err(err).Msg("could not scan postprocessing uploads")
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (o *Orchestrator) scan(due func(r *record, now time.Time) bool, from int) error {
+	ids, err := o.opts.Store.List()
+	if err != nil {
+		return fmt.Errorf("postprocessing: could not list uploads: %w", err)
+	}
+	for _, id := range ids {
+		st, err := o.acquire(id, due, from)
+		switch {
+		case errors.Is(err, errLeased), errors.Is(err, errNotDue), errors.Is(err, errUnknownUpload):
+			continue
+		case err != nil:
+			o.log.Error().Err(err).Str("uploadid", id).Msg("could not resume postprocessing")
+			continue
+		}
+		o.schedule(st)
+	}
+	return nil
+}
+
+// resumeDue resumes an upload when the wait that ends at retryAt is over, unless it was resumed in the meantime
+func (o *Orchestrator) resumeDue(id string, retryAt time.Time) {
+	st, err := o.acquire(id, func(r *record, _ time.Time) bool { return r.RetryAt.Equal(retryAt) }, -1)
+	switch {
+	case errors.Is(err, errLeased), errors.Is(err, errNotDue), errors.Is(err, errUnknownUpload):
+		return
+	case err != nil:
+		// the next scan tries again
+		o.log.Error().Err(err).Str("uploadid", id).Msg("could not resume postprocessing")
+		return
+	}
+	o.schedule(st)
+}
+
+// Status returns the state of the given upload
+func (o *Orchestrator) Status(uploadID string) (Status, bool) {
+	r, err := o.read(uploadID)
+	if err != nil {
+		return Status{}, false
+	}
+	return o.status(r, time.Now()), true
+}
+
+// List returns the state of all uploads in postprocessing
+func (o *Orchestrator) List() []Status {
+	ids, err := o.opts.Store.List()
+	if err != nil {
+		o.log.Error().Err(err).Msg("could not list postprocessing uploads")
+		return []Status{}
+	}
+	now := time.Now()
+	list := make([]Status, 0, len(ids))
+	for _, id := range ids {
+		if r, err := o.read(id); err == nil {
+			list = append(list, o.status(r, now))
+		}
+	}
+	return list
+}
+
+// Wait blocks until all currently running pipelines are done
+func (o *Orchestrator) Wait() {
+	o.wg.Wait()
+}
+
+func (o *Orchestrator) status(r record, now time.Time) Status {
+	s := Status{
+		Upload:   r.Upload,
+		Running:  r.running(now),
+		Failures: r.Failures,
+		Results:  r.Results,
+	}
+	if s.Results == nil {
+		s.Results = map[events.Postprocessingstep]interface{}{}
+	}
+	if r.Next < len(o.steps) {
+		s.NextStep = o.steps[r.Next].Name
+	}
+	return s
+}
+
+func (o *Orchestrator) schedule(st *state) {
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		done := make(chan struct{})
+		defer close(done)
+		go o.keepLease(st, done)
+
+		o.workers <- struct{}{}
+		defer func() { <-o.workers }()
+		o.run(st)
+	}()
+}
+
+// run executes the pipeline starting with the next unfinished step
+func (o *Orchestrator) run(st *state) {
+	ctx := st.ctx
+	log := o.log.With().Str("uploadid", st.rec.Upload.ID).Logger()
+
+	o.mu.Lock()
+	outcome, from := st.rec.Outcome, st.rec.Next
+	o.mu.Unlock()
+	if outcome != "" {
+		// the outcome could not be published before
+		o.finish(log, st, outcome)
+		return
+	}
+
+	for i := from; i < len(o.steps); i++ {
+		if ctx.Err() != nil {
+			// the lease was lost
+			return
+		}
+		ps := o.steps[i]
+		res, err := o.runStep(ctx, ps, st.rec.Upload)
+		outcome := res.Outcome
+		if outcome == "" {
+			outcome = events.PPOutcomeContinue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("step", string(ps.Name)).Msg("postprocessing step failed")
+			outcome = ps.OnError
+		}
+
+		o.mu.Lock()
+		st.rec.Results[ps.Name] = res.Data
+		st.rec.Next = i + 1
+		o.mu.Unlock()
+		if !o.persist(log, st, false) {
+			return
+		}
+
+		o.publish(log, events.PostprocessingStepFinished{
+			UploadID:      st.rec.Upload.ID,
+			ExecutingUser: st.rec.Upload.ExecutingUser,
+			Filename:      st.rec.Upload.Filename,
+			FinishedStep:  ps.Name,
+			Result:        res.Data,
+			Error:         err,
+			Outcome:       outcome,
+			Timestamp:     utils.TSNow(),
+		})
+
+		switch outcome {
+		case events.PPOutcomeContinue:
+			continue
+		case events.PPOutcomeRetry:
+			o.retry(log, st, i)
+			return
+		default:
+			o.finish(log, st, outcome)
+			return
+		}
+	}
+	o.finish(log, st, events.PPOutcomeContinue)
+}
+
+// runStep runs a single step with its timeout and retries
+func (o *Orchestrator) runStep(ctx context.Context, ps PipelineStep, u Upload) (Result, error) {
+	var (
+		res Result
+		err error
+	)
+	for attempt := 0; attempt <= ps.Retries; attempt++ {
+		if attempt > 0 {
+			if err := o.sleep(ctx, ps.RetryBackoff); err != nil {
+				return res, err
+			}
+		}
+		res, err = o.attempt(ctx, ps, u)
+		if err == nil {
+			return res, nil
+		}
+	}
+	return res, err
+}
+
+func (o *Orchestrator) attempt(ctx context.Context, ps PipelineStep, u Upload) (res Result, err error) {
+	if ps.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ps.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("step %s panicked: %v", ps.Name, r)
+		}
+	}()
+	return ps.Step.Run(ctx, u)
+}
+
+// retry gives up the lease until the step at index i is due again after a backoff. Any replica may
+// resume the upload then, this replica tries first.
+func (o *Orchestrator) retry(log zerolog.Logger, st *state, i int) {
+	o.mu.Lock()
+	st.rec.Failures++
+	failures := st.rec.Failures
+	st.rec.Next = i
+	o.mu.Unlock()
+
+	if failures > o.opts.MaxRetries {
+		log.Info().Int("failures", failures).Msg("too many postprocessing retries, aborting")
+		o.finish(log, st, events.PPOutcomeAbort)
+		return
+	}
+
+	backoff := o.opts.RetryBackoff << (failures - 1)
+	if backoff <= 0 || backoff > o.opts.MaxRetryBackoff {
+		backoff = o.opts.MaxRetryBackoff
+	}
+	o.publish(log, events.PostprocessingRetry{
+		UploadID:        st.rec.Upload.ID,
+		Filename:        st.rec.Upload.Filename,
+		ExecutingUser:   st.rec.Upload.ExecutingUser,
+		Failures:        failures,
+		BackoffDuration: backoff,
+	})
+	o.wait(log, st, backoff)
+}
+
+// wait gives up the lease of the upload until it is due again after the delay
+func (o *Orchestrator) wait(log zerolog.Logger, st *state, delay time.Duration) {
+	retryAt := time.Now().Add(delay)
+	o.mu.Lock()
+	st.rec.RetryAt = retryAt
+	o.mu.Unlock()
+	defer o.release(st)
+	if !o.persist(log, st, true) {
+		return
+	}
+	id := st.rec.Upload.ID
+	o.afterFunc(delay, func() { o.resumeDue(id, retryAt) })
+}
+
+func (o *Orchestrator) finish(log zerolog.Logger, st *state, outcome events.PostprocessingOutcome) {
+	o.mu.Lock()
+	results := make(map[events.Postprocessingstep]interface{}, len(st.rec.Results))
+	for k, v := range st.rec.Results {
+		results[k] = v
+	}
+	st.rec.Outcome = outcome
+	o.mu.Unlock()
+	if !o.persist(log, st, false) {
+		return
+	}
+
+	err := events.Publish(context.Background(), o.pub, events.PostprocessingFinished{
+		UploadID:          st.rec.Upload.ID,
+		Filename:          st.rec.Upload.Filename,
+		SpaceOwner:        st.rec.Upload.SpaceOwner,
+		ExecutingUser:     st.rec.Upload.ExecutingUser,
+		Result:            results,
+		Outcome:           outcome,
+		Timestamp:         utils.TSNow(),
+		ImpersonatingUser: st.rec.Upload.ImpersonatingUser,
+	})
+	if err != nil {
+		// the state is kept, resuming the upload publishes the outcome again
+		log.Error().Err(err).Msg("could not publish postprocessing outcome")
+		o.wait(log, st, o.opts.RetryBackoff)
+		return
+	}
+
+	// the state is only removed after the outcome was published, so a restart in between
+	// finishes the upload again
+	defer o.release(st)
+	if err := o.renew(st); err != nil {
+		// the upload was started over in the meantime
+		return
+	}
+	if err := o.opts.Store.Delete(st.rec.Upload.ID); err != nil {
+		log.Error().Err(err).Msg("could not delete postprocessing state")
+	}
+}
+
+// persist saves the state of a run. It returns false if the run has to stop because the lease
+// was lost. Other failures are only logged, after a restart the upload is resumed at an earlier step.
+func (o *Orchestrator) persist(log zerolog.Logger, st *state, giveUp bool) bool {
+	err := o.save(st, giveUp)
+	switch {
+	case errors.Is(err, errLeaseLost):
+		log.Info().Msg("postprocessing was started over or taken over, stopping")
+		o.release(st)
+		return false
+	case err != nil:
+		log.Error().Err(err).Msg("could not persist postprocessing state")
+	}
+	return true
+}
+
+func (o *Orchestrator) publish(log zerolog.Logger, ev interface{}) {
+	if err := events.Publish(context.Background(), o.pub, ev); err != nil {
+		log.Error().Err(err).Msgf("could not publish %T event", ev)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,638 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package postprocessing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	gmevents "go-micro.dev/v4/events"
+
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	events   []interface{}
+	finished chan events.PostprocessingFinished
+}
+
+func newRecorder() *recorder {
+	return &recorder{finished: make(chan events.PostprocessingFinished, 10)}
+}
+
+func (r *recorder) Publish(_ string, ev interface{}, _ ...gmevents.PublishOption) error {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+	if f, ok := ev.(events.PostprocessingFinished); ok {
+		r.finished <- f
+	}
+	return nil
+}
+
+func (r *recorder) wait(t *testing.T) events.PostprocessingFinished {
+	t.Helper()
+	select {
+	case f := <-r.finished:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("postprocessing did not finish")
+	}
+	return events.PostprocessingFinished{}
+}
+
+func (r *recorder) count(match func(interface{}) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, ev := range r.events {
+		if match(ev) {
+			n++
+		}
+	}
+	return n
+}
+
+type stepFunc func(ctx context.Context, u Upload) (Result, error)
+
+func (f stepFunc) Run(ctx context.Context, u Upload) (Result, error) {
+	return f(ctx, u)
+}
+
+func newTestOrchestrator(t *testing.T, pub events.Publisher, steps ...PipelineStep) *Orchestrator {
+	t.Helper()
+	o, err := NewOrchestrator(steps, pub, Options{MaxRetries: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.sleep = func(context.Context, time.Duration) error { return nil }
+	o.afterFunc = func(_ time.Duration, f func()) *time.Timer { return time.AfterFunc(time.Millisecond, f) }
+	return o
+}
+
+func TestExpression(t *testing.T) {
+	env := Env{
+		"filename":    "Report.EXE",
+		"extension":   "exe",
+		"size":        uint64(2 * 1000 * 1000),
+		"user.groups": []string{"staff", "admins"},
+		"internal":    true,
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`extension == "exe"`, true},
+		{`extension != 'exe'`, false},
+		{`extension in ["exe", "bat"]`, true},
+		{`"admins" in user.groups`, true},
+		{`"guests" in user.groups`, false},
+		{`size > 1MB`, true},
+		{`size >= 2MB && size <= 2MB`, true},
+		{`size < 1KiB`, false},
+		{`filename =~ "(?i)\\.exe$"`, true},
+		{`filename !~ "^Report"`, false},
+		{`!internal || size > 1GB`, false},
+		{`not (internal and size > 1GB)`, true},
+		{`"port" in filename`, true},
+	}
+	for _, tt := range tests {
+		e, err := CompileExpression(tt.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected compile error: %v", tt.expr, err)
+			continue
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Errorf("%s: unexpected eval error: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, src := range []string{`size >`, `extension == "exe`, `size > 10XB`, `(true`, `true false`, `filename =~ "["`, `a $ b`} {
+		if _, err := CompileExpression(src); err == nil {
+			t.Errorf("%s: expected compile error", src)
+		}
+	}
+
+	for _, src := range []string{`unknown == 1`, `size`, `size > "a"`, `!size`} {
+		e, err := CompileExpression(src)
+		if err != nil {
+			t.Fatalf("%s: unexpected compile error: %v", src, err)
+		}
+		if _, err := e.Eval(env); err == nil {
+			t.Errorf("%s: expected eval error", src)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	step, err := NewStep("policies", map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{"name": "no executables", "expression": `extension in ["exe", "bat"]`, "outcome": "delete"},
+			{"name": "size limit", "expression": `size > 10MB && !("admins" in user.groups)`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		upload  Upload
+		outcome events.PostprocessingOutcome
+		rule    string
+	}{
+		{Upload{Filename: "setup.EXE", Filesize: 10}, events.PPOutcomeDelete, "no executables"},
+		{Upload{Filename: "video.mp4", Filesize: 20 * 1000 * 1000}, events.PPOutcomeAbort, "size limit"},
+		{Upload{Filename: "video.mp4", Filesize: 20 * 1000 * 1000, ExecutingUser: &user.User{Groups: []string{"admins"}}}, events.PPOutcomeContinue, ""},
+		{Upload{Filename: "notes.txt", Filesize: 10}, events.PPOutcomeContinue, ""},
+	}
+	for _, tt := range tests {
+		res, err := step.Run(context.Background(), tt.upload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Outcome != tt.outcome || res.Data.(PolicyResult).Rule != tt.rule {
+			t.Errorf("%s: got %s (%s), want %s (%s)", tt.upload.Filename, res.Outcome, res.Data.(PolicyResult).Rule, tt.outcome, tt.rule)
+		}
+	}
+
+	if _, err := NewStep("policies", map[string]interface{}{
+		"rules": []map[string]interface{}{{"expression": "true", "outcome": "explode"}},
+	}); err == nil {
+		t.Error("expected error for invalid outcome")
+	}
+}
+
+// fakeClamd implements the INSTREAM part of the clamd protocol. Content containing
+// the word "EICAR" is reported as infected.
+func fakeClamd(t *testing.T) (string, *[]byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var (
+		mu       sync.Mutex
+		received []byte
+	)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+					_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data []byte
+				for {
+					var size uint32
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					chunk := make([]byte, size)
+					if _, err := io.ReadFull(conn, chunk); err != nil {
+						return
+					}
+					data = append(data, chunk...)
+				}
+				mu.Lock()
+				received = data
+				mu.Unlock()
+				if bytes.Contains(data, []byte("EICAR")) {
+					_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				_, _ = conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+	return "tcp://" + l.Addr().String(), &received
+}
+
+func TestClamAV(t *testing.T) {
+	addr, received := fakeClamd(t)
+	files := map[string]string{
+		"/clean":    strings.Repeat("harmless content ", 100),
+		"/infected": "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, content)
+	}))
+	defer srv.Close()
+
+	step, err := NewStep("virusscan", map[string]interface{}{"address": addr, "chunk_size": "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := step.Run(context.Background(), Upload{URL: srv.URL + "/clean", Filesize: uint64(len(files["/clean"]))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vr := res.Data.(events.VirusscanResult); res.Outcome != events.PPOutcomeContinue || vr.Infected {
+		t.Errorf("clean file: got %s %+v", res.Outcome, vr)
+	}
+	if string(*received) != files["/clean"] {
+		t.Errorf("clamd received %d bytes, want %d", len(*received), len(files["/clean"]))
+	}
+
+	res, err = step.Run(context.Background(), Upload{URL: srv.URL + "/infected", Filesize: uint64(len(files["/infected"]))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vr := res.Data.(events.VirusscanResult)
+	if res.Outcome != events.PPOutcomeDelete || !vr.Infected || vr.Description != "Eicar-Test-Signature" {
+		t.Errorf("infected file: got %s %+v", res.Outcome, vr)
+	}
+
+	res, err = step.Run(context.Background(), Upload{URL: srv.URL + "/missing", Filesize: 1})
+	if err == nil || res.Data.(events.VirusscanResult).ErrorMsg == "" {
+		t.Error("expected download error to be reported")
+	}
+
+	limited, err := NewStep("virusscan", map[string]interface{}{"address": addr, "max_scan_size": "10", "infected_action": "abort"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limited.Run(context.Background(), Upload{URL: srv.URL + "/clean", Filesize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*received) != 10 {
+		t.Errorf("clamd received %d bytes, want 10", len(*received))
+	}
+}
+
+func TestClamdReply(t *testing.T) {
+	if _, infected, err := parseClamdReply("stream: OK\x00"); err != nil || infected {
+		t.Errorf("OK reply: infected %v, err %v", infected, err)
+	}
+	if sig, infected, err := parseClamdReply("stream: Win.Test FOUND\x00"); err != nil || !infected || sig != "Win.Test" {
+		t.Errorf("FOUND reply: %s %v %v", sig, infected, err)
+	}
+	if _, _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Error("expected error reply to fail")
+	}
+}
+
+func TestOrchestratorOutcomes(t *testing.T) {
+	pass := stepFunc(func(context.Context, Upload) (Result, error) { return Result{Data: "ok"}, nil })
+	deny := stepFunc(func(context.Context, Upload) (Result, error) {
+		return Result{Outcome: events.PPOutcomeDelete}, nil
+	})
+
+	pub := newRecorder()
+	o := newTestOrchestrator(t, pub,
+		PipelineStep{Name: "first", Step: pass},
+		PipelineStep{Name: "second", Step: pass},
+	)
+	if err := o.Start(Upload{ID: "upload-1", Filename: "a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	f := pub.wait(t)
+	if f.UploadID != "upload-1" || f.Outcome != events.PPOutcomeContinue || len(f.Result) != 2 {
+		t.Errorf("unexpected finished event %+v", f)
+	}
+	if n := pub.count(func(ev interface{}) bool { _, ok := ev.(events.PostprocessingStepFinished); return ok }); n != 2 {
+		t.Errorf("got %d step finished events, want 2", n)
+	}
+	o.Wait()
+	if _, ok := o.Status("upload-1"); ok {
+		t.Error("finished upload should be forgotten")
+	}
+
+	pub = newRecorder()
+	called := false
+	o = newTestOrchestrator(t, pub,
+		PipelineStep{Name: "deny", Step: deny},
+		PipelineStep{Name: "never", Step: stepFunc(func(context.Context, Upload) (Result, error) {
+			called = true
+			return Result{}, nil
+		})},
+	)
+	o.Start(Upload{ID: "upload-2"})
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeDelete {
+		t.Errorf("got outcome %s, want delete", f.Outcome)
+	}
+	if called {
+		t.Error("steps after a deciding step must not run")
+	}
+}
+
+func TestOrchestratorStepFailures(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	flaky := stepFunc(func(context.Context, Upload) (Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return Result{}, errors.New("unavailable")
+		}
+		return Result{}, nil
+	})
+	broken := stepFunc(func(context.Context, Upload) (Result, error) { return Result{}, errors.New("broken") })
+	slow := stepFunc(func(ctx context.Context, _ Upload) (Result, error) {
+		<-ctx.Done()
+		return Result{}, ctx.Err()
+	})
+
+	pub := newRecorder()
+	o := newTestOrchestrator(t, pub, PipelineStep{Name: "flaky", Step: flaky, Retries: 2})
+	o.Start(Upload{ID: "flaky"})
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeContinue || attempts != 3 {
+		t.Errorf("got outcome %s after %d attempts", f.Outcome, attempts)
+	}
+
+	pub = newRecorder()
+	o = newTestOrchestrator(t, pub, PipelineStep{Name: "broken", Step: broken, OnError: events.PPOutcomeAbort})
+	o.Start(Upload{ID: "broken"})
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeAbort {
+		t.Errorf("got outcome %s, want abort", f.Outcome)
+	}
+
+	pub = newRecorder()
+	o = newTestOrchestrator(t, pub, PipelineStep{Name: "slow", Step: slow, Timeout: 10 * time.Millisecond, OnError: events.PPOutcomeContinue})
+	o.Start(Upload{ID: "slow"})
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeContinue {
+		t.Errorf("got outcome %s, want continue", f.Outcome)
+	}
+
+	// steps failing with the default error outcome are retried until the limit is reached
+	pub = newRecorder()
+	o = newTestOrchestrator(t, pub, PipelineStep{Name: "broken", Step: broken})
+	o.Start(Upload{ID: "retried"})
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeAbort {
+		t.Errorf("got outcome %s, want abort", f.Outcome)
+	}
+	if n := pub.count(func(ev interface{}) bool { _, ok := ev.(events.PostprocessingRetry); return ok }); n != 2 {
+		t.Errorf("got %d retry events, want 2", n)
+	}
+}
+
+func TestOrchestratorRetryAndResume(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		runs  = map[string]int{}
+		ready bool
+	)
+	count := func(name string) stepFunc {
+		return func(context.Context, Upload) (Result, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return Result{}, nil
+		}
+	}
+	wait := stepFunc(func(context.Context, Upload) (Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		runs["wait"]++
+		if !ready {
+			return Result{Outcome: events.PPOutcomeRetry}, nil
+		}
+		return Result{}, nil
+	})
+
+	pub := newRecorder()
+	o := newTestOrchestrator(t, pub,
+		PipelineStep{Name: "first", Step: count("first")},
+		PipelineStep{Name: "wait", Step: wait},
+	)
+	// keep the retry pending until the upload is resumed
+	o.afterFunc = func(time.Duration, func()) *time.Timer { return time.NewTimer(time.Hour) }
+	o.Start(Upload{ID: "upload"})
+	o.Wait()
+
+	st, ok := o.Status("upload")
+	if !ok || st.Running || st.Failures != 1 || st.NextStep != "wait" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	mu.Lock()
+	ready = true
+	mu.Unlock()
+	if err := o.Resume("upload", ""); err != nil {
+		t.Fatal(err)
+	}
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeContinue {
+		t.Errorf("got outcome %s, want continue", f.Outcome)
+	}
+	if runs["first"] != 1 || runs["wait"] != 2 {
+		t.Errorf("unexpected runs %v", runs)
+	}
+
+	if err := o.Resume("unknown", ""); err == nil {
+		t.Error("expected error resuming an unknown upload")
+	}
+	if err := o.Resume("", "nosuchstep"); err == nil {
+		t.Error("expected error resuming an unknown step")
+	}
+}
+
+func TestOrchestratorRestart(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		runs = map[string]int{}
+	)
+	count := func(name string, outcome events.PostprocessingOutcome) stepFunc {
+		return func(context.Context, Upload) (Result, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return Result{Outcome: outcome, Data: name}, nil
+		}
+	}
+	s, err := cas.New(cas.Config{Store: "memory", Database: "reva", Table: "postprocessing"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := newRecorder()
+	o, err := NewOrchestrator([]PipelineStep{
+		{Name: "first", Step: count("first", "")},
+		{Name: "wait", Step: count("wait", events.PPOutcomeRetry)},
+	}, pub, Options{Store: s}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the service stops while the retry is pending
+	o.afterFunc = func(time.Duration, func()) *time.Timer { return time.NewTimer(time.Hour) }
+	if err := o.Start(Upload{ID: "upload", URL: "https://example.org/data/token"}); err != nil {
+		t.Fatal(err)
+	}
+	o.Wait()
+
+	pub = newRecorder()
+	o, err = NewOrchestrator([]PipelineStep{
+		{Name: "first", Step: count("first", "")},
+		{Name: "wait", Step: stepFunc(func(_ context.Context, u Upload) (Result, error) {
+			if u.URL != "https://example.org/data/token" {
+				t.Errorf("got url %q after restart", u.URL)
+			}
+			return Result{Data: "done"}, nil
+		})},
+	}, pub, Options{Store: s}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := o.Status("upload")
+	if !ok || st.Running || st.Failures != 1 || st.NextStep != "wait" || st.Results["first"] != "first" {
+		t.Fatalf("unexpected status after restart %+v", st)
+	}
+	if err := o.Resume("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeContinue || f.Result["wait"] != "done" {
+		t.Errorf("unexpected finished event %+v", f)
+	}
+	o.Wait()
+	if runs["first"] != 1 {
+		t.Errorf("finished steps must not run again, got %v", runs)
+	}
+	if keys, _ := s.List(); len(keys) != 0 {
+		t.Errorf("finished uploads must be removed from the store, got %v", keys)
+	}
+}
+
+func TestOrchestratorReplicas(t *testing.T) {
+	s, err := cas.New(cas.Config{Store: "memory", Database: "reva", Table: "postprocessing"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu      sync.Mutex
+		runs    = map[string]int{}
+		release = make(chan struct{})
+	)
+	step := func(replica string) stepFunc {
+		return func(ctx context.Context, _ Upload) (Result, error) {
+			mu.Lock()
+			runs[replica]++
+			mu.Unlock()
+			select {
+			case <-release:
+				return Result{}, nil
+			case <-ctx.Done():
+				return Result{}, ctx.Err()
+			}
+		}
+	}
+	newReplica := func(name string, pub events.Publisher) *Orchestrator {
+		o, err := NewOrchestrator([]PipelineStep{{Name: "step", Step: step(name)}}, pub, Options{Store: s}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	pub := newRecorder()
+	a, b := newReplica("a", pub), newReplica("b", pub)
+	if err := a.Start(Upload{ID: "upload"}); err != nil {
+		t.Fatal(err)
+	}
+	// wait until the step runs on a
+	for i := 0; ; i++ {
+		mu.Lock()
+		started := runs["a"] == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		if i > 500 {
+			t.Fatal("the step did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// another replica neither resumes nor scans an upload that is running
+	if st, ok := b.Status("upload"); !ok || !st.Running {
+		t.Fatalf("expected the other replica to see the running upload, got %+v", st)
+	}
+	if err := b.Resume("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resume("upload", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if f := pub.wait(t); f.Outcome != events.PPOutcomeContinue {
+		t.Errorf("got outcome %s, want continue", f.Outcome)
+	}
+	a.Wait()
+	b.Wait()
+	if runs["a"] != 1 || runs["b"] != 0 {
+		t.Errorf("expected the upload to only run on a, got %v", runs)
+	}
+
+	// the upload of a replica that stopped is taken over once its lease expired
+	v, err := json.Marshal(record{Upload: Upload{ID: "abandoned"}, Lease: "gone", LeaseUntil: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("abandoned", 0, func([]byte) ([]byte, error) { return v, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if f := pub.wait(t); f.UploadID != "abandoned" {
+		t.Errorf("expected the abandoned upload to finish, got %+v", f)
+	}
+	b.Wait()
+	if runs["b"] != 1 {
+		t.Errorf("expected b to take the upload over, got %v", runs)
+	}
+}
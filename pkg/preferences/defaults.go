@@ -0,0 +1,100 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package preferences
+
+import (
+	"context"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+)
+
+// Default is a value an admin defines for a key. Locked values can not be overridden by the users.
+type Default struct {
+	Value  string `mapstructure:"value"`
+	Locked bool   `mapstructure:"locked"`
+}
+
+// Defaults maps namespaces to keys to their defaults
+type Defaults map[string]map[string]Default
+
+// Locked returns true if the key of the namespace has a locked default
+func (d Defaults) Locked(key, namespace string) bool {
+	return d[namespace][key].Locked
+}
+
+type defaultsManager struct {
+	Manager
+	defaults Defaults
+}
+
+// WithDefaults returns a manager that falls back to the given defaults for keys the user did not set
+// and that rejects changes to locked keys.
+func WithDefaults(m Manager, defaults Defaults) Manager {
+	if len(defaults) == 0 {
+		return m
+	}
+	return &defaultsManager{Manager: m, defaults: defaults}
+}
+
+func (m *defaultsManager) SetKey(ctx context.Context, key, namespace, value string) error {
+	if m.defaults.Locked(key, namespace) {
+		return errtypes.PermissionDenied("preferences: key " + key + " is locked")
+	}
+	return m.Manager.SetKey(ctx, key, namespace, value)
+}
+
+func (m *defaultsManager) GetKey(ctx context.Context, key, namespace string) (string, error) {
+	d, ok := m.defaults[namespace][key]
+	if ok && d.Locked {
+		return d.Value, nil
+	}
+	v, err := m.Manager.GetKey(ctx, key, namespace)
+	if _, notFound := err.(errtypes.IsNotFound); notFound && ok {
+		return d.Value, nil
+	}
+	return v, err
+}
+
+func (m *defaultsManager) ListKeys(ctx context.Context, namespace string) (map[string]string, error) {
+	km, ok := m.Manager.(KeyManager)
+	if !ok {
+		return nil, errtypes.NotSupported("preferences: listing keys")
+	}
+	keys, err := km.ListKeys(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for k, d := range m.defaults[namespace] {
+		if _, ok := keys[k]; !ok || d.Locked {
+			keys[k] = d.Value
+		}
+	}
+	return keys, nil
+}
+
+func (m *defaultsManager) DeleteKey(ctx context.Context, key, namespace string) error {
+	if m.defaults.Locked(key, namespace) {
+		return errtypes.PermissionDenied("preferences: key " + key + " is locked")
+	}
+	km, ok := m.Manager.(KeyManager)
+	if !ok {
+		return errtypes.NotSupported("preferences: deleting keys")
+	}
+	return km.DeleteKey(ctx, key, namespace)
+}
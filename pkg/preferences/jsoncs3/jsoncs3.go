@@ -0,0 +1,200 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package jsoncs3 implements a preferences manager persisting the preferences of every user
+// as json files in a metadata storage, one file per namespace.
+package jsoncs3
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registry.Register("jsoncs3", NewDefault)
+}
+
+// maxRetries is the number of times a change is retried when the file was modified concurrently
+const maxRetries = 3
+
+type config struct {
+	ProviderAddr      string `mapstructure:"provider_addr"`
+	ServiceUserID     string `mapstructure:"service_user_id"`
+	ServiceUserIdp    string `mapstructure:"service_user_idp"`
+	MachineAuthAPIKey string `mapstructure:"machine_auth_apikey"`
+}
+
+type mgr struct {
+	sync.Mutex // serializes changes made by this instance
+
+	storage  metadata.Storage
+	initOnce sync.Once
+	initErr  error
+}
+
+// NewDefault returns a new manager instance with default dependencies
+func NewDefault(m map[string]interface{}) (preferences.Manager, error) {
+	c := &config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "error creating a new manager")
+	}
+
+	s, err := metadata.NewCS3Storage(c.ProviderAddr, c.ProviderAddr, c.ServiceUserID, c.ServiceUserIdp, c.MachineAuthAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	return New(s)
+}
+
+// New returns a new manager instance using the given storage
+func New(s metadata.Storage) (preferences.Manager, error) {
+	return &mgr{storage: s}, nil
+}
+
+func (m *mgr) init() error {
+	m.initOnce.Do(func() {
+		m.initErr = m.storage.Init(context.Background(), "jsoncs3-preferences-data")
+	})
+	return m.initErr
+}
+
+func userDir(ctx context.Context) (string, error) {
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return "", errtypes.UserRequired("preferences: error getting user from ctx")
+	}
+	return url.PathEscape(u.Id.OpaqueId), nil
+}
+
+func namespaceFile(dir, namespace string) string {
+	return path.Join(dir, url.PathEscape(namespace)+".json")
+}
+
+// read returns the keys of the namespace and the etag of the file, which is empty if it does not exist yet
+func (m *mgr) read(ctx context.Context, namespace string) (map[string]string, string, error) {
+	if err := m.init(); err != nil {
+		return nil, "", err
+	}
+	dir, err := userDir(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	keys := map[string]string{}
+	res, err := m.storage.Download(ctx, metadata.DownloadRequest{Path: namespaceFile(dir, namespace)})
+	switch err.(type) {
+	case nil:
+	case errtypes.NotFound:
+		return keys, "", nil
+	default:
+		return nil, "", err
+	}
+	if err := json.Unmarshal(res.Content, &keys); err != nil {
+		return nil, "", errors.Wrap(err, "preferences: error decoding namespace "+namespace)
+	}
+	return keys, res.Etag, nil
+}
+
+// update applies the change to the keys of the namespace and writes them back, retrying if the file
+// was changed by another instance in the meantime
+func (m *mgr) update(ctx context.Context, namespace string, change func(keys map[string]string) error) error {
+	m.Lock()
+	defer m.Unlock()
+
+	dir, err := userDir(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		keys, etag, err := m.read(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		if err := change(keys); err != nil {
+			return err
+		}
+		b, err := json.Marshal(keys)
+		if err != nil {
+			return err
+		}
+		req := metadata.UploadRequest{
+			Path:        namespaceFile(dir, namespace),
+			Content:     b,
+			IfMatchEtag: etag,
+		}
+		if etag == "" {
+			if err := m.storage.MakeDirIfNotExist(ctx, dir); err != nil {
+				return err
+			}
+			req.IfNoneMatch = []string{"*"}
+		}
+		_, err = m.storage.Upload(ctx, req)
+		switch err.(type) {
+		case nil:
+			return nil
+		case errtypes.PreconditionFailed, errtypes.Aborted, errtypes.AlreadyExists:
+			if i < maxRetries {
+				continue
+			}
+		}
+		return err
+	}
+}
+
+func (m *mgr) SetKey(ctx context.Context, key, namespace, value string) error {
+	return m.update(ctx, namespace, func(keys map[string]string) error {
+		keys[key] = value
+		return nil
+	})
+}
+
+func (m *mgr) GetKey(ctx context.Context, key, namespace string) (string, error) {
+	keys, _, err := m.read(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	if value, ok := keys[key]; ok {
+		return value, nil
+	}
+	return "", errtypes.NotFound("preferences: key not found")
+}
+
+func (m *mgr) ListKeys(ctx context.Context, namespace string) (map[string]string, error) {
+	keys, _, err := m.read(ctx, namespace)
+	return keys, err
+}
+
+func (m *mgr) DeleteKey(ctx context.Context, key, namespace string) error {
+	return m.update(ctx, namespace, func(keys map[string]string) error {
+		if _, ok := keys[key]; !ok {
+			return errtypes.NotFound("preferences: key not found")
+		}
+		delete(keys, key)
+		return nil
+	})
+}
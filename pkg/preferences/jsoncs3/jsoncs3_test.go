@@ -0,0 +1,128 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package jsoncs3
+
+import (
+	"context"
+	"testing"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+)
+
+func userContext(id string) context.Context {
+	return ctxpkg.ContextSetUser(context.Background(), &userpb.User{Id: &userpb.UserId{OpaqueId: id}})
+}
+
+func TestPreferences(t *testing.T) {
+	dir := t.TempDir()
+	s, err := metadata.NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	einstein, marie := userContext("einstein"), userContext("marie")
+
+	if _, err := m.GetKey(einstein, "lang", "core"); err == nil {
+		t.Fatal("expected missing key to fail")
+	}
+	if err := m.SetKey(einstein, "lang", "core", "de"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetKey(einstein, "theme", "core", "dark"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetKey(einstein, "lang", "other/ns", "fr"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetKey(marie, "lang", "core", "pl"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new instance reads the persisted keys
+	s, _ = metadata.NewDiskStorage(dir)
+	m, _ = New(s)
+	if v, err := m.GetKey(einstein, "lang", "core"); err != nil || v != "de" {
+		t.Fatalf("expected de, got %s %v", v, err)
+	}
+	if v, err := m.GetKey(einstein, "lang", "other/ns"); err != nil || v != "fr" {
+		t.Fatalf("expected fr, got %s %v", v, err)
+	}
+	if v, err := m.GetKey(marie, "lang", "core"); err != nil || v != "pl" {
+		t.Fatalf("expected pl, got %s %v", v, err)
+	}
+	keys, err := m.(preferences.KeyManager).ListKeys(einstein, "core")
+	if err != nil || len(keys) != 2 || keys["theme"] != "dark" {
+		t.Fatalf("unexpected keys %v %v", keys, err)
+	}
+
+	if err := m.(preferences.KeyManager).DeleteKey(einstein, "lang", "core"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetKey(einstein, "lang", "core"); err == nil {
+		t.Fatal("expected deleted key to be gone")
+	}
+	if _, ok := m.(preferences.KeyManager).DeleteKey(einstein, "lang", "core").(errtypes.NotFound); !ok {
+		t.Fatal("expected deleting a missing key to return NotFound")
+	}
+	if _, err := m.(preferences.KeyManager).ListKeys(context.Background(), "core"); err == nil {
+		t.Fatal("expected listing without user to fail")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	s, err := metadata.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, _ := New(s)
+	m := preferences.WithDefaults(pm, preferences.Defaults{
+		"core": {
+			"lang":  {Value: "en"},
+			"quota": {Value: "1GB", Locked: true},
+		},
+	})
+	ctx := userContext("einstein")
+
+	if v, _ := m.GetKey(ctx, "lang", "core"); v != "en" {
+		t.Fatalf("expected default en, got %s", v)
+	}
+	if err := m.SetKey(ctx, "lang", "core", "de"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.GetKey(ctx, "lang", "core"); v != "de" {
+		t.Fatalf("expected de, got %s", v)
+	}
+	if _, ok := m.SetKey(ctx, "quota", "core", "1TB").(errtypes.PermissionDenied); !ok {
+		t.Fatal("expected locked key to be rejected")
+	}
+	if _, ok := m.(preferences.KeyManager).DeleteKey(ctx, "quota", "core").(errtypes.PermissionDenied); !ok {
+		t.Fatal("expected deleting a locked key to be rejected")
+	}
+	keys, err := m.(preferences.KeyManager).ListKeys(ctx, "core")
+	if err != nil || keys["lang"] != "de" || keys["quota"] != "1GB" {
+		t.Fatalf("unexpected keys %v %v", keys, err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package keys defines the grpc api to list and delete preferences, which the CS3 preferences api has no calls for.
+//
+// The api reuses the messages of the CS3 preferences api: listing takes a GetKeyRequest with the namespace and
+// returns the keys and values as a json object in the value of the GetKeyResponse, deleting takes a SetKeyRequest
+// with the key. Services that do not offer the api, e.g. older gateways, answer with codes.Unimplemented.
+package keys
+
+import (
+	"context"
+
+	preferences "github.com/cs3org/go-cs3apis/cs3/preferences/v1beta1"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the full name of the grpc service
+const ServiceName = "revad.preferences.v1beta1.KeysAPI"
+
+const (
+	listMethod   = "/" + ServiceName + "/ListKeys"
+	deleteMethod = "/" + ServiceName + "/DeleteKey"
+)
+
+// KeysAPIServer is the server api for listing and deleting preferences
+type KeysAPIServer interface {
+	// ListKeys returns all keys of the namespace and their values as a json object
+	ListKeys(context.Context, *preferences.GetKeyRequest) (*preferences.GetKeyResponse, error)
+	// DeleteKey removes the key, the value of the request is ignored
+	DeleteKey(context.Context, *preferences.SetKeyRequest) (*preferences.SetKeyResponse, error)
+}
+
+// KeysAPIClient is the client api for listing and deleting preferences
+type KeysAPIClient interface {
+	// ListKeys returns all keys of the namespace and their values as a json object
+	ListKeys(ctx context.Context, in *preferences.GetKeyRequest, opts ...grpc.CallOption) (*preferences.GetKeyResponse, error)
+	// DeleteKey removes the key, the value of the request is ignored
+	DeleteKey(ctx context.Context, in *preferences.SetKeyRequest, opts ...grpc.CallOption) (*preferences.SetKeyResponse, error)
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewKeysAPIClient returns a new client for the keys api
+func NewKeysAPIClient(cc grpc.ClientConnInterface) KeysAPIClient {
+	return &client{cc: cc}
+}
+
+func (c *client) ListKeys(ctx context.Context, in *preferences.GetKeyRequest, opts ...grpc.CallOption) (*preferences.GetKeyResponse, error) {
+	out := new(preferences.GetKeyResponse)
+	if err := c.cc.Invoke(ctx, listMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) DeleteKey(ctx context.Context, in *preferences.SetKeyRequest, opts ...grpc.CallOption) (*preferences.SetKeyResponse, error) {
+	out := new(preferences.SetKeyResponse)
+	if err := c.cc.Invoke(ctx, deleteMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterKeysAPIServer registers the keys api with the grpc server
+func RegisterKeysAPIServer(s grpc.ServiceRegistrar, srv KeysAPIServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*KeysAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListKeys",
+			Handler:    listHandler,
+		},
+		{
+			MethodName: "DeleteKey",
+			Handler:    deleteHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func listHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(preferences.GetKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeysAPIServer).ListKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: listMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeysAPIServer).ListKeys(ctx, req.(*preferences.GetKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func deleteHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(preferences.SetKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeysAPIServer).DeleteKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: deleteMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeysAPIServer).DeleteKey(ctx, req.(*preferences.SetKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -20,6 +20,7 @@ package loader
 
 import (
 	// Load preferences drivers.
+	_ "github.com/opencloud-eu/reva/v2/pkg/preferences/jsoncs3"
 	_ "github.com/opencloud-eu/reva/v2/pkg/preferences/memory"
 	// Add your own here
 )
@@ -34,12 +34,12 @@ func init() {
 
 type mgr struct {
 	sync.RWMutex
-	keys map[string]map[string]string
+	keys map[string]map[string]map[string]string // user id -> namespace -> key -> value
 }
 
 // New returns an instance of the in-memory preferences manager.
 func New(m map[string]interface{}) (preferences.Manager, error) {
-	return &mgr{keys: make(map[string]map[string]string)}, nil
+	return &mgr{keys: make(map[string]map[string]map[string]string)}, nil
 }
 
 func (m *mgr) SetKey(ctx context.Context, key, namespace, value string) error {
@@ -52,11 +52,13 @@ func (m *mgr) SetKey(ctx context.Context, key, namespace, value string) error {
 
 	userKey := u.Id.OpaqueId
 
-	if len(m.keys[userKey]) == 0 {
-		m.keys[userKey] = map[string]string{key: value}
-	} else {
-		m.keys[userKey][key] = value
+	if m.keys[userKey] == nil {
+		m.keys[userKey] = map[string]map[string]string{}
 	}
+	if m.keys[userKey][namespace] == nil {
+		m.keys[userKey][namespace] = map[string]string{}
+	}
+	m.keys[userKey][namespace][key] = value
 	return nil
 }
 
@@ -70,10 +72,40 @@ func (m *mgr) GetKey(ctx context.Context, key, namespace string) (string, error)
 
 	userKey := u.Id.OpaqueId
 
-	if len(m.keys[userKey]) != 0 {
-		if value, ok := m.keys[userKey][key]; ok {
-			return value, nil
-		}
+	if value, ok := m.keys[userKey][namespace][key]; ok {
+		return value, nil
 	}
 	return "", errtypes.NotFound("preferences: key not found")
 }
+
+func (m *mgr) ListKeys(ctx context.Context, namespace string) (map[string]string, error) {
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return nil, errtypes.UserRequired("preferences: error getting user from ctx")
+	}
+	m.RLock()
+	defer m.RUnlock()
+
+	keys := map[string]string{}
+	for k, v := range m.keys[u.Id.OpaqueId][namespace] {
+		keys[k] = v
+	}
+	return keys, nil
+}
+
+func (m *mgr) DeleteKey(ctx context.Context, key, namespace string) error {
+	u, ok := ctxpkg.ContextGetUser(ctx)
+	if !ok {
+		return errtypes.UserRequired("preferences: error getting user from ctx")
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	userKey := u.Id.OpaqueId
+
+	if _, ok := m.keys[userKey][namespace][key]; !ok {
+		return errtypes.NotFound("preferences: key not found")
+	}
+	delete(m.keys[userKey][namespace], key)
+	return nil
+}
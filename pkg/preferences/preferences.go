@@ -29,3 +29,11 @@ type Manager interface {
 	// GetKey returns the value for a combination of key and namespace, if set.
 	GetKey(ctx context.Context, key, namespace string) (string, error)
 }
+
+// KeyManager is implemented by managers that can also list and delete keys
+type KeyManager interface {
+	// ListKeys returns all keys and their values set in a namespace.
+	ListKeys(ctx context.Context, namespace string) (map[string]string, error)
+	// DeleteKey removes a key from a namespace.
+	DeleteKey(ctx context.Context, key, namespace string) error
+}
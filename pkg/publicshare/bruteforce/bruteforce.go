@@ -0,0 +1,261 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package bruteforce protects password protected public links against guessing.
+// Attempts are counted per link token and client ip. After a number of free
+// attempts every further attempt blocks the client with an exponentially
+// growing delay until it is locked out completely for a while. Checking and
+// counting an attempt is one atomic update, so parallel guesses can not all
+// pass the check.
+// Because clients can change their ip, failed attempts are also counted per link
+// token alone. That count never blocks the link, anybody knowing the token could
+// lock out everybody else then. Once it is exceeded, clients only lose their
+// free attempts and a warning is raised.
+package bruteforce
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+// Config holds the brute force protection configuration
+type Config struct {
+	Store        string   `mapstructure:"store" docs:"memory;The store to keep the failed attempts in. Use a shared store like nats-js-kv or redis when running multiple replicas."`
+	Nodes        []string `mapstructure:"nodes" docs:";The nodes of the store."`
+	Database     string   `mapstructure:"database" docs:"reva;The database of the store."`
+	Table        string   `mapstructure:"table" docs:"publicshare-bruteforce;The table of the store."`
+	AuthUsername string   `mapstructure:"auth_username" docs:";The username to authenticate with the store."`
+	AuthPassword string   `mapstructure:"auth_password" docs:";The password to authenticate with the store."`
+
+	FreeAttempts    int `mapstructure:"free_attempts" docs:"3;The number of failed attempts before the client has to wait between attempts."`
+	BaseDelay       int `mapstructure:"base_delay" docs:"1;The delay in seconds after the first failure exceeding the free attempts. It doubles with every further failure."`
+	MaxDelay        int `mapstructure:"max_delay" docs:"60;The maximum delay in seconds between two attempts."`
+	LockoutAttempts int `mapstructure:"lockout_attempts" docs:"10;The number of failed attempts after which the client is locked out. Clients without a known ip are never locked out, they share one counter and are only delayed."`
+	LockoutDuration int `mapstructure:"lockout_duration" docs:"3600;The number of seconds a client stays locked out."`
+	ResetAfter      int `mapstructure:"reset_after" docs:"3600;The number of seconds after which failed attempts are forgotten."`
+
+	LinkFreeAttempts  int `mapstructure:"link_free_attempts" docs:"20;The number of failed attempts from all clients after which clients have no free attempts on the link anymore."`
+	LinkAlertAttempts int `mapstructure:"link_alert_attempts" docs:"100;The number of failed attempts from all clients after which a warning is raised for the link. The link is not locked."`
+}
+
+func (c *Config) init() {
+	if c.Database == "" {
+		c.Database = "reva"
+	}
+	if c.Table == "" {
+		c.Table = "publicshare-bruteforce"
+	}
+	if c.FreeAttempts <= 0 {
+		c.FreeAttempts = 3
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = 1
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 60
+	}
+	if c.LockoutAttempts <= 0 {
+		c.LockoutAttempts = 10
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = 3600
+	}
+	if c.ResetAfter <= 0 {
+		c.ResetAfter = 3600
+	}
+	if c.LinkFreeAttempts <= 0 {
+		c.LinkFreeAttempts = 20
+	}
+	if c.LinkAlertAttempts <= 0 {
+		c.LinkAlertAttempts = 100
+	}
+}
+
+// Failure describes the state of a client if its attempt fails
+type Failure struct {
+	// Attempts is the number of consecutive attempts of the client
+	Attempts int
+	// RetryAfter is the duration the client has to wait before the next attempt
+	RetryAfter time.Duration
+	// LockedOut is true if the attempt locked the client out
+	LockedOut bool
+	// LinkAttempts is the number of failed attempts on the link, it is set by Fail
+	LinkAttempts int
+	// LinkAlert is true if the failure reached the alert threshold of the link, it is set by Fail
+	LinkAlert bool
+}
+
+// Tracker keeps track of failed attempts
+type Tracker struct {
+	conf  *Config
+	store cas.Store
+}
+
+type record struct {
+	Attempts     int   `json:"attempts"`
+	BlockedUntil int64 `json:"blocked_until"`
+}
+
+// errBlocked aborts the update of a blocked client
+var errBlocked = errors.New("bruteforce: client is blocked")
+
+// New returns a new tracker
+func New(c *Config) (*Tracker, error) {
+	c.init()
+	ttl := time.Duration(max(c.ResetAfter, c.LockoutDuration)) * time.Second
+	s, err := cas.New(cas.Config{
+		Store:        c.Store,
+		Nodes:        c.Nodes,
+		Database:     c.Database,
+		Table:        c.Table,
+		AuthUsername: c.AuthUsername,
+		AuthPassword: c.AuthPassword,
+	}, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracker{conf: c, store: s}, nil
+}
+
+// Attempt checks whether the client may try the given link and counts the attempt in the same step.
+// A positive duration means the client has to wait that long and nothing was counted. Otherwise the
+// returned failure describes the state of the client in case the attempt fails, it is passed to Fail then.
+// A successful attempt has to be reported with Succeed.
+func (t *Tracker) Attempt(token, ip string) (time.Duration, Failure, error) {
+	// the link wide count is only read, it does not need to be exact to take away the free attempts
+	link, err := t.read(linkKey(token))
+	if err != nil {
+		return 0, Failure{}, err
+	}
+	freeAttempts := t.conf.FreeAttempts
+	if link.Attempts >= t.conf.LinkFreeAttempts {
+		freeAttempts = 0
+	}
+	lockoutAttempts := t.conf.LockoutAttempts
+	if ip == "" {
+		// clients without an ip share one counter, locking it would lock them all out
+		lockoutAttempts = math.MaxInt
+	}
+
+	var (
+		wait time.Duration
+		f    Failure
+	)
+	err = t.store.Update(key(token, ip), t.ttl(), func(old []byte) ([]byte, error) {
+		r, err := decode(old)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if wait = blockedFor(r, now); wait > 0 {
+			return nil, errBlocked
+		}
+		f = t.count(&r, now, freeAttempts, lockoutAttempts)
+		return json.Marshal(r)
+	})
+	switch {
+	case errors.Is(err, errBlocked):
+		return wait, Failure{}, nil
+	case err != nil:
+		return 0, Failure{}, err
+	}
+	return 0, f, nil
+}
+
+// count adds an attempt to the record and blocks the client if it exceeds the free attempts
+func (t *Tracker) count(r *record, now time.Time, freeAttempts, lockoutAttempts int) Failure {
+	r.Attempts++
+	f := Failure{Attempts: r.Attempts}
+	switch {
+	case r.Attempts >= lockoutAttempts:
+		// start over once the lockout has expired
+		f.LockedOut = true
+		f.RetryAfter = time.Duration(t.conf.LockoutDuration) * time.Second
+		r.Attempts = 0
+	case r.Attempts > freeAttempts:
+		delay := float64(t.conf.BaseDelay) * math.Pow(2, float64(r.Attempts-freeAttempts-1))
+		f.RetryAfter = time.Duration(math.Min(delay, float64(t.conf.MaxDelay))) * time.Second
+	}
+	// never shorten a block that is already in place
+	r.BlockedUntil = max(r.BlockedUntil, now.Add(f.RetryAfter).UnixNano())
+	return f
+}
+
+// Fail records a failed attempt on the link. f is the failure returned by Attempt for the attempt,
+// it is returned with the link wide count added.
+func (t *Tracker) Fail(token string, f Failure) (Failure, error) {
+	err := t.store.Update(linkKey(token), t.ttl(), func(old []byte) ([]byte, error) {
+		r, err := decode(old)
+		if err != nil {
+			return nil, err
+		}
+		r.Attempts++
+		f.LinkAttempts = r.Attempts
+		f.LinkAlert = r.Attempts == t.conf.LinkAlertAttempts
+		return json.Marshal(r)
+	})
+	return f, err
+}
+
+// Succeed forgets the attempts of the client after a successful attempt.
+// The failed attempts on the link are kept, otherwise a client knowing the password
+// could reset the counter for everybody else.
+func (t *Tracker) Succeed(token, ip string) error {
+	return t.store.Delete(key(token, ip))
+}
+
+func (t *Tracker) ttl() time.Duration {
+	return time.Duration(max(t.conf.ResetAfter, t.conf.LockoutDuration)) * time.Second
+}
+
+func (t *Tracker) read(k string) (record, error) {
+	v, err := t.store.Get(k)
+	if err != nil {
+		return record{}, err
+	}
+	return decode(v)
+}
+
+func decode(v []byte) (record, error) {
+	r := record{}
+	if v == nil {
+		return r, nil
+	}
+	err := json.Unmarshal(v, &r)
+	return r, err
+}
+
+func blockedFor(r record, now time.Time) time.Duration {
+	until := time.Unix(0, r.BlockedUntil)
+	if r.BlockedUntil == 0 || !until.After(now) {
+		return 0
+	}
+	return until.Sub(now)
+}
+
+func key(token, ip string) string {
+	return token + "|" + ip
+}
+
+func linkKey(token string) string {
+	return token
+}
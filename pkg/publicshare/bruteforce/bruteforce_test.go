@@ -0,0 +1,211 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package bruteforce
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTracker(t *testing.T, c *Config) *Tracker {
+	tr, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// fail makes an attempt that fails
+func fail(t *testing.T, tr *Tracker, token, ip string) (time.Duration, Failure) {
+	d, f, err := tr.Attempt(token, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d > 0 {
+		return d, Failure{}
+	}
+	f, err = tr.Fail(token, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, f
+}
+
+// wait returns how long the client would have to wait without counting an attempt
+func wait(tr *Tracker, token, ip string) time.Duration {
+	r, _ := tr.read(key(token, ip))
+	return blockedFor(r, time.Now())
+}
+
+// unblock skips the delay of the client, the attempts are kept
+func unblock(t *testing.T, tr *Tracker, token, ip string) {
+	err := tr.store.Update(key(token, ip), 0, func(old []byte) ([]byte, error) {
+		r, err := decode(old)
+		if err != nil {
+			return nil, err
+		}
+		r.BlockedUntil = 0
+		return json.Marshal(r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := newTracker(t, &Config{FreeAttempts: 2, BaseDelay: 1, MaxDelay: 2, LockoutAttempts: 5, LockoutDuration: 60})
+
+	expected := []Failure{
+		{Attempts: 1, LinkAttempts: 1},
+		{Attempts: 2, LinkAttempts: 2},
+		{Attempts: 3, LinkAttempts: 3, RetryAfter: time.Second},
+		{Attempts: 4, LinkAttempts: 4, RetryAfter: 2 * time.Second},
+		{Attempts: 5, LinkAttempts: 5, RetryAfter: time.Minute, LockedOut: true},
+	}
+	for _, e := range expected {
+		unblock(t, tr, "token", "10.0.0.1")
+		if _, f := fail(t, tr, "token", "10.0.0.1"); f != e {
+			t.Errorf("expected %+v, got %+v", e, f)
+		}
+	}
+
+	d, _, err := tr.Attempt("token", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d <= 55*time.Second || d > time.Minute {
+		t.Errorf("expected the client to be locked out for a minute, got %s", d)
+	}
+
+	// other clients and links are not affected
+	for _, c := range [][2]string{{"token", "10.0.0.2"}, {"other", "10.0.0.1"}} {
+		if d, _, _ := tr.Attempt(c[0], c[1]); d != 0 {
+			t.Errorf("expected %v to be allowed, got %s", c, d)
+		}
+	}
+
+	if err := tr.Succeed("token", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if d := wait(tr, "token", "10.0.0.1"); d != 0 {
+		t.Errorf("expected a success to reset the failures, got %s", d)
+	}
+	if _, f := fail(t, tr, "token", "10.0.0.1"); f.Attempts != 1 {
+		t.Errorf("expected the attempts to start over, got %d", f.Attempts)
+	}
+}
+
+func TestLockoutIsNotShortened(t *testing.T) {
+	tr := newTracker(t, &Config{FreeAttempts: 1, BaseDelay: 1, MaxDelay: 1, LockoutAttempts: 2, LockoutDuration: 60})
+
+	fail(t, tr, "token", "10.0.0.1")
+	if _, f := fail(t, tr, "token", "10.0.0.1"); !f.LockedOut {
+		t.Fatalf("expected a lockout, got %+v", f)
+	}
+	// the attempts start over with the lockout, counting another one must not lift it
+	r, _ := tr.read(key("token", "10.0.0.1"))
+	f := tr.count(&r, time.Now(), 1, 2)
+	if f.RetryAfter != 0 || blockedFor(r, time.Now()) <= 55*time.Second {
+		t.Errorf("expected the lockout to be kept, got %+v blocked for %s", f, blockedFor(r, time.Now()))
+	}
+}
+
+func TestParallelAttemptsAreCounted(t *testing.T) {
+	tr := newTracker(t, &Config{FreeAttempts: 3, BaseDelay: 60, MaxDelay: 60, LockoutAttempts: 10})
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, _, err := tr.Attempt("token", "10.0.0.1")
+			if err != nil {
+				t.Error(err)
+			}
+			if d == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// the free attempts and the one that starts the delay
+	if allowed != 4 {
+		t.Errorf("expected 4 parallel attempts to be allowed, got %d", allowed)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	tr := newTracker(t, &Config{FreeAttempts: 1, BaseDelay: 1, MaxDelay: 4, LockoutAttempts: 100})
+	var f Failure
+	r := record{}
+	for i := 0; i < 10; i++ {
+		f = tr.count(&r, time.Now(), 1, 100)
+	}
+	if f.RetryAfter != 4*time.Second || f.LockedOut {
+		t.Errorf("expected the delay to be capped, got %+v", f)
+	}
+}
+
+func TestClientsWithoutIPAreNotLockedOut(t *testing.T) {
+	tr := newTracker(t, &Config{FreeAttempts: 1, BaseDelay: 1, MaxDelay: 1, LockoutAttempts: 2, LockoutDuration: 60})
+
+	for i := 0; i < 3; i++ {
+		unblock(t, tr, "token", "")
+		if _, f := fail(t, tr, "token", ""); f.LockedOut {
+			t.Fatalf("expected clients without ip to not be locked out, got %+v", f)
+		}
+	}
+	if d := wait(tr, "token", ""); d > time.Second {
+		t.Errorf("expected clients without ip to only be delayed, got %s", d)
+	}
+}
+
+func TestLinkIsThrottledForChangingIPs(t *testing.T) {
+	tr := newTracker(t, &Config{FreeAttempts: 2, LinkFreeAttempts: 3, BaseDelay: 1, MaxDelay: 2, LockoutAttempts: 5, LinkAlertAttempts: 5, LockoutDuration: 60})
+
+	var f Failure
+	for i := 0; i < 5; i++ {
+		_, f = fail(t, tr, "token", fmt.Sprintf("10.0.0.%d", i))
+		if i == 3 && f.RetryAfter != time.Second {
+			t.Errorf("expected new clients to lose their free attempts on the link, got %+v", f)
+		}
+	}
+	if !f.LinkAlert || f.LinkAttempts != 5 || f.LockedOut {
+		t.Errorf("expected an alert but no lockout after failures from different ips, got %+v", f)
+	}
+	// the link is not locked, new clients may still try once
+	if d, _, _ := tr.Attempt("token", "10.0.0.99"); d != 0 {
+		t.Errorf("expected new clients to be allowed, got %s", d)
+	}
+	if d := wait(tr, "token", "10.0.0.99"); d == 0 || d > time.Second {
+		t.Errorf("expected new clients to be delayed after their attempt, got %s", d)
+	}
+	if _, f, _ := tr.Attempt("other", "10.0.0.99"); f.RetryAfter != 0 {
+		t.Errorf("expected other links not to be affected, got %+v", f)
+	}
+}
@@ -42,6 +42,8 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json/persistence"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json/persistence/cs3"
@@ -49,6 +51,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json/persistence/memory"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/pkg/errors"
@@ -73,7 +76,7 @@ func NewFile(c map[string]interface{}) (publicshare.Manager, error) {
 	}
 
 	p := file.New(conf.File)
-	return New(conf.GatewayAddr, conf.SharePasswordHashCost, conf.JanitorRunInterval, conf.EnableExpiredSharesCleanup, p)
+	return newWithConfig(&conf.commonConfig, p)
 }
 
 // NewMemory returns a new in-memory public shares manager.
@@ -86,7 +89,7 @@ func NewMemory(c map[string]interface{}) (publicshare.Manager, error) {
 	conf.init()
 	p := memory.New()
 
-	return New(conf.GatewayAddr, conf.SharePasswordHashCost, conf.JanitorRunInterval, conf.EnableExpiredSharesCleanup, p)
+	return newWithConfig(conf, p)
 }
 
 // NewCS3 returns a new cs3 public shares manager.
@@ -104,7 +107,7 @@ func NewCS3(c map[string]interface{}) (publicshare.Manager, error) {
 	}
 	p := cs3.New(s)
 
-	return New(conf.GatewayAddr, conf.SharePasswordHashCost, conf.JanitorRunInterval, conf.EnableExpiredSharesCleanup, p)
+	return newWithConfig(&conf.commonConfig, p)
 }
 
 // New returns a new public share manager instance
@@ -122,11 +125,44 @@ func New(gwAddr string, pwHashCost, janitorRunInterval int, enableCleanup bool,
 	return m, nil
 }
 
+// newWithConfig returns a new public share manager that publishes events and runs the expiry sweeper
+// if configured
+func newWithConfig(c *commonConfig, p persistence.Persistence) (publicshare.Manager, error) {
+	pm, err := New(c.GatewayAddr, c.SharePasswordHashCost, c.JanitorRunInterval, c.EnableExpiredSharesCleanup, p)
+	if err != nil {
+		return nil, err
+	}
+	m := pm.(*manager)
+	if c.Events.Endpoint != "" {
+		m.eventStream, err = stream.NatsFromConfig("json-publicshare-manager", false, c.Events)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sweeper, err := expiry.New("json-publicshares", c.Expiry, m.SweepExpiredShares)
+	if err != nil {
+		return nil, err
+	}
+	sweeper.Start()
+	m.sweeper = sweeper
+	return m, nil
+}
+
+// Close stops the expiry sweeper
+func (m *manager) Close() error {
+	if m.sweeper != nil {
+		m.sweeper.Stop()
+	}
+	return nil
+}
+
 type commonConfig struct {
-	GatewayAddr                string `mapstructure:"gateway_addr"`
-	SharePasswordHashCost      int    `mapstructure:"password_hash_cost"`
-	JanitorRunInterval         int    `mapstructure:"janitor_run_interval"`
-	EnableExpiredSharesCleanup bool   `mapstructure:"enable_expired_shares_cleanup"`
+	GatewayAddr                string            `mapstructure:"gateway_addr"`
+	SharePasswordHashCost      int               `mapstructure:"password_hash_cost"`
+	JanitorRunInterval         int               `mapstructure:"janitor_run_interval"`
+	EnableExpiredSharesCleanup bool              `mapstructure:"enable_expired_shares_cleanup"`
+	Events                     stream.NatsConfig `mapstructure:"events"`
+	Expiry                     expiry.Config     `mapstructure:",squash"`
 }
 
 type fileConfig struct {
@@ -161,6 +197,9 @@ type manager struct {
 	passwordHashCost           int
 	janitorRunInterval         int
 	enableExpiredSharesCleanup bool
+	eventStream                events.Stream
+
+	sweeper *expiry.Sweeper
 }
 
 func (m *manager) init() error {
@@ -590,12 +629,71 @@ func (m *manager) cleanupExpiredShares() {
 	}
 }
 
+// SweepExpiredShares removes the public shares that expired and publishes reminders for the public shares
+// that are about to expire
+func (m *manager) SweepExpiredShares(ctx context.Context, w expiry.Window) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.init(); err != nil {
+		return err
+	}
+
+	db, err := m.persistence.Read(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, v := range db {
+		d := v.(map[string]interface{})["share"]
+
+		var ps link.PublicShare
+		if err := utils.UnmarshalJSONToProtoV1([]byte(d.(string)), &ps); err != nil || ps.Expiration == nil {
+			continue
+		}
+
+		expiration := utils.TSToTime(ps.Expiration)
+		switch {
+		case w.Expired(expiration):
+			if err := m.expirePublicShare(ctx, &ps); err != nil {
+				failed++
+			}
+		case m.eventStream != nil && w.Remind(ps.Id.GetOpaqueId(), expiration):
+			if err := m.publish(ctx, events.LinkExpiringSoon{
+				ShareID:    ps.Id,
+				ShareToken: ps.Token,
+				Sharer:     ps.Creator,
+				Owner:      ps.Owner,
+				ItemID:     ps.ResourceId,
+				ExpiresAt:  expiration,
+			}); err != nil {
+				failed++
+				continue
+			}
+			if err := w.Reminded(ps.Id.GetOpaqueId(), expiration); err != nil {
+				log.Err(err).Msg("publicShareJSONManager: error recording the reminder")
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d expiring public shares could not be handled", failed)
+	}
+	return nil
+}
+
 // revokeExpiredPublicShare doesn't have a lock inside, ensure a lock before call
 func (m *manager) revokeExpiredPublicShare(ctx context.Context, s *link.PublicShare) error {
 	if !m.enableExpiredSharesCleanup {
 		return nil
 	}
 
+	return m.expirePublicShare(ctx, s)
+}
+
+// expirePublicShare doesn't have a lock inside, ensure a lock before call
+func (m *manager) expirePublicShare(ctx context.Context, s *link.PublicShare) error {
 	err := m.revokePublicShare(ctx, &link.PublicShareReference{
 		Spec: &link.PublicShareReference_Id{
 			Id: &link.PublicShareId{
@@ -608,6 +706,25 @@ func (m *manager) revokeExpiredPublicShare(ctx context.Context, s *link.PublicSh
 		return err
 	}
 
+	m.publish(ctx, events.LinkExpired{
+		ShareID:    s.Id,
+		ShareToken: s.Token,
+		Sharer:     s.Creator,
+		Owner:      s.Owner,
+		ItemID:     s.ResourceId,
+		ExpiredAt:  utils.TSToTime(s.Expiration),
+	})
+	return nil
+}
+
+func (m *manager) publish(ctx context.Context, ev interface{}) error {
+	if m.eventStream == nil {
+		return nil
+	}
+	if err := events.Publish(ctx, m.eventStream, ev); err != nil {
+		log.Err(err).Msg("publicShareJSONManager: error publishing event")
+		return err
+	}
 	return nil
 }
 
@@ -32,7 +32,9 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json/persistence/cs3"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
@@ -262,5 +264,34 @@ var _ = Describe("Json", func() {
 				Expect(len(ps)).To(Equal(1)) // Make sure the first created public share is gone
 			})
 		})
+
+		Describe("SweepExpiredShares", func() {
+			It("removes expired public shares", func() {
+				now := time.Now()
+				expiringGrant := proto.Clone(grant).(*link.Grant)
+				expiringGrant.Expiration = utils.TimeToTS(now.Add(time.Hour))
+				expiring, err := m.CreatePublicShare(ctx, user1, sharedResource, expiringGrant)
+				Expect(err).ToNot(HaveOccurred())
+
+				laterGrant := proto.Clone(grant).(*link.Grant)
+				laterGrant.Expiration = utils.TimeToTS(now.Add(24 * time.Hour))
+				later, err := m.CreatePublicShare(ctx, user1, sharedResource, laterGrant)
+				Expect(err).ToNot(HaveOccurred())
+
+				sweeper, ok := m.(interface {
+					SweepExpiredShares(context.Context, expiry.Window) error
+				})
+				Expect(ok).To(BeTrue())
+				s, err := expiry.New("test", expiry.Config{Interval: 60, Store: "memory"}, sweeper.SweepExpiredShares)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s.Sweep(ctx, now.Add(2*time.Hour))).To(Succeed())
+
+				_, err = m.GetPublicShareByToken(ctx, expiring.Token, nil, false)
+				Expect(err).To(HaveOccurred())
+				ps, err := m.GetPublicShareByToken(ctx, later.Token, nil, false)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ps.Id.OpaqueId).To(Equal(later.Id.OpaqueId))
+			})
+		})
 	})
 })
@@ -23,5 +23,6 @@ import (
 	_ "github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/json"
 	_ "github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/memory"
 	_ "github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/owncloudsql"
+	_ "github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/sql"
 	// Add your own here
 )
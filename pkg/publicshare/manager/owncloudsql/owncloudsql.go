@@ -35,9 +35,13 @@ import (
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/pkg/errors"
@@ -58,14 +62,16 @@ func init() {
 
 // Config configures an owncloudsql publicshare manager
 type Config struct {
-	GatewayAddr                string `mapstructure:"gateway_addr"`
-	DbUsername                 string `mapstructure:"db_username"`
-	DbPassword                 string `mapstructure:"db_password"`
-	DbHost                     string `mapstructure:"db_host"`
-	DbPort                     int    `mapstructure:"db_port"`
-	DbName                     string `mapstructure:"db_name"`
-	EnableExpiredSharesCleanup bool   `mapstructure:"enable_expired_shares_cleanup"`
-	SharePasswordHashCost      int    `mapstructure:"password_hash_cost"`
+	GatewayAddr                string            `mapstructure:"gateway_addr"`
+	DbUsername                 string            `mapstructure:"db_username"`
+	DbPassword                 string            `mapstructure:"db_password"`
+	DbHost                     string            `mapstructure:"db_host"`
+	DbPort                     int               `mapstructure:"db_port"`
+	DbName                     string            `mapstructure:"db_name"`
+	EnableExpiredSharesCleanup bool              `mapstructure:"enable_expired_shares_cleanup"`
+	SharePasswordHashCost      int               `mapstructure:"password_hash_cost"`
+	Events                     stream.NatsConfig `mapstructure:"events"`
+	Expiry                     expiry.Config     `mapstructure:",squash"`
 }
 
 type mgr struct {
@@ -73,6 +79,9 @@ type mgr struct {
 	db            *sql.DB
 	c             Config
 	userConverter UserConverter
+	eventStream   events.Stream
+
+	sweeper *expiry.Sweeper
 }
 
 // NewMysql returns a new publicshare manager connection to a mysql database
@@ -90,7 +99,32 @@ func NewMysql(m map[string]interface{}) (publicshare.Manager, error) {
 
 	userConverter := NewGatewayUserConverter(sharedconf.GetGatewaySVC(c.GatewayAddr))
 
-	return New("mysql", db, *c, userConverter)
+	pm, err := New("mysql", db, *c, userConverter)
+	if err != nil {
+		return nil, err
+	}
+	sm := pm.(*mgr)
+	if c.Events.Endpoint != "" {
+		sm.eventStream, err = stream.NatsFromConfig("owncloudsql-publicshare-manager", false, c.Events)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sweeper, err := expiry.New("owncloudsql-publicshares", c.Expiry, sm.SweepExpiredShares)
+	if err != nil {
+		return nil, err
+	}
+	sweeper.Start()
+	sm.sweeper = sweeper
+	return sm, nil
+}
+
+// Close stops the expiry sweeper
+func (m *mgr) Close() error {
+	if m.sweeper != nil {
+		m.sweeper.Stop()
+	}
+	return nil
 }
 
 // New returns a new Cache instance connecting to the given sql.DB
@@ -531,3 +565,98 @@ func (m *mgr) cleanupExpiredShares() error {
 	}
 	return nil
 }
+
+// SweepExpiredShares removes the public shares that expired and publishes reminders for the public shares
+// that are about to expire
+func (m *mgr) SweepExpiredShares(ctx context.Context, w expiry.Window) error {
+	log := appctx.GetLogger(ctx)
+
+	query := `SELECT
+				coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator,
+				coalesce(share_with, '') as share_with, coalesce(file_source, '') as file_source,
+				coalesce(item_type, '') as item_type, coalesce(token,'') as token,
+				coalesce(expiration, '') as expiration, coalesce(share_name, '') as share_name,
+				s.id, s.stime, s.permissions, coalesce(fc.storage, '') as storage
+			FROM oc_share s
+			LEFT JOIN oc_filecache fc ON fc.fileid = file_source
+			WHERE share_type=? AND expiration IS NOT NULL`
+	rows, err := m.db.QueryContext(ctx, query, publicShareType)
+	if err != nil {
+		return err
+	}
+	var failed int
+	var expired, expiring []*link.PublicShare
+	for rows.Next() {
+		var s DBShare
+		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.FileSource, &s.ItemType, &s.Token, &s.Expiration, &s.ShareName, &s.ID, &s.STime, &s.Permissions, &s.ItemStorage); err != nil {
+			log.Error().Err(err).Msg("error scanning public share")
+			failed++
+			continue
+		}
+		ps, err := m.ConvertToCS3PublicShare(ctx, s)
+		if err != nil {
+			log.Error().Err(err).Str("share", s.ID).Msg("error converting public share")
+			failed++
+			continue
+		}
+		if ps.Expiration == nil {
+			continue
+		}
+		expiration := utils.TSToTime(ps.Expiration)
+		switch {
+		case w.Expired(expiration):
+			expired = append(expired, ps)
+		case m.eventStream != nil && w.Remind(ps.GetId().GetOpaqueId(), expiration):
+			expiring = append(expiring, ps)
+		}
+	}
+	rows.Close()
+
+	for _, ps := range expired {
+		if _, err := m.db.ExecContext(ctx, "DELETE FROM oc_share WHERE id=?", ps.Id.OpaqueId); err != nil {
+			log.Error().Err(err).Str("share", ps.Id.OpaqueId).Msg("failed to remove expired public share")
+			failed++
+			continue
+		}
+		_ = m.publish(ctx, events.LinkExpired{
+			ShareID:    ps.Id,
+			ShareToken: ps.Token,
+			Sharer:     ps.Creator,
+			Owner:      ps.Owner,
+			ItemID:     ps.ResourceId,
+			ExpiredAt:  utils.TSToTime(ps.Expiration),
+		})
+	}
+	for _, ps := range expiring {
+		if err := m.publish(ctx, events.LinkExpiringSoon{
+			ShareID:    ps.Id,
+			ShareToken: ps.Token,
+			Sharer:     ps.Creator,
+			Owner:      ps.Owner,
+			ItemID:     ps.ResourceId,
+			ExpiresAt:  utils.TSToTime(ps.Expiration),
+		}); err != nil {
+			failed++
+			continue
+		}
+		if err := w.Reminded(ps.Id.OpaqueId, utils.TSToTime(ps.Expiration)); err != nil {
+			log.Error().Err(err).Str("share", ps.Id.OpaqueId).Msg("failed to record the reminder")
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d expiring public shares could not be handled", failed)
+	}
+	return nil
+}
+
+func (m *mgr) publish(ctx context.Context, ev interface{}) error {
+	if m.eventStream == nil {
+		return nil
+	}
+	if err := events.Publish(ctx, m.eventStream, ev); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("failed to publish event")
+		return err
+	}
+	return nil
+}
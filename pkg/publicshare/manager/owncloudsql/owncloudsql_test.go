@@ -33,9 +33,11 @@ import (
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
 	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/owncloudsql"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/owncloudsql/mocks"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -481,4 +483,32 @@ var _ = Describe("SQL manager", func() {
 			})
 		})
 	})
+
+	Describe("SweepExpiredShares", func() {
+		It("removes expired public shares", func() {
+			now := time.Now()
+			expiringGrant := proto.Clone(grant).(*link.Grant)
+			expiringGrant.Expiration = utils.TimeToTS(now.Add(time.Hour))
+			expiring, err := m.CreatePublicShare(ctx, user, ri, expiringGrant)
+			Expect(err).ToNot(HaveOccurred())
+
+			laterGrant := proto.Clone(grant).(*link.Grant)
+			laterGrant.Expiration = utils.TimeToTS(now.Add(24 * time.Hour))
+			later, err := m.CreatePublicShare(ctx, user, ri, laterGrant)
+			Expect(err).ToNot(HaveOccurred())
+
+			sweeper, ok := m.(interface {
+				SweepExpiredShares(context.Context, expiry.Window) error
+			})
+			Expect(ok).To(BeTrue())
+			s, err := expiry.New("test", expiry.Config{Interval: 60, Store: "memory"}, sweeper.SweepExpiredShares)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Sweep(ctx, now.Add(2*time.Hour))).To(Succeed())
+
+			_, err = owncloudsql.GetByToken(sqldb, expiring.Token)
+			Expect(err).To(HaveOccurred())
+			_, err = owncloudsql.GetByToken(sqldb, later.Token)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
 })
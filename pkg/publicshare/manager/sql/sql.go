@@ -0,0 +1,602 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/proto"
+)
+
+// This module implements the publicshare.Manager interface on top of a sqlite or postgres database.
+//
+// Public shares are saved in the table
+//     public_shares(*id*, token, storage_id, space_id, opaque_id, owner, creator, expiration, password, data)
+// data holds the json encoded share, the other columns are extracted from it so they can be indexed.
+// password holds the bcrypt hash of the password, users are referenced by their opaque id and
+// expiration is a unix timestamp.
+
+func init() {
+	registry.Register("sql", NewDefault)
+}
+
+var migrations = []sqldb.Migration{
+	{
+		`CREATE TABLE public_shares (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			token VARCHAR(255) NOT NULL,
+			storage_id VARCHAR(255) NOT NULL,
+			space_id VARCHAR(255) NOT NULL,
+			opaque_id VARCHAR(255) NOT NULL,
+			owner VARCHAR(255) NOT NULL,
+			creator VARCHAR(255) NOT NULL,
+			expiration BIGINT,
+			password TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		"CREATE UNIQUE INDEX public_shares_token ON public_shares (token)",
+		"CREATE INDEX public_shares_resource ON public_shares (storage_id, space_id, opaque_id)",
+		"CREATE INDEX public_shares_owner ON public_shares (owner)",
+		"CREATE INDEX public_shares_creator ON public_shares (creator)",
+		"CREATE INDEX public_shares_expiration ON public_shares (expiration)",
+	},
+}
+
+type config struct {
+	DB                         sqldb.Config      `mapstructure:",squash"`
+	GatewayAddr                string            `mapstructure:"gateway_addr"`
+	SharePasswordHashCost      int               `mapstructure:"password_hash_cost"`
+	EnableExpiredSharesCleanup bool              `mapstructure:"enable_expired_shares_cleanup"`
+	Events                     stream.NatsConfig `mapstructure:"events"`
+	Expiry                     expiry.Config     `mapstructure:",squash"`
+}
+
+func (c *config) init() {
+	if c.SharePasswordHashCost == 0 {
+		c.SharePasswordHashCost = 11
+	}
+}
+
+// Manager implements a public share manager using a sql database
+type Manager struct {
+	db              *sql.DB
+	gatewaySelector pool.Selectable[gatewayv1beta1.GatewayAPIClient]
+	eventStream     events.Stream
+
+	passwordHashCost           int
+	enableExpiredSharesCleanup bool
+
+	sweeper *expiry.Sweeper
+}
+
+// NewDefault returns a new public share manager with default dependencies
+func NewDefault(m map[string]interface{}) (publicshare.Manager, error) {
+	c := &config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, err
+	}
+	c.init()
+
+	db, err := sqldb.Open(c.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	gatewaySelector, err := pool.GatewaySelector(c.GatewayAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var es events.Stream
+	if c.Events.Endpoint != "" {
+		es, err = stream.NatsFromConfig("sql-publicshare-manager", false, c.Events)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mgr, err := New(db, gatewaySelector, c.SharePasswordHashCost, c.EnableExpiredSharesCleanup, es)
+	if err != nil {
+		return nil, err
+	}
+	sweeper, err := expiry.New("sql-publicshares", c.Expiry, mgr.SweepExpiredShares)
+	if err != nil {
+		return nil, err
+	}
+	sweeper.Start()
+	mgr.sweeper = sweeper
+	return mgr, nil
+}
+
+// Close stops the expiry sweeper
+func (m *Manager) Close() error {
+	if m.sweeper != nil {
+		m.sweeper.Stop()
+	}
+	return nil
+}
+
+// New returns a new public share manager using the given database. The schema is migrated on startup.
+func New(db *sql.DB, gatewaySelector pool.Selectable[gatewayv1beta1.GatewayAPIClient], pwHashCost int, enableCleanup bool, es events.Stream) (*Manager, error) {
+	if err := sqldb.Migrate(context.Background(), db, "public_shares", migrations); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		db:                         db,
+		gatewaySelector:            gatewaySelector,
+		eventStream:                es,
+		passwordHashCost:           pwHashCost,
+		enableExpiredSharesCleanup: enableCleanup,
+	}, nil
+}
+
+const shareColumns = "id, token, storage_id, space_id, opaque_id, owner, creator, expiration, password, data"
+
+func shareValues(s *link.PublicShare, password string) ([]interface{}, error) {
+	data, err := utils.MarshalProtoV1ToJSON(s)
+	if err != nil {
+		return nil, err
+	}
+	var expiration sql.NullInt64
+	if e := s.GetExpiration(); e != nil {
+		expiration = sql.NullInt64{Int64: int64(e.GetSeconds()), Valid: true}
+	}
+	rid := s.GetResourceId()
+	return []interface{}{
+		s.GetId().GetOpaqueId(), s.GetToken(), rid.GetStorageId(), rid.GetSpaceId(), rid.GetOpaqueId(),
+		s.GetOwner().GetOpaqueId(), s.GetCreator().GetOpaqueId(), expiration, password, string(data),
+	}, nil
+}
+
+type shareWithPassword struct {
+	share    *link.PublicShare
+	password string
+}
+
+// query returns the shares selected by the where clause. The rows are read completely before
+// returning, so the connection can be used for further queries.
+func (m *Manager) query(ctx context.Context, where string, args ...interface{}) ([]shareWithPassword, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT data, password FROM public_shares WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []shareWithPassword
+	for rows.Next() {
+		var data, password string
+		if err := rows.Scan(&data, &password); err != nil {
+			return nil, err
+		}
+		s := &link.PublicShare{}
+		if err := utils.UnmarshalJSONToProtoV1([]byte(data), s); err != nil {
+			return nil, err
+		}
+		shares = append(shares, shareWithPassword{share: s, password: password})
+	}
+	return shares, rows.Err()
+}
+
+func (m *Manager) get(ctx context.Context, ref *link.PublicShareReference) (*shareWithPassword, error) {
+	var (
+		shares []shareWithPassword
+		err    error
+	)
+	switch {
+	case ref.GetId().GetOpaqueId() != "":
+		shares, err = m.query(ctx, "id = $1", ref.GetId().GetOpaqueId())
+	case ref.GetToken() != "":
+		shares, err = m.query(ctx, "token = $1", ref.GetToken())
+	default:
+		return nil, errtypes.NotFound("reference does not exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, errtypes.NotFound(ref.String())
+	}
+	return &shares[0], nil
+}
+
+// CreatePublicShare creates a new public share
+func (m *Manager) CreatePublicShare(ctx context.Context, u *user.User, rInfo *provider.ResourceInfo, g *link.Grant) (*link.PublicShare, error) {
+	tkn := utils.RandString(15)
+	now := utils.TSNow()
+
+	displayName, ok := rInfo.GetArbitraryMetadata().GetMetadata()["name"]
+	if !ok {
+		displayName = tkn
+	}
+	quicklink, _ := strconv.ParseBool(rInfo.GetArbitraryMetadata().GetMetadata()["quicklink"])
+
+	var password string
+	if g.GetPassword() != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(g.GetPassword()), m.passwordHashCost)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not hash share password")
+		}
+		password = string(h)
+	}
+
+	s := &link.PublicShare{
+		Id:                &link.PublicShareId{OpaqueId: utils.RandString(15)},
+		Owner:             rInfo.GetOwner(),
+		Creator:           u.GetId(),
+		ResourceId:        rInfo.GetId(),
+		Token:             tkn,
+		Permissions:       g.GetPermissions(),
+		Ctime:             now,
+		Mtime:             now,
+		PasswordProtected: password != "",
+		Expiration:        g.GetExpiration(),
+		DisplayName:       displayName,
+		Quicklink:         quicklink,
+	}
+
+	values, err := shareValues(s, password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.db.ExecContext(ctx, "INSERT INTO public_shares ("+shareColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", values...); err != nil {
+		if sqldb.IsUniqueViolation(err) {
+			return nil, errtypes.AlreadyExists("public share already exists")
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// UpdatePublicShare updates the public share
+func (m *Manager) UpdatePublicShare(ctx context.Context, u *user.User, req *link.UpdatePublicShareRequest) (*link.PublicShare, error) {
+	ps, err := m.get(ctx, req.GetRef())
+	if err != nil {
+		return nil, err
+	}
+	s, password := ps.share, ps.password
+
+	hash := func(pw string) (string, error) {
+		h, err := bcrypt.GenerateFromPassword([]byte(pw), m.passwordHashCost)
+		if err != nil {
+			return "", errors.Wrap(err, "could not hash share password")
+		}
+		return string(h), nil
+	}
+
+	switch req.GetUpdate().GetType() {
+	case link.UpdatePublicShareRequest_Update_TYPE_DISPLAYNAME:
+		s.DisplayName = req.GetUpdate().GetDisplayName()
+	case link.UpdatePublicShareRequest_Update_TYPE_PERMISSIONS:
+		if pw := req.GetUpdate().GetGrant().GetPassword(); pw != "" {
+			if password, err = hash(pw); err != nil {
+				return nil, err
+			}
+			s.PasswordProtected = true
+		}
+		s.Permissions = req.GetUpdate().GetGrant().GetPermissions()
+	case link.UpdatePublicShareRequest_Update_TYPE_EXPIRATION:
+		s.Expiration = req.GetUpdate().GetGrant().GetExpiration()
+	case link.UpdatePublicShareRequest_Update_TYPE_PASSWORD:
+		password = ""
+		if pw := req.GetUpdate().GetGrant().GetPassword(); pw != "" {
+			if password, err = hash(pw); err != nil {
+				return nil, err
+			}
+		}
+		s.PasswordProtected = password != ""
+	default:
+		return nil, fmt.Errorf("invalid update type: %v", req.GetUpdate().GetType())
+	}
+	s.Mtime = utils.TSNow()
+
+	values, err := shareValues(s, password)
+	if err != nil {
+		return nil, err
+	}
+	// expiration, password and data
+	if _, err := m.db.ExecContext(ctx, "UPDATE public_shares SET expiration = $1, password = $2, data = $3 WHERE id = $4", append(values[7:], s.GetId().GetOpaqueId())...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetPublicShare gets a public share either by ID or Token.
+func (m *Manager) GetPublicShare(ctx context.Context, u *user.User, ref *link.PublicShareReference, sign bool) (*link.PublicShare, error) {
+	ps, err := m.get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if publicshare.IsExpired(ps.share) {
+		if err := m.revokeExpiredPublicShare(ctx, ps.share); err != nil {
+			return nil, err
+		}
+		return nil, errtypes.NotFound(ref.String())
+	}
+	if ps.share.GetPasswordProtected() && sign {
+		if err := publicshare.AddSignature(ps.share, ps.password); err != nil {
+			return nil, err
+		}
+	}
+	return ps.share, nil
+}
+
+// ListPublicShares returns the public shares created or owned by the user. If resource id filters are
+// given the public shares of these resources are returned if the user is allowed to list their grants.
+func (m *Manager) ListPublicShares(ctx context.Context, u *user.User, filters []*link.ListPublicSharesRequest_Filter, sign bool) ([]*link.PublicShare, error) {
+	log := appctx.GetLogger(ctx)
+
+	var (
+		args       sqldb.Args
+		conditions []string
+	)
+	grouped := publicshare.GroupFiltersByType(filters)
+	resourceFilters := grouped[link.ListPublicSharesRequest_Filter_TYPE_RESOURCE_ID]
+	if len(resourceFilters) > 0 {
+		or := make([]string, 0, len(resourceFilters))
+		for _, f := range resourceFilters {
+			rid := f.GetResourceId()
+			or = append(or, "(storage_id = "+args.Add(rid.GetStorageId())+" AND space_id = "+args.Add(rid.GetSpaceId())+" AND opaque_id = "+args.Add(rid.GetOpaqueId())+")")
+		}
+		conditions = append(conditions, "("+strings.Join(or, " OR ")+")")
+	} else {
+		userID := args.Add(u.GetId().GetOpaqueId())
+		conditions = append(conditions, "(owner = "+userID+" OR creator = "+userID+")")
+	}
+	if fs := grouped[publicshare.StorageIDFilterType]; len(fs) > 0 {
+		ids := make([]string, 0, len(fs))
+		for _, f := range fs {
+			ids = append(ids, f.GetResourceId().GetStorageId())
+		}
+		conditions = append(conditions, "storage_id IN ("+args.In(ids...)+")")
+	}
+
+	found, err := m.query(ctx, strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	shares := []*link.PublicShare{}
+	for _, ps := range found {
+		s := ps.share
+		if publicshare.IsExpired(s) {
+			if err := m.revokeExpiredPublicShare(ctx, s); err != nil {
+				log.Error().Err(err).Str("share_token", s.GetToken()).Msg("failed to revoke expired public share")
+			}
+			continue
+		}
+		if !publicshare.MatchesFilters(s, filters) {
+			continue
+		}
+		if !publicshare.IsCreatedByUser(s, u) {
+			key := storagespace.FormatResourceID(s.GetResourceId())
+			if _, ok := allowed[key]; !ok {
+				allowed[key] = m.canListGrants(ctx, s.GetResourceId())
+			}
+			if !allowed[key] {
+				continue
+			}
+		}
+		if s.GetPasswordProtected() && sign {
+			if err := publicshare.AddSignature(s, ps.password); err != nil {
+				return nil, err
+			}
+		}
+		shares = append(shares, s)
+	}
+	return shares, nil
+}
+
+func (m *Manager) canListGrants(ctx context.Context, id *provider.ResourceId) bool {
+	log := appctx.GetLogger(ctx)
+	client, err := m.gatewaySelector.Next()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select next gateway client")
+		return false
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{Ref: &provider.Reference{ResourceId: id}})
+	if err != nil {
+		log.Error().Err(err).Interface("resource_id", id).Msg("ListShares: an error occurred during stat on the resource")
+		return false
+	}
+	if res.GetStatus().GetCode() != rpc.Code_CODE_OK {
+		log.Debug().Interface("status", res.GetStatus()).Interface("resource_id", id).Msg("ListShares: could not stat resource")
+		return false
+	}
+	return res.GetInfo().GetPermissionSet().GetListGrants()
+}
+
+// RevokePublicShare removes a public share
+func (m *Manager) RevokePublicShare(ctx context.Context, _ *user.User, ref *link.PublicShareReference) error {
+	ps, err := m.get(ctx, ref)
+	if err != nil {
+		return err
+	}
+	_, err = m.db.ExecContext(ctx, "DELETE FROM public_shares WHERE id = $1", ps.share.GetId().GetOpaqueId())
+	return err
+}
+
+// GetPublicShareByToken gets a public share by its opaque token.
+func (m *Manager) GetPublicShareByToken(ctx context.Context, token string, auth *link.PublicShareAuthentication, sign bool) (*link.PublicShare, error) {
+	shares, err := m.query(ctx, "token = $1", token)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, errtypes.NotFound(fmt.Sprintf("share with token: `%v` not found", token))
+	}
+	s, password := shares[0].share, shares[0].password
+
+	if publicshare.IsExpired(s) {
+		if err := m.revokeExpiredPublicShare(ctx, s); err != nil {
+			return nil, err
+		}
+		return nil, errtypes.NotFound(fmt.Sprintf("share with token: `%v` not found", token))
+	}
+	if s.GetPasswordProtected() {
+		if !publicshare.Authenticate(s, password, auth) {
+			return nil, errtypes.InvalidCredentials("sql: invalid password")
+		}
+		if sign {
+			if err := publicshare.AddSignature(s, password); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Dump exports public shares to channels (e.g. during migration)
+func (m *Manager) Dump(ctx context.Context, shareChan chan<- *publicshare.WithPassword) error {
+	shares, err := m.query(ctx, "1 = 1")
+	if err != nil {
+		return err
+	}
+	for _, ps := range shares {
+		local := &publicshare.WithPassword{Password: ps.password}
+		proto.Merge(&local.PublicShare, ps.share)
+		shareChan <- local
+	}
+	return nil
+}
+
+// Load imports public shares from channels (e.g. during migration). Existing shares are
+// overwritten, so loading the same dump twice is safe.
+func (m *Manager) Load(ctx context.Context, shareChan <-chan *publicshare.WithPassword) error {
+	for ps := range shareChan {
+		values, err := shareValues(&ps.PublicShare, ps.Password)
+		if err != nil {
+			return err
+		}
+		_, err = m.db.ExecContext(ctx, "INSERT INTO public_shares ("+shareColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"+
+			" ON CONFLICT (id) DO UPDATE SET token=excluded.token, storage_id=excluded.storage_id, space_id=excluded.space_id,"+
+			" opaque_id=excluded.opaque_id, owner=excluded.owner, creator=excluded.creator, expiration=excluded.expiration,"+
+			" password=excluded.password, data=excluded.data", values...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SweepExpiredShares removes the public shares that expired and publishes reminders for the public shares
+// that are about to expire
+func (m *Manager) SweepExpiredShares(ctx context.Context, w expiry.Window) error {
+	shares, err := m.query(ctx, "expiration IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, ps := range shares {
+		s := ps.share
+		expiration := utils.TSToTime(s.GetExpiration())
+		switch {
+		case w.Expired(expiration):
+			if err := m.expirePublicShare(ctx, s); err != nil {
+				failed++
+			}
+		case m.eventStream != nil && w.Remind(s.GetId().GetOpaqueId(), expiration):
+			if err := m.remind(ctx, w, s, expiration); err != nil {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d expiring public shares could not be handled", failed)
+	}
+	return nil
+}
+
+func (m *Manager) remind(ctx context.Context, w expiry.Window, s *link.PublicShare, expiration time.Time) error {
+	if err := m.publish(ctx, events.LinkExpiringSoon{
+		ShareID:    s.GetId(),
+		ShareToken: s.GetToken(),
+		Sharer:     s.GetCreator(),
+		Owner:      s.GetOwner(),
+		ItemID:     s.GetResourceId(),
+		ExpiresAt:  expiration,
+	}); err != nil {
+		return err
+	}
+	if err := w.Reminded(s.GetId().GetOpaqueId(), expiration); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("error recording the reminder")
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) revokeExpiredPublicShare(ctx context.Context, s *link.PublicShare) error {
+	if !m.enableExpiredSharesCleanup {
+		return nil
+	}
+	return m.expirePublicShare(ctx, s)
+}
+
+func (m *Manager) expirePublicShare(ctx context.Context, s *link.PublicShare) error {
+	if _, err := m.db.ExecContext(ctx, "DELETE FROM public_shares WHERE id = $1", s.GetId().GetOpaqueId()); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("error deleting expired public share")
+		return err
+	}
+
+	m.publish(ctx, events.LinkExpired{
+		ShareID:    s.GetId(),
+		ShareToken: s.GetToken(),
+		Sharer:     s.GetCreator(),
+		Owner:      s.GetOwner(),
+		ItemID:     s.GetResourceId(),
+		ExpiredAt:  utils.TSToTime(s.GetExpiration()),
+	})
+	return nil
+}
+
+func (m *Manager) publish(ctx context.Context, ev interface{}) error {
+	if m.eventStream == nil {
+		return nil
+	}
+	if err := events.Publish(ctx, m.eventStream, ev); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("error publishing event")
+		return err
+	}
+	return nil
+}
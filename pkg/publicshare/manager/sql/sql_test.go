@@ -0,0 +1,240 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql_test
+
+import (
+	"context"
+	"errors"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/sql"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb/sqldbtest"
+	"github.com/opencloud-eu/reva/v2/tests/cs3mocks/mocks"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sql", func() {
+	for _, driver := range sqldbtest.Drivers {
+		Context(driver, func() { describeManager(driver) })
+	}
+})
+
+func describeManager(driver string) {
+	var (
+		owner = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "admin",
+			},
+		}
+		other = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "einstein",
+			},
+		}
+		resource = &provider.ResourceInfo{
+			Id: &provider.ResourceId{
+				StorageId: "storageid",
+				SpaceId:   "spaceid",
+				OpaqueId:  "opaqueid",
+			},
+			Owner: owner.Id,
+			ArbitraryMetadata: &provider.ArbitraryMetadata{
+				Metadata: map[string]string{"name": "link"},
+			},
+		}
+		grant = &link.Grant{
+			Permissions: &link.PublicSharePermissions{
+				Permissions: &provider.ResourcePermissions{Stat: true},
+			},
+		}
+
+		ctx    = context.Background()
+		client *mocks.GatewayAPIClient
+		m      *sql.Manager
+
+		newManager = func() *sql.Manager {
+			c, cleanup, err := sqldbtest.Config(driver, GinkgoT().TempDir())
+			if errors.Is(err, sqldbtest.ErrNotConfigured) {
+				Skip(err.Error())
+			}
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			DeferCleanup(cleanup)
+			db, err := sqldb.Open(c)
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			DeferCleanup(db.Close)
+			pool.RemoveSelector("GatewaySelector" + "eu.opencloud.api.gateway")
+			gatewaySelector := pool.GetSelector[gatewayv1beta1.GatewayAPIClient](
+				"GatewaySelector",
+				"eu.opencloud.api.gateway",
+				func(cc grpc.ClientConnInterface) gatewayv1beta1.GatewayAPIClient {
+					return client
+				},
+			)
+			mgr, err := sql.New(db, gatewaySelector, bcrypt.MinCost, true, nil)
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			return mgr
+		}
+		byToken = func(s *link.PublicShare) *link.PublicShareReference {
+			return &link.PublicShareReference{Spec: &link.PublicShareReference_Token{Token: s.Token}}
+		}
+		password = func(pw string) *link.PublicShareAuthentication {
+			return &link.PublicShareAuthentication{Spec: &link.PublicShareAuthentication_Password{Password: pw}}
+		}
+	)
+
+	BeforeEach(func() {
+		client = &mocks.GatewayAPIClient{}
+		m = newManager()
+	})
+
+	It("creates public shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, grant)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.DisplayName).To(Equal("link"))
+		Expect(s.PasswordProtected).To(BeFalse())
+
+		got, err := m.GetPublicShare(ctx, owner, &link.PublicShareReference{Spec: &link.PublicShareReference_Id{Id: s.Id}}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got.Token).To(Equal(s.Token))
+
+		got, err = m.GetPublicShareByToken(ctx, s.Token, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got.Id.OpaqueId).To(Equal(s.Id.OpaqueId))
+	})
+
+	It("authenticates password protected shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, &link.Grant{Permissions: grant.Permissions, Password: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.PasswordProtected).To(BeTrue())
+
+		_, err = m.GetPublicShareByToken(ctx, s.Token, password("wrong"), false)
+		Expect(err).To(BeAssignableToTypeOf(errtypes.InvalidCredentials("")))
+
+		got, err := m.GetPublicShareByToken(ctx, s.Token, password("secret"), true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got.Signature).ToNot(BeNil())
+
+		// removing the password makes the share public
+		_, err = m.UpdatePublicShare(ctx, owner, &link.UpdatePublicShareRequest{
+			Ref:    byToken(s),
+			Update: &link.UpdatePublicShareRequest_Update{Type: link.UpdatePublicShareRequest_Update_TYPE_PASSWORD, Grant: &link.Grant{}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		got, err = m.GetPublicShareByToken(ctx, s.Token, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got.PasswordProtected).To(BeFalse())
+	})
+
+	It("updates public shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, grant)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = m.UpdatePublicShare(ctx, owner, &link.UpdatePublicShareRequest{
+			Ref:    byToken(s),
+			Update: &link.UpdatePublicShareRequest_Update{Type: link.UpdatePublicShareRequest_Update_TYPE_DISPLAYNAME, DisplayName: "renamed"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		got, err := m.GetPublicShare(ctx, owner, byToken(s), false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got.DisplayName).To(Equal("renamed"))
+	})
+
+	It("lists public shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, grant)
+		Expect(err).ToNot(HaveOccurred())
+
+		shares, err := m.ListPublicShares(ctx, owner, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shares).To(HaveLen(1))
+
+		shares, err = m.ListPublicShares(ctx, other, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shares).To(BeEmpty())
+
+		client.On("Stat", mock.Anything, mock.Anything).Return(&provider.StatResponse{
+			Status: status.NewOK(ctx),
+			Info:   &provider.ResourceInfo{PermissionSet: &provider.ResourcePermissions{ListGrants: true}},
+		}, nil)
+		shares, err = m.ListPublicShares(ctx, other, []*link.ListPublicSharesRequest_Filter{publicshare.ResourceIDFilter(resource.Id)}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shares).To(HaveLen(1))
+		Expect(shares[0].Id.OpaqueId).To(Equal(s.Id.OpaqueId))
+
+		shares, err = m.ListPublicShares(ctx, owner, []*link.ListPublicSharesRequest_Filter{publicshare.StorageIDFilter("otherstorage")}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shares).To(BeEmpty())
+	})
+
+	It("revokes public shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, grant)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.RevokePublicShare(ctx, owner, byToken(s))).To(Succeed())
+		_, err = m.GetPublicShareByToken(ctx, s.Token, nil, false)
+		Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+	})
+
+	It("removes expired public shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, &link.Grant{Permissions: grant.Permissions, Expiration: &typespb.Timestamp{Seconds: 1}})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = m.GetPublicShareByToken(ctx, s.Token, nil, false)
+		Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+
+		shares, err := m.ListPublicShares(ctx, owner, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shares).To(BeEmpty())
+	})
+
+	It("dumps and loads public shares", func() {
+		s, err := m.CreatePublicShare(ctx, owner, resource, &link.Grant{Permissions: grant.Permissions, Password: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+
+		shareChan := make(chan *publicshare.WithPassword)
+		target := newManager()
+		done := make(chan error)
+		go func() {
+			done <- target.Load(ctx, shareChan)
+		}()
+		Expect(m.Dump(ctx, shareChan)).To(Succeed())
+		close(shareChan)
+		Expect(<-done).To(Succeed())
+
+		got, err := target.GetPublicShareByToken(ctx, s.Token, password("secret"), false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got.Id.OpaqueId).To(Equal(s.Id.OpaqueId))
+	})
+}
@@ -0,0 +1,259 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package usage keeps download and access limits and statistics for public links.
+// The CS3 public share does not have room for them, so they are kept in a store
+// next to the share, keyed by the share id. The public share provider and the
+// public storage provider have to be configured with the same persistent store.
+package usage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/store"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+const (
+	// OpaqueKeyLimits is the opaque key used to pass the limits when creating or updating a public link
+	OpaqueKeyLimits = "usage-limits"
+	// OpaqueKey is the opaque key used to return the usage of a public link
+	OpaqueKey = "usage"
+	// OpaqueKeyRecordAccess is the opaque key of a GetPublicShareByToken request that authenticates a client.
+	// The first of these requests of a link session counts as an access of the public link.
+	OpaqueKeyRecordAccess = "usage-record-access"
+)
+
+// Config holds the store configuration
+type Config struct {
+	Store          string   `mapstructure:"store" docs:";The store to keep the usage in. It has to be persistent and support atomic updates, one of redis, redis-sentinel or nats-js-kv."`
+	Volatile       bool     `mapstructure:"volatile" docs:"false;Allow the memory store, which loses the counters on restart. Only meant for tests."`
+	Nodes          []string `mapstructure:"nodes" docs:";The nodes of the store."`
+	Database       string   `mapstructure:"database" docs:"reva;The database of the store."`
+	Table          string   `mapstructure:"table" docs:"publicshare-usage;The table of the store."`
+	AuthUsername   string   `mapstructure:"auth_username" docs:";The username to authenticate with the store."`
+	AuthPassword   string   `mapstructure:"auth_password" docs:";The password to authenticate with the store."`
+	SessionTimeout int      `mapstructure:"session_timeout" docs:"1800;The number of seconds after which an idle link session ends. The next request of the client counts as a new access."`
+}
+
+func (c *Config) init() {
+	if c.Database == "" {
+		c.Database = "reva"
+	}
+	if c.Table == "" {
+		c.Table = "publicshare-usage"
+	}
+	if c.SessionTimeout == 0 {
+		c.SessionTimeout = 30 * 60
+	}
+}
+
+// Limits restrict how often a public link can be used. Zero means unlimited.
+type Limits struct {
+	MaxDownloads int `json:"max_downloads,omitempty"`
+	MaxAccesses  int `json:"max_accesses,omitempty"`
+}
+
+// Stats are the access statistics of a public link
+type Stats struct {
+	Downloads   int   `json:"downloads"`
+	Accesses    int   `json:"accesses"`
+	LastAccess  int64 `json:"last_access,omitempty"`
+	BytesServed int64 `json:"bytes_served"`
+}
+
+// Usage combines the limits and statistics of a public link
+type Usage struct {
+	Limits
+	Stats
+}
+
+// DownloadsExhausted returns true if no more downloads are allowed
+func (u Usage) DownloadsExhausted() bool {
+	return u.MaxDownloads > 0 && u.Downloads >= u.MaxDownloads
+}
+
+// AccessesExhausted returns true if no more accesses are allowed
+func (u Usage) AccessesExhausted() bool {
+	return u.MaxAccesses > 0 && u.Accesses >= u.MaxAccesses
+}
+
+// Exhausted returns true if the link has reached any of its limits
+func (u Usage) Exhausted() bool {
+	return u.DownloadsExhausted() || u.AccessesExhausted()
+}
+
+// record is what is kept in the store
+type record struct {
+	Usage
+	// Sessions holds the time the open link sessions were last seen
+	Sessions map[string]int64 `json:"sessions,omitempty"`
+}
+
+// SessionKey identifies the link session of a client. The parts are hashed, so the store does not
+// learn the client addresses.
+func SessionKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Manager reads and updates the usage of public links. Every update is an atomic compare-and-swap
+// on the store, so replicas and services sharing the store do not lose updates.
+type Manager struct {
+	store          cas.Store
+	sessionTimeout time.Duration
+}
+
+// New returns a new usage manager. It fails if the store does not keep the counters.
+func New(c *Config) (*Manager, error) {
+	c.init()
+	switch c.Store {
+	case store.TypeRedis, store.TypeRedisSentinel, store.TypeNatsJSKV:
+	case store.TypeMemory:
+		if !c.Volatile {
+			return nil, errors.New("usage: the memory store loses the counters on restart, configure a persistent store")
+		}
+	case "":
+		return nil, errors.New("usage: missing store, configure a persistent store")
+	case store.TypeEtcd, store.TypeNatsJS:
+		return nil, fmt.Errorf("usage: the %s store can not update the counters atomically, configure redis, redis-sentinel or nats-js-kv", c.Store)
+	default:
+		// ocmem evicts entries and noop does not keep them, both would reset the counters
+		return nil, fmt.Errorf("usage: the %s store does not keep the counters, configure a persistent store", c.Store)
+	}
+	s, err := cas.New(cas.Config{
+		Store:        c.Store,
+		Nodes:        c.Nodes,
+		Database:     c.Database,
+		Table:        c.Table,
+		AuthUsername: c.AuthUsername,
+		AuthPassword: c.AuthPassword,
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		store:          s,
+		sessionTimeout: time.Duration(c.SessionTimeout) * time.Second,
+	}, nil
+}
+
+// Get returns the usage of the given share
+func (m *Manager) Get(shareID string) (Usage, error) {
+	v, err := m.store.Get(shareID)
+	if err != nil {
+		return Usage{}, err
+	}
+	r, err := decode(v)
+	return r.Usage, err
+}
+
+// SetLimits sets the limits of the given share and keeps its statistics
+func (m *Manager) SetLimits(shareID string, l Limits) (Usage, error) {
+	if l.MaxDownloads < 0 || l.MaxAccesses < 0 {
+		return Usage{}, errtypes.BadRequest("limits must not be negative")
+	}
+	return m.update(shareID, func(r *record) error {
+		r.Limits = l
+		return nil
+	})
+}
+
+// RecordAccess counts an access to the given share unless the link session was already counted.
+// Sessions with an empty key always count. New sessions fail if the share has reached one of its
+// limits, existing ones once the download limit has been reached.
+func (m *Manager) RecordAccess(shareID, session string) (Usage, error) {
+	return m.update(shareID, func(r *record) error {
+		now := time.Now()
+		for k, seen := range r.Sessions {
+			if now.Sub(time.Unix(seen, 0)) > m.sessionTimeout {
+				delete(r.Sessions, k)
+			}
+		}
+		if _, ok := r.Sessions[session]; ok && session != "" {
+			if r.DownloadsExhausted() {
+				return errtypes.PermissionDenied("public link has reached its download limit")
+			}
+		} else {
+			if r.Exhausted() {
+				return errtypes.PermissionDenied("public link has reached its usage limit")
+			}
+			r.Accesses++
+		}
+		if session != "" {
+			if r.Sessions == nil {
+				r.Sessions = map[string]int64{}
+			}
+			r.Sessions[session] = now.Unix()
+		}
+		r.LastAccess = now.Unix()
+		return nil
+	})
+}
+
+// RecordDownload counts a download of the given size. It fails if the share has reached its download limit.
+func (m *Manager) RecordDownload(shareID string, size int64) (Usage, error) {
+	return m.update(shareID, func(r *record) error {
+		if r.DownloadsExhausted() {
+			return errtypes.PermissionDenied("public link has reached its download limit")
+		}
+		r.Downloads++
+		r.BytesServed += size
+		r.LastAccess = time.Now().Unix()
+		return nil
+	})
+}
+
+// Delete removes the usage of the given share
+func (m *Manager) Delete(shareID string) error {
+	return m.store.Delete(shareID)
+}
+
+// update applies fn to the stored record. fn may be called again if the record was changed concurrently.
+func (m *Manager) update(shareID string, fn func(r *record) error) (Usage, error) {
+	var u Usage
+	err := m.store.Update(shareID, 0, func(old []byte) ([]byte, error) {
+		r, err := decode(old)
+		if err != nil {
+			return nil, err
+		}
+		err = fn(&r)
+		u = r.Usage
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(r)
+	})
+	return u, err
+}
+
+func decode(v []byte) (record, error) {
+	r := record{}
+	if len(v) == 0 {
+		return r, nil
+	}
+	err := json.Unmarshal(v, &r)
+	return r, err
+}
@@ -0,0 +1,180 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package usage
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+)
+
+func TestDownloadLimit(t *testing.T) {
+	m := newVolatile(t)
+	if _, err := m.SetLimits("share", Limits{MaxDownloads: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := m.RecordDownload("share", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Downloads != 1 || u.BytesServed != 42 || u.LastAccess == 0 || !u.Exhausted() {
+		t.Errorf("unexpected usage after the first download: %+v", u)
+	}
+
+	if _, err := m.RecordDownload("share", 42); err == nil {
+		t.Error("expected the second download to be denied")
+	} else if _, ok := err.(errtypes.PermissionDenied); !ok {
+		t.Errorf("expected a permission denied error, got %v", err)
+	}
+	if _, err := m.RecordAccess("share", "session"); err == nil {
+		t.Error("expected accesses to be denied once the download limit has been reached")
+	}
+
+	// other shares are not affected
+	if _, err := m.RecordDownload("other", 1); err != nil {
+		t.Errorf("expected downloads of other shares to be allowed, got %v", err)
+	}
+}
+
+func TestAccessLimit(t *testing.T) {
+	m := newVolatile(t)
+	if _, err := m.SetLimits("share", Limits{MaxAccesses: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range []string{"a", "b", "a", ""} {
+		if _, err := m.RecordAccess("share", session); err != nil && session != "" {
+			t.Fatal(err)
+		}
+	}
+	// accesses without a session always count
+	if _, err := m.RecordAccess("share", ""); err == nil {
+		t.Error("expected an access without session to be denied")
+	}
+	if _, err := m.RecordAccess("share", "c"); err == nil {
+		t.Error("expected the third session to be denied")
+	}
+	// open sessions keep working
+	if _, err := m.RecordAccess("share", "b"); err != nil {
+		t.Errorf("expected an open session to keep working, got %v", err)
+	}
+
+	// raising the limit keeps the statistics
+	u, err := m.SetLimits("share", Limits{MaxAccesses: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Accesses != 2 || u.Exhausted() {
+		t.Errorf("unexpected usage after raising the limit: %+v", u)
+	}
+
+	if err := m.Delete("share"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := m.Get("share"); u != (Usage{}) {
+		t.Errorf("expected the usage to be deleted, got %+v", u)
+	}
+	if _, err := m.SetLimits("share", Limits{MaxDownloads: -1}); err == nil {
+		t.Error("expected negative limits to be rejected")
+	}
+}
+
+func TestSessionTimeout(t *testing.T) {
+	m := newVolatile(t)
+	m.sessionTimeout = 0
+	if _, err := m.SetLimits("share", Limits{MaxAccesses: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RecordAccess("share", "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, err := m.RecordAccess("share", "a"); err == nil {
+		t.Error("expected an expired session to count as a new access")
+	}
+}
+
+func TestConcurrentDownloads(t *testing.T) {
+	opts := &natsserver.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()}
+	ns, err := natsserver.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+
+	// the public share and the public storage provider share the store
+	var managers []*Manager
+	for i := 0; i < 2; i++ {
+		m, err := New(&Config{Store: "nats-js-kv", Nodes: []string{ns.ClientURL()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		managers = append(managers, m)
+	}
+	if _, err := managers[0].SetLimits("share", Limits{MaxDownloads: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(m *Manager) {
+			defer wg.Done()
+			if _, err := m.RecordDownload("share", 1); err == nil {
+				allowed.Add(1)
+			}
+		}(managers[i%2])
+	}
+	wg.Wait()
+	if allowed.Load() != 3 {
+		t.Errorf("expected exactly 3 downloads to be allowed, got %d", allowed.Load())
+	}
+	if u, err := managers[1].Get("share"); err != nil || u.Downloads != 3 || u.BytesServed != 3 {
+		t.Errorf("unexpected usage %+v %v", u, err)
+	}
+}
+
+func TestPersistentStore(t *testing.T) {
+	for _, c := range []Config{{}, {Store: "ocmem"}, {Store: "noop"}, {Store: "memory"}, {Store: "etcd"}} {
+		if _, err := New(&c); err == nil {
+			t.Errorf("expected the %q store to be rejected", c.Store)
+		}
+	}
+	if _, err := New(&Config{Store: "memory", Volatile: true}); err != nil {
+		t.Errorf("expected the memory store to be allowed explicitly, got %v", err)
+	}
+}
+
+func newVolatile(t *testing.T) *Manager {
+	t.Helper()
+	m, err := New(&Config{Store: "memory", Volatile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
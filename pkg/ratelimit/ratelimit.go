@@ -0,0 +1,239 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package ratelimit implements token bucket rate limiting. The buckets are kept in a
+// store, the limits hold across replicas when the redis or nats-js-kv store is used.
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+// The supported bucket keys
+const (
+	// KeyUser keeps one bucket per user. Anonymous requests fall back to the client ip.
+	KeyUser = "user"
+	// KeyIP keeps one bucket per client ip.
+	KeyIP = "ip"
+	// KeyMethod keeps a single bucket for the configured method or route prefix.
+	KeyMethod = "method"
+)
+
+// DefaultRule is the rule name used for requests that do not match a configured limit
+const DefaultRule = "default"
+
+var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "reva",
+	Subsystem: "ratelimit",
+	Name:      "requests_total",
+	Help:      "The total number of rate limited requests by protocol, rule and result",
+}, []string{"protocol", "rule", "result"})
+
+// Limit describes a token bucket
+type Limit struct {
+	Rate  float64 `mapstructure:"rate" docs:"0;The number of requests per second that are refilled into the bucket. 0 disables the limit."`
+	Burst int     `mapstructure:"burst" docs:"0;The maximum number of requests that can be made at once. Defaults to the rate."`
+	Key   string  `mapstructure:"key" docs:"user;What to keep a bucket for. One of user, ip or method."`
+}
+
+// Config holds the rate limiting configuration
+type Config struct {
+	Store        string   `mapstructure:"store" docs:"memory;The store to keep the buckets in. Use nats-js-kv, redis or redis-sentinel when running multiple replicas, other stores are only consistent within a replica."`
+	Nodes        []string `mapstructure:"nodes" docs:";The nodes of the store."`
+	Database     string   `mapstructure:"database" docs:"reva;The database of the store."`
+	Table        string   `mapstructure:"table" docs:"ratelimit;The table of the store."`
+	AuthUsername string   `mapstructure:"auth_username" docs:";The username to authenticate with the store."`
+	AuthPassword string   `mapstructure:"auth_password" docs:";The password to authenticate with the store."`
+
+	Default *Limit           `mapstructure:"default" docs:";The limit for all methods or routes without a specific limit. No limit if unset."`
+	Limits  map[string]Limit `mapstructure:"limits" docs:";Limits per grpc method or http route prefix, e.g. /cs3.gateway.v1beta1.GatewayAPI/Stat or /remote.php/dav/. The longest matching prefix wins."`
+}
+
+// Request identifies who is calling what
+type Request struct {
+	// Name is the full grpc method or the http path
+	Name string
+	// User is the id of the authenticated user, if any
+	User string
+	// IP is the client ip
+	IP string
+}
+
+// Limiter checks requests against the configured limits
+type Limiter struct {
+	conf     *Config
+	protocol string
+	buckets  cas.Store
+}
+
+type bucket struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+}
+
+// New returns a new limiter. The protocol is used to separate the buckets and metrics of different interceptors.
+func New(protocol string, c *Config) (*Limiter, error) {
+	if c.Database == "" {
+		c.Database = "reva"
+	}
+	if c.Table == "" {
+		c.Table = "ratelimit"
+	}
+	for name, l := range c.Limits {
+		c.Limits[name] = l.withDefaults()
+	}
+	if c.Default != nil {
+		d := c.Default.withDefaults()
+		c.Default = &d
+	}
+	var maxTTL time.Duration
+	for _, l := range c.Limits {
+		maxTTL = max(maxTTL, l.ttl())
+	}
+	if c.Default != nil {
+		maxTTL = max(maxTTL, c.Default.ttl())
+	}
+	// the bucket is full again once all tokens have been refilled, so the keys can expire with the longest ttl
+	b, err := cas.New(cas.Config{
+		Store:        c.Store,
+		Nodes:        c.Nodes,
+		Database:     c.Database,
+		Table:        c.Table,
+		AuthUsername: c.AuthUsername,
+		AuthPassword: c.AuthPassword,
+	}, maxTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Limiter{
+		conf:     c,
+		protocol: protocol,
+		buckets:  b,
+	}, nil
+}
+
+func (l Limit) withDefaults() Limit {
+	if l.Key == "" {
+		l.Key = KeyUser
+	}
+	if l.Burst <= 0 {
+		l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	}
+	return l
+}
+
+// ttl returns the duration after which an empty bucket is full again
+func (l Limit) ttl() time.Duration {
+	if l.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// rule returns the name and limit of the longest configured prefix matching the given name
+func (lim *Limiter) rule(name string) (string, *Limit) {
+	var match string
+	var limit *Limit
+	for prefix, l := range lim.conf.Limits {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(match) {
+			l := l
+			match, limit = prefix, &l
+		}
+	}
+	if limit != nil {
+		return match, limit
+	}
+	return DefaultRule, lim.conf.Default
+}
+
+// Take takes a token for the request. If the bucket is empty it returns false and
+// the duration after which the request can be retried. Errors of the store are
+// returned alongside an allowed result so that callers can log them without
+// rejecting requests.
+func (lim *Limiter) Take(r Request) (bool, time.Duration, error) {
+	name, limit := lim.rule(r.Name)
+	if limit == nil || limit.Rate <= 0 {
+		return true, 0, nil
+	}
+
+	subject := ""
+	switch limit.Key {
+	case KeyMethod:
+		// prefix rules share one bucket, the default rule keeps one per method or route
+		if name == DefaultRule {
+			subject = r.Name
+		}
+	case KeyIP:
+		subject = "ip:" + r.IP
+	default:
+		if r.User != "" {
+			subject = "user:" + r.User
+		} else {
+			subject = "ip:" + r.IP
+		}
+	}
+
+	allowed, retryAfter, err := lim.take(lim.protocol+"|"+name+"|"+subject, *limit, time.Now())
+	result := "allowed"
+	if !allowed {
+		result = "rejected"
+	}
+	requests.WithLabelValues(lim.protocol, name, result).Inc()
+	return allowed, retryAfter, err
+}
+
+func (lim *Limiter) take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	var allowed bool
+	var retryAfter time.Duration
+	// the bucket is full again once all tokens have been refilled, so there is no need to keep it
+	err := lim.buckets.Update(key, limit.ttl(), func(old []byte) ([]byte, error) {
+		b := bucket{Tokens: float64(limit.Burst), Updated: now.UnixNano()}
+		if old != nil {
+			if err := json.Unmarshal(old, &b); err != nil {
+				return nil, err
+			}
+			elapsed := now.Sub(time.Unix(0, b.Updated)).Seconds()
+			if elapsed > 0 {
+				b.Tokens = math.Min(float64(limit.Burst), b.Tokens+elapsed*limit.Rate)
+			}
+			b.Updated = now.UnixNano()
+		}
+
+		allowed = b.Tokens >= 1
+		retryAfter = 0
+		if allowed {
+			b.Tokens--
+		} else {
+			retryAfter = time.Duration((1 - b.Tokens) / limit.Rate * float64(time.Second))
+		}
+		return json.Marshal(b)
+	})
+	if err != nil {
+		// do not reject requests because the store is unavailable
+		return true, 0, err
+	}
+	return allowed, retryAfter, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+func TestTake(t *testing.T) {
+	lim, err := New("test", &Config{
+		Default: &Limit{Rate: 1, Burst: 2},
+		Limits: map[string]Limit{
+			"/remote.php/dav/": {Rate: 10, Key: KeyIP},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Request{Name: "/cs3.gateway.v1beta1.GatewayAPI/Stat", User: "einstein", IP: "127.0.0.1"}
+	for i := 0; i < 2; i++ {
+		if allowed, _, err := lim.Take(r); !allowed || err != nil {
+			t.Fatalf("expected request %d to be allowed, got %v, %v", i, allowed, err)
+		}
+	}
+	allowed, retryAfter, err := lim.Take(r)
+	if allowed || err != nil {
+		t.Fatalf("expected request to be rejected, got %v, %v", allowed, err)
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("unexpected retry after %s", retryAfter)
+	}
+
+	// other users have their own bucket
+	r.User = "marie"
+	if allowed, _, _ := lim.Take(r); !allowed {
+		t.Error("expected request of another user to be allowed")
+	}
+
+	// the more specific limit applies
+	r.Name = "/remote.php/dav/spaces/foo"
+	for i := 0; i < 10; i++ {
+		if allowed, _, _ := lim.Take(r); !allowed {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+	if allowed, _, _ := lim.Take(r); allowed {
+		t.Error("expected request to be rejected after the burst")
+	}
+}
+
+func TestRefill(t *testing.T) {
+	lim, err := New("test", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := Limit{Rate: 2, Burst: 1}.withDefaults()
+	now := time.Now()
+
+	if allowed, _, _ := lim.take("key", limit, now); !allowed {
+		t.Fatal("expected first request to be allowed")
+	}
+	if allowed, _, _ := lim.take("key", limit, now.Add(100*time.Millisecond)); allowed {
+		t.Fatal("expected request to be rejected before the bucket was refilled")
+	}
+	if allowed, _, _ := lim.take("key", limit, now.Add(600*time.Millisecond)); !allowed {
+		t.Fatal("expected request to be allowed after the bucket was refilled")
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	lim, err := New("test", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if allowed, _, _ := lim.Take(Request{Name: "/foo"}); !allowed {
+			t.Fatal("expected requests to be allowed without limits")
+		}
+	}
+}
+
+func TestConcurrentTakes(t *testing.T) {
+	takeConcurrently(t, &Config{})
+}
+
+func TestConcurrentTakesAcrossReplicas(t *testing.T) {
+	opts := &natsserver.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()}
+	ns, err := natsserver.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+
+	takeConcurrently(t, &Config{Store: "nats-js-kv", Nodes: []string{ns.ClientURL()}}, &Config{Store: "nats-js-kv", Nodes: []string{ns.ClientURL()}})
+}
+
+// takeConcurrently takes tokens from one bucket with a limiter per config, like replicas
+// sharing a store would, and checks that no update gets lost
+func takeConcurrently(t *testing.T, configs ...*Config) {
+	const burst = 20
+	var limiters []*Limiter
+	for _, c := range configs {
+		c.Default = &Limit{Rate: 0.001, Burst: burst, Key: KeyMethod}
+		lim, err := New("test", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limiters = append(limiters, lim)
+	}
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2*burst; i++ {
+		wg.Add(1)
+		go func(lim *Limiter) {
+			defer wg.Done()
+			ok, _, err := lim.Take(Request{Name: "/foo"})
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}(limiters[i%len(limiters)])
+	}
+	wg.Wait()
+	if allowed.Load() != burst {
+		t.Errorf("expected exactly %d requests to be allowed, got %d", burst, allowed.Load())
+	}
+}
@@ -139,6 +139,22 @@ func NewInvalidArg(ctx context.Context, msg string) *rpc.Status {
 	}
 }
 
+// NewOutOfRange returns a Status with CODE_OUT_OF_RANGE.
+func NewOutOfRange(ctx context.Context, msg string) *rpc.Status {
+	return &rpc.Status{Code: rpc.Code_CODE_OUT_OF_RANGE,
+		Message: msg,
+		Trace:   getTrace(ctx),
+	}
+}
+
+// NewResourceExhausted returns a Status with CODE_RESOURCE_EXHAUSTED.
+func NewResourceExhausted(ctx context.Context, msg string) *rpc.Status {
+	return &rpc.Status{Code: rpc.Code_CODE_RESOURCE_EXHAUSTED,
+		Message: msg,
+		Trace:   getTrace(ctx),
+	}
+}
+
 // NewConflict returns a Status with Code_CODE_ABORTED.
 //
 // Deprecated: NewConflict exists for historical compatibility
@@ -191,6 +207,12 @@ func NewStatusFromErrType(ctx context.Context, msg string, err error) *rpc.Statu
 		return NewUnimplemented(ctx, err, msg+":"+err.Error())
 	case errtypes.BadRequest:
 		return NewInvalid(ctx, msg+":"+err.Error())
+	case errtypes.TooLarge:
+		return NewOutOfRange(ctx, msg+": "+err.Error())
+	case errtypes.UnsupportedMediaType:
+		return NewInvalidArg(ctx, msg+": "+err.Error())
+	case errtypes.TooManyRequests:
+		return NewResourceExhausted(ctx, msg+": "+err.Error())
 	}
 
 	// map GRPC status codes coming from the auth middleware
@@ -38,6 +38,10 @@ import (
 	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	storageregistry "github.com/cs3org/go-cs3apis/cs3/storage/registry/v1beta1"
 	datatx "github.com/cs3org/go-cs3apis/cs3/tx/v1beta1"
+	groupprovisioning "github.com/opencloud-eu/reva/v2/pkg/group/provisioning"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences/keys"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/uploadsessions"
+	userprovisioning "github.com/opencloud-eu/reva/v2/pkg/user/provisioning"
 )
 
 // GetGatewayServiceClient returns a GatewayServiceClient.
@@ -159,3 +163,27 @@ func GetDataTxClient(id string, opts ...Option) (datatx.TxAPIClient, error) {
 	selector, _ := TXSelector(id, opts...)
 	return selector.Next()
 }
+
+// GetUploadSessionsClient returns a new UploadSessionsClient.
+func GetUploadSessionsClient(id string, opts ...Option) (uploadsessions.UploadSessionsAPIClient, error) {
+	selector, _ := UploadSessionsSelector(id, opts...)
+	return selector.Next()
+}
+
+// GetPreferencesKeysClient returns a new PreferencesKeysClient.
+func GetPreferencesKeysClient(id string, opts ...Option) (keys.KeysAPIClient, error) {
+	selector, _ := PreferencesKeysSelector(id, opts...)
+	return selector.Next()
+}
+
+// GetUserProvisioningClient returns a new UserProvisioningClient.
+func GetUserProvisioningClient(id string, opts ...Option) (userprovisioning.ProvisioningAPIClient, error) {
+	selector, _ := UserProvisioningSelector(id, opts...)
+	return selector.Next()
+}
+
+// GetGroupProvisioningClient returns a new GroupProvisioningClient.
+func GetGroupProvisioningClient(id string, opts ...Option) (groupprovisioning.ProvisioningAPIClient, error) {
+	selector, _ := GroupProvisioningSelector(id, opts...)
+	return selector.Next()
+}
@@ -42,7 +42,11 @@ import (
 	storageProvider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	storageRegistry "github.com/cs3org/go-cs3apis/cs3/storage/registry/v1beta1"
 	tx "github.com/cs3org/go-cs3apis/cs3/tx/v1beta1"
+	groupprovisioning "github.com/opencloud-eu/reva/v2/pkg/group/provisioning"
+	"github.com/opencloud-eu/reva/v2/pkg/preferences/keys"
 	"github.com/opencloud-eu/reva/v2/pkg/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/uploadsessions"
+	userprovisioning "github.com/opencloud-eu/reva/v2/pkg/user/provisioning"
 	"github.com/pkg/errors"
 	"github.com/sercand/kuberesolver/v5"
 	"google.golang.org/grpc"
@@ -343,3 +347,43 @@ func TXSelector(id string, options ...Option) (*Selector[tx.TxAPIClient], error)
 		options...,
 	), nil
 }
+
+// UploadSessionsSelector returns a Selector[uploadsessions.UploadSessionsAPIClient].
+func UploadSessionsSelector(id string, options ...Option) (*Selector[uploadsessions.UploadSessionsAPIClient], error) {
+	return GetSelector[uploadsessions.UploadSessionsAPIClient](
+		"UploadSessionsSelector",
+		id,
+		uploadsessions.NewUploadSessionsAPIClient,
+		options...,
+	), nil
+}
+
+// PreferencesKeysSelector returns a Selector[keys.KeysAPIClient].
+func PreferencesKeysSelector(id string, options ...Option) (*Selector[keys.KeysAPIClient], error) {
+	return GetSelector[keys.KeysAPIClient](
+		"PreferencesKeysSelector",
+		id,
+		keys.NewKeysAPIClient,
+		options...,
+	), nil
+}
+
+// UserProvisioningSelector returns a Selector[userprovisioning.ProvisioningAPIClient].
+func UserProvisioningSelector(id string, options ...Option) (*Selector[userprovisioning.ProvisioningAPIClient], error) {
+	return GetSelector[userprovisioning.ProvisioningAPIClient](
+		"UserProvisioningSelector",
+		id,
+		userprovisioning.NewProvisioningAPIClient,
+		options...,
+	), nil
+}
+
+// GroupProvisioningSelector returns a Selector[groupprovisioning.ProvisioningAPIClient].
+func GroupProvisioningSelector(id string, options ...Option) (*Selector[groupprovisioning.ProvisioningAPIClient], error) {
+	return GetSelector[groupprovisioning.ProvisioningAPIClient](
+		"GroupProvisioningSelector",
+		id,
+		groupprovisioning.NewProvisioningAPIClient,
+		options...,
+	), nil
+}
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"path"
 	"regexp"
+	"strings"
 
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/mitchellh/mapstructure"
@@ -38,6 +39,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx"
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/manager/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/metrics"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/utils/checksum"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
 	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
 )
@@ -167,6 +169,18 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 			method = r.Header.Get("X-HTTP-Method-Override")
 		}
 
+		// the tus checksum extension allows verifying every chunk, see https://tus.io/protocols/resumable-upload#checksum
+		// the tusd handler passes the request context to the storage, so we hand over the expected checksum there.
+		if header := r.Header.Get(net.HeaderUploadChecksum); header != "" && (method == "POST" || method == "PATCH") {
+			c, err := checksum.Parse(header)
+			if err != nil {
+				sublog.Debug().Err(err).Str("header", header).Msg("invalid upload checksum")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(checksum.ContextSetChecksum(r.Context(), c))
+		}
+
 		switch method {
 		case "POST":
 			metrics.UploadsActive.Add(1)
@@ -202,7 +216,12 @@ func (m *manager) Handler(fs storage.FS) (http.Handler, error) {
 		}
 	}))
 
-	return h, nil
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set(net.HeaderTusChecksumAlgorithm, strings.Join(checksum.Algorithms, ","))
+		}
+		h.ServeHTTP(w, r)
+	}), nil
 }
 
 func setHeaders(fs storage.FS, w http.ResponseWriter, r *http.Request) {
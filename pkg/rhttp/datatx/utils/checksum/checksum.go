@@ -0,0 +1,143 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package checksum implements the tus checksum extension for chunk uploads.
+// See https://tus.io/protocols/resumable-upload#checksum
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+// StatusChecksumMismatch is the status code the tus protocol uses for chunks that do not match the sent checksum.
+const StatusChecksumMismatch = 460
+
+// Algorithms lists the supported checksum algorithms as advertised in the Tus-Checksum-Algorithm header.
+var Algorithms = []string{"sha1", "md5", "adler32", "crc32"}
+
+var (
+	// ErrChecksumMismatch is returned when the checksum of a chunk does not match the Upload-Checksum header.
+	ErrChecksumMismatch = tusd.NewError("ERR_CHECKSUM_MISMATCH", "checksum mismatch", StatusChecksumMismatch)
+	// ErrUnsupportedAlgorithm is returned when the Upload-Checksum header uses an unknown algorithm.
+	ErrUnsupportedAlgorithm = tusd.NewError("ERR_UNSUPPORTED_CHECKSUM_ALGORITHM", "unsupported checksum algorithm", 400)
+	// ErrInvalidChecksum is returned when the Upload-Checksum header cannot be parsed.
+	ErrInvalidChecksum = tusd.NewError("ERR_INVALID_CHECKSUM", "invalid Upload-Checksum header", 400)
+)
+
+type key int
+
+const checksumKey key = iota
+
+// Checksum is the expected checksum of a single chunk.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// Parse parses the value of an Upload-Checksum header, e.g. 'sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0='.
+func Parse(header string) (*Checksum, error) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidChecksum
+	}
+	alg := strings.ToLower(parts[0])
+	if _, err := NewHash(alg); err != nil {
+		return nil, err
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, ErrInvalidChecksum
+	}
+	return &Checksum{Algorithm: alg, Sum: sum}, nil
+}
+
+// NewHash returns a new hash for the given algorithm.
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	case "adler32":
+		return adler32.New(), nil
+	case "crc32":
+		return crc32.NewIEEE(), nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// ContextSetChecksum stores the expected chunk checksum in the context.
+func ContextSetChecksum(ctx context.Context, c *Checksum) context.Context {
+	return context.WithValue(ctx, checksumKey, c)
+}
+
+// ContextGetChecksum returns the expected chunk checksum if set in the given context.
+func ContextGetChecksum(ctx context.Context) (*Checksum, bool) {
+	c, ok := ctx.Value(checksumKey).(*Checksum)
+	return c, ok && c != nil
+}
+
+// Verifier hashes everything read through it and compares the result with the expected checksum.
+type Verifier struct {
+	checksum *Checksum
+	h        hash.Hash
+}
+
+// NewVerifier returns a verifier for the checksum in the context. It returns nil if no checksum was sent.
+func NewVerifier(ctx context.Context) *Verifier {
+	c, ok := ContextGetChecksum(ctx)
+	if !ok {
+		return nil
+	}
+	h, err := NewHash(c.Algorithm)
+	if err != nil {
+		return nil
+	}
+	return &Verifier{checksum: c, h: h}
+}
+
+// Reader wraps the given reader so that all read bytes are hashed.
+func (v *Verifier) Reader(r io.Reader) io.Reader {
+	if v == nil {
+		return r
+	}
+	return io.TeeReader(r, v.h)
+}
+
+// Verify returns ErrChecksumMismatch if the hashed bytes do not match the expected checksum.
+func (v *Verifier) Verify() error {
+	if v == nil {
+		return nil
+	}
+	if !bytes.Equal(v.h.Sum(nil), v.checksum.Sum) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
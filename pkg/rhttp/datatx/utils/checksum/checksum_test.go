@@ -0,0 +1,110 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package checksum
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	tests := []struct {
+		description string
+		header      string
+		content     string
+		expected    error
+	}{
+		{
+			description: "sha1",
+			header:      "sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=",
+			content:     "hello world",
+		},
+		{
+			description: "md5",
+			header:      "md5 XrY7u+Ae7tCTyyK7j1rNww==",
+			content:     "hello world",
+		},
+		{
+			description: "adler32",
+			header:      "adler32 GgsEXQ==",
+			content:     "hello world",
+		},
+		{
+			description: "crc32",
+			header:      "CRC32 DUoRhQ==",
+			content:     "hello world",
+		},
+		{
+			description: "mismatch",
+			header:      "sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=",
+			content:     "hello mars",
+			expected:    ErrChecksumMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			c, err := Parse(tt.header)
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %v", tt.header, err)
+			}
+			v := NewVerifier(ContextSetChecksum(context.Background(), c))
+			if _, err := io.Copy(io.Discard, v.Reader(strings.NewReader(tt.content))); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Verify(); !matches(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	for header, expected := range map[string]error{
+		"sha1":                           ErrInvalidChecksum,
+		"sha1 not-base64!":               ErrInvalidChecksum,
+		"sha256 DUoRhQ==":                ErrUnsupportedAlgorithm,
+		"adler32 GgsEXQ==":               nil,
+		"  md5 XrY7u+Ae7tCTyyK7j1rNww==": nil,
+	} {
+		if _, err := Parse(header); !matches(err, expected) {
+			t.Errorf("parsing %q: expected %v, got %v", header, expected, err)
+		}
+	}
+}
+
+func TestNoChecksum(t *testing.T) {
+	v := NewVerifier(context.Background())
+	if v != nil {
+		t.Fatal("expected no verifier without checksum")
+	}
+	if err := v.Verify(); err != nil {
+		t.Errorf("expected nil verifier to succeed, got %v", err)
+	}
+}
+
+func matches(err, expected error) bool {
+	if expected == nil {
+		return err == nil
+	}
+	return errors.Is(err, expected)
+}
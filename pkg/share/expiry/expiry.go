@@ -0,0 +1,273 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package expiry periodically expires shares and reminds users of shares that are about to expire.
+package expiry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/store"
+	"github.com/opencloud-eu/reva/v2/pkg/store/cas"
+)
+
+// minLease is the shortest time a sweeper holds the lease of a sweep, another replica takes over
+// if the sweep did not finish by then
+const minLease = 5 * time.Minute
+
+// Config configures a sweeper
+type Config struct {
+	// Interval is the number of seconds between two sweeps, 0 disables the sweeper
+	Interval int `mapstructure:"expiry_sweep_interval"`
+	// RemindBefore is the number of seconds before the expiration a reminder is sent, 0 disables reminders
+	RemindBefore int `mapstructure:"expiry_reminder"`
+
+	// Store keeps the time of the last sweep and the lease that makes sure only one replica sweeps at a time.
+	// It has to be set if the sweeper is enabled. Use nats-js-kv, redis or redis-sentinel, other stores are
+	// neither shared across replicas nor persistent.
+	Store        string   `mapstructure:"expiry_store"`
+	Nodes        []string `mapstructure:"expiry_store_nodes"`
+	Database     string   `mapstructure:"expiry_store_database"`
+	Table        string   `mapstructure:"expiry_store_table"`
+	AuthUsername string   `mapstructure:"expiry_store_auth_username"`
+	AuthPassword string   `mapstructure:"expiry_store_auth_password"`
+}
+
+// Window describes what a single sweep has to do
+type Window struct {
+	// Now is the time of the sweep, shares that expired before are removed
+	Now time.Time
+	// shares expiring until remindUntil get a reminder
+	remindUntil time.Time
+	sweeper     *Sweeper
+}
+
+// Expired returns true if the expiration lies before the sweep
+func (w Window) Expired(expiration time.Time) bool {
+	return expiration.Before(w.Now)
+}
+
+// Remind returns true if a reminder has to be sent for the share. Reminders are tracked per share,
+// call Reminded once the reminder was sent. A share gets a new reminder when its expiration changes.
+func (w Window) Remind(shareID string, expiration time.Time) bool {
+	if w.sweeper == nil || w.Expired(expiration) || expiration.After(w.remindUntil) {
+		return false
+	}
+	b, err := w.sweeper.store.Get(w.sweeper.reminderKey(shareID))
+	if err != nil {
+		// rather remind twice than not at all
+		return true
+	}
+	return string(b) != expiration.UTC().Format(time.RFC3339Nano)
+}
+
+// Reminded records that the reminder for the share has been sent
+func (w Window) Reminded(shareID string, expiration time.Time) error {
+	// the record is not needed anymore once the share expired
+	ttl := max(expiration.Sub(w.Now)+w.sweeper.interval, time.Second)
+	return w.sweeper.store.Update(w.sweeper.reminderKey(shareID), ttl, func([]byte) ([]byte, error) {
+		return []byte(expiration.UTC().Format(time.RFC3339Nano)), nil
+	})
+}
+
+// state is kept in the store, it is shared by the sweepers of all replicas of a share manager
+type state struct {
+	// Last is the time of the last successful sweep
+	Last time.Time `json:"last"`
+	// Holder is the sweeper currently sweeping, it holds the lease until LeasedUntil
+	Holder      string    `json:"holder,omitempty"`
+	LeasedUntil time.Time `json:"leased_until,omitempty"`
+}
+
+// errSkipped aborts taking the lease if another sweeper is sweeping or just swept
+var errSkipped = errors.New("expiry: sweep skipped")
+
+// Sweeper calls a sweep function of a share manager periodically. The replicas of a share manager
+// share the state of the sweeps and the sent reminders through the store, so only one of them sweeps
+// at a time and reminders are sent once.
+type Sweeper struct {
+	name         string
+	id           string
+	interval     time.Duration
+	remindBefore time.Duration
+	sweep        func(context.Context, Window) error
+	store        cas.Store
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New returns a sweeper for the given sweep function. The name identifies the share manager in the store.
+// The sweep function returns an error if a share could not be handled, the sweep is repeated then.
+func New(name string, c Config, sweep func(context.Context, Window) error) (*Sweeper, error) {
+	if c.Store == "" {
+		if c.Interval > 0 {
+			return nil, fmt.Errorf("expiry: expiry_store has to be configured to sweep expired shares")
+		}
+		// sweeps can only be triggered manually
+		c.Store = store.TypeMemory
+	}
+	if c.Database == "" {
+		c.Database = "reva"
+	}
+	if c.Table == "" {
+		c.Table = "expiry"
+	}
+	interval := time.Duration(c.Interval) * time.Second
+	remindBefore := time.Duration(c.RemindBefore) * time.Second
+	var maxTTL time.Duration
+	if remindBefore > 0 {
+		// the state is written on every sweep and the reminders are needed until the shares expired
+		maxTTL = remindBefore + 2*max(interval, minLease)
+	}
+	st, err := cas.New(cas.Config{
+		Store:        c.Store,
+		Nodes:        c.Nodes,
+		Database:     c.Database,
+		Table:        c.Table,
+		AuthUsername: c.AuthUsername,
+		AuthPassword: c.AuthPassword,
+	}, maxTTL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sweeper{
+		name:         name,
+		id:           uuid.New().String(),
+		interval:     interval,
+		remindBefore: remindBefore,
+		sweep:        sweep,
+		store:        st,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// Start runs the sweeper in the background if an interval is configured
+func (s *Sweeper) Start() {
+	if s.interval <= 0 {
+		close(s.done)
+		return
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := s.Sweep(s.ctx, now); err != nil && s.ctx.Err() == nil {
+					appctx.GetLogger(s.ctx).Error().Err(err).Str("sweeper", s.name).Msg("error sweeping expired shares")
+				}
+			}
+		}
+	}()
+}
+
+// Stop cancels a running sweep and waits until the background sweeps ended. It must only be
+// called after Start.
+func (s *Sweeper) Stop() {
+	s.stopOnce.Do(s.cancel)
+	<-s.done
+}
+
+// Sweep runs a single sweep at the given time. It does nothing if another sweeper holds the lease or
+// swept less than half an interval ago.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) error {
+	err := s.acquire(now)
+	if errors.Is(err, errSkipped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w := Window{Now: now}
+	if s.remindBefore > 0 {
+		w.remindUntil = now.Add(s.remindBefore)
+		w.sweeper = s
+	}
+	err = s.sweep(ctx, w)
+
+	// a failed sweep is repeated with the next tick
+	if rerr := s.release(now, err == nil); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	return err
+}
+
+func (s *Sweeper) reminderKey(shareID string) string {
+	return s.name + "/reminded/" + base64.RawURLEncoding.EncodeToString([]byte(shareID))
+}
+
+// acquire takes the lease
+func (s *Sweeper) acquire(now time.Time) error {
+	return s.store.Update(s.name, 0, func(old []byte) ([]byte, error) {
+		st := state{}
+		if old != nil {
+			if err := json.Unmarshal(old, &st); err != nil {
+				return nil, err
+			}
+		}
+		// the lease is checked against the clock, now may lie in the past or future when sweeping manually
+		if st.Holder != "" && st.Holder != s.id && time.Now().Before(st.LeasedUntil) {
+			return nil, errSkipped
+		}
+		if s.interval > 0 && now.Before(st.Last.Add(s.interval/2)) {
+			return nil, errSkipped
+		}
+		st.Holder = s.id
+		st.LeasedUntil = time.Now().Add(max(s.interval, minLease))
+		return json.Marshal(st)
+	})
+}
+
+// release gives up the lease and records a successful sweep
+func (s *Sweeper) release(now time.Time, success bool) error {
+	return s.store.Update(s.name, 0, func(old []byte) ([]byte, error) {
+		st := state{}
+		if old != nil {
+			if err := json.Unmarshal(old, &st); err != nil {
+				return nil, err
+			}
+		}
+		if st.Holder == s.id {
+			st.Holder = ""
+			st.LeasedUntil = time.Time{}
+		}
+		if success && now.After(st.Last) {
+			st.Last = now
+		}
+		return json.Marshal(st)
+	})
+}
@@ -0,0 +1,247 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+func TestSweeper(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	expiration := start.Add(90 * time.Minute)
+
+	var reminded, expired int
+	s, err := New("test", Config{Interval: 600, RemindBefore: 3600, Store: "memory"}, func(_ context.Context, w Window) error {
+		if w.Remind("share", expiration) {
+			reminded++
+			if err := w.Reminded("share", expiration); err != nil {
+				return err
+			}
+		}
+		if w.Expired(expiration) {
+			expired++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for now := start; now.Before(start.Add(2 * time.Hour)); now = now.Add(10 * time.Minute) {
+		if err := s.Sweep(context.Background(), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reminded != 1 {
+		t.Errorf("expected one reminder, got %d", reminded)
+	}
+	if expired == 0 {
+		t.Error("expected the share to expire")
+	}
+
+	reminded = 0
+	s, err = New("test", Config{Interval: 600, Store: "memory"}, func(_ context.Context, w Window) error {
+		if w.Remind("share", expiration) {
+			reminded++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sweep(context.Background(), expiration.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if reminded != 0 {
+		t.Error("expected no reminder when reminders are disabled")
+	}
+}
+
+func TestSweeperReminders(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	expirations := map[string]time.Time{}
+	reminded := map[string]int{}
+	s, err := New("test", Config{Interval: 600, RemindBefore: 3600, Store: "memory"}, func(_ context.Context, w Window) error {
+		for id, expiration := range expirations {
+			if w.Remind(id, expiration) {
+				reminded[id]++
+				if err := w.Reminded(id, expiration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Sweep(context.Background(), start); err != nil {
+		t.Fatal(err)
+	}
+	// shares created or extended after a sweep are reminded even if they expire soon
+	expirations["new"] = start.Add(30 * time.Minute)
+	expirations["extended"] = start.Add(2 * time.Hour)
+	if err := s.Sweep(context.Background(), start.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	expirations["extended"] = start.Add(50 * time.Minute)
+	if err := s.Sweep(context.Background(), start.Add(20*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sweep(context.Background(), start.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if reminded["new"] != 1 || reminded["extended"] != 1 {
+		t.Errorf("expected one reminder per share, got %v", reminded)
+	}
+}
+
+func TestSweeperFailure(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var sweeps int
+	fail := true
+	s, err := New("test", Config{Interval: 600, Store: "memory"}, func(context.Context, Window) error {
+		sweeps++
+		if fail {
+			return errors.New("share could not be removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Sweep(context.Background(), start); err == nil {
+		t.Error("expected the error of the sweep")
+	}
+	// the failed sweep is not recorded, so the next tick sweeps again
+	fail = false
+	for _, now := range []time.Time{start.Add(time.Minute), start.Add(2 * time.Minute)} {
+		if err := s.Sweep(context.Background(), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sweeps != 2 {
+		t.Errorf("expected 2 sweeps, got %d", sweeps)
+	}
+}
+
+func TestSweeperStop(t *testing.T) {
+	started := make(chan struct{})
+	s, err := New("test", Config{Interval: 1, Store: "memory"}, func(ctx context.Context, _ Window) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	<-started
+	// stopping cancels the running sweep
+	s.Stop()
+	s.Stop()
+}
+
+func TestSweeperStoreRequired(t *testing.T) {
+	if _, err := New("test", Config{Interval: 600}, func(context.Context, Window) error { return nil }); err == nil {
+		t.Error("expected an enabled sweeper without store to be rejected")
+	}
+}
+
+func TestSweepersAcrossReplicas(t *testing.T) {
+	opts := &natsserver.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()}
+	ns, err := natsserver.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+
+	start := time.Unix(1700000000, 0)
+	expiration := start.Add(90 * time.Minute)
+	c := Config{Interval: 600, RemindBefore: 3600, Store: "nats-js-kv", Nodes: []string{ns.ClientURL()}}
+
+	var sweeps, reminded atomic.Int32
+	newReplica := func() *Sweeper {
+		s, err := New("test", c, func(_ context.Context, w Window) error {
+			sweeps.Add(1)
+			if w.Remind("share", expiration) {
+				reminded.Add(1)
+				return w.Reminded("share", expiration)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	replicas := []*Sweeper{newReplica(), newReplica()}
+
+	// both replicas tick at about the same time, only one of them sweeps
+	for now := start; now.Before(start.Add(2 * time.Hour)); now = now.Add(10 * time.Minute) {
+		for i, r := range replicas {
+			if err := r.Sweep(context.Background(), now.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if sweeps.Load() != 12 {
+		t.Errorf("expected 12 sweeps, got %d", sweeps.Load())
+	}
+	if reminded.Load() != 1 {
+		t.Errorf("expected one reminder, got %d", reminded.Load())
+	}
+
+	// a replica does not sweep while another one holds the lease
+	blocked, err := New("test", c, func(context.Context, Window) error {
+		sweeps.Add(1)
+		return replicas[0].Sweep(context.Background(), start.Add(4*time.Hour))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweeps.Store(0)
+	if err := blocked.Sweep(context.Background(), start.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if sweeps.Load() != 1 {
+		t.Errorf("expected only the lease holder to sweep, got %d sweeps", sweeps.Load())
+	}
+
+	// a restarted replica continues with the persisted time of the last sweep
+	sweeps.Store(0)
+	if err := newReplica().Sweep(context.Background(), start.Add(3*time.Hour+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if sweeps.Load() != 0 {
+		t.Errorf("expected the restarted replica to skip the sweep, got %d sweeps", sweeps.Load())
+	}
+}
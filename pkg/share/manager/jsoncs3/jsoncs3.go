@@ -39,6 +39,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/logger"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
 	"github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3/providercache"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3/receivedsharecache"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3/sharecache"
@@ -122,14 +123,15 @@ var (
 )
 
 type config struct {
-	GatewayAddr       string       `mapstructure:"gateway_addr"`
-	MaxConcurrency    int          `mapstructure:"max_concurrency"`
-	ProviderAddr      string       `mapstructure:"provider_addr"`
-	ServiceUserID     string       `mapstructure:"service_user_id"`
-	ServiceUserIdp    string       `mapstructure:"service_user_idp"`
-	MachineAuthAPIKey string       `mapstructure:"machine_auth_apikey"`
-	CacheTTL          int          `mapstructure:"ttl"`
-	Events            EventOptions `mapstructure:"events"`
+	GatewayAddr       string        `mapstructure:"gateway_addr"`
+	MaxConcurrency    int           `mapstructure:"max_concurrency"`
+	ProviderAddr      string        `mapstructure:"provider_addr"`
+	ServiceUserID     string        `mapstructure:"service_user_id"`
+	ServiceUserIdp    string        `mapstructure:"service_user_idp"`
+	MachineAuthAPIKey string        `mapstructure:"machine_auth_apikey"`
+	CacheTTL          int           `mapstructure:"ttl"`
+	Events            EventOptions  `mapstructure:"events"`
+	Expiry            expiry.Config `mapstructure:",squash"`
 }
 
 // EventOptions are the configurable options for events
@@ -161,6 +163,8 @@ type Manager struct {
 
 	gatewaySelector pool.Selectable[gatewayv1beta1.GatewayAPIClient]
 	eventStream     events.Stream
+
+	sweeper *expiry.Sweeper
 }
 
 // NewDefault returns a new manager instance with default dependencies
@@ -189,7 +193,25 @@ func NewDefault(m map[string]interface{}) (share.Manager, error) {
 		}
 	}
 
-	return New(s, gatewaySelector, c.CacheTTL, es, c.MaxConcurrency)
+	mgr, err := New(s, gatewaySelector, c.CacheTTL, es, c.MaxConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	sweeper, err := expiry.New("jsoncs3-shares", c.Expiry, mgr.SweepExpiredShares)
+	if err != nil {
+		return nil, err
+	}
+	sweeper.Start()
+	mgr.sweeper = sweeper
+	return mgr, nil
+}
+
+// Close stops the expiry sweeper
+func (m *Manager) Close() error {
+	if m.sweeper != nil {
+		m.sweeper.Stop()
+	}
+	return nil
 }
 
 // New returns a new manager instance.
@@ -1234,6 +1256,87 @@ func (m *Manager) removeShare(ctx context.Context, s *collaboration.Share, skipS
 	return eg.Wait()
 }
 
+// SweepExpiredShares removes the shares that expired and publishes reminders for the shares that are about to expire
+func (m *Manager) SweepExpiredShares(ctx context.Context, w expiry.Window) error {
+	log := appctx.GetLogger(ctx)
+
+	if err := m.initialize(ctx); err != nil {
+		return err
+	}
+
+	providers, err := m.Cache.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	var expired, expiring []*collaboration.Share
+	providers.Range(func(_ string, spaces *providercache.Spaces) bool {
+		spaces.Spaces.Range(func(spaceID string, shares *providercache.Shares) bool {
+			// the shares of the space are modified concurrently
+			unlock := m.Cache.LockSpace(spaceID)
+			defer unlock()
+			for _, s := range shares.Shares {
+				if s.GetExpiration() == nil {
+					continue
+				}
+				expiration := utils.TSToTime(s.GetExpiration())
+				switch {
+				case w.Expired(expiration):
+					expired = append(expired, s)
+				case m.eventStream != nil && w.Remind(s.GetId().GetOpaqueId(), expiration):
+					expiring = append(expiring, s)
+				}
+			}
+			return true
+		})
+		return true
+	})
+
+	var failed int
+	for _, s := range expired {
+		if err := m.removeShare(ctx, s, false); err != nil {
+			log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to unshare expired share")
+			failed++
+			continue
+		}
+		if m.eventStream == nil {
+			continue
+		}
+		if err := events.Publish(ctx, m.eventStream, events.ShareExpired{
+			ShareID:        s.GetId(),
+			ShareOwner:     s.GetOwner(),
+			ItemID:         s.GetResourceId(),
+			ExpiredAt:      utils.TSToTime(s.GetExpiration()),
+			GranteeUserID:  s.GetGrantee().GetUserId(),
+			GranteeGroupID: s.GetGrantee().GetGroupId(),
+		}); err != nil {
+			log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to publish share expired event")
+		}
+	}
+	for _, s := range expiring {
+		if err := events.Publish(ctx, m.eventStream, events.ShareExpiringSoon{
+			ShareID:        s.GetId(),
+			ShareOwner:     s.GetOwner(),
+			ItemID:         s.GetResourceId(),
+			ExpiresAt:      utils.TSToTime(s.GetExpiration()),
+			GranteeUserID:  s.GetGrantee().GetUserId(),
+			GranteeGroupID: s.GetGrantee().GetGroupId(),
+		}); err != nil {
+			log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to publish share expiring soon event")
+			failed++
+			continue
+		}
+		if err := w.Reminded(s.GetId().GetOpaqueId(), utils.TSToTime(s.GetExpiration())); err != nil {
+			log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to record the reminder")
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d expiring shares could not be handled", failed)
+	}
+	return nil
+}
+
 func (m *Manager) CleanupStaleShares(ctx context.Context) {
 	log := appctx.GetLogger(ctx)
 
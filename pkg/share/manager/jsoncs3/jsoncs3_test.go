@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	groupv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
@@ -36,6 +37,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
 	sharespkg "github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3/sharecache"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3/shareid"
@@ -44,6 +46,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/tests/cs3mocks/mocks"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
@@ -272,6 +275,26 @@ var _ = Describe("Jsoncs3", func() {
 		})
 	})
 
+	Describe("SweepExpiredShares", func() {
+		It("removes shares once they expired", func() {
+			expiring := proto.Clone(grant).(*collaboration.ShareGrant)
+			expiring.Expiration = utils.TimeToTS(time.Now().Add(time.Hour))
+			share, err := m.Share(ctx, sharedResource, expiring)
+			Expect(err).ToNot(HaveOccurred())
+
+			sweeper, err := expiry.New("test", expiry.Config{Interval: 60, RemindBefore: 7200, Store: "memory"}, m.SweepExpiredShares)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sweeper.Sweep(ctx, time.Now())).To(Succeed())
+			s, err := m.GetShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: share.Id}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).ToNot(BeNil())
+
+			Expect(sweeper.Sweep(ctx, time.Now().Add(2*time.Hour))).To(Succeed())
+			_, err = m.GetShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: share.Id}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Context("with a space manager", func() {
 		var (
 			share *collaboration.Share
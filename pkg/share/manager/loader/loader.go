@@ -24,5 +24,6 @@ import (
 	_ "github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3"
 	_ "github.com/opencloud-eu/reva/v2/pkg/share/manager/memory"
 	_ "github.com/opencloud-eu/reva/v2/pkg/share/manager/owncloudsql"
+	_ "github.com/opencloud-eu/reva/v2/pkg/share/manager/sql"
 	// Add your own here
 )
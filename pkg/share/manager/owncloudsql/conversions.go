@@ -279,9 +279,23 @@ func (m *mgr) convertToCS3Share(ctx context.Context, s DBShare, storageMountID s
 		Creator:     creator,
 		Ctime:       ts,
 		Mtime:       ts,
+		Expiration:  parseExpiration(s.Expiration),
 	}, nil
 }
 
+// parseExpiration parses the expiration column, the format depends on the database driver
+func parseExpiration(expiration string) *typespb.Timestamp {
+	if expiration == "" {
+		return nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04:05-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, expiration); err == nil {
+			return &typespb.Timestamp{Seconds: uint64(t.Unix())}
+		}
+	}
+	return nil
+}
+
 func (m *mgr) convertToCS3ReceivedShare(ctx context.Context, s DBShare, storageMountID string) (*collaboration.ReceivedShare, error) {
 	share, err := m.convertToCS3Share(ctx, s, storageMountID)
 	if err != nil {
@@ -32,9 +32,13 @@ import (
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
 	"github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	"github.com/opencloud-eu/reva/v2/pkg/share/manager/registry"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/pkg/errors"
@@ -54,13 +58,15 @@ func init() {
 }
 
 type config struct {
-	GatewayAddr    string `mapstructure:"gateway_addr"`
-	StorageMountID string `mapstructure:"storage_mount_id"`
-	DbUsername     string `mapstructure:"db_username"`
-	DbPassword     string `mapstructure:"db_password"`
-	DbHost         string `mapstructure:"db_host"`
-	DbPort         int    `mapstructure:"db_port"`
-	DbName         string `mapstructure:"db_name"`
+	GatewayAddr    string            `mapstructure:"gateway_addr"`
+	StorageMountID string            `mapstructure:"storage_mount_id"`
+	DbUsername     string            `mapstructure:"db_username"`
+	DbPassword     string            `mapstructure:"db_password"`
+	DbHost         string            `mapstructure:"db_host"`
+	DbPort         int               `mapstructure:"db_port"`
+	DbName         string            `mapstructure:"db_name"`
+	Events         stream.NatsConfig `mapstructure:"events"`
+	Expiry         expiry.Config     `mapstructure:",squash"`
 }
 
 type mgr struct {
@@ -68,6 +74,9 @@ type mgr struct {
 	db             *sql.DB
 	storageMountID string
 	userConverter  UserConverter
+	eventStream    events.Stream
+
+	sweeper *expiry.Sweeper
 }
 
 // NewMysql returns a new share manager connection to a mysql database
@@ -85,17 +94,42 @@ func NewMysql(m map[string]interface{}) (share.Manager, error) {
 
 	userConverter := NewGatewayUserConverter(c.GatewayAddr)
 
-	return New("mysql", db, c.StorageMountID, userConverter)
+	sm := newManager("mysql", db, c.StorageMountID, userConverter)
+	if c.Events.Endpoint != "" {
+		sm.eventStream, err = stream.NatsFromConfig("owncloudsql-share-manager", false, c.Events)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sweeper, err := expiry.New("owncloudsql-shares", c.Expiry, sm.SweepExpiredShares)
+	if err != nil {
+		return nil, err
+	}
+	sweeper.Start()
+	sm.sweeper = sweeper
+	return sm, nil
+}
+
+// Close stops the expiry sweeper
+func (m *mgr) Close() error {
+	if m.sweeper != nil {
+		m.sweeper.Stop()
+	}
+	return nil
 }
 
 // New returns a new Cache instance connecting to the given sql.DB
 func New(driver string, db *sql.DB, storageMountID string, userConverter UserConverter) (share.Manager, error) {
+	return newManager(driver, db, storageMountID, userConverter), nil
+}
+
+func newManager(driver string, db *sql.DB, storageMountID string, userConverter UserConverter) *mgr {
 	return &mgr{
 		driver:         driver,
 		db:             db,
 		storageMountID: storageMountID,
 		userConverter:  userConverter,
-	}, nil
+	}
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -153,8 +187,15 @@ func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collabora
 		fileSource = 0
 	}
 
-	stmtString := "INSERT INTO oc_share (share_type,uid_owner,uid_initiator,item_type,item_source,file_source,permissions,stime,share_with,file_target) VALUES (?,?,?,?,?,?,?,?,?,?)"
+	columns := "share_type,uid_owner,uid_initiator,item_type,item_source,file_source,permissions,stime,share_with,file_target"
+	placeholders := "?,?,?,?,?,?,?,?,?,?"
 	stmtValues := []interface{}{shareType, owner, user.Username, itemType, itemSource, fileSource, permissions, now, shareWith, targetPath}
+	if g.Expiration != nil && g.Expiration.Seconds != 0 {
+		columns += ",expiration"
+		placeholders += ",?"
+		stmtValues = append(stmtValues, time.Unix(int64(g.Expiration.Seconds), 0))
+	}
+	stmtString := "INSERT INTO oc_share (" + columns + ") VALUES (" + placeholders + ")"
 
 	stmt, err := m.db.Prepare(stmtString)
 	if err != nil {
@@ -180,6 +221,7 @@ func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collabora
 		Creator:     user.Id,
 		Ctime:       ts,
 		Mtime:       ts,
+		Expiration:  g.Expiration,
 	}, nil
 }
 
@@ -672,3 +714,92 @@ func translateFilters(filters []*collaboration.Filter) (string, []interface{}, e
 	}
 	return filterQuery, params, nil
 }
+
+// SweepExpiredShares removes the shares that expired and publishes reminders for the shares that are about to expire
+func (m *mgr) SweepExpiredShares(ctx context.Context, w expiry.Window) error {
+	log := appctx.GetLogger(ctx)
+
+	query := `
+		SELECT
+			coalesce(s.uid_owner, '') as uid_owner, coalesce(s.uid_initiator, '') as uid_initiator,
+			coalesce(s.share_with, '') as share_with, coalesce(s.file_source, '') as file_source,
+			s.file_target, s.id, s.stime, s.permissions, s.share_type, s.expiration, coalesce(fc.storage, '') as storage
+		FROM oc_share s
+		LEFT JOIN oc_filecache fc ON fc.fileid = file_source
+		WHERE (share_type=? OR share_type=?) AND expiration IS NOT NULL
+	`
+	rows, err := m.db.QueryContext(ctx, query, shareTypeUser, shareTypeGroup)
+	if err != nil {
+		return err
+	}
+	var failed int
+	var expired, expiring []*collaboration.Share
+	for rows.Next() {
+		var s DBShare
+		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.FileSource, &s.FileTarget, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.Expiration, &s.ItemStorage); err != nil {
+			log.Error().Err(err).Msg("error scanning share")
+			failed++
+			continue
+		}
+		share, err := m.convertToCS3Share(ctx, s, m.storageMountID)
+		if err != nil {
+			log.Error().Err(err).Str("share", s.ID).Msg("error converting share")
+			failed++
+			continue
+		}
+		if share.Expiration == nil {
+			continue
+		}
+		expiration := utils.TSToTime(share.Expiration)
+		switch {
+		case w.Expired(expiration):
+			expired = append(expired, share)
+		case m.eventStream != nil && w.Remind(share.GetId().GetOpaqueId(), expiration):
+			expiring = append(expiring, share)
+		}
+	}
+	rows.Close()
+
+	for _, s := range expired {
+		if _, err := m.db.ExecContext(ctx, "DELETE FROM oc_share WHERE id=?", s.Id.OpaqueId); err != nil {
+			log.Error().Err(err).Str("share", s.Id.OpaqueId).Msg("failed to remove expired share")
+			failed++
+			continue
+		}
+		if m.eventStream == nil {
+			continue
+		}
+		if err := events.Publish(ctx, m.eventStream, events.ShareExpired{
+			ShareID:        s.GetId(),
+			ShareOwner:     s.GetOwner(),
+			ItemID:         s.GetResourceId(),
+			ExpiredAt:      utils.TSToTime(s.GetExpiration()),
+			GranteeUserID:  s.GetGrantee().GetUserId(),
+			GranteeGroupID: s.GetGrantee().GetGroupId(),
+		}); err != nil {
+			log.Error().Err(err).Str("share", s.Id.OpaqueId).Msg("failed to publish share expired event")
+		}
+	}
+	for _, s := range expiring {
+		if err := events.Publish(ctx, m.eventStream, events.ShareExpiringSoon{
+			ShareID:        s.GetId(),
+			ShareOwner:     s.GetOwner(),
+			ItemID:         s.GetResourceId(),
+			ExpiresAt:      utils.TSToTime(s.GetExpiration()),
+			GranteeUserID:  s.GetGrantee().GetUserId(),
+			GranteeGroupID: s.GetGrantee().GetGroupId(),
+		}); err != nil {
+			log.Error().Err(err).Str("share", s.Id.OpaqueId).Msg("failed to publish share expiring soon event")
+			failed++
+			continue
+		}
+		if err := w.Reminded(s.GetId().GetOpaqueId(), utils.TSToTime(s.GetExpiration())); err != nil {
+			log.Error().Err(err).Str("share", s.Id.OpaqueId).Msg("failed to record the reminder")
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d expiring shares could not be handled", failed)
+	}
+	return nil
+}
@@ -23,13 +23,17 @@ import (
 	"database/sql"
 	"os"
 	"strconv"
+	"time"
 
 	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/conversions"
 	ruser "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
 	sqlmanager "github.com/opencloud-eu/reva/v2/pkg/share/manager/owncloudsql"
 	mocks "github.com/opencloud-eu/reva/v2/pkg/share/manager/owncloudsql/mocks"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
@@ -526,4 +530,64 @@ var _ = Describe("SQL manager", func() {
 			Expect(share.Permissions.Permissions.Delete).To(BeFalse())
 		})
 	})
+
+	Describe("SweepExpiredShares", func() {
+		var (
+			sweeper interface {
+				SweepExpiredShares(context.Context, expiry.Window) error
+			}
+			shareWithExpiration = func(expiration time.Time) *collaboration.Share {
+				grant := &collaboration.ShareGrant{
+					Grantee: &provider.Grantee{
+						Type: provider.GranteeType_GRANTEE_TYPE_USER,
+						Id: &provider.Grantee_UserId{UserId: &user.UserId{
+							OpaqueId: "someone",
+						}},
+					},
+					Permissions: &collaboration.SharePermissions{
+						Permissions: conversions.NewViewerRole().CS3ResourcePermissions(),
+					},
+					Expiration: &types.Timestamp{Seconds: uint64(expiration.Unix())},
+				}
+				info := &provider.ResourceInfo{
+					Id: &provider.ResourceId{
+						SpaceId:  "/",
+						OpaqueId: "something",
+					},
+				}
+				share, err := mgr.Share(ctx, info, grant)
+				Expect(err).ToNot(HaveOccurred())
+				return share
+			}
+		)
+
+		BeforeEach(func() {
+			var ok bool
+			sweeper, ok = mgr.(interface {
+				SweepExpiredShares(context.Context, expiry.Window) error
+			})
+			Expect(ok).To(BeTrue())
+		})
+
+		It("removes expired shares and keeps the others", func() {
+			now := time.Now()
+			expired := shareWithExpiration(now.Add(-time.Minute))
+			expiring := shareWithExpiration(now.Add(time.Minute))
+
+			s, err := expiry.New("test", expiry.Config{Interval: 60, RemindBefore: 3600, Store: "memory"}, sweeper.SweepExpiredShares)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Sweep(ctx, now)).To(Succeed())
+
+			_, err = mgr.GetShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: expired.Id}})
+			Expect(err).To(HaveOccurred())
+
+			share, err := mgr.GetShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: expiring.Id}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(share).ToNot(BeNil())
+
+			share, err = mgr.GetShare(ctx, shareRef)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(share).ToNot(BeNil())
+		})
+	})
 })
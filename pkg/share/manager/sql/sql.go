@@ -0,0 +1,915 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpcv1beta1 "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/google/uuid"
+	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/events/stream"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/share/expiry"
+	"github.com/opencloud-eu/reva/v2/pkg/share/manager/jsoncs3/shareid"
+	"github.com/opencloud-eu/reva/v2/pkg/share/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// This module implements the share.Manager interface on top of a sqlite or postgres database.
+//
+// Shares are saved in the table
+//     shares(*id*, storage_id, space_id, opaque_id, owner, creator, grantee_type, grantee, expiration, data)
+// data holds the json encoded share, the other columns are extracted from it so they can be indexed.
+// Users and groups are referenced by their opaque id, expiration is a unix timestamp.
+//
+// The state of received shares is saved per user in the table
+//     received_shares(*share_id*, *user_id*, state, hidden, mount_point)
+// Shares without a row in received_shares are pending.
+//
+// Share ids are prefixed with the storage and space id like in the jsoncs3 manager, so shares can be
+// moved between both managers with the share dump and load.
+
+func init() {
+	registry.Register("sql", NewDefault)
+}
+
+var migrations = []sqldb.Migration{
+	{
+		`CREATE TABLE shares (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			storage_id VARCHAR(255) NOT NULL,
+			space_id VARCHAR(255) NOT NULL,
+			opaque_id VARCHAR(255) NOT NULL,
+			owner VARCHAR(255) NOT NULL,
+			creator VARCHAR(255) NOT NULL,
+			grantee_type INTEGER NOT NULL,
+			grantee VARCHAR(255) NOT NULL,
+			expiration BIGINT,
+			data TEXT NOT NULL
+		)`,
+		"CREATE UNIQUE INDEX shares_resource_grantee ON shares (storage_id, space_id, opaque_id, grantee_type, grantee)",
+		"CREATE INDEX shares_owner ON shares (owner)",
+		"CREATE INDEX shares_creator ON shares (creator)",
+		"CREATE INDEX shares_grantee ON shares (grantee, grantee_type)",
+		"CREATE INDEX shares_expiration ON shares (expiration)",
+		`CREATE TABLE received_shares (
+			share_id VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255) NOT NULL,
+			state INTEGER NOT NULL,
+			hidden BOOLEAN NOT NULL DEFAULT FALSE,
+			mount_point TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (share_id, user_id)
+		)`,
+		"CREATE INDEX received_shares_user ON received_shares (user_id)",
+	},
+}
+
+type config struct {
+	DB          sqldb.Config      `mapstructure:",squash"`
+	GatewayAddr string            `mapstructure:"gateway_addr"`
+	Events      stream.NatsConfig `mapstructure:"events"`
+	Expiry      expiry.Config     `mapstructure:",squash"`
+}
+
+// Manager implements a share manager using a sql database
+type Manager struct {
+	db              *sql.DB
+	gatewaySelector pool.Selectable[gatewayv1beta1.GatewayAPIClient]
+	eventStream     events.Stream
+
+	sweeper *expiry.Sweeper
+}
+
+// NewDefault returns a new manager instance with default dependencies
+func NewDefault(m map[string]interface{}) (share.Manager, error) {
+	c := &config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		err = errors.Wrap(err, "error creating a new manager")
+		return nil, err
+	}
+
+	db, err := sqldb.Open(c.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	gatewaySelector, err := pool.GatewaySelector(c.GatewayAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var es events.Stream
+	if c.Events.Endpoint != "" {
+		es, err = stream.NatsFromConfig("sql-share-manager", false, c.Events)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mgr, err := New(db, gatewaySelector, es)
+	if err != nil {
+		return nil, err
+	}
+	sweeper, err := expiry.New("sql-shares", c.Expiry, mgr.SweepExpiredShares)
+	if err != nil {
+		return nil, err
+	}
+	sweeper.Start()
+	mgr.sweeper = sweeper
+	return mgr, nil
+}
+
+// Close stops the expiry sweeper
+func (m *Manager) Close() error {
+	if m.sweeper != nil {
+		m.sweeper.Stop()
+	}
+	return nil
+}
+
+// New returns a new manager instance using the given database. The schema is migrated on startup.
+func New(db *sql.DB, gatewaySelector pool.Selectable[gatewayv1beta1.GatewayAPIClient], es events.Stream) (*Manager, error) {
+	if err := sqldb.Migrate(context.Background(), db, "shares", migrations); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		db:              db,
+		gatewaySelector: gatewaySelector,
+		eventStream:     es,
+	}, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+const shareColumns = "id, storage_id, space_id, opaque_id, owner, creator, grantee_type, grantee, expiration, data"
+
+func granteeID(g *provider.Grantee) string {
+	switch g.GetType() {
+	case provider.GranteeType_GRANTEE_TYPE_USER:
+		return g.GetUserId().GetOpaqueId()
+	case provider.GranteeType_GRANTEE_TYPE_GROUP:
+		return g.GetGroupId().GetOpaqueId()
+	default:
+		return ""
+	}
+}
+
+func expirationValue(s *collaboration.Share) sql.NullInt64 {
+	if e := s.GetExpiration(); e != nil {
+		return sql.NullInt64{Int64: int64(e.GetSeconds()), Valid: true}
+	}
+	return sql.NullInt64{}
+}
+
+func shareValues(s *collaboration.Share) ([]interface{}, error) {
+	data, err := utils.MarshalProtoV1ToJSON(s)
+	if err != nil {
+		return nil, err
+	}
+	rid := s.GetResourceId()
+	return []interface{}{
+		s.GetId().GetOpaqueId(), rid.GetStorageId(), rid.GetSpaceId(), rid.GetOpaqueId(),
+		s.GetOwner().GetOpaqueId(), s.GetCreator().GetOpaqueId(),
+		int(s.GetGrantee().GetType()), granteeID(s.GetGrantee()),
+		expirationValue(s), string(data),
+	}, nil
+}
+
+func insertShare(ctx context.Context, db execer, s *collaboration.Share) error {
+	values, err := shareValues(s)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO shares ("+shareColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", values...)
+	return err
+}
+
+func upsertShare(ctx context.Context, db execer, s *collaboration.Share) error {
+	values, err := shareValues(s)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO shares ("+shareColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"+
+		" ON CONFLICT (id) DO UPDATE SET storage_id=excluded.storage_id, space_id=excluded.space_id, opaque_id=excluded.opaque_id,"+
+		" owner=excluded.owner, creator=excluded.creator, grantee_type=excluded.grantee_type, grantee=excluded.grantee,"+
+		" expiration=excluded.expiration, data=excluded.data", values...)
+	return err
+}
+
+func upsertReceivedShare(ctx context.Context, db execer, userID string, rs *collaboration.ReceivedShare) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO received_shares (share_id, user_id, state, hidden, mount_point) VALUES ($1, $2, $3, $4, $5)"+
+		" ON CONFLICT (share_id, user_id) DO UPDATE SET state=excluded.state, hidden=excluded.hidden, mount_point=excluded.mount_point",
+		rs.GetShare().GetId().GetOpaqueId(), userID, int(rs.GetState()), rs.GetHidden(), rs.GetMountPoint().GetPath())
+	return err
+}
+
+func decodeShare(data string) (*collaboration.Share, error) {
+	s := &collaboration.Share{}
+	if err := utils.UnmarshalJSONToProtoV1([]byte(data), s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// queryShares returns the shares selected by the where clause. The rows are read completely before
+// returning, so the connection can be used for further queries.
+func (m *Manager) queryShares(ctx context.Context, where string, args ...interface{}) ([]*collaboration.Share, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT data FROM shares WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []*collaboration.Share
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		s, err := decodeShare(data)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+func (m *Manager) getByID(ctx context.Context, id *collaboration.ShareId) (*collaboration.Share, error) {
+	shares, err := m.queryShares(ctx, "id = $1", id.GetOpaqueId())
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, errtypes.NotFound(id.String())
+	}
+	return shares[0], nil
+}
+
+func (m *Manager) getByKey(ctx context.Context, key *collaboration.ShareKey) (*collaboration.Share, error) {
+	rid := key.GetResourceId()
+	shares, err := m.queryShares(ctx, "storage_id = $1 AND space_id = $2 AND opaque_id = $3 AND grantee_type = $4 AND grantee = $5",
+		rid.GetStorageId(), rid.GetSpaceId(), rid.GetOpaqueId(), int(key.GetGrantee().GetType()), granteeID(key.GetGrantee()))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range shares {
+		if utils.GranteeEqual(key.GetGrantee(), s.GetGrantee()) {
+			return s, nil
+		}
+	}
+	return nil, errtypes.NotFound(key.String())
+}
+
+func (m *Manager) get(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.Share, error) {
+	switch {
+	case ref.GetId() != nil:
+		return m.getByID(ctx, ref.GetId())
+	case ref.GetKey() != nil:
+		return m.getByKey(ctx, ref.GetKey())
+	default:
+		return nil, errtypes.NotFound(ref.String())
+	}
+}
+
+func (m *Manager) removeShare(ctx context.Context, s *collaboration.Share) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM received_shares WHERE share_id = $1", s.GetId().GetOpaqueId()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM shares WHERE id = $1", s.GetId().GetOpaqueId()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeExpiredShare removes a share that was found to be expired while reading it
+func (m *Manager) removeExpiredShare(ctx context.Context, s *collaboration.Share) error {
+	log := appctx.GetLogger(ctx)
+	if err := m.removeShare(ctx, s); err != nil {
+		log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to unshare expired share")
+		return err
+	}
+	if m.eventStream == nil {
+		return nil
+	}
+	if err := events.Publish(ctx, m.eventStream, events.ShareExpired{
+		ShareID:        s.GetId(),
+		ShareOwner:     s.GetOwner(),
+		ItemID:         s.GetResourceId(),
+		ExpiredAt:      utils.TSToTime(s.GetExpiration()),
+		GranteeUserID:  s.GetGrantee().GetUserId(),
+		GranteeGroupID: s.GetGrantee().GetGroupId(),
+	}); err != nil {
+		log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to publish share expired event")
+	}
+	return nil
+}
+
+// canAccessGrants checks if the user in the context can list (or update) the grants of the resource
+func (m *Manager) canAccessGrants(ctx context.Context, id *provider.ResourceId, update bool) bool {
+	client, err := m.gatewaySelector.Next()
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("failed to select next gateway client")
+		return false
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{
+		Ref: &provider.Reference{ResourceId: id},
+		FieldMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"permissions"},
+		},
+	})
+	if err != nil || res.GetStatus().GetCode() != rpcv1beta1.Code_CODE_OK {
+		return false
+	}
+	if update {
+		return res.GetInfo().GetPermissionSet().GetUpdateGrant()
+	}
+	return res.GetInfo().GetPermissionSet().GetListGrants()
+}
+
+// Share creates a share
+func (m *Manager) Share(ctx context.Context, md *provider.ResourceInfo, g *collaboration.ShareGrant) (*collaboration.Share, error) {
+	user := ctxpkg.ContextMustGetUser(ctx)
+	ts := utils.TSNow()
+
+	// do not allow share to myself or the owner if share is for a user
+	if g.GetGrantee().GetType() == provider.GranteeType_GRANTEE_TYPE_USER &&
+		(utils.UserEqual(g.GetGrantee().GetUserId(), user.GetId()) || utils.UserEqual(g.GetGrantee().GetUserId(), md.GetOwner())) {
+		return nil, errtypes.BadRequest("sql: owner/creator and grantee are the same")
+	}
+
+	s := &collaboration.Share{
+		Id: &collaboration.ShareId{
+			OpaqueId: shareid.Encode(md.GetId().GetStorageId(), md.GetId().GetSpaceId(), uuid.NewString()),
+		},
+		ResourceId:  md.GetId(),
+		Permissions: g.GetPermissions(),
+		Grantee:     g.GetGrantee(),
+		Expiration:  g.GetExpiration(),
+		Owner:       md.GetOwner(),
+		Creator:     user.GetId(),
+		Ctime:       ts,
+		Mtime:       ts,
+	}
+
+	if err := insertShare(ctx, m.db, s); err != nil {
+		if sqldb.IsUniqueViolation(err) {
+			key := &collaboration.ShareKey{ResourceId: md.GetId(), Grantee: g.GetGrantee()}
+			return nil, errtypes.AlreadyExists(key.String())
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetShare gets the information for a share by the given ref.
+func (m *Manager) GetShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.Share, error) {
+	s, err := m.get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if share.IsExpired(s) {
+		m.removeExpiredShare(ctx, s)
+		return nil, errtypes.NotFound(ref.String())
+	}
+
+	// check if we are the creator or the grantee
+	user := ctxpkg.ContextMustGetUser(ctx)
+	if share.IsCreatedByUser(s, user) || share.IsGrantedToUser(s, user) || m.canAccessGrants(ctx, s.GetResourceId(), false) {
+		return s, nil
+	}
+
+	// we return not found to not disclose information
+	return nil, errtypes.NotFound(ref.String())
+}
+
+// Unshare deletes a share
+func (m *Manager) Unshare(ctx context.Context, ref *collaboration.ShareReference) error {
+	s, err := m.get(ctx, ref)
+	if err != nil {
+		return err
+	}
+	user := ctxpkg.ContextMustGetUser(ctx)
+	if !share.IsCreatedByUser(s, user) {
+		return errtypes.NotFound(ref.String())
+	}
+	return m.removeShare(ctx, s)
+}
+
+// UpdateShare updates the mode of the given share.
+func (m *Manager) UpdateShare(ctx context.Context, ref *collaboration.ShareReference, p *collaboration.SharePermissions, updated *collaboration.Share, fieldMask *field_mask.FieldMask) (*collaboration.Share, error) {
+	var (
+		toUpdate *collaboration.Share
+		err      error
+	)
+	switch {
+	case ref != nil:
+		toUpdate, err = m.get(ctx, ref)
+	case updated != nil:
+		toUpdate, err = m.getByID(ctx, updated.GetId())
+	default:
+		err = errtypes.BadRequest("sql: missing share reference")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range fieldMask.GetPaths() {
+		switch path {
+		case "permissions":
+			toUpdate.Permissions = updated.GetPermissions()
+		case "expiration":
+			toUpdate.Expiration = updated.GetExpiration()
+		default:
+			return nil, errtypes.NotSupported("updating " + path + " is not supported")
+		}
+	}
+
+	user := ctxpkg.ContextMustGetUser(ctx)
+	if !share.IsCreatedByUser(toUpdate, user) && !m.canAccessGrants(ctx, toUpdate.GetResourceId(), true) {
+		return nil, errtypes.NotFound(toUpdate.GetId().GetOpaqueId())
+	}
+
+	if p != nil {
+		toUpdate.Permissions = p
+	}
+	toUpdate.Mtime = utils.TSNow()
+
+	data, err := utils.MarshalProtoV1ToJSON(toUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE shares SET expiration = $1, data = $2 WHERE id = $3", expirationValue(toUpdate), string(data), toUpdate.GetId().GetOpaqueId()); err != nil {
+		return nil, err
+	}
+	return toUpdate, nil
+}
+
+// filterConditions translates the filters into sql conditions on the shares table, which is
+// aliased as s. Filters of the same type are ORed, filters of different types are ANDed.
+// Filters that can not be expressed are ignored, the results have to be checked with the
+// share filter functions.
+func filterConditions(args *sqldb.Args, filters []*collaboration.Filter) []string {
+	var conditions []string
+	grouped := share.GroupFiltersByType(filters)
+
+	if fs := grouped[collaboration.Filter_TYPE_RESOURCE_ID]; len(fs) > 0 {
+		or := make([]string, 0, len(fs))
+		for _, f := range fs {
+			rid := f.GetResourceId()
+			or = append(or, "(s.storage_id = "+args.Add(rid.GetStorageId())+" AND s.space_id = "+args.Add(rid.GetSpaceId())+" AND s.opaque_id = "+args.Add(rid.GetOpaqueId())+")")
+		}
+		conditions = append(conditions, "("+strings.Join(or, " OR ")+")")
+	}
+	if fs := grouped[collaboration.Filter_TYPE_SPACE_ID]; len(fs) > 0 {
+		ids := make([]string, 0, len(fs))
+		for _, f := range fs {
+			ids = append(ids, f.GetSpaceId())
+		}
+		conditions = append(conditions, "s.space_id IN ("+args.In(ids...)+")")
+	}
+	if fs := grouped[collaboration.Filter_TYPE_GRANTEE_TYPE]; len(fs) > 0 {
+		types := make([]string, 0, len(fs))
+		for _, f := range fs {
+			types = append(types, args.Add(int(f.GetGranteeType())))
+		}
+		conditions = append(conditions, "s.grantee_type IN ("+strings.Join(types, ", ")+")")
+	}
+	return conditions
+}
+
+// ListShares returns the shares created by the user. If resource id filters are given the shares
+// of these resources are returned if the user is allowed to list their grants.
+func (m *Manager) ListShares(ctx context.Context, filters []*collaboration.Filter) ([]*collaboration.Share, error) {
+	user := ctxpkg.ContextMustGetUser(ctx)
+	byResource := len(share.FilterFiltersByType(filters, collaboration.Filter_TYPE_RESOURCE_ID)) > 0
+
+	var args sqldb.Args
+	conditions := filterConditions(&args, filters)
+	if !byResource {
+		conditions = append(conditions, "s.creator = "+args.Add(user.GetId().GetOpaqueId()))
+	}
+	rows, err := m.db.QueryContext(ctx, "SELECT s.data FROM shares s WHERE "+strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		return nil, err
+	}
+	var shares []*collaboration.Share
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s, err := decodeShare(data)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ss := []*collaboration.Share{}
+	allowed := make(map[string]bool)
+	for _, s := range shares {
+		if share.IsExpired(s) {
+			m.removeExpiredShare(ctx, s)
+			continue
+		}
+		if !share.MatchesFilters(s, filters) {
+			continue
+		}
+		if !byResource {
+			if utils.UserEqual(user.GetId(), s.GetCreator()) {
+				ss = append(ss, s)
+			}
+			continue
+		}
+		if !share.IsCreatedByUser(s, user) && !share.IsGrantedToUser(s, user) {
+			key := storagespace.FormatResourceID(s.GetResourceId())
+			if _, ok := allowed[key]; !ok {
+				allowed[key] = m.canAccessGrants(ctx, s.GetResourceId(), false)
+			}
+			if !allowed[key] {
+				continue
+			}
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// ListReceivedShares returns the list of shares the user has access to.
+func (m *Manager) ListReceivedShares(ctx context.Context, filters []*collaboration.Filter, forUser *userpb.UserId) ([]*collaboration.ReceivedShare, error) {
+	user := ctxpkg.ContextMustGetUser(ctx)
+	if user.GetId().GetType() == userpb.UserType_USER_TYPE_SERVICE {
+		client, err := m.gatewaySelector.Next()
+		if err != nil {
+			return nil, err
+		}
+		u, err := utils.GetUserWithContext(ctx, forUser, client)
+		if err != nil {
+			return nil, errtypes.BadRequest("user not found")
+		}
+		user = u
+	}
+
+	var args sqldb.Args
+	userID := args.Add(user.GetId().GetOpaqueId())
+	grantee := "(s.grantee_type = " + args.Add(int(provider.GranteeType_GRANTEE_TYPE_USER)) + " AND s.grantee = " + userID + ")"
+	if len(user.GetGroups()) > 0 {
+		grantee = "(" + grantee + " OR (s.grantee_type = " + args.Add(int(provider.GranteeType_GRANTEE_TYPE_GROUP)) + " AND s.grantee IN (" + args.In(user.GetGroups()...) + ")))"
+	}
+	conditions := append([]string{grantee}, filterConditions(&args, filters)...)
+	if fs := share.FilterFiltersByType(filters, collaboration.Filter_TYPE_STATE); len(fs) > 0 {
+		state := "COALESCE(r.state, " + args.Add(int(collaboration.ShareState_SHARE_STATE_PENDING)) + ")"
+		states := make([]string, 0, len(fs))
+		for _, f := range fs {
+			states = append(states, args.Add(int(f.GetState())))
+		}
+		conditions = append(conditions, state+" IN ("+strings.Join(states, ", ")+")")
+	}
+
+	query := "SELECT s.data, r.state, r.hidden, r.mount_point FROM shares s" +
+		" LEFT JOIN received_shares r ON r.share_id = s.id AND r.user_id = " + userID +
+		" WHERE " + strings.Join(conditions, " AND ")
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var received []*collaboration.ReceivedShare
+	for rows.Next() {
+		rs, err := scanReceivedShare(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		received = append(received, rs)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rss := []*collaboration.ReceivedShare{}
+	for _, rs := range received {
+		if share.IsExpired(rs.GetShare()) {
+			m.removeExpiredShare(ctx, rs.GetShare())
+			continue
+		}
+		if share.IsGrantedToUser(rs.GetShare(), user) && share.MatchesFiltersWithState(rs.GetShare(), rs.GetState(), filters) {
+			rss = append(rss, rs)
+		}
+	}
+	return rss, nil
+}
+
+func scanReceivedShare(rows *sql.Rows) (*collaboration.ReceivedShare, error) {
+	var (
+		data       string
+		state      sql.NullInt64
+		hidden     sql.NullBool
+		mountPoint sql.NullString
+	)
+	if err := rows.Scan(&data, &state, &hidden, &mountPoint); err != nil {
+		return nil, err
+	}
+	s, err := decodeShare(data)
+	if err != nil {
+		return nil, err
+	}
+	rs := &collaboration.ReceivedShare{
+		Share:  s,
+		State:  collaboration.ShareState_SHARE_STATE_PENDING,
+		Hidden: hidden.Bool,
+	}
+	if state.Valid {
+		rs.State = collaboration.ShareState(state.Int64)
+	}
+	if mountPoint.String != "" {
+		rs.MountPoint = &provider.Reference{Path: mountPoint.String}
+	}
+	return rs, nil
+}
+
+// GetReceivedShare returns the information for a received share.
+func (m *Manager) GetReceivedShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.ReceivedShare, error) {
+	s, err := m.get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	user := ctxpkg.ContextMustGetUser(ctx)
+	if user.GetId().GetType() != userpb.UserType_USER_TYPE_SERVICE && !share.IsGrantedToUser(s, user) {
+		return nil, errtypes.NotFound(ref.String())
+	}
+	if share.IsExpired(s) {
+		m.removeExpiredShare(ctx, s)
+		return nil, errtypes.NotFound(ref.String())
+	}
+	return m.convert(ctx, user.GetId().GetOpaqueId(), s)
+}
+
+// convert adds the state of the user to the share
+func (m *Manager) convert(ctx context.Context, userID string, s *collaboration.Share) (*collaboration.ReceivedShare, error) {
+	rs := &collaboration.ReceivedShare{
+		Share: s,
+		State: collaboration.ShareState_SHARE_STATE_PENDING,
+	}
+	var (
+		state      int
+		mountPoint string
+	)
+	err := m.db.QueryRowContext(ctx, "SELECT state, hidden, mount_point FROM received_shares WHERE share_id = $1 AND user_id = $2", s.GetId().GetOpaqueId(), userID).
+		Scan(&state, &rs.Hidden, &mountPoint)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return rs, nil
+	case err != nil:
+		return nil, err
+	}
+	rs.State = collaboration.ShareState(state)
+	if mountPoint != "" {
+		rs.MountPoint = &provider.Reference{Path: mountPoint}
+	}
+	return rs, nil
+}
+
+// UpdateReceivedShare updates the received share with share state.
+func (m *Manager) UpdateReceivedShare(ctx context.Context, receivedShare *collaboration.ReceivedShare, fieldMask *field_mask.FieldMask, forUser *userpb.UserId) (*collaboration.ReceivedShare, error) {
+	rs, err := m.GetReceivedShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: receivedShare.GetShare().GetId()}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range fieldMask.GetPaths() {
+		switch path {
+		case "state":
+			rs.State = receivedShare.GetState()
+		case "mount_point":
+			rs.MountPoint = receivedShare.GetMountPoint()
+		case "hidden":
+			rs.Hidden = receivedShare.GetHidden()
+		default:
+			return nil, errtypes.NotSupported("updating " + path + " is not supported")
+		}
+	}
+
+	u := ctxpkg.ContextMustGetUser(ctx)
+	uid := u.GetId().GetOpaqueId()
+	if u.GetId().GetType() == userpb.UserType_USER_TYPE_SERVICE {
+		uid = forUser.GetOpaqueId()
+	}
+	if err := upsertReceivedShare(ctx, m.db, uid, rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Dump exports shares and received shares to channels (e.g. during migration)
+func (m *Manager) Dump(ctx context.Context, shareChan chan<- *collaboration.Share, receivedShareChan chan<- share.ReceivedShareWithUser) error {
+	shares, err := m.queryShares(ctx, "1 = 1")
+	if err != nil {
+		return err
+	}
+	for _, s := range shares {
+		shareChan <- s
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT r.user_id, s.data, r.state, r.hidden, r.mount_point FROM received_shares r JOIN shares s ON s.id = r.share_id")
+	if err != nil {
+		return err
+	}
+	var received []share.ReceivedShareWithUser
+	for rows.Next() {
+		var (
+			userID     string
+			data       string
+			state      int
+			hidden     bool
+			mountPoint string
+		)
+		if err := rows.Scan(&userID, &data, &state, &hidden, &mountPoint); err != nil {
+			rows.Close()
+			return err
+		}
+		s, err := decodeShare(data)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rs := &collaboration.ReceivedShare{
+			Share:  s,
+			State:  collaboration.ShareState(state),
+			Hidden: hidden,
+		}
+		if mountPoint != "" {
+			rs.MountPoint = &provider.Reference{Path: mountPoint}
+		}
+		uid := &userpb.UserId{OpaqueId: userID}
+		if g := s.GetGrantee().GetUserId(); g.GetOpaqueId() == userID {
+			uid = g
+		}
+		received = append(received, share.ReceivedShareWithUser{UserID: uid, ReceivedShare: rs})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, rs := range received {
+		receivedShareChan <- rs
+	}
+	return nil
+}
+
+// Load imports shares and received shares from channels (e.g. during migration). Existing shares
+// and states are overwritten, so loading the same dump twice is safe.
+func (m *Manager) Load(ctx context.Context, shareChan <-chan *collaboration.Share, receivedShareChan <-chan share.ReceivedShareWithUser) error {
+	log := appctx.GetLogger(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for s := range shareChan {
+			if s == nil {
+				continue
+			}
+			if !shareIsRoutable(s) {
+				updateShareID(s)
+			}
+			if err := upsertShare(ctx, m.db, s); err != nil {
+				log.Error().Err(err).Interface("share", s).Msg("error persisting share")
+				continue
+			}
+			log.Debug().Str("shareid", s.GetId().GetOpaqueId()).Msg("imported share")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for s := range receivedShareChan {
+			rs := s.ReceivedShare
+			if rs.GetShare() == nil {
+				continue
+			}
+			if !shareIsRoutable(rs.GetShare()) {
+				updateShareID(rs.GetShare())
+			}
+			userID := s.UserID.GetOpaqueId()
+			if userID == "" {
+				userID = rs.GetShare().GetGrantee().GetUserId().GetOpaqueId()
+			}
+			if userID == "" {
+				log.Error().Interface("received share", s).Msg("received share without user")
+				continue
+			}
+			if err := upsertReceivedShare(ctx, m.db, userID, rs); err != nil {
+				log.Error().Err(err).Interface("received share", s).Msg("error persisting received share")
+				continue
+			}
+			log.Debug().Str("userid", userID).Str("shareid", rs.GetShare().GetId().GetOpaqueId()).Msg("imported received share")
+		}
+	}()
+	wg.Wait()
+
+	return nil
+}
+
+func shareIsRoutable(s *collaboration.Share) bool {
+	return strings.Contains(s.GetId().GetOpaqueId(), shareid.IDDelimiter)
+}
+
+func updateShareID(s *collaboration.Share) {
+	s.Id.OpaqueId = shareid.Encode(s.GetResourceId().GetStorageId(), s.GetResourceId().GetSpaceId(), s.GetId().GetOpaqueId())
+}
+
+// SweepExpiredShares removes the shares that expired and publishes reminders for the shares that are about to expire
+func (m *Manager) SweepExpiredShares(ctx context.Context, w expiry.Window) error {
+	log := appctx.GetLogger(ctx)
+
+	shares, err := m.queryShares(ctx, "expiration IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	var expiring []*collaboration.Share
+	for _, s := range shares {
+		expiration := utils.TSToTime(s.GetExpiration())
+		switch {
+		case w.Expired(expiration):
+			if err := m.removeExpiredShare(ctx, s); err != nil {
+				failed++
+			}
+		case m.eventStream != nil && w.Remind(s.GetId().GetOpaqueId(), expiration):
+			expiring = append(expiring, s)
+		}
+	}
+
+	for _, s := range expiring {
+		if err := events.Publish(ctx, m.eventStream, events.ShareExpiringSoon{
+			ShareID:        s.GetId(),
+			ShareOwner:     s.GetOwner(),
+			ItemID:         s.GetResourceId(),
+			ExpiresAt:      utils.TSToTime(s.GetExpiration()),
+			GranteeUserID:  s.GetGrantee().GetUserId(),
+			GranteeGroupID: s.GetGrantee().GetGroupId(),
+		}); err != nil {
+			log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to publish share expiring soon event")
+			failed++
+			continue
+		}
+		if err := w.Reminded(s.GetId().GetOpaqueId(), utils.TSToTime(s.GetExpiration())); err != nil {
+			log.Error().Err(err).Str("share", s.GetId().GetOpaqueId()).Msg("failed to record the reminder")
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d expiring shares could not be handled", failed)
+	}
+	return nil
+}
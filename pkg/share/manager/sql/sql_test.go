@@ -0,0 +1,381 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql_test
+
+import (
+	"context"
+	"errors"
+
+	gatewayv1beta1 "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	groupv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/status"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	sharespkg "github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/share/manager/sql"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb/sqldbtest"
+	"github.com/opencloud-eu/reva/v2/tests/cs3mocks/mocks"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sql", func() {
+	for _, driver := range sqldbtest.Drivers {
+		Context(driver, func() { describeManager(driver) })
+	}
+})
+
+func describeManager(driver string) {
+	var (
+		owner = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "admin",
+			},
+		}
+		grantee = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "einstein",
+			},
+			Groups: []string{"physics"},
+		}
+		other = &userpb.User{
+			Id: &userpb.UserId{
+				Idp:      "https://localhost:9200",
+				OpaqueId: "marie",
+			},
+			Groups: []string{"physics"},
+		}
+
+		resource = &provider.ResourceInfo{
+			Id: &provider.ResourceId{
+				StorageId: "storageid",
+				SpaceId:   "spaceid",
+				OpaqueId:  "opaqueid",
+			},
+			Owner: owner.Id,
+		}
+		resource2 = &provider.ResourceInfo{
+			Id: &provider.ResourceId{
+				StorageId: "storageid",
+				SpaceId:   "spaceid2",
+				OpaqueId:  "opaqueid2",
+			},
+			Owner: owner.Id,
+		}
+
+		userGrant = &collaboration.ShareGrant{
+			Grantee: &provider.Grantee{
+				Type: provider.GranteeType_GRANTEE_TYPE_USER,
+				Id:   &provider.Grantee_UserId{UserId: grantee.Id},
+			},
+			Permissions: &collaboration.SharePermissions{
+				Permissions: &provider.ResourcePermissions{Stat: true},
+			},
+		}
+		groupGrant = &collaboration.ShareGrant{
+			Grantee: &provider.Grantee{
+				Type: provider.GranteeType_GRANTEE_TYPE_GROUP,
+				Id:   &provider.Grantee_GroupId{GroupId: &groupv1beta1.GroupId{OpaqueId: "physics"}},
+			},
+			Permissions: &collaboration.SharePermissions{
+				Permissions: &provider.ResourcePermissions{Stat: true},
+			},
+		}
+
+		ctx        = ctxpkg.ContextSetUser(context.Background(), owner)
+		granteeCtx = ctxpkg.ContextSetUser(context.Background(), grantee)
+		otherCtx   = ctxpkg.ContextSetUser(context.Background(), other)
+
+		client *mocks.GatewayAPIClient
+		m      *sql.Manager
+
+		newManager = func() *sql.Manager {
+			c, cleanup, err := sqldbtest.Config(driver, GinkgoT().TempDir())
+			if errors.Is(err, sqldbtest.ErrNotConfigured) {
+				Skip(err.Error())
+			}
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			DeferCleanup(cleanup)
+			db, err := sqldb.Open(c)
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			DeferCleanup(db.Close)
+			pool.RemoveSelector("GatewaySelector" + "eu.opencloud.api.gateway")
+			gatewaySelector := pool.GetSelector[gatewayv1beta1.GatewayAPIClient](
+				"GatewaySelector",
+				"eu.opencloud.api.gateway",
+				func(cc grpc.ClientConnInterface) gatewayv1beta1.GatewayAPIClient {
+					return client
+				},
+			)
+			mgr, err := sql.New(db, gatewaySelector, nil)
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			return mgr
+		}
+		byID = func(s *collaboration.Share) *collaboration.ShareReference {
+			return &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: s.Id}}
+		}
+	)
+
+	BeforeEach(func() {
+		client = &mocks.GatewayAPIClient{}
+		m = newManager()
+	})
+
+	Describe("Share", func() {
+		It("creates a share", func() {
+			s, err := m.Share(ctx, resource, userGrant)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Id.OpaqueId).To(HavePrefix("storageid:spaceid:"))
+			Expect(utils.UserEqual(s.Creator, owner.Id)).To(BeTrue())
+
+			got, err := m.GetShare(ctx, byID(s))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.Id.OpaqueId).To(Equal(s.Id.OpaqueId))
+		})
+
+		It("does not share twice with the same grantee", func() {
+			_, err := m.Share(ctx, resource, userGrant)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = m.Share(ctx, resource, userGrant)
+			Expect(err).To(BeAssignableToTypeOf(errtypes.AlreadyExists("")))
+		})
+
+		It("does not share with the owner", func() {
+			_, err := m.Share(granteeCtx, resource, &collaboration.ShareGrant{
+				Grantee: &provider.Grantee{
+					Type: provider.GranteeType_GRANTEE_TYPE_USER,
+					Id:   &provider.Grantee_UserId{UserId: owner.Id},
+				},
+			})
+			Expect(err).To(BeAssignableToTypeOf(errtypes.BadRequest("")))
+		})
+	})
+
+	Describe("with shares", func() {
+		var (
+			userShare, groupShare *collaboration.Share
+		)
+
+		BeforeEach(func() {
+			var err error
+			userShare, err = m.Share(ctx, resource, userGrant)
+			Expect(err).ToNot(HaveOccurred())
+			groupShare, err = m.Share(ctx, resource2, groupGrant)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("gets shares by key", func() {
+			s, err := m.GetShare(granteeCtx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Key{Key: &collaboration.ShareKey{
+				ResourceId: resource.Id,
+				Grantee:    userGrant.Grantee,
+			}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Id.OpaqueId).To(Equal(userShare.Id.OpaqueId))
+		})
+
+		It("hides shares from other users", func() {
+			client.On("Stat", mock.Anything, mock.Anything).Return(&provider.StatResponse{
+				Status: status.NewOK(ctx),
+				Info:   &provider.ResourceInfo{PermissionSet: &provider.ResourcePermissions{}},
+			}, nil)
+			_, err := m.GetShare(otherCtx, byID(userShare))
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+		})
+
+		It("lists the created shares", func() {
+			shares, err := m.ListShares(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(2))
+
+			shares, err = m.ListShares(ctx, []*collaboration.Filter{sharespkg.SpaceIDFilter("spaceid2")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(1))
+			Expect(shares[0].Id.OpaqueId).To(Equal(groupShare.Id.OpaqueId))
+
+			shares, err = m.ListShares(granteeCtx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(BeEmpty())
+		})
+
+		It("lists the shares of a resource for users that can list grants", func() {
+			client.On("Stat", mock.Anything, mock.Anything).Return(&provider.StatResponse{
+				Status: status.NewOK(ctx),
+				Info:   &provider.ResourceInfo{PermissionSet: &provider.ResourcePermissions{ListGrants: true}},
+			}, nil)
+			shares, err := m.ListShares(otherCtx, []*collaboration.Filter{sharespkg.ResourceIDFilter(resource.Id)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(1))
+			Expect(shares[0].Id.OpaqueId).To(Equal(userShare.Id.OpaqueId))
+		})
+
+		It("updates shares", func() {
+			s, err := m.UpdateShare(ctx, byID(userShare), &collaboration.SharePermissions{
+				Permissions: &provider.ResourcePermissions{Stat: true, ListContainer: true},
+			}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Permissions.Permissions.ListContainer).To(BeTrue())
+
+			s, err = m.GetShare(ctx, byID(userShare))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Permissions.Permissions.ListContainer).To(BeTrue())
+		})
+
+		It("removes shares", func() {
+			Expect(m.Unshare(granteeCtx, byID(userShare))).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+			Expect(m.Unshare(ctx, byID(userShare))).To(Succeed())
+			_, err := m.GetShare(ctx, byID(userShare))
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+		})
+
+		It("removes expired shares", func() {
+			_, err := m.UpdateShare(ctx, byID(userShare), nil, &collaboration.Share{
+				Expiration: &typespb.Timestamp{Seconds: 1},
+			}, &fieldmaskpb.FieldMask{Paths: []string{"expiration"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			received, err := m.ListReceivedShares(granteeCtx, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].Share.Id.OpaqueId).To(Equal(groupShare.Id.OpaqueId))
+
+			_, err = m.GetShare(ctx, byID(userShare))
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+		})
+
+		Describe("received shares", func() {
+			It("lists user and group shares", func() {
+				received, err := m.ListReceivedShares(granteeCtx, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(HaveLen(2))
+				for _, rs := range received {
+					Expect(rs.State).To(Equal(collaboration.ShareState_SHARE_STATE_PENDING))
+				}
+
+				received, err = m.ListReceivedShares(otherCtx, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(HaveLen(1))
+				Expect(received[0].Share.Id.OpaqueId).To(Equal(groupShare.Id.OpaqueId))
+
+				received, err = m.ListReceivedShares(ctx, nil, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(BeEmpty())
+			})
+
+			It("filters received shares", func() {
+				received, err := m.ListReceivedShares(granteeCtx, []*collaboration.Filter{sharespkg.GroupGranteeFilter()}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(HaveLen(1))
+				Expect(received[0].Share.Id.OpaqueId).To(Equal(groupShare.Id.OpaqueId))
+
+				received, err = m.ListReceivedShares(granteeCtx, []*collaboration.Filter{sharespkg.ResourceIDFilter(resource.Id)}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(HaveLen(1))
+				Expect(received[0].Share.Id.OpaqueId).To(Equal(userShare.Id.OpaqueId))
+			})
+
+			It("updates the state per user", func() {
+				rs, err := m.UpdateReceivedShare(granteeCtx, &collaboration.ReceivedShare{
+					Share:      groupShare,
+					State:      collaboration.ShareState_SHARE_STATE_ACCEPTED,
+					MountPoint: &provider.Reference{Path: "physics"},
+				}, &fieldmaskpb.FieldMask{Paths: []string{"state", "mount_point"}}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rs.State).To(Equal(collaboration.ShareState_SHARE_STATE_ACCEPTED))
+
+				received, err := m.ListReceivedShares(granteeCtx, []*collaboration.Filter{sharespkg.StateFilter(collaboration.ShareState_SHARE_STATE_ACCEPTED)}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(HaveLen(1))
+				Expect(received[0].Share.Id.OpaqueId).To(Equal(groupShare.Id.OpaqueId))
+				Expect(received[0].MountPoint.Path).To(Equal("physics"))
+
+				received, err = m.ListReceivedShares(granteeCtx, []*collaboration.Filter{sharespkg.StateFilter(collaboration.ShareState_SHARE_STATE_PENDING)}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(received).To(HaveLen(1))
+				Expect(received[0].Share.Id.OpaqueId).To(Equal(userShare.Id.OpaqueId))
+
+				// the state of the group share is not changed for other members
+				rs, err = m.GetReceivedShare(otherCtx, byID(groupShare))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rs.State).To(Equal(collaboration.ShareState_SHARE_STATE_PENDING))
+			})
+		})
+
+		It("dumps and loads shares", func() {
+			_, err := m.UpdateReceivedShare(granteeCtx, &collaboration.ReceivedShare{
+				Share:  groupShare,
+				State:  collaboration.ShareState_SHARE_STATE_ACCEPTED,
+				Hidden: true,
+			}, &fieldmaskpb.FieldMask{Paths: []string{"state", "hidden"}}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			shareChan := make(chan *collaboration.Share)
+			receivedChan := make(chan sharespkg.ReceivedShareWithUser)
+			target := newManager()
+			done := make(chan error)
+			go func() {
+				done <- target.Load(ctx, shareChan, receivedChan)
+			}()
+			Expect(m.Dump(ctx, shareChan, receivedChan)).To(Succeed())
+			close(shareChan)
+			close(receivedChan)
+			Expect(<-done).To(Succeed())
+
+			shares, err := target.ListShares(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shares).To(HaveLen(2))
+
+			rs, err := target.GetReceivedShare(granteeCtx, byID(groupShare))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rs.State).To(Equal(collaboration.ShareState_SHARE_STATE_ACCEPTED))
+			Expect(rs.Hidden).To(BeTrue())
+		})
+
+		It("loads shares without a space prefix in their id", func() {
+			shareChan := make(chan *collaboration.Share, 1)
+			receivedChan := make(chan sharespkg.ReceivedShareWithUser)
+			shareChan <- &collaboration.Share{
+				Id:         &collaboration.ShareId{OpaqueId: "legacy"},
+				ResourceId: &provider.ResourceId{StorageId: "storageid", SpaceId: "spaceid3", OpaqueId: "opaqueid3"},
+				Owner:      owner.Id,
+				Creator:    owner.Id,
+				Grantee:    userGrant.Grantee,
+			}
+			close(shareChan)
+			close(receivedChan)
+			Expect(m.Load(ctx, shareChan, receivedChan)).To(Succeed())
+
+			s, err := m.GetShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: &collaboration.ShareId{OpaqueId: "storageid:spaceid3:legacy"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.ResourceId.SpaceId).To(Equal("spaceid3"))
+		})
+	})
+}
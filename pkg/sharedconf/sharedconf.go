@@ -20,10 +20,13 @@ package sharedconf
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/uploadpolicy"
 )
 
 var (
@@ -37,12 +40,24 @@ type ClientOptions struct {
 	CACertFile string `mapstructure:"cacert"`
 }
 
+// SharingRole defines a custom sharing role as a named set of cs3 resource permissions
+type SharingRole struct {
+	Name        string   `mapstructure:"name"`
+	Permissions []string `mapstructure:"permissions"`
+}
+
 type conf struct {
 	JWTSecret             string        `mapstructure:"jwt_secret"`
 	GatewaySVC            string        `mapstructure:"gatewaysvc"`
 	DataGateway           string        `mapstructure:"datagateway"`
 	SkipUserGroupsInToken bool          `mapstructure:"skip_user_groups_in_token"`
 	GRPCClientOptions     ClientOptions `mapstructure:"grpc_client_options"`
+	SharingRoles          []SharingRole `mapstructure:"sharing_roles"`
+	TrustedProxies        []string      `mapstructure:"trusted_proxies"`
+	// UploadPolicies are enforced by the storage providers and advertised in the capabilities
+	UploadPolicies uploadpolicy.Config `mapstructure:"upload_policies"`
+
+	trustedProxies []*net.IPNet
 }
 
 // Decode decodes the configuration.
@@ -73,6 +88,11 @@ func Decode(v interface{}) error {
 		if sharedConf.JWTSecret == "" {
 			sharedConf.JWTSecret = "changemeplease"
 		}
+
+		if sharedConf.trustedProxies, err = ParseNetworks(sharedConf.TrustedProxies); err != nil {
+			return
+		}
+		err = sharedConf.UploadPolicies.Validate()
 	})
 
 	return err
@@ -112,6 +132,66 @@ func GRPCClientOptions() ClientOptions {
 	return sharedConf.GRPCClientOptions
 }
 
+// SharingRoles returns the custom sharing roles
+func SharingRoles() []SharingRole {
+	return sharedConf.SharingRoles
+}
+
+// UploadPolicies returns the upload policies
+func UploadPolicies() *uploadpolicy.Config {
+	return &sharedConf.UploadPolicies
+}
+
+// RestrictForwardedClientIP returns whether the forwarded client ip is only accepted from trusted
+// proxies. That is the case when trusted_proxies is configured, otherwise it is accepted from anyone.
+func RestrictForwardedClientIP() bool {
+	return len(sharedConf.trustedProxies) > 0
+}
+
+// IsTrustedProxy returns whether the given ip belongs to a proxy or reva service that is trusted
+// to forward the ip of the client, either in the X-Forwarded-For header or the client-ip grpc metadata.
+// Loopback addresses are always trusted.
+func IsTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, n := range sharedConf.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses a list of ip addresses and CIDR ranges
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
 // this is used by the tests
 func resetOnce() {
 	sharedConf = &conf{}
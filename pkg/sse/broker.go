@@ -0,0 +1,136 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a change together with its event id
+type Entry struct {
+	ID     string
+	Change Change
+}
+
+// Subscription receives the entries published after subscribing. Its channel is closed
+// when the subscriber falls behind or is removed.
+type Subscription struct {
+	C <-chan Entry
+	c chan Entry
+}
+
+// Broker distributes changes to subscriptions and keeps the most recent ones so clients
+// can resume from the last event they received.
+type Broker struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	buf    []Entry
+	start  int
+	buffer int
+	subs   map[*Subscription]struct{}
+}
+
+// NewBroker returns a broker keeping the given number of entries for resuming clients
+func NewBroker(size, buffer int) *Broker {
+	if size <= 0 {
+		size = 1000
+	}
+	if buffer <= 0 {
+		buffer = 100
+	}
+	return &Broker{
+		// ids of a previous instance can not be resumed
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		buf:    make([]Entry, 0, size),
+		buffer: buffer,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Publish assigns the next id to the change and hands it to all subscriptions
+func (b *Broker) Publish(c Change) Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := Entry{ID: fmt.Sprintf("%s-%d", b.epoch, b.seq), Change: c}
+	if len(b.buf) < cap(b.buf) {
+		b.buf = append(b.buf, e)
+	} else {
+		b.buf[b.start] = e
+		b.start = (b.start + 1) % len(b.buf)
+	}
+
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+			// the subscriber is too slow, it has to resume with its last event id
+			delete(b.subs, s)
+			close(s.c)
+		}
+	}
+	return e
+}
+
+// Subscribe returns a subscription for new entries and the buffered entries published after
+// the given event id. The returned bool is false if entries after the given id were lost.
+func (b *Broker) Subscribe(lastEventID string) (*Subscription, []Entry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Entry, b.buffer)
+	s := &Subscription{C: c, c: c}
+	b.subs[s] = struct{}{}
+
+	if lastEventID == "" {
+		return s, nil, true
+	}
+	epoch, seqStr, ok := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil || epoch != b.epoch || seq > b.seq {
+		return s, nil, false
+	}
+
+	var replay []Entry
+	for i := 0; i < len(b.buf); i++ {
+		e := b.buf[(b.start+i)%len(b.buf)]
+		if b.seq-uint64(len(b.buf))+uint64(i)+1 > seq {
+			replay = append(replay, e)
+		}
+	}
+	// the buffer must still contain the entry following the last one the client received
+	complete := b.seq-uint64(len(b.buf)) <= seq
+	return s, replay, complete
+}
+
+// Unsubscribe removes the subscription and closes its channel
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
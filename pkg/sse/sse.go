@@ -0,0 +1,234 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package sse converts storage and sharing events into compact change notifications
+// and buffers them so clients can resume their server-sent event streams.
+package sse
+
+import (
+	group "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+)
+
+// The types of the change notifications
+const (
+	TypeFolderCreated       = "folder-created"
+	TypeFileUploaded        = "file-uploaded"
+	TypeFileReady           = "file-ready"
+	TypeFileTouched         = "file-touched"
+	TypeFileLocked          = "file-locked"
+	TypeFileUnlocked        = "file-unlocked"
+	TypeFileVersionRestored = "file-version-restored"
+	TypeItemMoved           = "item-moved"
+	TypeItemTrashed         = "item-trashed"
+	TypeItemPurged          = "item-purged"
+	TypeItemRestored        = "item-restored"
+	TypeSpaceCreated        = "space-created"
+	TypeSpaceUpdated        = "space-updated"
+	TypeSpaceDisabled       = "space-disabled"
+	TypeSpaceDeleted        = "space-deleted"
+	TypeSpaceShared         = "space-shared"
+	TypeSpaceUnshared       = "space-unshared"
+	TypeShareCreated        = "share-created"
+	TypeShareUpdated        = "share-updated"
+	TypeShareRemoved        = "share-removed"
+	// TypeResync tells the client that notifications were lost and it needs to refresh its state
+	TypeResync = "resync"
+)
+
+// Change is a change to a resource derived from an event
+type Change struct {
+	Type string
+	// Ref references the changed resource. It is used to check the access of the receiving user.
+	Ref *provider.Reference
+	// ParentRef is checked instead of Ref when the changed resource is gone
+	ParentRef *provider.Reference
+	// ResourceID is the id of the resource if it is known from the event
+	ResourceID *provider.ResourceId
+	// SpaceID is the id of the space the resource lives in
+	SpaceID string
+	// Users and Groups restrict the change to the given receivers. No access check is done for them.
+	Users  []string
+	Groups []string
+	// Message is sent as is instead of a notification
+	Message []byte
+}
+
+// HasAudience returns true when the change is addressed to explicit receivers
+func (c Change) HasAudience() bool {
+	return len(c.Users) > 0 || len(c.Groups) > 0
+}
+
+// Addresses returns true if the change is addressed to the given user
+func (c Change) Addresses(u *user.User) bool {
+	for _, id := range c.Users {
+		if id == u.GetId().GetOpaqueId() {
+			return true
+		}
+	}
+	for _, g := range c.Groups {
+		for _, ug := range u.GetGroups() {
+			if g == ug {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Notification is the payload sent to the clients
+type Notification struct {
+	Type       string `json:"type"`
+	ResourceID string `json:"resource_id,omitempty"`
+	ParentID   string `json:"parent_id,omitempty"`
+	SpaceID    string `json:"space_id,omitempty"`
+	// ETag is the etag of the resource, or of its parent if the resource is gone
+	ETag string `json:"etag,omitempty"`
+}
+
+// Events returns the events changes are derived from
+func Events() []events.Unmarshaller {
+	return []events.Unmarshaller{
+		events.ContainerCreated{}, events.FileUploaded{}, events.UploadReady{}, events.FileTouched{},
+		events.FileLocked{}, events.FileUnlocked{}, events.FileVersionRestored{}, events.ItemMoved{},
+		events.ItemTrashed{}, events.ItemPurged{}, events.ItemRestored{},
+		events.SpaceCreated{}, events.SpaceRenamed{}, events.SpaceUpdated{}, events.SpaceEnabled{},
+		events.SpaceDisabled{}, events.SpaceDeleted{}, events.SpaceShared{}, events.SpaceShareUpdated{},
+		events.SpaceUnshared{}, events.SpaceMembershipExpired{},
+		events.ShareCreated{}, events.ShareUpdated{}, events.ShareRemoved{}, events.ShareExpired{},
+		events.ReceivedShareUpdated{},
+		events.SendSSE{},
+	}
+}
+
+// FromEvent derives a change from the given event. It returns false for events that
+// do not change anything clients are interested in.
+func FromEvent(ev interface{}) (Change, bool) {
+	switch e := ev.(type) {
+	case events.ContainerCreated:
+		return resourceChange(TypeFolderCreated, e.Ref, nil), true
+	case events.FileUploaded:
+		return resourceChange(TypeFileUploaded, e.Ref, nil), true
+	case events.UploadReady:
+		if e.Failed {
+			return Change{}, false
+		}
+		return resourceChange(TypeFileReady, e.FileRef, nil), true
+	case events.FileTouched:
+		return resourceChange(TypeFileTouched, e.Ref, nil), true
+	case events.FileLocked:
+		return resourceChange(TypeFileLocked, e.Ref, nil), true
+	case events.FileUnlocked:
+		return resourceChange(TypeFileUnlocked, e.Ref, nil), true
+	case events.FileVersionRestored:
+		return resourceChange(TypeFileVersionRestored, e.Ref, nil), true
+	case events.ItemMoved:
+		return resourceChange(TypeItemMoved, e.Ref, nil), true
+	case events.ItemTrashed:
+		return resourceChange(TypeItemTrashed, e.Ref, e.ID), true
+	case events.ItemPurged:
+		return resourceChange(TypeItemPurged, e.Ref, e.ID), true
+	case events.ItemRestored:
+		return resourceChange(TypeItemRestored, e.Ref, e.ID), true
+	case events.SpaceCreated:
+		return spaceChange(TypeSpaceCreated, e.ID), true
+	case events.SpaceRenamed:
+		return spaceChange(TypeSpaceUpdated, e.ID), true
+	case events.SpaceUpdated:
+		return spaceChange(TypeSpaceUpdated, e.ID), true
+	case events.SpaceEnabled:
+		return spaceChange(TypeSpaceUpdated, e.ID), true
+	case events.SpaceDisabled:
+		return spaceChange(TypeSpaceDisabled, e.ID), true
+	case events.SpaceDeleted:
+		c := spaceChange(TypeSpaceDeleted, e.ID)
+		c.Ref = nil
+		for id := range e.FinalMembers {
+			// members can be users or groups
+			c.Users = append(c.Users, id)
+			c.Groups = append(c.Groups, id)
+		}
+		return c, len(c.Users) > 0
+	case events.SpaceShared:
+		return audienceChange(spaceChange(TypeSpaceShared, e.ID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.SpaceShareUpdated:
+		return audienceChange(spaceChange(TypeSpaceShared, e.ID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.SpaceUnshared:
+		return audienceChange(spaceChange(TypeSpaceUnshared, e.ID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.SpaceMembershipExpired:
+		return audienceChange(spaceChange(TypeSpaceUnshared, e.SpaceID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.ShareCreated:
+		return audienceChange(resourceChange(TypeShareCreated, nil, e.ItemID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.ShareUpdated:
+		return audienceChange(resourceChange(TypeShareUpdated, nil, e.ItemID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.ReceivedShareUpdated:
+		return audienceChange(resourceChange(TypeShareUpdated, nil, e.ItemID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.ShareRemoved:
+		return audienceChange(resourceChange(TypeShareRemoved, nil, e.ItemID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.ShareExpired:
+		return audienceChange(resourceChange(TypeShareRemoved, nil, e.ItemID), e.GranteeUserID, e.GranteeGroupID), true
+	case events.SendSSE:
+		return Change{Type: e.Type, Users: e.UserIDs, Message: e.Message}, len(e.UserIDs) > 0
+	}
+	return Change{}, false
+}
+
+func resourceChange(typ string, ref *provider.Reference, id *provider.ResourceId) Change {
+	c := Change{Type: typ, Ref: ref, ResourceID: id}
+	if ref.GetResourceId() != nil {
+		c.SpaceID = ref.GetResourceId().GetSpaceId()
+		if p := ref.GetPath(); p != "" && p != "." {
+			// relative references point to a resource below their base
+			c.ParentRef = &provider.Reference{ResourceId: ref.GetResourceId(), Path: "."}
+		}
+	}
+	if c.SpaceID == "" {
+		c.SpaceID = id.GetSpaceId()
+	}
+	return c
+}
+
+func spaceChange(typ string, id *provider.StorageSpaceId) Change {
+	c := Change{Type: typ}
+	rid, err := storagespace.ParseID(id.GetOpaqueId())
+	if err != nil {
+		return c
+	}
+	if rid.GetOpaqueId() == "" {
+		rid.OpaqueId = rid.GetSpaceId()
+	}
+	c.SpaceID = rid.GetSpaceId()
+	c.ResourceID = &rid
+	c.Ref = &provider.Reference{ResourceId: &rid, Path: "."}
+	return c
+}
+
+func audienceChange(c Change, u *user.UserId, g *group.GroupId) Change {
+	c.Ref, c.ParentRef = nil, nil
+	if u.GetOpaqueId() != "" {
+		c.Users = append(c.Users, u.GetOpaqueId())
+	}
+	if g.GetOpaqueId() != "" {
+		c.Groups = append(c.Groups, g.GetOpaqueId())
+	}
+	return c
+}
@@ -0,0 +1,142 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sse
+
+import (
+	"testing"
+
+	group "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+)
+
+func TestBrokerResume(t *testing.T) {
+	b := NewBroker(3, 10)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, b.Publish(Change{Type: TypeFileUploaded}).ID)
+	}
+
+	_, replay, complete := b.Subscribe("")
+	if !complete || len(replay) != 0 {
+		t.Errorf("new subscription: complete %v, replay %d", complete, len(replay))
+	}
+
+	_, replay, complete = b.Subscribe(ids[2])
+	if !complete || len(replay) != 2 || replay[0].ID != ids[3] || replay[1].ID != ids[4] {
+		t.Errorf("resume within buffer: complete %v, replay %v", complete, replay)
+	}
+
+	_, replay, complete = b.Subscribe(ids[1])
+	if !complete || len(replay) != 3 {
+		t.Errorf("resume at buffer start: complete %v, replay %d", complete, len(replay))
+	}
+
+	_, replay, complete = b.Subscribe(ids[0])
+	if complete || len(replay) != 3 {
+		t.Errorf("resume before buffer: complete %v, replay %d", complete, len(replay))
+	}
+
+	_, replay, complete = b.Subscribe(ids[4])
+	if !complete || len(replay) != 0 {
+		t.Errorf("resume at latest: complete %v, replay %d", complete, len(replay))
+	}
+
+	for _, id := range []string{"other-1", "garbage", ids[4] + "0"} {
+		if _, _, complete := b.Subscribe(id); complete {
+			t.Errorf("%s: expected incomplete resume", id)
+		}
+	}
+}
+
+func TestBrokerSubscribers(t *testing.T) {
+	b := NewBroker(10, 2)
+	fast, _, _ := b.Subscribe("")
+	slow, _, _ := b.Subscribe("")
+
+	for i := 0; i < 3; i++ {
+		b.Publish(Change{Type: TypeFileUploaded})
+		if i < 2 {
+			<-fast.C
+		}
+	}
+	// the slow subscriber got two entries and was then dropped
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("slow subscriber got %d entries, want 2", n)
+	}
+	if e := <-fast.C; e.Change.Type != TypeFileUploaded {
+		t.Errorf("fast subscriber got %+v", e)
+	}
+
+	b.Unsubscribe(fast)
+	if _, ok := <-fast.C; ok {
+		t.Error("channel should be closed after unsubscribing")
+	}
+	b.Unsubscribe(fast)
+}
+
+func TestFromEvent(t *testing.T) {
+	root := &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "space"}
+	file := &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "file"}
+
+	c, ok := FromEvent(events.FileUploaded{Ref: &provider.Reference{ResourceId: root, Path: "./a.txt"}})
+	if !ok || c.Type != TypeFileUploaded || c.SpaceID != "space" || c.ParentRef.GetResourceId() != root || c.HasAudience() {
+		t.Errorf("unexpected change for FileUploaded: %+v", c)
+	}
+
+	c, ok = FromEvent(events.ItemTrashed{ID: file, Ref: &provider.Reference{ResourceId: file}})
+	if !ok || c.ResourceID != file || c.ParentRef != nil {
+		t.Errorf("unexpected change for ItemTrashed: %+v", c)
+	}
+
+	if _, ok := FromEvent(events.UploadReady{Failed: true}); ok {
+		t.Error("failed uploads should not be notified")
+	}
+
+	c, ok = FromEvent(events.SpaceCreated{ID: &provider.StorageSpaceId{OpaqueId: "storage$space"}})
+	if !ok || c.SpaceID != "space" || c.Ref.GetResourceId().GetOpaqueId() != "space" {
+		t.Errorf("unexpected change for SpaceCreated: %+v", c)
+	}
+
+	c, ok = FromEvent(events.ShareCreated{ItemID: file, GranteeGroupID: &group.GroupId{OpaqueId: "staff"}})
+	if !ok || c.Ref != nil || !c.HasAudience() {
+		t.Errorf("unexpected change for ShareCreated: %+v", c)
+	}
+	if !c.Addresses(&user.User{Id: &user.UserId{OpaqueId: "u1"}, Groups: []string{"staff"}}) {
+		t.Error("group members should be addressed")
+	}
+	if c.Addresses(&user.User{Id: &user.UserId{OpaqueId: "u1"}}) {
+		t.Error("non members should not be addressed")
+	}
+
+	c, ok = FromEvent(events.SendSSE{UserIDs: []string{"u1"}, Type: "custom", Message: []byte("hi")})
+	if !ok || c.Type != "custom" || string(c.Message) != "hi" || !c.Addresses(&user.User{Id: &user.UserId{OpaqueId: "u1"}}) {
+		t.Errorf("unexpected change for SendSSE: %+v", c)
+	}
+
+	if _, ok := FromEvent(events.FileDownloaded{}); ok {
+		t.Error("downloads do not change anything")
+	}
+}
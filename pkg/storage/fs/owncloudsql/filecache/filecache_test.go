@@ -23,7 +23,10 @@ import (
 	"database/sql"
 	"os"
 	"strconv"
+	"time"
 
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/owncloudsql/filecache"
@@ -626,4 +629,70 @@ var _ = Describe("Filecache", func() {
 			Expect(perms.InitiateFileUpload).To(BeFalse())
 		})
 	})
+
+	Describe("Locks", func() {
+		var lock *provider.Lock
+
+		BeforeEach(func() {
+			Expect(cache.CreateLocksTable(ctx)).To(Succeed())
+			// creating the table is idempotent
+			Expect(cache.CreateLocksTable(ctx)).To(Succeed())
+
+			lock = &provider.Lock{
+				LockId:  "lock1",
+				Type:    provider.LockType_LOCK_TYPE_WRITE,
+				AppName: "app",
+			}
+		})
+
+		It("returns ErrNoRows if there is no lock", func() {
+			_, err := cache.GetLock(ctx, 10)
+			Expect(err).To(Equal(sql.ErrNoRows))
+		})
+
+		It("sets, updates and deletes a lock", func() {
+			Expect(cache.SetLock(ctx, 10, lock)).To(Succeed())
+
+			l, err := cache.GetLock(ctx, 10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.LockId).To(Equal("lock1"))
+			Expect(l.AppName).To(Equal("app"))
+
+			Expect(cache.SetLock(ctx, 10, &provider.Lock{LockId: "lock2"})).To(Equal(filecache.ErrAlreadyLocked))
+
+			lock.LockId = "lock3"
+			Expect(cache.UpdateLock(ctx, 10, lock)).To(Succeed())
+			l, err = cache.GetLock(ctx, 10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.LockId).To(Equal("lock3"))
+
+			Expect(cache.DeleteLock(ctx, 10)).To(Succeed())
+			_, err = cache.GetLock(ctx, 10)
+			Expect(err).To(Equal(sql.ErrNoRows))
+			Expect(cache.UpdateLock(ctx, 10, lock)).To(Equal(sql.ErrNoRows))
+		})
+
+		It("ignores expired locks", func() {
+			lock.Expiration = &types.Timestamp{Seconds: uint64(time.Now().Add(-time.Minute).Unix())}
+			Expect(cache.SetLock(ctx, 10, lock)).To(Succeed())
+
+			_, err := cache.GetLock(ctx, 10)
+			Expect(err).To(Equal(sql.ErrNoRows))
+
+			lock.Expiration = &types.Timestamp{Seconds: uint64(time.Now().Add(time.Minute).Unix())}
+			Expect(cache.SetLock(ctx, 10, lock)).To(Succeed())
+			_, err = cache.GetLock(ctx, 10)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns the locks of the children of a folder", func() {
+			Expect(cache.SetLock(ctx, 10, lock)).To(Succeed())
+			Expect(cache.SetLock(ctx, 8, &provider.Lock{LockId: "other"})).To(Succeed())
+
+			locks, err := cache.GetLocks(ctx, 9)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locks).To(HaveLen(1))
+			Expect(locks[10].LockId).To(Equal("lock1"))
+		})
+	})
 })
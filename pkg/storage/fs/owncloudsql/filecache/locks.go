@@ -0,0 +1,157 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package filecache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/pkg/errors"
+)
+
+// ErrAlreadyLocked is returned when a lock is set on a file that is already locked
+var ErrAlreadyLocked = errors.New("already locked")
+
+// The oc_file_locks table of ownCloud only holds short lived transactional locks,
+// so the cs3 locks are kept in a companion table keyed by the fileid.
+const createLocksTable = `CREATE TABLE IF NOT EXISTS oc_cs3_locks (
+	fileid BIGINT NOT NULL PRIMARY KEY,
+	lock_id VARCHAR(255) NOT NULL,
+	expiration BIGINT NOT NULL DEFAULT 0,
+	data TEXT NOT NULL
+)`
+
+// CreateLocksTable creates the table holding the locks if it does not exist yet
+func (c *Cache) CreateLocksTable(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, createLocksTable)
+	return err
+}
+
+// GetLock returns the lock of the given file. sql.ErrNoRows is returned if the file is not locked.
+func (c *Cache) GetLock(ctx context.Context, fileid int) (*provider.Lock, error) {
+	row := c.db.QueryRowContext(ctx, "SELECT lock_id, data, expiration FROM oc_cs3_locks WHERE fileid = ?", fileid)
+	var lockID, data string
+	var expiration int64
+	if err := row.Scan(&lockID, &data, &expiration); err != nil {
+		return nil, err
+	}
+	if expired(expiration) {
+		// only remove the expired lock, a lock that was set in the meantime stays
+		if _, err := c.db.ExecContext(ctx, "DELETE FROM oc_cs3_locks WHERE fileid = ? AND lock_id = ? AND expiration = ?", fileid, lockID, expiration); err != nil {
+			return nil, errors.Wrap(err, "could not remove expired lock")
+		}
+		return nil, sql.ErrNoRows
+	}
+	return decodeLock(data)
+}
+
+// GetLocks returns the locks of the children of the given folder, keyed by their fileid
+func (c *Cache) GetLocks(ctx context.Context, parent int) (map[int]*provider.Lock, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT l.fileid, l.data, l.expiration FROM oc_cs3_locks l JOIN oc_filecache f ON f.fileid = l.fileid WHERE f.parent = ?", parent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := map[int]*provider.Lock{}
+	for rows.Next() {
+		var fileid int
+		var data string
+		var expiration int64
+		if err := rows.Scan(&fileid, &data, &expiration); err != nil {
+			return nil, err
+		}
+		if expired(expiration) {
+			// expired locks are cleaned up when they are read individually
+			continue
+		}
+		lock, err := decodeLock(data)
+		if err != nil {
+			return nil, err
+		}
+		locks[fileid] = lock
+	}
+	return locks, rows.Err()
+}
+
+// SetLock locks the given file. ErrAlreadyLocked is returned if the file already has a valid lock.
+func (c *Cache) SetLock(ctx context.Context, fileid int, lock *provider.Lock) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	// clean up an expired lock, so that it does not block the new one
+	_, err = c.db.ExecContext(ctx, "DELETE FROM oc_cs3_locks WHERE fileid = ? AND expiration > 0 AND expiration <= ?", fileid, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.ExecContext(ctx, "INSERT INTO oc_cs3_locks(fileid, lock_id, expiration, data) VALUES(?,?,?,?)", fileid, lock.GetLockId(), expiration(lock), string(data))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") || strings.Contains(err.Error(), "Error 1062") {
+			return ErrAlreadyLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateLock replaces the existing lock of the given file
+func (c *Cache) UpdateLock(ctx context.Context, fileid int, lock *provider.Lock) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	res, err := c.db.ExecContext(ctx, "UPDATE oc_cs3_locks SET lock_id = ?, expiration = ?, data = ? WHERE fileid = ?", lock.GetLockId(), expiration(lock), string(data), fileid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteLock removes the lock of the given file
+func (c *Cache) DeleteLock(ctx context.Context, fileid int) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM oc_cs3_locks WHERE fileid = ?", fileid)
+	return err
+}
+
+func decodeLock(data string) (*provider.Lock, error) {
+	lock := &provider.Lock{}
+	if err := json.Unmarshal([]byte(data), lock); err != nil {
+		return nil, errors.Wrap(err, "could not decode lock")
+	}
+	return lock, nil
+}
+
+func expiration(lock *provider.Lock) int64 {
+	if lock.GetExpiration() == nil {
+		return 0
+	}
+	return int64(lock.GetExpiration().GetSeconds())
+}
+
+func expired(expiration int64) bool {
+	return expiration > 0 && time.Now().Unix() >= expiration
+}
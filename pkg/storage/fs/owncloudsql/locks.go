@@ -0,0 +1,263 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/pkg/errors"
+
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/owncloudsql/filecache"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// GetLock returns an existing lock on the given reference
+func (fs *owncloudsqlfs) GetLock(ctx context.Context, ref *provider.Reference) (*provider.Lock, error) {
+	ip, err := fs.resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "owncloudsql: error resolving reference")
+	}
+
+	// check permissions
+	if perm, err := fs.readPermissions(ctx, ip); err == nil {
+		if !perm.Stat {
+			return nil, errtypes.PermissionDenied("")
+		}
+	} else {
+		if isNotFound(err) {
+			return nil, errtypes.NotFound(fs.toStoragePath(ctx, filepath.Dir(ip)))
+		}
+		return nil, errors.Wrap(err, "owncloudsql: error reading permissions")
+	}
+
+	fileid, err := fs.getFileID(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := fs.filecache.GetLock(ctx, fileid)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, errtypes.NotFound("no lock found")
+	case err != nil:
+		return nil, errors.Wrap(err, "owncloudsql: could not read lock")
+	}
+	return lock, nil
+}
+
+// SetLock puts a lock on the given reference
+func (fs *owncloudsqlfs) SetLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
+	fileid, err := fs.lockableFileID(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	err = fs.filecache.SetLock(ctx, fileid, lock)
+	switch {
+	case err == filecache.ErrAlreadyLocked:
+		return errtypes.PreconditionFailed("already locked")
+	case err != nil:
+		return errors.Wrap(err, "owncloudsql: could not set lock")
+	}
+	return nil
+}
+
+// RefreshLock refreshes an existing lock on the given reference
+func (fs *owncloudsqlfs) RefreshLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock, existingLockID string) error {
+	fileid, err := fs.lockableFileID(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	oldLock, err := fs.filecache.GetLock(ctx, fileid)
+	switch {
+	case err == sql.ErrNoRows:
+		return errtypes.PreconditionFailed("lock does not exist")
+	case err != nil:
+		return errors.Wrap(err, "owncloudsql: could not read lock")
+	}
+
+	// check refresh lockID match
+	if existingLockID == "" && oldLock.LockId != lock.LockId {
+		return errtypes.Aborted("mismatching lock ID")
+	}
+
+	// check if UnlockAndRelock sends the correct lockID
+	if existingLockID != "" && oldLock.LockId != existingLockID {
+		return errtypes.Aborted("mismatching existing lock ID")
+	}
+
+	if err := checkLockModification(ctx, oldLock, lock); err != nil {
+		return err
+	}
+
+	if err := fs.filecache.UpdateLock(ctx, fileid, lock); err != nil {
+		return errors.Wrap(err, "owncloudsql: could not refresh lock")
+	}
+	return nil
+}
+
+// Unlock removes an existing lock from the given reference
+func (fs *owncloudsqlfs) Unlock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
+	fileid, err := fs.lockableFileID(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	oldLock, err := fs.filecache.GetLock(ctx, fileid)
+	switch {
+	case err == sql.ErrNoRows:
+		return errtypes.Aborted("lock does not exist")
+	case err != nil:
+		return errors.Wrap(err, "owncloudsql: could not read lock")
+	}
+
+	if lock == nil || oldLock.LockId != lock.LockId {
+		return errtypes.Locked(oldLock.LockId)
+	}
+
+	if err := checkLockModification(ctx, oldLock, lock); err != nil {
+		return err
+	}
+
+	if err := fs.filecache.DeleteLock(ctx, fileid); err != nil {
+		return errors.Wrap(err, "owncloudsql: could not remove lock")
+	}
+	return nil
+}
+
+// lockableFileID resolves the reference and checks that the current user may change its lock
+func (fs *owncloudsqlfs) lockableFileID(ctx context.Context, ref *provider.Reference) (int, error) {
+	ip, err := fs.resolve(ctx, ref)
+	if err != nil {
+		return 0, errors.Wrap(err, "owncloudsql: error resolving reference")
+	}
+
+	// check permissions
+	if perm, err := fs.readPermissions(ctx, ip); err == nil {
+		if !perm.InitiateFileUpload {
+			return 0, errtypes.PermissionDenied("")
+		}
+	} else {
+		if isNotFound(err) {
+			return 0, errtypes.NotFound(fs.toStoragePath(ctx, filepath.Dir(ip)))
+		}
+		return 0, errors.Wrap(err, "owncloudsql: error reading permissions")
+	}
+
+	return fs.getFileID(ctx, ip)
+}
+
+func (fs *owncloudsqlfs) getFileID(ctx context.Context, ip string) (int, error) {
+	ownerStorageID, err := fs.filecache.GetNumericStorageID(ctx, "home::"+fs.getOwner(ip))
+	if err != nil {
+		return 0, err
+	}
+	entry, err := fs.filecache.Get(ctx, ownerStorageID, fs.toDatabasePath(ip))
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, errtypes.NotFound(fs.toStoragePath(ctx, ip))
+	case err != nil:
+		return 0, err
+	}
+	return entry.ID, nil
+}
+
+// checkLock compares the lock id in the context with the lock of the file. Files that
+// do not exist yet can not be locked.
+func (fs *owncloudsqlfs) checkLock(ctx context.Context, ip string) error {
+	contextLock, _ := ctxpkg.ContextGetLockID(ctx)
+
+	var lock *provider.Lock
+	fileid, err := fs.getFileID(ctx, ip)
+	switch err.(type) {
+	case nil:
+		lock, err = fs.filecache.GetLock(ctx, fileid)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Wrap(err, "owncloudsql: could not read lock")
+		}
+	case errtypes.NotFound:
+	default:
+		return err
+	}
+
+	if lock != nil {
+		switch contextLock {
+		case "":
+			return errtypes.Locked(lock.LockId) // no lockid in request
+		case lock.LockId:
+			return nil // ok
+		default:
+			return errtypes.Aborted("mismatching lock")
+		}
+	}
+	if contextLock != "" {
+		return errtypes.Aborted("not locked") // no lock on the file. why is there a lockid in the context
+	}
+	return nil
+}
+
+// readLockIntoResourceInfo adds the lock to the resource info the same way decomposedfs does
+func readLockIntoResourceInfo(ctx context.Context, lock *provider.Lock, ri *provider.ResourceInfo) {
+	b, err := json.Marshal(lock)
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("owncloudsql: could not marshal lock")
+		return
+	}
+	if ri.Opaque == nil {
+		ri.Opaque = &types.Opaque{
+			Map: map[string]*types.OpaqueEntry{},
+		}
+	}
+	ri.Opaque.Map["lock"] = &types.OpaqueEntry{
+		Decoder: "json",
+		Value:   b,
+	}
+	ri.Lock = lock
+}
+
+func checkLockModification(ctx context.Context, oldLock *provider.Lock, newLock *provider.Lock) error {
+	if oldLock.Type == provider.LockType_LOCK_TYPE_SHARED {
+		return nil
+	}
+
+	if oldLock.AppName != newLock.AppName {
+		return errtypes.PermissionDenied("app names of the locks are mismatching")
+	}
+
+	if oldLock.User == nil && newLock.GetUser() == nil {
+		// no user lock set
+		return nil
+	}
+	if !utils.UserIDEqual(oldLock.User, newLock.GetUser()) {
+		return errtypes.PermissionDenied("users of the locks are mismatching")
+	}
+	u := ctxpkg.ContextMustGetUser(ctx)
+	if !utils.UserIDEqual(oldLock.User, u.Id) {
+		return errtypes.PermissionDenied("lock holder and current user are mismatching")
+	}
+	return nil
+}
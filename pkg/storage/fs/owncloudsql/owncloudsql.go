@@ -183,6 +183,9 @@ func New(m map[string]interface{}, _ events.Stream, _ *zerolog.Logger) (storage.
 	if err != nil {
 		return nil, err
 	}
+	if err := filecache.CreateLocksTable(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "owncloudsql: could not create locks table")
+	}
 
 	return &owncloudsqlfs{
 		c:            c,
@@ -1137,26 +1140,6 @@ func (fs *owncloudsqlfs) UnsetArbitraryMetadata(ctx context.Context, ref *provid
 	}
 }
 
-// GetLock returns an existing lock on the given reference
-func (fs *owncloudsqlfs) GetLock(ctx context.Context, ref *provider.Reference) (*provider.Lock, error) {
-	return nil, errtypes.NotSupported("unimplemented")
-}
-
-// SetLock puts a lock on the given reference
-func (fs *owncloudsqlfs) SetLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
-	return errtypes.NotSupported("unimplemented")
-}
-
-// RefreshLock refreshes an existing lock on the given reference
-func (fs *owncloudsqlfs) RefreshLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock, existingLockID string) error {
-	return errtypes.NotSupported("unimplemented")
-}
-
-// Unlock removes an existing lock from the given reference
-func (fs *owncloudsqlfs) Unlock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
-	return errtypes.NotSupported("unimplemented")
-}
-
 // Delete is actually only a move to trash
 //
 // This is a first optimistic approach.
@@ -1192,6 +1175,14 @@ func (fs *owncloudsqlfs) Delete(ctx context.Context, ref *provider.Reference) (e
 		return errors.Wrap(err, "owncloudsql: error stating "+ip)
 	}
 
+	if err := fs.checkLock(ctx, ip); err != nil {
+		return err
+	}
+	fileid, err := fs.getFileID(ctx, ip)
+	if err != nil {
+		return err
+	}
+
 	// Delete file into the owner's trash, not the user's (in case of shares)
 	rp, err := fs.getRecyclePathForUser(fs.getOwner(ip))
 	if err != nil {
@@ -1209,7 +1200,8 @@ func (fs *owncloudsqlfs) Delete(ctx context.Context, ref *provider.Reference) (e
 	if err != nil {
 		return errors.Wrapf(err, "owncloudsql: error deleting file %s", ip)
 	}
-	return nil
+	// the lock must not survive in the trash
+	return fs.filecache.DeleteLock(ctx, fileid)
 }
 
 func (fs *owncloudsqlfs) trash(ctx context.Context, ip string, rp string, origin string) error {
@@ -1319,6 +1311,20 @@ func (fs *owncloudsqlfs) Move(ctx context.Context, oldRef, newRef *provider.Refe
 	}
 
 	// TODO check target permissions ... if it exists
+	if err := fs.checkLock(ctx, oldIP); err != nil {
+		return err
+	}
+	// the target usually does not exist yet, it only has to be checked when it is overwritten
+	if newIP != oldIP {
+		if _, err := os.Stat(newIP); err == nil {
+			if err := fs.checkLock(ctx, newIP); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return errors.Wrap(err, "owncloudsql: error checking target "+newIP)
+		}
+	}
+
 	storage, err := fs.getStorage(ctx, oldIP)
 	if err != nil {
 		return err
@@ -1383,7 +1389,18 @@ func (fs *owncloudsqlfs) GetMD(ctx context.Context, ref *provider.Reference, mdK
 		return nil, err
 	}
 
-	return fs.convertToResourceInfo(ctx, entry, ip, mdKeys)
+	ri, err := fs.convertToResourceInfo(ctx, entry, ip, mdKeys)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := fs.filecache.GetLock(ctx, entry.ID)
+	switch {
+	case err == nil:
+		readLockIntoResourceInfo(ctx, lock, ri)
+	case err != sql.ErrNoRows:
+		appctx.GetLogger(ctx).Error().Err(err).Str("path", ip).Msg("could not read lock")
+	}
+	return ri, nil
 }
 
 func (fs *owncloudsqlfs) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys, fieldMask []string) ([]*provider.ResourceInfo, error) {
@@ -1437,17 +1454,7 @@ func (fs *owncloudsqlfs) listWithNominalHome(ctx context.Context, ip string, mdK
 	if err != nil {
 		return nil, errors.Wrapf(err, "owncloudsql: error listing %s", ip)
 	}
-	owner := fs.getOwner(ip)
-	finfos := []*provider.ResourceInfo{}
-	for _, entry := range entries {
-		cp := filepath.Join(fs.c.DataDirectory, owner, entry.Path)
-		m, err := fs.convertToResourceInfo(ctx, entry, cp, mdKeys)
-		if err != nil {
-			appctx.GetLogger(ctx).Error().Err(err).Str("path", cp).Msg("error converting to a resource info")
-		}
-		finfos = append(finfos, m)
-	}
-	return finfos, nil
+	return fs.convertToResourceInfos(ctx, entries, ip, mdKeys), nil
 }
 
 func (fs *owncloudsqlfs) listWithHome(ctx context.Context, home, p string, mdKeys []string) ([]*provider.ResourceInfo, error) {
@@ -1485,7 +1492,18 @@ func (fs *owncloudsqlfs) listHome(ctx context.Context, home string, mdKeys []str
 	if err != nil {
 		return nil, errors.Wrapf(err, "owncloudsql: error listing %s", ip)
 	}
+	return fs.convertToResourceInfos(ctx, entries, ip, mdKeys), nil
+}
+
+func (fs *owncloudsqlfs) convertToResourceInfos(ctx context.Context, entries []*filecache.File, ip string, mdKeys []string) []*provider.ResourceInfo {
 	owner := fs.getOwner(ip)
+	locks := map[int]*provider.Lock{}
+	if len(entries) > 0 {
+		var err error
+		if locks, err = fs.filecache.GetLocks(ctx, entries[0].Parent); err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("path", ip).Msg("could not read locks")
+		}
+	}
 	finfos := []*provider.ResourceInfo{}
 	for _, entry := range entries {
 		cp := filepath.Join(fs.c.DataDirectory, owner, entry.Path)
@@ -1493,9 +1511,12 @@ func (fs *owncloudsqlfs) listHome(ctx context.Context, home string, mdKeys []str
 		if err != nil {
 			appctx.GetLogger(ctx).Error().Err(err).Str("path", cp).Msg("error converting to a resource info")
 		}
+		if lock, ok := locks[entry.ID]; ok && m != nil {
+			readLockIntoResourceInfo(ctx, lock, m)
+		}
 		finfos = append(finfos, m)
 	}
-	return finfos, nil
+	return finfos
 }
 
 func (fs *owncloudsqlfs) archiveRevision(ctx context.Context, vbp string, ip string) error {
@@ -1654,7 +1675,70 @@ func (fs *owncloudsqlfs) ListRevisions(ctx context.Context, ref *provider.Refere
 }
 
 func (fs *owncloudsqlfs) DownloadRevision(ctx context.Context, ref *provider.Reference, revisionKey string, openReaderfunc func(*provider.ResourceInfo) bool) (*provider.ResourceInfo, io.ReadCloser, error) {
-	return nil, nil, errtypes.NotSupported("download revision")
+	ip, err := fs.resolve(ctx, ref)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "owncloudsql: error resolving reference")
+	}
+
+	// check permissions
+	if perm, err := fs.readPermissions(ctx, ip); err == nil {
+		if !perm.ListFileVersions || !perm.InitiateFileDownload {
+			return nil, nil, errtypes.PermissionDenied("")
+		}
+	} else {
+		if isNotFound(err) {
+			return nil, nil, errtypes.NotFound(fs.toStoragePath(ctx, filepath.Dir(ip)))
+		}
+		return nil, nil, errors.Wrap(err, "owncloudsql: error reading permissions")
+	}
+
+	// versions have filename.ext.v12345678, the revision key is the version mtime
+	mtime, err := strconv.Atoi(revisionKey)
+	if err != nil {
+		return nil, nil, errtypes.NotFound(revisionKey)
+	}
+
+	storageID, err := fs.getStorage(ctx, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, err := fs.filecache.Get(ctx, storageID, fs.toDatabasePath(ip))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil, errtypes.NotFound(fs.toStoragePath(ctx, ip))
+	case err != nil:
+		return nil, nil, err
+	}
+	vp := fs.getVersionsPath(ctx, ip) + ".v" + revisionKey
+	version, err := fs.filecache.Get(ctx, storageID, fs.toDatabasePath(vp))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil, errtypes.NotFound(revisionKey)
+	case err != nil:
+		return nil, nil, err
+	}
+
+	md, err := fs.convertToResourceInfo(ctx, entry, ip, []string{"size", "mimetype", "etag"})
+	if err != nil {
+		return nil, nil, err
+	}
+	// update resource info with revision data
+	md.Size = uint64(version.Size)
+	md.Mtime = &types.Timestamp{Seconds: uint64(mtime)}
+	md.Etag = version.Etag
+
+	if !openReaderfunc(md) {
+		return md, nil, nil
+	}
+
+	r, err := os.Open(vp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, errtypes.NotFound(revisionKey)
+		}
+		return nil, nil, errors.Wrap(err, "owncloudsql: error reading revision "+vp)
+	}
+	return md, r, nil
 }
 
 func (fs *owncloudsqlfs) RestoreRevision(ctx context.Context, ref *provider.Reference, revisionKey string) error {
@@ -1675,6 +1759,10 @@ func (fs *owncloudsqlfs) RestoreRevision(ctx context.Context, ref *provider.Refe
 		return errors.Wrap(err, "owncloudsql: error reading permissions")
 	}
 
+	if err := fs.checkLock(ctx, ip); err != nil {
+		return err
+	}
+
 	vp := fs.getVersionsPath(ctx, ip)
 	rp := vp + ".v" + revisionKey
 
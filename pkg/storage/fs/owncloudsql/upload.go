@@ -38,6 +38,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/logger"
 	"github.com/opencloud-eu/reva/v2/pkg/mime"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/utils/checksum"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/chunking"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/templates"
@@ -227,6 +228,12 @@ func (fs *owncloudsqlfs) NewUpload(ctx context.Context, info tusd.FileInfo) (upl
 		return nil, errtypes.PreconditionFailed("resource is not a file")
 	}
 
+	if fsInfo != nil {
+		if err := fs.checkLock(ctx, ip); err != nil {
+			return nil, err
+		}
+	}
+
 	log.Debug().Interface("info", info).Msg("owncloudsql: resolved filename")
 
 	info.ID = uuid.New().String()
@@ -236,6 +243,7 @@ func (fs *owncloudsqlfs) NewUpload(ctx context.Context, info tusd.FileInfo) (upl
 		return nil, errors.Wrap(err, "owncloudsql: error resolving upload path")
 	}
 	usr := ctxpkg.ContextMustGetUser(ctx)
+	lockID, _ := ctxpkg.ContextGetLockID(ctx)
 	storageID, err := fs.getStorage(ctx, ip)
 	if err != nil {
 		return nil, err
@@ -250,6 +258,9 @@ func (fs *owncloudsqlfs) NewUpload(ctx context.Context, info tusd.FileInfo) (upl
 		"UserId":   usr.Id.OpaqueId,
 		"UserName": usr.Username,
 
+		// the lock is checked again when the upload is finished
+		"LockID": lockID,
+
 		"LogLevel": log.GetLevel().String(),
 
 		"StorageId": strconv.Itoa(storageID),
@@ -322,6 +333,7 @@ func (fs *owncloudsqlfs) GetUpload(ctx context.Context, id string) (tusd.Upload,
 	}
 
 	ctx = ctxpkg.ContextSetUser(ctx, u)
+	ctx = ctxpkg.ContextSetLockID(ctx, info.Storage["LockID"])
 	// TODO configure the logger the same way ... store and add traceid in file info
 
 	var opts []logger.Option
@@ -369,7 +381,8 @@ func (upload *fileUpload) WriteChunk(ctx context.Context, offset int64, src io.R
 	}
 	defer file.Close()
 
-	n, err := io.Copy(file, src)
+	verifier := checksum.NewVerifier(ctx)
+	n, err := io.Copy(file, verifier.Reader(src))
 
 	// If the HTTP PATCH request gets interrupted in the middle (e.g. because
 	// the user wants to pause the upload), Go's net/http returns an io.ErrUnexpectedEOF.
@@ -381,6 +394,19 @@ func (upload *fileUpload) WriteChunk(ctx context.Context, offset int64, src io.R
 		}
 	}
 
+	if verifier != nil {
+		verr := verifier.Verify()
+		if verr == nil && err == io.ErrUnexpectedEOF {
+			verr = checksum.ErrChecksumMismatch
+		}
+		if verr != nil {
+			if terr := file.Truncate(offset); terr != nil {
+				return n, terr
+			}
+			return 0, verr
+		}
+	}
+
 	upload.info.Offset += n
 	err = upload.writeInfo() // TODO info is written here ... we need to truncate in DiscardChunk
 
@@ -407,6 +433,11 @@ func (upload *fileUpload) FinishUpload(ctx context.Context) error {
 
 	ip := upload.info.Storage["InternalDestination"]
 
+	// the file might have been locked while the upload was in progress
+	if err := upload.fs.checkLock(upload.ctx, ip); err != nil {
+		return err
+	}
+
 	// if destination exists
 	// TODO check etag with If-Match header
 	if _, err := os.Stat(ip); err == nil {
@@ -0,0 +1,471 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package acl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// PosixAccessAttr is the extended attribute holding the POSIX.1e access ACL
+	PosixAccessAttr = "system.posix_acl_access"
+	// PosixDefaultAttr is the extended attribute holding the POSIX.1e default ACL of a directory
+	PosixDefaultAttr = "system.posix_acl_default"
+	// NFS4Attr is the extended attribute holding the NFSv4 ACL
+	NFS4Attr = "system.nfs4_acl"
+)
+
+// Entry is a named ACL entry of a single user or group
+type Entry struct {
+	Group bool
+	ID    uint32
+	Deny  bool
+	// Permissions holds the ace permission letters, see pkg/storage/utils/ace
+	Permissions string
+}
+
+func (e Entry) key() string {
+	if e.Group {
+		return fmt.Sprintf("g:%d", e.ID)
+	}
+	return fmt.Sprintf("u:%d", e.ID)
+}
+
+// posixPerm maps the ace permission letters of the entry to rwx bits
+func (e Entry) posixPerm(isDir bool) uint16 {
+	if e.Deny {
+		return 0
+	}
+	var perm uint16
+	if strings.Contains(e.Permissions, "r") {
+		perm |= 4
+	}
+	if strings.ContainsAny(e.Permissions, "wad") {
+		perm |= 2
+	}
+	// traversing a directory requires the execute bit
+	if isDir && strings.ContainsAny(e.Permissions, "xtr") {
+		perm |= 1
+	}
+	return perm
+}
+
+// nfs4Mask maps the ace permission letters of the entry to an NFSv4 access mask
+func (e Entry) nfs4Mask() uint32 {
+	if e.Deny {
+		return nfs4FullMask
+	}
+	mask := nfs4ReadACL | nfs4Synchronize
+	for _, l := range e.Permissions {
+		mask |= nfs4LetterMask[l]
+	}
+	return mask
+}
+
+// permissionsFromPosix maps rwx bits to ace permission letters
+func permissionsFromPosix(perm uint16, isDir bool) string {
+	var b strings.Builder
+	if perm&4 != 0 {
+		b.WriteString("tr")
+		if isDir {
+			b.WriteString("x")
+		}
+	}
+	if perm&2 != 0 {
+		b.WriteString("wad")
+	}
+	return b.String()
+}
+
+// permissionsFromNFS4 maps an NFSv4 access mask to ace permission letters
+func permissionsFromNFS4(mask uint32) string {
+	var b strings.Builder
+	for _, l := range nfs4Letters {
+		if mask&nfs4LetterMask[l] != 0 {
+			b.WriteRune(l)
+		}
+	}
+	return b.String()
+}
+
+// MergeEntries combines the entries of the same principal. Denials take precedence,
+// otherwise the permissions are joined.
+func MergeEntries(entries []Entry) []Entry {
+	merged := map[string]Entry{}
+	for _, e := range entries {
+		m, ok := merged[e.key()]
+		switch {
+		case !ok:
+			m = e
+		case m.Deny || e.Deny:
+			m.Deny = true
+			m.Permissions = ""
+		default:
+			for _, l := range e.Permissions {
+				if !strings.ContainsRune(m.Permissions, l) {
+					m.Permissions += string(l)
+				}
+			}
+		}
+		merged[e.key()] = m
+	}
+
+	result := make([]Entry, 0, len(merged))
+	for _, e := range merged {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Group != result[j].Group {
+			return !result[i].Group
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// POSIX.1e ACLs as stored in the system.posix_acl_* extended attributes
+
+const (
+	posixACLVersion = 2
+
+	tagUserObj  uint16 = 0x01
+	tagUser     uint16 = 0x02
+	tagGroupObj uint16 = 0x04
+	tagGroup    uint16 = 0x08
+	tagMask     uint16 = 0x10
+	tagOther    uint16 = 0x20
+
+	undefinedID = ^uint32(0)
+)
+
+type posixEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+func decodePosix(b []byte) ([]posixEntry, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		return nil, fmt.Errorf("invalid posix acl of length %d", len(b))
+	}
+	if v := binary.LittleEndian.Uint32(b); v != posixACLVersion {
+		return nil, fmt.Errorf("unsupported posix acl version %d", v)
+	}
+	entries := make([]posixEntry, 0, (len(b)-4)/8)
+	for i := 4; i < len(b); i += 8 {
+		entries = append(entries, posixEntry{
+			tag:  binary.LittleEndian.Uint16(b[i:]),
+			perm: binary.LittleEndian.Uint16(b[i+2:]),
+			id:   binary.LittleEndian.Uint32(b[i+4:]),
+		})
+	}
+	return entries, nil
+}
+
+func encodePosix(entries []posixEntry) []byte {
+	b := make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(b, posixACLVersion)
+	for i, e := range entries {
+		o := 4 + 8*i
+		binary.LittleEndian.PutUint16(b[o:], e.tag)
+		binary.LittleEndian.PutUint16(b[o+2:], e.perm)
+		binary.LittleEndian.PutUint32(b[o+4:], e.id)
+	}
+	return b
+}
+
+// buildPosix renders the named entries into a complete ACL. The owner, owning group
+// and other entries are taken from the existing ACL if there is one, from the mode otherwise.
+func buildPosix(existing []posixEntry, mode uint32, entries []Entry, isDir bool) []posixEntry {
+	userObj, groupObj, other := uint16(mode>>6&7), uint16(mode>>3&7), uint16(mode&7)
+	for _, e := range existing {
+		switch e.tag {
+		case tagUserObj:
+			userObj = e.perm
+		case tagGroupObj:
+			groupObj = e.perm
+		case tagOther:
+			other = e.perm
+		}
+	}
+
+	acl := []posixEntry{{tag: tagUserObj, perm: userObj, id: undefinedID}}
+	mask := groupObj
+	var groups []posixEntry
+	for _, e := range MergeEntries(entries) {
+		perm := e.posixPerm(isDir)
+		mask |= perm
+		if e.Group {
+			groups = append(groups, posixEntry{tag: tagGroup, perm: perm, id: e.ID})
+		} else {
+			acl = append(acl, posixEntry{tag: tagUser, perm: perm, id: e.ID})
+		}
+	}
+	acl = append(acl, posixEntry{tag: tagGroupObj, perm: groupObj, id: undefinedID})
+	acl = append(acl, groups...)
+	acl = append(acl,
+		posixEntry{tag: tagMask, perm: mask, id: undefinedID},
+		posixEntry{tag: tagOther, perm: other, id: undefinedID},
+	)
+	return acl
+}
+
+// groupObjPerm returns the permissions of the owning group, which are hidden behind the mask
+// in the mode bits as long as the ACL has named entries
+func groupObjPerm(acl []posixEntry) (uint16, bool) {
+	for _, e := range acl {
+		if e.tag == tagGroupObj {
+			return e.perm, true
+		}
+	}
+	return 0, false
+}
+
+// withoutNamed drops the named entries that are contained in the other ACL
+func withoutNamed(acl, other []posixEntry) []posixEntry {
+	result := make([]posixEntry, 0, len(acl))
+	for _, e := range acl {
+		if (e.tag == tagUser || e.tag == tagGroup) && slices.Contains(other, e) {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// entriesFromPosix returns the named entries of the ACL, limited by its mask
+func entriesFromPosix(acl []posixEntry, isDir bool) []Entry {
+	mask := uint16(7)
+	for _, e := range acl {
+		if e.tag == tagMask {
+			mask = e.perm
+		}
+	}
+	var entries []Entry
+	for _, e := range acl {
+		if e.tag != tagUser && e.tag != tagGroup {
+			continue
+		}
+		perm := e.perm & mask
+		entries = append(entries, Entry{
+			Group:       e.tag == tagGroup,
+			ID:          e.id,
+			Deny:        perm == 0,
+			Permissions: permissionsFromPosix(perm, isDir),
+		})
+	}
+	return entries
+}
+
+// NFSv4 ACLs as stored XDR encoded in the system.nfs4_acl extended attribute
+
+const (
+	nfs4Allow uint32 = 0
+	nfs4Deny  uint32 = 1
+
+	nfs4FileInherit      uint32 = 0x1
+	nfs4DirectoryInherit uint32 = 0x2
+	nfs4IdentifierGroup  uint32 = 0x40
+	nfs4Inherited        uint32 = 0x80
+
+	nfs4ReadData        uint32 = 0x1
+	nfs4WriteData       uint32 = 0x2
+	nfs4AppendData      uint32 = 0x4
+	nfs4ReadNamedAttrs  uint32 = 0x8
+	nfs4WriteNamedAttrs uint32 = 0x10
+	nfs4Execute         uint32 = 0x20
+	nfs4DeleteChild     uint32 = 0x40
+	nfs4ReadAttributes  uint32 = 0x80
+	nfs4WriteAttributes uint32 = 0x100
+	nfs4Delete          uint32 = 0x10000
+	nfs4ReadACL         uint32 = 0x20000
+	nfs4WriteACL        uint32 = 0x40000
+	nfs4WriteOwner      uint32 = 0x80000
+	nfs4Synchronize     uint32 = 0x100000
+
+	nfs4FullMask uint32 = 0x1f01ff
+
+	nfs4Owner    = "OWNER@"
+	nfs4Group    = "GROUP@"
+	nfs4Everyone = "EVERYONE@"
+)
+
+var (
+	nfs4Letters    = "rwaxdDtTcCo"
+	nfs4LetterMask = map[rune]uint32{
+		'r': nfs4ReadData | nfs4ReadNamedAttrs,
+		'w': nfs4WriteData | nfs4WriteNamedAttrs,
+		'a': nfs4AppendData,
+		'x': nfs4Execute,
+		'd': nfs4Delete,
+		'D': nfs4DeleteChild,
+		't': nfs4ReadAttributes,
+		'T': nfs4WriteAttributes,
+		'c': nfs4ReadACL,
+		'C': nfs4WriteACL,
+		'o': nfs4WriteOwner,
+	}
+)
+
+type nfs4ACE struct {
+	typ  uint32
+	flag uint32
+	mask uint32
+	who  string
+}
+
+func (a nfs4ACE) special() bool {
+	return strings.HasSuffix(a.who, "@")
+}
+
+func decodeNFS4(b []byte) ([]nfs4ACE, error) {
+	next := func() (uint32, error) {
+		if len(b) < 4 {
+			return 0, fmt.Errorf("truncated nfs4 acl")
+		}
+		v := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		return v, nil
+	}
+	n, err := next()
+	if err != nil {
+		return nil, err
+	}
+	aces := make([]nfs4ACE, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var a nfs4ACE
+		var l uint32
+		for _, f := range []*uint32{&a.typ, &a.flag, &a.mask, &l} {
+			if *f, err = next(); err != nil {
+				return nil, err
+			}
+		}
+		padded := (l + 3) &^ 3
+		if uint32(len(b)) < padded {
+			return nil, fmt.Errorf("truncated nfs4 acl")
+		}
+		a.who = string(b[:l])
+		b = b[padded:]
+		aces = append(aces, a)
+	}
+	return aces, nil
+}
+
+func encodeNFS4(aces []nfs4ACE) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(aces)))
+	for _, a := range aces {
+		b = binary.BigEndian.AppendUint32(b, a.typ)
+		b = binary.BigEndian.AppendUint32(b, a.flag)
+		b = binary.BigEndian.AppendUint32(b, a.mask)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(a.who)))
+		b = append(b, a.who...)
+		b = append(b, make([]byte, (4-len(a.who)%4)%4)...)
+	}
+	return b
+}
+
+func nfs4MaskFromMode(perm uint32, isDir bool) uint32 {
+	mask := nfs4ReadAttributes | nfs4ReadACL | nfs4Synchronize
+	if perm&4 != 0 {
+		mask |= nfs4ReadData | nfs4ReadNamedAttrs
+	}
+	if perm&2 != 0 {
+		mask |= nfs4WriteData | nfs4AppendData | nfs4WriteNamedAttrs
+		if isDir {
+			mask |= nfs4DeleteChild
+		}
+	}
+	if perm&1 != 0 {
+		mask |= nfs4Execute
+	}
+	return mask
+}
+
+// buildNFS4 renders the named entries into a complete ACL. The OWNER@, GROUP@ and EVERYONE@
+// entries are kept from the existing ACL or derived from the mode. Denials are placed first
+// because NFSv4 ACLs are evaluated in order.
+func buildNFS4(existing []nfs4ACE, mode uint32, entries []Entry, isDir bool) []nfs4ACE {
+	var owner, others []nfs4ACE
+	for _, a := range existing {
+		switch {
+		case !a.special():
+		case a.who == nfs4Owner:
+			owner = append(owner, a)
+		default:
+			others = append(others, a)
+		}
+	}
+	if len(owner) == 0 && len(others) == 0 {
+		owner = []nfs4ACE{{typ: nfs4Allow, mask: nfs4MaskFromMode(mode>>6&7, isDir) | nfs4WriteAttributes | nfs4WriteACL, who: nfs4Owner}}
+		others = []nfs4ACE{
+			{typ: nfs4Allow, flag: nfs4IdentifierGroup, mask: nfs4MaskFromMode(mode>>3&7, isDir), who: nfs4Group},
+			{typ: nfs4Allow, mask: nfs4MaskFromMode(mode&7, isDir), who: nfs4Everyone},
+		}
+	}
+
+	var flag uint32
+	if isDir {
+		flag = nfs4FileInherit | nfs4DirectoryInherit
+	}
+	var denied, allowed []nfs4ACE
+	for _, e := range MergeEntries(entries) {
+		a := nfs4ACE{typ: nfs4Allow, flag: flag, mask: e.nfs4Mask(), who: fmt.Sprint(e.ID)}
+		if e.Group {
+			a.flag |= nfs4IdentifierGroup
+		}
+		if e.Deny {
+			a.typ = nfs4Deny
+			denied = append(denied, a)
+		} else {
+			allowed = append(allowed, a)
+		}
+	}
+
+	acl := make([]nfs4ACE, 0, len(denied)+len(owner)+len(allowed)+len(others))
+	acl = append(acl, denied...)
+	acl = append(acl, owner...)
+	acl = append(acl, allowed...)
+	return append(acl, others...)
+}
+
+// entriesFromNFS4 returns the entries of the ACL that name a numeric uid or gid
+func entriesFromNFS4(acl []nfs4ACE) []Entry {
+	var entries []Entry
+	for _, a := range acl {
+		if a.special() || (a.typ != nfs4Allow && a.typ != nfs4Deny) {
+			continue
+		}
+		var id uint32
+		if _, err := fmt.Sscanf(a.who, "%d", &id); err != nil || fmt.Sprint(id) != a.who {
+			continue
+		}
+		e := Entry{Group: a.flag&nfs4IdentifierGroup != 0, ID: id}
+		if a.typ == nfs4Deny {
+			e.Deny = true
+		} else {
+			e.Permissions = permissionsFromNFS4(a.mask)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
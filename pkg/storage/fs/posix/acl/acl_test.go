@@ -0,0 +1,273 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package acl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/pkg/xattr"
+
+	"github.com/opencloud-eu/reva/v2/pkg/conversions"
+)
+
+type fakeResolver struct {
+	uids map[string]uint32
+	gids map[string]uint32
+}
+
+func (r fakeResolver) UIDNumber(_ context.Context, id *userpb.UserId) (uint32, error) {
+	if uid, ok := r.uids[id.GetOpaqueId()]; ok {
+		return uid, nil
+	}
+	return 0, errors.New("unknown user")
+}
+
+func (r fakeResolver) GIDNumber(_ context.Context, id *grouppb.GroupId) (uint32, error) {
+	if gid, ok := r.gids[id.GetOpaqueId()]; ok {
+		return gid, nil
+	}
+	return 0, errors.New("unknown group")
+}
+
+func (r fakeResolver) UserID(_ context.Context, uid uint32) (*userpb.UserId, error) {
+	for id, n := range r.uids {
+		if n == uid {
+			return &userpb.UserId{OpaqueId: id}, nil
+		}
+	}
+	return nil, errors.New("unknown uid")
+}
+
+func (r fakeResolver) GroupID(_ context.Context, gid uint32) (*grouppb.GroupId, error) {
+	for id, n := range r.gids {
+		if n == gid {
+			return &grouppb.GroupId{OpaqueId: id}, nil
+		}
+	}
+	return nil, errors.New("unknown gid")
+}
+
+var resolver = fakeResolver{
+	uids: map[string]uint32{"einstein": 2001, "marie": 2002},
+	gids: map[string]uint32{"physics": 3001},
+}
+
+func userGrant(id string, role *conversions.Role) *provider.Grant {
+	return &provider.Grant{
+		Grantee:     &provider.Grantee{Type: provider.GranteeType_GRANTEE_TYPE_USER, Id: &provider.Grantee_UserId{UserId: &userpb.UserId{OpaqueId: id}}},
+		Permissions: role.CS3ResourcePermissions(),
+	}
+}
+
+func groupGrant(id string, role *conversions.Role) *provider.Grant {
+	return &provider.Grant{
+		Grantee:     &provider.Grantee{Type: provider.GranteeType_GRANTEE_TYPE_GROUP, Id: &provider.Grantee_GroupId{GroupId: &grouppb.GroupId{OpaqueId: id}}},
+		Permissions: role.CS3ResourcePermissions(),
+	}
+}
+
+func TestEntriesFromGrants(t *testing.T) {
+	m, err := NewMirror(ModePosix, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := userGrant("marie", conversions.NewEditorRole())
+	expired.Expiration = &types.Timestamp{Seconds: 1}
+	entries, err := m.EntriesFromGrants(context.Background(), []*provider.Grant{
+		userGrant("einstein", conversions.NewViewerRole()),
+		userGrant("einstein", conversions.NewEditorRole()),
+		groupGrant("physics", conversions.NewDeniedRole()),
+		expired,
+		userGrant("unknown", conversions.NewViewerRole()),
+	})
+	if err == nil {
+		t.Error("expected an error for the unknown user")
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+
+	einstein, physics := entries[0], entries[1]
+	if einstein.Group || einstein.ID != 2001 || einstein.Deny {
+		t.Errorf("unexpected entry %+v", einstein)
+	}
+	if perm := einstein.posixPerm(true); perm != 7 {
+		t.Errorf("expected merged viewer and editor grants to map to rwx, got %o", perm)
+	}
+	if perm := einstein.posixPerm(false); perm != 6 {
+		t.Errorf("expected rw on files, got %o", perm)
+	}
+	if !physics.Group || physics.ID != 3001 || !physics.Deny || physics.posixPerm(true) != 0 {
+		t.Errorf("unexpected entry %+v", physics)
+	}
+}
+
+func TestPosixEncoding(t *testing.T) {
+	acl := buildPosix(nil, 0o750, []Entry{
+		{Group: true, ID: 3001, Permissions: "txr"},
+		{ID: 2002, Permissions: "txrwad"},
+		{ID: 2001, Deny: true},
+	}, true)
+
+	expected := []posixEntry{
+		{tag: tagUserObj, perm: 7, id: undefinedID},
+		{tag: tagUser, perm: 0, id: 2001},
+		{tag: tagUser, perm: 7, id: 2002},
+		{tag: tagGroupObj, perm: 5, id: undefinedID},
+		{tag: tagGroup, perm: 5, id: 3001},
+		{tag: tagMask, perm: 7, id: undefinedID},
+		{tag: tagOther, perm: 0, id: undefinedID},
+	}
+	decoded, err := decodePosix(encodePosix(acl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, decoded)
+	}
+	for i := range expected {
+		if decoded[i] != expected[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, expected[i], decoded[i])
+		}
+	}
+
+	entries := entriesFromPosix(decoded, true)
+	if len(entries) != 3 || !entries[0].Deny || entries[1].Permissions != "trxwad" || entries[2].Permissions != "trx" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	if _, err := decodePosix([]byte{1, 0, 0, 0}); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+func TestNFS4Encoding(t *testing.T) {
+	acl := buildNFS4(nil, 0o750, []Entry{
+		{ID: 2002, Permissions: "txrw"},
+		{Group: true, ID: 3001, Deny: true},
+	}, true)
+
+	decoded, err := decodeNFS4(encodeNFS4(acl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 5 {
+		t.Fatalf("expected 5 aces, got %+v", decoded)
+	}
+	if decoded[0].typ != nfs4Deny || decoded[0].who != "3001" || decoded[0].flag != nfs4FileInherit|nfs4DirectoryInherit|nfs4IdentifierGroup {
+		t.Errorf("expected the denial first, got %+v", decoded[0])
+	}
+	if decoded[1].who != nfs4Owner || decoded[3].who != nfs4Group || decoded[4].who != nfs4Everyone {
+		t.Errorf("unexpected order %+v", decoded)
+	}
+	if decoded[4].mask&nfs4ReadData != 0 {
+		t.Errorf("expected no read access for everyone, got %x", decoded[4].mask)
+	}
+
+	entries := entriesFromNFS4(decoded)
+	if len(entries) != 2 || !entries[0].Deny || entries[1].ID != 2002 || entries[1].Permissions != "rwxtc" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	// the special entries of an existing acl are kept
+	rebuilt := buildNFS4(decoded, 0o777, nil, true)
+	if len(rebuilt) != 3 || rebuilt[2].mask != decoded[4].mask {
+		t.Errorf("unexpected acl %+v", rebuilt)
+	}
+}
+
+func TestApplyAndImport(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dir")
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := xattr.Set(dir, PosixAccessAttr, encodePosix(buildPosix(nil, 0o750, nil, true))); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			t.Skip("posix acls are not supported")
+		}
+		t.Fatal(err)
+	}
+
+	m, err := NewMirror(ModePosix, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := m.EntriesFromGrants(context.Background(), []*provider.Grant{
+		userGrant("einstein", conversions.NewViewerRole()),
+		groupGrant("physics", conversions.NewEditorRole()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(dir, true, entries); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xattr.Get(dir, PosixDefaultAttr); err != nil {
+		t.Errorf("expected a default acl: %v", err)
+	}
+
+	grants, err := m.ImportGrants(context.Background(), dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 {
+		t.Fatalf("expected 2 grants, got %+v", grants)
+	}
+	if grants[0].GetGrantee().GetUserId().GetOpaqueId() != "einstein" || grants[0].GetPermissions().InitiateFileUpload {
+		t.Errorf("expected a viewer grant for einstein, got %+v", grants[0])
+	}
+	if grants[1].GetGrantee().GetGroupId().GetOpaqueId() != "physics" || !grants[1].GetPermissions().InitiateFileUpload {
+		t.Errorf("expected an editor grant for physics, got %+v", grants[1])
+	}
+
+	// children inherit the entries of the default acl, they are not imported again
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if grants, err := m.ImportGrants(context.Background(), file, false); err != nil || len(grants) != 0 {
+		t.Errorf("expected no grants for inherited entries, got %+v, %v", grants, err)
+	}
+
+	// removing all entries restores the mode
+	if err := m.Apply(dir, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o750 {
+		t.Errorf("expected mode 750, got %o", fi.Mode().Perm())
+	}
+	if entries, err := m.Read(dir, true); err != nil || len(entries) != 0 {
+		t.Errorf("expected no entries, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package acl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/pkg/xattr"
+
+	"github.com/opencloud-eu/reva/v2/pkg/conversions"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/metadata"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/ace"
+)
+
+const (
+	// ModePosix mirrors grants as POSIX.1e ACLs
+	ModePosix = "posix"
+	// ModeNFS4 mirrors grants as NFSv4 ACLs
+	ModeNFS4 = "nfs4"
+)
+
+// Mirror translates CS3 grants into ACLs on the local filesystem and back
+type Mirror struct {
+	mode     string
+	resolver Resolver
+}
+
+// NewMirror returns a new Mirror writing ACLs of the given mode
+func NewMirror(mode string, resolver Resolver) (*Mirror, error) {
+	switch mode {
+	case ModePosix, ModeNFS4:
+	default:
+		return nil, fmt.Errorf("unknown acl mode '%s', only 'posix' or 'nfs4' supported", mode)
+	}
+	return &Mirror{mode: mode, resolver: resolver}, nil
+}
+
+// EntriesFromGrants resolves the grantees of the given grants to uid and gid numbers.
+// Expired grants and grants to other grantee types are skipped. Grantees that cannot
+// be resolved are skipped as well and reported in the returned error.
+func (m *Mirror) EntriesFromGrants(ctx context.Context, grants []*provider.Grant) ([]Entry, error) {
+	var errs []error
+	entries := make([]Entry, 0, len(grants))
+	for _, g := range grants {
+		if exp := g.GetExpiration(); exp != nil && time.Unix(int64(exp.GetSeconds()), int64(exp.GetNanos())).Before(time.Now()) {
+			continue
+		}
+
+		a := ace.FromGrant(g)
+		e := Entry{Deny: a.Type() == "D", Permissions: a.Permissions()}
+		var err error
+		switch g.GetGrantee().GetType() {
+		case provider.GranteeType_GRANTEE_TYPE_USER:
+			e.ID, err = m.resolver.UIDNumber(ctx, g.GetGrantee().GetUserId())
+		case provider.GranteeType_GRANTEE_TYPE_GROUP:
+			e.Group = true
+			e.ID, err = m.resolver.GIDNumber(ctx, g.GetGrantee().GetGroupId())
+		default:
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not resolve grantee %s: %w", a.Principal(), err))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return MergeEntries(entries), errors.Join(errs...)
+}
+
+// Apply replaces the named entries of the ACL of the given path. Directories also get
+// a default ACL so that new children inherit the entries.
+func (m *Mirror) Apply(path string, isDir bool, entries []Entry) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		// ACLs can not be set on symlinks
+		return nil
+	}
+	mode := uint32(fi.Mode().Perm())
+
+	if m.mode == ModeNFS4 {
+		existing, err := m.readNFS4(path)
+		if err != nil {
+			return err
+		}
+		return xattr.Set(path, NFS4Attr, encodeNFS4(buildNFS4(existing, mode, entries, isDir)))
+	}
+
+	existing, err := readPosix(path, PosixAccessAttr)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return removePosix(path, existing, mode, isDir)
+	}
+	if err := xattr.Set(path, PosixAccessAttr, encodePosix(buildPosix(existing, mode, entries, isDir))); err != nil {
+		return err
+	}
+	if !isDir {
+		return nil
+	}
+	existingDefault, err := readPosix(path, PosixDefaultAttr)
+	if err != nil {
+		return err
+	}
+	if existingDefault == nil {
+		existingDefault = existing
+	}
+	return xattr.Set(path, PosixDefaultAttr, encodePosix(buildPosix(existingDefault, mode, entries, isDir)))
+}
+
+// Read returns the named entries of the ACL of the given path
+func (m *Mirror) Read(path string, isDir bool) ([]Entry, error) {
+	if m.mode == ModeNFS4 {
+		acl, err := m.readNFS4(path)
+		if err != nil {
+			return nil, err
+		}
+		return entriesFromNFS4(acl), nil
+	}
+	acl, err := readPosix(path, PosixAccessAttr)
+	if err != nil {
+		return nil, err
+	}
+	return entriesFromPosix(acl, isDir), nil
+}
+
+// ImportGrants translates the named entries of the ACL of the given path into grants.
+// Entries inherited from the parent directory are skipped. Read and write access maps to
+// the editor role, read access to the viewer role, write access on a directory to the
+// uploader role and entries without permissions to a denial.
+func (m *Mirror) ImportGrants(ctx context.Context, path string, isDir bool) ([]*provider.Grant, error) {
+	entries, err := m.explicitEntries(path, isDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	grants := make([]*provider.Grant, 0, len(entries))
+	for _, e := range entries {
+		role := roleForEntry(e, isDir)
+		if role == nil {
+			continue
+		}
+		g := &provider.Grant{Permissions: role.CS3ResourcePermissions()}
+		if e.Group {
+			id, err := m.resolver.GroupID(ctx, e.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not resolve gid %d: %w", e.ID, err))
+				continue
+			}
+			g.Grantee = &provider.Grantee{Type: provider.GranteeType_GRANTEE_TYPE_GROUP, Id: &provider.Grantee_GroupId{GroupId: id}}
+		} else {
+			id, err := m.resolver.UserID(ctx, e.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not resolve uid %d: %w", e.ID, err))
+				continue
+			}
+			g.Grantee = &provider.Grantee{Type: provider.GranteeType_GRANTEE_TYPE_USER, Id: &provider.Grantee_UserId{UserId: id}}
+		}
+		grants = append(grants, g)
+	}
+	return grants, errors.Join(errs...)
+}
+
+// explicitEntries returns the named entries of the ACL of the given path that were not
+// inherited from the default ACL or the inheritable entries of the parent directory
+func (m *Mirror) explicitEntries(path string, isDir bool) ([]Entry, error) {
+	if m.mode == ModeNFS4 {
+		acl, err := m.readNFS4(path)
+		if err != nil {
+			return nil, err
+		}
+		explicit := acl[:0]
+		for _, a := range acl {
+			if a.flag&nfs4Inherited == 0 {
+				explicit = append(explicit, a)
+			}
+		}
+		return entriesFromNFS4(explicit), nil
+	}
+
+	acl, err := readPosix(path, PosixAccessAttr)
+	if err != nil {
+		return nil, err
+	}
+	parentDefault, err := readPosix(filepath.Dir(path), PosixDefaultAttr)
+	if err != nil {
+		return nil, err
+	}
+	return entriesFromPosix(withoutNamed(acl, parentDefault), isDir), nil
+}
+
+func roleForEntry(e Entry, isDir bool) *conversions.Role {
+	read := strings.Contains(e.Permissions, "r")
+	write := strings.ContainsAny(e.Permissions, "wad")
+	switch {
+	case e.Deny:
+		return conversions.NewDeniedRole()
+	case read && write && isDir:
+		return conversions.NewEditorRole()
+	case read && write:
+		return conversions.NewFileEditorRole()
+	case read:
+		return conversions.NewViewerRole()
+	case write && isDir:
+		return conversions.NewUploaderRole()
+	default:
+		return nil
+	}
+}
+
+func readPosix(path, attr string) ([]posixEntry, error) {
+	b, err := xattr.Get(path, attr)
+	switch {
+	case metadata.IsAttrUnset(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return decodePosix(b)
+}
+
+// removePosix drops the ACLs and restores the permissions of the owning group,
+// which the kernel replaced with the mask while the ACL was set
+func removePosix(path string, existing []posixEntry, mode uint32, isDir bool) error {
+	if existing != nil {
+		if err := xattr.Remove(path, PosixAccessAttr); err != nil && !metadata.IsAttrUnset(err) {
+			return err
+		}
+		if perm, ok := groupObjPerm(existing); ok {
+			if err := os.Chmod(path, os.FileMode(mode&^0o070|uint32(perm)<<3)); err != nil {
+				return err
+			}
+		}
+	}
+	if isDir {
+		if err := xattr.Remove(path, PosixDefaultAttr); err != nil && !metadata.IsAttrUnset(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) readNFS4(path string) ([]nfs4ACE, error) {
+	b, err := xattr.Get(path, NFS4Attr)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNFS4(b)
+}
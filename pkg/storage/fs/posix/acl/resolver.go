@@ -0,0 +1,172 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package acl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bluele/gcache"
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	"google.golang.org/grpc/metadata"
+
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// Resolver maps users and groups to uid and gid numbers and back
+type Resolver interface {
+	UIDNumber(ctx context.Context, id *userpb.UserId) (uint32, error)
+	GIDNumber(ctx context.Context, id *grouppb.GroupId) (uint32, error)
+	UserID(ctx context.Context, uid uint32) (*userpb.UserId, error)
+	GroupID(ctx context.Context, gid uint32) (*grouppb.GroupId, error)
+}
+
+// GatewayResolver resolves uid and gid numbers using the user and group providers
+// behind the gateway. Lookups are cached.
+type GatewayResolver struct {
+	gatewayAddr          string
+	serviceAccountID     string
+	serviceAccountSecret string
+
+	cache gcache.Cache
+}
+
+// NewGatewayResolver returns a new GatewayResolver. The service account is used for
+// lookups when the context does not carry a token.
+func NewGatewayResolver(gatewayAddr, serviceAccountID, serviceAccountSecret string) *GatewayResolver {
+	return &GatewayResolver{
+		gatewayAddr:          gatewayAddr,
+		serviceAccountID:     serviceAccountID,
+		serviceAccountSecret: serviceAccountSecret,
+		cache:                gcache.New(1024).LRU().Expiration(10 * time.Minute).Build(),
+	}
+}
+
+// UIDNumber returns the uid number of the given user
+func (r *GatewayResolver) UIDNumber(ctx context.Context, id *userpb.UserId) (uint32, error) {
+	v, err := r.cached(ctx, "uidnumber:"+id.GetIdp()+"!"+id.GetOpaqueId(), func(ctx context.Context, gwc gateway.GatewayAPIClient) (interface{}, error) {
+		res, err := gwc.GetUser(ctx, &userpb.GetUserRequest{UserId: id, SkipFetchingUserGroups: true})
+		if err := checkStatus(res.GetStatus(), err); err != nil {
+			return nil, err
+		}
+		return toID(res.GetUser().GetUidNumber())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(uint32), nil
+}
+
+// GIDNumber returns the gid number of the given group
+func (r *GatewayResolver) GIDNumber(ctx context.Context, id *grouppb.GroupId) (uint32, error) {
+	v, err := r.cached(ctx, "gidnumber:"+id.GetIdp()+"!"+id.GetOpaqueId(), func(ctx context.Context, gwc gateway.GatewayAPIClient) (interface{}, error) {
+		res, err := gwc.GetGroup(ctx, &grouppb.GetGroupRequest{GroupId: id, SkipFetchingMembers: true})
+		if err := checkStatus(res.GetStatus(), err); err != nil {
+			return nil, err
+		}
+		return toID(res.GetGroup().GetGidNumber())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(uint32), nil
+}
+
+// UserID returns the id of the user with the given uid number
+func (r *GatewayResolver) UserID(ctx context.Context, uid uint32) (*userpb.UserId, error) {
+	v, err := r.cached(ctx, "uid:"+strconv.FormatUint(uint64(uid), 10), func(ctx context.Context, gwc gateway.GatewayAPIClient) (interface{}, error) {
+		res, err := gwc.GetUserByClaim(ctx, &userpb.GetUserByClaimRequest{Claim: "uid", Value: strconv.FormatUint(uint64(uid), 10), SkipFetchingUserGroups: true})
+		if err := checkStatus(res.GetStatus(), err); err != nil {
+			return nil, err
+		}
+		return res.GetUser().GetId(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*userpb.UserId), nil
+}
+
+// GroupID returns the id of the group with the given gid number
+func (r *GatewayResolver) GroupID(ctx context.Context, gid uint32) (*grouppb.GroupId, error) {
+	v, err := r.cached(ctx, "gid:"+strconv.FormatUint(uint64(gid), 10), func(ctx context.Context, gwc gateway.GatewayAPIClient) (interface{}, error) {
+		res, err := gwc.GetGroupByClaim(ctx, &grouppb.GetGroupByClaimRequest{Claim: "gid_number", Value: strconv.FormatUint(uint64(gid), 10), SkipFetchingMembers: true})
+		if err := checkStatus(res.GetStatus(), err); err != nil {
+			return nil, err
+		}
+		return res.GetGroup().GetId(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*grouppb.GroupId), nil
+}
+
+func (r *GatewayResolver) cached(ctx context.Context, key string, lookup func(context.Context, gateway.GatewayAPIClient) (interface{}, error)) (interface{}, error) {
+	if v, err := r.cache.Get(key); err == nil {
+		return v, nil
+	}
+	gwc, err := pool.GetGatewayServiceClient(r.gatewayAddr)
+	if err != nil {
+		return nil, err
+	}
+	if ctx, err = r.authenticate(ctx, gwc); err != nil {
+		return nil, err
+	}
+	v, err := lookup(ctx, gwc)
+	if err != nil {
+		return nil, err
+	}
+	_ = r.cache.Set(key, v)
+	return v, nil
+}
+
+func (r *GatewayResolver) authenticate(ctx context.Context, gwc gateway.GatewayAPIClient) (context.Context, error) {
+	if token, ok := ctxpkg.ContextGetToken(ctx); ok {
+		return metadata.AppendToOutgoingContext(ctx, ctxpkg.TokenHeader, token), nil
+	}
+	if r.serviceAccountID == "" {
+		return nil, fmt.Errorf("no token in context and no service account configured")
+	}
+	return utils.GetServiceUserContextWithContext(ctx, gwc, r.serviceAccountID, r.serviceAccountSecret)
+}
+
+func checkStatus(status *rpc.Status, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case status.GetCode() != rpc.Code_CODE_OK:
+		return fmt.Errorf("%s: %s", status.GetCode(), status.GetMessage())
+	}
+	return nil
+}
+
+func toID(n int64) (uint32, error) {
+	if n <= 0 || n >= int64(undefinedID) {
+		return 0, fmt.Errorf("invalid id number %d", n)
+	}
+	return uint32(n), nil
+}
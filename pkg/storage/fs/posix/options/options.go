@@ -44,6 +44,24 @@ type Options struct {
 	WatchType               string `mapstructure:"watch_type"`
 	WatchPath               string `mapstructure:"watch_path"`
 	WatchFolderKafkaBrokers string `mapstructure:"watch_folder_kafka_brokers"`
+
+	// Settings of the poll watcher. The watch path can be a comma separated list of
+	// directories to poll, it defaults to the root. The full scan interval defaults to
+	// an hour, a negative value disables the periodic full scans.
+	WatchPollInterval         time.Duration `mapstructure:"watch_poll_interval"`
+	WatchPollMode             string        `mapstructure:"watch_poll_mode"`
+	WatchPollFullScanInterval time.Duration `mapstructure:"watch_poll_full_scan_interval"`
+
+	// Mirror the CS3 grants as ACLs on the local filesystem, either "posix" for POSIX.1e
+	// ACLs or "nfs4" for NFSv4 ACLs. Grants are mapped to the uid and gid numbers of the
+	// grantees. Empty disables mirroring.
+	ACLMode string `mapstructure:"acl_mode"`
+	// Import the ACLs of files and directories as grants when assimilating them.
+	ACLImport bool `mapstructure:"acl_import"`
+	// The service account is used to resolve uid and gid numbers when there is no user
+	// in the context, e.g. during assimilation.
+	ServiceAccountID     string `mapstructure:"service_account_id"`
+	ServiceAccountSecret string `mapstructure:"service_account_secret"`
 }
 
 // New returns a new Options instance for the given configuration
@@ -26,8 +26,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/rs/zerolog"
 	tusd "github.com/tus/tusd/v2/pkg/handler"
 	microstore "go-micro.dev/v4/store"
@@ -35,6 +37,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/events"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/posix/acl"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/posix/blobstore"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/posix/lookup"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/posix/options"
@@ -62,6 +65,12 @@ type posixFS struct {
 	storage.FS
 
 	um usermapper.Mapper
+
+	lu    *lookup.Lookup
+	tp    *tree.Tree
+	acls  *acl.Mirror
+	aclMu sync.Mutex
+	log   *zerolog.Logger
 }
 
 // New returns an implementation to of the storage.FS interface that talk to
@@ -181,13 +190,131 @@ func New(m map[string]interface{}, stream events.Stream, log *zerolog.Logger) (s
 		hooks = append(hooks, resolveSpaceHook, scopeSpaceGroupHook)
 	}
 
+	if o.ACLMode != "" {
+		m, err := acl.NewMirror(o.ACLMode, acl.NewGatewayResolver(o.GatewayAddr, o.ServiceAccountID, o.ServiceAccountSecret))
+		if err != nil {
+			return nil, err
+		}
+		fs.acls = m
+		if o.ACLImport {
+			tp.SetGrantImporter(m)
+		}
+	}
+
 	mw := middleware.NewFS(dfs, hooks...)
 	fs.FS = mw
 	fs.um = um
+	fs.lu = lu
+	fs.tp = tp
+	fs.log = log
 
 	return fs, nil
 }
 
+// AddGrant adds a grant to a resource and mirrors it to the ACLs
+func (fs *posixFS) AddGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	if err := fs.FS.AddGrant(ctx, ref, g); err != nil {
+		return err
+	}
+	fs.syncACLs(ctx, ref)
+	return nil
+}
+
+// DenyGrant denies access to a resource and mirrors the denial to the ACLs
+func (fs *posixFS) DenyGrant(ctx context.Context, ref *provider.Reference, g *provider.Grantee) error {
+	if err := fs.FS.DenyGrant(ctx, ref, g); err != nil {
+		return err
+	}
+	fs.syncACLs(ctx, ref)
+	return nil
+}
+
+// UpdateGrant updates a grant on a resource and mirrors it to the ACLs
+func (fs *posixFS) UpdateGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	if err := fs.FS.UpdateGrant(ctx, ref, g); err != nil {
+		return err
+	}
+	fs.syncACLs(ctx, ref)
+	return nil
+}
+
+// RemoveGrant removes a grant from a resource and removes it from the ACLs
+func (fs *posixFS) RemoveGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	if err := fs.FS.RemoveGrant(ctx, ref, g); err != nil {
+		return err
+	}
+	fs.syncACLs(ctx, ref)
+	return nil
+}
+
+// syncACLs rewrites the ACLs of the referenced resource and its descendants in the background.
+// As grants apply to the whole subtree every node gets the grants of its ancestors as well.
+func (fs *posixFS) syncACLs(ctx context.Context, ref *provider.Reference) {
+	if fs.acls == nil {
+		return
+	}
+	n, err := fs.lu.NodeFromResource(ctx, ref)
+	if err != nil {
+		fs.log.Error().Err(err).Interface("ref", ref).Msg("could not resolve node to sync acls")
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		fs.aclMu.Lock()
+		defer fs.aclMu.Unlock()
+
+		var inherited []*provider.Grant
+		for p := n; !p.IsSpaceRoot(ctx); {
+			if p, err = p.Parent(ctx); err != nil {
+				fs.log.Error().Err(err).Str("spaceid", n.SpaceID).Str("nodeid", n.ID).Msg("could not read ancestors to sync acls")
+				return
+			}
+			grants, err := p.ListGrants(ctx)
+			if err != nil {
+				fs.log.Error().Err(err).Str("spaceid", p.SpaceID).Str("nodeid", p.ID).Msg("could not list grants to sync acls")
+				return
+			}
+			inherited = append(inherited, grants...)
+		}
+		entries, err := fs.acls.EntriesFromGrants(ctx, inherited)
+		if err != nil {
+			fs.log.Error().Err(err).Str("spaceid", n.SpaceID).Str("nodeid", n.ID).Msg("could not resolve all inherited grants")
+		}
+		fs.applyACLs(ctx, n, entries)
+	}()
+}
+
+func (fs *posixFS) applyACLs(ctx context.Context, n *node.Node, inherited []acl.Entry) {
+	grants, err := n.ListGrants(ctx)
+	if err != nil {
+		fs.log.Error().Err(err).Str("spaceid", n.SpaceID).Str("nodeid", n.ID).Msg("could not list grants to sync acls")
+		return
+	}
+	own, err := fs.acls.EntriesFromGrants(ctx, grants)
+	if err != nil {
+		fs.log.Error().Err(err).Str("spaceid", n.SpaceID).Str("nodeid", n.ID).Msg("could not resolve all grants")
+	}
+	entries := acl.MergeEntries(append(own, inherited...))
+
+	isDir := n.IsDir(ctx)
+	if err := fs.acls.Apply(n.InternalPath(), isDir, entries); err != nil {
+		fs.log.Error().Err(err).Str("path", n.InternalPath()).Msg("could not apply acls")
+		return
+	}
+	if !isDir {
+		return
+	}
+	children, err := fs.tp.ListFolder(ctx, n)
+	if err != nil {
+		fs.log.Error().Err(err).Str("path", n.InternalPath()).Msg("could not list children to sync acls")
+		return
+	}
+	for _, child := range children {
+		fs.applyACLs(ctx, child, entries)
+	}
+}
+
 // ListUploadSessions returns the upload sessions matching the given filter
 func (fs *posixFS) ListUploadSessions(ctx context.Context, filter storage.UploadSessionFilter) ([]storage.UploadSession, error) {
 	return fs.FS.(storage.UploadSessionLister).ListUploadSessions(ctx, filter)
@@ -42,6 +42,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/metadata"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/metadata/prefixes"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/node"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/ace"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 )
 
@@ -606,6 +607,19 @@ assimilate:
 		attributes.SetInt64(prefixes.TypeAttr, int64(provider.ResourceType_RESOURCE_TYPE_FILE))
 		n = node.New(spaceID, id, parentID, filepath.Base(path), fi.Size(), blobID, provider.ResourceType_RESOURCE_TYPE_FILE, nil, t.lookup)
 	}
+	if t.grantImporter != nil {
+		grants, err := t.grantImporter.ImportGrants(context.Background(), path, fi.IsDir())
+		if err != nil {
+			t.log.Error().Err(err).Str("path", path).Msg("could not import grants")
+		}
+		for _, g := range grants {
+			principal, value := ace.FromGrant(g).Marshal()
+			// grants managed by the storage take precedence
+			if _, ok := previousAttribs[prefixes.GrantPrefix+principal]; !ok {
+				attributes[prefixes.GrantPrefix+principal] = value
+			}
+		}
+	}
 	attributes.SetTime(prefixes.MTimeAttr, fi.ModTime())
 
 	n.SpaceRoot = &node.Node{BaseNode: node.BaseNode{SpaceID: spaceID, ID: spaceID}}
@@ -0,0 +1,312 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package tree
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// pollTree is the part of the tree the polling watcher works on
+type pollTree interface {
+	Scan(path string, action EventAction, isDir bool) error
+	isIgnored(path string) bool
+	isDirty(path string) (bool, error)
+	setDirty(path string, dirty bool) error
+	knownPath(path string) bool
+	storedMTime(path string) (time.Time, bool)
+}
+
+type polledEntry struct {
+	isDir bool
+	mtime time.Time
+	size  int64
+}
+
+type polledDir struct {
+	mtime   time.Time
+	entries map[string]polledEntry
+}
+
+// PollingWatcher detects changes by periodically walking the watched directories. It is meant
+// for network filesystems like NFS or SMB where inotify does not see changes made by other clients.
+//
+// In incremental mode directories whose mtime did not change since the last run and that are not
+// marked dirty are not listed again, only their known files are checked for a changed mtime or size.
+// The periodic full scans, hourly by default, compare every entry with its stored metadata to catch
+// anything else. In full mode every directory is listed and every entry is compared with its stored
+// metadata on every run.
+type PollingWatcher struct {
+	tree             pollTree
+	interval         time.Duration
+	fullScanInterval time.Duration
+	full             bool
+	log              *zerolog.Logger
+
+	dirs     map[string]*polledDir
+	lastFull time.Time
+}
+
+// NewPollingWatcher returns a new PollingWatcher
+func NewPollingWatcher(tree *Tree, interval, fullScanInterval time.Duration, mode string, log *zerolog.Logger) (*PollingWatcher, error) {
+	return newPollingWatcher(tree, interval, fullScanInterval, mode, log)
+}
+
+func newPollingWatcher(tree pollTree, interval, fullScanInterval time.Duration, mode string, log *zerolog.Logger) (*PollingWatcher, error) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	switch {
+	case fullScanInterval == 0:
+		fullScanInterval = time.Hour
+	case fullScanInterval < 0:
+		// only the first run is a full scan
+		fullScanInterval = 0
+	}
+	w := &PollingWatcher{
+		tree:             tree,
+		interval:         interval,
+		fullScanInterval: fullScanInterval,
+		log:              log,
+		dirs:             map[string]*polledDir{},
+	}
+	switch mode {
+	case "", "incremental":
+	case "full":
+		w.full = true
+	default:
+		return nil, fmt.Errorf("unknown poll mode '%s'. Use incremental or full", mode)
+	}
+	return w, nil
+}
+
+// Watch polls the given comma separated list of directories
+func (w *PollingWatcher) Watch(path string) {
+	var roots []string
+	for _, p := range strings.Split(path, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			roots = append(roots, filepath.Clean(p))
+		}
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.poll(roots)
+		<-ticker.C
+	}
+}
+
+// poll walks the roots once and feeds the detected changes to the tree
+func (w *PollingWatcher) poll(roots []string) {
+	full := w.full || w.lastFull.IsZero() ||
+		(w.fullScanInterval > 0 && time.Since(w.lastFull) >= w.fullScanInterval)
+	start := time.Now()
+	for _, root := range roots {
+		w.scanDir(root, full)
+	}
+	if full {
+		w.lastFull = start
+	}
+	w.log.Debug().Bool("full", full).Dur("duration", time.Since(start)).Msg("polled for changes")
+}
+
+func (w *PollingWatcher) scanDir(path string, full bool) {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		// deleted directories are handled when their parent is scanned
+		return
+	}
+	prev := w.dirs[path]
+	dirty, _ := w.tree.isDirty(path)
+
+	if !full && !dirty && prev != nil && prev.mtime.Equal(fi.ModTime()) {
+		// no entries were added or removed, editing a file in place does not change the mtime
+		// of the directory though
+		w.checkFiles(path, prev)
+		for name, e := range prev.entries {
+			if e.isDir {
+				w.scanDir(filepath.Join(path, name), full)
+			}
+		}
+		return
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		w.log.Error().Err(err).Str("path", path).Msg("could not list directory")
+		return
+	}
+
+	cur := &polledDir{mtime: fi.ModTime(), entries: make(map[string]polledEntry, len(entries))}
+	// without a previous listing or when the directory is marked dirty we can not tell what
+	// changed, so the entries are compared with their stored metadata
+	compareStored := full || dirty || prev == nil
+	changed := false
+	for _, entry := range entries {
+		p := filepath.Join(path, entry.Name())
+		if w.tree.isIgnored(p) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // the entry was removed in the meantime
+		}
+		e := polledEntry{isDir: info.IsDir(), mtime: info.ModTime(), size: info.Size()}
+		cur.entries[entry.Name()] = e
+
+		var old polledEntry
+		known := false
+		if prev != nil {
+			old, known = prev.entries[entry.Name()]
+		}
+		switch {
+		case compareStored:
+			if action, ok := w.compare(p, info); ok {
+				changed = true
+				w.scan(p, action, e.isDir)
+			}
+		case !known || old.isDir != e.isDir:
+			changed = true
+			w.scan(p, ActionCreate, e.isDir)
+		case !e.isDir && (!old.mtime.Equal(e.mtime) || old.size != e.size):
+			changed = true
+			w.scan(p, ActionUpdate, false)
+		}
+	}
+
+	if prev != nil {
+		for name, old := range prev.entries {
+			if e, ok := cur.entries[name]; ok && e.isDir == old.isDir {
+				continue
+			}
+			p := filepath.Join(path, name)
+			if old.isDir {
+				w.forget(p)
+			}
+			if w.tree.knownPath(p) {
+				changed = true
+				w.scan(p, ActionDelete, old.isDir)
+			}
+		}
+	}
+	w.dirs[path] = cur
+
+	switch {
+	case changed:
+		// remember the directory until the changes were assimilated
+		if err := w.tree.setDirty(path, true); err != nil {
+			w.log.Error().Err(err).Str("path", path).Msg("could not mark directory dirty")
+		}
+	case dirty:
+		// all entries are in sync with their metadata
+		if err := w.tree.setDirty(path, false); err != nil {
+			w.log.Error().Err(err).Str("path", path).Msg("could not clear dirty flag")
+		}
+	}
+
+	for name, e := range cur.entries {
+		if e.isDir {
+			w.scanDir(filepath.Join(path, name), full)
+		}
+	}
+}
+
+// checkFiles detects in place changes of the known files of a directory that was not listed
+func (w *PollingWatcher) checkFiles(path string, prev *polledDir) {
+	changed := false
+	for name, old := range prev.entries {
+		if old.isDir {
+			continue
+		}
+		p := filepath.Join(path, name)
+		info, err := os.Lstat(p)
+		if err != nil || info.IsDir() {
+			// the entry was replaced in the meantime, the next listing handles it
+			continue
+		}
+		if old.mtime.Equal(info.ModTime()) && old.size == info.Size() {
+			continue
+		}
+		prev.entries[name] = polledEntry{mtime: info.ModTime(), size: info.Size()}
+		changed = true
+		w.scan(p, ActionUpdate, false)
+	}
+	if changed {
+		if err := w.tree.setDirty(path, true); err != nil {
+			w.log.Error().Err(err).Str("path", path).Msg("could not mark directory dirty")
+		}
+	}
+}
+
+// compare returns the action needed to bring the metadata of the entry in sync
+func (w *PollingWatcher) compare(path string, info fs.FileInfo) (EventAction, bool) {
+	mtime, ok := w.tree.storedMTime(path)
+	switch {
+	case !ok:
+		return ActionCreate, true
+	case !info.IsDir() && !mtime.Equal(info.ModTime()):
+		return ActionUpdate, true
+	}
+	return 0, false
+}
+
+func (w *PollingWatcher) scan(path string, action EventAction, isDir bool) {
+	if err := w.tree.Scan(path, action, isDir); err != nil {
+		w.log.Error().Err(err).Str("path", path).Msg("error scanning file")
+	}
+}
+
+// forget drops the listings of a removed directory and its subdirectories
+func (w *PollingWatcher) forget(path string) {
+	prefix := path + string(filepath.Separator)
+	for p := range w.dirs {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(w.dirs, p)
+		}
+	}
+}
+
+// knownPath returns true if the path is in the id cache
+func (t *Tree) knownPath(path string) bool {
+	_, _, err := t.lookup.IDsForPath(context.Background(), path)
+	return err == nil
+}
+
+// storedMTime returns the mtime stored in the metadata of the path. It returns false if the
+// path has not been assimilated at its current location.
+func (t *Tree) storedMTime(path string) (time.Time, bool) {
+	_, id, mtime, err := t.lookup.MetadataBackend().IdentifyPath(context.Background(), path)
+	if err != nil || id == "" {
+		return time.Time{}, false
+	}
+	// a copied item carries the id of its source
+	_, cachedID, err := t.lookup.IDsForPath(context.Background(), path)
+	if err != nil || cachedID != id {
+		return time.Time{}, false
+	}
+	return mtime, true
+}
@@ -0,0 +1,215 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type scanCall struct {
+	path   string
+	action EventAction
+	isDir  bool
+}
+
+// fakePollTree records the scans and treats every scanned path as assimilated
+type fakePollTree struct {
+	scans  []scanCall
+	known  map[string]time.Time
+	dirty  map[string]bool
+	ignore string
+}
+
+func newFakePollTree() *fakePollTree {
+	return &fakePollTree{known: map[string]time.Time{}, dirty: map[string]bool{}}
+}
+
+func (f *fakePollTree) Scan(path string, action EventAction, isDir bool) error {
+	f.scans = append(f.scans, scanCall{path, action, isDir})
+	if action == ActionDelete {
+		delete(f.known, path)
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err == nil {
+		f.known[path] = fi.ModTime()
+	}
+	return nil
+}
+
+func (f *fakePollTree) isIgnored(path string) bool {
+	return f.ignore != "" && strings.HasSuffix(path, f.ignore)
+}
+
+func (f *fakePollTree) isDirty(path string) (bool, error) {
+	return f.dirty[path], nil
+}
+
+func (f *fakePollTree) setDirty(path string, dirty bool) error {
+	f.dirty[path] = dirty
+	return nil
+}
+
+func (f *fakePollTree) knownPath(path string) bool {
+	_, ok := f.known[path]
+	return ok
+}
+
+func (f *fakePollTree) storedMTime(path string) (time.Time, bool) {
+	mtime, ok := f.known[path]
+	return mtime, ok
+}
+
+// takeScans returns the recorded scans relative to root and resets them
+func (f *fakePollTree) takeScans(root string) []string {
+	var scans []string
+	for _, s := range f.scans {
+		action := map[EventAction]string{ActionCreate: "create", ActionUpdate: "update", ActionDelete: "delete"}[s.action]
+		scans = append(scans, action+" "+strings.TrimPrefix(s.path, root))
+	}
+	sort.Strings(scans)
+	f.scans = nil
+	return scans
+}
+
+func expectScans(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got scans %v, want %v", got, want)
+	}
+}
+
+// touch changes the mtime of the path to a distinct value
+func touch(t *testing.T, path string, offset int) {
+	t.Helper()
+	mtime := time.Now().Add(time.Duration(offset) * time.Hour)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollingWatcher(t *testing.T) {
+	root := t.TempDir()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.MkdirAll(filepath.Join(root, "a", "b"), 0700))
+	must(os.WriteFile(filepath.Join(root, "a", "file.txt"), []byte("1"), 0600))
+	must(os.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), []byte("1"), 0600))
+	must(os.WriteFile(filepath.Join(root, "upload.lock"), nil, 0600))
+
+	tree := newFakePollTree()
+	tree.ignore = ".lock"
+	nop := zerolog.Nop()
+	w, err := newPollingWatcher(tree, time.Second, -1, "incremental", &nop)
+	must(err)
+
+	// the first run compares everything with the stored metadata
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "create /a", "create /a/b", "create /a/b/deep.txt", "create /a/file.txt")
+	if !tree.dirty[root] || !tree.dirty[filepath.Join(root, "a")] {
+		t.Error("changed directories should be marked dirty")
+	}
+	// the tree clears the flags after assimilating
+	tree.dirty = map[string]bool{}
+
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root))
+
+	// new and removed entries change the mtime of their directory
+	must(os.WriteFile(filepath.Join(root, "a", "b", "new.txt"), []byte("1"), 0600))
+	must(os.Remove(filepath.Join(root, "a", "file.txt")))
+	touch(t, filepath.Join(root, "a"), 1)
+	touch(t, filepath.Join(root, "a", "b"), 1)
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "create /a/b/new.txt", "delete /a/file.txt")
+	tree.dirty = map[string]bool{}
+
+	// content changes in unchanged directories are detected by checking the known files
+	dirInfo, err := os.Stat(filepath.Join(root, "a", "b"))
+	must(err)
+	must(os.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), []byte("22"), 0600))
+	touch(t, filepath.Join(root, "a", "b", "deep.txt"), 2)
+	must(os.Chtimes(filepath.Join(root, "a", "b"), dirInfo.ModTime(), dirInfo.ModTime()))
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "update /a/b/deep.txt")
+	if !tree.dirty[filepath.Join(root, "a", "b")] {
+		t.Error("directory should be marked dirty when a file changed")
+	}
+
+	// dirty directories are always listed
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root))
+	if tree.dirty[filepath.Join(root, "a", "b")] {
+		t.Error("dirty flag should be cleared when the directory is in sync")
+	}
+	tree.known[filepath.Join(root, "a", "b", "deep.txt")] = time.Time{}
+	tree.dirty[filepath.Join(root, "a", "b")] = true
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "update /a/b/deep.txt")
+	if !tree.dirty[filepath.Join(root, "a", "b")] {
+		t.Error("directory should stay dirty until the changes are assimilated")
+	}
+	tree.dirty = map[string]bool{}
+
+	// removed directories are forgotten
+	must(os.RemoveAll(filepath.Join(root, "a", "b")))
+	touch(t, filepath.Join(root, "a"), 3)
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "delete /a/b")
+	if _, ok := w.dirs[filepath.Join(root, "a", "b")]; ok {
+		t.Error("listing of removed directory should be dropped")
+	}
+}
+
+func TestPollingWatcherFullMode(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tree := newFakePollTree()
+	nop := zerolog.Nop()
+	w, err := newPollingWatcher(tree, time.Second, 0, "full", &nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "create /file.txt")
+
+	// the stored metadata is compared even if the directory did not change
+	touch(t, filepath.Join(root, "file.txt"), 1)
+	touch(t, root, 0)
+	w.poll([]string{root})
+	expectScans(t, tree.takeScans(root), "update /file.txt")
+
+	if _, err := newPollingWatcher(tree, time.Second, 0, "sometimes", &nop); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
@@ -95,10 +95,17 @@ type Tree struct {
 	scanQueue     chan scanItem
 	scanDebouncer *ScanDebouncer
 
+	grantImporter GrantImporter
+
 	es  events.Stream
 	log *zerolog.Logger
 }
 
+// GrantImporter reads grants from the local filesystem, e.g. from ACLs
+type GrantImporter interface {
+	ImportGrants(ctx context.Context, path string, isDir bool) ([]*provider.Grant, error)
+}
+
 // PermissionCheckFunc defined a function used to check resource permissions
 type PermissionCheckFunc func(rp *provider.ResourcePermissions) bool
 
@@ -130,6 +137,14 @@ func New(lu node.PathLookup, bs Blobstore, um usermapper.Mapper, trashbin *trash
 		if err != nil {
 			return nil, err
 		}
+	case "poll":
+		t.watcher, err = NewPollingWatcher(t, o.WatchPollInterval, o.WatchPollFullScanInterval, o.WatchPollMode, log)
+		if err != nil {
+			return nil, err
+		}
+		if watchPath == "" {
+			watchPath = o.Root
+		}
 	case "gpfsfileauditlogging":
 		t.watcher, err = NewGpfsFileAuditLoggingWatcher(t, o.WatchPath, log)
 		if err != nil {
@@ -164,6 +179,11 @@ func (t *Tree) PublishEvent(ev interface{}) {
 	}
 }
 
+// SetGrantImporter sets the importer used to pick up grants when assimilating files and directories
+func (t *Tree) SetGrantImporter(gi GrantImporter) {
+	t.grantImporter = gi
+}
+
 // Setup prepares the tree structure
 func (t *Tree) Setup() error {
 	err := os.MkdirAll(t.options.Root, 0700)
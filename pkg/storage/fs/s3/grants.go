@@ -0,0 +1,198 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// grants and arbitrary metadata are kept in the node record
+
+func granteeKey(g *provider.Grantee) string {
+	if g.GetType() == provider.GranteeType_GRANTEE_TYPE_GROUP {
+		return "g:" + g.GetGroupId().GetOpaqueId()
+	}
+	return "u:" + g.GetUserId().GetOpaqueId()
+}
+
+func (fs *s3FS) AddGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	return fs.updateGrants(ctx, ref, func(grants map[string]*provider.Grant) error {
+		k := granteeKey(g.GetGrantee())
+		if _, ok := grants[k]; ok {
+			return errtypes.AlreadyExists(k)
+		}
+		grants[k] = g
+		return nil
+	})
+}
+
+// DenyGrant stores a grant without permissions for the grantee
+func (fs *s3FS) DenyGrant(ctx context.Context, ref *provider.Reference, g *provider.Grantee) error {
+	return fs.updateGrants(ctx, ref, func(grants map[string]*provider.Grant) error {
+		grants[granteeKey(g)] = &provider.Grant{
+			Grantee:     g,
+			Permissions: &provider.ResourcePermissions{},
+		}
+		return nil
+	})
+}
+
+func (fs *s3FS) ListGrants(ctx context.Context, ref *provider.Reference) ([]*provider.Grant, error) {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	rec, _, err := fs.readRecord(ctx, n.id)
+	switch err.(type) {
+	case nil:
+	case errtypes.IsNotFound:
+		return []*provider.Grant{}, nil
+	default:
+		return nil, err
+	}
+
+	byGrantee, err := decodeGrants(rec)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(byGrantee))
+	for k := range byGrantee {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	grants := make([]*provider.Grant, 0, len(keys))
+	for _, k := range keys {
+		grants = append(grants, byGrantee[k])
+	}
+	return grants, nil
+}
+
+func (fs *s3FS) RemoveGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	return fs.updateGrants(ctx, ref, func(grants map[string]*provider.Grant) error {
+		k := granteeKey(g.GetGrantee())
+		if _, ok := grants[k]; !ok {
+			return errtypes.NotFound(k)
+		}
+		delete(grants, k)
+		return nil
+	})
+}
+
+func (fs *s3FS) UpdateGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	return fs.updateGrants(ctx, ref, func(grants map[string]*provider.Grant) error {
+		k := granteeKey(g.GetGrantee())
+		if _, ok := grants[k]; !ok {
+			return errtypes.NotFound(k)
+		}
+		grants[k] = g
+		return nil
+	})
+}
+
+func (fs *s3FS) updateGrants(ctx context.Context, ref *provider.Reference, update func(map[string]*provider.Grant) error) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+	id, err := fs.ensureID(ctx, n)
+	if err != nil {
+		return err
+	}
+	return fs.updateRecord(ctx, id, func(rec *nodeRecord) error {
+		grants, err := decodeGrants(rec)
+		if err != nil {
+			return err
+		}
+		if err := update(grants); err != nil {
+			return err
+		}
+		rec.Grants = make(map[string]json.RawMessage, len(grants))
+		for k, g := range grants {
+			if rec.Grants[k], err = utils.MarshalProtoV1ToJSON(g); err != nil {
+				return errors.Wrap(err, "s3fs: error encoding grant")
+			}
+		}
+		return nil
+	})
+}
+
+func decodeGrants(rec *nodeRecord) (map[string]*provider.Grant, error) {
+	grants := make(map[string]*provider.Grant, len(rec.Grants))
+	for k, b := range rec.Grants {
+		g := &provider.Grant{}
+		if err := utils.UnmarshalJSONToProtoV1(b, g); err != nil {
+			return nil, errors.Wrap(err, "s3fs: error decoding grant")
+		}
+		grants[k] = g
+	}
+	return grants, nil
+}
+
+func (fs *s3FS) SetArbitraryMetadata(ctx context.Context, ref *provider.Reference, md *provider.ArbitraryMetadata) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkLock(ctx, n); err != nil {
+		return err
+	}
+	id, err := fs.ensureID(ctx, n)
+	if err != nil {
+		return err
+	}
+	return fs.updateRecord(ctx, id, func(rec *nodeRecord) error {
+		if rec.Metadata == nil {
+			rec.Metadata = map[string]string{}
+		}
+		for k, v := range md.GetMetadata() {
+			rec.Metadata[k] = v
+		}
+		return nil
+	})
+}
+
+func (fs *s3FS) UnsetArbitraryMetadata(ctx context.Context, ref *provider.Reference, keys []string) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkLock(ctx, n); err != nil {
+		return err
+	}
+	if _, _, err := fs.readRecord(ctx, n.id); err != nil {
+		if _, ok := err.(errtypes.IsNotFound); ok {
+			// nothing to unset
+			return nil
+		}
+		return err
+	}
+	return fs.updateRecord(ctx, n.id, func(rec *nodeRecord) error {
+		for _, k := range keys {
+			delete(rec.Metadata, k)
+		}
+		return nil
+	})
+}
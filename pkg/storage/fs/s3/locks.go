@@ -0,0 +1,237 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// Locks are stored as an object per node id. They are created with a conditional write,
+// so that only one of several concurrent lock requests can succeed.
+
+func (fs *s3FS) lockKey(id string) string {
+	return fs.metaKey("locks", id+".json")
+}
+
+// GetLock returns an existing lock on the given reference
+func (fs *s3FS) GetLock(ctx context.Context, ref *provider.Reference) (*provider.Lock, error) {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return fs.readLock(ctx, n.id)
+}
+
+// SetLock puts a lock on the given reference
+func (fs *s3FS) SetLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+	id, err := fs.ensureID(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	switch _, err := fs.readLock(ctx, id); err.(type) {
+	case nil:
+		return errtypes.PreconditionFailed("already locked")
+	case errtypes.IsNotFound:
+	default:
+		return err
+	}
+
+	err = fs.putJSON(ctx, fs.lockKey(id), lock, ifNoneMatch)
+	switch {
+	case isPreconditionFailed(err):
+		return errtypes.PreconditionFailed("already locked")
+	case err != nil:
+		return errors.Wrap(err, "s3fs: could not write lock")
+	}
+	return nil
+}
+
+// RefreshLock refreshes an existing lock on the given reference
+func (fs *s3FS) RefreshLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock, existingLockID string) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	oldLock, etag, err := fs.readLockWithETag(ctx, n.id)
+	switch err.(type) {
+	case nil:
+	case errtypes.IsNotFound:
+		return errtypes.PreconditionFailed("lock does not exist")
+	default:
+		return err
+	}
+
+	// check refresh lockID match
+	if existingLockID == "" && oldLock.LockId != lock.LockId {
+		return errtypes.Aborted("mismatching lock ID")
+	}
+
+	// check if UnlockAndRelock sends the correct lockID
+	if existingLockID != "" && oldLock.LockId != existingLockID {
+		return errtypes.Aborted("mismatching existing lock ID")
+	}
+
+	if err := checkLockModification(ctx, oldLock, lock); err != nil {
+		return err
+	}
+
+	err = fs.putJSON(ctx, fs.lockKey(n.id), lock, ifMatch(etag))
+	switch {
+	case isPreconditionFailed(err):
+		return errtypes.Aborted("the lock has been modified concurrently")
+	case err != nil:
+		return errors.Wrap(err, "s3fs: could not write lock")
+	}
+	return nil
+}
+
+// Unlock removes an existing lock from the given reference
+func (fs *s3FS) Unlock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	oldLock, err := fs.readLock(ctx, n.id)
+	switch err.(type) {
+	case nil:
+	case errtypes.IsNotFound:
+		return errtypes.Aborted("lock does not exist")
+	default:
+		return err
+	}
+
+	if lock == nil || oldLock.LockId != lock.LockId {
+		return errtypes.Locked(oldLock.LockId)
+	}
+
+	if err := checkLockModification(ctx, oldLock, lock); err != nil {
+		return err
+	}
+
+	return fs.deleteObject(ctx, fs.lockKey(n.id))
+}
+
+func (fs *s3FS) readLock(ctx context.Context, id string) (*provider.Lock, error) {
+	lock, _, err := fs.readLockWithETag(ctx, id)
+	return lock, err
+}
+
+// readLockWithETag returns the lock of a node and the etag of the lock object.
+// Expired locks are removed.
+func (fs *s3FS) readLockWithETag(ctx context.Context, id string) (*provider.Lock, string, error) {
+	lock := &provider.Lock{}
+	etag, err := fs.getJSON(ctx, fs.lockKey(id), lock)
+	switch {
+	case isNotFound(err):
+		return nil, "", errtypes.NotFound("no lock found")
+	case err != nil:
+		return nil, "", errors.Wrap(err, "s3fs: could not read lock")
+	}
+
+	if lock.Expiration != nil && time.Now().After(time.Unix(int64(lock.Expiration.Seconds), int64(lock.Expiration.Nanos))) {
+		if err := fs.deleteObject(ctx, fs.lockKey(id)); err != nil {
+			return nil, "", errors.Wrap(err, "s3fs: could not remove expired lock")
+		}
+		return nil, "", errtypes.NotFound("no lock found")
+	}
+	return lock, etag, nil
+}
+
+// checkLock compares the lock id in the context with the lock of the node
+func (fs *s3FS) checkLock(ctx context.Context, n *node) error {
+	contextLock, _ := ctxpkg.ContextGetLockID(ctx)
+	lock, err := fs.readLock(ctx, n.id)
+	switch err.(type) {
+	case nil:
+		switch contextLock {
+		case "":
+			return errtypes.Locked(lock.LockId) // no lockid in request
+		case lock.LockId:
+			return nil // ok
+		default:
+			return errtypes.Aborted("mismatching lock")
+		}
+	case errtypes.IsNotFound:
+	default:
+		return err
+	}
+	if contextLock != "" {
+		return errtypes.Aborted("not locked") // no lock on the node. why is there a lockid in the context
+	}
+	return nil
+}
+
+// readLockIntoResourceInfo adds the lock to the resource info the same way decomposedfs does
+func readLockIntoResourceInfo(ctx context.Context, lock *provider.Lock, ri *provider.ResourceInfo) {
+	b, err := json.Marshal(lock)
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("s3fs: could not marshal lock")
+		return
+	}
+	if ri.Opaque == nil {
+		ri.Opaque = &types.Opaque{
+			Map: map[string]*types.OpaqueEntry{},
+		}
+	}
+	ri.Opaque.Map["lock"] = &types.OpaqueEntry{
+		Decoder: "json",
+		Value:   b,
+	}
+	ri.Lock = lock
+}
+
+func checkLockModification(ctx context.Context, oldLock *provider.Lock, newLock *provider.Lock) error {
+	if oldLock.Type == provider.LockType_LOCK_TYPE_SHARED {
+		return nil
+	}
+
+	if oldLock.AppName != newLock.AppName {
+		return errtypes.PermissionDenied("app names of the locks are mismatching")
+	}
+
+	if oldLock.User == nil && newLock.GetUser() == nil {
+		// no user lock set
+		return nil
+	}
+	if !utils.UserIDEqual(oldLock.User, newLock.GetUser()) {
+		return errtypes.PermissionDenied("users of the locks are mismatching")
+	}
+	u := ctxpkg.ContextMustGetUser(ctx)
+	if !utils.UserIDEqual(oldLock.User, u.Id) {
+		return errtypes.PermissionDenied("lock holder and current user are mismatching")
+	}
+	return nil
+}
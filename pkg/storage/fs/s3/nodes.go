@@ -0,0 +1,406 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// Every file and folder created by the driver gets a stable id, which is stored in the metadata
+// of the object or of the folder marker. Copying an object preserves its metadata, so the id
+// survives moves. The reverse mapping from the id to the path is kept in a record object per
+// node, which only holds the id of the parent and the name, so that moving a folder does not
+// require touching the records of its children.
+//
+// Objects that were created by other clients have no id. They are addressed by their path
+// prefixed with "fileid-" until an id is assigned to them, e.g. when they are shared.
+const (
+	idMetadataKey    = "Reva-Id"
+	mtimeMetadataKey = "Reva-Mtime"
+
+	legacyIDPrefix = "fileid-"
+
+	// maxDepth protects the id resolution against cycles in the records
+	maxDepth = 256
+	// maxRetries limits the attempts to update a record that is modified concurrently
+	maxRetries = 5
+)
+
+type node struct {
+	key         string // object key, without the trailing slash for folders
+	id          string
+	isDir       bool
+	size        int64
+	etag        string
+	mtime       time.Time
+	contentType string
+	metadata    map[string]*string
+}
+
+func (n *node) legacy() bool {
+	return strings.HasPrefix(n.id, legacyIDPrefix)
+}
+
+func legacyID(p string) string {
+	return legacyIDPrefix + strings.TrimPrefix(p, "/")
+}
+
+type nodeRecord struct {
+	ParentID string `json:"parent_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	// Trashed holds the key of the trash item when the node has been deleted
+	Trashed  string            `json:"trashed,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Grants are encoded with protojson, the grantee is a oneof which encoding/json can not decode
+	Grants map[string]json.RawMessage `json:"grants,omitempty"`
+}
+
+func (fs *s3FS) recordKey(id string) string {
+	return fs.metaKey("nodes", id+".json")
+}
+
+// lookupKey returns the file or folder stored at the given key
+func (fs *s3FS) lookupKey(ctx context.Context, key string) (*node, error) {
+	if fs.isRoot(key) {
+		return &node{key: key, id: legacyID("/"), isDir: true}, nil
+	}
+
+	n, err := fs.lookupChild(ctx, key, false)
+	if _, ok := err.(errtypes.IsNotFound); !ok {
+		return n, err
+	}
+	return fs.lookupChild(ctx, key, true)
+}
+
+// lookupChild looks up a node when it is already known whether it is a file or a folder
+func (fs *s3FS) lookupChild(ctx context.Context, key string, isDir bool) (*node, error) {
+	objectKey := key
+	if isDir {
+		objectKey += "/"
+	}
+	head, err := fs.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(objectKey),
+	})
+	switch {
+	case err == nil:
+		return fs.nodeFromHead(key, isDir, head), nil
+	case !isNotFound(err):
+		return nil, errors.Wrap(err, "s3fs: error reading "+objectKey)
+	case !isDir:
+		return nil, errtypes.NotFound(fs.storagePath(key))
+	}
+
+	// folders created by other clients might only exist implicitly
+	output, err := fs.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(fs.config.Bucket),
+		Prefix:  aws.String(objectKey),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "s3fs: error listing "+objectKey)
+	}
+	if len(output.Contents) == 0 {
+		return nil, errtypes.NotFound(fs.storagePath(key))
+	}
+	return &node{key: key, id: legacyID(fs.storagePath(key)), isDir: true}, nil
+}
+
+func (fs *s3FS) nodeFromHead(key string, isDir bool, head *s3.HeadObjectOutput) *node {
+	n := &node{
+		key:         key,
+		isDir:       isDir,
+		etag:        aws.StringValue(head.ETag),
+		mtime:       aws.TimeValue(head.LastModified),
+		contentType: aws.StringValue(head.ContentType),
+		metadata:    head.Metadata,
+	}
+	if !isDir {
+		n.size = aws.Int64Value(head.ContentLength)
+	}
+	n.id = metadataValue(head.Metadata, idMetadataKey)
+	if n.id == "" {
+		n.id = legacyID(fs.storagePath(key))
+	}
+	if mtime, err := utils.MTimeToTime(metadataValue(head.Metadata, mtimeMetadataKey)); err == nil {
+		n.mtime = mtime
+	}
+	return n
+}
+
+// metadataValue looks up user metadata case insensitively, S3 implementations differ in the casing they return
+func metadataValue(md map[string]*string, key string) string {
+	for k, v := range md {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
+
+// pathByID returns the storage path of the node with the given id
+func (fs *s3FS) pathByID(ctx context.Context, id string) (string, error) {
+	p := ""
+	for depth := 0; depth < maxDepth; depth++ {
+		if strings.HasPrefix(id, legacyIDPrefix) {
+			return path.Join("/", strings.TrimPrefix(id, legacyIDPrefix), p), nil
+		}
+		rec, _, err := fs.readRecord(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if rec.Trashed != "" {
+			return "", errtypes.NotFound(id)
+		}
+		p = path.Join(rec.Name, p)
+		id = rec.ParentID
+	}
+	return "", errtypes.InternalError("s3fs: the path of the node is nested too deeply")
+}
+
+// idOf returns the id of the node stored at the given key
+func (fs *s3FS) idOf(ctx context.Context, key string) (string, error) {
+	n, err := fs.lookupKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return n.id, nil
+}
+
+func (fs *s3FS) readRecord(ctx context.Context, id string) (*nodeRecord, string, error) {
+	rec := &nodeRecord{}
+	etag, err := fs.getJSON(ctx, fs.recordKey(id), rec)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", errtypes.NotFound(id)
+		}
+		return nil, "", errors.Wrap(err, "s3fs: error reading node record "+id)
+	}
+	return rec, etag, nil
+}
+
+// createRecord creates the record of a new node and returns its id
+func (fs *s3FS) createRecord(ctx context.Context, parentID, name string) (string, error) {
+	id := newID()
+	if err := fs.putJSON(ctx, fs.recordKey(id), &nodeRecord{ParentID: parentID, Name: name}); err != nil {
+		return "", errors.Wrap(err, "s3fs: error creating node record")
+	}
+	return id, nil
+}
+
+// updateRecord applies the given function to the record of a node. Concurrent modifications
+// are detected with conditional writes and the update is retried.
+func (fs *s3FS) updateRecord(ctx context.Context, id string, update func(*nodeRecord) error) error {
+	for i := 0; i < maxRetries; i++ {
+		rec, etag, err := fs.readRecord(ctx, id)
+		condition := ifMatch(etag)
+		switch err.(type) {
+		case nil:
+		case errtypes.IsNotFound:
+			rec = &nodeRecord{}
+			condition = ifNoneMatch
+		default:
+			return err
+		}
+		if err := update(rec); err != nil {
+			return err
+		}
+		err = fs.putJSON(ctx, fs.recordKey(id), rec, condition)
+		if !isPreconditionFailed(err) {
+			return err
+		}
+	}
+	return errtypes.Aborted("s3fs: the node " + id + " is modified concurrently")
+}
+
+// ensureID assigns an id to nodes that were created by other clients and returns the id of the node.
+// The root keeps its legacy id, because it can not be moved.
+func (fs *s3FS) ensureID(ctx context.Context, n *node) (string, error) {
+	if !n.legacy() || fs.isRoot(n.key) {
+		return n.id, nil
+	}
+
+	parentID, err := fs.idOf(ctx, parentKey(n.key))
+	if err != nil {
+		return "", err
+	}
+	id, err := fs.createRecord(ctx, parentID, path.Base(n.key))
+	if err != nil {
+		return "", err
+	}
+
+	metadata := map[string]*string{}
+	for k, v := range n.metadata {
+		metadata[k] = v
+	}
+	metadata[idMetadataKey] = aws.String(id)
+	if n.isDir {
+		_, err = fs.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(fs.config.Bucket),
+			Key:           aws.String(n.key + "/"),
+			ContentType:   aws.String("application/octet-stream"),
+			ContentLength: aws.Int64(0),
+			Metadata:      metadata,
+		})
+	} else {
+		// the metadata of an object can only be changed by copying it onto itself
+		_, err = fs.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(fs.config.Bucket),
+			CopySource:        aws.String(fs.copySource(n.key)),
+			Key:               aws.String(n.key),
+			ContentType:       aws.String(n.contentType),
+			Metadata:          metadata,
+			MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		})
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "s3fs: error assigning an id to "+n.key)
+	}
+	n.id = id
+	n.metadata = metadata
+	return id, nil
+}
+
+// relink updates the record of the node at the given key after it has been moved or restored
+func (fs *s3FS) relink(ctx context.Context, key string) error {
+	n, err := fs.lookupKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	if n.legacy() {
+		return nil
+	}
+	parentID, err := fs.idOf(ctx, parentKey(key))
+	if err != nil {
+		return err
+	}
+	return fs.updateRecord(ctx, n.id, func(rec *nodeRecord) error {
+		rec.ParentID = parentID
+		rec.Name = path.Base(key)
+		rec.Trashed = ""
+		return nil
+	})
+}
+
+type condition struct {
+	header string
+	value  string
+}
+
+// ifNoneMatch makes a write fail if the object already exists
+var ifNoneMatch = condition{header: "If-None-Match", value: "*"}
+
+// ifMatch makes a write fail if the object has been changed
+func ifMatch(etag string) condition {
+	return condition{header: "If-Match", value: etag}
+}
+
+func withConditions(conditions []condition) request.Option {
+	return func(r *request.Request) {
+		for _, c := range conditions {
+			r.HTTPRequest.Header.Set(c.header, c.value)
+		}
+	}
+}
+
+// getJSON reads a json encoded object and returns its etag
+func (fs *s3FS) getJSON(ctx context.Context, key string, v interface{}) (string, error) {
+	output, err := fs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer output.Body.Close()
+	if err := json.NewDecoder(output.Body).Decode(v); err != nil {
+		return "", errors.Wrap(err, "s3fs: error decoding "+key)
+	}
+	return aws.StringValue(output.ETag), nil
+}
+
+// putJSON writes a json encoded object. The PutObjectInput of the sdk has no fields for
+// conditional writes, so the conditions are added as headers.
+func (fs *s3FS) putJSON(ctx context.Context, key string, v interface{}, conditions ...condition) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fs.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(fs.config.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	}, withConditions(conditions))
+	return err
+}
+
+func (fs *s3FS) deleteObject(ctx context.Context, key string) error {
+	_, err := fs.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "s3fs: error deleting "+key)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	if rerr, ok := err.(awserr.RequestFailure); ok && rerr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound", "NoSuchVersion":
+			return true
+		}
+	}
+	return false
+}
+
+func isPreconditionFailed(err error) bool {
+	if rerr, ok := err.(awserr.RequestFailure); ok && rerr.StatusCode() == http.StatusPreconditionFailed {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "PreconditionFailed"
+	}
+	return false
+}
+
+// isBadRequest is used to detect invalid version ids, which some implementations reject instead of reporting them missing
+func isBadRequest(err error) bool {
+	rerr, ok := err.(awserr.RequestFailure)
+	return ok && rerr.StatusCode() == http.StatusBadRequest
+}
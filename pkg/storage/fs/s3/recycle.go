@@ -0,0 +1,374 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package s3
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/pkg/errors"
+)
+
+// Deleted nodes are moved below the metadata prefix. Every trash item consists of an info
+// object and the moved objects, which are stored below the data key of the item:
+//
+//	<metadata_prefix>/trash/<item>.json
+//	<metadata_prefix>/trash/<item>/data[/...]
+type trashInfo struct {
+	Origin    string    `json:"origin"`
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Size      uint64    `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (fs *s3FS) trashInfoKey(item string) string {
+	return fs.metaKey("trash", item+".json")
+}
+
+func (fs *s3FS) trashDataKey(item, relativePath string) string {
+	return fs.metaKey("trash", item, "data", path.Clean("/"+relativePath))
+}
+
+func (fs *s3FS) readTrashInfo(ctx context.Context, item string) (*trashInfo, error) {
+	if item == "" || strings.Contains(item, "/") {
+		return nil, errtypes.BadRequest("invalid trash item key")
+	}
+	info := &trashInfo{}
+	if _, err := fs.getJSON(ctx, fs.trashInfoKey(item), info); err != nil {
+		if isNotFound(err) {
+			return nil, errtypes.NotFound(item)
+		}
+		return nil, errors.Wrap(err, "s3fs: error reading trash item "+item)
+	}
+	return info, nil
+}
+
+// Delete moves the node to the trash
+func (fs *s3FS) Delete(ctx context.Context, ref *provider.Reference) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if fs.isRoot(n.key) {
+		return errtypes.PermissionDenied("s3fs: the root can not be deleted")
+	}
+	if err := fs.checkLock(ctx, n); err != nil {
+		return err
+	}
+
+	item := newID()
+	size, err := fs.moveTree(ctx, n, fs.trashDataKey(item, ""))
+	if err != nil {
+		return err
+	}
+	fs.invalidateUsedBytes()
+
+	info := &trashInfo{
+		Origin:    fs.storagePath(n.key),
+		ID:        n.id,
+		Type:      getResourceType(n.isDir).String(),
+		Size:      size,
+		DeletedAt: time.Now(),
+	}
+	if err := fs.putJSON(ctx, fs.trashInfoKey(item), info); err != nil {
+		return errors.Wrap(err, "s3fs: error writing trash info")
+	}
+
+	if !n.legacy() {
+		err := fs.updateRecord(ctx, n.id, func(rec *nodeRecord) error {
+			rec.Trashed = item
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return fs.deleteObject(ctx, fs.lockKey(n.id))
+}
+
+func (fs *s3FS) ListRecycle(ctx context.Context, ref *provider.Reference, key, relativePath string) ([]*provider.RecycleItem, error) {
+	if key == "" {
+		if relativePath != "" {
+			return nil, errtypes.BadRequest("a relative path requires a trash item key")
+		}
+		return fs.listTrashItems(ctx)
+	}
+
+	info, err := fs.readTrashInfo(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if (relativePath == "" || relativePath == "/") && info.Type != provider.ResourceType_RESOURCE_TYPE_CONTAINER.String() {
+		return []*provider.RecycleItem{fs.trashItem(key, info)}, nil
+	}
+
+	items := []*provider.RecycleItem{}
+	prefix := childPrefix(fs.trashDataKey(key, relativePath))
+	err = fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(fs.config.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, p := range output.CommonPrefixes {
+			name := path.Base(strings.TrimSuffix(*p.Prefix, "/"))
+			items = append(items, fs.trashChild(key, relativePath, name, info, true, 0))
+		}
+		for _, o := range output.Contents {
+			if *o.Key == prefix {
+				// the folder marker
+				continue
+			}
+			name := path.Base(*o.Key)
+			items = append(items, fs.trashChild(key, relativePath, name, info, false, uint64(aws.Int64Value(o.Size))))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "s3fs: error listing trash item "+key)
+	}
+	return items, nil
+}
+
+func (fs *s3FS) listTrashItems(ctx context.Context) ([]*provider.RecycleItem, error) {
+	items := []*provider.RecycleItem{}
+	var keys []string
+	err := fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(fs.config.Bucket),
+		Prefix:    aws.String(childPrefix(fs.metaKey("trash"))),
+		Delimiter: aws.String("/"),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range output.Contents {
+			if strings.HasSuffix(*o.Key, ".json") {
+				keys = append(keys, strings.TrimSuffix(path.Base(*o.Key), ".json"))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "s3fs: error listing the trash")
+	}
+
+	for _, key := range keys {
+		info, err := fs.readTrashInfo(ctx, key)
+		if err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("key", key).Msg("could not read trash item")
+			continue
+		}
+		items = append(items, fs.trashItem(key, info))
+	}
+	return items, nil
+}
+
+func (fs *s3FS) trashItem(key string, info *trashInfo) *provider.RecycleItem {
+	t := provider.ResourceType_RESOURCE_TYPE_FILE
+	if info.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER.String() {
+		t = provider.ResourceType_RESOURCE_TYPE_CONTAINER
+	}
+	return &provider.RecycleItem{
+		Type: t,
+		Key:  key,
+		Ref:  &provider.Reference{Path: info.Origin},
+		Size: info.Size,
+		DeletionTime: &types.Timestamp{
+			Seconds: uint64(info.DeletedAt.Unix()),
+			Nanos:   uint32(info.DeletedAt.Nanosecond()),
+		},
+	}
+}
+
+func (fs *s3FS) trashChild(key, relativePath, name string, info *trashInfo, isDir bool, size uint64) *provider.RecycleItem {
+	return &provider.RecycleItem{
+		Type: getResourceType(isDir),
+		Key:  path.Join(key, relativePath, name),
+		Ref:  &provider.Reference{Path: path.Join(info.Origin, relativePath, name)},
+		Size: size,
+		DeletionTime: &types.Timestamp{
+			Seconds: uint64(info.DeletedAt.Unix()),
+			Nanos:   uint32(info.DeletedAt.Nanosecond()),
+		},
+	}
+}
+
+func (fs *s3FS) RestoreRecycleItem(ctx context.Context, ref *provider.Reference, key, relativePath string, restoreRef *provider.Reference) error {
+	info, err := fs.readTrashInfo(ctx, key)
+	if err != nil {
+		return err
+	}
+	n, err := fs.lookupKey(ctx, fs.trashDataKey(key, relativePath))
+	if err != nil {
+		return err
+	}
+
+	var target string
+	if restoreRef != nil && (restoreRef.GetPath() != "" || restoreRef.GetResourceId() != nil) {
+		if target, err = fs.resolve(ctx, restoreRef); err != nil {
+			return err
+		}
+	} else {
+		target = fs.key(path.Join(info.Origin, relativePath))
+	}
+
+	switch _, err := fs.lookupKey(ctx, target); err.(type) {
+	case nil:
+		return errtypes.AlreadyExists(fs.storagePath(target))
+	case errtypes.IsNotFound:
+	default:
+		return err
+	}
+	parent, err := fs.lookupKey(ctx, parentKey(target))
+	if err != nil {
+		return err
+	}
+	if !parent.isDir {
+		return errtypes.PreconditionFailed("parent is not a folder")
+	}
+
+	if _, err := fs.moveTree(ctx, n, target); err != nil {
+		return err
+	}
+	fs.invalidateUsedBytes()
+	if err := fs.relink(ctx, target); err != nil {
+		return err
+	}
+	if relativePath == "" || relativePath == "/" {
+		return fs.deleteObject(ctx, fs.trashInfoKey(key))
+	}
+	return nil
+}
+
+func (fs *s3FS) PurgeRecycleItem(ctx context.Context, ref *provider.Reference, key, relativePath string) error {
+	if _, err := fs.readTrashInfo(ctx, key); err != nil {
+		return err
+	}
+	n, err := fs.lookupKey(ctx, fs.trashDataKey(key, relativePath))
+	if err != nil {
+		return err
+	}
+
+	keys := []string{n.key}
+	if n.isDir {
+		keys = []string{}
+		err := fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(fs.config.Bucket),
+			Prefix: aws.String(childPrefix(n.key)),
+		}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+			for _, o := range output.Contents {
+				keys = append(keys, *o.Key)
+			}
+			return true
+		})
+		if err != nil {
+			return errors.Wrap(err, "s3fs: error listing trash item "+key)
+		}
+	}
+
+	for _, k := range keys {
+		if err := fs.purgeObject(ctx, k); err != nil {
+			return err
+		}
+	}
+	if relativePath == "" || relativePath == "/" {
+		return fs.deleteObject(ctx, fs.trashInfoKey(key))
+	}
+	return nil
+}
+
+// purgeObject removes an object with all its versions and the metadata of the node it belongs to
+func (fs *s3FS) purgeObject(ctx context.Context, key string) error {
+	head, err := fs.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(key),
+	})
+	switch {
+	case err == nil:
+		if id := metadataValue(head.Metadata, idMetadataKey); id != "" {
+			if err := fs.deleteObject(ctx, fs.recordKey(id)); err != nil {
+				return err
+			}
+			if err := fs.deleteObject(ctx, fs.lockKey(id)); err != nil {
+				return err
+			}
+		}
+	case !isNotFound(err):
+		return errors.Wrap(err, "s3fs: error reading "+key)
+	}
+
+	if !fs.config.EnableVersioning {
+		return fs.deleteObject(ctx, key)
+	}
+
+	var deleteErr error
+	err = fs.client.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(key),
+	}, func(output *s3.ListObjectVersionsOutput, _ bool) bool {
+		versions := []*string{}
+		for _, v := range output.Versions {
+			if aws.StringValue(v.Key) == key {
+				versions = append(versions, v.VersionId)
+			}
+		}
+		for _, m := range output.DeleteMarkers {
+			if aws.StringValue(m.Key) == key {
+				versions = append(versions, m.VersionId)
+			}
+		}
+		for _, v := range versions {
+			_, deleteErr = fs.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket:    aws.String(fs.config.Bucket),
+				Key:       aws.String(key),
+				VersionId: v,
+			})
+			if deleteErr != nil && !isNotFound(deleteErr) {
+				return false
+			}
+			deleteErr = nil
+		}
+		return true
+	})
+	if err != nil {
+		return errors.Wrap(err, "s3fs: error listing the versions of "+key)
+	}
+	if deleteErr != nil {
+		return errors.Wrap(deleteErr, "s3fs: error deleting "+key)
+	}
+	return nil
+}
+
+func (fs *s3FS) EmptyRecycle(ctx context.Context, ref *provider.Reference) error {
+	items, err := fs.listTrashItems(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fs.PurgeRecycleItem(ctx, ref, item.Key, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
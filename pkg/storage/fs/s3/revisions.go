@@ -0,0 +1,164 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package s3
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// Revisions are the noncurrent versions of an object in a versioned bucket, the revision key
+// is the version id. Moving a file copies only the current version, so the history of a file
+// starts anew after it has been moved.
+
+func (fs *s3FS) ListRevisions(ctx context.Context, ref *provider.Reference) ([]*provider.FileVersion, error) {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	revisions := []*provider.FileVersion{}
+	if n.isDir {
+		return revisions, nil
+	}
+
+	err = fs.client.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(n.key),
+	}, func(output *s3.ListObjectVersionsOutput, _ bool) bool {
+		for _, v := range output.Versions {
+			if aws.StringValue(v.Key) != n.key || aws.BoolValue(v.IsLatest) {
+				continue
+			}
+			revisions = append(revisions, &provider.FileVersion{
+				Key:   aws.StringValue(v.VersionId),
+				Size:  uint64(aws.Int64Value(v.Size)),
+				Mtime: uint64(aws.TimeValue(v.LastModified).Unix()),
+				Etag:  aws.StringValue(v.ETag),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "s3fs: error listing the versions of "+n.key)
+	}
+	return revisions, nil
+}
+
+func (fs *s3FS) DownloadRevision(ctx context.Context, ref *provider.Reference, revisionKey string, openReaderfunc func(*provider.ResourceInfo) bool) (*provider.ResourceInfo, io.ReadCloser, error) {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n.isDir {
+		return nil, nil, errtypes.NotFound(revisionKey)
+	}
+
+	head, err := fs.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(fs.config.Bucket),
+		Key:       aws.String(n.key),
+		VersionId: aws.String(revisionKey),
+	})
+	if err != nil {
+		if isNotFound(err) || isBadRequest(err) {
+			return nil, nil, errtypes.NotFound(revisionKey)
+		}
+		return nil, nil, errors.Wrap(err, "s3fs: error reading revision "+revisionKey)
+	}
+	revision := fs.nodeFromHead(n.key, false, head)
+	// the revision is presented as the current node
+	revision.id = n.id
+	ri := fs.normalize(ctx, revision)
+
+	if !openReaderfunc(ri) {
+		return ri, nil, nil
+	}
+
+	r, err := fs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(fs.config.Bucket),
+		Key:       aws.String(n.key),
+		VersionId: aws.String(revisionKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil, errtypes.NotFound(revisionKey)
+		}
+		return nil, nil, errors.Wrap(err, "s3fs: error downloading revision "+revisionKey)
+	}
+	return ri, r.Body, nil
+}
+
+// RestoreRevision uploads the content of the version as the current version. The content is streamed
+// instead of copied on the server, because a single copy request is limited to 5GB.
+func (fs *s3FS) RestoreRevision(ctx context.Context, ref *provider.Reference, revisionKey string) error {
+	n, err := fs.lookup(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if n.isDir {
+		return errtypes.NotFound(revisionKey)
+	}
+	if err := fs.checkLock(ctx, n); err != nil {
+		return err
+	}
+
+	r, err := fs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(fs.config.Bucket),
+		Key:       aws.String(n.key),
+		VersionId: aws.String(revisionKey),
+	})
+	if err != nil {
+		if isNotFound(err) || isBadRequest(err) {
+			return errtypes.NotFound(revisionKey)
+		}
+		return errors.Wrap(err, "s3fs: error reading revision "+revisionKey)
+	}
+	defer r.Body.Close()
+
+	// the metadata of the version might be outdated, keep the id of the current object
+	metadata := map[string]*string{
+		mtimeMetadataKey: aws.String(utils.TimeToOCMtime(time.Now())),
+	}
+	if !n.legacy() {
+		metadata[idMetadataKey] = aws.String(n.id)
+	}
+	uploader := s3manager.NewUploaderWithClient(fs.client, func(u *s3manager.Uploader) {
+		u.Concurrency = fs.config.Concurrency
+	})
+	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(fs.config.Bucket),
+		Key:         aws.String(n.key),
+		Body:        r.Body,
+		ContentType: aws.String(n.contentType),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return errors.Wrap(err, "s3fs: error restoring revision "+revisionKey)
+	}
+	fs.invalidateUsedBytes()
+	return nil
+}
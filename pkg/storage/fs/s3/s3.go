@@ -20,22 +20,24 @@ package s3
 
 import (
 	"context"
+	"crypto/md5"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/google/uuid"
 	"github.com/mitchellh/mapstructure"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
@@ -58,6 +60,15 @@ type config struct {
 	Endpoint  string `mapstructure:"endpoint"`
 	Bucket    string `mapstructure:"bucket"`
 	Prefix    string `mapstructure:"prefix"`
+	// MetadataPrefix is where the node records, locks, upload sessions and the trash are kept.
+	// It defaults to a .reva folder below the prefix, which is hidden from listings.
+	MetadataPrefix string `mapstructure:"metadata_prefix"`
+	// EnableVersioning turns on versioning for the bucket. Object versions are exposed as revisions.
+	EnableVersioning bool `mapstructure:"enable_versioning"`
+	// Quota is the number of bytes that may be stored in the bucket, 0 means unlimited.
+	Quota uint64 `mapstructure:"quota"`
+	// Concurrency limits the number of parallel requests when listing folders and uploading parts.
+	Concurrency int `mapstructure:"concurrency"`
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -69,6 +80,17 @@ func parseConfig(m map[string]interface{}) (*config, error) {
 	return c, nil
 }
 
+func (c *config) init() {
+	c.Prefix = strings.Trim(c.Prefix, "/")
+	if c.MetadataPrefix == "" {
+		c.MetadataPrefix = path.Join(c.Prefix, ".reva")
+	}
+	c.MetadataPrefix = strings.Trim(c.MetadataPrefix, "/")
+	if c.Concurrency <= 0 {
+		c.Concurrency = 16
+	}
+}
+
 // New returns an implementation to of the storage.FS interface that talk to
 // a s3 api.
 func New(m map[string]interface{}, _ events.Stream, _ *zerolog.Logger) (storage.FS, error) {
@@ -76,6 +98,7 @@ func New(m map[string]interface{}, _ events.Stream, _ *zerolog.Logger) (storage.
 	if err != nil {
 		return nil, err
 	}
+	c.init()
 
 	awsConfig := aws.NewConfig().
 		WithHTTPClient(http.DefaultClient).
@@ -104,6 +127,18 @@ func New(m map[string]interface{}, _ events.Stream, _ *zerolog.Logger) (storage.
 
 	s3Client := s3.New(sess)
 
+	if c.EnableVersioning {
+		_, err := s3Client.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(c.Bucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String(s3.BucketVersioningStatusEnabled),
+			},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "s3fs: error enabling versioning for bucket "+c.Bucket)
+		}
+	}
+
 	return &s3FS{client: s3Client, config: c}, nil
 }
 
@@ -111,37 +146,76 @@ func (fs *s3FS) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-func (fs *s3FS) addRoot(p string) string {
-	np := path.Join(fs.config.Prefix, p)
-	return np
+// key returns the object key for the given storage path. Keys never start with a slash
+// and the key of the root is the prefix.
+func (fs *s3FS) key(p string) string {
+	return strings.Trim(path.Join(fs.config.Prefix, path.Clean("/"+p)), "/")
+}
+
+// storagePath returns the storage path of the given object key
+func (fs *s3FS) storagePath(key string) string {
+	return path.Join("/", strings.TrimPrefix(key, fs.config.Prefix))
+}
+
+// metaKey returns the key of an object below the metadata prefix
+func (fs *s3FS) metaKey(elem ...string) string {
+	return path.Join(append([]string{fs.config.MetadataPrefix}, elem...)...)
+}
+
+func (fs *s3FS) isRoot(key string) bool {
+	return key == fs.key("/")
 }
 
+// parentKey returns the key of the parent directory
+func parentKey(key string) string {
+	p := path.Dir(key)
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+// childPrefix returns the prefix of all objects below the given directory key
+func childPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+// resolve returns the object key of the given reference
 func (fs *s3FS) resolve(ctx context.Context, ref *provider.Reference) (string, error) {
-	if strings.HasPrefix(ref.Path, "/") {
-		return fs.addRoot(ref.GetPath()), nil
+	if strings.HasPrefix(ref.GetPath(), "/") {
+		return fs.key(ref.GetPath()), nil
 	}
 
-	if ref.ResourceId != nil && ref.ResourceId.OpaqueId != "" {
-		fn := path.Join("/", strings.TrimPrefix(ref.ResourceId.OpaqueId, "fileid-"))
-		fn = fs.addRoot(fn)
-		return fn, nil
+	if ref.GetResourceId().GetOpaqueId() != "" {
+		p, err := fs.pathByID(ctx, ref.GetResourceId().GetOpaqueId())
+		if err != nil {
+			return "", err
+		}
+		return fs.key(path.Join(p, ref.GetPath())), nil
 	}
 
 	// reference is invalid
 	return "", fmt.Errorf("invalid reference %+v", ref)
 }
 
-func (fs *s3FS) removeRoot(np string) string {
-	p := strings.TrimPrefix(np, fs.config.Prefix)
-	if p == "" {
-		p = "/"
+func (fs *s3FS) lookup(ctx context.Context, ref *provider.Reference) (*node, error) {
+	key, err := fs.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
 	}
-	return p
+	return fs.lookupKey(ctx, key)
 }
 
 type s3FS struct {
 	client *s3.S3
 	config *config
+
+	quotaMu     sync.Mutex
+	usedBytes   uint64
+	usedBytesAt time.Time
 }
 
 // permissionSet returns the permission set for the current user
@@ -167,30 +241,37 @@ func (fs *s3FS) permissionSet(ctx context.Context) *provider.ResourcePermissions
 		RestoreRecycleItem:   true,
 		Stat:                 true,
 		UpdateGrant:          true,
+		DenyGrant:            true,
 	}
 }
 
-func (fs *s3FS) normalizeObject(ctx context.Context, o *s3.Object, fn string) *provider.ResourceInfo {
-	fn = fs.removeRoot(path.Join("/", fn))
-	isDir := strings.HasSuffix(*o.Key, "/")
+func (fs *s3FS) normalize(ctx context.Context, n *node) *provider.ResourceInfo {
+	fn := fs.storagePath(n.key)
+	etag := n.etag
+	if etag == "" {
+		// implicit directories and the root have no object to take the etag from
+		etag = fmt.Sprintf(`"%x"`, md5.Sum([]byte(n.key+n.mtime.String())))
+	}
 	md := &provider.ResourceInfo{
-		Id: &provider.ResourceId{
-			OpaqueId: "fileid-" + strings.TrimPrefix(fn, "/"),
-		},
+		Id:            &provider.ResourceId{OpaqueId: n.id},
 		Path:          fn,
-		Type:          getResourceType(isDir),
-		Etag:          *o.ETag,
-		MimeType:      mime.Detect(isDir, fn),
+		Type:          getResourceType(n.isDir),
+		Etag:          etag,
+		MimeType:      mime.Detect(n.isDir, fn),
 		PermissionSet: fs.permissionSet(ctx),
-		Size:          uint64(*o.Size),
+		Size:          uint64(n.size),
 		Mtime: &types.Timestamp{
-			Seconds: uint64(o.LastModified.Unix()),
+			Seconds: uint64(n.mtime.Unix()),
+			Nanos:   uint32(n.mtime.Nanosecond()),
 		},
 	}
+	if !fs.isRoot(n.key) {
+		md.Name = path.Base(fn)
+	}
 	appctx.GetLogger(ctx).Debug().
-		Interface("object", o).
+		Str("key", n.key).
 		Interface("metadata", md).
-		Msg("normalized Object")
+		Msg("normalized node")
 	return md
 }
 
@@ -198,108 +279,63 @@ func getResourceType(isDir bool) provider.ResourceType {
 	if isDir {
 		return provider.ResourceType_RESOURCE_TYPE_CONTAINER
 	}
-	return provider.ResourceType_RESOURCE_TYPE_CONTAINER
-}
-
-func (fs *s3FS) normalizeHead(ctx context.Context, o *s3.HeadObjectOutput, fn string) *provider.ResourceInfo {
-	fn = fs.removeRoot(path.Join("/", fn))
-	isDir := strings.HasSuffix(fn, "/")
-	md := &provider.ResourceInfo{
-		Id:            &provider.ResourceId{OpaqueId: "fileid-" + strings.TrimPrefix(fn, "/")},
-		Path:          fn,
-		Type:          getResourceType(isDir),
-		Etag:          *o.ETag,
-		MimeType:      mime.Detect(isDir, fn),
-		PermissionSet: fs.permissionSet(ctx),
-		Size:          uint64(*o.ContentLength),
-		Mtime: &types.Timestamp{
-			Seconds: uint64(o.LastModified.Unix()),
-		},
-	}
-	appctx.GetLogger(ctx).Debug().
-		Interface("head", o).
-		Interface("metadata", md).
-		Msg("normalized Head")
-	return md
-}
-func (fs *s3FS) normalizeCommonPrefix(ctx context.Context, p *s3.CommonPrefix) *provider.ResourceInfo {
-	fn := fs.removeRoot(path.Join("/", *p.Prefix))
-	md := &provider.ResourceInfo{
-		Id:            &provider.ResourceId{OpaqueId: "fileid-" + strings.TrimPrefix(fn, "/")},
-		Path:          fn,
-		Type:          getResourceType(true),
-		Etag:          "TODO(labkode)",
-		MimeType:      mime.Detect(true, fn),
-		PermissionSet: fs.permissionSet(ctx),
-		Size:          0,
-		Mtime: &types.Timestamp{
-			Seconds: 0,
-		},
-	}
-	appctx.GetLogger(ctx).Debug().
-		Interface("prefix", p).
-		Interface("metadata", md).
-		Msg("normalized CommonPrefix")
-	return md
+	return provider.ResourceType_RESOURCE_TYPE_FILE
 }
 
 // GetPathByID returns the path pointed by the file id
-// In this implementation the file id is that path of the file without the first slash
-// thus the file id always points to the filename
 func (fs *s3FS) GetPathByID(ctx context.Context, id *provider.ResourceId) (string, error) {
-	return path.Join("/", strings.TrimPrefix(id.OpaqueId, "fileid-")), nil
-}
-
-func (fs *s3FS) AddGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
-	return errtypes.NotSupported("s3: operation not supported")
-}
-
-func (fs *s3FS) DenyGrant(ctx context.Context, ref *provider.Reference, g *provider.Grantee) error {
-	return errtypes.NotSupported("s3: operation not supported")
-}
-
-func (fs *s3FS) ListGrants(ctx context.Context, ref *provider.Reference) ([]*provider.Grant, error) {
-	return nil, errtypes.NotSupported("s3: operation not supported")
-}
-
-func (fs *s3FS) RemoveGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
-	return errtypes.NotSupported("s3: operation not supported")
-}
-
-func (fs *s3FS) UpdateGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
-	return errtypes.NotSupported("s3: operation not supported")
+	return fs.pathByID(ctx, id.GetOpaqueId())
 }
 
+// GetQuota returns the configured quota and the number of bytes stored below the prefix.
+// The trash and the metadata are not accounted for.
 func (fs *s3FS) GetQuota(ctx context.Context, ref *provider.Reference) (uint64, uint64, uint64, error) {
-	return 0, 0, 0, nil
-}
-
-func (fs *s3FS) SetArbitraryMetadata(ctx context.Context, ref *provider.Reference, md *provider.ArbitraryMetadata) error {
-	return errtypes.NotSupported("s3: operation not supported")
-}
-
-func (fs *s3FS) UnsetArbitraryMetadata(ctx context.Context, ref *provider.Reference, keys []string) error {
-	return errtypes.NotSupported("s3: operation not supported")
+	used, err := fs.getUsedBytes(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	total := fs.config.Quota
+	var remaining uint64
+	if total > used {
+		remaining = total - used
+	}
+	return total, used, remaining, nil
 }
 
-// GetLock returns an existing lock on the given reference
-func (fs *s3FS) GetLock(ctx context.Context, ref *provider.Reference) (*provider.Lock, error) {
-	return nil, errtypes.NotSupported("unimplemented")
-}
+const usedBytesTTL = 30 * time.Second
 
-// SetLock puts a lock on the given reference
-func (fs *s3FS) SetLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
-	return errtypes.NotSupported("unimplemented")
-}
+func (fs *s3FS) getUsedBytes(ctx context.Context) (uint64, error) {
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+	if time.Since(fs.usedBytesAt) < usedBytesTTL {
+		return fs.usedBytes, nil
+	}
 
-// RefreshLock refreshes an existing lock on the given reference
-func (fs *s3FS) RefreshLock(ctx context.Context, ref *provider.Reference, lock *provider.Lock, existingLockID string) error {
-	return errtypes.NotSupported("unimplemented")
+	var used uint64
+	metaPrefix := childPrefix(fs.config.MetadataPrefix)
+	err := fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(childPrefix(fs.key("/"))),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range output.Contents {
+			if !strings.HasPrefix(*o.Key, metaPrefix) {
+				used += uint64(aws.Int64Value(o.Size))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "s3fs: error calculating the used bytes")
+	}
+	fs.usedBytes, fs.usedBytesAt = used, time.Now()
+	return used, nil
 }
 
-// Unlock removes an existing lock from the given reference
-func (fs *s3FS) Unlock(ctx context.Context, ref *provider.Reference, lock *provider.Lock) error {
-	return errtypes.NotSupported("unimplemented")
+// invalidateUsedBytes makes the next quota request recalculate the used bytes
+func (fs *s3FS) invalidateUsedBytes() {
+	fs.quotaMu.Lock()
+	fs.usedBytesAt = time.Time{}
+	fs.quotaMu.Unlock()
 }
 
 func (fs *s3FS) CreateReference(ctx context.Context, path string, targetURI *url.URL) error {
@@ -316,35 +352,41 @@ func (fs *s3FS) CreateHome(ctx context.Context) error {
 }
 
 func (fs *s3FS) CreateDir(ctx context.Context, ref *provider.Reference) error {
-	log := appctx.GetLogger(ctx)
-
-	fn, err := fs.resolve(ctx, ref)
+	key, err := fs.resolve(ctx, ref)
 	if err != nil {
-		return nil
+		return errors.Wrap(err, "error resolving ref")
 	}
 
-	fn = fs.addRoot(fn) + "/" // append / to indicate folder // TODO only if fn does not end in /
+	switch _, err := fs.lookupKey(ctx, key); err.(type) {
+	case nil:
+		return errtypes.AlreadyExists(fs.storagePath(key))
+	case errtypes.IsNotFound:
+	default:
+		return err
+	}
+	parent, err := fs.lookupKey(ctx, parentKey(key))
+	if err != nil {
+		return err
+	}
+	if !parent.isDir {
+		return errtypes.PreconditionFailed("parent is not a folder")
+	}
 
-	input := &s3.PutObjectInput{
+	id, err := fs.createRecord(ctx, parent.id, path.Base(key))
+	if err != nil {
+		return err
+	}
+	// the folder marker carries the id
+	_, err = fs.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(fs.config.Bucket),
-		Key:           aws.String(fn),
+		Key:           aws.String(key + "/"),
 		ContentType:   aws.String("application/octet-stream"),
 		ContentLength: aws.Int64(0),
-	}
-
-	result, err := fs.client.PutObject(input)
+		Metadata:      map[string]*string{idMetadataKey: aws.String(id)},
+	})
 	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == s3.ErrCodeNoSuchBucket {
-				return errtypes.NotFound(ref.Path)
-			}
-		}
-		// FIXME we also need already exists error, webdav expects 405 MethodNotAllowed
-		return errors.Wrap(err, "s3fs: error creating dir "+ref.Path)
+		return errors.Wrap(err, "s3fs: error creating dir "+fs.storagePath(key))
 	}
-
-	log.Debug().Interface("result", result) // todo cache etag?
 	return nil
 }
 
@@ -353,282 +395,238 @@ func (fs *s3FS) TouchFile(ctx context.Context, ref *provider.Reference, markproc
 	return fmt.Errorf("unimplemented: TouchFile")
 }
 
-func (fs *s3FS) Delete(ctx context.Context, ref *provider.Reference) error {
-	log := appctx.GetLogger(ctx)
-
-	fn, err := fs.resolve(ctx, ref)
-	if err != nil {
-		return errors.Wrap(err, "error resolving ref")
-	}
-
-	// first we need to find out if fn is a dir or a file
-
-	_, err = fs.client.HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(fn),
-	})
-	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-			case s3.ErrCodeNoSuchKey:
-				return errtypes.NotFound(fn)
-			}
-		}
-		// it might be a directory, so we can batch delete the prefix + /
-		iter := s3manager.NewDeleteListIterator(fs.client, &s3.ListObjectsInput{
-			Bucket: aws.String(fs.config.Bucket),
-			Prefix: aws.String(fn + "/"),
-		})
-		batcher := s3manager.NewBatchDeleteWithClient(fs.client)
-		if err := batcher.Delete(aws.BackgroundContext(), iter); err != nil {
-			return err
-		}
-		// ok, we are done
-		return nil
-	}
-
-	// we found an object, let's get rid of it
-	result, err := fs.client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(fn),
-	})
-	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-			case s3.ErrCodeNoSuchKey:
-				return errtypes.NotFound(fn)
-			}
-		}
-		return errors.Wrap(err, "s3fs: error deleting "+fn)
-	}
-
-	log.Debug().Interface("result", result)
-	return nil
-}
-
 // CreateStorageSpace creates a storage space
 func (fs *s3FS) CreateStorageSpace(ctx context.Context, req *provider.CreateStorageSpaceRequest) (*provider.CreateStorageSpaceResponse, error) {
 	return nil, errtypes.NotSupported("unimplemented: CreateStorageSpace")
 }
 
-func (fs *s3FS) moveObject(ctx context.Context, oldKey string, newKey string) error {
+// copySource returns the url encoded source of a copy request
+func (fs *s3FS) copySource(key string) string {
+	return (&url.URL{Path: fs.config.Bucket + "/" + key}).EscapedPath()
+}
 
-	// Copy
+func (fs *s3FS) moveObject(ctx context.Context, oldKey string, newKey string) error {
 	// TODO double check CopyObject can deal with >5GB files.
 	// Docs say we need to use multipart upload: https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectCOPY.html
-	_, err := fs.client.CopyObject(&s3.CopyObjectInput{
+	_, err := fs.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
 		Bucket:     aws.String(fs.config.Bucket),
-		CopySource: aws.String("/" + fs.config.Bucket + oldKey),
+		CopySource: aws.String(fs.copySource(oldKey)),
 		Key:        aws.String(newKey),
 	})
-	if aerr, ok := err.(awserr.Error); ok {
-		if aerr.Code() == s3.ErrCodeNoSuchBucket {
-			return errtypes.NotFound(oldKey)
+	if err != nil {
+		if isNotFound(err) {
+			return errtypes.NotFound(fs.storagePath(oldKey))
 		}
-		return err
+		return errors.Wrap(err, "s3fs: error copying "+oldKey)
 	}
-	// TODO cache etag and mtime?
 
-	// Delete
-	_, err = fs.client.DeleteObject(&s3.DeleteObjectInput{
+	_, err = fs.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(fs.config.Bucket),
 		Key:    aws.String(oldKey),
 	})
-	if aerr, ok := err.(awserr.Error); ok {
-		switch aerr.Code() {
-		case s3.ErrCodeNoSuchBucket:
-		case s3.ErrCodeNoSuchKey:
-			return errtypes.NotFound(oldKey)
-		}
-		return err
+	if err != nil {
+		return errors.Wrap(err, "s3fs: error deleting "+oldKey)
 	}
 	return nil
 }
 
-func (fs *s3FS) Move(ctx context.Context, oldRef, newRef *provider.Reference) error {
-	log := appctx.GetLogger(ctx)
+// moveTree moves a file or a folder with all its children to the given key. It returns
+// the number of bytes moved.
+func (fs *s3FS) moveTree(ctx context.Context, n *node, newKey string) (uint64, error) {
+	if !n.isDir {
+		return uint64(n.size), fs.moveObject(ctx, n.key, newKey)
+	}
 
-	fn, err := fs.resolve(ctx, oldRef)
+	var size uint64
+	var moveErr error
+	prefix := childPrefix(n.key)
+	// the folder marker ends with a slash and is moved along with the children
+	err := fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range output.Contents {
+			if moveErr = fs.moveObject(ctx, *o.Key, newKey+"/"+strings.TrimPrefix(*o.Key, prefix)); moveErr != nil {
+				return false
+			}
+			size += uint64(aws.Int64Value(o.Size))
+		}
+		return true
+	})
 	if err != nil {
-		return errors.Wrap(err, "error resolving ref")
+		return size, errors.Wrap(err, "s3fs: error listing "+n.key)
 	}
+	return size, moveErr
+}
 
-	newName, err := fs.resolve(ctx, newRef)
+func (fs *s3FS) Move(ctx context.Context, oldRef, newRef *provider.Reference) error {
+	n, err := fs.lookup(ctx, oldRef)
 	if err != nil {
-		return errors.Wrap(err, "error resolving ref")
+		return err
+	}
+	if fs.isRoot(n.key) {
+		return errtypes.PermissionDenied("s3fs: the root can not be moved")
+	}
+	if err := fs.checkLock(ctx, n); err != nil {
+		return err
 	}
 
-	// first we need to find out if fn is a dir or a file
-
-	_, err = fs.client.HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(fn),
-	})
+	newKey, err := fs.resolve(ctx, newRef)
 	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-			case s3.ErrCodeNoSuchKey:
-				return errtypes.NotFound(fn)
-			}
-		}
-
-		// move directory
-		input := &s3.ListObjectsV2Input{
-			Bucket: aws.String(fs.config.Bucket),
-			Prefix: aws.String(fn + "/"),
-		}
-		isTruncated := true
-
-		for isTruncated {
-			output, err := fs.client.ListObjectsV2(input)
-			if err != nil {
-				return errors.Wrap(err, "s3FS: error listing "+fn)
-			}
-
-			for _, o := range output.Contents {
-				log.Debug().
-					Interface("object", *o).
-					Str("fn", fn).
-					Msg("found Object")
-
-				err := fs.moveObject(ctx, *o.Key, strings.Replace(*o.Key, fn+"/", newName+"/", 1))
-				if err != nil {
-					return err
-				}
-			}
-
-			input.ContinuationToken = output.NextContinuationToken
-			isTruncated = *output.IsTruncated
-		}
-		// ok, we are done
-		return nil
+		return errors.Wrap(err, "error resolving ref")
 	}
-
-	// move single object
-	err = fs.moveObject(ctx, fn, newName)
+	if newKey == n.key || strings.HasPrefix(newKey, childPrefix(n.key)) {
+		return errtypes.BadRequest("s3fs: can not move a folder into itself")
+	}
+	switch _, err := fs.lookupKey(ctx, newKey); err.(type) {
+	case nil:
+		return errtypes.AlreadyExists(fs.storagePath(newKey))
+	case errtypes.IsNotFound:
+	default:
+		return err
+	}
+	parent, err := fs.lookupKey(ctx, parentKey(newKey))
 	if err != nil {
 		return err
 	}
-	return nil
+	if !parent.isDir {
+		return errtypes.PreconditionFailed("parent is not a folder")
+	}
+
+	if _, err := fs.moveTree(ctx, n, newKey); err != nil {
+		return err
+	}
+	// the ids travel with the objects, only the record of the moved node needs to point to the new parent
+	return fs.relink(ctx, newKey)
 }
 
 func (fs *s3FS) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string, fieldMask []string) (*provider.ResourceInfo, error) {
-	log := appctx.GetLogger(ctx)
-
-	fn, err := fs.resolve(ctx, ref)
+	n, err := fs.lookup(ctx, ref)
 	if err != nil {
-		return nil, errors.Wrap(err, "error resolving ref")
+		return nil, err
 	}
+	ri := fs.normalize(ctx, n)
 
-	// first try a head, works for files
-	log.Debug().
-		Str("fn", fn).
-		Msg("trying HEAD")
-
-	input := &s3.HeadObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(fn),
+	rec, _, err := fs.readRecord(ctx, n.id)
+	switch err.(type) {
+	case nil:
+		ri.ArbitraryMetadata = &provider.ArbitraryMetadata{Metadata: filterMetadata(rec.Metadata, mdKeys)}
+	case errtypes.IsNotFound:
+	default:
+		return nil, err
 	}
-	output, err := fs.client.HeadObject(input)
-	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-			case s3.ErrCodeNoSuchKey:
-				return nil, errtypes.NotFound(fn)
-			}
-		}
-		log.Debug().
-			Str("fn", fn).
-			Msg("trying to list prefix")
-		// try by listing parent to find directory
-		input := &s3.ListObjectsV2Input{
-			Bucket:    aws.String(fs.config.Bucket),
-			Prefix:    aws.String(fn),
-			Delimiter: aws.String("/"), // limit to a single directory
-		}
-		isTruncated := true
-
-		for isTruncated {
-			output, err := fs.client.ListObjectsV2(input)
-			if err != nil {
-				return nil, errors.Wrap(err, "s3FS: error listing "+fn)
-			}
 
-			for i := range output.CommonPrefixes {
-				log.Debug().
-					Interface("object", output.CommonPrefixes[i]).
-					Str("fn", fn).
-					Msg("found CommonPrefix")
-				if *output.CommonPrefixes[i].Prefix == fn+"/" {
-					return fs.normalizeCommonPrefix(ctx, output.CommonPrefixes[i]), nil
-				}
-			}
+	lock, err := fs.readLock(ctx, n.id)
+	switch err.(type) {
+	case nil:
+		readLockIntoResourceInfo(ctx, lock, ri)
+	case errtypes.IsNotFound:
+	default:
+		appctx.GetLogger(ctx).Error().Err(err).Str("key", n.key).Msg("could not read lock")
+	}
+	return ri, nil
+}
 
-			input.ContinuationToken = output.NextContinuationToken
-			isTruncated = *output.IsTruncated
+func filterMetadata(md map[string]string, mdKeys []string) map[string]string {
+	filtered := map[string]string{}
+	all := len(mdKeys) == 0
+	wanted := map[string]bool{}
+	for _, k := range mdKeys {
+		if k == "*" {
+			all = true
 		}
-		return nil, errtypes.NotFound(fn)
+		wanted[k] = true
 	}
-
-	return fs.normalizeHead(ctx, output, fn), nil
+	for k, v := range md {
+		if all || wanted[k] {
+			filtered[k] = v
+		}
+	}
+	return filtered
 }
 
 func (fs *s3FS) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys, fieldMask []string) ([]*provider.ResourceInfo, error) {
-	fn, err := fs.resolve(ctx, ref)
+	n, err := fs.lookup(ctx, ref)
 	if err != nil {
-		return nil, errors.Wrap(err, "error resolving ref")
+		return nil, err
+	}
+	if !n.isDir {
+		return nil, errtypes.PreconditionFailed("s3fs: not a folder")
 	}
 
-	input := &s3.ListObjectsV2Input{
+	type child struct {
+		key   string
+		isDir bool
+	}
+	children := []child{}
+	prefix := childPrefix(n.key)
+	err = fs.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket:    aws.String(fs.config.Bucket),
-		Prefix:    aws.String(fn + "/"),
+		Prefix:    aws.String(prefix),
 		Delimiter: aws.String("/"), // limit to a single directory
-	}
-	isTruncated := true
-
-	finfos := []*provider.ResourceInfo{}
-
-	for isTruncated {
-		output, err := fs.client.ListObjectsV2(input)
-		if err != nil {
-			return nil, errors.Wrap(err, "s3FS: error listing "+fn)
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, p := range output.CommonPrefixes {
+			key := strings.TrimSuffix(*p.Prefix, "/")
+			if key != fs.config.MetadataPrefix {
+				children = append(children, child{key: key, isDir: true})
+			}
 		}
-
-		for i := range output.CommonPrefixes {
-			finfos = append(finfos, fs.normalizeCommonPrefix(ctx, output.CommonPrefixes[i]))
+		for _, o := range output.Contents {
+			// skip the marker of the folder itself
+			if *o.Key != prefix {
+				children = append(children, child{key: *o.Key})
+			}
 		}
-
-		for i := range output.Contents {
-			finfos = append(finfos, fs.normalizeObject(ctx, output.Contents[i], *output.Contents[i].Key))
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "s3FS: error listing "+n.key)
+	}
+
+	// the ids are only available from the object metadata, so every child needs a HEAD request
+	finfos := make([]*provider.ResourceInfo, len(children))
+	errs := make([]error, len(children))
+	sem := make(chan struct{}, fs.config.Concurrency)
+	wg := sync.WaitGroup{}
+	for i, c := range children {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c child) {
+			defer func() { <-sem; wg.Done() }()
+			cn, err := fs.lookupChild(ctx, c.key, c.isDir)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			finfos[i] = fs.normalize(ctx, cn)
+		}(i, c)
+	}
+	wg.Wait()
+
+	result := make([]*provider.ResourceInfo, 0, len(finfos))
+	for i, ri := range finfos {
+		switch errs[i].(type) {
+		case nil:
+			result = append(result, ri)
+		case errtypes.IsNotFound:
+			// deleted in the meantime
+		default:
+			return nil, errs[i]
 		}
-
-		input.ContinuationToken = output.NextContinuationToken
-		isTruncated = *output.IsTruncated
 	}
-	// TODO sort fileinfos?
-	return finfos, nil
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
 }
 
 func (fs *s3FS) Download(ctx context.Context, ref *provider.Reference, openReaderfunc func(*provider.ResourceInfo) bool) (*provider.ResourceInfo, io.ReadCloser, error) {
-	log := appctx.GetLogger(ctx)
-
-	fn, err := fs.resolve(ctx, ref)
+	n, err := fs.lookup(ctx, ref)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "error resolving ref")
+		return nil, nil, err
 	}
-
-	ri, err := fs.GetMD(ctx, ref, nil, []string{"size", "mimetype", "etag"})
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "error getting metadata")
+	if n.isDir {
+		return nil, nil, errtypes.PreconditionFailed("s3fs: can not download a folder")
 	}
+	ri := fs.normalize(ctx, n)
 
 	if !openReaderfunc(ri) {
 		return ri, nil, nil
@@ -636,53 +634,19 @@ func (fs *s3FS) Download(ctx context.Context, ref *provider.Reference, openReade
 
 	// use GetObject instead of s3manager.Downloader:
 	// the result.Body is a ReadCloser, which allows streaming
-	// TODO double check we are not caching bytes in memory
-	r, err := fs.client.GetObject(&s3.GetObjectInput{
+	r, err := fs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(fn),
+		Key:    aws.String(n.key),
 	})
 	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-			case s3.ErrCodeNoSuchKey:
-				return nil, nil, errtypes.NotFound(fn)
-			}
+		if isNotFound(err) {
+			return nil, nil, errtypes.NotFound(fs.storagePath(n.key))
 		}
-		return nil, nil, errors.Wrap(err, "s3fs: error deleting "+fn)
+		return nil, nil, errors.Wrap(err, "s3fs: error downloading "+n.key)
 	}
 	return ri, r.Body, nil
 }
 
-func (fs *s3FS) ListRevisions(ctx context.Context, ref *provider.Reference) ([]*provider.FileVersion, error) {
-	return nil, errtypes.NotSupported("list revisions")
-}
-
-func (fs *s3FS) DownloadRevision(ctx context.Context, ref *provider.Reference, revisionKey string, openReaderfunc func(*provider.ResourceInfo) bool) (*provider.ResourceInfo, io.ReadCloser, error) {
-	return nil, nil, errtypes.NotSupported("download revision")
-}
-
-func (fs *s3FS) RestoreRevision(ctx context.Context, ref *provider.Reference, revisionKey string) error {
-	return errtypes.NotSupported("restore revision")
-}
-
-func (fs *s3FS) PurgeRecycleItem(ctx context.Context, ref *provider.Reference, key, relativePath string) error {
-	return errtypes.NotSupported("purge recycle item")
-}
-
-func (fs *s3FS) EmptyRecycle(ctx context.Context, ref *provider.Reference) error {
-	return errtypes.NotSupported("empty recycle")
-}
-
-func (fs *s3FS) ListRecycle(ctx context.Context, ref *provider.Reference, key, relativePath string) ([]*provider.RecycleItem, error) {
-	return nil, errtypes.NotSupported("list recycle")
-}
-
-func (fs *s3FS) RestoreRecycleItem(ctx context.Context, ref *provider.Reference, key, relativePath string, restoreRef *provider.Reference) error {
-	return errtypes.NotSupported("restore recycle")
-}
-
 func (fs *s3FS) ListStorageSpaces(ctx context.Context, filter []*provider.ListStorageSpacesRequest_Filter, unrestricted bool) ([]*provider.StorageSpace, error) {
 	return nil, errtypes.NotSupported("list storage spaces")
 }
@@ -696,3 +660,7 @@ func (fs *s3FS) UpdateStorageSpace(ctx context.Context, req *provider.UpdateStor
 func (fs *s3FS) DeleteStorageSpace(ctx context.Context, req *provider.DeleteStorageSpaceRequest) error {
 	return errtypes.NotSupported("delete storage space")
 }
+
+func newID() string {
+	return uuid.New().String()
+}
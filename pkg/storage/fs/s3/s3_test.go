@@ -0,0 +1,408 @@
+// Copyright 2018-2021 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/storage"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/s3"
+)
+
+// markerName replaces the trailing slash of folder markers. gofakes3 trims slashes from
+// object keys, so the markers would otherwise end up as files.
+const markerName = ".folder-marker"
+
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) WriteHeader(status int) { r.status = status }
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+// fakeS3 serves the backend and translates folder marker keys
+func fakeS3(backend gofakes3.Backend) http.Handler {
+	h := gofakes3.New(backend).Server()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			if strings.HasSuffix(r.URL.Path, "/") {
+				r.URL.Path += markerName
+				r.URL.RawPath = ""
+			}
+			if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+				p, q, _ := strings.Cut(src, "?")
+				if strings.HasSuffix(p, "/") {
+					p += markerName
+				}
+				if q != "" {
+					p += "?" + q
+				}
+				r.Header.Set("X-Amz-Copy-Source", p)
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// listings
+		br := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(br, r)
+		body := bytes.ReplaceAll(br.body.Bytes(), []byte("/"+markerName+"<"), []byte("/<"))
+		w.Header().Del("Content-Length")
+		w.WriteHeader(br.status)
+		_, _ = w.Write(body)
+	})
+}
+
+var _ = Describe("S3", func() {
+	var (
+		server  *httptest.Server
+		backend *s3mem.Backend
+		fs      storage.FS
+		ctx     context.Context
+		config  map[string]interface{}
+
+		user = &userpb.User{
+			Id:       &userpb.UserId{OpaqueId: "einstein"},
+			Username: "einstein",
+		}
+	)
+
+	upload := func(p, content string) *provider.ResourceInfo {
+		ri, err := fs.Upload(ctx, storage.UploadRequest{
+			Ref:    &provider.Reference{Path: p},
+			Body:   io.NopCloser(strings.NewReader(content)),
+			Length: int64(len(content)),
+		}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		return ri
+	}
+
+	download := func(ref *provider.Reference) string {
+		_, r, err := fs.Download(ctx, ref, func(*provider.ResourceInfo) bool { return true })
+		Expect(err).ToNot(HaveOccurred())
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		return string(b)
+	}
+
+	BeforeEach(func() {
+		backend = s3mem.New()
+		server = httptest.NewServer(fakeS3(backend))
+		Expect(backend.CreateBucket("reva")).To(Succeed())
+		ctx = ctxpkg.ContextSetUser(context.Background(), user)
+		config = map[string]interface{}{
+			"endpoint":   server.URL,
+			"bucket":     "reva",
+			"prefix":     "/data",
+			"access_key": "key",
+			"secret_key": "secret",
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		fs, err = s3.New(config, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fs.CreateDir(ctx, &provider.Reference{Path: "/folder"})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("ids", func() {
+		It("resolves ids to paths", func() {
+			ri := upload("/folder/file.txt", "hello")
+			p, err := fs.GetPathByID(ctx, ri.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal("/folder/file.txt"))
+		})
+
+		It("keeps the ids of moved files and folders", func() {
+			file := upload("/folder/file.txt", "hello")
+			folder, err := fs.GetMD(ctx, &provider.Reference{Path: "/folder"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(fs.Move(ctx, &provider.Reference{Path: "/folder"}, &provider.Reference{Path: "/renamed"})).To(Succeed())
+
+			ri, err := fs.GetMD(ctx, &provider.Reference{Path: "/renamed"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Id.OpaqueId).To(Equal(folder.Id.OpaqueId))
+
+			p, err := fs.GetPathByID(ctx, file.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal("/renamed/file.txt"))
+			Expect(download(&provider.Reference{ResourceId: file.Id})).To(Equal("hello"))
+		})
+
+		It("keeps the id when a file is overwritten", func() {
+			first := upload("/folder/file.txt", "hello")
+			second := upload("/folder/file.txt", "world")
+			Expect(second.Id.OpaqueId).To(Equal(first.Id.OpaqueId))
+		})
+
+		It("assigns ids to objects created by other clients", func() {
+			_, err := backend.PutObject("reva", "data/foreign.txt", nil, bytes.NewReader([]byte("foreign")), 7)
+			Expect(err).ToNot(HaveOccurred())
+
+			ri, err := fs.GetMD(ctx, &provider.Reference{Path: "/foreign.txt"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Id.OpaqueId).To(Equal("fileid-foreign.txt"))
+
+			Expect(fs.SetArbitraryMetadata(ctx, &provider.Reference{Path: "/foreign.txt"}, &provider.ArbitraryMetadata{
+				Metadata: map[string]string{"foo": "bar"},
+			})).To(Succeed())
+			ri, err = fs.GetMD(ctx, &provider.Reference{Path: "/foreign.txt"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Id.OpaqueId).ToNot(HavePrefix("fileid-"))
+			Expect(ri.ArbitraryMetadata.Metadata).To(HaveKeyWithValue("foo", "bar"))
+			Expect(download(&provider.Reference{ResourceId: ri.Id})).To(Equal("foreign"))
+		})
+	})
+
+	Describe("ListFolder", func() {
+		It("hides the metadata", func() {
+			upload("/folder/file.txt", "hello")
+			Expect(fs.CreateDir(ctx, &provider.Reference{Path: "/folder/sub"})).To(Succeed())
+
+			root, err := fs.ListFolder(ctx, &provider.Reference{Path: "/"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(root).To(HaveLen(1))
+			Expect(root[0].Path).To(Equal("/folder"))
+
+			children, err := fs.ListFolder(ctx, &provider.Reference{Path: "/folder"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(children).To(HaveLen(2))
+			Expect(children[0].Path).To(Equal("/folder/file.txt"))
+			Expect(children[0].Type).To(Equal(provider.ResourceType_RESOURCE_TYPE_FILE))
+			Expect(children[1].Path).To(Equal("/folder/sub"))
+			Expect(children[1].Type).To(Equal(provider.ResourceType_RESOURCE_TYPE_CONTAINER))
+		})
+	})
+
+	Describe("grants", func() {
+		grant := &provider.Grant{
+			Grantee: &provider.Grantee{
+				Type: provider.GranteeType_GRANTEE_TYPE_USER,
+				Id:   &provider.Grantee_UserId{UserId: &userpb.UserId{OpaqueId: "marie"}},
+			},
+			Permissions: &provider.ResourcePermissions{Stat: true},
+		}
+		ref := &provider.Reference{Path: "/folder"}
+
+		It("adds, updates and removes grants", func() {
+			Expect(fs.AddGrant(ctx, ref, grant)).To(Succeed())
+			Expect(fs.AddGrant(ctx, ref, grant)).To(BeAssignableToTypeOf(errtypes.AlreadyExists("")))
+
+			grants, err := fs.ListGrants(ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(grants).To(HaveLen(1))
+			Expect(grants[0].Permissions.Stat).To(BeTrue())
+
+			updated := &provider.Grant{Grantee: grant.Grantee, Permissions: &provider.ResourcePermissions{Stat: true, ListContainer: true}}
+			Expect(fs.UpdateGrant(ctx, ref, updated)).To(Succeed())
+			grants, err = fs.ListGrants(ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(grants[0].Permissions.ListContainer).To(BeTrue())
+
+			Expect(fs.RemoveGrant(ctx, ref, grant)).To(Succeed())
+			grants, err = fs.ListGrants(ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(grants).To(BeEmpty())
+		})
+	})
+
+	Describe("locks", func() {
+		ref := &provider.Reference{Path: "/folder/file.txt"}
+		lock := &provider.Lock{
+			Type:   provider.LockType_LOCK_TYPE_EXCL,
+			LockId: "lock-1",
+			User:   &userpb.UserId{OpaqueId: "einstein"},
+		}
+
+		JustBeforeEach(func() {
+			upload("/folder/file.txt", "hello")
+		})
+
+		It("sets and removes locks", func() {
+			Expect(fs.SetLock(ctx, ref, lock)).To(Succeed())
+			Expect(fs.SetLock(ctx, ref, lock)).To(BeAssignableToTypeOf(errtypes.PreconditionFailed("")))
+
+			l, err := fs.GetLock(ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.LockId).To(Equal("lock-1"))
+
+			ri, err := fs.GetMD(ctx, ref, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ri.Lock.GetLockId()).To(Equal("lock-1"))
+
+			Expect(fs.Unlock(ctx, ref, lock)).To(Succeed())
+			_, err = fs.GetLock(ctx, ref)
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+		})
+
+		It("protects locked files", func() {
+			Expect(fs.SetLock(ctx, ref, lock)).To(Succeed())
+
+			Expect(fs.Delete(ctx, ref)).To(BeAssignableToTypeOf(errtypes.Locked("")))
+			Expect(fs.Move(ctx, ref, &provider.Reference{Path: "/folder/moved.txt"})).To(BeAssignableToTypeOf(errtypes.Locked("")))
+
+			lockedCtx := ctxpkg.ContextSetLockID(ctx, "lock-1")
+			Expect(fs.Move(lockedCtx, ref, &provider.Reference{Path: "/folder/moved.txt"})).To(Succeed())
+		})
+	})
+
+	Describe("trash", func() {
+		It("restores deleted folders", func() {
+			file := upload("/folder/file.txt", "hello")
+			Expect(fs.Delete(ctx, &provider.Reference{Path: "/folder"})).To(Succeed())
+
+			_, err := fs.GetMD(ctx, &provider.Reference{Path: "/folder"}, nil, nil)
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+			_, err = fs.GetPathByID(ctx, file.Id)
+			Expect(err).To(BeAssignableToTypeOf(errtypes.NotFound("")))
+
+			items, err := fs.ListRecycle(ctx, nil, "", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].Ref.Path).To(Equal("/folder"))
+			Expect(items[0].Size).To(Equal(uint64(5)))
+
+			children, err := fs.ListRecycle(ctx, nil, items[0].Key, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(children).To(HaveLen(1))
+
+			Expect(fs.RestoreRecycleItem(ctx, nil, items[0].Key, "", nil)).To(Succeed())
+			p, err := fs.GetPathByID(ctx, file.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal("/folder/file.txt"))
+
+			items, err = fs.ListRecycle(ctx, nil, "", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(items).To(BeEmpty())
+		})
+
+		It("lists and restores children of deleted folders", func() {
+			upload("/folder/file.txt", "hello")
+			Expect(fs.Delete(ctx, &provider.Reference{Path: "/folder"})).To(Succeed())
+			items, err := fs.ListRecycle(ctx, nil, "", "")
+			Expect(err).ToNot(HaveOccurred())
+
+			children, err := fs.ListRecycle(ctx, nil, items[0].Key, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(children).To(HaveLen(1))
+			Expect(children[0].Ref.Path).To(Equal("/folder/file.txt"))
+
+			Expect(fs.RestoreRecycleItem(ctx, nil, items[0].Key, "file.txt", &provider.Reference{Path: "/file.txt"})).To(Succeed())
+			Expect(download(&provider.Reference{Path: "/file.txt"})).To(Equal("hello"))
+		})
+
+		It("purges deleted files", func() {
+			upload("/folder/file.txt", "hello")
+			Expect(fs.Delete(ctx, &provider.Reference{Path: "/folder/file.txt"})).To(Succeed())
+			items, err := fs.ListRecycle(ctx, nil, "", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(items).To(HaveLen(1))
+
+			Expect(fs.EmptyRecycle(ctx, nil)).To(Succeed())
+			items, err = fs.ListRecycle(ctx, nil, "", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(items).To(BeEmpty())
+		})
+	})
+
+	Describe("revisions", func() {
+		BeforeEach(func() {
+			config["enable_versioning"] = true
+		})
+
+		It("lists, downloads and restores revisions", func() {
+			ref := &provider.Reference{Path: "/folder/file.txt"}
+			upload("/folder/file.txt", "first")
+			upload("/folder/file.txt", "second")
+
+			revisions, err := fs.ListRevisions(ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(HaveLen(1))
+
+			_, r, err := fs.DownloadRevision(ctx, ref, revisions[0].Key, func(*provider.ResourceInfo) bool { return true })
+			Expect(err).ToNot(HaveOccurred())
+			b, err := io.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal("first"))
+
+			Expect(fs.RestoreRevision(ctx, ref, revisions[0].Key)).To(Succeed())
+			Expect(download(ref)).To(Equal("first"))
+			revisions, err = fs.ListRevisions(ctx, ref)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(HaveLen(2))
+		})
+	})
+
+	Describe("quota", func() {
+		BeforeEach(func() {
+			config["quota"] = 10
+		})
+
+		It("rejects uploads exceeding the quota", func() {
+			upload("/folder/file.txt", "hello")
+
+			total, used, remaining, err := fs.GetQuota(ctx, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(Equal(uint64(10)))
+			Expect(used).To(Equal(uint64(5)))
+			Expect(remaining).To(Equal(uint64(5)))
+
+			_, err = fs.InitiateUpload(ctx, &provider.Reference{Path: "/folder/big.txt"}, 6, nil)
+			Expect(err).To(BeAssignableToTypeOf(errtypes.InsufficientStorage("")))
+		})
+
+		It("uploads through upload sessions", func() {
+			ids, err := fs.InitiateUpload(ctx, &provider.Reference{Path: "/folder/small.txt"}, 3, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = fs.Upload(ctx, storage.UploadRequest{
+				Ref:    &provider.Reference{Path: ids["simple"]},
+				Body:   io.NopCloser(strings.NewReader("abc")),
+				Length: 3,
+			}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(download(&provider.Reference{Path: "/folder/small.txt"})).To(Equal("abc"))
+		})
+	})
+})
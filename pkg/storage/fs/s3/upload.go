@@ -20,6 +20,10 @@ package s3
 
 import (
 	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -28,48 +32,185 @@ import (
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/mime"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
 	"github.com/pkg/errors"
 )
 
-func (fs *s3FS) Upload(ctx context.Context, req storage.UploadRequest, uff storage.UploadFinishedFunc) (*provider.ResourceInfo, error) {
-	log := appctx.GetLogger(ctx)
+// uploadSession remembers the target of an upload between InitiateUpload and Upload
+type uploadSession struct {
+	Key    string `json:"key"`
+	Length int64  `json:"length"`
+	MTime  string `json:"mtime,omitempty"`
+}
 
-	fn, err := fs.resolve(ctx, req.Ref)
+func (fs *s3FS) uploadSessionKey(id string) string {
+	return fs.metaKey("uploads", id+".json")
+}
+
+// InitiateUpload returns upload ids corresponding to different protocols it supports
+func (fs *s3FS) InitiateUpload(ctx context.Context, ref *provider.Reference, uploadLength int64, metadata map[string]string) (map[string]string, error) {
+	key, err := fs.resolve(ctx, ref)
 	if err != nil {
-		return &provider.ResourceInfo{}, errors.Wrap(err, "error resolving ref")
+		return nil, errors.Wrap(err, "error resolving ref")
 	}
 
-	upParams := &s3manager.UploadInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(fn),
-		Body:   req.Body,
+	n, err := fs.lookupKey(ctx, key)
+	switch err.(type) {
+	case nil:
+		if n.isDir {
+			return nil, errtypes.PreconditionFailed("can not upload to a folder")
+		}
+		if err := fs.checkLock(ctx, n); err != nil {
+			return nil, err
+		}
+	case errtypes.IsNotFound:
+		parent, err := fs.lookupKey(ctx, parentKey(key))
+		if err != nil {
+			return nil, err
+		}
+		if !parent.isDir {
+			return nil, errtypes.PreconditionFailed("parent is not a folder")
+		}
+	default:
+		return nil, err
 	}
-	uploader := s3manager.NewUploaderWithClient(fs.client)
-	result, err := uploader.Upload(upParams)
 
-	if err != nil {
-		log.Error().Err(err)
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == s3.ErrCodeNoSuchBucket {
-				return &provider.ResourceInfo{}, errtypes.NotFound(fn)
+	if err := fs.checkQuota(ctx, uploadLength); err != nil {
+		return nil, err
+	}
+
+	id := newID()
+	session := &uploadSession{Key: key, Length: uploadLength, MTime: metadata["mtime"]}
+	if err := fs.putJSON(ctx, fs.uploadSessionKey(id), session); err != nil {
+		return nil, errors.Wrap(err, "s3fs: error writing upload session")
+	}
+	return map[string]string{
+		"simple": id,
+	}, nil
+}
+
+// Upload writes the body to the object. The reference either carries the id of an upload
+// session as its path or points to the target directly.
+func (fs *s3FS) Upload(ctx context.Context, req storage.UploadRequest, uff storage.UploadFinishedFunc) (*provider.ResourceInfo, error) {
+	session := &uploadSession{Length: req.Length}
+	sessionID := ""
+	if req.Ref.GetResourceId() == nil && !strings.Contains(req.Ref.GetPath(), "/") {
+		sessionID = req.Ref.GetPath()
+		if _, err := fs.getJSON(ctx, fs.uploadSessionKey(sessionID), session); err != nil {
+			if isNotFound(err) {
+				return &provider.ResourceInfo{}, errtypes.NotFound("upload session " + sessionID)
 			}
+			return &provider.ResourceInfo{}, errors.Wrap(err, "s3fs: error reading upload session")
+		}
+	} else {
+		key, err := fs.resolve(ctx, req.Ref)
+		if err != nil {
+			return &provider.ResourceInfo{}, errors.Wrap(err, "error resolving ref")
+		}
+		session.Key = key
+		n, err := fs.lookupKey(ctx, key)
+		switch err.(type) {
+		case nil:
+			if n.isDir {
+				return &provider.ResourceInfo{}, errtypes.PreconditionFailed("can not upload to a folder")
+			}
+			if err := fs.checkLock(ctx, n); err != nil {
+				return &provider.ResourceInfo{}, err
+			}
+		case errtypes.IsNotFound:
+		default:
+			return &provider.ResourceInfo{}, err
+		}
+		if err := fs.checkQuota(ctx, req.Length); err != nil {
+			return &provider.ResourceInfo{}, err
 		}
-		return &provider.ResourceInfo{}, errors.Wrap(err, "s3fs: error creating object "+fn)
 	}
 
-	log.Debug().Interface("result", result) // todo cache etag?
-
-	// return id, etag and mtime
-	ri, err := fs.GetMD(ctx, req.Ref, []string{}, []string{"id", "etag", "mtime"})
+	ri, err := fs.upload(ctx, session, req.Body)
 	if err != nil {
 		return &provider.ResourceInfo{}, err
 	}
-
+	if sessionID != "" {
+		if err := fs.deleteObject(ctx, fs.uploadSessionKey(sessionID)); err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("session", sessionID).Msg("could not remove upload session")
+		}
+	}
 	return ri, nil
 }
 
-// InitiateUpload returns upload ids corresponding to different protocols it supports
-func (fs *s3FS) InitiateUpload(ctx context.Context, ref *provider.Reference, uploadLength int64, metadata map[string]string) (map[string]string, error) {
-	return nil, errtypes.NotSupported("op not supported")
+func (fs *s3FS) upload(ctx context.Context, session *uploadSession, body io.Reader) (*provider.ResourceInfo, error) {
+	log := appctx.GetLogger(ctx)
+
+	// overwriting a file keeps its id
+	var id string
+	n, err := fs.lookupChild(ctx, session.Key, false)
+	switch err.(type) {
+	case nil:
+		id, err = fs.ensureID(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+	case errtypes.IsNotFound:
+		parentID, err := fs.idOf(ctx, parentKey(session.Key))
+		if err != nil {
+			return nil, err
+		}
+		if id, err = fs.createRecord(ctx, parentID, path.Base(session.Key)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	mtime := time.Now()
+	if session.MTime != "" {
+		if t, err := utils.MTimeToTime(session.MTime); err == nil {
+			mtime = t
+		}
+	}
+
+	uploader := s3manager.NewUploaderWithClient(fs.client, func(u *s3manager.Uploader) {
+		u.Concurrency = fs.config.Concurrency
+	})
+	result, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(fs.config.Bucket),
+		Key:         aws.String(session.Key),
+		Body:        body,
+		ContentType: aws.String(mime.Detect(false, session.Key)),
+		Metadata: map[string]*string{
+			idMetadataKey:    aws.String(id),
+			mtimeMetadataKey: aws.String(utils.TimeToOCMtime(mtime)),
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchBucket {
+			return nil, errtypes.NotFound(fs.storagePath(session.Key))
+		}
+		return nil, errors.Wrap(err, "s3fs: error creating object "+session.Key)
+	}
+	log.Debug().Str("location", result.Location).Msg("uploaded object")
+	fs.invalidateUsedBytes()
+
+	n, err = fs.lookupChild(ctx, session.Key, false)
+	if err != nil {
+		return nil, err
+	}
+	return fs.normalize(ctx, n), nil
+}
+
+// checkQuota returns an error if the upload would exceed the configured quota
+func (fs *s3FS) checkQuota(ctx context.Context, length int64) error {
+	if fs.config.Quota == 0 || length <= 0 {
+		return nil
+	}
+	used, err := fs.getUsedBytes(ctx)
+	if err != nil {
+		return err
+	}
+	if used+uint64(length) > fs.config.Quota {
+		return errtypes.InsufficientStorage("quota exceeded")
+	}
+	return nil
 }
@@ -0,0 +1,181 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	// the database of ownCloud 10 is read using the mysql driver
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	publicshareregistry "github.com/opencloud-eu/reva/v2/pkg/publicshare/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/share"
+	shareregistry "github.com/opencloud-eu/reva/v2/pkg/share/manager/registry"
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
+	fsregistry "github.com/opencloud-eu/reva/v2/pkg/storage/fs/registry"
+)
+
+// Config is the configuration of a migration
+type Config struct {
+	// ownCloud 10 data directory and database
+	DataDirectory string `mapstructure:"datadirectory"`
+	DbUsername    string `mapstructure:"dbusername"`
+	DbPassword    string `mapstructure:"dbpassword"`
+	DbHost        string `mapstructure:"dbhost"`
+	DbPort        int    `mapstructure:"dbport"`
+	DbName        string `mapstructure:"dbname"`
+
+	// Users limits the migration to the given users
+	Users           []string `mapstructure:"users"`
+	JournalFile     string   `mapstructure:"journal_file"`
+	ReportFile      string   `mapstructure:"report_file"`
+	VerifyChecksums bool     `mapstructure:"verify_checksums"`
+
+	// the gateway is used to look up users and groups
+	GatewayAddr          string `mapstructure:"gateway_addr"`
+	ServiceAccountID     string `mapstructure:"service_account_id"`
+	ServiceAccountSecret string `mapstructure:"service_account_secret"`
+
+	// target storage driver and share managers
+	Driver              string                            `mapstructure:"driver"`
+	Drivers             map[string]map[string]interface{} `mapstructure:"drivers"`
+	ShareManager        string                            `mapstructure:"share_manager"`
+	ShareManagers       map[string]map[string]interface{} `mapstructure:"share_managers"`
+	PublicShareManager  string                            `mapstructure:"public_share_manager"`
+	PublicShareManagers map[string]map[string]interface{} `mapstructure:"public_share_managers"`
+}
+
+func (c *Config) init() {
+	if c.DbPort == 0 {
+		c.DbPort = 3306
+	}
+	if c.JournalFile == "" {
+		c.JournalFile = "owncloudsql-migration.jsonl"
+	}
+	if c.ReportFile == "" {
+		c.ReportFile = "owncloudsql-migration-report.json"
+	}
+	if c.Driver == "" {
+		c.Driver = "ocis"
+	}
+	if c.ShareManager == "" {
+		c.ShareManager = "jsoncs3"
+	}
+	if c.PublicShareManager == "" {
+		c.PublicShareManager = "json"
+	}
+	c.GatewayAddr = sharedconf.GetGatewaySVC(c.GatewayAddr)
+}
+
+// Run migrates the ownCloud 10 instance described by the given configuration, unless
+// verifyOnly is set, and verifies the result. The report is written to the report file.
+func Run(ctx context.Context, m map[string]interface{}, verifyOnly bool, log *zerolog.Logger) (*Report, error) {
+	c := &Config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "error decoding conf")
+	}
+	c.init()
+
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", c.DbUsername, c.DbPassword, c.DbHost, c.DbPort, c.DbName))
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to the database")
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return nil, errors.Wrap(err, "error connecting to the database")
+	}
+	source, err := NewSource("mysql", db, c.DataDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	newFS, ok := fsregistry.NewFuncs[c.Driver]
+	if !ok {
+		return nil, fmt.Errorf("driver %s not found", c.Driver)
+	}
+	fs, err := newFS(c.Drivers[c.Driver], nil, log)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Shutdown(ctx)
+
+	shares, err := loadShareManager(c.ShareManager, c.ShareManagers[c.ShareManager])
+	if err != nil {
+		return nil, err
+	}
+	publicShares, err := loadPublicShareManager(c.PublicShareManager, c.PublicShareManagers[c.PublicShareManager])
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := OpenJournal(c.JournalFile)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	migrator := New(source, fs, shares, publicShares, NewGatewayResolver(c.GatewayAddr, c.ServiceAccountID, c.ServiceAccountSecret), journal, log)
+	if !verifyOnly {
+		if err := migrator.Migrate(ctx, c.Users); err != nil {
+			return migrator.Report(), err
+		}
+	}
+	if err := migrator.Verify(ctx, c.Users, c.VerifyChecksums); err != nil {
+		return migrator.Report(), err
+	}
+	return migrator.Report(), migrator.Report().WriteFile(c.ReportFile)
+}
+
+func loadShareManager(name string, m map[string]interface{}) (share.LoadableManager, error) {
+	f, ok := shareregistry.NewFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("share manager %s not found", name)
+	}
+	mgr, err := f(m)
+	if err != nil {
+		return nil, err
+	}
+	lm, ok := mgr.(share.LoadableManager)
+	if !ok {
+		return nil, fmt.Errorf("share manager %s does not support loading shares", name)
+	}
+	return lm, nil
+}
+
+func loadPublicShareManager(name string, m map[string]interface{}) (publicshare.LoadableManager, error) {
+	f, ok := publicshareregistry.NewFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("public share manager %s not found", name)
+	}
+	mgr, err := f(m)
+	if err != nil {
+		return nil, err
+	}
+	lm, ok := mgr.(publicshare.LoadableManager)
+	if !ok {
+		return nil, fmt.Errorf("public share manager %s does not support loading public shares", name)
+	}
+	return lm, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Journal records the migrated items so that an interrupted migration can be resumed.
+// It is an append only file of json lines, later entries override earlier ones. Every
+// entry is synced to disk before Put returns, so a crash can not lose recorded items.
+type Journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]json.RawMessage
+}
+
+type journalEntry struct {
+	Key   string          `json:"k"`
+	Value json.RawMessage `json:"v"`
+}
+
+// OpenJournal opens or creates the journal at the given path
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{f: f, entries: map[string]json.RawMessage{}}
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a trailing line without newline was not completely written
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		size += int64(len(line))
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		j.entries[e.Key] = e.Value
+	}
+	// cut off the incomplete line, otherwise the next entry would be appended to it
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Get unmarshals the value recorded for the given key into v. It returns false if
+// there is no such key.
+func (j *Journal) Get(key string, v interface{}) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	raw, ok := j.entries[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// Has returns true if the given key has been recorded
+func (j *Journal) Has(key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, ok := j.entries[key]
+	return ok
+}
+
+// Put records a value for the given key and syncs it to disk
+func (j *Journal) Put(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(journalEntry{Key: key, Value: raw})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.entries[key] = raw
+	return nil
+}
+
+// Keys returns the recorded keys with the given prefix
+func (j *Journal) Keys(prefix string) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := []string{}
+	for k := range j.entries {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Sync syncs the journal file to disk
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Sync()
+}
+
+// Close syncs and closes the journal
+func (j *Journal) Close() error {
+	if err := j.Sync(); err != nil {
+		return err
+	}
+	return j.f.Close()
+}
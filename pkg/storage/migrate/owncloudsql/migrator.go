@@ -0,0 +1,855 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/conversions"
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	"github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/storage"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/owncloudsql/filecache"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// Migrator copies the files, versions, trashbins and shares of an ownCloud 10 instance
+// into a decomposedfs storage. Every migrated item is recorded in the journal, so an
+// interrupted migration can be resumed and running it again does not duplicate data.
+//
+// File ids can not be preserved, the storage assigns new ids to the migrated resources. The
+// journal keeps the mapping of the ownCloud 10 file ids to the new resource ids and the
+// verification counts the changed ids in the report. Trash items are deleted at migration time and per user states
+// of group shares are not migrated.
+type Migrator struct {
+	source       *Source
+	fs           storage.FS
+	shares       share.LoadableManager
+	publicShares publicshare.LoadableManager
+	users        UserResolver
+	journal      *Journal
+	log          *zerolog.Logger
+
+	report *Report
+}
+
+// fileRecord is the journal entry of a migrated file or directory
+type fileRecord struct {
+	User       string               `json:"user"`
+	Path       string               `json:"path"`
+	ResourceID *provider.ResourceId `json:"resource_id"`
+}
+
+func spaceKey(user string) string          { return "space:" + user }
+func fileKey(id int) string                { return "file:" + strconv.Itoa(id) }
+func versionKey(id, timestamp int) string  { return fmt.Sprintf("version:%d:%d", id, timestamp) }
+func trashKey(user string, id int) string  { return fmt.Sprintf("trash:%s:%d", user, id) }
+func stagedKey(user string, id int) string { return fmt.Sprintf("trash-staged:%s:%d", user, id) }
+func shareKey(id int) string               { return "share:" + strconv.Itoa(id) }
+
+// New returns a new Migrator. The share managers are optional, shares of the
+// respective type are skipped if they are nil.
+func New(source *Source, fs storage.FS, shares share.LoadableManager, publicShares publicshare.LoadableManager, users UserResolver, journal *Journal, log *zerolog.Logger) *Migrator {
+	return &Migrator{
+		source:       source,
+		fs:           fs,
+		shares:       shares,
+		publicShares: publicShares,
+		users:        users,
+		journal:      journal,
+		log:          log,
+		report:       newReport(),
+	}
+}
+
+// Report returns the report of the migration
+func (m *Migrator) Report() *Report {
+	return m.report
+}
+
+// Migrate migrates the given users, all users if none are given, and afterwards all
+// shares whose resources have been migrated
+func (m *Migrator) Migrate(ctx context.Context, users []string) error {
+	if len(users) == 0 {
+		var err error
+		if users, err = m.source.Users(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, user := range users {
+		m.log.Info().Str("user", user).Msg("migrating user")
+		if err := m.migrateUser(ctx, user); err != nil {
+			m.log.Error().Err(err).Str("user", user).Msg("could not migrate user")
+			m.report.problem(user, "", "could not migrate user: %s", err)
+		}
+	}
+
+	if err := m.migrateShares(ctx); err != nil {
+		return err
+	}
+	m.report.Finished = time.Now()
+	return nil
+}
+
+func (m *Migrator) migrateUser(ctx context.Context, user string) error {
+	u, err := m.users.User(ctx, user)
+	if err != nil {
+		return err
+	}
+	ctx = ctxpkg.ContextSetUser(ctx, u)
+	ur := m.report.user(user)
+
+	root, err := m.personalSpace(ctx, user, u)
+	if err != nil {
+		return err
+	}
+	storageID, err := m.source.Storage(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	err = m.source.Walk(ctx, storageID, func(f *filecache.File) error {
+		if m.journal.Has(fileKey(f.ID)) {
+			ur.Skipped++
+			return nil
+		}
+		rel := strings.TrimPrefix(f.Path, "files/")
+		ref := &provider.Reference{ResourceId: root, Path: utils.MakeRelativePath(rel)}
+
+		var info *provider.ResourceInfo
+		var err error
+		if f.MimeTypeString == mimetypeDirectory {
+			info, err = m.createDir(ctx, ref)
+			ur.Directories++
+		} else {
+			info, err = m.migrateFile(ctx, user, f, ref, ur)
+			ur.Files++
+		}
+		if err != nil {
+			m.report.problem(user, f.Path, "could not migrate: %s", err)
+			return nil
+		}
+		return m.journal.Put(fileKey(f.ID), fileRecord{User: user, Path: rel, ResourceID: info.GetId()})
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.migrateTrash(ctx, user, root, ur)
+}
+
+// personalSpace returns the root of the personal space of the user, it is created if necessary
+func (m *Migrator) personalSpace(ctx context.Context, user string, u *userpb.User) (*provider.ResourceId, error) {
+	root := &provider.ResourceId{}
+	if m.journal.Get(spaceKey(user), root) {
+		return root, nil
+	}
+
+	spaces, err := m.fs.ListStorageSpaces(ctx, []*provider.ListStorageSpacesRequest_Filter{
+		{Type: provider.ListStorageSpacesRequest_Filter_TYPE_SPACE_TYPE, Term: &provider.ListStorageSpacesRequest_Filter_SpaceType{SpaceType: "personal"}},
+		{Type: provider.ListStorageSpacesRequest_Filter_TYPE_OWNER, Term: &provider.ListStorageSpacesRequest_Filter_Owner{Owner: u.GetId()}},
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	var space *provider.StorageSpace
+	if len(spaces) > 0 {
+		space = spaces[0]
+	} else {
+		res, err := m.fs.CreateStorageSpace(ctx, &provider.CreateStorageSpaceRequest{Type: "personal", Owner: u, Name: u.GetDisplayName()})
+		switch {
+		case err != nil:
+			return nil, err
+		case res.GetStatus().GetCode() != rpc.Code_CODE_OK:
+			return nil, errtypes.NewErrtypeFromStatus(res.GetStatus())
+		}
+		space = res.GetStorageSpace()
+	}
+
+	root = space.GetRoot()
+	return root, m.journal.Put(spaceKey(user), root)
+}
+
+func (m *Migrator) createDir(ctx context.Context, ref *provider.Reference) (*provider.ResourceInfo, error) {
+	var exists errtypes.IsAlreadyExists
+	if err := m.fs.CreateDir(ctx, ref); err != nil && !errors.As(err, &exists) {
+		return nil, err
+	}
+	return m.fs.GetMD(ctx, ref, nil, nil)
+}
+
+// migrateFile uploads the versions of a file, oldest first, followed by the current content.
+// Decomposedfs turns the previous content into a revision on every upload.
+func (m *Migrator) migrateFile(ctx context.Context, user string, f *filecache.File, ref *provider.Reference, ur *UserReport) (*provider.ResourceInfo, error) {
+	versions, err := m.source.Versions(user, strings.TrimPrefix(f.Path, "files/"))
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err := m.uploadedVersions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if m.journal.Has(versionKey(f.ID, v.Timestamp)) {
+			continue
+		}
+		// the version may have been uploaded by a previous run that was interrupted before recording it
+		if !uploaded[uint64(v.Timestamp)] {
+			if _, err := m.upload(ctx, ref, v.Path, v.Timestamp); err != nil {
+				return nil, fmt.Errorf("could not upload version %d: %w", v.Timestamp, err)
+			}
+		}
+		if err := m.journal.Put(versionKey(f.ID, v.Timestamp), true); err != nil {
+			return nil, err
+		}
+		ur.Versions++
+	}
+	return m.upload(ctx, ref, m.source.Path(user, f.Path), f.MTime)
+}
+
+// uploadedVersions returns the mtimes of the revisions and the current content of the target
+func (m *Migrator) uploadedVersions(ctx context.Context, ref *provider.Reference) (map[uint64]bool, error) {
+	uploaded := map[uint64]bool{}
+	info, err := m.fs.GetMD(ctx, ref, nil, nil)
+	var notFound errtypes.IsNotFound
+	switch {
+	case errors.As(err, &notFound):
+		return uploaded, nil
+	case err != nil:
+		return nil, err
+	}
+	uploaded[info.GetMtime().GetSeconds()] = true
+
+	revisions, err := m.fs.ListRevisions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revisions {
+		uploaded[r.GetMtime()] = true
+	}
+	return uploaded, nil
+}
+
+// upload writes the content of the local file to the target. It is skipped if the target
+// already has the size and mtime, e.g. because a previous run was interrupted before
+// recording the upload.
+func (m *Migrator) upload(ctx context.Context, ref *provider.Reference, localPath string, mtime int) (*provider.ResourceInfo, error) {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := m.fs.GetMD(ctx, ref, nil, nil); err == nil &&
+		info.GetSize() == uint64(fi.Size()) && info.GetMtime().GetSeconds() == uint64(mtime) {
+		return info, nil
+	}
+
+	ids, err := m.fs.InitiateUpload(ctx, ref, fi.Size(), map[string]string{"mtime": strconv.Itoa(mtime)})
+	if err != nil {
+		return nil, err
+	}
+	// empty uploads are finished right away
+	if fi.Size() > 0 {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := m.fs.Upload(ctx, storage.UploadRequest{
+			Ref:    &provider.Reference{Path: ids["simple"]},
+			Body:   f,
+			Length: fi.Size(),
+		}, nil); err != nil {
+			return nil, err
+		}
+	}
+	return m.fs.GetMD(ctx, ref, nil, nil)
+}
+
+// uploadTree uploads a local directory tree, used for deleted folders
+func (m *Migrator) uploadTree(ctx context.Context, ref *provider.Reference, localPath string) error {
+	if _, err := m.createDir(ctx, ref); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		child := &provider.Reference{ResourceId: ref.GetResourceId(), Path: utils.MakeRelativePath(path.Join(ref.GetPath(), e.Name()))}
+		if e.IsDir() {
+			if err := m.uploadTree(ctx, child, filepath.Join(localPath, e.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if _, err := m.upload(ctx, child, filepath.Join(localPath, e.Name()), int(fi.ModTime().Unix())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTrash restores every trash item of the user at its original location, or the
+// space root if that no longer exists, and deletes it again
+func (m *Migrator) migrateTrash(ctx context.Context, user string, root *provider.ResourceId, ur *UserReport) error {
+	items, err := m.source.TrashItems(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if m.journal.Has(trashKey(user, item.ID)) {
+			ur.Skipped++
+			continue
+		}
+		if err := m.migrateTrashItem(ctx, user, root, item); err != nil {
+			m.report.problem(user, item.Path, "could not migrate trash item: %s", err)
+			continue
+		}
+		if err := m.journal.Put(trashKey(user, item.ID), true); err != nil {
+			return err
+		}
+		ur.TrashItems++
+	}
+	return nil
+}
+
+// stagedItem is the journal entry of a trash item while it is restored
+type stagedItem struct {
+	Path     string `json:"path"`
+	Uploaded bool   `json:"uploaded"`
+}
+
+// migrateTrashItem restores the trash item and deletes it again. The location is recorded before
+// anything is uploaded and again once the upload is complete, so an interrupted run continues with
+// the same location and does not restore the item twice.
+func (m *Migrator) migrateTrashItem(ctx context.Context, user string, root *provider.ResourceId, item TrashItem) error {
+	fi, err := os.Stat(item.Path)
+	if err != nil {
+		return err
+	}
+
+	staged := stagedItem{}
+	if !m.journal.Get(stagedKey(user, item.ID), &staged) {
+		location := item.Location
+		if _, err := m.fs.GetMD(ctx, &provider.Reference{ResourceId: root, Path: utils.MakeRelativePath(location)}, nil, nil); err != nil {
+			location = "."
+		}
+		staged.Path = path.Join(location, item.Name)
+		if _, err := m.fs.GetMD(ctx, &provider.Reference{ResourceId: root, Path: utils.MakeRelativePath(staged.Path)}, nil, nil); err == nil {
+			// do not overwrite an existing file with the same name
+			staged.Path = path.Join(location, fmt.Sprintf("%s (%d)", item.Name, item.Timestamp))
+		}
+		if err := m.journal.Put(stagedKey(user, item.ID), staged); err != nil {
+			return err
+		}
+	}
+
+	ref := &provider.Reference{ResourceId: root, Path: utils.MakeRelativePath(staged.Path)}
+	if !staged.Uploaded {
+		// the location was free when it was recorded, anything there now was uploaded by a previous run
+		if fi.IsDir() {
+			err = m.uploadTree(ctx, ref, item.Path)
+		} else {
+			err = m.uploadTrashFile(ctx, user, item, ref, int(fi.ModTime().Unix()))
+		}
+		if err != nil {
+			return err
+		}
+		staged.Uploaded = true
+		if err := m.journal.Put(stagedKey(user, item.ID), staged); err != nil {
+			return err
+		}
+	}
+
+	err = m.fs.Delete(ctx, ref)
+	var notFound errtypes.IsNotFound
+	if errors.As(err, &notFound) {
+		// already deleted by a previous run
+		return nil
+	}
+	return err
+}
+
+func (m *Migrator) uploadTrashFile(ctx context.Context, user string, item TrashItem, ref *provider.Reference, mtime int) error {
+	versions, err := m.source.TrashVersions(user, item)
+	if err != nil {
+		return err
+	}
+	uploaded, err := m.uploadedVersions(ctx, ref)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if uploaded[uint64(v.Timestamp)] {
+			continue
+		}
+		if _, err := m.upload(ctx, ref, v.Path, v.Timestamp); err != nil {
+			return err
+		}
+	}
+	_, err = m.upload(ctx, ref, item.Path, mtime)
+	return err
+}
+
+// migrateShares adds the grants of user and group shares to the storage and loads all
+// shares and public links into the share managers
+func (m *Migrator) migrateShares(ctx context.Context) error {
+	shares, err := m.source.Shares(ctx)
+	if err != nil {
+		return err
+	}
+
+	var userShares []*collaboration.Share
+	var receivedShares []share.ReceivedShareWithUser
+	var links []*publicshare.WithPassword
+	for _, sh := range shares {
+		if m.journal.Has(shareKey(sh.ID)) {
+			m.report.Shares.Skipped++
+			continue
+		}
+		item := "share " + strconv.Itoa(sh.ID)
+		switch {
+		case sh.ShareType == shareTypeGroupOverride:
+			// per user states of group shares
+			continue
+		case sh.ShareType == shareTypeLink && m.publicShares == nil, sh.ShareType != shareTypeLink && m.shares == nil:
+			m.report.problem(sh.UIDOwner, item, "no share manager configured")
+			continue
+		}
+
+		rec := fileRecord{}
+		if !m.journal.Get(fileKey(sh.FileSource), &rec) {
+			m.report.problem(sh.UIDOwner, item, "the shared resource %d has not been migrated", sh.FileSource)
+			continue
+		}
+		s, rs, l, err := m.convertShare(ctx, sh, rec.ResourceID)
+		if err != nil {
+			m.report.problem(sh.UIDOwner, item, "could not migrate: %s", err)
+			continue
+		}
+		switch {
+		case l != nil:
+			links = append(links, l)
+		case rs != nil:
+			receivedShares = append(receivedShares, *rs)
+			fallthrough
+		default:
+			userShares = append(userShares, s)
+		}
+	}
+
+	if len(userShares) > 0 {
+		if err := m.loadShares(ctx, userShares, receivedShares); err != nil {
+			return err
+		}
+		for _, s := range userShares {
+			id, _ := strconv.Atoi(s.GetId().GetOpaqueId())
+			if err := m.journal.Put(shareKey(id), true); err != nil {
+				return err
+			}
+			m.report.Shares.Shares++
+		}
+	}
+	if len(links) > 0 {
+		if err := m.loadPublicShares(ctx, links); err != nil {
+			return err
+		}
+		for _, l := range links {
+			id, _ := strconv.Atoi(l.PublicShare.GetId().GetOpaqueId())
+			if err := m.journal.Put(shareKey(id), true); err != nil {
+				return err
+			}
+			m.report.Shares.PublicLinks++
+		}
+	}
+	return nil
+}
+
+// convertShare converts a row of the oc_share table. User and group shares get their grant
+// added to the storage.
+func (m *Migrator) convertShare(ctx context.Context, sh Share, id *provider.ResourceId) (*collaboration.Share, *share.ReceivedShareWithUser, *publicshare.WithPassword, error) {
+	owner, err := m.users.User(ctx, sh.UIDOwner)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	creator, err := m.users.User(ctx, sh.UIDInitiator)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ocsPerms, err := conversions.NewPermissions(sh.Permissions)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	perms := conversions.RoleFromOCSPermissions(ocsPerms, nil).CS3ResourcePermissions()
+	ts := &types.Timestamp{Seconds: uint64(sh.STime)}
+	expiration := parseExpiration(sh.Expiration)
+	shareID := strconv.Itoa(sh.ID)
+
+	if sh.ShareType == shareTypeLink {
+		return nil, nil, &publicshare.WithPassword{
+			// ownCloud 10 prefixes the bcrypt hash with the version of the hashing scheme
+			Password: strings.TrimPrefix(sh.ShareWith, "1|"),
+			PublicShare: link.PublicShare{
+				Id:                &link.PublicShareId{OpaqueId: shareID},
+				Token:             sh.Token,
+				ResourceId:        id,
+				Permissions:       &link.PublicSharePermissions{Permissions: perms},
+				Owner:             owner.GetId(),
+				Creator:           creator.GetId(),
+				Ctime:             ts,
+				Mtime:             ts,
+				PasswordProtected: sh.ShareWith != "",
+				Expiration:        expiration,
+				DisplayName:       sh.ShareName,
+			},
+		}, nil
+	}
+
+	grantee := &provider.Grantee{}
+	var recipient *userpb.UserId
+	if sh.ShareType == shareTypeGroup {
+		gid, err := m.users.GroupID(ctx, sh.ShareWith)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		grantee.Type = provider.GranteeType_GRANTEE_TYPE_GROUP
+		grantee.Id = &provider.Grantee_GroupId{GroupId: gid}
+	} else {
+		u, err := m.users.User(ctx, sh.ShareWith)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		recipient = u.GetId()
+		grantee.Type = provider.GranteeType_GRANTEE_TYPE_USER
+		grantee.Id = &provider.Grantee_UserId{UserId: recipient}
+	}
+
+	ownerCtx := ctxpkg.ContextSetUser(ctx, owner)
+	ref := &provider.Reference{ResourceId: id}
+	g := &provider.Grant{Grantee: grantee, Permissions: perms, Creator: creator.GetId(), Expiration: expiration}
+	var exists errtypes.IsAlreadyExists
+	if err := m.fs.AddGrant(ownerCtx, ref, g); errors.As(err, &exists) {
+		err = m.fs.UpdateGrant(ownerCtx, ref, g)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else if err != nil {
+		return nil, nil, nil, err
+	}
+
+	s := &collaboration.Share{
+		Id:          &collaboration.ShareId{OpaqueId: shareID},
+		ResourceId:  id,
+		Permissions: &collaboration.SharePermissions{Permissions: perms},
+		Grantee:     grantee,
+		Owner:       owner.GetId(),
+		Creator:     creator.GetId(),
+		Ctime:       ts,
+		Mtime:       ts,
+		Expiration:  expiration,
+	}
+	if recipient == nil {
+		return s, nil, nil, nil
+	}
+	return s, &share.ReceivedShareWithUser{
+		UserID: recipient,
+		ReceivedShare: &collaboration.ReceivedShare{
+			Share:      s,
+			State:      shareState(sh.Accepted),
+			MountPoint: &provider.Reference{Path: path.Base(sh.FileTarget)},
+		},
+	}, nil, nil
+}
+
+func (m *Migrator) loadShares(ctx context.Context, shares []*collaboration.Share, received []share.ReceivedShareWithUser) error {
+	shareChan := make(chan *collaboration.Share)
+	receivedChan := make(chan share.ReceivedShareWithUser)
+	go func() {
+		for _, s := range shares {
+			shareChan <- s
+		}
+		close(shareChan)
+	}()
+	go func() {
+		for _, rs := range received {
+			receivedChan <- rs
+		}
+		close(receivedChan)
+	}()
+	return m.shares.Load(ctx, shareChan, receivedChan)
+}
+
+func (m *Migrator) loadPublicShares(ctx context.Context, links []*publicshare.WithPassword) error {
+	shareChan := make(chan *publicshare.WithPassword)
+	go func() {
+		for _, l := range links {
+			shareChan <- l
+		}
+		close(shareChan)
+	}()
+	return m.publicShares.Load(ctx, shareChan)
+}
+
+// Verify compares the migrated items of the given users, all users if none are given,
+// with the source and records mismatches in the report. Checksums are only compared
+// if requested as that requires reading all files.
+func (m *Migrator) Verify(ctx context.Context, users []string, checksums bool) error {
+	if len(users) == 0 {
+		var err error
+		if users, err = m.source.Users(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, user := range users {
+		if err := m.verifyUser(ctx, user, checksums); err != nil {
+			m.report.problem(user, "", "could not verify user: %s", err)
+		}
+	}
+	if err := m.verifyShares(ctx); err != nil {
+		return err
+	}
+	m.report.Finished = time.Now()
+	return nil
+}
+
+func (m *Migrator) verifyUser(ctx context.Context, user string, checksums bool) error {
+	u, err := m.users.User(ctx, user)
+	if err != nil {
+		return err
+	}
+	ctx = ctxpkg.ContextSetUser(ctx, u)
+	ur := m.report.user(user)
+	storageID, err := m.source.Storage(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	mismatch := func(item, format string, args ...interface{}) {
+		ur.Mismatches++
+		m.report.problem(user, item, format, args...)
+	}
+	err = m.source.Walk(ctx, storageID, func(f *filecache.File) error {
+		rec := fileRecord{}
+		if !m.journal.Get(fileKey(f.ID), &rec) {
+			mismatch(f.Path, "not migrated")
+			return nil
+		}
+		ref := &provider.Reference{ResourceId: rec.ResourceID}
+		info, err := m.fs.GetMD(ctx, ref, nil, nil)
+		if err != nil {
+			mismatch(f.Path, "could not stat the migrated resource: %s", err)
+			return nil
+		}
+		if info.GetId().GetOpaqueId() != strconv.Itoa(f.ID) {
+			// expected, see the limitations of the report
+			ur.ChangedIDs++
+		}
+		if f.MimeTypeString == mimetypeDirectory {
+			if info.GetType() != provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+				mismatch(f.Path, "expected a directory")
+				return nil
+			}
+			ur.Verified++
+			return nil
+		}
+
+		localPath := m.source.Path(user, f.Path)
+		fi, err := os.Stat(localPath)
+		if err != nil {
+			mismatch(f.Path, "could not stat the source: %s", err)
+			return nil
+		}
+		if info.GetType() != provider.ResourceType_RESOURCE_TYPE_FILE || info.GetSize() != uint64(fi.Size()) {
+			mismatch(f.Path, "expected a file of size %d, got %d", fi.Size(), info.GetSize())
+			return nil
+		}
+		if checksums && info.GetChecksum().GetType() == provider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_SHA1 {
+			sum, err := sha1sum(localPath)
+			if err != nil {
+				mismatch(f.Path, "could not read the source: %s", err)
+				return nil
+			}
+			if sum != info.GetChecksum().GetSum() {
+				mismatch(f.Path, "expected sha1 checksum %s, got %s", sum, info.GetChecksum().GetSum())
+				return nil
+			}
+		}
+		versions, err := m.source.Versions(user, rec.Path)
+		if err != nil {
+			mismatch(f.Path, "could not list the source versions: %s", err)
+			return nil
+		}
+		if len(versions) > 0 {
+			revisions, err := m.fs.ListRevisions(ctx, ref)
+			if err != nil || len(revisions) < len(versions) {
+				mismatch(f.Path, "expected %d revisions, got %d", len(versions), len(revisions))
+				return nil
+			}
+		}
+		ur.Verified++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	items, err := m.source.TrashItems(ctx, user)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if !m.journal.Has(trashKey(user, item.ID)) {
+			mismatch(item.Path, "trash item not migrated")
+		}
+	}
+	return nil
+}
+
+// verifyShares checks that all shares have been migrated and that the grants of
+// user and group shares exist on the storage
+func (m *Migrator) verifyShares(ctx context.Context) error {
+	shares, err := m.source.Shares(ctx)
+	if err != nil {
+		return err
+	}
+	mismatch := func(sh Share, format string, args ...interface{}) {
+		m.report.Shares.Mismatches++
+		m.report.problem(sh.UIDOwner, "share "+strconv.Itoa(sh.ID), format, args...)
+	}
+	for _, sh := range shares {
+		if sh.ShareType == shareTypeGroupOverride {
+			continue
+		}
+		if !m.journal.Has(shareKey(sh.ID)) {
+			mismatch(sh, "not migrated")
+			continue
+		}
+		if sh.ShareType == shareTypeLink {
+			m.report.Shares.Verified++
+			continue
+		}
+
+		rec := fileRecord{}
+		m.journal.Get(fileKey(sh.FileSource), &rec)
+		owner, err := m.users.User(ctx, sh.UIDOwner)
+		if err != nil {
+			mismatch(sh, "could not resolve the owner: %s", err)
+			continue
+		}
+		grants, err := m.fs.ListGrants(ctxpkg.ContextSetUser(ctx, owner), &provider.Reference{ResourceId: rec.ResourceID})
+		if err != nil {
+			mismatch(sh, "could not list the grants: %s", err)
+			continue
+		}
+		granteeID, err := m.granteeID(ctx, sh)
+		if err != nil {
+			mismatch(sh, "could not resolve the grantee: %s", err)
+			continue
+		}
+		if !hasGrantee(grants, granteeID) {
+			mismatch(sh, "grant missing on the storage")
+			continue
+		}
+		m.report.Shares.Verified++
+	}
+	return nil
+}
+
+func (m *Migrator) granteeID(ctx context.Context, sh Share) (string, error) {
+	if sh.ShareType == shareTypeGroup {
+		gid, err := m.users.GroupID(ctx, sh.ShareWith)
+		return gid.GetOpaqueId(), err
+	}
+	u, err := m.users.User(ctx, sh.ShareWith)
+	return u.GetId().GetOpaqueId(), err
+}
+
+func hasGrantee(grants []*provider.Grant, id string) bool {
+	for _, g := range grants {
+		if g.GetGrantee().GetUserId().GetOpaqueId() == id || g.GetGrantee().GetGroupId().GetOpaqueId() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sha1sum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func shareState(accepted int) collaboration.ShareState {
+	switch accepted {
+	case 0:
+		return collaboration.ShareState_SHARE_STATE_ACCEPTED
+	case 2:
+		return collaboration.ShareState_SHARE_STATE_REJECTED
+	default:
+		return collaboration.ShareState_SHARE_STATE_PENDING
+	}
+}
+
+// parseExpiration parses the expiration column, the format depends on the database driver
+func parseExpiration(expiration string) *types.Timestamp {
+	if expiration == "" {
+		return nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04:05-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, expiration); err == nil {
+			return &types.Timestamp{Seconds: uint64(t.Unix())}
+		}
+	}
+	return nil
+}
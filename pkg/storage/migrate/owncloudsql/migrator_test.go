@@ -0,0 +1,472 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/publicshare"
+	"github.com/opencloud-eu/reva/v2/pkg/share"
+	"github.com/opencloud-eu/reva/v2/pkg/storage"
+)
+
+type fakeNode struct {
+	id        string
+	dir       bool
+	size      uint64
+	mtime     uint64
+	revisions []uint64
+	grants    []*provider.Grant
+}
+
+// fakeFS keeps a single space in memory, nodes are addressed by their path
+type fakeFS struct {
+	storage.FS
+
+	spaces  []*provider.StorageSpace
+	nodes   map[string]*fakeNode
+	paths   map[string]string
+	uploads map[string]string
+	trash   []string
+	written int
+}
+
+func newFakeFS() *fakeFS {
+	return &fakeFS{nodes: map[string]*fakeNode{}, paths: map[string]string{}, uploads: map[string]string{}}
+}
+
+func (fs *fakeFS) resolve(ref *provider.Reference) string {
+	return path.Join(fs.paths[ref.GetResourceId().GetOpaqueId()], ref.GetPath())
+}
+
+func (fs *fakeFS) add(p string, n *fakeNode) {
+	n.id = "node-" + strconv.Itoa(len(fs.paths))
+	fs.nodes[p] = n
+	fs.paths[n.id] = p
+}
+
+func (fs *fakeFS) ListStorageSpaces(_ context.Context, _ []*provider.ListStorageSpacesRequest_Filter, _ bool) ([]*provider.StorageSpace, error) {
+	return fs.spaces, nil
+}
+
+func (fs *fakeFS) CreateStorageSpace(_ context.Context, req *provider.CreateStorageSpaceRequest) (*provider.CreateStorageSpaceResponse, error) {
+	fs.add("/", &fakeNode{dir: true})
+	space := &provider.StorageSpace{Root: &provider.ResourceId{SpaceId: "space", OpaqueId: fs.nodes["/"].id}}
+	fs.spaces = append(fs.spaces, space)
+	return &provider.CreateStorageSpaceResponse{Status: &rpc.Status{Code: rpc.Code_CODE_OK}, StorageSpace: space}, nil
+}
+
+func (fs *fakeFS) CreateDir(_ context.Context, ref *provider.Reference) error {
+	p := fs.resolve(ref)
+	if _, ok := fs.nodes[p]; ok {
+		return errtypes.AlreadyExists(p)
+	}
+	fs.add(p, &fakeNode{dir: true})
+	return nil
+}
+
+func (fs *fakeFS) GetMD(_ context.Context, ref *provider.Reference, _, _ []string) (*provider.ResourceInfo, error) {
+	p := fs.resolve(ref)
+	n, ok := fs.nodes[p]
+	if !ok {
+		return nil, errtypes.NotFound(p)
+	}
+	info := &provider.ResourceInfo{
+		Id:    &provider.ResourceId{SpaceId: "space", OpaqueId: n.id},
+		Type:  provider.ResourceType_RESOURCE_TYPE_FILE,
+		Size:  n.size,
+		Mtime: &types.Timestamp{Seconds: n.mtime},
+	}
+	if n.dir {
+		info.Type = provider.ResourceType_RESOURCE_TYPE_CONTAINER
+	}
+	return info, nil
+}
+
+func (fs *fakeFS) InitiateUpload(_ context.Context, ref *provider.Reference, length int64, md map[string]string) (map[string]string, error) {
+	p := fs.resolve(ref)
+	mtime, _ := strconv.Atoi(md["mtime"])
+	n, ok := fs.nodes[p]
+	if !ok {
+		n = &fakeNode{}
+		fs.add(p, n)
+	} else {
+		n.revisions = append(n.revisions, n.mtime)
+	}
+	n.size, n.mtime = uint64(length), uint64(mtime)
+	id := "upload-" + strconv.Itoa(len(fs.uploads))
+	fs.uploads[id] = p
+	return map[string]string{"simple": id}, nil
+}
+
+func (fs *fakeFS) Upload(_ context.Context, req storage.UploadRequest, _ storage.UploadFinishedFunc) (*provider.ResourceInfo, error) {
+	if _, ok := fs.uploads[req.Ref.GetPath()]; !ok {
+		return nil, errtypes.NotFound(req.Ref.GetPath())
+	}
+	b, err := io.ReadAll(req.Body)
+	fs.written += len(b)
+	return nil, err
+}
+
+func (fs *fakeFS) Delete(_ context.Context, ref *provider.Reference) error {
+	p := fs.resolve(ref)
+	if _, ok := fs.nodes[p]; !ok {
+		return errtypes.NotFound(p)
+	}
+	for np := range fs.nodes {
+		if np == p || len(np) > len(p) && np[:len(p)+1] == p+"/" {
+			delete(fs.nodes, np)
+		}
+	}
+	fs.trash = append(fs.trash, p)
+	return nil
+}
+
+func (fs *fakeFS) ListRevisions(_ context.Context, ref *provider.Reference) ([]*provider.FileVersion, error) {
+	n := fs.nodes[fs.resolve(ref)]
+	revisions := []*provider.FileVersion{}
+	for _, mtime := range n.revisions {
+		revisions = append(revisions, &provider.FileVersion{Mtime: mtime})
+	}
+	return revisions, nil
+}
+
+func (fs *fakeFS) AddGrant(_ context.Context, ref *provider.Reference, g *provider.Grant) error {
+	n := fs.nodes[fs.resolve(ref)]
+	for _, e := range n.grants {
+		if e.GetGrantee().String() == g.GetGrantee().String() {
+			return errtypes.AlreadyExists("grant")
+		}
+	}
+	n.grants = append(n.grants, g)
+	return nil
+}
+
+func (fs *fakeFS) UpdateGrant(_ context.Context, _ *provider.Reference, _ *provider.Grant) error {
+	return nil
+}
+
+func (fs *fakeFS) ListGrants(_ context.Context, ref *provider.Reference) ([]*provider.Grant, error) {
+	return fs.nodes[fs.resolve(ref)].grants, nil
+}
+
+type fakeShareManager struct {
+	shares   []*collaboration.Share
+	received []share.ReceivedShareWithUser
+}
+
+func (m *fakeShareManager) Load(_ context.Context, shareChan <-chan *collaboration.Share, receivedChan <-chan share.ReceivedShareWithUser) error {
+	for s := range shareChan {
+		m.shares = append(m.shares, s)
+	}
+	for rs := range receivedChan {
+		m.received = append(m.received, rs)
+	}
+	return nil
+}
+
+type fakePublicShareManager struct {
+	links []*publicshare.WithPassword
+}
+
+func (m *fakePublicShareManager) Load(_ context.Context, shareChan <-chan *publicshare.WithPassword) error {
+	for l := range shareChan {
+		m.links = append(m.links, l)
+	}
+	return nil
+}
+
+type fakeResolver struct{}
+
+func (fakeResolver) User(_ context.Context, username string) (*userpb.User, error) {
+	if username == "unknown" {
+		return nil, errors.New("unknown user")
+	}
+	return &userpb.User{Id: &userpb.UserId{OpaqueId: username + "-id"}, Username: username}, nil
+}
+
+func (fakeResolver) GroupID(_ context.Context, name string) (*grouppb.GroupId, error) {
+	return &grouppb.GroupId{OpaqueId: name + "-id"}, nil
+}
+
+func setup(t *testing.T) (*Source, string) {
+	t.Helper()
+	tmp := t.TempDir()
+
+	b, err := os.ReadFile("../../fs/owncloudsql/filecache/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbFile := filepath.Join(tmp, "test.db")
+	if err := os.WriteFile(dbFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range []string{
+		"DELETE FROM oc_share",
+		"INSERT INTO oc_share (id, share_type, share_with, uid_owner, uid_initiator, item_type, file_source, file_target, permissions, stime, accepted) VALUES (2, 0, 'marie', 'admin', 'admin', 'folder', 7, '/Documents', 31, 1620197311, 0)",
+		"INSERT INTO oc_share (id, share_type, share_with, uid_owner, uid_initiator, item_type, file_source, file_target, permissions, stime, accepted) VALUES (3, 1, 'physics', 'admin', 'admin', 'folder', 9, '/Photos', 1, 1620197311, 0)",
+		"INSERT INTO oc_share (id, share_type, share_with, uid_owner, uid_initiator, item_type, file_source, permissions, stime, token, share_name) VALUES (4, 3, '1|$2y$10$abcdefghijklmnopqrstuv', 'admin', 'admin', 'file', 10, 1, 1620197311, 'tokentoken', 'my link')",
+		"INSERT INTO oc_share (id, share_type, share_with, uid_owner, uid_initiator, item_type, file_source, permissions, stime) VALUES (5, 0, 'marie', 'admin', 'admin', 'file', 13, 1, 1620197311)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDir := filepath.Join(tmp, "data")
+	files := map[string]string{
+		"admin/files/Documents/Example.odt":                                "current",
+		"admin/files/Photos/Portugal.jpg":                                  "portugal",
+		"admin/files/Photos/Teotihuacan.jpg":                               "teotihuacan",
+		"admin/files/Photos/Lake-Constance.jpg":                            "lake",
+		"admin/files_versions/Documents/Example.odt.v1619000000":           "v1",
+		"admin/files_versions/Documents/Example.odt.v1618000000":           "v0",
+		"admin/files_versions/Documents/Other.odt.v1618000000":             "other",
+		"admin/files_trashbin/files/ownCloud Manual.pdf.d1619007109":       "manual",
+		"admin/files_trashbin/versions/ownCloud Manual.pdf.v1.d1619007109": "manual v1",
+	}
+	for p, content := range files {
+		p = filepath.Join(dataDir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source, err := NewSource("sqlite3", db, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source, tmp
+}
+
+func TestMigrate(t *testing.T) {
+	source, tmp := setup(t)
+	journal, err := OpenJournal(filepath.Join(tmp, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newFakeFS()
+	shares := &fakeShareManager{}
+	links := &fakePublicShareManager{}
+	log := zerolog.Nop()
+	ctx := context.Background()
+
+	m := New(source, fs, shares, links, fakeResolver{}, journal, &log)
+	if err := m.Migrate(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ur := m.Report().Users["admin"]
+	if ur == nil || ur.Directories != 2 || ur.Files != 4 || ur.Versions != 2 || ur.TrashItems != 1 {
+		t.Fatalf("unexpected report %+v", ur)
+	}
+	example := fs.nodes["/Documents/Example.odt"]
+	if example == nil || len(example.revisions) != 2 || example.mtime != 1619007009 || example.size != uint64(len("current")) {
+		t.Errorf("unexpected node %+v", example)
+	}
+	if len(fs.trash) != 1 || fs.trash[0] != "/ownCloud Manual.pdf" {
+		t.Errorf("expected the trash item to be deleted, got %v", fs.trash)
+	}
+	if _, ok := fs.nodes["/ownCloud Manual.pdf"]; ok {
+		t.Error("the trash item was not deleted")
+	}
+
+	if len(shares.shares) != 2 || len(shares.received) != 1 {
+		t.Fatalf("expected 2 shares and 1 received share, got %+v, %+v", shares.shares, shares.received)
+	}
+	if rs := shares.received[0]; rs.UserID.GetOpaqueId() != "marie-id" || rs.ReceivedShare.GetMountPoint().GetPath() != "Documents" ||
+		rs.ReceivedShare.GetState() != collaboration.ShareState_SHARE_STATE_ACCEPTED {
+		t.Errorf("unexpected received share %+v", rs)
+	}
+	if g := fs.nodes["/Photos"].grants; len(g) != 1 || g[0].GetGrantee().GetGroupId().GetOpaqueId() != "physics-id" || g[0].GetPermissions().InitiateFileUpload {
+		t.Errorf("unexpected grants %+v", g)
+	}
+	if len(links.links) != 1 || links.links[0].Password != "$2y$10$abcdefghijklmnopqrstuv" || links.links[0].PublicShare.GetToken() != "tokentoken" ||
+		!links.links[0].PublicShare.GetPasswordProtected() || links.links[0].PublicShare.GetResourceId().GetOpaqueId() != fs.nodes["/Photos/Portugal.jpg"].id {
+		t.Errorf("unexpected links %+v", links.links)
+	}
+	// the share of the trash item can not be migrated
+	if len(m.Report().Problems) != 1 {
+		t.Errorf("expected one problem, got %+v", m.Report().Problems)
+	}
+
+	if err := m.Verify(ctx, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if ur.Verified != 6 || ur.Mismatches != 0 || ur.ChangedIDs != 6 || m.Report().Shares.Verified != 3 || m.Report().Shares.Mismatches != 1 {
+		t.Errorf("unexpected verification %+v %+v %+v", ur, m.Report().Shares, m.Report().Problems)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// running the migration again only skips the migrated items
+	written := fs.written
+	journal, err = OpenJournal(filepath.Join(tmp, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	m = New(source, fs, shares, links, fakeResolver{}, journal, &log)
+	if err := m.Migrate(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fs.written != written || len(fs.trash) != 1 || len(shares.shares) != 2 || len(links.links) != 1 {
+		t.Errorf("expected nothing to be migrated again")
+	}
+	if ur := m.Report().Users["admin"]; ur.Skipped != 7 || ur.Files != 0 {
+		t.Errorf("unexpected report %+v", ur)
+	}
+}
+
+func TestMigrateUnrecordedItems(t *testing.T) {
+	source, tmp := setup(t)
+	journal, err := OpenJournal(filepath.Join(tmp, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newFakeFS()
+	log := zerolog.Nop()
+	ctx := context.Background()
+
+	m := New(source, fs, nil, nil, fakeResolver{}, journal, &log)
+	if err := m.Migrate(ctx, []string{"admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a run that was interrupted after uploading everything, but before recording it.
+	// The trash item was restored, only its location was recorded.
+	journal, err = OpenJournal(filepath.Join(tmp, "journal2.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	items, err := source.TrashItems(ctx, "admin")
+	if err != nil || len(items) != 1 {
+		t.Fatalf("expected one trash item, got %v %v", items, err)
+	}
+	if err := journal.Put(stagedKey("admin", items[0].ID), stagedItem{Path: "ownCloud Manual.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+	m = New(source, fs, nil, nil, fakeResolver{}, journal, &log)
+	fi, err := os.Stat(items[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := &provider.Reference{ResourceId: fs.spaces[0].GetRoot(), Path: "./ownCloud Manual.pdf"}
+	if err := m.uploadTrashFile(ctx, "admin", items[0], ref, int(fi.ModTime().Unix())); err != nil {
+		t.Fatal(err)
+	}
+
+	written := fs.written
+	if err := m.Migrate(ctx, []string{"admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if fs.written != written {
+		t.Errorf("expected nothing to be uploaded again, %d bytes were written", fs.written-written)
+	}
+	if example := fs.nodes["/Documents/Example.odt"]; len(example.revisions) != 2 {
+		t.Errorf("expected the versions to not be uploaded again, got %v", example.revisions)
+	}
+	if len(fs.trash) != 2 || fs.trash[1] != "/ownCloud Manual.pdf" {
+		t.Errorf("expected the trash item to be deleted at the recorded location, got %v", fs.trash)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := OpenJournal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Put("file:1", fileRecord{Path: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Put("file:1", fileRecord{Path: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate an interrupted write
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"k":"file:2","v":{"pa`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j, err = OpenJournal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	rec := fileRecord{}
+	if !j.Get("file:1", &rec) || rec.Path != "b" {
+		t.Errorf("expected the latest entry, got %+v", rec)
+	}
+	if j.Has("file:2") || len(j.Keys("file:")) != 1 {
+		t.Error("expected the incomplete entry to be ignored")
+	}
+
+	// the next entry must not be appended to the incomplete one
+	if err := j.Put("file:3", fileRecord{Path: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	j2, err := OpenJournal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j2.Close()
+	if !j2.Get("file:3", &rec) || rec.Path != "c" {
+		t.Errorf("expected the entry after the incomplete one, got %+v", rec)
+	}
+}
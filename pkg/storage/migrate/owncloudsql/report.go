@@ -0,0 +1,109 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Report summarizes a migration and the result of its verification
+type Report struct {
+	Started  time.Time              `json:"started"`
+	Finished time.Time              `json:"finished"`
+	Users    map[string]*UserReport `json:"users"`
+	Shares   ShareReport            `json:"shares"`
+	Problems []Problem              `json:"problems"`
+	// Limitations lists what the migration does not carry over, see Migrator
+	Limitations []string `json:"limitations"`
+
+	mu sync.Mutex
+}
+
+// UserReport holds the counts of the migrated and verified items of a user
+type UserReport struct {
+	Directories int `json:"directories"`
+	Files       int `json:"files"`
+	Versions    int `json:"versions"`
+	TrashItems  int `json:"trash_items"`
+	// Skipped counts the items that had already been migrated by a previous run
+	Skipped    int `json:"skipped"`
+	Verified   int `json:"verified"`
+	Mismatches int `json:"mismatches"`
+	// ChangedIDs counts the verified items whose id differs from the ownCloud 10 file id
+	ChangedIDs int `json:"changed_ids"`
+}
+
+// ShareReport holds the counts of the migrated shares
+type ShareReport struct {
+	Shares      int `json:"shares"`
+	PublicLinks int `json:"public_links"`
+	Skipped     int `json:"skipped"`
+	Verified    int `json:"verified"`
+	Mismatches  int `json:"mismatches"`
+}
+
+// Problem is an item that could not be migrated or did not pass the verification
+type Problem struct {
+	User    string `json:"user,omitempty"`
+	Item    string `json:"item"`
+	Message string `json:"message"`
+}
+
+func newReport() *Report {
+	return &Report{
+		Started:  time.Now(),
+		Users:    map[string]*UserReport{},
+		Problems: []Problem{},
+		Limitations: []string{
+			"file ids are not preserved, links and clients using ownCloud 10 file ids have to be mapped with the journal",
+			"trash items are deleted at migration time",
+			"per user states of group shares are not migrated",
+		},
+	}
+}
+
+func (r *Report) user(name string) *UserReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.Users[name]; !ok {
+		r.Users[name] = &UserReport{}
+	}
+	return r.Users[name]
+}
+
+func (r *Report) problem(user, item string, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Problems = append(r.Problems, Problem{User: user, Item: item, Message: fmt.Sprintf(format, args...)})
+}
+
+// WriteFile writes the report as json to the given path
+func (r *Report) WriteFile(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
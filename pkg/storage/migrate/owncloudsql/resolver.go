@@ -0,0 +1,113 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"context"
+	"fmt"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	"google.golang.org/grpc/metadata"
+
+	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/utils"
+)
+
+// UserResolver maps the user and group names of ownCloud 10 to CS3 users and groups
+type UserResolver interface {
+	User(ctx context.Context, username string) (*userpb.User, error)
+	GroupID(ctx context.Context, name string) (*grouppb.GroupId, error)
+}
+
+// GatewayResolver resolves users and groups using the gateway
+type GatewayResolver struct {
+	gatewayAddr          string
+	serviceAccountID     string
+	serviceAccountSecret string
+
+	users  map[string]*userpb.User
+	groups map[string]*grouppb.GroupId
+}
+
+// NewGatewayResolver returns a new GatewayResolver authenticating with the given service account
+func NewGatewayResolver(gatewayAddr, serviceAccountID, serviceAccountSecret string) *GatewayResolver {
+	return &GatewayResolver{
+		gatewayAddr:          gatewayAddr,
+		serviceAccountID:     serviceAccountID,
+		serviceAccountSecret: serviceAccountSecret,
+		users:                map[string]*userpb.User{},
+		groups:               map[string]*grouppb.GroupId{},
+	}
+}
+
+// User returns the user with the given username
+func (r *GatewayResolver) User(ctx context.Context, username string) (*userpb.User, error) {
+	if u, ok := r.users[username]; ok {
+		return u, nil
+	}
+	gwc, ctx, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := gwc.GetUserByClaim(ctx, &userpb.GetUserByClaimRequest{Claim: "username", Value: username})
+	switch {
+	case err != nil:
+		return nil, err
+	case res.GetStatus().GetCode() != rpc.Code_CODE_OK:
+		return nil, fmt.Errorf("could not get user %s: %s", username, res.GetStatus().GetMessage())
+	}
+	r.users[username] = res.GetUser()
+	return res.GetUser(), nil
+}
+
+// GroupID returns the id of the group with the given name
+func (r *GatewayResolver) GroupID(ctx context.Context, name string) (*grouppb.GroupId, error) {
+	if g, ok := r.groups[name]; ok {
+		return g, nil
+	}
+	gwc, ctx, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := gwc.GetGroupByClaim(ctx, &grouppb.GetGroupByClaimRequest{Claim: "group_name", Value: name, SkipFetchingMembers: true})
+	switch {
+	case err != nil:
+		return nil, err
+	case res.GetStatus().GetCode() != rpc.Code_CODE_OK:
+		return nil, fmt.Errorf("could not get group %s: %s", name, res.GetStatus().GetMessage())
+	}
+	r.groups[name] = res.GetGroup().GetId()
+	return res.GetGroup().GetId(), nil
+}
+
+func (r *GatewayResolver) client(ctx context.Context) (gateway.GatewayAPIClient, context.Context, error) {
+	gwc, err := pool.GetGatewayServiceClient(r.gatewayAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token, ok := ctxpkg.ContextGetToken(ctx); ok {
+		return gwc, metadata.AppendToOutgoingContext(ctx, ctxpkg.TokenHeader, token), nil
+	}
+	ctx, err = utils.GetServiceUserContextWithContext(ctx, gwc, r.serviceAccountID, r.serviceAccountSecret)
+	return gwc, ctx, err
+}
@@ -0,0 +1,247 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package owncloudsql
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencloud-eu/reva/v2/pkg/storage/fs/owncloudsql/filecache"
+)
+
+const (
+	mimetypeDirectory = "httpd/unix-directory"
+
+	shareTypeUser          = 0
+	shareTypeGroup         = 1
+	shareTypeGroupOverride = 2
+	shareTypeLink          = 3
+)
+
+// Source reads the data of an ownCloud 10 instance from its database and data directory
+type Source struct {
+	db            *sql.DB
+	filecache     *filecache.Cache
+	dataDirectory string
+}
+
+// Version is a version of a file kept in the files_versions folder
+type Version struct {
+	Timestamp int
+	Path      string
+}
+
+// TrashItem is an item in the trashbin of a user
+type TrashItem struct {
+	ID        int
+	Name      string
+	Location  string
+	Timestamp int
+	// Path is the location of the item on disk
+	Path string
+}
+
+// Share is a row of the oc_share table
+type Share struct {
+	ID           int
+	ShareType    int
+	ShareWith    string
+	UIDOwner     string
+	UIDInitiator string
+	Parent       int
+	ItemType     string
+	FileSource   int
+	FileTarget   string
+	Permissions  int
+	STime        int
+	Accepted     int
+	Expiration   string
+	Token        string
+	ShareName    string
+}
+
+// NewSource returns a new Source for the given database and data directory
+func NewSource(driver string, db *sql.DB, dataDirectory string) (*Source, error) {
+	fc, err := filecache.New(driver, db)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{
+		db:            db,
+		filecache:     fc,
+		dataDirectory: filepath.Clean(dataDirectory),
+	}, nil
+}
+
+// Users returns the names of all users with a home storage
+func (s *Source) Users(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM oc_storages WHERE id LIKE 'home::%'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, strings.TrimPrefix(id, "home::"))
+	}
+	sort.Strings(users)
+	return users, rows.Err()
+}
+
+// Storage returns the numeric id of the home storage of the given user
+func (s *Source) Storage(ctx context.Context, user string) (int, error) {
+	return s.filecache.GetNumericStorageID(ctx, "home::"+user)
+}
+
+// Walk calls fn for every entry below the files folder of the given storage. Parents are
+// visited before their children.
+func (s *Source) Walk(ctx context.Context, storage int, fn func(*filecache.File) error) error {
+	queue := []string{"files"}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		entries, err := s.filecache.List(ctx, storage, p)
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+			if e.MimeTypeString == mimetypeDirectory {
+				queue = append(queue, e.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// File returns the filecache entry at the given path
+func (s *Source) File(ctx context.Context, storage int, path string) (*filecache.File, error) {
+	return s.filecache.Get(ctx, storage, path)
+}
+
+// Path returns the location of a file of the given user on disk
+func (s *Source) Path(user, path string) string {
+	return filepath.Join(s.dataDirectory, user, path)
+}
+
+// Versions returns the versions of the file at the given path relative to the files folder
+// of the user, oldest first
+func (s *Source) Versions(user, path string) ([]Version, error) {
+	return readVersions(filepath.Join(s.dataDirectory, user, "files_versions", filepath.Dir(path)), filepath.Base(path), "")
+}
+
+// TrashItems returns the items in the trashbin of the given user
+func (s *Source) TrashItems(ctx context.Context, user string) ([]TrashItem, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT auto_id, id, location, timestamp FROM oc_files_trash WHERE user = ? ORDER BY auto_id", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []TrashItem{}
+	for rows.Next() {
+		var item TrashItem
+		var timestamp string
+		if err := rows.Scan(&item.ID, &item.Name, &item.Location, &timestamp); err != nil {
+			return nil, err
+		}
+		if item.Timestamp, err = strconv.Atoi(timestamp); err != nil {
+			return nil, err
+		}
+		item.Path = filepath.Join(s.dataDirectory, user, "files_trashbin", "files", item.Name+".d"+timestamp)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// TrashVersions returns the versions of a deleted file, oldest first
+func (s *Source) TrashVersions(user string, item TrashItem) ([]Version, error) {
+	return readVersions(filepath.Join(s.dataDirectory, user, "files_trashbin", "versions"), item.Name, ".d"+strconv.Itoa(item.Timestamp))
+}
+
+// Shares returns all user, group and link shares, ordered by id
+func (s *Source) Shares(ctx context.Context) ([]Share, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			id, share_type, coalesce(share_with, ''), uid_owner, coalesce(uid_initiator, ''), coalesce(parent, 0),
+			item_type, coalesce(file_source, 0), coalesce(file_target, ''), permissions, stime, accepted,
+			coalesce(expiration, ''), coalesce(token, ''), coalesce(share_name, '')
+		FROM oc_share
+		WHERE share_type IN (?, ?, ?, ?)
+		ORDER BY id`, shareTypeUser, shareTypeGroup, shareTypeGroupOverride, shareTypeLink)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		var sh Share
+		if err := rows.Scan(&sh.ID, &sh.ShareType, &sh.ShareWith, &sh.UIDOwner, &sh.UIDInitiator, &sh.Parent,
+			&sh.ItemType, &sh.FileSource, &sh.FileTarget, &sh.Permissions, &sh.STime, &sh.Accepted,
+			&sh.Expiration, &sh.Token, &sh.ShareName); err != nil {
+			return nil, err
+		}
+		if sh.UIDInitiator == "" {
+			sh.UIDInitiator = sh.UIDOwner
+		}
+		shares = append(shares, sh)
+	}
+	return shares, rows.Err()
+}
+
+// readVersions lists the `<name>.v<timestamp><suffix>` files in dir
+func readVersions(dir, name, suffix string) ([]Version, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	versions := []Version{}
+	prefix := name + ".v"
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() || !strings.HasPrefix(n, prefix) || !strings.HasSuffix(n, suffix) {
+			continue
+		}
+		ts, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(n, prefix), suffix))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, Version{Timestamp: ts, Path: filepath.Join(dir, n)})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Timestamp < versions[j].Timestamp })
+	return versions, nil
+}
@@ -428,6 +428,15 @@ func (fs *Decomposedfs) Postprocessing(ch <-chan events.Event) {
 			}
 		case events.PostprocessingStepFinished:
 			sublog := log.With().Str("event", "PostprocessingStepFinished").Str("uploadid", ev.UploadID).Logger()
+			if ev.UploadID != "" {
+				// remember the last finished step so admins can see where a session is stuck
+				if session, err := fs.sessionStore.Get(ctx, ev.UploadID); err == nil {
+					session.SetPostprocessingStep(ev.FinishedStep)
+					if err := session.Persist(ctx); err != nil {
+						sublog.Error().Err(err).Msg("Failed to persist postprocessing step")
+					}
+				}
+			}
 			if ev.FinishedStep != events.PPStepAntivirus {
 				// atm we are only interested in antivirus results
 				continue
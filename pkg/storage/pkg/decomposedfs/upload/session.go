@@ -34,6 +34,7 @@ import (
 	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/node"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
 )
@@ -328,6 +329,16 @@ func (s *DecomposedFsSession) SetScanData(result string, date time.Time) {
 	s.info.MetaData["scanDate"] = date.Format(time.RFC3339)
 }
 
+// SetPostprocessingStep remembers the last finished postprocessing step
+func (s *DecomposedFsSession) SetPostprocessingStep(step events.Postprocessingstep) {
+	s.info.MetaData["postprocessingStep"] = string(step)
+}
+
+// PostprocessingStep returns the last finished postprocessing step
+func (s *DecomposedFsSession) PostprocessingStep() string {
+	return s.info.MetaData["postprocessingStep"]
+}
+
 // ScanData returns the virus scan data
 func (s *DecomposedFsSession) ScanData() (string, time.Time) {
 	date := s.info.MetaData["scanDate"]
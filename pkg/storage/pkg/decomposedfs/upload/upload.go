@@ -44,6 +44,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/events"
 	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/metrics"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/utils/checksum"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/metadata/prefixes"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/node"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
@@ -71,12 +72,11 @@ func (session *DecomposedFsSession) WriteChunk(ctx context.Context, offset int64
 	}
 	defer file.Close()
 
-	// calculate cheksum here? needed for the TUS checksum extension. https://tus.io/protocols/resumable-upload.html#checksum
-	// TODO but how do we get the `Upload-Checksum`? WriteChunk() only has a context, offset and the reader ...
-	// It is sent with the PATCH request, well or in the POST when the creation-with-upload extension is used
-	// but the tus handler uses a context.Background() so we cannot really check the header and put it in the context ...
+	// the tus checksum extension sends the expected checksum of the chunk in the Upload-Checksum header of the PATCH request.
+	// The tus datatx manager puts it into the request context, see https://tus.io/protocols/resumable-upload.html#checksum
+	verifier := checksum.NewVerifier(ctx)
 	_, subspan = tracer.Start(ctx, "io.Copy")
-	n, err := io.Copy(file, src)
+	n, err := io.Copy(file, verifier.Reader(src))
 	subspan.End()
 
 	// If the HTTP PATCH request gets interrupted in the middle (e.g. because
@@ -87,6 +87,21 @@ func (session *DecomposedFsSession) WriteChunk(ctx context.Context, offset int64
 		return n, err
 	}
 
+	if verifier != nil {
+		// an incomplete chunk cannot be verified, so it is rejected as well
+		verr := verifier.Verify()
+		if verr == nil && err == io.ErrUnexpectedEOF {
+			verr = checksum.ErrChecksumMismatch
+		}
+		if verr != nil {
+			// roll back the offset so the client can resend the chunk
+			if terr := file.Truncate(offset); terr != nil {
+				return n, errors.Wrap(terr, "failed to roll back chunk with mismatching checksum")
+			}
+			return 0, verr
+		}
+	}
+
 	// update upload.Session.Offset so subsequent code flow can use it.
 	// No need to persist the session as the offset is determined by stating the blob in the GetUpload / ReadSession codepath.
 	// The session offset is written to disk in FinishUpload
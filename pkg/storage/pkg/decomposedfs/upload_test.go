@@ -31,6 +31,7 @@ import (
 	ruser "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/opencloud-eu/reva/v2/pkg/rgrpc/todo/pool"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/utils/checksum"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/pkg/decomposedfs/aspects"
@@ -49,6 +50,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/tests/helpers"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/mock"
+	tusd "github.com/tus/tusd/v2/pkg/handler"
 	"google.golang.org/grpc"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -320,6 +322,38 @@ var _ = Describe("File uploads", func() {
 			})
 		})
 
+		When("the user sends a chunk with a mismatching checksum", func() {
+			It("rejects the chunk and rolls back the offset", func() {
+				uploadIds, err := fs.InitiateUpload(ctx, ref, 10, map[string]string{})
+				Expect(err).ToNot(HaveOccurred())
+
+				upload, err := fs.(tusd.DataStore).GetUpload(ctx, uploadIds["tus"])
+				Expect(err).ToNot(HaveOccurred())
+
+				// sha1 of "hello world"
+				c, err := checksum.Parse("sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=")
+				Expect(err).ToNot(HaveOccurred())
+				chunkCtx := checksum.ContextSetChecksum(ctx, c)
+
+				n, err := upload.WriteChunk(chunkCtx, 0, bytes.NewReader([]byte("01234")))
+				Expect(err).To(MatchError(checksum.ErrChecksumMismatch))
+				Expect(n).To(Equal(int64(0)))
+
+				upload, err = fs.(tusd.DataStore).GetUpload(ctx, uploadIds["tus"])
+				Expect(err).ToNot(HaveOccurred())
+				info, err := upload.GetInfo(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Offset).To(Equal(int64(0)))
+
+				// sha1 of "01234"
+				c, err = checksum.Parse("sha1 EZBKTot39iQuLSiHBQI62tAKkxA=")
+				Expect(err).ToNot(HaveOccurred())
+				n, err = upload.WriteChunk(checksum.ContextSetChecksum(ctx, c), 0, bytes.NewReader([]byte("01234")))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(5)))
+			})
+		})
+
 		When("the user tries to upload a file without intialising the upload", func() {
 			It("fails", func() {
 				var (
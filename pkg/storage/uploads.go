@@ -25,9 +25,14 @@ import (
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
 	tusd "github.com/tus/tusd/v2/pkg/handler"
 )
 
+// OpaqueKeyUploadSessions is the opaque key of the json encoded UploadSessionFilter in the requests of the
+// upload sessions api, the responses carry the json encoded []UploadSessionInfo under the same key.
+const OpaqueKeyUploadSessions = "upload_sessions"
+
 // UploadFinishedFunc is a callback function used in storage drivers to indicate that an upload has finished
 type UploadFinishedFunc func(spaceOwner, executant *userpb.UserId, ref *provider.Reference)
 
@@ -81,10 +86,66 @@ type UploadSession interface {
 	ScanData() (string, time.Time)
 }
 
+// PostprocessingStepper is implemented by upload sessions that keep track of the last finished postprocessing step
+type PostprocessingStepper interface {
+	// PostprocessingStep returns the last finished postprocessing step
+	PostprocessingStep() string
+}
+
 // UploadSessionFilter can be used to filter upload sessions
 type UploadSessionFilter struct {
-	ID         *string
-	Processing *bool
-	Expired    *bool
-	HasVirus   *bool
+	ID         *string        `json:"id,omitempty"`
+	Processing *bool          `json:"processing,omitempty"`
+	Expired    *bool          `json:"expired,omitempty"`
+	HasVirus   *bool          `json:"has_virus,omitempty"`
+	SpaceID    *string        `json:"space_id,omitempty"`
+	Executant  *userpb.UserId `json:"executant,omitempty"`
+}
+
+// UploadSessionInfo is the serializable state of an upload session. It is used to pass
+// upload sessions from the storage providers to other services.
+type UploadSessionInfo struct {
+	ID                 string         `json:"id"`
+	Filename           string         `json:"filename"`
+	Size               int64          `json:"size"`
+	Offset             int64          `json:"offset"`
+	SpaceID            string         `json:"space_id"`
+	ResourceID         string         `json:"resource_id"`
+	Executant          *userpb.UserId `json:"executant"`
+	SpaceOwner         *userpb.UserId `json:"space_owner,omitempty"`
+	Expires            time.Time      `json:"expires"`
+	Expired            bool           `json:"expired"`
+	Processing         bool           `json:"processing"`
+	PostprocessingStep string         `json:"postprocessing_step,omitempty"`
+	ScanResult         string         `json:"scan_result,omitempty"`
+	ScanDate           *time.Time     `json:"scan_date,omitempty"`
+}
+
+// NewUploadSessionInfo returns the state of the given upload session at the given time
+func NewUploadSessionInfo(us UploadSession, now time.Time) UploadSessionInfo {
+	ref := us.Reference()
+	executant := us.Executant()
+	info := UploadSessionInfo{
+		ID:         us.ID(),
+		Filename:   us.Filename(),
+		Size:       us.Size(),
+		Offset:     us.Offset(),
+		SpaceID:    ref.GetResourceId().GetSpaceId(),
+		Executant:  &executant,
+		SpaceOwner: us.SpaceOwner(),
+		Expires:    us.Expires(),
+		Expired:    now.After(us.Expires()),
+		Processing: us.IsProcessing(),
+	}
+	if ref.GetResourceId() != nil {
+		info.ResourceID = storagespace.FormatResourceID(ref.GetResourceId())
+	}
+	if ps, ok := us.(PostprocessingStepper); ok {
+		info.PostprocessingStep = ps.PostprocessingStep()
+	}
+	if result, date := us.ScanData(); !date.IsZero() {
+		info.ScanResult = result
+		info.ScanDate = &date
+	}
+	return info
 }
@@ -0,0 +1,138 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package uploadsessions defines the grpc api storage providers offer to list and purge their upload sessions.
+//
+// The CS3 apis have no operations for upload sessions, so the api reuses the messages of the spaces api as
+// envelopes: the json encoded storage.UploadSessionFilter is sent and the json encoded []storage.UploadSessionInfo
+// is returned in the opaque under storage.OpaqueKeyUploadSessions. Storage providers that do not offer the api
+// answer with codes.Unimplemented.
+package uploadsessions
+
+import (
+	"context"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the full name of the grpc service
+const ServiceName = "revad.storage.uploadsessions.v1beta1.UploadSessionsAPI"
+
+const (
+	listMethod  = "/" + ServiceName + "/ListUploadSessions"
+	purgeMethod = "/" + ServiceName + "/PurgeUploadSessions"
+)
+
+// UploadSessionsAPIServer is the server api for listing and purging upload sessions
+type UploadSessionsAPIServer interface {
+	// ListUploadSessions lists the upload sessions matching the filter
+	ListUploadSessions(context.Context, *provider.ListStorageSpacesRequest) (*provider.ListStorageSpacesResponse, error)
+	// PurgeUploadSessions purges the upload sessions matching the filter and returns them
+	PurgeUploadSessions(context.Context, *provider.DeleteStorageSpaceRequest) (*provider.DeleteStorageSpaceResponse, error)
+}
+
+// UploadSessionsAPIClient is the client api for listing and purging upload sessions
+type UploadSessionsAPIClient interface {
+	// ListUploadSessions lists the upload sessions matching the filter
+	ListUploadSessions(ctx context.Context, in *provider.ListStorageSpacesRequest, opts ...grpc.CallOption) (*provider.ListStorageSpacesResponse, error)
+	// PurgeUploadSessions purges the upload sessions matching the filter and returns them
+	PurgeUploadSessions(ctx context.Context, in *provider.DeleteStorageSpaceRequest, opts ...grpc.CallOption) (*provider.DeleteStorageSpaceResponse, error)
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewUploadSessionsAPIClient returns a new client for the upload sessions api
+func NewUploadSessionsAPIClient(cc grpc.ClientConnInterface) UploadSessionsAPIClient {
+	return &client{cc: cc}
+}
+
+func (c *client) ListUploadSessions(ctx context.Context, in *provider.ListStorageSpacesRequest, opts ...grpc.CallOption) (*provider.ListStorageSpacesResponse, error) {
+	out := new(provider.ListStorageSpacesResponse)
+	if err := c.cc.Invoke(ctx, listMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) PurgeUploadSessions(ctx context.Context, in *provider.DeleteStorageSpaceRequest, opts ...grpc.CallOption) (*provider.DeleteStorageSpaceResponse, error) {
+	out := new(provider.DeleteStorageSpaceResponse)
+	if err := c.cc.Invoke(ctx, purgeMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterUploadSessionsAPIServer registers the upload sessions api with the grpc server
+func RegisterUploadSessionsAPIServer(s grpc.ServiceRegistrar, srv UploadSessionsAPIServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*UploadSessionsAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListUploadSessions",
+			Handler:    listHandler,
+		},
+		{
+			MethodName: "PurgeUploadSessions",
+			Handler:    purgeHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func listHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(provider.ListStorageSpacesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UploadSessionsAPIServer).ListUploadSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: listMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UploadSessionsAPIServer).ListUploadSessions(ctx, req.(*provider.ListStorageSpacesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func purgeHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(provider.DeleteStorageSpaceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UploadSessionsAPIServer).PurgeUploadSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: purgeMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UploadSessionsAPIServer).PurgeUploadSessions(ctx, req.(*provider.DeleteStorageSpaceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -223,6 +223,16 @@ func (e *ACE) Principal() string {
 	return e.principal
 }
 
+// Type returns the type of the ACE, `A` for allow or `D` for deny
+func (e *ACE) Type() string {
+	return e._type
+}
+
+// Permissions returns the NFSv4.x inspired permission string of the ACE, eg. `txrwad`
+func (e *ACE) Permissions() string {
+	return e.permissions
+}
+
 // Marshal renders a principal and byte[] that can be used to persist the ACE as an extended attribute
 func (e *ACE) Marshal() (string, []byte) {
 	// NOTE: first byte will be replaced after converting to byte array
@@ -409,6 +409,15 @@ func (fs *Decomposedfs) ListUploadSessions(ctx context.Context, filter storage.U
 				continue
 			}
 		}
+		if filter.SpaceID != nil && *filter.SpaceID != session.SpaceID() {
+			continue
+		}
+		if filter.Executant != nil {
+			executant := session.Executant()
+			if !utils.UserIDEqual(filter.Executant, &executant) {
+				continue
+			}
+		}
 		filteredSessions = append(filteredSessions, session)
 	}
 	return filteredSessions, nil
@@ -31,6 +31,7 @@ import (
 	"github.com/opencloud-eu/reva/v2/pkg/appctx"
 	ctxpkg "github.com/opencloud-eu/reva/v2/pkg/ctx"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/rhttp/datatx/utils/checksum"
 	"github.com/opencloud-eu/reva/v2/pkg/storage"
 	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/chunking"
 	"github.com/opencloud-eu/reva/v2/pkg/utils"
@@ -308,7 +309,8 @@ func (upload *fileUpload) WriteChunk(ctx context.Context, offset int64, src io.R
 	}
 	defer file.Close()
 
-	n, err := io.Copy(file, src)
+	verifier := checksum.NewVerifier(ctx)
+	n, err := io.Copy(file, verifier.Reader(src))
 
 	// If the HTTP PATCH request gets interrupted in the middle (e.g. because
 	// the user wants to pause the upload), Go's net/http returns an io.ErrUnexpectedEOF.
@@ -320,6 +322,19 @@ func (upload *fileUpload) WriteChunk(ctx context.Context, offset int64, src io.R
 		}
 	}
 
+	if verifier != nil {
+		verr := verifier.Verify()
+		if verr == nil && err == io.ErrUnexpectedEOF {
+			verr = checksum.ErrChecksumMismatch
+		}
+		if verr != nil {
+			if terr := file.Truncate(offset); terr != nil {
+				return n, terr
+			}
+			return 0, verr
+		}
+	}
+
 	upload.info.Offset += n
 	err = upload.writeInfo()
 
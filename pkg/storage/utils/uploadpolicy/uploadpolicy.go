@@ -0,0 +1,262 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package uploadpolicy checks uploads against size, file type and filename policies.
+package uploadpolicy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/opencloud-eu/reva/v2/pkg/bytesize"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/mime"
+)
+
+// OpaqueKey is the opaque key used to tell clients which kind of policy was violated
+const OpaqueKey = "policy-violation"
+
+// The kinds of policy violations
+const (
+	ViolationSize     = "size"
+	ViolationType     = "type"
+	ViolationFilename = "filename"
+)
+
+// Policy describes the restrictions for uploads. Empty fields mean no restriction.
+type Policy struct {
+	MaxFileSize         string   `mapstructure:"max_file_size" docs:";The maximum size of a single file, e.g. 10GB."`
+	AllowedExtensions   []string `mapstructure:"allowed_extensions" docs:";Only files with these extensions can be uploaded."`
+	BlockedExtensions   []string `mapstructure:"blocked_extensions" docs:";Files with these extensions can not be uploaded."`
+	AllowedMimeTypes    []string `mapstructure:"allowed_mime_types" docs:";Only files with these mime types can be uploaded. Supports wildcards like image/*."`
+	BlockedMimeTypes    []string `mapstructure:"blocked_mime_types" docs:";Files with these mime types can not be uploaded. Supports wildcards like video/*."`
+	ForbiddenCharacters string   `mapstructure:"forbidden_characters" docs:";Characters that must not be used in filenames."`
+	ReservedNames       []string `mapstructure:"reserved_names" docs:";Filenames that can not be used, compared case insensitively."`
+	MaxFilenameLength   int      `mapstructure:"max_filename_length" docs:"0;The maximum length of a filename in bytes."`
+}
+
+// Config holds the upload policies. More specific policies override the fields of less specific ones.
+type Config struct {
+	Default    Policy            `mapstructure:"default" docs:";The policy for all spaces."`
+	SpaceTypes map[string]Policy `mapstructure:"space_types" docs:";Policies per space type, e.g. personal or project."`
+	Spaces     map[string]Policy `mapstructure:"spaces" docs:";Policies per space id."`
+}
+
+// Empty returns true if no policy has been configured
+func (c *Config) Empty() bool {
+	return c == nil || (c.Default.Empty() && len(c.SpaceTypes) == 0 && len(c.Spaces) == 0)
+}
+
+// Validate returns an error if a policy can not be applied, it is meant to be called at startup
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default upload policy: %w", err)
+	}
+	for st, p := range c.SpaceTypes {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("upload policy of space type %s: %w", st, err)
+		}
+	}
+	for id, p := range c.Spaces {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("upload policy of space %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// NeedsSpaceType returns true if the space type is needed to determine the policy
+func (c *Config) NeedsSpaceType() bool {
+	return c != nil && len(c.SpaceTypes) > 0
+}
+
+// PolicyFor returns the effective policy for the given space
+func (c *Config) PolicyFor(spaceType, spaceID string) Policy {
+	if c == nil {
+		return Policy{}
+	}
+	p := c.Default
+	if st, ok := c.SpaceTypes[spaceType]; ok {
+		p = p.merge(st)
+	}
+	if s, ok := c.Spaces[spaceID]; ok {
+		p = p.merge(s)
+	}
+	return p
+}
+
+// Check returns an error if an upload of the given file and size violates the policy.
+// A negative size means the size is not known, which violates a maximum file size.
+func (p Policy) Check(filename string, size int64) error {
+	if err := p.CheckName(filename); err != nil {
+		return err
+	}
+	if p.MaxFileSize == "" {
+		return nil
+	}
+	max, err := bytesize.Parse(p.MaxFileSize)
+	if err != nil {
+		return errtypes.InternalError("invalid max_file_size in upload policy: " + p.MaxFileSize)
+	}
+	switch {
+	case size < 0:
+		return errtypes.TooLarge("the file size must be known in advance, it is limited to " + p.MaxFileSize)
+	case uint64(size) > uint64(max):
+		return errtypes.TooLarge(fmt.Sprintf("file size %d exceeds the maximum of %s", size, p.MaxFileSize))
+	}
+	return nil
+}
+
+// CheckName returns an error if the filename or the file type of the given file violate the policy
+func (p Policy) CheckName(filename string) error {
+	if err := p.CheckFilename(filename); err != nil {
+		return err
+	}
+	return p.checkType(filename)
+}
+
+// CheckFilename returns an error if the filename violates the policy, the file type is not checked
+func (p Policy) CheckFilename(filename string) error {
+	if p.MaxFilenameLength > 0 && len(filename) > p.MaxFilenameLength {
+		return errtypes.BadRequest(fmt.Sprintf("filename exceeds the maximum length of %d", p.MaxFilenameLength))
+	}
+	if p.ForbiddenCharacters != "" && strings.ContainsAny(filename, p.ForbiddenCharacters) {
+		return errtypes.BadRequest("filename contains forbidden characters: " + p.ForbiddenCharacters)
+	}
+	for _, n := range p.ReservedNames {
+		if strings.EqualFold(n, filename) {
+			return errtypes.BadRequest("filename is reserved: " + filename)
+		}
+	}
+	return nil
+}
+
+func (p Policy) checkType(filename string) error {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(filename)), ".")
+	if len(p.AllowedExtensions) > 0 && !containsExtension(p.AllowedExtensions, ext) {
+		return errtypes.UnsupportedMediaType("file extension is not allowed: " + ext)
+	}
+	if containsExtension(p.BlockedExtensions, ext) {
+		return errtypes.UnsupportedMediaType("file extension is blocked: " + ext)
+	}
+
+	if len(p.AllowedMimeTypes) == 0 && len(p.BlockedMimeTypes) == 0 {
+		return nil
+	}
+	mimetype := mime.Detect(false, filename)
+	if len(p.AllowedMimeTypes) > 0 && !matchesMimeType(p.AllowedMimeTypes, mimetype) {
+		return errtypes.UnsupportedMediaType("mime type is not allowed: " + mimetype)
+	}
+	if matchesMimeType(p.BlockedMimeTypes, mimetype) {
+		return errtypes.UnsupportedMediaType("mime type is blocked: " + mimetype)
+	}
+	return nil
+}
+
+func (p Policy) validate() error {
+	if p.MaxFileSize != "" {
+		if _, err := bytesize.Parse(p.MaxFileSize); err != nil {
+			return fmt.Errorf("invalid max_file_size %s: %w", p.MaxFileSize, err)
+		}
+	}
+	if p.MaxFilenameLength < 0 {
+		return fmt.Errorf("invalid max_filename_length %d", p.MaxFilenameLength)
+	}
+	return nil
+}
+
+// Empty returns true if the policy has no restrictions
+func (p Policy) Empty() bool {
+	return p.MaxFileSize == "" &&
+		len(p.AllowedExtensions) == 0 &&
+		len(p.BlockedExtensions) == 0 &&
+		len(p.AllowedMimeTypes) == 0 &&
+		len(p.BlockedMimeTypes) == 0 &&
+		p.ForbiddenCharacters == "" &&
+		len(p.ReservedNames) == 0 &&
+		p.MaxFilenameLength == 0
+}
+
+// merge returns a copy of p with all fields that are set in o overridden
+func (p Policy) merge(o Policy) Policy {
+	if o.MaxFileSize != "" {
+		p.MaxFileSize = o.MaxFileSize
+	}
+	if o.AllowedExtensions != nil {
+		p.AllowedExtensions = o.AllowedExtensions
+	}
+	if o.BlockedExtensions != nil {
+		p.BlockedExtensions = o.BlockedExtensions
+	}
+	if o.AllowedMimeTypes != nil {
+		p.AllowedMimeTypes = o.AllowedMimeTypes
+	}
+	if o.BlockedMimeTypes != nil {
+		p.BlockedMimeTypes = o.BlockedMimeTypes
+	}
+	if o.ForbiddenCharacters != "" {
+		p.ForbiddenCharacters = o.ForbiddenCharacters
+	}
+	if o.ReservedNames != nil {
+		p.ReservedNames = o.ReservedNames
+	}
+	if o.MaxFilenameLength != 0 {
+		p.MaxFilenameLength = o.MaxFilenameLength
+	}
+	return p
+}
+
+// Violation returns the kind of policy violation for the given error or an empty string
+func Violation(err error) string {
+	switch err.(type) {
+	case errtypes.TooLarge:
+		return ViolationSize
+	case errtypes.UnsupportedMediaType:
+		return ViolationType
+	case errtypes.BadRequest:
+		return ViolationFilename
+	default:
+		return ""
+	}
+}
+
+func containsExtension(exts []string, ext string) bool {
+	for _, e := range exts {
+		if strings.TrimPrefix(strings.ToLower(e), ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMimeType(patterns []string, mimetype string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == mimetype {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(mimetype, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package uploadpolicy
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	policy := Policy{
+		MaxFileSize:         "1KB",
+		BlockedExtensions:   []string{".exe"},
+		AllowedMimeTypes:    []string{"image/*", "text/plain"},
+		ForbiddenCharacters: "\\:",
+		ReservedNames:       []string{"desktop.ini"},
+		MaxFilenameLength:   16,
+	}
+
+	tests := []struct {
+		description string
+		filename    string
+		size        int64
+		violation   string
+	}{
+		{"allowed image", "photo.png", 100, ""},
+		{"allowed text", "notes.txt", 1000, ""},
+		{"unknown size", "photo.jpg", -1, ViolationSize},
+		{"too large", "photo.png", 2000, ViolationSize},
+		{"blocked extension", "setup.exe", 10, ViolationType},
+		{"mime type not allowed", "movie.mp4", 10, ViolationType},
+		{"forbidden character", "a:b.txt", 10, ViolationFilename},
+		{"reserved name", "Desktop.ini", 10, ViolationFilename},
+		{"filename too long", "a-very-long-filename.txt", 10, ViolationFilename},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			err := policy.Check(tt.filename, tt.size)
+			if tt.violation == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if v := Violation(err); v != tt.violation {
+				t.Errorf("expected violation %q, got %q (%v)", tt.violation, v, err)
+			}
+		})
+	}
+
+	if err := (Policy{BlockedExtensions: []string{"exe"}}).Check("notes.txt", -1); err != nil {
+		t.Errorf("expected an unknown size without a size limit to be allowed, got %v", err)
+	}
+	if err := policy.CheckName("setup.exe"); Violation(err) != ViolationType {
+		t.Errorf("expected the file type to be checked, got %v", err)
+	}
+	if err := policy.CheckFilename("setup.exe"); err != nil {
+		t.Errorf("expected only the filename to be checked, got %v", err)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	c := &Config{
+		Default: Policy{MaxFileSize: "10GB", ReservedNames: []string{"thumbs.db"}},
+		SpaceTypes: map[string]Policy{
+			"project": {MaxFileSize: "1GB"},
+		},
+		Spaces: map[string]Policy{
+			"space-1": {MaxFileSize: "5MB", ReservedNames: []string{}},
+		},
+	}
+
+	p := c.PolicyFor("personal", "space-2")
+	if p.MaxFileSize != "10GB" || len(p.ReservedNames) != 1 {
+		t.Errorf("expected default policy, got %+v", p)
+	}
+	p = c.PolicyFor("project", "space-2")
+	if p.MaxFileSize != "1GB" || len(p.ReservedNames) != 1 {
+		t.Errorf("expected space type policy to override the max file size, got %+v", p)
+	}
+	p = c.PolicyFor("project", "space-1")
+	if p.MaxFileSize != "5MB" || len(p.ReservedNames) != 0 {
+		t.Errorf("expected space policy to override the space type policy, got %+v", p)
+	}
+
+	if (&Config{}).Empty() != true || c.Empty() {
+		t.Error("unexpected result for Empty()")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := &Config{
+		Default:    Policy{MaxFileSize: "10GB"},
+		SpaceTypes: map[string]Policy{"project": {MaxFileSize: "1 GiB"}},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	invalid := &Config{
+		Spaces: map[string]Policy{"space-1": {MaxFileSize: "ten gigabytes"}},
+	}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected an invalid max_file_size to be rejected")
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package cas updates the values of a store without losing concurrent updates of other replicas.
+package cas
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+	"github.com/opencloud-eu/reva/v2/pkg/store"
+	microstore "go-micro.dev/v4/store"
+)
+
+// maxUpdateAttempts limits how often a concurrently modified key is retried
+const maxUpdateAttempts = 10
+
+// ErrConflict is returned if a key was modified concurrently too often
+var ErrConflict = errors.New("cas: too many concurrent updates of the key")
+
+// Config configures the store
+type Config struct {
+	Store        string
+	Nodes        []string
+	Database     string
+	Table        string
+	AuthUsername string
+	AuthPassword string
+}
+
+// Store updates keys. Implementations must not lose concurrent updates of the same key,
+// other keys must not be blocked by an update.
+type Store interface {
+	// Update passes the stored value, nil if there is none, to fn and stores the result.
+	// fn may be called again if the value was changed concurrently. A ttl of 0 keeps the value.
+	Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error
+	// Get returns the stored value, nil if there is none
+	Get(key string) ([]byte, error)
+	// Delete removes the key, it does not fail if there is none
+	Delete(key string) error
+	// List returns all keys
+	List() ([]string, error)
+}
+
+// New returns the store for the config. The redis and nats-js-kv stores are updated with atomic
+// compare-and-swap operations so that updates of other replicas are not lost. The other stores only
+// serialize the updates within a replica. The keys of nats-js-kv stores expire after maxTTL, 0 keeps them.
+func New(c Config, maxTTL time.Duration) (Store, error) {
+	switch c.Store {
+	case store.TypeRedis, store.TypeRedisSentinel:
+		return newRedisStore(c), nil
+	case store.TypeNatsJSKV:
+		return newNatsStore(c, maxTTL)
+	default:
+		return &localStore{store: store.Create(
+			store.Store(c.Store),
+			microstore.Nodes(c.Nodes...),
+			microstore.Database(c.Database),
+			microstore.Table(c.Table),
+			store.Authentication(c.AuthUsername, c.AuthPassword),
+		)}, nil
+	}
+}
+
+// keyLocks serializes the updates of a key within a replica without blocking other keys
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*keyLock{}
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.Lock()
+	return func() {
+		kl.Unlock()
+		l.mu.Lock()
+		if kl.refs--; kl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// backoff waits a random time that grows with the attempts before a conflicting update is retried
+func backoff(attempt int) {
+	time.Sleep(time.Duration(rand.Int63n(int64(attempt+1) * int64(time.Millisecond))))
+}
+
+// localStore only serializes the updates within a replica
+type localStore struct {
+	store microstore.Store
+	locks keyLocks
+}
+
+// Update implements Store
+func (b *localStore) Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	defer b.locks.lock(key)()
+
+	var old []byte
+	recs, err := b.store.Read(key)
+	switch {
+	case err == nil && len(recs) > 0:
+		old = recs[0].Value
+	case err != nil && !errors.Is(err, microstore.ErrNotFound):
+		return err
+	}
+	v, err := fn(old)
+	if err != nil {
+		return err
+	}
+	return b.store.Write(&microstore.Record{Key: key, Value: v, Expiry: ttl})
+}
+
+// Get implements Store
+func (b *localStore) Get(key string) ([]byte, error) {
+	recs, err := b.store.Read(key)
+	switch {
+	case errors.Is(err, microstore.ErrNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case len(recs) == 0:
+		return nil, nil
+	}
+	return recs[0].Value, nil
+}
+
+// Delete implements Store
+func (b *localStore) Delete(key string) error {
+	defer b.locks.lock(key)()
+	if err := b.store.Delete(key); err != nil && !errors.Is(err, microstore.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// List implements Store
+func (b *localStore) List() ([]string, error) {
+	return b.store.List()
+}
+
+// redisStore uses optimistic transactions, the update is discarded if the key was changed after it was read
+type redisStore struct {
+	client redis.UniversalClient
+	prefix string
+	locks  keyLocks
+}
+
+func newRedisStore(c Config) *redisStore {
+	opts := &redis.UniversalOptions{
+		Username: c.AuthUsername,
+		Password: c.AuthPassword,
+	}
+	for _, node := range c.Nodes {
+		node = strings.TrimPrefix(node, "redis://")
+		if c.Store == store.TypeRedisSentinel {
+			// sentinel nodes are configured as host:port/master
+			var master string
+			node, master, _ = strings.Cut(node, "/")
+			if opts.MasterName == "" {
+				opts.MasterName = master
+			}
+		}
+		opts.Addrs = append(opts.Addrs, node)
+	}
+	return &redisStore{
+		client: redis.NewUniversalClient(opts),
+		prefix: c.Database + ":" + c.Table + ":",
+	}
+}
+
+// Update implements Store
+func (b *redisStore) Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	// conflicts can only be caused by other replicas
+	defer b.locks.lock(key)()
+	ctx := context.Background()
+	key = b.prefix + key
+	for i := 0; i < maxUpdateAttempts; i++ {
+		if i > 0 {
+			backoff(i)
+		}
+		err := b.client.Watch(ctx, func(tx *redis.Tx) error {
+			old, err := tx.Get(ctx, key).Bytes()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			v, err := fn(old)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Set(ctx, key, v, ttl)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// Get implements Store
+func (b *redisStore) Get(key string) ([]byte, error) {
+	v, err := b.client.Get(context.Background(), b.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// Delete implements Store
+func (b *redisStore) Delete(key string) error {
+	return b.client.Del(context.Background(), b.prefix+key).Err()
+}
+
+// List implements Store
+func (b *redisStore) List() ([]string, error) {
+	ctx := context.Background()
+	keys := []string{}
+	it := b.client.Scan(ctx, 0, b.prefix+"*", 0).Iterator()
+	for it.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(it.Val(), b.prefix))
+	}
+	return keys, it.Err()
+}
+
+// natsStore uses the revisions of the key value store, an update fails if the key was changed after it was read.
+// The keys expire with the ttl of the bucket, which is set when the bucket is created.
+type natsStore struct {
+	kv    nats.KeyValue
+	locks keyLocks
+}
+
+func newNatsStore(c Config, maxTTL time.Duration) (*natsStore, error) {
+	opts := []nats.Option{nats.Name("reva-" + c.Table)}
+	if c.AuthUsername != "" {
+		opts = append(opts, nats.UserInfo(c.AuthUsername, c.AuthPassword))
+	}
+	nc, err := nats.Connect(strings.Join(c.Nodes, ","), opts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	bucket := c.Database + "_" + c.Table
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket: bucket,
+			TTL:    maxTTL,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &natsStore{kv: kv}, nil
+}
+
+// Update implements Store
+func (b *natsStore) Update(key string, _ time.Duration, fn func(old []byte) ([]byte, error)) error {
+	// conflicts can only be caused by other replicas
+	defer b.locks.lock(key)()
+	key = natsKey(key)
+	for i := 0; i < maxUpdateAttempts; i++ {
+		if i > 0 {
+			backoff(i)
+		}
+		var old []byte
+		var rev uint64
+		e, err := b.kv.Get(key)
+		switch {
+		case err == nil:
+			old, rev = e.Value(), e.Revision()
+		case !errors.Is(err, nats.ErrKeyNotFound):
+			return err
+		}
+		v, err := fn(old)
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			_, err = b.kv.Create(key, v)
+		} else {
+			_, err = b.kv.Update(key, v, rev)
+		}
+		var apiErr *nats.APIError
+		if err == nil || !(errors.Is(err, nats.ErrKeyExists) || errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// Get implements Store
+func (b *natsStore) Get(key string) ([]byte, error) {
+	e, err := b.kv.Get(natsKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.Value(), nil
+}
+
+// Delete implements Store
+func (b *natsStore) Delete(key string) error {
+	err := b.kv.Delete(natsKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// List implements Store
+func (b *natsStore) List() ([]string, error) {
+	encoded, err := b.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(encoded))
+	for _, k := range encoded {
+		key, err := base64.RawURLEncoding.DecodeString(k)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, string(key))
+	}
+	return keys, nil
+}
+
+// natsKey encodes the key, the keys contain characters that are not allowed by nats
+func natsKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
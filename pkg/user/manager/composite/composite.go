@@ -0,0 +1,305 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package composite implements a user manager that chains several other user managers,
+// e.g. to keep employees in LDAP and guests in a json file.
+package composite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/appctx"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/user"
+	"github.com/opencloud-eu/reva/v2/pkg/user/manager/registry"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registry.Register("composite", New)
+}
+
+type backendConfig struct {
+	// Driver is the name of the user manager driver of the backend
+	Driver string `mapstructure:"driver"`
+	// Config is the configuration of the driver
+	Config map[string]interface{} `mapstructure:"config"`
+	// Idp routes lookups of user ids with this idp to the backend
+	Idp string `mapstructure:"idp"`
+	// Prefix routes lookups of user ids starting with this prefix to the backend
+	Prefix string `mapstructure:"prefix"`
+	// Timeout is the timeout for requests to the backend in seconds. Defaults to the global timeout.
+	Timeout int `mapstructure:"timeout"`
+}
+
+type config struct {
+	// Backends are queried in the configured order. Earlier backends win when results are merged.
+	Backends []backendConfig `mapstructure:"backends"`
+	// Timeout is the default timeout for requests to a backend in seconds
+	Timeout int `mapstructure:"timeout"`
+}
+
+func (c *config) init() {
+	if c.Timeout == 0 {
+		c.Timeout = 5
+	}
+	for i := range c.Backends {
+		if c.Backends[i].Timeout == 0 {
+			c.Backends[i].Timeout = c.Timeout
+		}
+	}
+}
+
+func parseConfig(m map[string]interface{}) (*config, error) {
+	c := &config{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		err = errors.Wrap(err, "error decoding conf")
+		return nil, err
+	}
+	c.init()
+	return c, nil
+}
+
+type backend struct {
+	user.Manager
+	name    string
+	idp     string
+	prefix  string
+	timeout time.Duration
+}
+
+// routes returns true if lookups of the given id should go to this backend
+func (b *backend) routes(uid *userpb.UserId) bool {
+	return (b.idp != "" && b.idp == uid.GetIdp()) || (b.prefix != "" && strings.HasPrefix(uid.GetOpaqueId(), b.prefix))
+}
+
+type manager struct {
+	backends []*backend
+}
+
+// New returns a user manager that queries all configured user managers.
+func New(m map[string]interface{}) (user.Manager, error) {
+	mgr := &manager{}
+	err := mgr.Configure(m)
+	if err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
+
+func (m *manager) Configure(ml map[string]interface{}) error {
+	c, err := parseConfig(ml)
+	if err != nil {
+		return err
+	}
+	if len(c.Backends) == 0 {
+		return errors.New("composite: no backends configured")
+	}
+
+	backends := make([]*backend, 0, len(c.Backends))
+	for i, bc := range c.Backends {
+		if bc.Driver == "composite" {
+			return errors.New("composite: backends can not be composite")
+		}
+		f, ok := registry.NewFuncs[bc.Driver]
+		if !ok {
+			return errtypes.NotFound(fmt.Sprintf("composite: driver %s not found", bc.Driver))
+		}
+		mgr, err := f(bc.Config)
+		if err != nil {
+			return errors.Wrapf(err, "composite: error creating backend %d", i)
+		}
+		backends = append(backends, &backend{
+			Manager: mgr,
+			name:    fmt.Sprintf("%d-%s", i, bc.Driver),
+			idp:     bc.Idp,
+			prefix:  bc.Prefix,
+			timeout: time.Duration(bc.Timeout) * time.Second,
+		})
+	}
+	m.backends = backends
+	return nil
+}
+
+// route returns the backends responsible for the given id. All backends are responsible if none matches.
+func (m *manager) route(uid *userpb.UserId) []*backend {
+	routed := []*backend{}
+	for _, b := range m.backends {
+		if b.routes(uid) {
+			routed = append(routed, b)
+		}
+	}
+	if len(routed) == 0 {
+		return m.backends
+	}
+	return routed
+}
+
+// result is the result of a backend request
+type result[T any] struct {
+	value T
+	err   error
+}
+
+// call sends a request to the backend. It returns when the timeout of the backend is reached or the context
+// is done, even if the backend does not stop.
+func call[T any](ctx context.Context, b *backend, f func(context.Context, *backend) (T, error)) (T, error) {
+	var res T
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	done := make(chan result[T], 1)
+	go func() {
+		r, err := f(ctx, b)
+		done <- result[T]{r, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return res, ctx.Err()
+	}
+}
+
+// first returns the first result of the backends in order. Errors other than NotFound are returned if no backend found a result.
+func first[T any](ctx context.Context, backends []*backend, f func(context.Context, *backend) (T, error)) (T, error) {
+	var res T
+	var failed error
+	for _, b := range backends {
+		r, err := call(ctx, b, f)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		switch err.(type) {
+		case nil:
+			return r, nil
+		case errtypes.NotFound:
+		default:
+			appctx.GetLogger(ctx).Warn().Err(err).Str("backend", b.name).Msg("composite: backend request failed")
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	if failed != nil {
+		return res, failed
+	}
+	return res, errtypes.NotFound("no backend found a result")
+}
+
+// all queries the backends concurrently and returns the results in the order of the backends.
+// Failing backends are logged and skipped, backends that do not answer in time are not waited for.
+func all[T any](ctx context.Context, backends []*backend, f func(context.Context, *backend) (T, error)) []T {
+	results := make([]T, len(backends))
+	ok := make([]bool, len(backends))
+	wg := sync.WaitGroup{}
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			r, err := call(ctx, b, f)
+			if err != nil {
+				if _, notFound := err.(errtypes.NotFound); !notFound && ctx.Err() == nil {
+					appctx.GetLogger(ctx).Warn().Err(err).Str("backend", b.name).Msg("composite: backend request failed")
+				}
+				return
+			}
+			results[i], ok[i] = r, true
+		}(i, b)
+	}
+	wg.Wait()
+
+	res := make([]T, 0, len(backends))
+	for i := range results {
+		if ok[i] {
+			res = append(res, results[i])
+		}
+	}
+	return res
+}
+
+func (m *manager) GetUser(ctx context.Context, uid *userpb.UserId, skipFetchingGroups bool) (*userpb.User, error) {
+	u, err := first(ctx, m.route(uid), func(ctx context.Context, b *backend) (*userpb.User, error) {
+		return b.GetUser(ctx, uid, true)
+	})
+	if err != nil || skipFetchingGroups {
+		return u, err
+	}
+	u.Groups, err = m.GetUserGroups(ctx, u.Id)
+	return u, err
+}
+
+func (m *manager) GetUserByClaim(ctx context.Context, claim, value string, skipFetchingGroups bool) (*userpb.User, error) {
+	u, err := first(ctx, m.backends, func(ctx context.Context, b *backend) (*userpb.User, error) {
+		return b.GetUserByClaim(ctx, claim, value, true)
+	})
+	if err != nil || skipFetchingGroups {
+		return u, err
+	}
+	u.Groups, err = m.GetUserGroups(ctx, u.Id)
+	return u, err
+}
+
+// GetUserGroups merges the groups all backends know for the user, e.g. a guest from a json backend
+// can list the id of a group from an LDAP backend.
+func (m *manager) GetUserGroups(ctx context.Context, uid *userpb.UserId) ([]string, error) {
+	results := all(ctx, m.backends, func(ctx context.Context, b *backend) ([]string, error) {
+		return b.GetUserGroups(ctx, uid)
+	})
+	if len(results) == 0 {
+		return nil, errtypes.NotFound(uid.GetOpaqueId())
+	}
+	groups := []string{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		for _, g := range r {
+			if !seen[g] {
+				seen[g] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// FindUsers merges the users found by all backends. Users with the same id are only returned once.
+func (m *manager) FindUsers(ctx context.Context, query string, skipFetchingGroups bool) ([]*userpb.User, error) {
+	results := all(ctx, m.backends, func(ctx context.Context, b *backend) ([]*userpb.User, error) {
+		return b.FindUsers(ctx, query, skipFetchingGroups)
+	})
+	users := []*userpb.User{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		for _, u := range r {
+			key := u.GetId().GetIdp() + "!" + u.GetId().GetOpaqueId()
+			if !seen[key] {
+				seen[key] = true
+				users = append(users, u)
+			}
+		}
+	}
+	return users, nil
+}
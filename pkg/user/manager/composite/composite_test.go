@@ -0,0 +1,109 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package composite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+
+	_ "github.com/opencloud-eu/reva/v2/pkg/user/manager/memory"
+)
+
+func memoryUser(idp, id, username string, groups ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       map[string]interface{}{"Idp": idp, "OpaqueId": id},
+		"username": username,
+		"groups":   groups,
+	}
+}
+
+func TestCompositeUserManager(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := New(map[string]interface{}{
+		"backends": []map[string]interface{}{
+			{
+				"driver": "memory",
+				"idp":    "https://ldap.example.org",
+				"config": map[string]interface{}{"users": map[string]interface{}{
+					"einstein": memoryUser("https://ldap.example.org", "einstein", "einstein", "physics-lovers"),
+					"shared":   memoryUser("https://ldap.example.org", "shared", "shared-employee"),
+				}},
+			},
+			{
+				"driver": "memory",
+				"prefix": "guest-",
+				"config": map[string]interface{}{"users": map[string]interface{}{
+					"guest-marie": memoryUser("https://guests.example.org", "guest-marie", "marie", "physics-lovers", "guests"),
+					"shared":      memoryUser("https://ldap.example.org", "shared", "shared-guest"),
+				}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := mgr.GetUser(ctx, &userpb.UserId{OpaqueId: "guest-marie"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u.Groups, []string{"physics-lovers", "guests"}) {
+		t.Fatalf("unexpected groups %v", u.Groups)
+	}
+	if _, err := mgr.GetUser(ctx, &userpb.UserId{Idp: "https://ldap.example.org", OpaqueId: "guest-marie"}, true); err == nil {
+		t.Fatal("expected idp routing to skip the guest backend")
+	}
+	if _, err := mgr.GetUser(ctx, &userpb.UserId{OpaqueId: "nobody"}, true); !reflect.DeepEqual(err, errtypes.NotFound("no backend found a result")) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	u, err = mgr.GetUserByClaim(ctx, "username", "marie", true)
+	if err != nil || u.Id.OpaqueId != "guest-marie" {
+		t.Fatalf("expected marie, got %v, %v", u, err)
+	}
+
+	users, err := mgr.FindUsers(ctx, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("expected 3 distinct users, got %d", len(users))
+	}
+	for _, u := range users {
+		if u.Id.OpaqueId == "shared" && u.Username != "shared-employee" {
+			t.Fatalf("expected the first backend to win, got %s", u.Username)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	for _, c := range []map[string]interface{}{
+		{},
+		{"backends": []map[string]interface{}{{"driver": "unknown"}}},
+		{"backends": []map[string]interface{}{{"driver": "composite"}}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("expected error for config %v", c)
+		}
+	}
+}
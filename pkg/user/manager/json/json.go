@@ -22,10 +22,15 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/google/uuid"
 	"github.com/mitchellh/mapstructure"
+	"github.com/opencloud-eu/reva/v2/pkg/group"
+	groupjson "github.com/opencloud-eu/reva/v2/pkg/group/manager/json"
 	"github.com/opencloud-eu/reva/v2/pkg/user"
 	"github.com/opencloud-eu/reva/v2/pkg/user/manager/registry"
 	"github.com/pkg/errors"
@@ -40,12 +45,19 @@ func init() {
 }
 
 type manager struct {
-	users []*userpb.User
+	sync.RWMutex
+	file   string
+	info   os.FileInfo
+	users  []*userpb.User
+	groups group.Manager
 }
 
 type config struct {
 	// Users holds a path to a file containing json conforming to the Users struct
 	Users string `mapstructure:"users"`
+	// Groups optionally holds the path to the file of the json group manager. The members of the groups
+	// in that file are taken from there instead of the groups of the users.
+	Groups string `mapstructure:"groups"`
 }
 
 func (c *config) init() {
@@ -80,7 +92,26 @@ func (m *manager) Configure(ml map[string]interface{}) error {
 		return err
 	}
 
-	f, err := os.ReadFile(c.Users)
+	m.file = c.Users
+	if c.Groups != "" {
+		if m.groups, err = groupjson.New(map[string]interface{}{"groups": c.Groups}); err != nil {
+			return err
+		}
+	}
+	return m.load()
+}
+
+// load reads the users file if it was changed since it was last read
+func (m *manager) load() error {
+	info, err := os.Stat(m.file)
+	if err != nil {
+		return err
+	}
+	if m.unchanged(info) {
+		return nil
+	}
+
+	f, err := os.ReadFile(m.file)
 	if err != nil {
 		return err
 	}
@@ -92,30 +123,88 @@ func (m *manager) Configure(ml map[string]interface{}) error {
 		return err
 	}
 	m.users = users
+	m.info = info
+	return nil
+}
+
+// unchanged tells if the users file was not changed since it was last read. persist replaces the file,
+// so a changed file is detected even if the modification time did not change.
+func (m *manager) unchanged(info os.FileInfo) bool {
+	return m.users != nil && os.SameFile(info, m.info) && info.ModTime().Equal(m.info.ModTime())
+}
+
+// reload picks up changes other instances made to the users file. The write lock is only taken if
+// the file changed, so that reads do not block each other.
+func (m *manager) reload() {
+	info, err := os.Stat(m.file)
+	if err != nil {
+		return
+	}
+	m.RLock()
+	unchanged := m.unchanged(info)
+	m.RUnlock()
+	if unchanged {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	_ = m.load()
+}
+
+// persist atomically writes the users to the users file
+func (m *manager) persist() error {
+	b, err := json.MarshalIndent(m.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), filepath.Base(m.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), m.file); err != nil {
+		return err
+	}
+	if info, err := os.Stat(m.file); err == nil {
+		m.info = info
+	}
 	return nil
 }
 
 func (m *manager) GetUser(ctx context.Context, uid *userpb.UserId, skipFetchingGroups bool) (*userpb.User, error) {
+	ms, err := m.memberships(ctx, skipFetchingGroups)
+	if err != nil {
+		return nil, err
+	}
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	for _, u := range m.users {
 		if (u.Id.GetOpaqueId() == uid.OpaqueId || u.Username == uid.OpaqueId) && (uid.Idp == "" || uid.Idp == u.Id.GetIdp()) {
-			user := proto.Clone(u).(*userpb.User)
-			if skipFetchingGroups {
-				user.Groups = nil
-			}
-			return user, nil
+			return withGroups(u, ms, skipFetchingGroups), nil
 		}
 	}
 	return nil, errtypes.NotFound(uid.OpaqueId)
 }
 
 func (m *manager) GetUserByClaim(ctx context.Context, claim, value string, skipFetchingGroups bool) (*userpb.User, error) {
+	ms, err := m.memberships(ctx, skipFetchingGroups)
+	if err != nil {
+		return nil, err
+	}
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	for _, u := range m.users {
 		if userClaim, err := extractClaim(u, claim); err == nil && value == userClaim {
-			user := proto.Clone(u).(*userpb.User)
-			if skipFetchingGroups {
-				user.Groups = nil
-			}
-			return user, nil
+			return withGroups(u, ms, skipFetchingGroups), nil
 		}
 	}
 	return nil, errtypes.NotFound(value)
@@ -145,19 +234,90 @@ func userContains(u *userpb.User, query string) bool {
 }
 
 func (m *manager) FindUsers(ctx context.Context, query string, skipFetchingGroups bool) ([]*userpb.User, error) {
+	ms, err := m.memberships(ctx, skipFetchingGroups)
+	if err != nil {
+		return nil, err
+	}
+	m.reload()
+	m.RLock()
+	defer m.RUnlock()
 	users := []*userpb.User{}
 	for _, u := range m.users {
 		if userContains(u, query) {
-			user := proto.Clone(u).(*userpb.User)
-			if skipFetchingGroups {
-				user.Groups = nil
-			}
-			users = append(users, user)
+			users = append(users, withGroups(u, ms, skipFetchingGroups))
 		}
 	}
 	return users, nil
 }
 
+// withGroups returns a copy of the user with its current groups
+func withGroups(u *userpb.User, ms *memberships, skipFetchingGroups bool) *userpb.User {
+	user := proto.Clone(u).(*userpb.User)
+	if skipFetchingGroups {
+		user.Groups = nil
+	} else {
+		user.Groups = ms.groupsOf(u)
+	}
+	return user
+}
+
+// memberships indexes the members of the groups that are managed by the group manager, so that
+// changes to the members of a group are visible to the users
+type memberships struct {
+	// managed holds the names of the groups of the group manager
+	managed map[string]bool
+	// members maps the opaque ids of the members to their groups
+	members map[string][]membership
+}
+
+type membership struct {
+	idp   string
+	group string
+}
+
+// memberships builds the membership index from the groups of the group manager. It is nil if there
+// is no group manager or the groups are not needed.
+func (m *manager) memberships(ctx context.Context, skipFetchingGroups bool) (*memberships, error) {
+	if m.groups == nil || skipFetchingGroups {
+		return nil, nil
+	}
+	all, err := m.groups.FindGroups(ctx, "", false)
+	if err != nil {
+		return nil, err
+	}
+	ms := &memberships{
+		managed: make(map[string]bool, len(all)),
+		members: map[string][]membership{},
+	}
+	for _, g := range all {
+		ms.managed[g.GroupName] = true
+		for _, member := range g.Members {
+			ms.members[member.GetOpaqueId()] = append(ms.members[member.GetOpaqueId()], membership{idp: member.GetIdp(), group: g.GroupName})
+		}
+	}
+	return ms, nil
+}
+
+// groupsOf returns the names of the groups of the user. Without index the groups stored with the user are returned.
+func (ms *memberships) groupsOf(u *userpb.User) []string {
+	if ms == nil {
+		return u.Groups
+	}
+	groups := []string{}
+	for _, member := range ms.members[u.Id.GetOpaqueId()] {
+		// the memberships of a group are indexed one after another, skip duplicate members
+		if (member.idp == "" || member.idp == u.Id.GetIdp()) && (len(groups) == 0 || groups[len(groups)-1] != member.group) {
+			groups = append(groups, member.group)
+		}
+	}
+	for _, name := range u.Groups {
+		if !ms.managed[name] {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
 func (m *manager) GetUserGroups(ctx context.Context, uid *userpb.UserId) ([]string, error) {
 	user, err := m.GetUser(ctx, uid, false)
 	if err != nil {
@@ -165,3 +325,101 @@ func (m *manager) GetUserGroups(ctx context.Context, uid *userpb.UserId) ([]stri
 	}
 	return user.Groups, nil
 }
+
+// CreateUser implements the user.Writer interface
+func (m *manager) CreateUser(ctx context.Context, u *userpb.User) (*userpb.User, error) {
+	if u.Username == "" {
+		return nil, errtypes.BadRequest("username must not be empty")
+	}
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	u = proto.Clone(u).(*userpb.User)
+	if u.Id == nil {
+		u.Id = &userpb.UserId{}
+	}
+	if u.Id.OpaqueId == "" {
+		u.Id.OpaqueId = uuid.New().String()
+	}
+	if u.Id.Type == userpb.UserType_USER_TYPE_INVALID {
+		u.Id.Type = userpb.UserType_USER_TYPE_PRIMARY
+	}
+	for _, existing := range m.users {
+		if existing.Id.GetOpaqueId() == u.Id.OpaqueId || existing.Username == u.Username {
+			return nil, errtypes.AlreadyExists(u.Username)
+		}
+	}
+
+	m.users = append(m.users, u)
+	if err := m.persist(); err != nil {
+		m.users = m.users[:len(m.users)-1]
+		return nil, err
+	}
+	return proto.Clone(u).(*userpb.User), nil
+}
+
+// UpdateUser implements the user.Writer interface
+func (m *manager) UpdateUser(ctx context.Context, u *userpb.User) (*userpb.User, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	i := m.indexOf(u.GetId())
+	if i < 0 {
+		return nil, errtypes.NotFound(u.GetId().GetOpaqueId())
+	}
+	for j, existing := range m.users {
+		if j != i && u.Username != "" && existing.Username == u.Username {
+			return nil, errtypes.AlreadyExists(u.Username)
+		}
+	}
+
+	old := m.users[i]
+	updated := proto.Clone(u).(*userpb.User)
+	updated.Id = old.Id
+	updated.Groups = old.Groups
+	if updated.Username == "" {
+		updated.Username = old.Username
+	}
+	m.users[i] = updated
+	if err := m.persist(); err != nil {
+		m.users[i] = old
+		return nil, err
+	}
+	return proto.Clone(updated).(*userpb.User), nil
+}
+
+// DeleteUser implements the user.Writer interface
+func (m *manager) DeleteUser(ctx context.Context, uid *userpb.UserId) error {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.load(); err != nil {
+		return err
+	}
+
+	i := m.indexOf(uid)
+	if i < 0 {
+		return errtypes.NotFound(uid.GetOpaqueId())
+	}
+	users := m.users
+	m.users = append(append([]*userpb.User{}, users[:i]...), users[i+1:]...)
+	if err := m.persist(); err != nil {
+		m.users = users
+		return err
+	}
+	return nil
+}
+
+func (m *manager) indexOf(uid *userpb.UserId) int {
+	for i, u := range m.users {
+		if u.Id.GetOpaqueId() == uid.GetOpaqueId() && (uid.GetIdp() == "" || uid.GetIdp() == u.Id.GetIdp()) {
+			return i
+		}
+	}
+	return -1
+}
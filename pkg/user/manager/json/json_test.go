@@ -24,8 +24,12 @@ import (
 	"reflect"
 	"testing"
 
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/group"
+	groupjson "github.com/opencloud-eu/reva/v2/pkg/group/manager/json"
+	"github.com/opencloud-eu/reva/v2/pkg/user"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -147,3 +151,95 @@ func TestUserManager(t *testing.T) {
 		t.Fatalf("user differ: expected=%v got=%v", "einstein", resUser[0].Username)
 	}
 }
+
+func TestUserWriter(t *testing.T) {
+	file := t.TempDir() + "/users.json"
+	if err := os.WriteFile(file, []byte(`[{"id":{"idp":"localhost","opaque_id":"einstein","type":1},"username":"einstein"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := New(map[string]interface{}{"users": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := mgr.(user.Writer)
+
+	if _, err := w.CreateUser(ctx, &userpb.User{Username: "einstein"}); !reflect.DeepEqual(err, errtypes.AlreadyExists("einstein")) {
+		t.Fatalf("expected already exists error, got %v", err)
+	}
+	created, err := w.CreateUser(ctx, &userpb.User{Username: "marie", Mail: "marie@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id.OpaqueId == "" || created.Id.Type != userpb.UserType_USER_TYPE_PRIMARY {
+		t.Fatalf("expected generated primary user id, got %v", created.Id)
+	}
+
+	created.DisplayName = "Marie Curie"
+	if _, err := w.UpdateUser(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second instance reads the persisted changes
+	other, err := New(map[string]interface{}{"users": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := other.GetUser(ctx, created.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.DisplayName != "Marie Curie" || u.Mail != "marie@example.org" {
+		t.Fatalf("unexpected user %v", u)
+	}
+
+	if err := w.DeleteUser(ctx, created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.GetUser(ctx, created.Id, false); !reflect.DeepEqual(err, errtypes.NotFound(created.Id.OpaqueId)) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if err := w.DeleteUser(ctx, created.Id); !reflect.DeepEqual(err, errtypes.NotFound(created.Id.OpaqueId)) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestGroupsFromGroupManager(t *testing.T) {
+	dir := t.TempDir()
+	users := dir + "/users.json"
+	if err := os.WriteFile(users, []byte(`[{"id":{"idp":"localhost","opaque_id":"einstein","type":1},"username":"einstein","groups":["sailing-lovers","physics-lovers"]}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	groups := dir + "/groups.json"
+	if err := os.WriteFile(groups, []byte(`[{"id":{"opaque_id":"sailing-lovers"},"group_name":"sailing-lovers"},{"id":{"opaque_id":"violin-haters"},"group_name":"violin-haters"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := New(map[string]interface{}{"users": users, "groups": groups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm, err := groupjson.New(map[string]interface{}{"groups": groups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	einstein := &userpb.UserId{Idp: "localhost", OpaqueId: "einstein", Type: userpb.UserType_USER_TYPE_PRIMARY}
+
+	// groups of the group manager decide about the membership, the other groups are kept
+	got, err := mgr.GetUserGroups(ctx, einstein)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"physics-lovers"}) {
+		t.Errorf("unexpected groups %v", got)
+	}
+
+	if err := gm.(group.Writer).AddMembers(ctx, &grouppb.GroupId{OpaqueId: "violin-haters"}, []*userpb.UserId{einstein}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := mgr.GetUser(ctx, einstein, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u.Groups, []string{"violin-haters", "physics-lovers"}) {
+		t.Errorf("expected the added membership, got %v", u.Groups)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/go-ldap/ldap/v3"
@@ -51,6 +52,10 @@ type config struct {
 	Idp            string                `mapstructure:"idp"`
 	// Nobody specifies the fallback uid number for users that don't have a uidNumber set in LDAP
 	Nobody int64 `mapstructure:"nobody"`
+	// WriteEnabled allows creating, updating and deleting users
+	WriteEnabled bool `mapstructure:"write_enabled"`
+	// CreateObjectClasses are the object classes of newly created users. The configured user_objectclass is always added.
+	CreateObjectClasses []string `mapstructure:"user_create_objectclasses"`
 }
 
 func parseConfig(m map[string]interface{}) (*config, error) {
@@ -86,6 +91,9 @@ func (m *manager) Configure(ml map[string]interface{}) error {
 	if c.Nobody == 0 {
 		c.Nobody = 99
 	}
+	if len(c.CreateObjectClasses) == 0 {
+		c.CreateObjectClasses = []string{"inetOrgPerson"}
+	}
 
 	if err = c.LDAPIdentity.Setup(); err != nil {
 		return fmt.Errorf("error setting up Identity config: %w", err)
@@ -264,3 +272,168 @@ func (m *manager) ldapEntryToUserID(entry *ldap.Entry) (*userpb.UserId, error) {
 		Type:     m.c.LDAPIdentity.GetUserType(entry),
 	}, nil
 }
+
+// CreateUser implements the user.Writer interface. The entry is created below the user base dn
+// using the username as RDN.
+func (m *manager) CreateUser(ctx context.Context, u *userpb.User) (*userpb.User, error) {
+	if !m.c.WriteEnabled {
+		return nil, errtypes.NotSupported("ldap: write support is disabled")
+	}
+	if u.Username == "" {
+		return nil, errtypes.BadRequest("username must not be empty")
+	}
+	log := appctx.GetLogger(ctx)
+	if _, err := m.c.LDAPIdentity.GetLDAPUserByAttribute(log, m.ldapClient, "username", u.Username); err == nil {
+		return nil, errtypes.AlreadyExists(u.Username)
+	}
+
+	schema := m.c.LDAPIdentity.User.Schema
+	dn := fmt.Sprintf("%s=%s,%s", schema.Username, ldap.EscapeDN(u.Username), m.c.LDAPIdentity.User.BaseDN)
+	classes := m.objectClasses()
+	ar := ldap.NewAddRequest(dn, nil)
+	ar.Attribute("objectClass", classes)
+
+	attrs := map[string]string{
+		schema.Username: u.Username,
+	}
+	setIfEmpty := func(attr, value string) {
+		if _, ok := attrs[attr]; !ok && value != "" {
+			attrs[attr] = value
+		}
+	}
+	setIfEmpty(schema.DisplayName, u.DisplayName)
+	setIfEmpty(schema.Mail, u.Mail)
+	if u.UidNumber != 0 {
+		setIfEmpty(schema.UIDNumber, strconv.FormatInt(u.UidNumber, 10))
+	}
+	if u.GidNumber != 0 {
+		setIfEmpty(schema.GIDNumber, strconv.FormatInt(u.GidNumber, 10))
+	}
+	if containsFold(classes, "inetOrgPerson") || containsFold(classes, "person") {
+		// persons require a cn and sn
+		setIfEmpty("cn", u.Username)
+		setIfEmpty("sn", u.DisplayName)
+		setIfEmpty("sn", u.Username)
+	}
+	for attr, value := range attrs {
+		ar.Attribute(attr, []string{value})
+	}
+	if !isOperationalID(schema.ID) {
+		id := uuid.New()
+		if u.GetId().GetOpaqueId() != "" {
+			var err error
+			if id, err = uuid.Parse(u.GetId().GetOpaqueId()); err != nil {
+				return nil, errtypes.BadRequest("ldap: user ids must be uuids")
+			}
+		}
+		if schema.IDIsOctetString {
+			b, _ := id.MarshalBinary()
+			ar.Attribute(schema.ID, []string{string(b)})
+		} else {
+			ar.Attribute(schema.ID, []string{id.String()})
+		}
+	}
+
+	if err := m.ldapClient.Add(ar); err != nil {
+		log.Debug().Err(err).Str("dn", dn).Msg("error creating user")
+		return nil, ldapError(err, u.Username)
+	}
+	return m.GetUserByClaim(ctx, "username", u.Username, true)
+}
+
+// UpdateUser implements the user.Writer interface. Renaming users is not supported.
+func (m *manager) UpdateUser(ctx context.Context, u *userpb.User) (*userpb.User, error) {
+	if !m.c.WriteEnabled {
+		return nil, errtypes.NotSupported("ldap: write support is disabled")
+	}
+	log := appctx.GetLogger(ctx)
+	entry, err := m.c.LDAPIdentity.GetLDAPUserByID(log, m.ldapClient, u.GetId().GetOpaqueId())
+	if err != nil {
+		return nil, err
+	}
+	schema := m.c.LDAPIdentity.User.Schema
+	if u.Username != "" && u.Username != entry.GetEqualFoldAttributeValue(schema.Username) {
+		return nil, errtypes.NotSupported("ldap: users can not be renamed")
+	}
+
+	mr := ldap.NewModifyRequest(entry.DN, nil)
+	replace := func(attr, value string) {
+		switch {
+		case attr == schema.Username:
+		case value != "":
+			mr.Replace(attr, []string{value})
+		case entry.GetEqualFoldAttributeValue(attr) != "":
+			mr.Delete(attr, nil)
+		}
+	}
+	replace(schema.DisplayName, u.DisplayName)
+	replace(schema.Mail, u.Mail)
+	if u.UidNumber != 0 {
+		replace(schema.UIDNumber, strconv.FormatInt(u.UidNumber, 10))
+	}
+	if u.GidNumber != 0 {
+		replace(schema.GIDNumber, strconv.FormatInt(u.GidNumber, 10))
+	}
+	if len(mr.Changes) > 0 {
+		if err := m.ldapClient.Modify(mr); err != nil {
+			log.Debug().Err(err).Str("dn", entry.DN).Msg("error updating user")
+			return nil, ldapError(err, u.GetId().GetOpaqueId())
+		}
+	}
+	return m.GetUser(ctx, u.Id, true)
+}
+
+// DeleteUser implements the user.Writer interface
+func (m *manager) DeleteUser(ctx context.Context, uid *userpb.UserId) error {
+	if !m.c.WriteEnabled {
+		return errtypes.NotSupported("ldap: write support is disabled")
+	}
+	log := appctx.GetLogger(ctx)
+	entry, err := m.c.LDAPIdentity.GetLDAPUserByID(log, m.ldapClient, uid.GetOpaqueId())
+	if err != nil {
+		return err
+	}
+	if err := m.ldapClient.Del(ldap.NewDelRequest(entry.DN, nil)); err != nil {
+		log.Debug().Err(err).Str("dn", entry.DN).Msg("error deleting user")
+		return ldapError(err, uid.GetOpaqueId())
+	}
+	return nil
+}
+
+func (m *manager) objectClasses() []string {
+	classes := append([]string{}, m.c.CreateObjectClasses...)
+	if containsFold(classes, m.c.LDAPIdentity.User.Objectclass) {
+		return classes
+	}
+	return append(classes, m.c.LDAPIdentity.User.Objectclass)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOperationalID returns true for id attributes that are generated by the server
+func isOperationalID(attr string) bool {
+	switch strings.ToLower(attr) {
+	case "entryuuid", "objectguid", "nsuniqueid", "ipauniqueid":
+		return true
+	}
+	return false
+}
+
+func ldapError(err error, id string) error {
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists):
+		return errtypes.AlreadyExists(id)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject):
+		return errtypes.NotFound(id)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights):
+		return errtypes.PermissionDenied(id)
+	}
+	return err
+}
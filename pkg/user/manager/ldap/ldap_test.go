@@ -19,8 +19,13 @@
 package ldap
 
 import (
+	"context"
 	"testing"
 
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/user"
+
 	ldapIdentity "github.com/opencloud-eu/reva/v2/pkg/utils/ldap"
 )
 
@@ -66,3 +71,14 @@ func TestUserManager(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 }
+
+func TestWriteDisabled(t *testing.T) {
+	mgr, err := New(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mgr.(user.Writer).CreateUser(context.Background(), &userpb.User{Username: "einstein"})
+	if _, ok := err.(errtypes.NotSupported); !ok {
+		t.Fatalf("expected not supported error, got %v", err)
+	}
+}
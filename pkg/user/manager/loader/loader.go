@@ -20,6 +20,7 @@ package loader
 
 import (
 	// Load core user manager drivers.
+	_ "github.com/opencloud-eu/reva/v2/pkg/user/manager/composite"
 	_ "github.com/opencloud-eu/reva/v2/pkg/user/manager/demo"
 	_ "github.com/opencloud-eu/reva/v2/pkg/user/manager/json"
 	_ "github.com/opencloud-eu/reva/v2/pkg/user/manager/ldap"
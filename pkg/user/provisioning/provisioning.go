@@ -0,0 +1,172 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package provisioning defines the grpc api to create, update and delete users, which the CS3 user api has no calls for.
+//
+// The api reuses the messages of the CS3 user api: creating and updating take the user and return it in a
+// GetUserResponse, deleting takes a GetUserRequest with the id of the user. Providers whose driver does not
+// allow writing answer with an unimplemented status.
+package provisioning
+
+import (
+	"context"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the full name of the grpc service
+const ServiceName = "revad.identity.user.v1beta1.ProvisioningAPI"
+
+const (
+	createMethod = "/" + ServiceName + "/CreateUser"
+	updateMethod = "/" + ServiceName + "/UpdateUser"
+	deleteMethod = "/" + ServiceName + "/DeleteUser"
+)
+
+// ProvisioningAPIServer is the server api for provisioning users
+type ProvisioningAPIServer interface {
+	// CreateUser creates the user and returns it including the generated id
+	CreateUser(context.Context, *userpb.User) (*userpb.GetUserResponse, error)
+	// UpdateUser replaces the attributes of the user identified by its id, group memberships are not changed
+	UpdateUser(context.Context, *userpb.User) (*userpb.GetUserResponse, error)
+	// DeleteUser deletes the user, the response contains no user
+	DeleteUser(context.Context, *userpb.GetUserRequest) (*userpb.GetUserResponse, error)
+}
+
+// ProvisioningAPIClient is the client api for provisioning users
+type ProvisioningAPIClient interface {
+	// CreateUser creates the user and returns it including the generated id
+	CreateUser(ctx context.Context, in *userpb.User, opts ...grpc.CallOption) (*userpb.GetUserResponse, error)
+	// UpdateUser replaces the attributes of the user identified by its id, group memberships are not changed
+	UpdateUser(ctx context.Context, in *userpb.User, opts ...grpc.CallOption) (*userpb.GetUserResponse, error)
+	// DeleteUser deletes the user, the response contains no user
+	DeleteUser(ctx context.Context, in *userpb.GetUserRequest, opts ...grpc.CallOption) (*userpb.GetUserResponse, error)
+}
+
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewProvisioningAPIClient returns a new client for the provisioning api
+func NewProvisioningAPIClient(cc grpc.ClientConnInterface) ProvisioningAPIClient {
+	return &client{cc: cc}
+}
+
+func (c *client) CreateUser(ctx context.Context, in *userpb.User, opts ...grpc.CallOption) (*userpb.GetUserResponse, error) {
+	out := new(userpb.GetUserResponse)
+	if err := c.cc.Invoke(ctx, createMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) UpdateUser(ctx context.Context, in *userpb.User, opts ...grpc.CallOption) (*userpb.GetUserResponse, error) {
+	out := new(userpb.GetUserResponse)
+	if err := c.cc.Invoke(ctx, updateMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *client) DeleteUser(ctx context.Context, in *userpb.GetUserRequest, opts ...grpc.CallOption) (*userpb.GetUserResponse, error) {
+	out := new(userpb.GetUserResponse)
+	if err := c.cc.Invoke(ctx, deleteMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterProvisioningAPIServer registers the provisioning api with the grpc server
+func RegisterProvisioningAPIServer(s grpc.ServiceRegistrar, srv ProvisioningAPIServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ProvisioningAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateUser",
+			Handler:    createHandler,
+		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    updateHandler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    deleteHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func createHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(userpb.User)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).CreateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: createMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).CreateUser(ctx, req.(*userpb.User))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func updateHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(userpb.User)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).UpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: updateMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).UpdateUser(ctx, req.(*userpb.User))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func deleteHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(userpb.GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProvisioningAPIServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: deleteMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProvisioningAPIServer).DeleteUser(ctx, req.(*userpb.GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
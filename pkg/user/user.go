@@ -39,3 +39,13 @@ type Manager interface {
 	// FindUsers returns all the user objects which match a query parameter.
 	FindUsers(ctx context.Context, query string, skipFetchingGroups bool) ([]*userpb.User, error)
 }
+
+// Writer is an optional interface for user managers that allow provisioning users.
+type Writer interface {
+	// CreateUser creates a new user and returns it including the generated id.
+	CreateUser(ctx context.Context, u *userpb.User) (*userpb.User, error)
+	// UpdateUser replaces the attributes of the user identified by u.Id. Group memberships are not changed.
+	UpdateUser(ctx context.Context, u *userpb.User) (*userpb.User, error)
+	// DeleteUser deletes the user identified by uid.
+	DeleteUser(ctx context.Context, uid *userpb.UserId) error
+}
@@ -21,10 +21,12 @@ package ldap
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	identityUser "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/go-ldap/ldap/v3"
 	"github.com/google/uuid"
+	"github.com/jellydator/ttlcache/v2"
 	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -61,6 +63,14 @@ type groupConfig struct {
 	substringFilterVal  int
 	// LocalDisabledDN contains the full DN of a group that contains disabled users.
 	LocalDisabledDN string `mapstructure:"group_local_disabled_dn"`
+	// NestedGroups configures how members of nested groups are resolved: none, in_chain
+	// (Active Directory's LDAP_MATCHING_RULE_IN_CHAIN) or recursive
+	NestedGroups string `mapstructure:"group_nested_groups"`
+	// NestedMaxDepth limits the levels of nesting that are resolved recursively
+	NestedMaxDepth int `mapstructure:"group_nested_max_depth"`
+	// NestedCacheTTL is the time in seconds nested group lookups are cached. 0 disables the cache.
+	NestedCacheTTL int `mapstructure:"group_nested_cache_ttl"`
+	nestedCache    *ttlcache.Cache
 }
 
 type groupSchema struct {
@@ -126,6 +136,9 @@ var groupDefaults = groupConfig{
 		Member:          "memberUid",
 	},
 	SubstringFilterType: "initial",
+	NestedGroups:        "none",
+	NestedMaxDepth:      10,
+	NestedCacheTTL:      60,
 }
 
 // New initializes the default config
@@ -171,6 +184,21 @@ func (i *Identity) Setup() error {
 		return fmt.Errorf("invalid disable mechanism setting: %s", i.User.DisableMechanism)
 	}
 
+	switch i.Group.NestedGroups {
+	case "in_chain", "recursive":
+		if i.isPosixGroup() {
+			return fmt.Errorf("error configuring nested groups, posixGroups do not support nesting")
+		}
+		if i.Group.NestedCacheTTL > 0 {
+			i.Group.nestedCache = ttlcache.NewCache()
+			_ = i.Group.nestedCache.SetTTL(time.Duration(i.Group.NestedCacheTTL) * time.Second)
+			i.Group.nestedCache.SkipTTLExtensionOnHit(true)
+		}
+	case "", "none":
+	default:
+		return fmt.Errorf("invalid nested groups setting: %s", i.Group.NestedGroups)
+	}
+
 	return nil
 }
 
@@ -327,9 +355,13 @@ func (i *Identity) IsLDAPUserInDisabledGroup(log *zerolog.Logger, lc ldap.Client
 // GetLDAPUserGroups looks up the group member ship of the supplied LDAP user entry.
 // Returns a slice of strings with groupids
 func (i *Identity) GetLDAPUserGroups(log *zerolog.Logger, lc ldap.Client, userEntry *ldap.Entry) ([]string, error) {
+	if i.nested() {
+		return i.getNestedUserGroups(log, lc, userEntry)
+	}
+
 	var memberValue string
 
-	if strings.ToLower(i.Group.Objectclass) == "posixgroup" {
+	if i.isPosixGroup() {
 		// posixGroup usually means that the member attribute just contains the username
 		memberValue = userEntry.GetEqualFoldAttributeValue(i.User.Schema.Username)
 	} else {
@@ -337,7 +369,16 @@ func (i *Identity) GetLDAPUserGroups(log *zerolog.Logger, lc ldap.Client, userEn
 		memberValue = userEntry.DN
 	}
 
-	filter := i.getGroupMemberFilter(memberValue)
+	entries, err := i.searchGroups(log, lc, i.getGroupMemberFilter(memberValue))
+	if err != nil {
+		log.Debug().Str("backend", "ldap").Err(err).Msg("Error looking up group memberships")
+		return []string{}, err
+	}
+	return i.groupIDs(entries)
+}
+
+// searchGroups returns the ids and DNs of the groups matching the filter
+func (i *Identity) searchGroups(log *zerolog.Logger, lc ldap.Client, filter string) ([]*ldap.Entry, error) {
 	searchRequest := ldap.NewSearchRequest(
 		i.Group.BaseDN, i.Group.scopeVal,
 		ldap.NeverDerefAliases, 0, 0, false,
@@ -349,12 +390,14 @@ func (i *Identity) GetLDAPUserGroups(log *zerolog.Logger, lc ldap.Client, userEn
 	log.Debug().Str("backend", "ldap").Str("basedn", i.Group.BaseDN).Str("filter", filter).Int("scope", i.Group.scopeVal).Msg("LDAP Search")
 	sr, err := lc.Search(searchRequest)
 	if err != nil {
-		log.Debug().Str("backend", "ldap").Err(err).Str("filter", filter).Msg("Error looking up group memberships")
-		return []string{}, err
+		return nil, err
 	}
+	return sr.Entries, nil
+}
 
-	groups := make([]string, 0, len(sr.Entries))
-	for _, entry := range sr.Entries {
+func (i *Identity) groupIDs(entries []*ldap.Entry) ([]string, error) {
+	groups := make([]string, 0, len(entries))
+	for _, entry := range entries {
 		// FIXME this makes the users groups use the cn, not an immutable id
 		// FIXME 1. use the memberof or members attribute of a user to get the groups
 		// FIXME 2. ook up the id for each group
@@ -461,13 +504,16 @@ func (i *Identity) GetLDAPGroups(log *zerolog.Logger, lc ldap.Client, query stri
 // GetLDAPGroupMembers looks up all members of the supplied LDAP group entry and returns the
 // corresponding LDAP user entries
 func (i *Identity) GetLDAPGroupMembers(log *zerolog.Logger, lc ldap.Client, group *ldap.Entry) ([]*ldap.Entry, error) {
+	if i.nested() {
+		return i.getNestedGroupMembers(log, lc, group)
+	}
 	members := group.GetEqualFoldAttributeValues(i.Group.Schema.Member)
 	log.Debug().Str("dn", group.DN).Interface("member", members).Msg("Get Group members")
 	memberEntries := make([]*ldap.Entry, 0, len(members))
 	for _, member := range members {
 		var e *ldap.Entry
 		var err error
-		if strings.ToLower(i.Group.Objectclass) == "posixgroup" {
+		if i.isPosixGroup() {
 			e, err = i.GetLDAPUserByAttribute(log, lc, "username", member)
 		} else {
 			e, err = i.GetLDAPUserByDN(log, lc, member)
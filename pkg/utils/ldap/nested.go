@@ -0,0 +1,228 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ldap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog"
+)
+
+// matchingRuleInChain is the OID of Active Directory's LDAP_MATCHING_RULE_IN_CHAIN, which
+// matches the whole chain of ancestry of nested groups on the server side
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+func (i *Identity) nested() bool {
+	return i.Group.NestedGroups == "in_chain" || i.Group.NestedGroups == "recursive"
+}
+
+func (i *Identity) isPosixGroup() bool {
+	return strings.EqualFold(i.Group.Objectclass, "posixGroup")
+}
+
+// getNestedUserGroups returns the ids of all groups the user is a direct or indirect member of
+func (i *Identity) getNestedUserGroups(log *zerolog.Logger, lc ldap.Client, userEntry *ldap.Entry) ([]string, error) {
+	key := "groups:" + strings.ToLower(userEntry.DN)
+	if v, ok := i.cached(key); ok {
+		return v.([]string), nil
+	}
+
+	var entries []*ldap.Entry
+	var err error
+	switch i.Group.NestedGroups {
+	case "in_chain":
+		filter := fmt.Sprintf("(&%s(objectclass=%s)(%s:%s:=%s))",
+			i.Group.Filter,
+			i.Group.Objectclass,
+			i.Group.Schema.Member,
+			matchingRuleInChain,
+			ldap.EscapeFilter(userEntry.DN),
+		)
+		entries, err = i.searchGroups(log, lc, filter)
+	default:
+		entries, err = i.getParentGroups(log, lc, userEntry.DN)
+	}
+	if err != nil {
+		log.Debug().Str("backend", "ldap").Err(err).Str("dn", userEntry.DN).Msg("Error looking up nested group memberships")
+		return []string{}, err
+	}
+
+	groups, err := i.groupIDs(entries)
+	if err != nil {
+		return nil, err
+	}
+	i.cache(key, groups)
+	return groups, nil
+}
+
+// getParentGroups walks up the group hierarchy starting at the given member DN. Every group
+// is only visited once, so cycles in the hierarchy are harmless.
+func (i *Identity) getParentGroups(log *zerolog.Logger, lc ldap.Client, dn string) ([]*ldap.Entry, error) {
+	groups := []*ldap.Entry{}
+	visited := map[string]bool{}
+	queue := []string{dn}
+	for depth := 0; len(queue) > 0 && depth <= i.Group.NestedMaxDepth; depth++ {
+		next := []string{}
+		for _, member := range queue {
+			entries, err := i.searchGroups(log, lc, i.getGroupMemberFilter(member))
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if visited[strings.ToLower(e.DN)] {
+					continue
+				}
+				visited[strings.ToLower(e.DN)] = true
+				groups = append(groups, e)
+				next = append(next, e.DN)
+			}
+		}
+		queue = next
+	}
+	if len(queue) > 0 {
+		log.Warn().Str("dn", dn).Int("max_depth", i.Group.NestedMaxDepth).Msg("Nested groups exceed the maximum depth")
+	}
+	return groups, nil
+}
+
+// getNestedGroupMembers returns the user entries of all direct and indirect members of the group
+func (i *Identity) getNestedGroupMembers(log *zerolog.Logger, lc ldap.Client, group *ldap.Entry) ([]*ldap.Entry, error) {
+	key := "members:" + strings.ToLower(group.DN)
+	if v, ok := i.cached(key); ok {
+		return v.([]*ldap.Entry), nil
+	}
+
+	var members []*ldap.Entry
+	var err error
+	switch i.Group.NestedGroups {
+	case "in_chain":
+		members, err = i.getInChainGroupMembers(log, lc, group)
+	default:
+		members, err = i.getRecursiveGroupMembers(log, lc, group)
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.cache(key, members)
+	return members, nil
+}
+
+func (i *Identity) getInChainGroupMembers(log *zerolog.Logger, lc ldap.Client, group *ldap.Entry) ([]*ldap.Entry, error) {
+	filter := fmt.Sprintf("(&%s(objectclass=%s)(memberOf:%s:=%s))",
+		i.User.Filter,
+		i.User.Objectclass,
+		matchingRuleInChain,
+		ldap.EscapeFilter(group.DN),
+	)
+	searchRequest := ldap.NewSearchRequest(
+		i.User.BaseDN, i.User.scopeVal, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{
+			i.User.Schema.DisplayName,
+			i.User.Schema.ID,
+			i.User.Schema.Mail,
+			i.User.Schema.Username,
+			i.User.Schema.UIDNumber,
+			i.User.Schema.GIDNumber,
+			i.User.EnabledProperty,
+			i.User.UserTypeProperty,
+		},
+		nil,
+	)
+	log.Debug().Str("backend", "ldap").Str("basedn", i.User.BaseDN).Str("filter", filter).Int("scope", i.User.scopeVal).Msg("LDAP Search")
+	sr, err := lc.Search(searchRequest)
+	if err != nil {
+		log.Debug().Str("backend", "ldap").Err(err).Str("dn", group.DN).Msg("Error looking up nested group members")
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+// getRecursiveGroupMembers walks down the group hierarchy. Members that are no users are looked
+// up as groups and expanded until the maximum depth is reached.
+func (i *Identity) getRecursiveGroupMembers(log *zerolog.Logger, lc ldap.Client, group *ldap.Entry) ([]*ldap.Entry, error) {
+	members := []*ldap.Entry{}
+	seenUsers := map[string]bool{}
+	visited := map[string]bool{strings.ToLower(group.DN): true}
+	queue := []*ldap.Entry{group}
+	for depth := 0; len(queue) > 0 && depth <= i.Group.NestedMaxDepth; depth++ {
+		next := []*ldap.Entry{}
+		for _, g := range queue {
+			for _, member := range g.GetEqualFoldAttributeValues(i.Group.Schema.Member) {
+				key := strings.ToLower(member)
+				if member == "" || visited[key] || seenUsers[key] {
+					continue
+				}
+				if e, err := i.GetLDAPUserByDN(log, lc, member); err == nil {
+					seenUsers[key] = true
+					members = append(members, e)
+					continue
+				}
+				visited[key] = true
+				sub, err := i.getLDAPGroupByDN(log, lc, member)
+				if err != nil {
+					log.Warn().Err(err).Str("member", member).Msg("Failed read entry for member")
+					continue
+				}
+				next = append(next, sub)
+			}
+		}
+		queue = next
+	}
+	if len(queue) > 0 {
+		log.Warn().Str("dn", group.DN).Int("max_depth", i.Group.NestedMaxDepth).Msg("Nested groups exceed the maximum depth")
+	}
+	return members, nil
+}
+
+// getLDAPGroupByDN looks up a single group by the supplied LDAP DN
+func (i *Identity) getLDAPGroupByDN(log *zerolog.Logger, lc ldap.Client, dn string) (*ldap.Entry, error) {
+	filter := fmt.Sprintf("(&%s(objectclass=%s))", i.Group.Filter, i.Group.Objectclass)
+	searchRequest := ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		filter,
+		[]string{i.Group.Schema.ID, i.Group.Schema.Member},
+		nil,
+	)
+	log.Debug().Str("backend", "ldap").Str("basedn", dn).Str("filter", filter).Msg("LDAP Search")
+	res, err := lc.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) == 0 {
+		return nil, fmt.Errorf("no group found for dn %s", dn)
+	}
+	return res.Entries[0], nil
+}
+
+func (i *Identity) cached(key string) (interface{}, bool) {
+	if i.Group.nestedCache == nil {
+		return nil, false
+	}
+	v, err := i.Group.nestedCache.Get(key)
+	return v, err == nil
+}
+
+func (i *Identity) cache(key string, value interface{}) {
+	if i.Group.nestedCache != nil {
+		_ = i.Group.nestedCache.Set(key, value)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ldap
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog"
+)
+
+// directory is a fake ldap.Client answering the searches used for nested groups
+type directory struct {
+	ldap.Client
+	users    map[string]bool
+	groups   map[string][]string
+	searches int
+}
+
+var (
+	memberFilter  = regexp.MustCompile(`\(member=([^)]*)\)`)
+	inChainFilter = regexp.MustCompile(`\((member|memberOf):` + matchingRuleInChain + `:=([^)]*)\)`)
+)
+
+func (d *directory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.searches++
+	res := &ldap.SearchResult{}
+	switch {
+	case inChainFilter.MatchString(req.Filter):
+		m := inChainFilter.FindStringSubmatch(req.Filter)
+		if m[1] == "member" {
+			for _, g := range d.ancestors(m[2]) {
+				res.Entries = append(res.Entries, d.group(g))
+			}
+		} else {
+			for _, u := range d.descendants(m[2]) {
+				res.Entries = append(res.Entries, ldap.NewEntry(u, map[string][]string{"entryUUID": {cn(u)}}))
+			}
+		}
+	case memberFilter.MatchString(req.Filter):
+		member := memberFilter.FindStringSubmatch(req.Filter)[1]
+		for g, members := range d.groups {
+			for _, m := range members {
+				if m == member {
+					res.Entries = append(res.Entries, d.group(g))
+				}
+			}
+		}
+	case d.users[req.BaseDN] && strings.Contains(req.Filter, "inetOrgPerson"):
+		res.Entries = append(res.Entries, ldap.NewEntry(req.BaseDN, map[string][]string{"entryUUID": {cn(req.BaseDN)}}))
+	case d.groups[req.BaseDN] != nil && strings.Contains(req.Filter, "groupOfNames"):
+		res.Entries = append(res.Entries, d.group(req.BaseDN))
+	}
+	return res, nil
+}
+
+func (d *directory) group(dn string) *ldap.Entry {
+	return ldap.NewEntry(dn, map[string][]string{"entryUUID": {cn(dn)}, "member": d.groups[dn]})
+}
+
+func (d *directory) ancestors(dn string) []string {
+	found := map[string]bool{}
+	queue := []string{dn}
+	for len(queue) > 0 {
+		member := queue[0]
+		queue = queue[1:]
+		for g, members := range d.groups {
+			for _, m := range members {
+				if m == member && !found[g] {
+					found[g] = true
+					queue = append(queue, g)
+				}
+			}
+		}
+	}
+	res := []string{}
+	for g := range found {
+		res = append(res, g)
+	}
+	return res
+}
+
+func (d *directory) descendants(dn string) []string {
+	res := []string{}
+	for u := range d.users {
+		for _, g := range d.ancestors(u) {
+			if g == dn {
+				res = append(res, u)
+			}
+		}
+	}
+	return res
+}
+
+func cn(dn string) string {
+	return strings.SplitN(strings.SplitN(dn, ",", 2)[0], "=", 2)[1]
+}
+
+func newDirectory() *directory {
+	return &directory{
+		users: map[string]bool{
+			"uid=einstein,ou=users": true,
+			"uid=marie,ou=users":    true,
+			"uid=richard,ou=users":  true,
+		},
+		groups: map[string][]string{
+			"cn=science,ou=groups":     {"cn=physics,ou=groups", "uid=richard,ou=users"},
+			"cn=physics,ou=groups":     {"cn=theoretical,ou=groups", "uid=marie,ou=users"},
+			"cn=theoretical,ou=groups": {"uid=einstein,ou=users", "cn=science,ou=groups"},
+		},
+	}
+}
+
+func newNestedIdentity(t *testing.T, mode string, maxDepth int) Identity {
+	i := New()
+	i.User.Objectclass = "inetOrgPerson"
+	i.User.Schema.ID = "entryUUID"
+	i.Group.Objectclass = "groupOfNames"
+	i.Group.Schema.ID = "entryUUID"
+	i.Group.Schema.Member = "member"
+	i.Group.NestedGroups = mode
+	i.Group.NestedMaxDepth = maxDepth
+	if err := i.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestNestedUserGroups(t *testing.T) {
+	log := zerolog.Nop()
+	einstein := ldap.NewEntry("uid=einstein,ou=users", nil)
+	for _, tc := range []struct {
+		mode     string
+		maxDepth int
+		expected []string
+	}{
+		{"none", 10, []string{"theoretical"}},
+		{"recursive", 10, []string{"physics", "science", "theoretical"}},
+		{"recursive", 1, []string{"physics", "theoretical"}},
+		{"in_chain", 10, []string{"physics", "science", "theoretical"}},
+	} {
+		i := newNestedIdentity(t, tc.mode, tc.maxDepth)
+		groups, err := i.GetLDAPUserGroups(&log, newDirectory(), einstein)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(groups)
+		if !reflect.DeepEqual(groups, tc.expected) {
+			t.Errorf("%s/%d: expected %v, got %v", tc.mode, tc.maxDepth, tc.expected, groups)
+		}
+	}
+}
+
+func TestNestedGroupMembers(t *testing.T) {
+	log := zerolog.Nop()
+	for _, mode := range []string{"recursive", "in_chain"} {
+		i := newNestedIdentity(t, mode, 10)
+		d := newDirectory()
+		members, err := i.GetLDAPGroupMembers(&log, d, d.group("cn=physics,ou=groups"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, m := range members {
+			names = append(names, cn(m.DN))
+		}
+		sort.Strings(names)
+		if expected := []string{"einstein", "marie", "richard"}; !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected %v, got %v", mode, expected, names)
+		}
+
+		// the second lookup is served from the cache
+		searches := d.searches
+		if _, err := i.GetLDAPGroupMembers(&log, d, d.group("cn=physics,ou=groups")); err != nil {
+			t.Fatal(err)
+		}
+		if d.searches != searches {
+			t.Errorf("%s: expected cached result, got %d new searches", mode, d.searches-searches)
+		}
+	}
+}
+
+func TestNestedGroupsSetup(t *testing.T) {
+	i := New()
+	i.Group.NestedGroups = "recursive"
+	if err := i.Setup(); err == nil {
+		t.Error("expected error for nested posixGroups")
+	}
+	i.Group.Objectclass = "groupOfNames"
+	i.Group.NestedGroups = "invalid"
+	if err := i.Setup(); err == nil {
+		t.Error("expected error for invalid nested groups setting")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package sqldb contains helpers shared by the drivers that keep their data in a sql database.
+// SQLite and PostgreSQL are supported. Queries use numbered placeholders ($1, $2, ...), which
+// both databases understand.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Supported drivers
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+)
+
+// Config selects the database of a driver
+type Config struct {
+	// Driver is either sqlite3 or postgres
+	Driver string `mapstructure:"db_driver"`
+	// DSN is the data source name, e.g. a file name for sqlite3 or a connection string for postgres
+	DSN string `mapstructure:"db_dsn"`
+}
+
+// Open opens the configured database
+func Open(c Config) (*sql.DB, error) {
+	if c.DSN == "" {
+		return nil, errors.New("sqldb: db_dsn is required")
+	}
+	switch c.Driver {
+	case SQLite, Postgres:
+	case "":
+		c.Driver = SQLite
+	default:
+		return nil, fmt.Errorf("sqldb: unsupported driver %q", c.Driver)
+	}
+
+	db, err := sql.Open(c.Driver, c.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if c.Driver == SQLite {
+		// sqlite only allows a single writer, sharing one connection avoids busy errors.
+		// It also keeps in-memory databases alive, they only exist as long as their connection.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migration is a list of statements that are applied in a single transaction
+type Migration []string
+
+// Migrate applies the migrations that have not been applied yet. The applied versions are
+// recorded per component in the schema_migrations table, so several drivers can share a database.
+// Migrations must never be changed or reordered once they have been released, only appended.
+func Migrate(ctx context.Context, db *sql.DB, component string, migrations []Migration) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		component VARCHAR(255) NOT NULL,
+		version INTEGER NOT NULL,
+		PRIMARY KEY (component, version)
+	)`)
+	if err != nil {
+		return fmt.Errorf("sqldb: could not create the migrations table: %w", err)
+	}
+
+	var current int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations WHERE component = $1", component).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("sqldb: could not read the schema version: %w", err)
+	}
+
+	for v := current + 1; v <= len(migrations); v++ {
+		if err := applyMigration(ctx, db, component, v, migrations[v-1]); err != nil {
+			return fmt.Errorf("sqldb: could not apply migration %d of %s: %w", v, component, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, component string, version int, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, stmt := range m {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	// a concurrent migration makes this insert fail, which rolls back the whole migration
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (component, version) VALUES ($1, $2)", component, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsUniqueViolation returns true if the error is caused by a unique or primary key constraint
+func IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" // unique_violation
+	}
+	return false
+}
+
+// Args collects the arguments of a query. The placeholders have to appear in the query in the order
+// they were added, sqlite numbers $N parameters by their first appearance. Repeating a placeholder is fine.
+type Args []interface{}
+
+// Add adds an argument and returns its placeholder
+func (a *Args) Add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// In adds the values and returns a comma separated list of their placeholders, e.g. for an IN clause
+func (a *Args) In(values ...string) string {
+	placeholders := make([]string, 0, len(values))
+	for _, v := range values {
+		placeholders = append(placeholders, a.Add(v))
+	}
+	return strings.Join(placeholders, ", ")
+}
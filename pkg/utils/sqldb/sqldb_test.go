@@ -0,0 +1,82 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sqldb_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloud-eu/reva/v2/pkg/utils/sqldb"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqldb.Open(sqldb.Config{Driver: sqldb.SQLite, DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []sqldb.Migration{
+		{"CREATE TABLE things (id VARCHAR(255) PRIMARY KEY)"},
+	}
+	if err := sqldb.Migrate(ctx, db, "test", migrations); err != nil {
+		t.Fatal(err)
+	}
+	// applying the same migrations again is a noop
+	if err := sqldb.Migrate(ctx, db, "test", migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations = append(migrations, sqldb.Migration{"ALTER TABLE things ADD COLUMN name VARCHAR(255)"})
+	if err := sqldb.Migrate(ctx, db, "test", migrations); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO things (id, name) VALUES ($1, $2)", "1", "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO things (id, name) VALUES ($1, $2)", "1", "other")
+	if !sqldb.IsUniqueViolation(err) {
+		t.Fatalf("expected a unique violation, got %v", err)
+	}
+
+	// a failing migration is rolled back
+	migrations = append(migrations, sqldb.Migration{"CREATE TABLE others (id VARCHAR(255))", "INVALID"})
+	if err := sqldb.Migrate(ctx, db, "test", migrations); err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	if _, err := db.ExecContext(ctx, "SELECT * FROM others"); err == nil {
+		t.Fatal("expected the failed migration to be rolled back")
+	}
+}
+
+func TestArgs(t *testing.T) {
+	var args sqldb.Args
+	if p := args.Add(1); p != "$1" {
+		t.Fatalf("unexpected placeholder %s", p)
+	}
+	if p := args.In("a", "b"); p != "$2, $3" {
+		t.Fatalf("unexpected placeholders %s", p)
+	}
+	if len(args) != 3 {
+		t.Fatalf("unexpected number of arguments %d", len(args))
+	}
+}
@@ -21,6 +21,7 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
@@ -42,6 +43,8 @@ import (
 	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/golang/protobuf/proto"
 	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/opencloud-eu/reva/v2/pkg/sharedconf"
 )
 
 var (
@@ -79,25 +82,46 @@ func Skip(source string, prefixes []string) bool {
 	return false
 }
 
-// GetClientIP retrieves the client IP from incoming requests
+// GetClientIP retrieves the client IP from incoming requests.
+// When trusted proxies are configured the X-Forwarded-For header is only honoured if the request
+// was sent by a trusted proxy, in that case the right-most entry that is not a trusted proxy is the client.
 func GetClientIP(r *http.Request) (string, error) {
-	var clientIP string
-	forwarded := r.Header.Get("X-FORWARDED-FOR")
-
-	if forwarded != "" {
-		clientIP = forwarded
-	} else {
-		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
-			ipObj := net.ParseIP(r.RemoteAddr)
-			if ipObj == nil {
-				return "", err
-			}
-			clientIP = ipObj.String()
-		} else {
-			clientIP = ip
+	if !sharedconf.RestrictForwardedClientIP() {
+		if forwarded := r.Header.Get("X-FORWARDED-FOR"); forwarded != "" {
+			return forwarded, nil
+		}
+	}
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return "", fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	if !sharedconf.RestrictForwardedClientIP() {
+		return ip.String(), nil
+	}
+	return ClientIPFromForwardedFor(ip, r.Header.Values("X-Forwarded-For"), sharedconf.IsTrustedProxy).String(), nil
+}
+
+// ClientIPFromForwardedFor walks the X-Forwarded-For hops from the right, starting at the peer
+// the request was received from, and returns the first address that is not trusted.
+func ClientIPFromForwardedFor(peer net.IP, forwardedFor []string, trusted func(net.IP) bool) net.IP {
+	var hops []string
+	for _, h := range forwardedFor {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0 && trusted(client); i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// the hops to the left of an invalid entry can not be trusted
+			break
 		}
+		client = ip
 	}
-	return clientIP, nil
+	return client
 }
 
 // ToSnakeCase converts a CamelCase string to a snake_case string.
@@ -19,6 +19,7 @@
 package utils
 
 import (
+	"net"
 	"testing"
 
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
@@ -171,3 +172,30 @@ func TestMakeRelativePath(t *testing.T) {
 		}
 	}
 }
+
+func TestClientIPFromForwardedFor(t *testing.T) {
+	trusted := func(ip net.IP) bool {
+		return ip.IsLoopback() || ip.Equal(net.ParseIP("10.0.0.2"))
+	}
+	tests := []struct {
+		name         string
+		peer         string
+		forwardedFor []string
+		client       string
+	}{
+		{"no proxy", "192.0.2.1", nil, "192.0.2.1"},
+		{"untrusted peer", "192.0.2.1", []string{"10.8.0.1"}, "192.0.2.1"},
+		{"trusted proxy", "127.0.0.1", []string{"192.0.2.1"}, "192.0.2.1"},
+		{"spoofed first hop", "127.0.0.1", []string{"10.8.0.1, 192.0.2.1"}, "192.0.2.1"},
+		{"chain of proxies", "127.0.0.1", []string{"10.8.0.1, 192.0.2.1", "10.0.0.2"}, "192.0.2.1"},
+		{"invalid hop", "127.0.0.1", []string{"192.0.2.1, invalid"}, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := ClientIPFromForwardedFor(net.ParseIP(tt.peer), tt.forwardedFor, trusted)
+			if client.String() != tt.client {
+				t.Errorf("expected %s, got %s", tt.client, client)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+)
+
+// Options configures a dispatcher
+type Options struct {
+	// MaxAttempts is the number of attempts before a delivery is moved to the dead letters
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry, it doubles with every retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the time to wait between two attempts
+	MaxBackoff time.Duration
+	// Workers is the number of deliveries that are sent concurrently
+	Workers int
+	// QueueSize is the number of deliveries that can be queued for the workers. Deliveries that do
+	// not fit are left in the store and picked up by the next scan.
+	QueueSize int
+	// ScanInterval is the interval in which the store is scanned for pending deliveries that are not queued
+	ScanInterval time.Duration
+	// Client sends the deliveries, it defaults to a client that refuses to connect to non public addresses
+	Client *http.Client
+	// Authorize is called before every attempt to make sure the owner of the webhook may still receive
+	// its events. Deliveries it denies with a permission denied or not found error are dropped.
+	Authorize func(ctx context.Context, w *Webhook) error
+}
+
+// Dispatcher sends events to the matching webhooks. Deliveries are stored as pending before the
+// event is acknowledged and removed once they were delivered, dropped or moved to the dead letters,
+// so they survive a restart.
+type Dispatcher struct {
+	store *Store
+	opts  Options
+	log   *zerolog.Logger
+	queue chan *Delivery
+	after func(time.Duration, func())
+
+	mu sync.Mutex
+	// scheduled holds the deliveries that are queued or waiting for a retry
+	scheduled map[string]bool
+}
+
+// NewDispatcher returns a dispatcher for the webhooks of the store
+func NewDispatcher(store *Store, o Options, log *zerolog.Logger) *Dispatcher {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Minute
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 100
+	}
+	if o.ScanInterval <= 0 {
+		o.ScanInterval = time.Minute
+	}
+	if o.Client == nil {
+		o.Client = NewClient(10*time.Second, false)
+	}
+	return &Dispatcher{
+		store:     store,
+		opts:      o,
+		log:       log,
+		queue:     make(chan *Delivery, o.QueueSize),
+		after:     func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+		scheduled: map[string]bool{},
+	}
+}
+
+// Start starts the workers sending the queued deliveries and the scan for pending deliveries,
+// which also picks up the deliveries left over from a previous run
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.opts.Workers; i++ {
+		go func() {
+			for dl := range d.queue {
+				_ = d.Deliver(context.Background(), dl)
+			}
+		}()
+	}
+	go func() {
+		for {
+			if err := d.Scan(context.Background()); err != nil {
+				d.log.Error().Err(err).Msg("error scanning pending webhook deliveries")
+			}
+			time.Sleep(d.opts.ScanInterval)
+		}
+	}()
+}
+
+// Scan schedules the pending deliveries that are neither queued nor waiting for a retry
+func (d *Dispatcher) Scan(ctx context.Context) error {
+	pending, err := d.store.ListPending(ctx)
+	if err != nil {
+		return err
+	}
+	for _, dl := range pending {
+		d.schedule(dl, time.Until(dl.NextAttempt))
+	}
+	return nil
+}
+
+// Consume dispatches all events of the channel and acknowledges them once their deliveries are stored
+func (d *Dispatcher) Consume(ch <-chan events.Event) {
+	for ev := range ch {
+		if err := d.Dispatch(context.Background(), ev); err != nil {
+			d.log.Error().Err(err).Str("type", ev.Type).Str("id", ev.ID).Msg("error dispatching event")
+			// the event is redelivered
+			_ = ev.Nack()
+			continue
+		}
+		if err := ev.Ack(); err != nil {
+			d.log.Error().Err(err).Str("type", ev.Type).Str("id", ev.ID).Msg("error acknowledging event")
+		}
+	}
+}
+
+// Dispatch stores a pending delivery of the event for every matching webhook and queues it. It does
+// not block if the queue is full.
+func (d *Dispatcher) Dispatch(ctx context.Context, ev events.Event) error {
+	payload, r, ok := NewPayload(ev)
+	if !ok {
+		d.log.Debug().Str("type", ev.Type).Str("id", ev.ID).Msg("skipping unreadable event")
+		return nil
+	}
+	webhooks, err := d.store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	var body []byte
+	for _, w := range webhooks {
+		if !w.Matches(r) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(payload); err != nil {
+				return err
+			}
+		}
+		dl := &Delivery{
+			// the id is derived from the event, so an event that is redelivered after a partial
+			// failure does not create a second delivery
+			ID:        uuid.NewSHA1(uuid.NameSpaceOID, []byte(ev.ID+"|"+w.ID)).String(),
+			WebhookID: w.ID,
+			EventType: payload.Type,
+			Payload:   body,
+			CreatedAt: time.Now(),
+		}
+		if err := d.store.PutPending(ctx, dl); err != nil {
+			return err
+		}
+		d.schedule(dl, 0)
+	}
+	return nil
+}
+
+// Redeliver queues a dead letter again
+func (d *Dispatcher) Redeliver(ctx context.Context, id string) error {
+	dl, err := d.store.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+	dl.Attempts = 0
+	dl.LastError = ""
+	dl.NextAttempt = time.Time{}
+	if err := d.store.PutPending(ctx, dl); err != nil {
+		return err
+	}
+	if err := d.store.DeleteDeadLetter(ctx, id); err != nil {
+		return err
+	}
+	d.schedule(dl, 0)
+	return nil
+}
+
+// schedule queues the delivery after the delay unless it is already scheduled
+func (d *Dispatcher) schedule(dl *Delivery, delay time.Duration) {
+	d.mu.Lock()
+	if d.scheduled[dl.ID] {
+		d.mu.Unlock()
+		return
+	}
+	d.scheduled[dl.ID] = true
+	d.mu.Unlock()
+
+	if delay <= 0 {
+		d.enqueue(dl)
+		return
+	}
+	d.after(delay, func() { d.enqueue(dl) })
+}
+
+// enqueue hands the delivery to the workers. If the queue is full it is left to the next scan.
+func (d *Dispatcher) enqueue(dl *Delivery) {
+	select {
+	case d.queue <- dl:
+	default:
+		d.log.Debug().Str("delivery", dl.ID).Msg("webhook delivery queue is full, leaving the delivery to the next scan")
+		d.unschedule(dl)
+	}
+}
+
+func (d *Dispatcher) unschedule(dl *Delivery) {
+	d.mu.Lock()
+	delete(d.scheduled, dl.ID)
+	d.mu.Unlock()
+}
+
+// Deliver makes one attempt to send the delivery. A failed attempt is retried with an exponential
+// backoff by a timer, deliveries that fail too often are moved to the dead letters.
+func (d *Dispatcher) Deliver(ctx context.Context, dl *Delivery) error {
+	w, err := d.store.Get(ctx, dl.WebhookID)
+	if err != nil {
+		// the webhook was removed in the meantime
+		d.done(ctx, dl)
+		return err
+	}
+
+	dl.Attempts++
+	dl.LastAttempt = time.Now()
+	if d.opts.Authorize != nil {
+		err = d.opts.Authorize(ctx, w)
+		var pd errtypes.IsPermissionDenied
+		var nf errtypes.IsNotFound
+		if errors.As(err, &pd) || errors.As(err, &nf) {
+			d.log.Info().Err(err).Str("webhook", w.ID).Str("delivery", dl.ID).Msg("dropping webhook delivery, the owner lost access")
+			d.done(ctx, dl)
+			return err
+		}
+	}
+	if err == nil {
+		err = d.send(ctx, w, dl)
+	}
+	if err == nil {
+		d.done(ctx, dl)
+		return nil
+	}
+	dl.LastError = err.Error()
+	d.log.Debug().Err(err).Str("webhook", w.ID).Str("delivery", dl.ID).Int("attempt", dl.Attempts).Msg("webhook delivery failed")
+
+	if dl.Attempts >= d.opts.MaxAttempts {
+		d.log.Warn().Err(err).Str("webhook", w.ID).Str("delivery", dl.ID).Msg("giving up webhook delivery")
+		if err := d.store.PutDeadLetter(ctx, dl); err != nil {
+			// keep the pending delivery, the next scan tries again
+			d.log.Error().Err(err).Str("delivery", dl.ID).Msg("error storing dead letter")
+			d.unschedule(dl)
+			return err
+		}
+		d.done(ctx, dl)
+		return err
+	}
+
+	backoff := d.opts.InitialBackoff << (dl.Attempts - 1)
+	if backoff > d.opts.MaxBackoff || backoff <= 0 {
+		backoff = d.opts.MaxBackoff
+	}
+	dl.NextAttempt = time.Now().Add(backoff)
+	if err := d.store.PutPending(ctx, dl); err != nil {
+		d.log.Error().Err(err).Str("delivery", dl.ID).Msg("error storing pending webhook delivery")
+	}
+	// the delivery stays scheduled while it waits for the retry
+	d.after(backoff, func() { d.enqueue(dl) })
+	return err
+}
+
+// done removes a delivery that needs no further attempts
+func (d *Dispatcher) done(ctx context.Context, dl *Delivery) {
+	if err := d.store.DeletePending(ctx, dl.ID); err != nil {
+		d.log.Error().Err(err).Str("delivery", dl.ID).Msg("error removing pending webhook delivery")
+	}
+	d.unschedule(dl)
+}
+
+func (d *Dispatcher) send(ctx context.Context, w *Webhook, dl *Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.EventType)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, now, dl.Payload))
+
+	res, err := d.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+)
+
+// Delivery is a payload that has to be sent to a webhook
+type Delivery struct {
+	ID          string          `json:"id"`
+	WebhookID   string          `json:"webhook_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	LastAttempt time.Time       `json:"last_attempt,omitempty"`
+	NextAttempt time.Time       `json:"next_attempt,omitempty"`
+}
+
+// Store persists the webhooks, the pending deliveries and the dead letters in a metadata storage. The webhooks are cached
+// in memory, so only one instance should manage them.
+type Store struct {
+	storage      metadata.Storage
+	initOnce     sync.Once
+	initErr      error
+	mu           sync.RWMutex
+	webhooks     map[string]*Webhook
+	spaceName    string
+	webhooksD    string
+	pendingD     string
+	deadLettersD string
+}
+
+// NewStore returns a store using the given metadata storage
+func NewStore(s metadata.Storage) *Store {
+	return &Store{
+		storage:      s,
+		webhooks:     map[string]*Webhook{},
+		spaceName:    "webhooks",
+		webhooksD:    "webhooks",
+		pendingD:     "pending",
+		deadLettersD: "deadletters",
+	}
+}
+
+func (s *Store) init() error {
+	s.initOnce.Do(func() {
+		ctx := context.Background()
+		if s.initErr = s.storage.Init(ctx, s.spaceName); s.initErr != nil {
+			return
+		}
+		for _, d := range []string{s.webhooksD, s.pendingD, s.deadLettersD} {
+			if s.initErr = s.storage.MakeDirIfNotExist(ctx, d); s.initErr != nil {
+				return
+			}
+		}
+		var entries []string
+		if entries, s.initErr = s.storage.ReadDir(ctx, s.webhooksD); s.initErr != nil {
+			return
+		}
+		for _, e := range entries {
+			w := &Webhook{}
+			if s.initErr = s.read(ctx, e, w); s.initErr != nil {
+				return
+			}
+			s.webhooks[w.ID] = w
+		}
+	})
+	return s.initErr
+}
+
+func (s *Store) read(ctx context.Context, p string, v interface{}) error {
+	res, err := s.storage.Download(ctx, metadata.DownloadRequest{Path: p})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(res.Content, v)
+}
+
+func (s *Store) write(ctx context.Context, p string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.storage.SimpleUpload(ctx, p, b)
+}
+
+// List returns the webhooks of the owner sorted by creation time. An empty owner returns all webhooks.
+func (s *Store) List(ctx context.Context, owner string) ([]*Webhook, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := []*Webhook{}
+	for _, w := range s.webhooks {
+		if owner == "" || w.Owner == owner {
+			c := *w
+			webhooks = append(webhooks, &c)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, nil
+}
+
+// Get returns the webhook or a NotFound error
+func (s *Store) Get(ctx context.Context, id string) (*Webhook, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return nil, errtypes.NotFound(id)
+	}
+	c := *w
+	return &c, nil
+}
+
+// Put creates or updates the webhook
+func (s *Store) Put(ctx context.Context, w *Webhook) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(ctx, path.Join(s.webhooksD, w.ID+".json"), w); err != nil {
+		return err
+	}
+	c := *w
+	s.webhooks[w.ID] = &c
+	return nil
+}
+
+// Delete removes the webhook and its dead letters. Its pending deliveries are dropped by the dispatcher.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	deadLetters, err := s.ListDeadLetters(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return errtypes.NotFound(id)
+	}
+	if err := s.storage.Delete(ctx, path.Join(s.webhooksD, id+".json")); err != nil {
+		return err
+	}
+	delete(s.webhooks, id)
+	for _, d := range deadLetters {
+		_ = s.storage.Delete(ctx, path.Join(s.deadLettersD, d.ID+".json"))
+	}
+	return nil
+}
+
+// PutPending stores a delivery that has not been delivered yet
+func (s *Store) PutPending(ctx context.Context, d *Delivery) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return s.write(ctx, path.Join(s.pendingD, d.ID+".json"), d)
+}
+
+// ListPending returns all pending deliveries sorted by creation time
+func (s *Store) ListPending(ctx context.Context) ([]*Delivery, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	return s.listDeliveries(ctx, s.pendingD, "")
+}
+
+// DeletePending removes the pending delivery once it was delivered, dropped or moved to the dead letters
+func (s *Store) DeletePending(ctx context.Context, id string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	err := s.storage.Delete(ctx, path.Join(s.pendingD, id+".json"))
+	var notFound errtypes.IsNotFound
+	if errors.As(err, &notFound) || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PutDeadLetter stores a delivery that could not be delivered
+func (s *Store) PutDeadLetter(ctx context.Context, d *Delivery) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return s.write(ctx, path.Join(s.deadLettersD, d.ID+".json"), d)
+}
+
+// GetDeadLetter returns the dead letter or a NotFound error
+func (s *Store) GetDeadLetter(ctx context.Context, id string) (*Delivery, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	d := &Delivery{}
+	if err := s.read(ctx, path.Join(s.deadLettersD, id+".json"), d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDeadLetters returns the dead letters of the webhook sorted by creation time. An empty webhook id
+// returns all dead letters.
+func (s *Store) ListDeadLetters(ctx context.Context, webhookID string) ([]*Delivery, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	return s.listDeliveries(ctx, s.deadLettersD, webhookID)
+}
+
+func (s *Store) listDeliveries(ctx context.Context, dir, webhookID string) ([]*Delivery, error) {
+	entries, err := s.storage.ReadDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []*Delivery{}
+	for _, e := range entries {
+		d := &Delivery{}
+		if err := s.read(ctx, e, d); err != nil {
+			if _, ok := err.(errtypes.NotFound); ok {
+				continue
+			}
+			return nil, err
+		}
+		if webhookID == "" || d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
+
+// DeleteDeadLetter removes the dead letter
+func (s *Store) DeleteDeadLetter(ctx context.Context, id string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return s.storage.Delete(ctx, path.Join(s.deadLettersD, id+".json"))
+}
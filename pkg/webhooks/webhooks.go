@@ -0,0 +1,150 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package webhooks delivers events to HTTP endpoints registered by users.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencloud-eu/reva/v2/pkg/audit"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/storagespace"
+)
+
+const (
+	// HeaderEvent holds the type of the delivered event
+	HeaderEvent = "X-Reva-Webhook-Event"
+	// HeaderDelivery holds the id of the delivery, it does not change when a delivery is retried
+	HeaderDelivery = "X-Reva-Webhook-Delivery"
+	// HeaderTimestamp holds the unix time the request was signed at
+	HeaderTimestamp = "X-Reva-Webhook-Timestamp"
+	// HeaderSignature holds the signature of the request, see Sign
+	HeaderSignature = "X-Reva-Webhook-Signature"
+)
+
+// Webhook is an endpoint that gets the events matching its filters
+type Webhook struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	URL   string `json:"url"`
+	// Secret is used to sign the deliveries
+	Secret string `json:"secret,omitempty"`
+	// Events are the event types without the package prefix, e.g. FileUploaded. Empty matches all events.
+	Events []string `json:"events,omitempty"`
+	// SpaceID restricts the webhook to events of resources in the space
+	SpaceID string `json:"space_id,omitempty"`
+	// PathPrefix restricts the webhook to events of resources below the path
+	PathPrefix string    `json:"path_prefix,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Matches returns true if the normalized event has to be delivered to the webhook
+func (w *Webhook) Matches(r audit.Record) bool {
+	if len(w.Events) > 0 {
+		found := false
+		for _, e := range w.Events {
+			if e == r.Action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if w.SpaceID != "" {
+		if r.ResourceID == "" {
+			return false
+		}
+		rid, err := storagespace.ParseID(r.ResourceID)
+		if err != nil {
+			return false
+		}
+		sid, err := storagespace.ParseID(w.SpaceID)
+		if err != nil || sid.GetSpaceId() != rid.GetSpaceId() {
+			return false
+		}
+	}
+	if w.PathPrefix != "" && !(audit.Query{Resource: w.PathPrefix}).Matches(r) {
+		return false
+	}
+	return true
+}
+
+// Payload is the body of a delivery
+type Payload struct {
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	Time         time.Time       `json:"time"`
+	Actor        string          `json:"actor,omitempty"`
+	ResourceID   string          `json:"resource_id,omitempty"`
+	ResourcePath string          `json:"resource_path,omitempty"`
+	Event        json.RawMessage `json:"event"`
+}
+
+// NewPayload converts an event as returned by events.ConsumeAll into a payload. It returns false if the
+// event can not be read.
+func NewPayload(ev events.Event) (Payload, audit.Record, bool) {
+	r, ok := audit.Normalize(ev)
+	if !ok {
+		return Payload{}, r, false
+	}
+	raw, ok := ev.Event.([]byte)
+	if !ok {
+		b, err := json.Marshal(ev.Event)
+		if err != nil {
+			return Payload{}, r, false
+		}
+		raw = b
+	}
+	return Payload{
+		ID:           ev.ID,
+		Type:         r.Action,
+		Time:         r.Time,
+		Actor:        r.Actor,
+		ResourceID:   r.ResourceID,
+		ResourcePath: r.ResourcePath,
+		Event:        raw,
+	}, r, true
+}
+
+// Sign returns the signature of a body sent at the given time. It is the hex encoded HMAC-SHA256 of
+// the unix timestamp, a dot and the body, prefixed with "sha256=".
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received delivery
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, time.Unix(ts, 0), body)))
+}
@@ -0,0 +1,332 @@
+// Copyright 2018-2024 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/rs/zerolog"
+
+	"github.com/opencloud-eu/reva/v2/pkg/errtypes"
+	"github.com/opencloud-eu/reva/v2/pkg/events"
+	"github.com/opencloud-eu/reva/v2/pkg/storage/utils/metadata"
+)
+
+func uploadedEvent(t *testing.T) events.Event {
+	payload, err := json.Marshal(events.FileUploaded{
+		Executant: &userpb.UserId{OpaqueId: "einstein"},
+		Ref: &provider.Reference{
+			ResourceId: &provider.ResourceId{StorageId: "storage", SpaceId: "space", OpaqueId: "node"},
+			Path:       "./docs/file.txt",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.Event{Type: "events.FileUploaded", ID: "ev1", Event: payload}
+}
+
+func TestMatches(t *testing.T) {
+	_, r, ok := NewPayload(uploadedEvent(t))
+	if !ok {
+		t.Fatal("event not readable")
+	}
+
+	tests := map[string]struct {
+		webhook Webhook
+		matches bool
+	}{
+		"no filters":         {Webhook{}, true},
+		"event type":         {Webhook{Events: []string{"ItemTrashed", "FileUploaded"}}, true},
+		"other event type":   {Webhook{Events: []string{"ItemTrashed"}}, false},
+		"space":              {Webhook{SpaceID: "storage$space"}, true},
+		"other space":        {Webhook{SpaceID: "storage$other"}, false},
+		"path prefix":        {Webhook{PathPrefix: "./docs"}, true},
+		"other path prefix":  {Webhook{PathPrefix: "./doc"}, false},
+		"all filters":        {Webhook{Events: []string{"FileUploaded"}, SpaceID: "storage$space", PathPrefix: "./docs"}, true},
+		"one filter differs": {Webhook{Events: []string{"FileUploaded"}, SpaceID: "storage$other", PathPrefix: "./docs"}, false},
+	}
+	for name, tt := range tests {
+		if got := tt.webhook.Matches(r); got != tt.matches {
+			t.Errorf("%s: expected %v, got %v", name, tt.matches, got)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	sig := Sign("secret", now, []byte("body"))
+	if !Verify("secret", "1700000000", sig, []byte("body")) {
+		t.Error("valid signature rejected")
+	}
+	if Verify("other", "1700000000", sig, []byte("body")) {
+		t.Error("signature with other secret accepted")
+	}
+	if Verify("secret", "1700000001", sig, []byte("body")) {
+		t.Error("signature with other timestamp accepted")
+	}
+	if Verify("secret", "1700000000", sig, []byte("changed")) {
+		t.Error("signature of other body accepted")
+	}
+}
+
+func newTestDispatcher(t *testing.T, url string, authorize func(context.Context, *Webhook) error) (*Dispatcher, *Store, *[]time.Duration) {
+	s, err := metadata.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(s)
+	if err := store.Put(context.Background(), &Webhook{ID: "hook", Owner: "einstein", URL: url, Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	log := zerolog.Nop()
+	d := NewDispatcher(store, Options{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     1500 * time.Millisecond,
+		// the test servers listen on loopback addresses
+		Client:    NewClient(time.Second, true),
+		Authorize: authorize,
+	}, &log)
+	// retries are queued right away
+	var delays []time.Duration
+	d.after = func(b time.Duration, f func()) {
+		delays = append(delays, b)
+		f()
+	}
+	return d, store, &delays
+}
+
+func TestDeliver(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("secret", r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(HeaderEvent) != "FileUploaded" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p := Payload{}
+		if err := json.Unmarshal(body, &p); err != nil || p.ID != "ev1" || p.Actor != "einstein" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	d, store, slept := newTestDispatcher(t, srv.URL, nil)
+	if err := d.Dispatch(context.Background(), uploadedEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queue) != 1 {
+		t.Fatalf("expected 1 queued delivery, got %d", len(d.queue))
+	}
+	if err := d.Deliver(context.Background(), <-d.queue); err != nil {
+		t.Fatal(err)
+	}
+	if received.Load() != 1 {
+		t.Errorf("expected 1 delivery, got %d", received.Load())
+	}
+	if len(*slept) != 0 {
+		t.Errorf("expected no retries, got %v", *slept)
+	}
+	dls, err := store.ListDeadLetters(context.Background(), "")
+	if err != nil || len(dls) != 0 {
+		t.Errorf("expected no dead letters, got %v %v", dls, err)
+	}
+}
+
+func TestDeliverDeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	d, store, slept := newTestDispatcher(t, srv.URL, nil)
+	if err := d.Dispatch(context.Background(), uploadedEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+	var dl *Delivery
+	for i := 0; i < 3; i++ {
+		dl = <-d.queue
+		if err := d.Deliver(context.Background(), dl); err == nil {
+			t.Fatal("expected the delivery to fail")
+		}
+	}
+	if len(d.queue) != 0 {
+		t.Errorf("expected no retry after the last attempt")
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts.Load())
+	}
+	if len(*slept) != 2 || (*slept)[0] != time.Second || (*slept)[1] != 1500*time.Millisecond {
+		t.Errorf("unexpected backoff %v", *slept)
+	}
+
+	dls, err := store.ListDeadLetters(context.Background(), "hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].ID != dl.ID || dls[0].Attempts != 3 || dls[0].LastError == "" {
+		t.Fatalf("unexpected dead letters %+v", dls)
+	}
+	if pending, err := store.ListPending(context.Background()); err != nil || len(pending) != 0 {
+		t.Errorf("expected the dead letter to not be pending anymore, got %v %v", pending, err)
+	}
+
+	if err := d.Redeliver(context.Background(), dl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.queue) != 1 {
+		t.Errorf("expected the dead letter to be queued again")
+	}
+	dls, err = store.ListDeadLetters(context.Background(), "hook")
+	if err != nil || len(dls) != 0 {
+		t.Errorf("expected the dead letter to be removed, got %v %v", dls, err)
+	}
+}
+
+func TestPendingDeliveriesSurviveRestart(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	d, store, _ := newTestDispatcher(t, srv.URL, nil)
+	d.queue = make(chan *Delivery) // nobody is listening, the queue is always full
+	for _, id := range []string{"ev1", "ev2"} {
+		ev := uploadedEvent(t)
+		ev.ID = id
+		if err := d.Dispatch(context.Background(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// dispatching the same event again does not add a delivery
+	if err := d.Dispatch(context.Background(), uploadedEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new dispatcher picks up the deliveries
+	log := zerolog.Nop()
+	restarted := NewDispatcher(store, Options{Client: NewClient(time.Second, true)}, &log)
+	if err := restarted.Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.queue) != 2 {
+		t.Fatalf("expected 2 pending deliveries, got %d", len(restarted.queue))
+	}
+	for len(restarted.queue) > 0 {
+		if err := restarted.Deliver(context.Background(), <-restarted.queue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if received.Load() != 2 {
+		t.Errorf("expected 2 deliveries, got %d", received.Load())
+	}
+	if pending, err := store.ListPending(context.Background()); err != nil || len(pending) != 0 {
+		t.Errorf("expected no pending deliveries, got %v %v", pending, err)
+	}
+}
+
+func TestDeliverOwnerLostAccess(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	d, store, slept := newTestDispatcher(t, srv.URL, func(_ context.Context, w *Webhook) error {
+		return errtypes.PermissionDenied(w.SpaceID)
+	})
+	if err := d.Dispatch(context.Background(), uploadedEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Deliver(context.Background(), <-d.queue); err == nil {
+		t.Fatal("expected the delivery to be denied")
+	}
+	if received.Load() != 0 || len(*slept) != 0 {
+		t.Errorf("expected the delivery to be dropped, got %d deliveries and retries %v", received.Load(), *slept)
+	}
+	dls, err := store.ListDeadLetters(context.Background(), "")
+	if err != nil || len(dls) != 0 {
+		t.Errorf("expected no dead letters, got %v %v", dls, err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fd00:ec2::254":        false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"8.8.8.8":              true,
+		"2001:4860:4860::8888": true,
+	} {
+		if IsPublicIP(net.ParseIP(ip)) != public {
+			t.Errorf("expected IsPublicIP(%s) to be %v", ip, public)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, u := range []string{"http://127.0.0.1:8080/hook", "https://[::1]/hook", "http://169.254.169.254/latest/meta-data", "http://localhost/hook"} {
+		if err := CheckURL(context.Background(), u); err == nil {
+			t.Errorf("expected %s to be rejected", u)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	if _, err := NewClient(time.Second, false).Get(srv.URL); err == nil {
+		t.Fatal("expected the connection to be refused")
+	}
+	if received.Load() != 0 {
+		t.Errorf("expected no request to reach the server")
+	}
+}